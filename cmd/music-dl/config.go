@@ -0,0 +1,242 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/guohuiyuan/go-music-dl/core"
+	"github.com/guohuiyuan/go-music-dl/internal/web"
+)
+
+var (
+	configIncludeCookies bool
+	configPassphrase     string
+	configOutput         string
+	configSkipSettings   bool
+	configSkipProfiles   bool
+	configSkipDedup      bool
+	configSkipCollection bool
+)
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "导入/导出配置与切换配置档案",
+	Long: `在桌面版与服务器之间迁移配置。
+
+导出文件是一个带版本号的 zip 包，包含设置、配置档案、下载去重索引和本地歌单；
+Cookie 属于敏感信息，只有加上 --cookies 并提供 --passphrase 时才会加密导出。
+
+配置档案（profile）保存一整套设置，例如 "home" 与 "travel" 使用不同的下载目录、
+搜索源和文件名模板，可随时切换。`,
+	Example: `  # 导出到当前目录
+  music-dl config export
+
+  # 连同 Cookie 一起加密导出
+  music-dl config export -o backup.zip --cookies --passphrase "secret"
+
+  # 导入（Cookie 需要同一个口令）
+  music-dl config import backup.zip --cookies --passphrase "secret"
+
+  # 保存当前设置为档案并切换
+  music-dl config profile save travel
+  music-dl config profile use home
+
+  # 查看生效设置（含配置文件 / 环境变量覆盖）
+  music-dl --config music-dl.yaml config show`,
+}
+
+var configExportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "导出配置归档",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		output := configOutput
+		if output == "" {
+			output = fmt.Sprintf("go-music-dl-config-%s.zip", time.Now().Format("20060102-150405"))
+		}
+
+		web.InitDB()
+		defer web.CloseDB()
+
+		if dir := filepath.Dir(output); dir != "." {
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				return err
+			}
+		}
+		file, err := os.Create(output)
+		if err != nil {
+			return err
+		}
+		if err := web.ExportConfigArchive(file, configArchiveOptionsFromFlags()); err != nil {
+			_ = file.Close()
+			_ = os.Remove(output)
+			return err
+		}
+		if err := file.Close(); err != nil {
+			return err
+		}
+		fmt.Printf("配置已导出到 %s\n", output)
+		return nil
+	},
+}
+
+var configImportCmd = &cobra.Command{
+	Use:   "import <archive.zip>",
+	Short: "从配置归档导入",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		data, err := os.ReadFile(args[0])
+		if err != nil {
+			return err
+		}
+
+		web.InitDB()
+		defer web.CloseDB()
+
+		result, err := web.ImportConfigArchive(data, configArchiveOptionsFromFlags())
+		if err != nil {
+			return err
+		}
+		fmt.Printf("导入完成: 设置=%t 档案=%d Cookie=%d 去重记录=%d 新建歌单=%d\n",
+			result.Settings, result.Profiles, result.Cookies, result.Dedup, result.Sections["collections"])
+		if !configIncludeCookies && core.HasEncryptedCookies(data) {
+			fmt.Println("提示: 归档中包含加密 Cookie，使用 --cookies --passphrase 可一并导入")
+		}
+		return nil
+	},
+}
+
+var configShowCmd = &cobra.Command{
+	Use:   "show",
+	Short: "显示当前生效的设置及其来源",
+	Long: `显示当前生效的设置。来源列为 file 的项来自 --config 配置文件，env 表示来自
+MUSIC_DL_* 环境变量，这些项在 Web 设置面板中只读；其余项保存在数据库中。`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		raw, err := json.Marshal(core.GetWebSettings())
+		if err != nil {
+			return err
+		}
+		var values map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &values); err != nil {
+			return err
+		}
+		overrides := core.WebSettingsOverrides()
+		if path := core.ConfigFilePath(); path != "" {
+			fmt.Printf("配置文件: %s\n", path)
+		}
+		for _, key := range core.WebSettingsKeys() {
+			origin := overrides[key]
+			if origin == "" {
+				origin = "db"
+			}
+			fmt.Printf("%-26s %-40s %-38s %s\n", key, string(values[key]), core.WebSettingsEnvName(key), origin)
+		}
+		return nil
+	},
+}
+
+var configProfileCmd = &cobra.Command{
+	Use:   "profile",
+	Short: "管理配置档案",
+}
+
+var configProfileListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "列出配置档案",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		profiles, err := core.GetConfigProfiles()
+		if err != nil {
+			return err
+		}
+		if len(profiles.Profiles) == 0 {
+			fmt.Println("还没有配置档案，可使用 music-dl config profile save <name> 创建")
+			return nil
+		}
+		for _, profile := range profiles.Profiles {
+			marker := " "
+			if profile.Name == profiles.Active {
+				marker = "*"
+			}
+			fmt.Printf("%s %-16s %s\n", marker, profile.Name, profile.Settings.DownloadDir)
+		}
+		return nil
+	},
+}
+
+var configProfileSaveCmd = &cobra.Command{
+	Use:   "save <name>",
+	Short: "把当前设置保存为档案",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		profile, err := core.SaveConfigProfile(args[0], core.GetWebSettings())
+		if err != nil {
+			return err
+		}
+		fmt.Printf("已保存档案 %s\n", profile.Name)
+		return nil
+	},
+}
+
+var configProfileUseCmd = &cobra.Command{
+	Use:   "use <name>",
+	Short: "切换到指定档案",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		settings, err := core.ActivateConfigProfile(args[0])
+		if errors.Is(err, core.ErrConfigProfileNotFound) {
+			return fmt.Errorf("档案 %s 不存在", args[0])
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Printf("已切换到档案 %s，下载目录: %s\n", args[0], settings.DownloadDir)
+		return nil
+	},
+}
+
+var configProfileDeleteCmd = &cobra.Command{
+	Use:   "delete <name>",
+	Short: "删除档案",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := core.DeleteConfigProfile(args[0]); err != nil {
+			return err
+		}
+		fmt.Printf("已删除档案 %s\n", args[0])
+		return nil
+	},
+}
+
+func configArchiveOptionsFromFlags() web.ConfigArchiveOptions {
+	return web.ConfigArchiveOptions{
+		ConfigArchiveOptions: core.ConfigArchiveOptions{
+			Settings:   !configSkipSettings,
+			Profiles:   !configSkipProfiles,
+			Cookies:    configIncludeCookies,
+			Dedup:      !configSkipDedup,
+			Passphrase: configPassphrase,
+		},
+		Collections: !configSkipCollection,
+	}
+}
+
+func init() {
+	for _, c := range []*cobra.Command{configExportCmd, configImportCmd} {
+		c.Flags().BoolVar(&configIncludeCookies, "cookies", false, "包含 Cookie（需要 --passphrase）")
+		c.Flags().StringVar(&configPassphrase, "passphrase", "", "加密/解密 Cookie 的口令")
+		c.Flags().BoolVar(&configSkipSettings, "no-settings", false, "跳过设置")
+		c.Flags().BoolVar(&configSkipProfiles, "no-profiles", false, "跳过配置档案")
+		c.Flags().BoolVar(&configSkipDedup, "no-dedup", false, "跳过下载去重索引")
+		c.Flags().BoolVar(&configSkipCollection, "no-collections", false, "跳过本地歌单")
+	}
+	configExportCmd.Flags().StringVarP(&configOutput, "output", "o", "", "输出文件路径")
+
+	configProfileCmd.AddCommand(configProfileListCmd, configProfileSaveCmd, configProfileUseCmd, configProfileDeleteCmd)
+	configCmd.AddCommand(configExportCmd, configImportCmd, configShowCmd, configProfileCmd)
+	rootCmd.AddCommand(configCmd)
+}
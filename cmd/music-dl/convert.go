@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/spf13/cobra"
+
+	"github.com/guohuiyuan/go-music-dl/core"
+)
+
+var (
+	convertProfile    string
+	convertFormat     string
+	convertBitrate    int
+	convertSampleRate int
+	convertOutput     string
+	convertOverwrite  bool
+	convertJobs       int
+)
+
+var convertAudioExts = map[string]bool{
+	".aac": true, ".flac": true, ".m4a": true, ".mp3": true, ".ogg": true, ".opus": true, ".wav": true, ".wma": true,
+}
+
+var convertSidecarExts = []string{".lrc", ".txt", ".lyric", ".jpg", ".jpeg", ".png", ".webp"}
+
+var convertCmd = &cobra.Command{
+	Use:   "convert <dir>",
+	Short: "批量转换文件夹里的音频格式",
+	Long: `用 ffmpeg 把文件夹（含子目录）里的音频转换成指定格式，标签和封面随文件保留。
+
+转码档案与 Web 设置里的一致：内置 mp3-320、mp3-192、aac-256、opus-128、flac，
+以及在设置中自定义的档案；也可以用 --format/--bitrate/--sample-rate 临时指定。
+不加 -o 时输出写在原文件旁边；加 -o 时按原目录结构写到输出目录，并复制同名歌词和封面。
+已是目标格式的文件、已存在的输出文件（除非 --overwrite）会被跳过。`,
+	Example: `  # 把 FLAC 收藏转一份 MP3 给车机
+  music-dl convert ~/Music/flac -o ~/Music/car --profile mp3-320
+
+  # 临时指定格式和码率
+  music-dl convert ./downloads --format m4a --bitrate 192 --sample-rate 44100`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if _, err := core.ResolveFFmpegPath(); err != nil {
+			return core.ErrFFmpegNotFound
+		}
+		profile, err := convertProfileFromFlags(cmd)
+		if err != nil {
+			return err
+		}
+		srcDir, err := filepath.Abs(args[0])
+		if err != nil {
+			return err
+		}
+		outDir := ""
+		if convertOutput != "" {
+			if outDir, err = filepath.Abs(convertOutput); err != nil {
+				return err
+			}
+		}
+
+		files, err := collectConvertFiles(srcDir, outDir)
+		if err != nil {
+			return err
+		}
+		if len(files) == 0 {
+			fmt.Println("没有找到可转换的音频文件")
+			return nil
+		}
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+		fmt.Printf("转换 %d 个文件为 %s (%s)\n", len(files), profile.Name, profile.Format)
+		converted, skipped, failed := runConvertFiles(ctx, srcDir, outDir, files, profile)
+		fmt.Printf("完成: 转换=%d 跳过=%d 失败=%d\n", converted, skipped, failed)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if failed > 0 {
+			return fmt.Errorf("%d 个文件转换失败", failed)
+		}
+		return nil
+	},
+}
+
+func convertProfileFromFlags(cmd *cobra.Command) (core.TranscodeProfile, error) {
+	if convertFormat != "" {
+		name := ""
+		if cmd.Flags().Changed("profile") {
+			name = convertProfile
+		}
+		return core.NormalizeTranscodeProfile(core.TranscodeProfile{
+			Name:       name,
+			Format:     convertFormat,
+			Bitrate:    convertBitrate,
+			SampleRate: convertSampleRate,
+		})
+	}
+	if cmd.Flags().Changed("bitrate") || cmd.Flags().Changed("sample-rate") {
+		return core.TranscodeProfile{}, errors.New("--bitrate/--sample-rate 需要与 --format 一起使用")
+	}
+	profile, err := core.FindTranscodeProfile(core.GetWebSettings().TranscodeProfiles, convertProfile)
+	if errors.Is(err, core.ErrTranscodeProfileNotFound) {
+		names := make([]string, 0)
+		for _, p := range core.TranscodeProfiles(core.GetWebSettings().TranscodeProfiles) {
+			names = append(names, p.Name)
+		}
+		return profile, fmt.Errorf("转码档案 %s 不存在，可用: %s", convertProfile, strings.Join(names, ", "))
+	}
+	return profile, err
+}
+
+// collectConvertFiles lists the audio files under srcDir, skipping hidden
+// directories and the output directory when it lies inside srcDir.
+func collectConvertFiles(srcDir string, outDir string) ([]string, error) {
+	var files []string
+	err := filepath.WalkDir(srcDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if path != srcDir && (strings.HasPrefix(d.Name(), ".") || path == outDir) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if convertAudioExts[strings.ToLower(filepath.Ext(path))] {
+			files = append(files, path)
+		}
+		return nil
+	})
+	return files, err
+}
+
+func runConvertFiles(ctx context.Context, srcDir string, outDir string, files []string, profile core.TranscodeProfile) (int, int, int) {
+	var converted, skipped, failed, finished int64
+	workers := convertJobs
+	if workers < 1 {
+		workers = 1
+	}
+	queue := make(chan string)
+	var wg sync.WaitGroup
+	var printMu sync.Mutex
+	report := func(rel string, status string) {
+		n := atomic.AddInt64(&finished, 1)
+		printMu.Lock()
+		fmt.Printf("[%d/%d] %s %s\n", n, len(files), status, rel)
+		printMu.Unlock()
+	}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for src := range queue {
+				rel, _ := filepath.Rel(srcDir, src)
+				targetDir := ""
+				if outDir != "" {
+					targetDir = filepath.Join(outDir, filepath.Dir(rel))
+				}
+				target := core.TranscodeTargetPath(src, targetDir, profile)
+				if strings.EqualFold(filepath.Ext(src), "."+profile.Ext()) {
+					atomic.AddInt64(&skipped, 1)
+					report(rel, "跳过(已是目标格式)")
+					continue
+				}
+				if _, err := os.Stat(target); err == nil && !convertOverwrite {
+					atomic.AddInt64(&skipped, 1)
+					report(rel, "跳过(已存在)")
+					continue
+				}
+				if err := core.TranscodeAudioFile(ctx, src, target, profile, nil); err != nil {
+					if ctx.Err() != nil {
+						return
+					}
+					atomic.AddInt64(&failed, 1)
+					report(rel, "失败: "+err.Error())
+					continue
+				}
+				if outDir != "" {
+					copyConvertSidecars(src, target)
+				}
+				atomic.AddInt64(&converted, 1)
+				report(rel, "完成")
+			}
+		}()
+	}
+feed:
+	for _, file := range files {
+		select {
+		case queue <- file:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(queue)
+	wg.Wait()
+	return int(converted), int(skipped), int(failed)
+}
+
+// copyConvertSidecars copies the lyric and cover files named after src next
+// to target, without overwriting existing ones.
+func copyConvertSidecars(src string, target string) {
+	srcBase := strings.TrimSuffix(src, filepath.Ext(src))
+	targetBase := strings.TrimSuffix(target, filepath.Ext(target))
+	for _, ext := range convertSidecarExts {
+		dest := targetBase + ext
+		if _, err := os.Stat(dest); err == nil {
+			continue
+		}
+		in, err := os.Open(srcBase + ext)
+		if err != nil {
+			continue
+		}
+		out, err := os.Create(dest)
+		if err == nil {
+			_, err = io.Copy(out, in)
+			if closeErr := out.Close(); err == nil {
+				err = closeErr
+			}
+		}
+		in.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "复制 %s 失败: %v\n", srcBase+ext, err)
+		}
+	}
+}
+
+func init() {
+	convertCmd.Flags().StringVarP(&convertProfile, "profile", "p", "mp3-320", "转码档案名称")
+	convertCmd.Flags().StringVarP(&convertFormat, "format", "f", "", "输出格式: "+strings.Join(core.TranscodeFormats, "|")+"（指定后忽略 --profile）")
+	convertCmd.Flags().IntVarP(&convertBitrate, "bitrate", "b", 0, "码率 kbps，0 为编码器默认值")
+	convertCmd.Flags().IntVar(&convertSampleRate, "sample-rate", 0, "采样率 Hz，0 为保持原采样率")
+	convertCmd.Flags().StringVarP(&convertOutput, "output", "o", "", "输出目录（默认写在原文件旁边）")
+	convertCmd.Flags().BoolVar(&convertOverwrite, "overwrite", false, "覆盖已存在的输出文件")
+	convertCmd.Flags().IntVarP(&convertJobs, "jobs", "j", 1, "同时运行的 ffmpeg 数量")
+	rootCmd.AddCommand(convertCmd)
+}
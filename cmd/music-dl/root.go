@@ -19,8 +19,15 @@ var (
 	outDir      string
 	withCover   bool
 	withLyrics  bool
+	configFile  string
+	logLevel    string
+	logFormat   string
+	logFile     string
 )
 
+// tuiLogFile 是 TUI 模式下的默认日志文件，避免日志输出打乱终端界面。
+const tuiLogFile = "data/music-dl.log"
+
 var rootCmd = &cobra.Command{
 	Use:   "music-dl",
 	Short: "聚合音乐搜索下载工具 (支持多源/TUI/Web/封面/歌词)",
@@ -57,6 +64,16 @@ var rootCmd = &cobra.Command{
 
   # 5. 直接进入 TUI 交互模式 (不带参数)
   music-dl`,
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		opts := core.LogOptions{Level: logLevel, Format: logFormat, File: logFile}
+		if cmd == cmd.Root() && !showVersion && opts.File == "" && os.Getenv(core.LogFileEnv) == "" {
+			opts.File = tuiLogFile
+		}
+		if err := core.ConfigureLogging(opts); err != nil {
+			return err
+		}
+		return core.LoadConfigFile(configFile)
+	},
 	Run: func(cmd *cobra.Command, args []string) {
 		if showVersion {
 			fmt.Printf("music-dl version v%s (TUI Version)\n", core.AppVersion)
@@ -86,6 +103,10 @@ var rootCmd = &cobra.Command{
 
 func init() {
 	// 绑定 Flags
+	rootCmd.PersistentFlags().StringVar(&configFile, "config", "", "配置文件路径 (YAML/TOML)，其中的设置项在 Web 设置面板中只读，也可通过 "+core.ConfigFileEnv+" 指定")
+	rootCmd.PersistentFlags().StringVar(&logLevel, "log-level", "", "日志级别: debug|info|warn|error，debug 会记录上游请求 URL (Cookie 已脱敏)，也可通过 "+core.LogLevelEnv+" 指定")
+	rootCmd.PersistentFlags().StringVar(&logFormat, "log-format", "", "日志格式: text|json，也可通过 "+core.LogFormatEnv+" 指定")
+	rootCmd.PersistentFlags().StringVar(&logFile, "log-file", "", "日志输出文件 (默认输出到 stderr，TUI 模式默认写入 "+tuiLogFile+")，也可通过 "+core.LogFileEnv+" 指定")
 	rootCmd.Flags().BoolVarP(&showVersion, "version", "v", false, "显示版本信息")
 	rootCmd.Flags().StringVarP(&keyword, "keyword", "k", "", "搜索关键字")
 	rootCmd.Flags().StringVarP(&urlStr, "url", "u", "", "通过指定的歌曲URL下载音乐 (开发中)")
@@ -0,0 +1,147 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/dhowden/tag"
+)
+
+// AudioTags is the full set of tags the local tag editor manages. Unlike
+// EmbedSongMetadata, WriteAudioTags writes every text field: an empty value
+// removes that tag. Cover replaces the picture and RemoveCover drops it; with
+// neither set the existing picture is kept.
+type AudioTags struct {
+	Title       string `json:"title"`
+	Artist      string `json:"artist"`
+	Album       string `json:"album"`
+	AlbumArtist string `json:"album_artist"`
+	Track       string `json:"track"`
+	Disc        string `json:"disc"`
+	Year        string `json:"year"`
+	Genre       string `json:"genre"`
+	Lyrics      string `json:"lyrics"`
+
+	Cover       []byte `json:"-"`
+	CoverMime   string `json:"-"`
+	RemoveCover bool   `json:"-"`
+}
+
+// TagWritableAudioExts lists the formats WriteAudioTags can write: mp3 through
+// the built-in ID3v2.3 writer, the rest through ffmpeg.
+var TagWritableAudioExts = []string{"mp3", "flac", "m4a", "wma"}
+
+// ReadAudioTags reads the tags WriteAudioTags manages, including the cover.
+// A file without any tags yields empty AudioTags.
+func ReadAudioTags(audioData []byte) (AudioTags, error) {
+	metadata, err := tag.ReadFrom(bytes.NewReader(audioData))
+	if errors.Is(err, tag.ErrNoTagsFound) {
+		return AudioTags{}, nil
+	}
+	if err != nil {
+		return AudioTags{}, err
+	}
+	tags := AudioTags{
+		Title:       strings.TrimSpace(metadata.Title()),
+		Artist:      strings.TrimSpace(metadata.Artist()),
+		Album:       strings.TrimSpace(metadata.Album()),
+		AlbumArtist: strings.TrimSpace(metadata.AlbumArtist()),
+		Genre:       strings.TrimSpace(metadata.Genre()),
+		Lyrics:      strings.TrimSpace(metadata.Lyrics()),
+	}
+	tags.Track = formatTagNumberPair(metadata.Track())
+	tags.Disc = formatTagNumberPair(metadata.Disc())
+	if year := metadata.Year(); year > 0 {
+		tags.Year = strconv.Itoa(year)
+	}
+	if picture := metadata.Picture(); picture != nil && len(picture.Data) > 0 {
+		tags.Cover = append([]byte(nil), picture.Data...)
+		tags.CoverMime = picture.MIMEType
+	}
+	return tags, nil
+}
+
+func formatTagNumberPair(n, total int) string {
+	switch {
+	case n <= 0:
+		return ""
+	case total > 0:
+		return fmt.Sprintf("%d/%d", n, total)
+	default:
+		return strconv.Itoa(n)
+	}
+}
+
+// WriteAudioTags returns audioData with tags written. ext is the audio format
+// without the dot.
+func WriteAudioTags(audioData []byte, ext string, tags AudioTags) ([]byte, error) {
+	if len(audioData) == 0 {
+		return nil, fmt.Errorf("empty audio data")
+	}
+	ext = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(ext), "."))
+	switch ext {
+	case "mp3":
+		return writeMP3AudioTags(audioData, tags), nil
+	case "flac", "m4a", "wma":
+		metadata := [][2]string{
+			{"title", tags.Title},
+			{"artist", tags.Artist},
+			{"album", tags.Album},
+			{"album_artist", tags.AlbumArtist},
+			{"track", tags.Track},
+			{"disc", tags.Disc},
+			{"date", tags.Year},
+			{"genre", tags.Genre},
+			{"lyrics", tags.Lyrics},
+		}
+		return runFFmpegMetadataEmbed(audioData, ext, metadata, tags.Cover, normalizeCoverMime(tags.CoverMime), tags.RemoveCover)
+	default:
+		return nil, fmt.Errorf("writing %s tags is not supported", ext)
+	}
+}
+
+func writeMP3AudioTags(audioData []byte, tags AudioTags) []byte {
+	// 非 ID3v2.3 的旧标签不会被逐帧保留，先把原封面取出来，避免改文字时丢图。
+	cover, coverMime := tags.Cover, tags.CoverMime
+	if len(cover) == 0 && !tags.RemoveCover {
+		if existing, err := tag.ReadFrom(bytes.NewReader(audioData)); err == nil {
+			if picture := existing.Picture(); picture != nil && len(picture.Data) > 0 {
+				cover, coverMime = picture.Data, picture.MIMEType
+			}
+		}
+	}
+
+	replace := map[string]bool{"USLT": true, "APIC": true}
+	var frames [][]byte
+	for _, field := range [][2]string{
+		{"TIT2", tags.Title},
+		{"TPE1", tags.Artist},
+		{"TALB", tags.Album},
+		{"TPE2", tags.AlbumArtist},
+		{"TRCK", tags.Track},
+		{"TPOS", tags.Disc},
+		{"TYER", tags.Year},
+		{"TCON", tags.Genre},
+	} {
+		replace[field[0]] = true
+		if value := strings.TrimSpace(field[1]); value != "" {
+			frames = append(frames, id3v23Frame(field[0], id3TextFramePayload(value)))
+		}
+	}
+	if lyric := strings.TrimSpace(tags.Lyrics); lyric != "" {
+		frames = append(frames, id3v23Frame("USLT", id3USLTPayload(lyric)))
+	}
+	if len(cover) > 0 {
+		frames = append(frames, id3v23Frame("APIC", id3APICPayload(cover, coverMime)))
+	}
+	if len(frames) == 0 {
+		// 标签全部清空且没有其它帧可保留时，直接去掉整个 ID3v2 标签头。
+		if preserved := preservedID3v23Frames(audioData, replace); len(preserved) == 0 {
+			return stripID3v2Prefix(audioData)
+		}
+	}
+	return rewriteID3v23Tag(audioData, replace, frames)
+}
@@ -0,0 +1,134 @@
+package core
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestWriteAudioTagsMP3RoundTrip(t *testing.T) {
+	audioData := []byte{0xff, 0xfb, 0x90, 0x64, 0x00, 0x00}
+	cover := []byte{0xff, 0xd8, 0xff, 0xd9}
+	want := AudioTags{
+		Title:       "晴天",
+		Artist:      "周杰伦",
+		Album:       "叶惠美",
+		AlbumArtist: "周杰伦",
+		Track:       "3/11",
+		Disc:        "1",
+		Year:        "2003",
+		Genre:       "Pop",
+		Lyrics:      "[00:01.00]故事的小黄花",
+		Cover:       cover,
+		CoverMime:   "image/jpeg",
+	}
+	written, err := WriteAudioTags(audioData, "mp3", want)
+	if err != nil {
+		t.Fatalf("WriteAudioTags() error = %v", err)
+	}
+	if !bytes.HasSuffix(written, audioData) {
+		t.Fatal("written data should keep the MP3 audio frames")
+	}
+
+	got, err := ReadAudioTags(written)
+	if err != nil {
+		t.Fatalf("ReadAudioTags() error = %v", err)
+	}
+	if !bytes.Equal(got.Cover, cover) {
+		t.Fatalf("cover = %v, want %v", got.Cover, cover)
+	}
+	got.Cover, got.CoverMime, want.Cover, want.CoverMime = nil, "", nil, ""
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("tags = %+v, want %+v", got, want)
+	}
+
+	// Clearing fields removes them; the cover stays unless RemoveCover is set.
+	cleared, err := WriteAudioTags(written, "mp3", AudioTags{Title: "晴天", Artist: "周杰伦"})
+	if err != nil {
+		t.Fatalf("clear WriteAudioTags() error = %v", err)
+	}
+	got, err = ReadAudioTags(cleared)
+	if err != nil {
+		t.Fatalf("ReadAudioTags(cleared) error = %v", err)
+	}
+	if got.Album != "" || got.Genre != "" || got.Track != "" || got.Year != "" || got.Lyrics != "" {
+		t.Fatalf("cleared tags = %+v", got)
+	}
+	if !bytes.Equal(got.Cover, cover) {
+		t.Fatal("cover should be kept when not replaced")
+	}
+
+	bare, err := WriteAudioTags(cleared, "mp3", AudioTags{RemoveCover: true})
+	if err != nil {
+		t.Fatalf("remove-all WriteAudioTags() error = %v", err)
+	}
+	if !bytes.Equal(bare, audioData) {
+		t.Fatalf("removing every tag should leave the bare audio, got %d bytes", len(bare))
+	}
+}
+
+func TestWriteAudioTagsKeepsUnmanagedID3Frames(t *testing.T) {
+	audioData := []byte{0xff, 0xfb, 0x90, 0x64}
+	comment := id3v23Frame("TCOM", id3TextFramePayload("Composer"))
+	tagSize := id3SynchsafeSize(len(comment))
+	tagged := append([]byte{'I', 'D', '3', 0x03, 0x00, 0x00}, tagSize[:]...)
+	tagged = append(tagged, comment...)
+	tagged = append(tagged, audioData...)
+
+	written, err := WriteAudioTags(tagged, "mp3", AudioTags{})
+	if err != nil {
+		t.Fatalf("WriteAudioTags() error = %v", err)
+	}
+	if !bytes.Contains(written, comment) {
+		t.Fatal("TCOM frame should survive a tag edit")
+	}
+}
+
+func TestWriteAudioTagsRejectsUnsupportedFormat(t *testing.T) {
+	if _, err := WriteAudioTags([]byte("RIFF"), "wav", AudioTags{Title: "x"}); err == nil {
+		t.Fatal("wav tags should not be writable")
+	}
+}
+
+func TestWriteAudioTagsFLACByFFmpeg(t *testing.T) {
+	if _, err := exec.LookPath("ffmpeg"); err != nil {
+		t.Skip("ffmpeg not available")
+	}
+	inPath := filepath.Join(t.TempDir(), "source.flac")
+	cmd := exec.Command("ffmpeg", "-y", "-hide_banner", "-loglevel", "error",
+		"-f", "lavfi", "-i", "anullsrc=r=8000:cl=mono", "-t", "0.05",
+		"-metadata", "title=Old", "-metadata", "genre=Jazz", "-metadata", "comment=keep", inPath)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("ffmpeg create flac failed: %v, output: %s", err, string(out))
+	}
+	audioData, err := os.ReadFile(inPath)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+
+	written, err := WriteAudioTags(audioData, "flac", AudioTags{Title: "New", AlbumArtist: "VA", Track: "2", Year: "1999"})
+	if err != nil {
+		t.Fatalf("WriteAudioTags() error = %v", err)
+	}
+	got, err := ReadAudioTags(written)
+	if err != nil {
+		t.Fatalf("ReadAudioTags() error = %v", err)
+	}
+	if got.Title != "New" || got.AlbumArtist != "VA" || got.Track != "2" || got.Year != "1999" || got.Genre != "" {
+		t.Fatalf("flac tags = %+v", got)
+	}
+}
+
+func TestReadAudioTagsUntaggedFile(t *testing.T) {
+	audioData := append([]byte{0xff, 0xfb, 0x90, 0x64}, make([]byte, 256)...)
+	tags, err := ReadAudioTags(audioData)
+	if err != nil {
+		t.Fatalf("ReadAudioTags() error = %v", err)
+	}
+	if !reflect.DeepEqual(tags, AudioTags{}) {
+		t.Fatalf("untagged file tags = %+v", tags)
+	}
+}
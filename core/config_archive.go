@@ -0,0 +1,488 @@
+package core
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/scrypt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	ConfigArchiveFormat  = "go-music-dl/config"
+	ConfigArchiveVersion = 1
+
+	configArchiveManifestFile = "manifest.json"
+	configArchiveSettingsFile = "settings.json"
+	configArchiveProfilesFile = "profiles.json"
+	configArchiveCookiesFile  = "cookies.enc"
+	configArchiveDedupFile    = "dedup.json"
+	configArchiveSectionDir   = "sections/"
+
+	configArchiveMaxEntryBytes = 64 << 20
+)
+
+var (
+	ErrConfigArchivePassphrase = errors.New("config archive cookies require a passphrase")
+	ErrConfigArchiveDecrypt    = errors.New("config archive cookies could not be decrypted, check the passphrase")
+)
+
+// ConfigArchiveManifest describes what an exported archive contains.
+type ConfigArchiveManifest struct {
+	Format     string    `json:"format"`
+	Version    int       `json:"version"`
+	AppVersion string    `json:"appVersion"`
+	ExportedAt time.Time `json:"exportedAt"`
+	Sections   []string  `json:"sections"`
+}
+
+// ConfigArchive is the in-memory form of an exported configuration. Cookies
+// are kept in plain text here and only encrypted when the archive is written.
+// Sections holds JSON payloads owned by other packages, e.g. collections.
+type ConfigArchive struct {
+	Manifest ConfigArchiveManifest
+	Settings *WebSettings
+	Profiles *ConfigProfiles
+	Cookies  map[string]string
+	Dedup    []DownloadDedupEntry
+	Sections map[string]json.RawMessage
+}
+
+// ConfigArchiveOptions selects the parts of the configuration to export or import.
+type ConfigArchiveOptions struct {
+	Settings   bool
+	Profiles   bool
+	Cookies    bool
+	Dedup      bool
+	Passphrase string
+}
+
+// DefaultConfigArchiveOptions selects everything except cookies, which are
+// secrets and must be requested explicitly together with a passphrase.
+func DefaultConfigArchiveOptions() ConfigArchiveOptions {
+	return ConfigArchiveOptions{Settings: true, Profiles: true, Dedup: true}
+}
+
+// ConfigImportResult summarises what ApplyConfigArchive changed.
+type ConfigImportResult struct {
+	Settings bool           `json:"settings"`
+	Profiles int            `json:"profiles"`
+	Cookies  int            `json:"cookies"`
+	Dedup    int            `json:"dedup"`
+	Sections map[string]int `json:"sections,omitempty"`
+}
+
+type encryptedConfigBlob struct {
+	KDF        string `json:"kdf"`
+	N          int    `json:"n"`
+	R          int    `json:"r"`
+	P          int    `json:"p"`
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// SetSection stores a JSON payload for an extension section.
+func (a *ConfigArchive) SetSection(name string, value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	if a.Sections == nil {
+		a.Sections = make(map[string]json.RawMessage)
+	}
+	a.Sections[name] = data
+	return nil
+}
+
+// BuildConfigArchive collects the core-owned parts of the configuration.
+func BuildConfigArchive(opts ConfigArchiveOptions) (*ConfigArchive, error) {
+	if opts.Cookies && opts.Passphrase == "" {
+		return nil, ErrConfigArchivePassphrase
+	}
+	if opts.Cookies || opts.Dedup {
+		if err := initDownloadRecordTable(); err != nil {
+			return nil, err
+		}
+	}
+
+	archive := &ConfigArchive{
+		Manifest: ConfigArchiveManifest{
+			Format:     ConfigArchiveFormat,
+			Version:    ConfigArchiveVersion,
+			AppVersion: AppVersion,
+			ExportedAt: time.Now().UTC(),
+		},
+	}
+	if opts.Settings {
+		settings := GetWebSettings()
+		archive.Settings = &settings
+	}
+	if opts.Profiles {
+		profiles, err := GetConfigProfiles()
+		if err != nil {
+			return nil, err
+		}
+		archive.Profiles = &profiles
+	}
+	if opts.Cookies {
+		var rows []cookieEntry
+		if err := configDB.Order("source ASC").Find(&rows).Error; err != nil {
+			return nil, err
+		}
+		archive.Cookies = make(map[string]string, len(rows))
+		for _, row := range rows {
+			archive.Cookies[row.Source] = row.Value
+		}
+	}
+	if opts.Dedup {
+		if err := configDB.Order("song_key ASC").Find(&archive.Dedup).Error; err != nil {
+			return nil, err
+		}
+	}
+	return archive, nil
+}
+
+// WriteConfigArchive writes archive as a zip file. Cookies are encrypted with
+// a key derived from passphrase; exporting cookies without one is refused.
+func WriteConfigArchive(w io.Writer, archive *ConfigArchive, passphrase string) error {
+	if archive == nil {
+		return errors.New("config archive is nil")
+	}
+	if archive.Cookies != nil && passphrase == "" {
+		return ErrConfigArchivePassphrase
+	}
+
+	files := make(map[string][]byte)
+	addJSON := func(name string, value interface{}) error {
+		data, err := json.MarshalIndent(value, "", "  ")
+		if err != nil {
+			return err
+		}
+		files[name] = data
+		return nil
+	}
+
+	sections := make([]string, 0, 4+len(archive.Sections))
+	if archive.Settings != nil {
+		sections = append(sections, "settings")
+		if err := addJSON(configArchiveSettingsFile, archive.Settings); err != nil {
+			return err
+		}
+	}
+	if archive.Profiles != nil {
+		sections = append(sections, "profiles")
+		if err := addJSON(configArchiveProfilesFile, archive.Profiles); err != nil {
+			return err
+		}
+	}
+	if archive.Cookies != nil {
+		plain, err := json.Marshal(archive.Cookies)
+		if err != nil {
+			return err
+		}
+		blob, err := encryptConfigBlob(plain, passphrase)
+		if err != nil {
+			return err
+		}
+		sections = append(sections, "cookies")
+		if err := addJSON(configArchiveCookiesFile, blob); err != nil {
+			return err
+		}
+	}
+	if archive.Dedup != nil {
+		sections = append(sections, "dedup")
+		if err := addJSON(configArchiveDedupFile, archive.Dedup); err != nil {
+			return err
+		}
+	}
+	names := make([]string, 0, len(archive.Sections))
+	for name := range archive.Sections {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if !validConfigSectionName(name) {
+			return fmt.Errorf("invalid config archive section %q", name)
+		}
+		sections = append(sections, name)
+		files[configArchiveSectionDir+name+".json"] = archive.Sections[name]
+	}
+
+	manifest := archive.Manifest
+	manifest.Format = ConfigArchiveFormat
+	manifest.Version = ConfigArchiveVersion
+	if manifest.AppVersion == "" {
+		manifest.AppVersion = AppVersion
+	}
+	if manifest.ExportedAt.IsZero() {
+		manifest.ExportedAt = time.Now().UTC()
+	}
+	manifest.Sections = sections
+	if err := addJSON(configArchiveManifestFile, manifest); err != nil {
+		return err
+	}
+
+	zw := zip.NewWriter(w)
+	order := make([]string, 0, len(files))
+	for name := range files {
+		if name != configArchiveManifestFile {
+			order = append(order, name)
+		}
+	}
+	sort.Strings(order)
+	order = append([]string{configArchiveManifestFile}, order...)
+	for _, name := range order {
+		fw, err := zw.Create(name)
+		if err != nil {
+			return err
+		}
+		if _, err := fw.Write(files[name]); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// ReadConfigArchive parses an archive produced by WriteConfigArchive. The
+// cookies section is only decrypted when opts.Cookies is set, in which case a
+// passphrase is required; otherwise it is skipped without touching the key.
+func ReadConfigArchive(data []byte, opts ConfigArchiveOptions) (*ConfigArchive, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid config archive: %w", err)
+	}
+
+	files := make(map[string][]byte, len(zr.File))
+	for _, file := range zr.File {
+		if file.UncompressedSize64 > configArchiveMaxEntryBytes {
+			return nil, fmt.Errorf("config archive entry %s is too large", file.Name)
+		}
+		rc, err := file.Open()
+		if err != nil {
+			return nil, err
+		}
+		content, err := io.ReadAll(io.LimitReader(rc, configArchiveMaxEntryBytes+1))
+		_ = rc.Close()
+		if err != nil {
+			return nil, err
+		}
+		files[file.Name] = content
+	}
+
+	archive := &ConfigArchive{}
+	raw, ok := files[configArchiveManifestFile]
+	if !ok {
+		return nil, errors.New("invalid config archive: missing manifest")
+	}
+	if err := json.Unmarshal(raw, &archive.Manifest); err != nil {
+		return nil, fmt.Errorf("invalid config archive manifest: %w", err)
+	}
+	if archive.Manifest.Format != ConfigArchiveFormat {
+		return nil, fmt.Errorf("unsupported config archive format %q", archive.Manifest.Format)
+	}
+	if archive.Manifest.Version < 1 || archive.Manifest.Version > ConfigArchiveVersion {
+		return nil, fmt.Errorf("unsupported config archive version %d", archive.Manifest.Version)
+	}
+
+	if raw, ok := files[configArchiveSettingsFile]; ok {
+		var settings WebSettings
+		if err := json.Unmarshal(raw, &settings); err != nil {
+			return nil, fmt.Errorf("invalid settings section: %w", err)
+		}
+		archive.Settings = &settings
+	}
+	if raw, ok := files[configArchiveProfilesFile]; ok {
+		var profiles ConfigProfiles
+		if err := json.Unmarshal(raw, &profiles); err != nil {
+			return nil, fmt.Errorf("invalid profiles section: %w", err)
+		}
+		archive.Profiles = &profiles
+	}
+	if raw, ok := files[configArchiveCookiesFile]; ok && opts.Cookies {
+		if opts.Passphrase == "" {
+			return nil, ErrConfigArchivePassphrase
+		}
+		var blob encryptedConfigBlob
+		if err := json.Unmarshal(raw, &blob); err != nil {
+			return nil, fmt.Errorf("invalid cookies section: %w", err)
+		}
+		plain, err := decryptConfigBlob(blob, opts.Passphrase)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(plain, &archive.Cookies); err != nil {
+			return nil, fmt.Errorf("invalid cookies section: %w", err)
+		}
+	}
+	if raw, ok := files[configArchiveDedupFile]; ok {
+		if err := json.Unmarshal(raw, &archive.Dedup); err != nil {
+			return nil, fmt.Errorf("invalid dedup section: %w", err)
+		}
+		if archive.Dedup == nil {
+			archive.Dedup = []DownloadDedupEntry{}
+		}
+	}
+	for name, content := range files {
+		if !strings.HasPrefix(name, configArchiveSectionDir) || !strings.HasSuffix(name, ".json") {
+			continue
+		}
+		section := strings.TrimSuffix(strings.TrimPrefix(name, configArchiveSectionDir), ".json")
+		if !validConfigSectionName(section) {
+			continue
+		}
+		if archive.Sections == nil {
+			archive.Sections = make(map[string]json.RawMessage)
+		}
+		archive.Sections[section] = json.RawMessage(content)
+	}
+	return archive, nil
+}
+
+// HasEncryptedCookies reports whether the raw archive carries a cookies
+// section, so callers can ask for a passphrase before importing.
+func HasEncryptedCookies(data []byte) bool {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return false
+	}
+	for _, file := range zr.File {
+		if file.Name == configArchiveCookiesFile {
+			return true
+		}
+	}
+	return false
+}
+
+// ApplyConfigArchive imports the core-owned sections selected by opts.
+// Settings and profiles replace the current ones, cookies are merged per
+// source and the dedup index is only ever extended.
+func ApplyConfigArchive(archive *ConfigArchive, opts ConfigArchiveOptions) (ConfigImportResult, error) {
+	var result ConfigImportResult
+	if archive == nil {
+		return result, errors.New("config archive is nil")
+	}
+
+	// 配置方案数量先检查，超出上限时整个归档都不导入。
+	var profiles ConfigProfiles
+	if opts.Profiles && archive.Profiles != nil {
+		profiles = normalizeConfigProfiles(*archive.Profiles)
+		if len(profiles.Profiles) > maxConfigProfileCount {
+			return result, fmt.Errorf("%w: archive has %d, limit is %d", ErrTooManyConfigProfiles, len(profiles.Profiles), maxConfigProfileCount)
+		}
+	}
+
+	if opts.Settings && archive.Settings != nil {
+		if err := SaveWebSettings(*archive.Settings); err != nil {
+			return result, err
+		}
+		result.Settings = true
+	}
+	if opts.Profiles && archive.Profiles != nil {
+		if err := saveConfigProfiles(profiles); err != nil {
+			return result, err
+		}
+		result.Profiles = len(profiles.Profiles)
+	}
+	if opts.Cookies && len(archive.Cookies) > 0 {
+		CM.Load()
+		CM.SetAll(archive.Cookies)
+		CM.Save()
+		result.Cookies = len(archive.Cookies)
+	}
+	if opts.Dedup && len(archive.Dedup) > 0 {
+		if err := initDownloadRecordTable(); err != nil {
+			return result, err
+		}
+		entries := make([]DownloadDedupEntry, 0, len(archive.Dedup))
+		for _, entry := range archive.Dedup {
+			name := cleanDownloadRecordText(entry.Name)
+			artist := cleanDownloadRecordText(entry.Artist)
+			key := cleanDownloadRecordText(entry.SongKey)
+			if key == "" {
+				key = songKeyFromParts(name, artist)
+			}
+			entries = append(entries, DownloadDedupEntry{SongKey: key, Name: name, Artist: artist, CreatedAt: entry.CreatedAt})
+		}
+		err := configDB.Transaction(func(tx *gorm.DB) error {
+			res := tx.Clauses(clause.OnConflict{DoNothing: true}).CreateInBatches(&entries, 200)
+			result.Dedup = int(res.RowsAffected)
+			return res.Error
+		})
+		if err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+func validConfigSectionName(name string) bool {
+	if name == "" || len(name) > 64 {
+		return false
+	}
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z') && !(r >= '0' && r <= '9') && r != '_' && r != '-' {
+			return false
+		}
+	}
+	return true
+}
+
+func encryptConfigBlob(plain []byte, passphrase string) (encryptedConfigBlob, error) {
+	blob := encryptedConfigBlob{KDF: "scrypt", N: 1 << 15, R: 8, P: 1}
+	blob.Salt = make([]byte, 16)
+	if _, err := rand.Read(blob.Salt); err != nil {
+		return blob, err
+	}
+	gcm, err := configBlobCipher(blob, passphrase)
+	if err != nil {
+		return blob, err
+	}
+	blob.Nonce = make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(blob.Nonce); err != nil {
+		return blob, err
+	}
+	blob.Ciphertext = gcm.Seal(nil, blob.Nonce, plain, []byte(ConfigArchiveFormat))
+	return blob, nil
+}
+
+func decryptConfigBlob(blob encryptedConfigBlob, passphrase string) ([]byte, error) {
+	if blob.KDF != "scrypt" || blob.N <= 1 || blob.N > 1<<20 || blob.R <= 0 || blob.P <= 0 {
+		return nil, errors.New("unsupported config archive encryption")
+	}
+	gcm, err := configBlobCipher(blob, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	if len(blob.Nonce) != gcm.NonceSize() {
+		return nil, ErrConfigArchiveDecrypt
+	}
+	plain, err := gcm.Open(nil, blob.Nonce, blob.Ciphertext, []byte(ConfigArchiveFormat))
+	if err != nil {
+		return nil, ErrConfigArchiveDecrypt
+	}
+	return plain, nil
+}
+
+func configBlobCipher(blob encryptedConfigBlob, passphrase string) (cipher.AEAD, error) {
+	key, err := scrypt.Key([]byte(passphrase), blob.Salt, blob.N, blob.R, blob.P, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
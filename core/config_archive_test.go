@@ -0,0 +1,270 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func setupConfigArchiveTest(t *testing.T) string {
+	t.Helper()
+	baseDir := t.TempDir()
+	t.Setenv("MUSIC_DL_CONFIG_DB", filepath.Join(baseDir, "data", "settings.db"))
+	t.Setenv("MUSIC_DL_COOKIE_FILE", filepath.Join(baseDir, "data", "cookies.json"))
+	resetConfigStateForTest()
+	t.Cleanup(resetConfigStateForTest)
+	return baseDir
+}
+
+func writeConfigArchiveForTest(t *testing.T, opts ConfigArchiveOptions) []byte {
+	t.Helper()
+	archive, err := BuildConfigArchive(opts)
+	if err != nil {
+		t.Fatalf("BuildConfigArchive() error = %v", err)
+	}
+	var buf bytes.Buffer
+	if err := WriteConfigArchive(&buf, archive, opts.Passphrase); err != nil {
+		t.Fatalf("WriteConfigArchive() error = %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestConfigArchiveRoundTrip(t *testing.T) {
+	setupConfigArchiveTest(t)
+
+	settings := defaultWebSettings()
+	settings.DownloadDir = "/srv/music"
+	settings.DownloadFilenameTemplate = "{artist}/{name}"
+	settings.SearchSources = []string{"netease", "qq"}
+	if err := SaveWebSettings(settings); err != nil {
+		t.Fatalf("SaveWebSettings() error = %v", err)
+	}
+	if _, err := SaveConfigProfile("travel", settings); err != nil {
+		t.Fatalf("SaveConfigProfile() error = %v", err)
+	}
+	if err := SaveDownloadDedupEntry("晴天", "周杰伦"); err != nil {
+		t.Fatalf("SaveDownloadDedupEntry() error = %v", err)
+	}
+	CM.SetAll(map[string]string{"netease": "MUSIC_U=secret"})
+	CM.Save()
+
+	opts := DefaultConfigArchiveOptions()
+	opts.Cookies = true
+	opts.Passphrase = "correct horse"
+	data := writeConfigArchiveForTest(t, opts)
+	if !HasEncryptedCookies(data) {
+		t.Fatal("expected archive to carry encrypted cookies")
+	}
+	if bytes.Contains(data, []byte("MUSIC_U=secret")) {
+		t.Fatal("cookie value leaked into the archive in plain text")
+	}
+
+	setupConfigArchiveTest(t)
+	archive, err := ReadConfigArchive(data, opts)
+	if err != nil {
+		t.Fatalf("ReadConfigArchive() error = %v", err)
+	}
+	result, err := ApplyConfigArchive(archive, opts)
+	if err != nil {
+		t.Fatalf("ApplyConfigArchive() error = %v", err)
+	}
+	if !result.Settings || result.Profiles != 1 || result.Cookies != 1 || result.Dedup != 1 {
+		t.Fatalf("unexpected import result: %+v", result)
+	}
+
+	got := GetWebSettings()
+	if got.DownloadDir != "/srv/music" || got.DownloadFilenameTemplate != "{artist}/{name}" {
+		t.Fatalf("settings not restored: %+v", got)
+	}
+	if !reflect.DeepEqual(got.SearchSources, []string{"netease", "qq"}) {
+		t.Fatalf("search sources not restored: %#v", got.SearchSources)
+	}
+	if cookie := CM.Get("netease"); cookie != "MUSIC_U=secret" {
+		t.Fatalf("cookie not restored, got %q", cookie)
+	}
+	dedup, err := LoadDownloadDedupSet()
+	if err != nil {
+		t.Fatalf("LoadDownloadDedupSet() error = %v", err)
+	}
+	if _, ok := dedup[songKeyFromParts("晴天", "周杰伦")]; !ok {
+		t.Fatalf("dedup entry not restored: %#v", dedup)
+	}
+
+	// Importing the same archive again must not duplicate the dedup index.
+	result, err = ApplyConfigArchive(archive, opts)
+	if err != nil {
+		t.Fatalf("second ApplyConfigArchive() error = %v", err)
+	}
+	if result.Dedup != 0 {
+		t.Fatalf("expected re-import to add no dedup rows, got %d", result.Dedup)
+	}
+}
+
+func TestConfigArchiveCookiesRequirePassphrase(t *testing.T) {
+	setupConfigArchiveTest(t)
+	CM.SetAll(map[string]string{"qq": "uin=1"})
+	CM.Save()
+
+	opts := DefaultConfigArchiveOptions()
+	opts.Cookies = true
+	if _, err := BuildConfigArchive(opts); !errors.Is(err, ErrConfigArchivePassphrase) {
+		t.Fatalf("expected BuildConfigArchive to require a passphrase, got %v", err)
+	}
+
+	archive := &ConfigArchive{
+		Manifest: ConfigArchiveManifest{Format: ConfigArchiveFormat, Version: ConfigArchiveVersion},
+		Cookies:  CM.GetAll(),
+	}
+	var buf bytes.Buffer
+	if err := WriteConfigArchive(&buf, archive, ""); !errors.Is(err, ErrConfigArchivePassphrase) {
+		t.Fatalf("expected ErrConfigArchivePassphrase, got %v", err)
+	}
+}
+
+func TestConfigArchiveWrongPassphrase(t *testing.T) {
+	setupConfigArchiveTest(t)
+	CM.SetAll(map[string]string{"qq": "uin=1"})
+	CM.Save()
+
+	opts := DefaultConfigArchiveOptions()
+	opts.Cookies = true
+	opts.Passphrase = "right"
+	data := writeConfigArchiveForTest(t, opts)
+
+	opts.Passphrase = "wrong"
+	if _, err := ReadConfigArchive(data, opts); !errors.Is(err, ErrConfigArchiveDecrypt) {
+		t.Fatalf("expected ErrConfigArchiveDecrypt, got %v", err)
+	}
+	opts.Passphrase = ""
+	if _, err := ReadConfigArchive(data, opts); !errors.Is(err, ErrConfigArchivePassphrase) {
+		t.Fatalf("expected ErrConfigArchivePassphrase, got %v", err)
+	}
+
+	// Cookies that were not requested are never decrypted, even with a
+	// (wrong) passphrase, and the rest of the archive is still readable.
+	archive, err := ReadConfigArchive(data, ConfigArchiveOptions{Settings: true, Passphrase: "wrong"})
+	if err != nil {
+		t.Fatalf("ReadConfigArchive() without cookies error = %v", err)
+	}
+	if archive.Cookies != nil {
+		t.Fatalf("expected cookies to be skipped, got %#v", archive.Cookies)
+	}
+	if archive.Settings == nil {
+		t.Fatal("expected settings section to be present")
+	}
+}
+
+func TestConfigArchiveRejectsUnknownFormat(t *testing.T) {
+	if _, err := ReadConfigArchive([]byte("not a zip"), ConfigArchiveOptions{}); err == nil {
+		t.Fatal("expected error for non-zip input")
+	}
+}
+
+func TestConfigArchiveSections(t *testing.T) {
+	setupConfigArchiveTest(t)
+
+	opts := ConfigArchiveOptions{}
+	archive, err := BuildConfigArchive(opts)
+	if err != nil {
+		t.Fatalf("BuildConfigArchive() error = %v", err)
+	}
+	if err := archive.SetSection("collections", []string{"a", "b"}); err != nil {
+		t.Fatalf("SetSection() error = %v", err)
+	}
+	var buf bytes.Buffer
+	if err := WriteConfigArchive(&buf, archive, ""); err != nil {
+		t.Fatalf("WriteConfigArchive() error = %v", err)
+	}
+
+	read, err := ReadConfigArchive(buf.Bytes(), ConfigArchiveOptions{})
+	if err != nil {
+		t.Fatalf("ReadConfigArchive() error = %v", err)
+	}
+	if string(read.Sections["collections"]) == "" {
+		t.Fatalf("expected collections section, got %#v", read.Sections)
+	}
+	if read.Settings != nil || read.Profiles != nil || read.Dedup != nil {
+		t.Fatalf("expected only the extension section, got %+v", read)
+	}
+}
+
+func TestConfigProfilesSaveActivateDelete(t *testing.T) {
+	setupConfigArchiveTest(t)
+
+	home := defaultWebSettings()
+	home.DownloadDir = "/home/music"
+	travel := defaultWebSettings()
+	travel.DownloadDir = "/mnt/usb/music"
+
+	if _, err := SaveConfigProfile("home", home); err != nil {
+		t.Fatalf("SaveConfigProfile(home) error = %v", err)
+	}
+	if _, err := SaveConfigProfile(" travel ", travel); err != nil {
+		t.Fatalf("SaveConfigProfile(travel) error = %v", err)
+	}
+	if _, err := SaveConfigProfile("   ", travel); !errors.Is(err, ErrInvalidConfigProfile) {
+		t.Fatalf("expected ErrInvalidConfigProfile, got %v", err)
+	}
+
+	settings, err := ActivateConfigProfile("travel")
+	if err != nil {
+		t.Fatalf("ActivateConfigProfile() error = %v", err)
+	}
+	if settings.DownloadDir != "/mnt/usb/music" || GetWebSettings().DownloadDir != "/mnt/usb/music" {
+		t.Fatalf("profile not applied: %+v", settings)
+	}
+
+	profiles, err := GetConfigProfiles()
+	if err != nil {
+		t.Fatalf("GetConfigProfiles() error = %v", err)
+	}
+	if profiles.Active != "travel" || len(profiles.Profiles) != 2 {
+		t.Fatalf("unexpected profiles: %+v", profiles)
+	}
+
+	if err := DeleteConfigProfile("travel"); err != nil {
+		t.Fatalf("DeleteConfigProfile() error = %v", err)
+	}
+	if err := DeleteConfigProfile("travel"); !errors.Is(err, ErrConfigProfileNotFound) {
+		t.Fatalf("expected ErrConfigProfileNotFound, got %v", err)
+	}
+	if _, err := ActivateConfigProfile("travel"); !errors.Is(err, ErrConfigProfileNotFound) {
+		t.Fatalf("expected ErrConfigProfileNotFound, got %v", err)
+	}
+	profiles, _ = GetConfigProfiles()
+	if profiles.Active != "" || len(profiles.Profiles) != 1 {
+		t.Fatalf("unexpected profiles after delete: %+v", profiles)
+	}
+	if GetWebSettings().DownloadDir != "/mnt/usb/music" {
+		t.Fatal("deleting a profile must not change current settings")
+	}
+}
+
+func TestApplyConfigArchiveRejectsTooManyProfiles(t *testing.T) {
+	setupConfigArchiveTest(t)
+
+	settings := defaultWebSettings()
+	settings.DownloadDir = "/srv/music"
+	archive := &ConfigArchive{Settings: &settings, Profiles: &ConfigProfiles{}}
+	for i := 0; i <= maxConfigProfileCount; i++ {
+		archive.Profiles.Profiles = append(archive.Profiles.Profiles, ConfigProfile{
+			Name:     fmt.Sprintf("profile-%d", i),
+			Settings: settings,
+		})
+	}
+
+	_, err := ApplyConfigArchive(archive, ConfigArchiveOptions{Settings: true, Profiles: true})
+	if !errors.Is(err, ErrTooManyConfigProfiles) {
+		t.Fatalf("expected ErrTooManyConfigProfiles, got %v", err)
+	}
+	// 被拒绝的归档不应改动任何部分。
+	if GetWebSettings().DownloadDir == "/srv/music" {
+		t.Fatal("settings were applied from a rejected archive")
+	}
+	if profiles, err := GetConfigProfiles(); err != nil || len(profiles.Profiles) != 0 {
+		t.Fatalf("profiles = %+v, %v", profiles, err)
+	}
+}
@@ -0,0 +1,345 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/goccy/go-yaml"
+	"github.com/pelletier/go-toml/v2"
+)
+
+// ConfigFileEnv names the environment variable used when --config is not given.
+const ConfigFileEnv = "MUSIC_DL_CONFIG"
+
+const settingsEnvPrefix = "MUSIC_DL_"
+
+// webSettingsField describes one WebSettings field that can be overridden
+// from a config file or an environment variable.
+type webSettingsField struct {
+	Key  string // JSON name, also used by the web UI
+	Env  string
+	Kind reflect.Kind
+}
+
+var webSettingsFields = buildWebSettingsFields()
+
+var (
+	configFileMu     sync.RWMutex
+	configFilePath   string
+	configFileValues map[string]json.RawMessage
+	// configOverrides caches the merged file and environment overrides. It is
+	// filled by LoadConfigFile, or lazily from the environment alone when no
+	// file was ever loaded, so GetWebSettings does not rescan MUSIC_DL_* on
+	// every call.
+	configOverrides *webSettingsOverrideSet
+)
+
+// webSettingsOverrideSet is the merged override state; patch is values encoded
+// once so applying it costs a single Unmarshal.
+type webSettingsOverrideSet struct {
+	values  map[string]json.RawMessage
+	origins map[string]string
+	patch   []byte
+}
+
+func buildWebSettingsFields() []webSettingsField {
+	t := reflect.TypeOf(WebSettings{})
+	fields := make([]webSettingsField, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		key, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if key == "" || key == "-" {
+			continue
+		}
+		fields = append(fields, webSettingsField{
+			Key:  key,
+			Env:  settingsEnvPrefix + envSuffixForKey(key),
+			Kind: f.Type.Kind(),
+		})
+	}
+	return fields
+}
+
+// envSuffixForKey turns "downloadDir" into "DOWNLOAD_DIR" and "githubProxyUrl"
+// into "GITHUB_PROXY_URL".
+func envSuffixForKey(key string) string {
+	var b strings.Builder
+	for i, r := range key {
+		if i > 0 && unicode.IsUpper(r) {
+			b.WriteByte('_')
+		}
+		b.WriteRune(unicode.ToUpper(r))
+	}
+	return b.String()
+}
+
+// lookupWebSettingsField accepts camelCase, snake_case and kebab-case keys so
+// that YAML and TOML files can use whichever style they prefer.
+func lookupWebSettingsField(name string) (webSettingsField, bool) {
+	normalized := strings.ToLower(strings.NewReplacer("_", "", "-", "").Replace(strings.TrimSpace(name)))
+	for _, field := range webSettingsFields {
+		if strings.ToLower(field.Key) == normalized {
+			return field, true
+		}
+	}
+	return webSettingsField{}, false
+}
+
+// LoadConfigFile reads a YAML, TOML or JSON settings file. Every key in it
+// overrides the stored web settings and is shown read-only in the settings
+// panel. An empty path falls back to $MUSIC_DL_CONFIG; when both are empty any
+// previously loaded file is forgotten. MUSIC_DL_* setting variables are read
+// here too and invalid ones are reported, so misconfiguration fails at
+// startup; later changes to the environment need another LoadConfigFile.
+func LoadConfigFile(path string) error {
+	path = strings.TrimSpace(path)
+	if path == "" {
+		path = strings.TrimSpace(os.Getenv(ConfigFileEnv))
+	}
+
+	var values map[string]json.RawMessage
+	if path != "" {
+		var err error
+		values, err = parseConfigFile(path)
+		if err != nil {
+			return err
+		}
+	}
+	// Invalid variables are skipped but the valid ones still take effect.
+	envValues, envErr := envWebSettingsOverrides()
+
+	configFileMu.Lock()
+	configFilePath = path
+	configFileValues = values
+	configOverrides = mergeWebSettingsOverrides(path, values, envValues)
+	configFileMu.Unlock()
+	return envErr
+}
+
+// ConfigFilePath returns the config file loaded by LoadConfigFile, if any.
+func ConfigFilePath() string {
+	configFileMu.RLock()
+	defer configFileMu.RUnlock()
+	return configFilePath
+}
+
+func parseConfigFile(path string) (map[string]json.RawMessage, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read config file: %w", err)
+	}
+
+	raw := make(map[string]interface{})
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &raw)
+	case ".toml":
+		err = toml.Unmarshal(data, &raw)
+	case ".json":
+		err = json.Unmarshal(data, &raw)
+	default:
+		return nil, fmt.Errorf("unsupported config file type %q (use .yaml, .yml, .toml or .json)", filepath.Ext(path))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parse config file %s: %w", path, err)
+	}
+
+	values := make(map[string]json.RawMessage, len(raw))
+	for name, value := range raw {
+		field, ok := lookupWebSettingsField(name)
+		if !ok {
+			return nil, fmt.Errorf("config file %s: unknown setting %q", path, name)
+		}
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return nil, fmt.Errorf("config file %s: setting %q: %w", path, name, err)
+		}
+		if err := checkWebSettingsValue(field, encoded); err != nil {
+			return nil, fmt.Errorf("config file %s: setting %q: %w", path, name, err)
+		}
+		values[field.Key] = encoded
+	}
+	return values, nil
+}
+
+// checkWebSettingsValue makes sure value decodes into the field's Go type.
+func checkWebSettingsValue(field webSettingsField, value json.RawMessage) error {
+	var settings WebSettings
+	return json.Unmarshal([]byte(`{"`+field.Key+`":`+string(value)+`}`), &settings)
+}
+
+// envWebSettingsOverrides parses every MUSIC_DL_* setting variable. Invalid
+// values are skipped and the first problem is returned alongside the rest.
+func envWebSettingsOverrides() (map[string]json.RawMessage, error) {
+	values := make(map[string]json.RawMessage)
+	var firstErr error
+	fail := func(err error) {
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	for _, field := range webSettingsFields {
+		raw, ok := os.LookupEnv(field.Env)
+		if !ok {
+			continue
+		}
+		raw = strings.TrimSpace(raw)
+		var value interface{}
+		switch field.Kind {
+		case reflect.Bool:
+			b, err := strconv.ParseBool(raw)
+			if err != nil {
+				fail(fmt.Errorf("%s: invalid boolean %q", field.Env, raw))
+				continue
+			}
+			value = b
+		case reflect.Int:
+			n, err := strconv.Atoi(raw)
+			if err != nil {
+				fail(fmt.Errorf("%s: invalid integer %q", field.Env, raw))
+				continue
+			}
+			value = n
+		case reflect.Slice:
+			// A JSON array is taken as-is, which is the only way to pass
+			// structured lists such as libraryRoots.
+			if strings.HasPrefix(raw, "[") {
+				if err := checkWebSettingsValue(field, json.RawMessage(raw)); err != nil {
+					fail(fmt.Errorf("%s: invalid JSON list: %w", field.Env, err))
+					continue
+				}
+				values[field.Key] = json.RawMessage(raw)
+				continue
+			}
+			items := []string{}
+			for _, item := range strings.Split(raw, ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					items = append(items, item)
+				}
+			}
+			value = items
+		default:
+			value = raw
+		}
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			fail(err)
+			continue
+		}
+		values[field.Key] = encoded
+	}
+	return values, firstErr
+}
+
+// webSettingsOverrides returns the cached file and environment overrides.
+// Callers must not modify the returned maps.
+func webSettingsOverrides() *webSettingsOverrideSet {
+	configFileMu.RLock()
+	set := configOverrides
+	configFileMu.RUnlock()
+	if set != nil {
+		return set
+	}
+
+	// Invalid variables are reported by LoadConfigFile; here they are skipped
+	// so GetWebSettings never fails.
+	envValues, _ := envWebSettingsOverrides()
+	configFileMu.Lock()
+	defer configFileMu.Unlock()
+	if configOverrides == nil {
+		configOverrides = mergeWebSettingsOverrides(configFilePath, configFileValues, envValues)
+	}
+	return configOverrides
+}
+
+// mergeWebSettingsOverrides combines file and environment overrides;
+// environment variables win over the file.
+func mergeWebSettingsOverrides(path string, fileValues, envValues map[string]json.RawMessage) *webSettingsOverrideSet {
+	set := &webSettingsOverrideSet{
+		values:  make(map[string]json.RawMessage, len(fileValues)+len(envValues)),
+		origins: make(map[string]string, len(fileValues)+len(envValues)),
+	}
+	for key, value := range fileValues {
+		set.values[key] = value
+		set.origins[key] = "file:" + path
+	}
+	for _, field := range webSettingsFields {
+		if value, ok := envValues[field.Key]; ok {
+			set.values[field.Key] = value
+			set.origins[field.Key] = "env:" + field.Env
+		}
+	}
+	if len(set.values) > 0 {
+		set.patch, _ = json.Marshal(set.values)
+	}
+	return set
+}
+
+func applyWebSettingsOverrides(settings WebSettings, values map[string]json.RawMessage) WebSettings {
+	if len(values) == 0 {
+		return settings
+	}
+	patch, err := json.Marshal(values)
+	if err != nil {
+		return settings
+	}
+	_ = json.Unmarshal(patch, &settings)
+	return settings
+}
+
+// WebSettingsOverrides reports which settings are pinned by the config file or
+// environment, keyed by JSON field name. The value is "file:<path>" or
+// "env:<VARIABLE>".
+func WebSettingsOverrides() map[string]string {
+	origins := webSettingsOverrides().origins
+	out := make(map[string]string, len(origins))
+	for key, origin := range origins {
+		out[key] = origin
+	}
+	return out
+}
+
+// WebSettingsEnvName returns the MUSIC_DL_* variable that overrides key.
+func WebSettingsEnvName(key string) string {
+	if field, ok := lookupWebSettingsField(key); ok {
+		return field.Env
+	}
+	return ""
+}
+
+// WebSettingsKeys returns the JSON names of all settings in struct order.
+func WebSettingsKeys() []string {
+	keys := make([]string, 0, len(webSettingsFields))
+	for _, field := range webSettingsFields {
+		keys = append(keys, field.Key)
+	}
+	return keys
+}
+
+// keepPinnedWebSettings copies pinned fields from stored into settings so that
+// saving from the UI does not overwrite the stored value with the override;
+// removing the override later reveals the user's own value again.
+func keepPinnedWebSettings(settings, stored WebSettings, pinned map[string]string) WebSettings {
+	if len(pinned) == 0 {
+		return settings
+	}
+	var storedMap map[string]json.RawMessage
+	data, err := json.Marshal(stored)
+	if err != nil || json.Unmarshal(data, &storedMap) != nil {
+		return settings
+	}
+	values := make(map[string]json.RawMessage, len(pinned))
+	for key := range pinned {
+		if value, ok := storedMap[key]; ok {
+			values[key] = value
+		}
+	}
+	return applyWebSettingsOverrides(settings, values)
+}
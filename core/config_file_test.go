@@ -0,0 +1,232 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func setupConfigFileTest(t *testing.T) string {
+	t.Helper()
+	baseDir := setupConfigArchiveTest(t)
+	t.Setenv(ConfigFileEnv, "")
+	t.Cleanup(func() {
+		configFileMu.Lock()
+		configFilePath = ""
+		configFileValues = nil
+		configOverrides = nil
+		configFileMu.Unlock()
+	})
+	return baseDir
+}
+
+func writeConfigFileForTest(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("write config file: %v", err)
+	}
+	return path
+}
+
+func TestEnvSuffixForKey(t *testing.T) {
+	cases := map[string]string{
+		"downloadDir":              "DOWNLOAD_DIR",
+		"githubProxyUrl":           "GITHUB_PROXY_URL",
+		"autoSwitchInvalidSources": "AUTO_SWITCH_INVALID_SOURCES",
+		"webPageSize":              "WEB_PAGE_SIZE",
+	}
+	for key, want := range cases {
+		if got := envSuffixForKey(key); got != want {
+			t.Fatalf("envSuffixForKey(%q) = %q, want %q", key, got, want)
+		}
+	}
+}
+
+func TestLoadConfigFileYAMLOverridesStoredSettings(t *testing.T) {
+	baseDir := setupConfigFileTest(t)
+
+	stored := defaultWebSettings()
+	stored.DownloadDir = "/home/me/Music"
+	stored.CliPageSize = 50
+	if err := SaveWebSettings(stored); err != nil {
+		t.Fatalf("SaveWebSettings() error = %v", err)
+	}
+
+	path := writeConfigFileForTest(t, baseDir, "music-dl.yaml", `
+download_dir: /data/music
+webPageSize: 100
+auto-cache-on-play: true
+search_sources: [qq, netease]
+`)
+	if err := LoadConfigFile(path); err != nil {
+		t.Fatalf("LoadConfigFile() error = %v", err)
+	}
+
+	got := GetWebSettings()
+	if got.DownloadDir != "/data/music" || got.WebPageSize != 100 || !got.AutoCacheOnPlay {
+		t.Fatalf("file overrides not applied: %+v", got)
+	}
+	if !reflect.DeepEqual(got.SearchSources, []string{"qq", "netease"}) {
+		t.Fatalf("search sources = %#v", got.SearchSources)
+	}
+	if got.CliPageSize != 50 {
+		t.Fatalf("stored value for unpinned field lost, got %d", got.CliPageSize)
+	}
+
+	overrides := WebSettingsOverrides()
+	if overrides["downloadDir"] != "file:"+path || overrides["cliPageSize"] != "" {
+		t.Fatalf("unexpected overrides: %#v", overrides)
+	}
+	if ConfigFilePath() != path {
+		t.Fatalf("ConfigFilePath() = %q", ConfigFilePath())
+	}
+}
+
+func TestLoadConfigFileTOML(t *testing.T) {
+	baseDir := setupConfigFileTest(t)
+	path := writeConfigFileForTest(t, baseDir, "music-dl.toml", `
+downloadFilenameTemplate = "{artist}/{album}/{name}"
+downloadConcurrency = 9
+embedDownload = false
+`)
+	if err := LoadConfigFile(path); err != nil {
+		t.Fatalf("LoadConfigFile() error = %v", err)
+	}
+	got := GetWebSettings()
+	if got.DownloadFilenameTemplate != "{artist}/{album}/{name}" || got.EmbedDownload {
+		t.Fatalf("toml overrides not applied: %+v", got)
+	}
+	if got.DownloadConcurrency != 5 {
+		t.Fatalf("expected normalizeWebSettings to clamp concurrency to 5, got %d", got.DownloadConcurrency)
+	}
+}
+
+func TestLoadConfigFileRejectsInvalidInput(t *testing.T) {
+	baseDir := setupConfigFileTest(t)
+
+	cases := []struct {
+		name, content, want string
+	}{
+		{"unknown.yaml", "downloadDirectory: /x\n", "unknown setting"},
+		{"type.yaml", "webPageSize: lots\n", "webPageSize"},
+		{"settings.ini", "downloadDir=/x\n", ".yaml, .yml, .toml or .json"},
+	}
+	for _, tc := range cases {
+		path := writeConfigFileForTest(t, baseDir, tc.name, tc.content)
+		err := LoadConfigFile(path)
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Fatalf("LoadConfigFile(%s) error = %v, want it to mention %q", tc.name, err, tc.want)
+		}
+	}
+	if err := LoadConfigFile(filepath.Join(baseDir, "missing.yaml")); err == nil {
+		t.Fatal("expected error for missing file")
+	}
+}
+
+func TestEnvOverridesWinOverConfigFile(t *testing.T) {
+	baseDir := setupConfigFileTest(t)
+	path := writeConfigFileForTest(t, baseDir, "music-dl.yml", "downloadDir: /from/file\ncliPageSize: 30\n")
+	t.Setenv(ConfigFileEnv, path)
+	t.Setenv("MUSIC_DL_DOWNLOAD_DIR", "/from/env")
+	t.Setenv("MUSIC_DL_AUTO_CHECK_UPDATE", "false")
+	t.Setenv("MUSIC_DL_SEARCH_SOURCES", "kugou, qq ,unknown")
+
+	if err := LoadConfigFile(""); err != nil {
+		t.Fatalf("LoadConfigFile() error = %v", err)
+	}
+	got := GetWebSettings()
+	if got.DownloadDir != "/from/env" || got.CliPageSize != 30 || got.AutoCheckUpdate {
+		t.Fatalf("unexpected settings: %+v", got)
+	}
+	if !reflect.DeepEqual(got.SearchSources, []string{"kugou", "qq"}) {
+		t.Fatalf("search sources = %#v", got.SearchSources)
+	}
+
+	overrides := WebSettingsOverrides()
+	if overrides["downloadDir"] != "env:MUSIC_DL_DOWNLOAD_DIR" || overrides["cliPageSize"] != "file:"+path {
+		t.Fatalf("unexpected overrides: %#v", overrides)
+	}
+}
+
+func TestInvalidEnvOverrideIsReportedAndSkipped(t *testing.T) {
+	setupConfigFileTest(t)
+	t.Setenv("MUSIC_DL_WEB_PAGE_SIZE", "many")
+	t.Setenv("MUSIC_DL_CLI_PAGE_SIZE", "42")
+
+	err := LoadConfigFile("")
+	if err == nil || !strings.Contains(err.Error(), "MUSIC_DL_WEB_PAGE_SIZE") {
+		t.Fatalf("expected invalid env error, got %v", err)
+	}
+	got := GetWebSettings()
+	if got.WebPageSize != DefaultWebPageSize || got.CliPageSize != 42 {
+		t.Fatalf("unexpected settings: %+v", got)
+	}
+}
+
+func TestSaveWebSettingsKeepsStoredValueForPinnedFields(t *testing.T) {
+	setupConfigFileTest(t)
+
+	stored := defaultWebSettings()
+	stored.DownloadDir = "/home/me/Music"
+	if err := SaveWebSettings(stored); err != nil {
+		t.Fatalf("SaveWebSettings() error = %v", err)
+	}
+
+	t.Setenv("MUSIC_DL_DOWNLOAD_DIR", "/data/music")
+	if err := LoadConfigFile(""); err != nil {
+		t.Fatalf("LoadConfigFile() error = %v", err)
+	}
+	next := GetWebSettings()
+	next.DownloadDir = "/tmp/ignored"
+	next.CliPageSize = 77
+	if err := SaveWebSettings(next); err != nil {
+		t.Fatalf("SaveWebSettings() error = %v", err)
+	}
+	if got := GetWebSettings(); got.DownloadDir != "/data/music" || got.CliPageSize != 77 {
+		t.Fatalf("unexpected effective settings: %+v", got)
+	}
+
+	os.Unsetenv("MUSIC_DL_DOWNLOAD_DIR")
+	if err := LoadConfigFile(""); err != nil {
+		t.Fatalf("LoadConfigFile() error = %v", err)
+	}
+	if got := GetWebSettings(); got.DownloadDir != "/home/me/Music" {
+		t.Fatalf("stored value for pinned field was overwritten: %q", got.DownloadDir)
+	}
+}
+
+func TestLibraryRootsFromConfigFileAndEnv(t *testing.T) {
+	baseDir := setupConfigFileTest(t)
+	path := writeConfigFileForTest(t, baseDir, "music-dl.yaml", `
+libraryRoots:
+  - label: Archive
+    path: /disk2/archive
+    readOnly: true
+    exclude: ["Podcasts"]
+`)
+	t.Setenv(ConfigFileEnv, path)
+	if err := LoadConfigFile(""); err != nil {
+		t.Fatalf("LoadConfigFile() error = %v", err)
+	}
+	roots := GetWebSettings().LibraryRoots
+	if len(roots) != 1 || roots[0].Label != "Archive" || !roots[0].ReadOnly || roots[0].Key == "" {
+		t.Fatalf("roots from file = %+v", roots)
+	}
+
+	t.Setenv("MUSIC_DL_LIBRARY_ROOTS", `[{"key":"nas","label":"NAS","path":"/mnt/nas"}]`)
+	if err := LoadConfigFile(""); err != nil {
+		t.Fatalf("LoadConfigFile() error = %v", err)
+	}
+	roots = GetWebSettings().LibraryRoots
+	if len(roots) != 1 || roots[0].Key != "nas" || roots[0].Path != filepath.Clean("/mnt/nas") {
+		t.Fatalf("roots from env = %+v", roots)
+	}
+
+	t.Setenv("MUSIC_DL_LIBRARY_ROOTS", `[{"path":`)
+	if err := LoadConfigFile(""); err == nil || !strings.Contains(err.Error(), "MUSIC_DL_LIBRARY_ROOTS") {
+		t.Fatalf("expected invalid JSON error, got %v", err)
+	}
+}
@@ -0,0 +1,148 @@
+package core
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+const (
+	webProfilesKey        = "web_profiles"
+	maxConfigProfileName  = 64
+	maxConfigProfileCount = 32
+)
+
+var (
+	ErrConfigProfileNotFound = errors.New("config profile not found")
+	ErrInvalidConfigProfile  = errors.New("invalid config profile name")
+	ErrTooManyConfigProfiles = errors.New("too many config profiles")
+)
+
+// ConfigProfile is a named snapshot of WebSettings, e.g. "home" and "travel"
+// with different download directories, templates and search sources.
+type ConfigProfile struct {
+	Name      string      `json:"name"`
+	Settings  WebSettings `json:"settings"`
+	UpdatedAt time.Time   `json:"updatedAt"`
+}
+
+// ConfigProfiles is the persisted profile list plus the name of the profile
+// that was last switched to. Active is informational only: the effective
+// settings always live in the web_settings row.
+type ConfigProfiles struct {
+	Active   string          `json:"active"`
+	Profiles []ConfigProfile `json:"profiles"`
+}
+
+func normalizeConfigProfileName(name string) (string, error) {
+	name = strings.TrimSpace(stripControl(name))
+	if name == "" || len([]rune(name)) > maxConfigProfileName {
+		return "", ErrInvalidConfigProfile
+	}
+	return name, nil
+}
+
+func normalizeConfigProfiles(profiles ConfigProfiles) ConfigProfiles {
+	seen := make(map[string]bool, len(profiles.Profiles))
+	normalized := make([]ConfigProfile, 0, len(profiles.Profiles))
+	for _, profile := range profiles.Profiles {
+		name, err := normalizeConfigProfileName(profile.Name)
+		if err != nil || seen[name] {
+			continue
+		}
+		seen[name] = true
+		profile.Name = name
+		profile.Settings = normalizeWebSettings(profile.Settings)
+		normalized = append(normalized, profile)
+	}
+	profiles.Profiles = normalized
+	profiles.Active = strings.TrimSpace(profiles.Active)
+	if !seen[profiles.Active] {
+		profiles.Active = ""
+	}
+	return profiles
+}
+
+func (p ConfigProfiles) index(name string) int {
+	for i, profile := range p.Profiles {
+		if profile.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// GetConfigProfiles returns all stored profiles in creation order.
+func GetConfigProfiles() (ConfigProfiles, error) {
+	var profiles ConfigProfiles
+	if _, err := loadConfigValue(webProfilesKey, &profiles); err != nil {
+		return ConfigProfiles{}, err
+	}
+	return normalizeConfigProfiles(profiles), nil
+}
+
+func saveConfigProfiles(profiles ConfigProfiles) error {
+	return saveConfigValue(webProfilesKey, normalizeConfigProfiles(profiles))
+}
+
+// SaveConfigProfile creates or replaces the profile called name.
+func SaveConfigProfile(name string, settings WebSettings) (ConfigProfile, error) {
+	name, err := normalizeConfigProfileName(name)
+	if err != nil {
+		return ConfigProfile{}, err
+	}
+	profiles, err := GetConfigProfiles()
+	if err != nil {
+		return ConfigProfile{}, err
+	}
+
+	profile := ConfigProfile{
+		Name:      name,
+		Settings:  normalizeWebSettings(settings),
+		UpdatedAt: time.Now(),
+	}
+	if i := profiles.index(name); i >= 0 {
+		profiles.Profiles[i] = profile
+	} else {
+		if len(profiles.Profiles) >= maxConfigProfileCount {
+			return ConfigProfile{}, ErrTooManyConfigProfiles
+		}
+		profiles.Profiles = append(profiles.Profiles, profile)
+	}
+	return profile, saveConfigProfiles(profiles)
+}
+
+// DeleteConfigProfile removes a profile. The current settings are untouched.
+func DeleteConfigProfile(name string) error {
+	profiles, err := GetConfigProfiles()
+	if err != nil {
+		return err
+	}
+	i := profiles.index(strings.TrimSpace(name))
+	if i < 0 {
+		return ErrConfigProfileNotFound
+	}
+	profiles.Profiles = append(profiles.Profiles[:i], profiles.Profiles[i+1:]...)
+	return saveConfigProfiles(profiles)
+}
+
+// ActivateConfigProfile copies the profile's settings into web_settings so the
+// running server, the TUI and the next start all pick them up.
+func ActivateConfigProfile(name string) (WebSettings, error) {
+	profiles, err := GetConfigProfiles()
+	if err != nil {
+		return WebSettings{}, err
+	}
+	i := profiles.index(strings.TrimSpace(name))
+	if i < 0 {
+		return WebSettings{}, ErrConfigProfileNotFound
+	}
+	if err := SaveWebSettings(profiles.Profiles[i].Settings); err != nil {
+		return WebSettings{}, err
+	}
+	profiles.Active = profiles.Profiles[i].Name
+	if err := saveConfigProfiles(profiles); err != nil {
+		return WebSettings{}, err
+	}
+	return GetWebSettings(), nil
+}
@@ -27,6 +27,14 @@ const (
 	webAuthSettingsKey              = "web_auth_settings"
 )
 
+// Local music watch modes (WebSettings.LocalMusicWatchMode).
+const (
+	LocalMusicWatchAuto     = "auto"
+	LocalMusicWatchFSNotify = "fsnotify"
+	LocalMusicWatchPoll     = "poll"
+	LocalMusicWatchOff      = "off"
+)
+
 type configKV struct {
 	Key       string    `gorm:"primaryKey;size:128"`
 	Value     string    `gorm:"type:text;not null"`
@@ -40,24 +48,48 @@ type cookieEntry struct {
 }
 
 type WebSettings struct {
-	EmbedDownload            bool   `json:"embedDownload"`
-	DownloadToLocal          bool   `json:"downloadToLocal"`
-	DownloadDir              string `json:"downloadDir"`
-	DownloadFilenameTemplate string `json:"downloadFilenameTemplate"`
-	DisableFloatingLyrics    bool   `json:"disableFloatingLyrics"`
-	WebPageSize              int    `json:"webPageSize"`
-	CliPageSize              int    `json:"cliPageSize"`
-	DownloadConcurrency      int    `json:"downloadConcurrency"`
-	AutoCheckUpdate          bool   `json:"autoCheckUpdate"`
-	AutoSwitchInvalidSources bool   `json:"autoSwitchInvalidSources"`
-	AutoCacheOnPlay          bool   `json:"autoCacheOnPlay"`
-	UpdateRepoURL            string `json:"updateRepoUrl"`
-	GithubProxyEnabled       bool   `json:"githubProxyEnabled"`
-	GithubProxyURL           string `json:"githubProxyUrl"`
-	VgChangeCover            bool   `json:"vgChangeCover"`
-	VgChangeAudio            bool   `json:"vgChangeAudio"`
-	VgChangeLyric            bool   `json:"vgChangeLyric"`
-	VgExportVideo            bool   `json:"vgExportVideo"`
+	EmbedDownload            bool     `json:"embedDownload"`
+	DownloadToLocal          bool     `json:"downloadToLocal"`
+	DownloadDir              string   `json:"downloadDir"`
+	DownloadFilenameTemplate string   `json:"downloadFilenameTemplate"`
+	DisableFloatingLyrics    bool     `json:"disableFloatingLyrics"`
+	WebPageSize              int      `json:"webPageSize"`
+	CliPageSize              int      `json:"cliPageSize"`
+	DownloadConcurrency      int      `json:"downloadConcurrency"`
+	AutoCheckUpdate          bool     `json:"autoCheckUpdate"`
+	AutoSwitchInvalidSources bool     `json:"autoSwitchInvalidSources"`
+	AutoCacheOnPlay          bool     `json:"autoCacheOnPlay"`
+	UpdateRepoURL            string   `json:"updateRepoUrl"`
+	GithubProxyEnabled       bool     `json:"githubProxyEnabled"`
+	GithubProxyURL           string   `json:"githubProxyUrl"`
+	VgChangeCover            bool     `json:"vgChangeCover"`
+	VgChangeAudio            bool     `json:"vgChangeAudio"`
+	VgChangeLyric            bool     `json:"vgChangeLyric"`
+	VgExportVideo            bool     `json:"vgExportVideo"`
+	SearchSources            []string `json:"searchSources"`
+	// LocalMusicWatchMode 控制本地音乐目录的变更监听：auto、fsnotify、poll、off。
+	LocalMusicWatchMode string `json:"localMusicWatchMode"`
+	// LibraryRoots 是下载目录之外的其他本地曲库目录。
+	LibraryRoots []LibraryRoot `json:"libraryRoots"`
+	// 本地搜索的拼音 / 首字母匹配与繁简归一，修改后会重建本地索引。
+	LocalMusicSearchPinyin       bool `json:"localMusicSearchPinyin"`
+	LocalMusicSearchFoldVariants bool `json:"localMusicSearchFoldVariants"`
+	// TranscodeProfiles 是内置转码档案之外的自定义档案。
+	TranscodeProfiles []TranscodeProfile `json:"transcodeProfiles"`
+	// DownloadTranscodeMode 决定下载到本地后是否转码：""、also（另存一份）、
+	// convert（替换原文件）；DownloadTranscodeProfile 是使用的档案名。
+	DownloadTranscodeMode    string `json:"downloadTranscodeMode"`
+	DownloadTranscodeProfile string `json:"downloadTranscodeProfile"`
+	// DisablePlayHistory 关闭播放历史记录；PlayHistoryRetentionDays 是播放历史
+	// 保留天数，0 表示一直保留。
+	DisablePlayHistory       bool `json:"disablePlayHistory"`
+	PlayHistoryRetentionDays int  `json:"playHistoryRetentionDays"`
+	// ReplayGainMode 控制播放时的响度均衡：""（关闭）或 track（按单曲增益）。
+	ReplayGainMode string `json:"replayGainMode"`
+	// CrossfadeSeconds 是网页播放器切歌时的淡入淡出秒数，0 表示不淡入淡出；
+	// GaplessPlayback 让网页播放器提前缓冲下一首，播完无缝接上。
+	CrossfadeSeconds int  `json:"crossfadeSeconds"`
+	GaplessPlayback  bool `json:"gaplessPlayback"`
 }
 
 type WebAuthSettings struct {
@@ -79,6 +111,19 @@ func configDBPath() string {
 	return ConfigDBFile
 }
 
+// PingConfigDB opens the settings database if needed and checks that it
+// still answers queries.
+func PingConfigDB() error {
+	if err := ensureConfigDB(); err != nil {
+		return err
+	}
+	sqlDB, err := configDB.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.Ping()
+}
+
 // ConfigDBPath returns the canonical SQLite file used by the app.
 func ConfigDBPath() string {
 	return configDBPath()
@@ -161,20 +206,23 @@ func migrateLegacyCookies() error {
 
 func defaultWebSettings() WebSettings {
 	return normalizeWebSettings(WebSettings{
-		EmbedDownload:            true,
-		DownloadToLocal:          false,
-		DownloadDir:              DefaultWebDownloadDir,
-		DownloadFilenameTemplate: DefaultDownloadFilenameTemplate,
-		DisableFloatingLyrics:    false,
-		WebPageSize:              DefaultWebPageSize,
-		CliPageSize:              DefaultCLIPageSize,
-		DownloadConcurrency:      DefaultWebConcurrency,
-		AutoCheckUpdate:          true,
-		AutoSwitchInvalidSources: true,
-		AutoCacheOnPlay:          false,
-		UpdateRepoURL:            DefaultUpdateRepoURL,
-		GithubProxyEnabled:       false,
-		GithubProxyURL:           DefaultGithubProxyURL,
+		EmbedDownload:                true,
+		DownloadToLocal:              false,
+		DownloadDir:                  DefaultWebDownloadDir,
+		DownloadFilenameTemplate:     DefaultDownloadFilenameTemplate,
+		DisableFloatingLyrics:        false,
+		WebPageSize:                  DefaultWebPageSize,
+		CliPageSize:                  DefaultCLIPageSize,
+		DownloadConcurrency:          DefaultWebConcurrency,
+		AutoCheckUpdate:              true,
+		AutoSwitchInvalidSources:     true,
+		AutoCacheOnPlay:              false,
+		UpdateRepoURL:                DefaultUpdateRepoURL,
+		GithubProxyEnabled:           false,
+		GithubProxyURL:               DefaultGithubProxyURL,
+		LocalMusicWatchMode:          LocalMusicWatchAuto,
+		LocalMusicSearchPinyin:       true,
+		LocalMusicSearchFoldVariants: true,
 	})
 }
 
@@ -217,9 +265,62 @@ func normalizeWebSettings(settings WebSettings) WebSettings {
 		settings.GithubProxyURL = DefaultGithubProxyURL
 	}
 	settings.DownloadDir = normalizeWebDownloadDir(settings.DownloadDir)
+	settings.SearchSources = normalizeSearchSources(settings.SearchSources)
+	settings.LocalMusicWatchMode = normalizeLocalMusicWatchMode(settings.LocalMusicWatchMode)
+	settings.LibraryRoots = normalizeLibraryRoots(settings.LibraryRoots, settings.DownloadDir)
+	settings.TranscodeProfiles = normalizeTranscodeProfiles(settings.TranscodeProfiles)
+	settings.DownloadTranscodeMode = normalizeTranscodeMode(settings.DownloadTranscodeMode)
+	settings.DownloadTranscodeProfile = strings.TrimSpace(settings.DownloadTranscodeProfile)
+	if settings.DownloadTranscodeProfile == "" {
+		settings.DownloadTranscodeProfile = BuiltinTranscodeProfiles[0].Name
+	}
+	if settings.PlayHistoryRetentionDays < 0 {
+		settings.PlayHistoryRetentionDays = 0
+	}
+	settings.ReplayGainMode = normalizeReplayGainMode(settings.ReplayGainMode)
+	settings.CrossfadeSeconds = min(max(settings.CrossfadeSeconds, 0), MaxCrossfadeSeconds)
 	return settings
 }
 
+// normalizeLocalMusicWatchMode maps unknown or empty modes to auto, which
+// prefers fsnotify and falls back to polling.
+func normalizeLocalMusicWatchMode(mode string) string {
+	mode = strings.ToLower(strings.TrimSpace(mode))
+	switch mode {
+	case LocalMusicWatchFSNotify, LocalMusicWatchPoll, LocalMusicWatchOff:
+		return mode
+	case "polling":
+		return LocalMusicWatchPoll
+	}
+	return LocalMusicWatchAuto
+}
+
+// normalizeSearchSources keeps only known sources in their first-seen order.
+// An empty result means "use the built-in default source list".
+func normalizeSearchSources(sources []string) []string {
+	if len(sources) == 0 {
+		return nil
+	}
+	known := make(map[string]bool)
+	for _, source := range GetAllSourceNames() {
+		known[source] = true
+	}
+	seen := make(map[string]bool, len(sources))
+	normalized := make([]string, 0, len(sources))
+	for _, source := range sources {
+		source = strings.TrimSpace(source)
+		if !known[source] || seen[source] {
+			continue
+		}
+		seen[source] = true
+		normalized = append(normalized, source)
+	}
+	if len(normalized) == 0 {
+		return nil
+	}
+	return normalized
+}
+
 func normalizeWebAuthSettings(settings WebAuthSettings) WebAuthSettings {
 	settings.Username = strings.TrimSpace(settings.Username)
 	if settings.Username == "" {
@@ -238,7 +339,17 @@ func normalizeWebDownloadDir(dir string) string {
 	return filepath.ToSlash(cleaned)
 }
 
+// GetWebSettings returns the effective settings: defaults, then the stored
+// values, then the config file and MUSIC_DL_* environment overrides.
 func GetWebSettings() WebSettings {
+	settings := storedWebSettings()
+	if patch := webSettingsOverrides().patch; patch != nil {
+		_ = json.Unmarshal(patch, &settings)
+	}
+	return normalizeWebSettings(settings)
+}
+
+func storedWebSettings() WebSettings {
 	settings := defaultWebSettings()
 	if err := ensureConfigDB(); err != nil {
 		return settings
@@ -258,24 +369,13 @@ func GetWebSettings() WebSettings {
 	return normalizeWebSettings(settings)
 }
 
+// SaveWebSettings persists settings. Fields pinned by the config file or
+// environment keep their previously stored value.
 func SaveWebSettings(settings WebSettings) error {
-	if err := ensureConfigDB(); err != nil {
-		return err
+	if pinned := webSettingsOverrides().origins; len(pinned) > 0 {
+		settings = keepPinnedWebSettings(settings, storedWebSettings(), pinned)
 	}
-
-	settings = normalizeWebSettings(settings)
-	data, err := json.Marshal(settings)
-	if err != nil {
-		return err
-	}
-
-	return configDB.Clauses(clause.OnConflict{
-		Columns:   []clause.Column{{Name: "key"}},
-		DoUpdates: clause.AssignmentColumns([]string{"value", "updated_at"}),
-	}).Create(&configKV{
-		Key:   webSettingsKey,
-		Value: string(data),
-	}).Error
+	return saveConfigValue(webSettingsKey, normalizeWebSettings(settings))
 }
 
 func GetWebAuthSettings() (WebAuthSettings, error) {
@@ -299,12 +399,33 @@ func GetWebAuthSettings() (WebAuthSettings, error) {
 }
 
 func SaveWebAuthSettings(settings WebAuthSettings) error {
+	return saveConfigValue(webAuthSettingsKey, normalizeWebAuthSettings(settings))
+}
+
+// loadConfigValue decodes one configKV row into dst. It reports false without
+// touching dst when the key has never been written.
+func loadConfigValue(key string, dst interface{}) (bool, error) {
+	if err := ensureConfigDB(); err != nil {
+		return false, err
+	}
+
+	var row configKV
+	if err := configDB.Where("key = ?", key).Limit(1).Find(&row).Error; err != nil {
+		return false, err
+	}
+	if row.Key == "" {
+		return false, nil
+	}
+	return true, json.Unmarshal([]byte(row.Value), dst)
+}
+
+// saveConfigValue stores value as JSON in the configKV row named key.
+func saveConfigValue(key string, value interface{}) error {
 	if err := ensureConfigDB(); err != nil {
 		return err
 	}
 
-	settings = normalizeWebAuthSettings(settings)
-	data, err := json.Marshal(settings)
+	data, err := json.Marshal(value)
 	if err != nil {
 		return err
 	}
@@ -313,7 +434,7 @@ func SaveWebAuthSettings(settings WebAuthSettings) error {
 		Columns:   []clause.Column{{Name: "key"}},
 		DoUpdates: clause.AssignmentColumns([]string{"value", "updated_at"}),
 	}).Create(&configKV{
-		Key:   webAuthSettingsKey,
+		Key:   key,
 		Value: string(data),
 	}).Error
 }
@@ -22,6 +22,9 @@ func resetConfigStateForTest() {
 	CM.mu.Lock()
 	CM.cookies = make(map[string]string)
 	CM.mu.Unlock()
+
+	invalidateWebhookTargets()
+	invalidateScrobbleTargets()
 }
 
 func TestCookieManagerMigratesLegacyJSONAndPersistsToSQLite(t *testing.T) {
@@ -140,6 +143,12 @@ func TestWebSettingsDefaultAndPersist(t *testing.T) {
 	if defaults.VgExportVideo {
 		t.Fatalf("default VgExportVideo should be false")
 	}
+	if !defaults.LocalMusicSearchPinyin || !defaults.LocalMusicSearchFoldVariants {
+		t.Fatalf("local music pinyin search and variant folding should default to true")
+	}
+	if defaults.DownloadTranscodeMode != TranscodeModeOff || defaults.DownloadTranscodeProfile != "mp3-320" {
+		t.Fatalf("download transcode should default to off with mp3-320: got %q / %q", defaults.DownloadTranscodeMode, defaults.DownloadTranscodeProfile)
+	}
 
 	if err := SaveWebSettings(WebSettings{
 		EmbedDownload:            true,
@@ -160,6 +169,10 @@ func TestWebSettingsDefaultAndPersist(t *testing.T) {
 		VgChangeAudio:            true,
 		VgChangeLyric:            true,
 		VgExportVideo:            true,
+		LocalMusicWatchMode:      " Polling ",
+		ReplayGainMode:           " Track ",
+		CrossfadeSeconds:         30,
+		GaplessPlayback:          true,
 	}); err != nil {
 		t.Fatalf("save web settings: %v", err)
 	}
@@ -184,6 +197,11 @@ func TestWebSettingsDefaultAndPersist(t *testing.T) {
 		VgChangeAudio:            true,
 		VgChangeLyric:            true,
 		VgExportVideo:            true,
+		LocalMusicWatchMode:      LocalMusicWatchPoll,
+		DownloadTranscodeProfile: BuiltinTranscodeProfiles[0].Name,
+		ReplayGainMode:           ReplayGainModeTrack,
+		CrossfadeSeconds:         MaxCrossfadeSeconds,
+		GaplessPlayback:          true,
 	}
 	if !reflect.DeepEqual(got, want) {
 		t.Fatalf("saved settings mismatch\ngot:  %#v\nwant: %#v", got, want)
@@ -233,6 +251,9 @@ func TestWebSettingsDefaultAndPersist(t *testing.T) {
 	if got.GithubProxyURL != DefaultGithubProxyURL {
 		t.Fatalf("custom save should fallback GithubProxyURL to default: got %q want %q", got.GithubProxyURL, DefaultGithubProxyURL)
 	}
+	if got.LocalMusicWatchMode != LocalMusicWatchAuto {
+		t.Fatalf("custom save should fallback LocalMusicWatchMode to auto: got %q", got.LocalMusicWatchMode)
+	}
 	if got.VgChangeCover || got.VgChangeAudio || got.VgChangeLyric || got.VgExportVideo {
 		t.Fatalf("custom save should fallback video generator settings to default false: %#v", got)
 	}
@@ -47,8 +47,11 @@ func DownloadSongDataWithTemplate(song *model.Song, withCover bool, withLyrics b
 
 	audioData, contentType, err := fetchSongAudio(&normalized)
 	if err != nil {
+		ObserveDownload(normalized.Source, DownloadStatusFailed)
 		return nil, err
 	}
+	ObserveDownload(normalized.Source, DownloadStatusSuccess)
+	ObserveDownloadBytes(normalized.Source, DownloadModeFile, int64(len(audioData)))
 
 	signatureExt := DetectAudioExtBySignature(audioData)
 	ext := signatureExt
@@ -62,14 +65,20 @@ func DownloadSongDataWithTemplate(song *model.Song, withCover bool, withLyrics b
 	var lyric string
 	if withLyrics {
 		if lyricFn := GetLyricFunc(normalized.Source); lyricFn != nil {
-			lyric, _ = lyricFn(&normalized)
+			var lyricErr error
+			if lyric, lyricErr = lyricFn(&normalized); lyricErr != nil {
+				Logger().Debug("fetch lyric failed", "source", normalized.Source, "song", normalized.Name, "error", lyricErr)
+			}
 		}
 	}
 
 	var coverData []byte
 	var coverMime string
 	if withCover && strings.TrimSpace(normalized.Cover) != "" {
-		coverData, coverMime, _ = FetchBytesWithMime(normalized.Cover, normalized.Source)
+		var coverErr error
+		if coverData, coverMime, coverErr = FetchBytesWithMime(normalized.Cover, normalized.Source); coverErr != nil {
+			Logger().Debug("fetch cover failed", "source", normalized.Source, "url", RedactURL(normalized.Cover), "error", coverErr)
+		}
 	}
 
 	finalData := audioData
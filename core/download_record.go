@@ -1,6 +1,8 @@
 package core
 
 import (
+	"context"
+	"fmt"
 	"strings"
 	"time"
 
@@ -23,6 +25,7 @@ type DownloadRecord struct {
 	Source    string    `gorm:"size:64;not null"`
 	Status    string    `gorm:"size:32;not null;index"`
 	Error     string    `gorm:"size:1024"`
+	RequestID string    `gorm:"size:64;index"`
 	CreatedAt time.Time `gorm:"autoCreateTime;index"`
 }
 
@@ -45,6 +48,12 @@ func initDownloadRecordTable() error {
 // SaveDownloadRecord persists one download outcome and records successful songs in
 // the durable de-duplication index. Control characters are removed before writing.
 func SaveDownloadRecord(name, artist, source, status, errStr string) error {
+	return SaveDownloadRecordContext(context.Background(), name, artist, source, status, errStr)
+}
+
+// SaveDownloadRecordContext is SaveDownloadRecord that also stores the request
+// ID carried by ctx, so a history entry can be matched with its log lines.
+func SaveDownloadRecordContext(ctx context.Context, name, artist, source, status, errStr string) error {
 	if err := initDownloadRecordTable(); err != nil {
 		return err
 	}
@@ -57,6 +66,8 @@ func SaveDownloadRecord(name, artist, source, status, errStr string) error {
 		Source: cleanDownloadRecordText(source),
 		Status: cleanDownloadRecordText(status),
 		Error:  cleanDownloadRecordText(errStr),
+		// 请求 ID 只用于关联日志，不参与去重。
+		RequestID: RequestIDFromContext(ctx),
 	}
 
 	return configDB.Transaction(func(tx *gorm.DB) error {
@@ -221,13 +232,29 @@ func CountSkippable(queue []model.Song, dedupSet map[string]struct{}) int {
 }
 
 func DownloadWithDedupCheck(song *model.Song, outDir string, withCover, withLyrics bool, dedupSet map[string]struct{}) (*DownloadedSong, error) {
-	return DownloadWithDedupCheckWithTemplate(song, outDir, withCover, withLyrics, "", dedupSet)
+	return DownloadWithDedupCheckContext(context.Background(), song, outDir, withCover, withLyrics, "", dedupSet)
 }
 
 func DownloadWithDedupCheckWithTemplate(song *model.Song, outDir string, withCover, withLyrics bool, filenameTemplate string, dedupSet map[string]struct{}) (*DownloadedSong, error) {
+	return DownloadWithDedupCheckContext(context.Background(), song, outDir, withCover, withLyrics, filenameTemplate, dedupSet)
+}
+
+// DownloadWithDedupCheckContext downloads song unless it is already in
+// dedupSet. The outcome is written to the download history tagged with the
+// request ID of ctx; failures to write the history are logged, not returned.
+func DownloadWithDedupCheckContext(ctx context.Context, song *model.Song, outDir string, withCover, withLyrics bool, filenameTemplate string, dedupSet map[string]struct{}) (*DownloadedSong, error) {
+	logger := LoggerFromContext(ctx).With("source", song.Source, "song", song.Name, "artist", song.Artist)
+	record := func(status, errStr string) {
+		if err := SaveDownloadRecordContext(ctx, song.Name, song.Artist, song.Source, status, errStr); err != nil {
+			logger.Warn("save download record failed", "status", status, "error", err)
+		}
+	}
+
 	key := SongKey(song)
 	if IsSongDownloaded(song, dedupSet) {
-		_ = SaveDownloadRecord(song.Name, song.Artist, song.Source, DownloadStatusSkipped, "")
+		record(DownloadStatusSkipped, "")
+		ObserveDownload(song.Source, DownloadStatusSkipped)
+		logger.Debug("download skipped, already in library")
 		return &DownloadedSong{Skipped: true, Filename: key}, nil
 	}
 
@@ -241,13 +268,86 @@ func DownloadWithDedupCheckWithTemplate(song *model.Song, outDir string, withCov
 		result, dlErr = SaveSongToFileWithTemplate(song, outDir, withCover, withLyrics, filenameTemplate)
 	}
 	if dlErr != nil {
-		_ = SaveDownloadRecord(song.Name, song.Artist, song.Source, DownloadStatusFailed, dlErr.Error())
+		record(DownloadStatusFailed, dlErr.Error())
+		logger.Warn("download failed", "error", dlErr)
+		Notify(ctx, downloadNotifyEvent(EventDownloadFailed, song, "", dlErr))
 		return result, dlErr
 	}
 
-	_ = SaveDownloadRecord(song.Name, song.Artist, song.Source, DownloadStatusSuccess, "")
+	record(DownloadStatusSuccess, "")
+	savedPath := ""
+	if result != nil {
+		savedPath = result.SavedPath
+		logger.Info("download finished", "path", savedPath)
+	}
+	Notify(ctx, downloadNotifyEvent(EventDownloadSuccess, song, savedPath, nil))
 	if dedupSet != nil {
 		dedupSet[key] = struct{}{}
 	}
 	return result, nil
 }
+
+func downloadNotifyEvent(eventType string, song *model.Song, savedPath string, err error) NotifyEvent {
+	label := song.Name
+	if song.Artist != "" {
+		label += " - " + song.Artist
+	}
+	event := NotifyEvent{
+		Type:    eventType,
+		Title:   "下载完成",
+		Message: label,
+		Data: map[string]any{
+			"name":   song.Name,
+			"artist": song.Artist,
+			"album":  song.Album,
+			"source": song.Source,
+		},
+	}
+	if savedPath != "" {
+		event.Data["path"] = savedPath
+	}
+	if err != nil {
+		event.Title = "下载失败"
+		event.Message = label + ": " + err.Error()
+		event.Data["error"] = err.Error()
+	}
+	return event
+}
+
+// BatchDownloadSummary describes a finished multi-song download.
+type BatchDownloadSummary struct {
+	Name     string   `json:"name,omitempty"`
+	Total    int      `json:"total"`
+	Success  int      `json:"success"`
+	Skipped  int      `json:"skipped"`
+	Failed   int      `json:"failed"`
+	Failures []string `json:"failures,omitempty"`
+}
+
+// NotifyBatchDownloadFinished raises download.batch_finished for a batch.
+func NotifyBatchDownloadFinished(ctx context.Context, summary BatchDownloadSummary) {
+	title := "批量下载完成"
+	if summary.Failed > 0 {
+		title = "批量下载完成（部分失败）"
+	}
+	message := fmt.Sprintf("共 %d 首：成功 %d，跳过 %d，失败 %d", summary.Total, summary.Success, summary.Skipped, summary.Failed)
+	if summary.Name != "" {
+		message = summary.Name + "：" + message
+	}
+	if len(summary.Failures) > 0 {
+		message += "\n失败：" + strings.Join(summary.Failures, "；")
+	}
+	Notify(ctx, NotifyEvent{
+		Type:    EventDownloadBatchFinished,
+		Title:   title,
+		Message: message,
+		Data: map[string]any{
+			"name":     summary.Name,
+			"total":    summary.Total,
+			"success":  summary.Success,
+			"skipped":  summary.Skipped,
+			"failed":   summary.Failed,
+			"failures": summary.Failures,
+		},
+	})
+}
@@ -0,0 +1,337 @@
+package core
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"math/bits"
+	"math/cmplx"
+	"os/exec"
+	"strings"
+)
+
+// Audio fingerprints follow Chromaprint's default algorithm: 11025 Hz mono
+// PCM, 4096-sample Hamming frames with 2/3 overlap, a 12-band chroma
+// (28-3520 Hz) smoothed over five frames, and 16 Haar-like classifiers over
+// the chroma image. Each 32-bit item covers about 0.124 s of audio.
+const (
+	FingerprintSampleRate = 11025
+	// FingerprintMaxSeconds is how much of the start of a file is decoded.
+	FingerprintMaxSeconds = 120
+
+	fingerprintFrameSize = 4096
+	fingerprintFrameStep = fingerprintFrameSize / 3
+	fingerprintMinFreq   = 28
+	fingerprintMaxFreq   = 3520
+	fingerprintBands     = 12
+	// fingerprintMaxOffset bounds the alignment search (about 10 s).
+	fingerprintMaxOffset = 80
+)
+
+var ErrFingerprintTooShort = errors.New("audio too short to fingerprint")
+
+type fingerprintFilter struct {
+	kind, y, height, width int
+}
+
+type fingerprintClassifier struct {
+	filter     fingerprintFilter
+	thresholds [3]float64
+}
+
+var fingerprintClassifiers = [16]fingerprintClassifier{
+	{fingerprintFilter{0, 4, 3, 15}, [3]float64{1.98215, 2.35817, 2.63523}},
+	{fingerprintFilter{4, 4, 6, 15}, [3]float64{-1.03809, -0.651211, -0.282167}},
+	{fingerprintFilter{1, 0, 4, 16}, [3]float64{-0.298702, 0.119262, 0.558497}},
+	{fingerprintFilter{3, 8, 2, 12}, [3]float64{-0.105439, 0.0153946, 0.135898}},
+	{fingerprintFilter{3, 4, 4, 8}, [3]float64{-0.142891, 0.0258736, 0.200632}},
+	{fingerprintFilter{4, 0, 3, 5}, [3]float64{-0.826319, -0.590612, -0.368214}},
+	{fingerprintFilter{1, 2, 2, 9}, [3]float64{-0.557409, -0.233035, 0.0534525}},
+	{fingerprintFilter{2, 7, 3, 4}, [3]float64{-0.0646826, 0.00620476, 0.0784847}},
+	{fingerprintFilter{2, 6, 2, 16}, [3]float64{-0.192387, -0.029699, 0.215855}},
+	{fingerprintFilter{2, 1, 3, 2}, [3]float64{-0.0397818, -0.00568076, 0.0292026}},
+	{fingerprintFilter{5, 10, 1, 15}, [3]float64{-0.53823, -0.369934, -0.190235}},
+	{fingerprintFilter{3, 6, 2, 10}, [3]float64{-0.124877, 0.0296483, 0.139239}},
+	{fingerprintFilter{2, 1, 1, 14}, [3]float64{-0.101475, 0.0225617, 0.231971}},
+	{fingerprintFilter{3, 5, 6, 4}, [3]float64{-0.0799915, -0.00729616, 0.063262}},
+	{fingerprintFilter{1, 9, 2, 12}, [3]float64{-0.272556, 0.019424, 0.302559}},
+	{fingerprintFilter{3, 4, 2, 14}, [3]float64{-0.164292, -0.0321188, 0.0846339}},
+}
+
+var fingerprintChromaFilter = [5]float64{0.25, 0.75, 1.0, 0.75, 0.25}
+
+var fingerprintGrayCode = [4]uint32{0, 1, 3, 2}
+
+// FingerprintAudioFile decodes up to FingerprintMaxSeconds of path through
+// ffmpeg and fingerprints it.
+func FingerprintAudioFile(path string) ([]uint32, error) {
+	ffmpegPath, err := ResolveFFmpegPath()
+	if err != nil {
+		return nil, ErrFFmpegNotFound
+	}
+	cmd := exec.Command(ffmpegPath, "-hide_banner", "-loglevel", "error",
+		"-i", path, "-t", fmt.Sprint(FingerprintMaxSeconds),
+		"-vn", "-ac", "1", "-ar", fmt.Sprint(FingerprintSampleRate), "-f", "s16le", "-")
+	HideCommandWindow(cmd)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("ffmpeg decode failed: %v, output: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	samples := make([]int16, len(out)/2)
+	for i := range samples {
+		samples[i] = int16(binary.LittleEndian.Uint16(out[i*2:]))
+	}
+	return ComputeFingerprint(samples)
+}
+
+// ComputeFingerprint fingerprints mono PCM sampled at FingerprintSampleRate.
+func ComputeFingerprint(samples []int16) ([]uint32, error) {
+	if max := FingerprintMaxSeconds * FingerprintSampleRate; len(samples) > max {
+		samples = samples[:max]
+	}
+	chroma := fingerprintChroma(samples)
+	image := smoothFingerprintChroma(chroma)
+	const maxWidth = 16
+	if len(image) < maxWidth {
+		return nil, ErrFingerprintTooShort
+	}
+	integral := newFingerprintIntegral(image)
+	items := make([]uint32, 0, len(image)-maxWidth+1)
+	for offset := 0; offset <= len(image)-maxWidth; offset++ {
+		var item uint32
+		for _, classifier := range fingerprintClassifiers {
+			item = item<<2 | fingerprintGrayCode[classifier.apply(integral, offset)]
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// fingerprintChroma returns the raw 12-band chroma of every frame.
+func fingerprintChroma(samples []int16) [][fingerprintBands]float64 {
+	if len(samples) < fingerprintFrameSize {
+		return nil
+	}
+	window := make([]float64, fingerprintFrameSize)
+	for i := range window {
+		window[i] = 0.54 - 0.46*math.Cos(2*math.Pi*float64(i)/float64(fingerprintFrameSize-1))
+	}
+	freqIndex := func(freq float64) int {
+		return int(math.Round(fingerprintFrameSize * freq / FingerprintSampleRate))
+	}
+	minIndex := freqIndex(fingerprintMinFreq)
+	if minIndex < 1 {
+		minIndex = 1
+	}
+	maxIndex := freqIndex(fingerprintMaxFreq)
+	if maxIndex > fingerprintFrameSize/2 {
+		maxIndex = fingerprintFrameSize / 2
+	}
+	notes := make([]int, maxIndex)
+	for i := minIndex; i < maxIndex; i++ {
+		freq := float64(i) * FingerprintSampleRate / fingerprintFrameSize
+		octave := math.Log2(freq / (440.0 / 16.0))
+		notes[i] = int(fingerprintBands * (octave - math.Floor(octave)))
+	}
+
+	frames := make([][fingerprintBands]float64, 0, (len(samples)-fingerprintFrameSize)/fingerprintFrameStep+1)
+	buf := make([]complex128, fingerprintFrameSize)
+	for start := 0; start+fingerprintFrameSize <= len(samples); start += fingerprintFrameStep {
+		for i := range buf {
+			buf[i] = complex(float64(samples[start+i])*window[i], 0)
+		}
+		fftInPlace(buf)
+		var bands [fingerprintBands]float64
+		for i := minIndex; i < maxIndex; i++ {
+			power := real(buf[i])*real(buf[i]) + imag(buf[i])*imag(buf[i])
+			bands[notes[i]] += power
+		}
+		frames = append(frames, bands)
+	}
+	return frames
+}
+
+// smoothFingerprintChroma applies the five-frame chroma filter and
+// normalises each row to unit length; near-silent rows become zero.
+func smoothFingerprintChroma(chroma [][fingerprintBands]float64) [][fingerprintBands]float64 {
+	taps := len(fingerprintChromaFilter)
+	if len(chroma) < taps {
+		return nil
+	}
+	out := make([][fingerprintBands]float64, 0, len(chroma)-taps+1)
+	for t := 0; t+taps <= len(chroma); t++ {
+		var row [fingerprintBands]float64
+		for k, coef := range fingerprintChromaFilter {
+			for b := range row {
+				row[b] += coef * chroma[t+k][b]
+			}
+		}
+		var norm float64
+		for _, v := range row {
+			norm += v * v
+		}
+		norm = math.Sqrt(norm)
+		if norm < 0.01 {
+			row = [fingerprintBands]float64{}
+		} else {
+			for b := range row {
+				row[b] /= norm
+			}
+		}
+		out = append(out, row)
+	}
+	return out
+}
+
+type fingerprintIntegral [][fingerprintBands + 1]float64
+
+func newFingerprintIntegral(image [][fingerprintBands]float64) fingerprintIntegral {
+	integral := make(fingerprintIntegral, len(image)+1)
+	for r, row := range image {
+		for c, v := range row {
+			integral[r+1][c+1] = v + integral[r][c+1] + integral[r+1][c] - integral[r][c]
+		}
+	}
+	return integral
+}
+
+// area sums rows [r1, r2) and bands [c1, c2).
+func (im fingerprintIntegral) area(r1, c1, r2, c2 int) float64 {
+	return im[r2][c2] - im[r1][c2] - im[r2][c1] + im[r1][c1]
+}
+
+func (c fingerprintClassifier) apply(im fingerprintIntegral, x int) int {
+	f := c.filter
+	y, w, h := f.y, f.width, f.height
+	var a, b float64
+	switch f.kind {
+	case 0:
+		a = im.area(x, y, x+w, y+h)
+	case 1:
+		h2 := h / 2
+		a = im.area(x, y+h2, x+w, y+h)
+		b = im.area(x, y, x+w, y+h2)
+	case 2:
+		w2 := w / 2
+		a = im.area(x+w2, y, x+w, y+h)
+		b = im.area(x, y, x+w2, y+h)
+	case 3:
+		w2, h2 := w/2, h/2
+		a = im.area(x, y+h2, x+w2, y+h) + im.area(x+w2, y, x+w, y+h2)
+		b = im.area(x, y, x+w2, y+h2) + im.area(x+w2, y+h2, x+w, y+h)
+	case 4:
+		h3 := h / 3
+		a = im.area(x, y+h3, x+w, y+2*h3)
+		b = im.area(x, y, x+w, y+h3) + im.area(x, y+2*h3, x+w, y+h)
+	case 5:
+		w3 := w / 3
+		a = im.area(x+w3, y, x+2*w3, y+h)
+		b = im.area(x, y, x+w3, y+h) + im.area(x+2*w3, y, x+w, y+h)
+	}
+	value := math.Log(1+a) - math.Log(1+b)
+	switch {
+	case value < c.thresholds[0]:
+		return 0
+	case value < c.thresholds[1]:
+		return 1
+	case value < c.thresholds[2]:
+		return 2
+	default:
+		return 3
+	}
+}
+
+// fftInPlace is an iterative radix-2 FFT; len(buf) must be a power of two.
+func fftInPlace(buf []complex128) {
+	n := len(buf)
+	for i, j := 1, 0; i < n; i++ {
+		bit := n >> 1
+		for ; j&bit != 0; bit >>= 1 {
+			j ^= bit
+		}
+		j ^= bit
+		if i < j {
+			buf[i], buf[j] = buf[j], buf[i]
+		}
+	}
+	for size := 2; size <= n; size <<= 1 {
+		step := cmplx.Exp(complex(0, -2*math.Pi/float64(size)))
+		for start := 0; start < n; start += size {
+			w := complex(1, 0)
+			for k := 0; k < size/2; k++ {
+				u := buf[start+k]
+				v := buf[start+k+size/2] * w
+				buf[start+k] = u + v
+				buf[start+k+size/2] = u - v
+				w *= step
+			}
+		}
+	}
+}
+
+// FingerprintSimilarity returns 1 minus the bit error rate of a and b at
+// their best alignment within about ten seconds, in [0, 1]. Alignments that
+// overlap less than half of the shorter fingerprint are ignored.
+func FingerprintSimilarity(a, b []uint32) float64 {
+	if len(a) == 0 || len(b) == 0 {
+		return 0
+	}
+	minOverlap := len(a)
+	if len(b) < minOverlap {
+		minOverlap = len(b)
+	}
+	minOverlap = (minOverlap + 1) / 2
+	best := 0.0
+	for offset := -fingerprintMaxOffset; offset <= fingerprintMaxOffset; offset++ {
+		ai, bi := 0, 0
+		if offset > 0 {
+			ai = offset
+		} else {
+			bi = -offset
+		}
+		n := len(a) - ai
+		if len(b)-bi < n {
+			n = len(b) - bi
+		}
+		if n < minOverlap {
+			continue
+		}
+		errs := 0
+		for i := 0; i < n; i++ {
+			errs += bits.OnesCount32(a[ai+i] ^ b[bi+i])
+		}
+		if score := 1 - float64(errs)/float64(32*n); score > best {
+			best = score
+		}
+	}
+	return best
+}
+
+// EncodeFingerprint packs items as base64 of little-endian uint32s.
+func EncodeFingerprint(items []uint32) string {
+	raw := make([]byte, 4*len(items))
+	for i, item := range items {
+		binary.LittleEndian.PutUint32(raw[i*4:], item)
+	}
+	return base64.StdEncoding.EncodeToString(raw)
+}
+
+func DecodeFingerprint(encoded string) ([]uint32, error) {
+	raw, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+	if len(raw)%4 != 0 {
+		return nil, errors.New("invalid fingerprint length")
+	}
+	items := make([]uint32, len(raw)/4)
+	for i := range items {
+		items[i] = binary.LittleEndian.Uint32(raw[i*4:])
+	}
+	return items, nil
+}
@@ -0,0 +1,110 @@
+package core
+
+import (
+	"math"
+	"math/rand"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// synthMelody renders a tone per note (in Hz) with a couple of harmonics.
+func synthMelody(notes []float64, noteSeconds float64, gain float64, noise float64, seed int64) []int16 {
+	rng := rand.New(rand.NewSource(seed))
+	perNote := int(noteSeconds * FingerprintSampleRate)
+	samples := make([]int16, 0, perNote*len(notes))
+	for _, freq := range notes {
+		for i := 0; i < perNote; i++ {
+			t := float64(i) / FingerprintSampleRate
+			v := math.Sin(2*math.Pi*freq*t) + 0.5*math.Sin(4*math.Pi*freq*t) + 0.25*math.Sin(6*math.Pi*freq*t)
+			v = v*gain*8000 + rng.NormFloat64()*noise
+			samples = append(samples, int16(math.Max(-32768, math.Min(32767, v))))
+		}
+	}
+	return samples
+}
+
+func melodyNotes(seed int64, count int) []float64 {
+	rng := rand.New(rand.NewSource(seed))
+	notes := make([]float64, count)
+	for i := range notes {
+		notes[i] = 220 * math.Pow(2, float64(rng.Intn(24))/12)
+	}
+	return notes
+}
+
+func TestFingerprintSimilarity(t *testing.T) {
+	notes := melodyNotes(1, 40)
+	original, err := ComputeFingerprint(synthMelody(notes, 0.5, 1, 0, 1))
+	if err != nil {
+		t.Fatalf("ComputeFingerprint() error = %v", err)
+	}
+	if got := FingerprintSimilarity(original, original); got != 1 {
+		t.Fatalf("self similarity = %v, want 1", got)
+	}
+
+	// Quieter, noisier and starting a little later: still the same recording.
+	reencoded := synthMelody(notes, 0.5, 0.6, 600, 2)[3000:]
+	copyPrint, err := ComputeFingerprint(reencoded)
+	if err != nil {
+		t.Fatalf("ComputeFingerprint(copy) error = %v", err)
+	}
+	same := FingerprintSimilarity(original, copyPrint)
+
+	other, err := ComputeFingerprint(synthMelody(melodyNotes(7, 40), 0.5, 1, 0, 1))
+	if err != nil {
+		t.Fatalf("ComputeFingerprint(other) error = %v", err)
+	}
+	different := FingerprintSimilarity(original, other)
+	if same < 0.85 || different > 0.7 {
+		t.Fatalf("similarity same=%.3f different=%.3f", same, different)
+	}
+}
+
+func TestComputeFingerprintTooShort(t *testing.T) {
+	if _, err := ComputeFingerprint(make([]int16, FingerprintSampleRate)); err != ErrFingerprintTooShort {
+		t.Fatalf("ComputeFingerprint(1s) error = %v, want ErrFingerprintTooShort", err)
+	}
+}
+
+func TestFingerprintEncoding(t *testing.T) {
+	items := []uint32{0, 1, 0xdeadbeef, math.MaxUint32}
+	decoded, err := DecodeFingerprint(EncodeFingerprint(items))
+	if err != nil || !reflect.DeepEqual(decoded, items) {
+		t.Fatalf("round trip = %v, %v", decoded, err)
+	}
+	if _, err := DecodeFingerprint("AAA="); err == nil {
+		t.Fatal("truncated fingerprint should fail to decode")
+	}
+}
+
+func TestFingerprintAudioFileByFFmpeg(t *testing.T) {
+	if _, err := exec.LookPath("ffmpeg"); err != nil {
+		t.Skip("ffmpeg not available")
+	}
+	dir := t.TempDir()
+	wav := filepath.Join(dir, "tone.wav")
+	mp3 := filepath.Join(dir, "tone.mp3")
+	src := "sine=frequency=440:duration=6,volume=0.5"
+	for _, args := range [][]string{
+		{"-f", "lavfi", "-i", src, wav},
+		{"-i", wav, "-b:a", "96k", mp3},
+	} {
+		cmd := exec.Command("ffmpeg", append([]string{"-y", "-hide_banner", "-loglevel", "error"}, args...)...)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Skipf("ffmpeg encode failed: %v, output: %s", err, string(out))
+		}
+	}
+	a, err := FingerprintAudioFile(wav)
+	if err != nil {
+		t.Fatalf("FingerprintAudioFile(wav) error = %v", err)
+	}
+	b, err := FingerprintAudioFile(mp3)
+	if err != nil {
+		t.Fatalf("FingerprintAudioFile(mp3) error = %v", err)
+	}
+	if got := FingerprintSimilarity(a, b); got < 0.9 {
+		t.Fatalf("wav/mp3 similarity = %.3f", got)
+	}
+}
@@ -0,0 +1,100 @@
+package core
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// DownloadLibraryRootKey is the key of the implicit library root backed by
+// WebSettings.DownloadDir. Its track IDs keep the historical format.
+const DownloadLibraryRootKey = ""
+
+// LibraryRoot is an additional local music directory. Read-only roots are
+// indexed and searchable, but uploads, deletes and tag edits never touch them.
+type LibraryRoot struct {
+	// Key is the stable identifier embedded in track IDs. It is derived from
+	// the path when empty, so renaming the label does not change IDs.
+	Key      string   `json:"key"`
+	Label    string   `json:"label"`
+	Path     string   `json:"path"`
+	ReadOnly bool     `json:"readOnly"`
+	Include  []string `json:"include,omitempty"`
+	Exclude  []string `json:"exclude,omitempty"`
+}
+
+// normalizeLibraryRoots drops empty and duplicate paths (including the
+// download directory itself), trims globs and assigns unique keys.
+func normalizeLibraryRoots(roots []LibraryRoot, downloadDir string) []LibraryRoot {
+	if len(roots) == 0 {
+		return nil
+	}
+	seenPaths := map[string]bool{filepath.Clean(downloadDir): true}
+	seenKeys := make(map[string]bool, len(roots))
+	normalized := make([]LibraryRoot, 0, len(roots))
+	for _, root := range roots {
+		root.Path = strings.TrimSpace(root.Path)
+		if root.Path == "" {
+			continue
+		}
+		root.Path = filepath.Clean(root.Path)
+		if seenPaths[root.Path] {
+			continue
+		}
+		seenPaths[root.Path] = true
+
+		root.Label = strings.TrimSpace(root.Label)
+		if root.Label == "" {
+			root.Label = filepath.Base(root.Path)
+		}
+		root.Include = normalizeLibraryGlobs(root.Include)
+		root.Exclude = normalizeLibraryGlobs(root.Exclude)
+
+		key := libraryRootKey(root.Key)
+		if key == "" {
+			sum := sha1.Sum([]byte(filepath.ToSlash(root.Path)))
+			key = "lib-" + hex.EncodeToString(sum[:4])
+		}
+		for base, i := key, 2; seenKeys[key]; i++ {
+			key = base + "-" + strconv.Itoa(i)
+		}
+		seenKeys[key] = true
+		root.Key = key
+		normalized = append(normalized, root)
+	}
+	if len(normalized) == 0 {
+		return nil
+	}
+	return normalized
+}
+
+// libraryRootKey keeps lowercase letters, digits, '-' and '_' so keys are safe
+// inside IDs and URLs.
+func libraryRootKey(key string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(strings.TrimSpace(key)) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || r == '-' || r == '_' {
+			b.WriteRune(r)
+		}
+	}
+	if b.Len() > 32 {
+		return b.String()[:32]
+	}
+	return b.String()
+}
+
+func normalizeLibraryGlobs(globs []string) []string {
+	out := make([]string, 0, len(globs))
+	for _, glob := range globs {
+		glob = strings.Trim(filepath.ToSlash(strings.TrimSpace(glob)), "/")
+		if glob != "" {
+			out = append(out, glob)
+		}
+	}
+	if len(out) == 0 {
+		return nil
+	}
+	return out
+}
@@ -0,0 +1,44 @@
+package core
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestNormalizeLibraryRoots(t *testing.T) {
+	download := filepath.Clean("/music/downloads")
+	got := normalizeLibraryRoots([]LibraryRoot{
+		{Path: " /music/downloads/ "},
+		{Key: " NAS Share! ", Path: "/mnt/nas/music", Include: []string{" *.flac ", "", "/Albums/"}},
+		{Key: "nas", Path: "/mnt/nas/other"},
+		{Path: "/mnt/nas/music"},
+		{Path: "  "},
+		{Path: "/disk2/archive", ReadOnly: true},
+	}, download)
+
+	if len(got) != 3 {
+		t.Fatalf("roots = %+v, want 3", got)
+	}
+	if got[0].Key != "nasshare" || got[0].Label != "music" {
+		t.Fatalf("first root = %+v", got[0])
+	}
+	if !reflect.DeepEqual(got[0].Include, []string{"*.flac", "Albums"}) || got[0].Exclude != nil {
+		t.Fatalf("globs = %#v / %#v", got[0].Include, got[0].Exclude)
+	}
+	if got[1].Key != "nas" {
+		t.Fatalf("second key = %q, want nas", got[1].Key)
+	}
+	if len(got[2].Key) != len("lib-")+8 || got[2].Label != "archive" || !got[2].ReadOnly {
+		t.Fatalf("derived root = %+v", got[2])
+	}
+	again := normalizeLibraryRoots([]LibraryRoot{{Path: "/disk2/archive"}}, download)
+	if again[0].Key != got[2].Key {
+		t.Fatalf("derived key is not stable: %q vs %q", again[0].Key, got[2].Key)
+	}
+
+	clash := normalizeLibraryRoots([]LibraryRoot{{Key: "a", Path: "/a"}, {Key: "A", Path: "/b"}, {Key: "a", Path: "/c"}}, download)
+	if clash[0].Key != "a" || clash[1].Key != "a-2" || clash[2].Key != "a-3" {
+		t.Fatalf("clashing keys = %q, %q, %q", clash[0].Key, clash[1].Key, clash[2].Key)
+	}
+}
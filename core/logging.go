@@ -0,0 +1,290 @@
+package core
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	LogLevelEnv  = "MUSIC_DL_LOG_LEVEL"
+	LogFormatEnv = "MUSIC_DL_LOG_FORMAT"
+	LogFileEnv   = "MUSIC_DL_LOG_FILE"
+
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
+// LogOptions configures the process-wide logger. Empty fields fall back to the
+// MUSIC_DL_LOG_* environment variables, then to info level text on stderr.
+type LogOptions struct {
+	Level  string
+	Format string
+	// File, when set, receives the log instead of Output.
+	File   string
+	Output io.Writer
+}
+
+var (
+	currentLogger atomic.Pointer[slog.Logger]
+	logLevel      = new(slog.LevelVar)
+
+	logFileMu sync.Mutex
+	logFile   *os.File
+
+	debugTransportOnce sync.Once
+)
+
+func init() {
+	currentLogger.Store(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: logLevel})))
+}
+
+// Logger returns the process-wide structured logger.
+func Logger() *slog.Logger {
+	return currentLogger.Load()
+}
+
+// ParseLogLevel accepts debug, info, warn/warning and error (case-insensitive).
+func ParseLogLevel(value string) (slog.Level, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "", "info":
+		return slog.LevelInfo, nil
+	case "debug":
+		return slog.LevelDebug, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return slog.LevelInfo, fmt.Errorf("invalid log level %q (want debug, info, warn or error)", value)
+}
+
+// ConfigureLogging replaces the process-wide logger. It also routes the
+// standard library log package through slog so stray log.Printf calls share
+// the same format and destination.
+func ConfigureLogging(opts LogOptions) error {
+	level := firstNonEmpty(opts.Level, os.Getenv(LogLevelEnv))
+	format := strings.ToLower(strings.TrimSpace(firstNonEmpty(opts.Format, os.Getenv(LogFormatEnv))))
+	path := firstNonEmpty(opts.File, os.Getenv(LogFileEnv))
+
+	parsed, err := ParseLogLevel(level)
+	if err != nil {
+		return err
+	}
+	if format == "" {
+		format = LogFormatText
+	}
+	if format != LogFormatText && format != LogFormatJSON {
+		return fmt.Errorf("invalid log format %q (want text or json)", format)
+	}
+
+	out := opts.Output
+	if out == nil {
+		out = os.Stderr
+	}
+	if path != "" {
+		file, err := openLogFile(path)
+		if err != nil {
+			return err
+		}
+		out = file
+	}
+
+	handlerOpts := &slog.HandlerOptions{Level: logLevel}
+	var handler slog.Handler
+	if format == LogFormatJSON {
+		handler = slog.NewJSONHandler(out, handlerOpts)
+	} else {
+		handler = slog.NewTextHandler(out, handlerOpts)
+	}
+
+	logLevel.Set(parsed)
+	logger := slog.New(handler)
+	currentLogger.Store(logger)
+	slog.SetDefault(logger)
+	log.SetFlags(0)
+
+	if parsed <= slog.LevelDebug {
+		installDebugTransport()
+	}
+	return nil
+}
+
+// DebugEnabled reports whether debug logging (including upstream URLs) is on.
+func DebugEnabled() bool {
+	return logLevel.Level() <= slog.LevelDebug
+}
+
+func openLogFile(path string) (*os.File, error) {
+	if dir := filepath.Dir(path); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	logFileMu.Lock()
+	previous := logFile
+	logFile = file
+	logFileMu.Unlock()
+	if previous != nil {
+		_ = previous.Close()
+	}
+	return file, nil
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if strings.TrimSpace(value) != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+type requestIDKey struct{}
+
+// NewRequestID returns a short random identifier for correlating log lines.
+func NewRequestID() string {
+	var buf [8]byte
+	if _, err := rand.Read(buf[:]); err != nil {
+		return fmt.Sprintf("%016x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(buf[:])
+}
+
+// WithRequestID attaches a request ID to ctx.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestIDFromContext returns the request ID attached to ctx, if any.
+func RequestIDFromContext(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// LoggerFromContext returns the logger annotated with the request ID of ctx.
+func LoggerFromContext(ctx context.Context) *slog.Logger {
+	if id := RequestIDFromContext(ctx); id != "" {
+		return Logger().With("request_id", id)
+	}
+	return Logger()
+}
+
+// sensitiveQueryKeys are query parameters that carry credentials or signed
+// tokens. Their values are dropped from debug logs. A parameter matches when
+// its whole name, or one of its parts split on '_' or '-', is in the list, so
+// access_token is caught but keyword and author are not.
+var sensitiveQueryKeys = map[string]bool{
+	"cookie": true, "token": true, "sign": true, "signature": true, "key": true,
+	"apikey": true, "auth": true, "authorization": true, "session": true,
+	"sessionid": true, "ticket": true, "secret": true, "password": true,
+	"uin": true, "musickey": true, "vkey": true, "skey": true, "csrf": true,
+}
+
+func isSensitiveQueryKey(name string) bool {
+	for _, part := range strings.FieldsFunc(strings.ToLower(name), func(r rune) bool { return r == '_' || r == '-' }) {
+		if sensitiveQueryKeys[part] {
+			return true
+		}
+	}
+	return false
+}
+
+// RedactURL strips user info and credential-like query values from raw so it
+// can be written to a bug report. Unparseable input is returned as a marker.
+func RedactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "<unparseable url>"
+	}
+	if u.User != nil {
+		u.User = url.User("redacted")
+	}
+	if u.RawQuery == "" {
+		return u.String()
+	}
+	query := u.Query()
+	for name := range query {
+		if isSensitiveQueryKey(name) {
+			query[name] = []string{"redacted"}
+		}
+	}
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+// redactedCookie describes a Cookie header without revealing its content.
+func redactedCookie(value string) string {
+	if value == "" {
+		return ""
+	}
+	return fmt.Sprintf("<redacted %d bytes>", len(value))
+}
+
+// debugTransport logs every outgoing HTTP request when debug logging is on.
+// Cookies are never written, only their presence and length.
+type debugTransport struct {
+	base http.RoundTripper
+}
+
+func (t *debugTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !DebugEnabled() {
+		return t.base.RoundTrip(req)
+	}
+	start := time.Now()
+	resp, err := t.base.RoundTrip(req)
+	attrs := []any{
+		"method", req.Method,
+		"url", RedactURL(req.URL.String()),
+		"duration_ms", time.Since(start).Milliseconds(),
+	}
+	if cookie := req.Header.Get("Cookie"); cookie != "" {
+		attrs = append(attrs, "cookie", redactedCookie(cookie))
+	}
+	if rangeHeader := req.Header.Get("Range"); rangeHeader != "" {
+		attrs = append(attrs, "range", rangeHeader)
+	}
+	logger := LoggerFromContext(req.Context())
+	if err != nil {
+		logger.Debug("upstream request failed", append(attrs, "error", err)...)
+		return resp, err
+	}
+	logger.Debug("upstream request", append(attrs, "status", resp.StatusCode)...)
+	return resp, nil
+}
+
+// installDebugTransport wraps http.DefaultTransport once. The wrapper checks
+// the level on every request, so lowering the level later silences it.
+//
+// Only clients that leave Transport nil go through it. Our own clients and
+// music-lib's request helpers do; a source that builds its own http.Transport
+// would bypass the wrapper, which TestDebugTransportCoversMusicLibRequests
+// guards against.
+func installDebugTransport() {
+	debugTransportOnce.Do(func() {
+		http.DefaultTransport = &debugTransport{base: http.DefaultTransport}
+	})
+}
@@ -0,0 +1,276 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"os/exec"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/guohuiyuan/music-lib/model"
+)
+
+// ReplayGain modes (WebSettings.ReplayGainMode). "track" applies each track's
+// own gain; tracks that were never analysed play unchanged in the web player.
+const (
+	ReplayGainModeOff   = ""
+	ReplayGainModeTrack = "track"
+)
+
+const (
+	// ReplayGainReferenceLUFS is the ReplayGain 2.0 target loudness.
+	ReplayGainReferenceLUFS = -18.0
+	// MaxCrossfadeSeconds bounds WebSettings.CrossfadeSeconds.
+	MaxCrossfadeSeconds = 12
+
+	// Song.Extra keys carrying a track's ReplayGain, as written by the local
+	// library for analysed files.
+	ExtraReplayGainTrackGain = "replaygain_track_gain"
+	ExtraReplayGainTrackPeak = "replaygain_track_peak"
+
+	// loudnessSilenceLUFS is the gate ebur128 reports for silent input.
+	loudnessSilenceLUFS = -70.0
+	// replayGainStreamFilter normalises streams without analysis on the fly
+	// (single-pass loudnorm), resampled back because loudnorm outputs 192 kHz.
+	replayGainStreamFilter = "loudnorm=I=-18:TP=-1.5:LRA=11,aresample=48000"
+)
+
+var (
+	ErrLoudnessSilent   = errors.New("audio is silent")
+	ErrLoudnessNoResult = errors.New("ebur128 summary not found")
+
+	// ReplayGainWritableExts lists the formats WriteReplayGainTags can write:
+	// mp3 as ID3v2.3 TXXX frames, flac as Vorbis comments through ffmpeg.
+	ReplayGainWritableExts = []string{"mp3", "flac"}
+)
+
+// Loudness is an EBU R128 measurement of one file.
+type Loudness struct {
+	// Integrated 是整体响度（LUFS），Range 是响度范围（LU），TruePeak 是真峰值（dBTP）。
+	Integrated float64 `json:"integrated"`
+	Range      float64 `json:"range"`
+	TruePeak   float64 `json:"true_peak"`
+}
+
+// TrackGain is the ReplayGain 2.0 track gain in dB.
+func (l Loudness) TrackGain() float64 {
+	return math.Round((ReplayGainReferenceLUFS-l.Integrated)*100) / 100
+}
+
+// PeakRatio is the true peak as a linear sample ratio (1 = full scale).
+func (l Loudness) PeakRatio() float64 {
+	return math.Round(math.Pow(10, l.TruePeak/20)*1e6) / 1e6
+}
+
+// ReplayGainAdjustment returns the gain to apply, lowered so the peak does not
+// clip. peak is linear; 0 means unknown.
+func ReplayGainAdjustment(gain, peak float64) float64 {
+	if peak > 0 {
+		if limit := -20 * math.Log10(peak); gain > limit {
+			gain = limit
+		}
+	}
+	return math.Round(gain*100) / 100
+}
+
+// AnalyzeLoudness measures path with ffmpeg's ebur128 filter.
+func AnalyzeLoudness(path string) (Loudness, error) {
+	ffmpegPath, err := ResolveFFmpegPath()
+	if err != nil {
+		return Loudness{}, ErrFFmpegNotFound
+	}
+	// framelog=verbose 让逐帧输出低于 info，stderr 里只剩结尾的汇总。
+	cmd := exec.Command(ffmpegPath, "-hide_banner", "-nostats", "-i", path,
+		"-vn", "-af", "ebur128=peak=true:framelog=verbose", "-f", "null", "-")
+	HideCommandWindow(cmd)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return Loudness{}, fmt.Errorf("ffmpeg loudness analysis failed: %v, output: %s", err, lastLines(stderr.String(), 5))
+	}
+	return parseEBUR128Summary(stderr.String())
+}
+
+// parseEBUR128Summary reads the summary ebur128 prints when the input ends.
+func parseEBUR128Summary(output string) (Loudness, error) {
+	idx := strings.LastIndex(output, "Summary:")
+	if idx < 0 {
+		return Loudness{}, ErrLoudnessNoResult
+	}
+	var result Loudness
+	var found [3]bool
+	scanner := bufio.NewScanner(strings.NewReader(output[idx:]))
+	for scanner.Scan() {
+		label, rest, ok := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if !ok {
+			continue
+		}
+		fields := strings.Fields(rest)
+		if len(fields) == 0 {
+			continue
+		}
+		value, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil {
+			continue
+		}
+		switch label {
+		case "I":
+			result.Integrated, found[0] = value, true
+		case "LRA":
+			result.Range, found[1] = value, true
+		case "Peak":
+			result.TruePeak, found[2] = value, true
+		}
+	}
+	if !found[0] || !found[2] {
+		return Loudness{}, ErrLoudnessNoResult
+	}
+	if result.Integrated <= loudnessSilenceLUFS || math.IsInf(result.TruePeak, -1) {
+		return Loudness{}, ErrLoudnessSilent
+	}
+	return result, nil
+}
+
+func lastLines(s string, n int) string {
+	lines := strings.Split(strings.TrimSpace(s), "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return strings.Join(lines, " | ")
+}
+
+// ReplayGainTagFields returns the REPLAYGAIN_* tags for l.
+func ReplayGainTagFields(l Loudness) [][2]string {
+	return [][2]string{
+		{"REPLAYGAIN_TRACK_GAIN", fmt.Sprintf("%.2f dB", l.TrackGain())},
+		{"REPLAYGAIN_TRACK_PEAK", fmt.Sprintf("%.6f", l.PeakRatio())},
+	}
+}
+
+// WriteReplayGainTags returns audioData with the REPLAYGAIN_* tags for l,
+// replacing older ones and keeping every other tag.
+func WriteReplayGainTags(audioData []byte, ext string, l Loudness) ([]byte, error) {
+	if len(audioData) == 0 {
+		return nil, errors.New("empty audio data")
+	}
+	fields := ReplayGainTagFields(l)
+	switch strings.ToLower(strings.TrimPrefix(strings.TrimSpace(ext), ".")) {
+	case "mp3":
+		return writeMP3ReplayGain(audioData, fields), nil
+	case "flac":
+		return runFFmpegMetadataEmbed(audioData, "flac", fields, nil, "", false)
+	default:
+		return nil, fmt.Errorf("writing ReplayGain tags to %s is not supported", ext)
+	}
+}
+
+func writeMP3ReplayGain(audioData []byte, fields [][2]string) []byte {
+	if len(audioData) >= 10 && string(audioData[:3]) == "ID3" && audioData[3] != 0x03 {
+		// 其他版本的 ID3v2 无法逐帧保留，先把常用标签按 v2.3 重写一遍。
+		if existing, err := ReadAudioTags(audioData); err == nil {
+			audioData = writeMP3AudioTags(audioData, existing)
+		}
+	}
+	var frames [][]byte
+	for _, frame := range splitID3v23Frames(preservedID3v23Frames(audioData, nil)) {
+		if string(frame[:4]) == "TXXX" && strings.HasPrefix(strings.ToUpper(id3TXXXDescription(frame[10:])), "REPLAYGAIN_") {
+			continue
+		}
+		frames = append(frames, frame)
+	}
+	for _, field := range fields {
+		frames = append(frames, id3v23Frame("TXXX", id3TXXXPayload(field[0], field[1])))
+	}
+	return rewriteID3v23Tag(stripID3v2Prefix(audioData), nil, frames)
+}
+
+// splitID3v23Frames splits a run of ID3v2.3 frames as returned by
+// preservedID3v23Frames.
+func splitID3v23Frames(data []byte) [][]byte {
+	var frames [][]byte
+	for pos := 0; pos+10 <= len(data); {
+		size := int(binary.BigEndian.Uint32(data[pos+4 : pos+8]))
+		if size <= 0 || pos+10+size > len(data) {
+			break
+		}
+		frames = append(frames, data[pos:pos+10+size])
+		pos += 10 + size
+	}
+	return frames
+}
+
+func id3TXXXPayload(description, value string) []byte {
+	payload := []byte{0x01}
+	payload = append(payload, id3UTF16LEText(description)...)
+	payload = append(payload, 0x00, 0x00)
+	payload = append(payload, id3UTF16LEText(value)...)
+	return payload
+}
+
+// id3TXXXDescription decodes the description of a TXXX frame payload.
+func id3TXXXDescription(payload []byte) string {
+	if len(payload) < 2 {
+		return ""
+	}
+	body := payload[1:]
+	switch payload[0] {
+	case 0x01, 0x02:
+		order := binary.ByteOrder(binary.BigEndian)
+		if len(body) >= 2 && body[0] == 0xFF && body[1] == 0xFE {
+			order, body = binary.LittleEndian, body[2:]
+		} else if len(body) >= 2 && body[0] == 0xFE && body[1] == 0xFF {
+			body = body[2:]
+		}
+		var units []uint16
+		for i := 0; i+1 < len(body); i += 2 {
+			unit := order.Uint16(body[i:])
+			if unit == 0 {
+				break
+			}
+			units = append(units, unit)
+		}
+		return string(utf16.Decode(units))
+	default:
+		if end := bytes.IndexByte(body, 0); end >= 0 {
+			body = body[:end]
+		}
+		return string(body)
+	}
+}
+
+// SongReplayGain returns the clip-safe gain stored in song.Extra.
+func SongReplayGain(song *model.Song) (float64, bool) {
+	if song == nil || song.Extra == nil {
+		return 0, false
+	}
+	gain, err := strconv.ParseFloat(strings.TrimSpace(song.Extra[ExtraReplayGainTrackGain]), 64)
+	if err != nil {
+		return 0, false
+	}
+	peak, _ := strconv.ParseFloat(strings.TrimSpace(song.Extra[ExtraReplayGainTrackPeak]), 64)
+	return ReplayGainAdjustment(gain, peak), true
+}
+
+// ReplayGainFilter returns the ffmpeg audio filter that normalises song under
+// mode: its stored gain when there is one, otherwise on-the-fly loudnorm.
+func ReplayGainFilter(song *model.Song, mode string) string {
+	if mode != ReplayGainModeTrack {
+		return ""
+	}
+	if gain, ok := SongReplayGain(song); ok {
+		return fmt.Sprintf("volume=%.2fdB", gain)
+	}
+	return replayGainStreamFilter
+}
+
+func normalizeReplayGainMode(mode string) string {
+	if strings.ToLower(strings.TrimSpace(mode)) == ReplayGainModeTrack {
+		return ReplayGainModeTrack
+	}
+	return ReplayGainModeOff
+}
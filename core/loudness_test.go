@@ -0,0 +1,128 @@
+package core
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/guohuiyuan/music-lib/model"
+)
+
+const ebur128OutputForTest = `Input #0, mp3, from 'song.mp3':
+  Duration: 00:03:45.12, start: 0.025057, bitrate: 320 kb/s
+[Parsed_ebur128_0 @ 0x5581] Summary:
+
+  Integrated loudness:
+    I:          -9.4 LUFS
+    Threshold: -19.6 LUFS
+
+  Loudness range:
+    LRA:         5.3 LU
+    Threshold: -29.5 LUFS
+    LRA low:   -13.8 LUFS
+    LRA high:   -8.5 LUFS
+
+  True peak:
+    Peak:        0.8 dBFS
+`
+
+func TestParseEBUR128Summary(t *testing.T) {
+	got, err := parseEBUR128Summary(ebur128OutputForTest)
+	if err != nil {
+		t.Fatalf("parseEBUR128Summary() error = %v", err)
+	}
+	if want := (Loudness{Integrated: -9.4, Range: 5.3, TruePeak: 0.8}); got != want {
+		t.Fatalf("loudness = %+v, want %+v", got, want)
+	}
+	if gain := got.TrackGain(); gain != -8.6 {
+		t.Fatalf("TrackGain() = %v", gain)
+	}
+	if peak := got.PeakRatio(); peak != 1.096478 {
+		t.Fatalf("PeakRatio() = %v", peak)
+	}
+
+	silent := strings.NewReplacer("-9.4 LUFS", "-70.0 LUFS", "0.8 dBFS", "-inf dBFS").Replace(ebur128OutputForTest)
+	if _, err := parseEBUR128Summary(silent); !errors.Is(err, ErrLoudnessSilent) {
+		t.Fatalf("silent error = %v", err)
+	}
+	if _, err := parseEBUR128Summary("Invalid data found when processing input"); !errors.Is(err, ErrLoudnessNoResult) {
+		t.Fatalf("missing summary error = %v", err)
+	}
+}
+
+func TestReplayGainAdjustmentAvoidsClipping(t *testing.T) {
+	if got := ReplayGainAdjustment(-6.5, 1.2); got != -6.5 {
+		t.Fatalf("negative gain = %v", got)
+	}
+	// 峰值 0.5 时最多只能提升约 6.02 dB。
+	if got := ReplayGainAdjustment(9, 0.5); got != 6.02 {
+		t.Fatalf("limited gain = %v", got)
+	}
+	if got := ReplayGainAdjustment(9, 0); got != 9 {
+		t.Fatalf("unknown peak gain = %v", got)
+	}
+}
+
+func TestReplayGainFilter(t *testing.T) {
+	song := &model.Song{Extra: map[string]string{ExtraReplayGainTrackGain: "-8.6", ExtraReplayGainTrackPeak: "1.096478"}}
+	if got := ReplayGainFilter(song, ReplayGainModeOff); got != "" {
+		t.Fatalf("off filter = %q", got)
+	}
+	if got := ReplayGainFilter(song, ReplayGainModeTrack); got != "volume=-8.60dB" {
+		t.Fatalf("stored gain filter = %q", got)
+	}
+	if got := ReplayGainFilter(&model.Song{}, ReplayGainModeTrack); !strings.HasPrefix(got, "loudnorm=") {
+		t.Fatalf("stream filter = %q", got)
+	}
+
+	args := PlaybackArgsAt(song, "http://example.com/a.mp3", PlaybackOptions{Volume: 100, ReplayGain: ReplayGainModeTrack})
+	if i := slices.Index(args, "-af"); i < 0 || args[i+1] != "volume=-8.60dB" {
+		t.Fatalf("playback args = %v", args)
+	}
+}
+
+func replayGainTXXXForTest(t *testing.T, data []byte) []string {
+	t.Helper()
+	var descriptions []string
+	for _, frame := range splitID3v23Frames(preservedID3v23Frames(data, nil)) {
+		if string(frame[:4]) == "TXXX" {
+			descriptions = append(descriptions, id3TXXXDescription(frame[10:]))
+		}
+	}
+	return descriptions
+}
+
+func TestWriteReplayGainTagsMP3(t *testing.T) {
+	audioData := []byte{0xff, 0xfb, 0x90, 0x64, 0x00, 0x00}
+	tagged, err := WriteAudioTags(audioData, "mp3", AudioTags{Title: "晴天", Artist: "周杰伦"})
+	if err != nil {
+		t.Fatalf("WriteAudioTags() error = %v", err)
+	}
+	first, err := WriteReplayGainTags(tagged, "mp3", Loudness{Integrated: -9.4, TruePeak: 0.8})
+	if err != nil {
+		t.Fatalf("WriteReplayGainTags() error = %v", err)
+	}
+	// 再分析一次只替换旧的 REPLAYGAIN_* 帧。
+	second, err := WriteReplayGainTags(first, ".MP3", Loudness{Integrated: -14, TruePeak: -1})
+	if err != nil {
+		t.Fatalf("second WriteReplayGainTags() error = %v", err)
+	}
+	if got := replayGainTXXXForTest(t, second); !slices.Equal(got, []string{"REPLAYGAIN_TRACK_GAIN", "REPLAYGAIN_TRACK_PEAK"}) {
+		t.Fatalf("TXXX frames = %v", got)
+	}
+	tags, err := ReadAudioTags(second)
+	if err != nil {
+		t.Fatalf("ReadAudioTags() error = %v", err)
+	}
+	if tags.Title != "晴天" || tags.Artist != "周杰伦" {
+		t.Fatalf("tags = %+v", tags)
+	}
+	if !strings.HasSuffix(string(second), string(audioData)) {
+		t.Fatal("written data should keep the MP3 audio frames")
+	}
+
+	if _, err := WriteReplayGainTags(audioData, "m4a", Loudness{}); err == nil {
+		t.Fatal("m4a should not be supported")
+	}
+}
@@ -0,0 +1,306 @@
+package core
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// A deliberately small Prometheus text-format registry. The app only needs
+// counters, gauges and histograms with labels, which does not justify pulling
+// in client_golang and its dependency tree.
+
+// DefaultLatencyBuckets are the histogram buckets used for request latencies.
+var DefaultLatencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+type metricFamily interface {
+	metricName() string
+	writeTo(w *bufio.Writer)
+}
+
+var (
+	metricsMu       sync.Mutex
+	metricFamilies  = make(map[string]metricFamily)
+	metricNameOrder []string
+)
+
+func registerMetric(family metricFamily) {
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+	name := family.metricName()
+	if _, exists := metricFamilies[name]; exists {
+		panic("metric registered twice: " + name)
+	}
+	metricFamilies[name] = family
+	metricNameOrder = append(metricNameOrder, name)
+	sort.Strings(metricNameOrder)
+}
+
+// WriteMetrics writes every registered metric in the Prometheus text
+// exposition format (version 0.0.4).
+func WriteMetrics(w io.Writer) error {
+	metricsMu.Lock()
+	families := make([]metricFamily, 0, len(metricNameOrder))
+	for _, name := range metricNameOrder {
+		families = append(families, metricFamilies[name])
+	}
+	metricsMu.Unlock()
+
+	bw := bufio.NewWriter(w)
+	for _, family := range families {
+		family.writeTo(bw)
+	}
+	return bw.Flush()
+}
+
+type metricSeries struct {
+	labels []string
+	value  float64
+	// histogram only
+	buckets []uint64
+	count   uint64
+}
+
+type labeledMetric struct {
+	name       string
+	help       string
+	kind       string
+	labelNames []string
+
+	mu     sync.Mutex
+	series map[string]*metricSeries
+}
+
+func newLabeledMetric(name, help, kind string, labelNames []string) labeledMetric {
+	return labeledMetric{
+		name:       name,
+		help:       help,
+		kind:       kind,
+		labelNames: labelNames,
+		series:     make(map[string]*metricSeries),
+	}
+}
+
+func (m *labeledMetric) metricName() string { return m.name }
+
+// seriesFor must be called with m.mu held.
+func (m *labeledMetric) seriesFor(labelValues []string) *metricSeries {
+	if len(labelValues) != len(m.labelNames) {
+		panic(fmt.Sprintf("metric %s: expected %d label values, got %d", m.name, len(m.labelNames), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+	s, ok := m.series[key]
+	if !ok {
+		s = &metricSeries{labels: append([]string(nil), labelValues...)}
+		m.series[key] = s
+	}
+	return s
+}
+
+func (m *labeledMetric) sortedSeries() []*metricSeries {
+	keys := make([]string, 0, len(m.series))
+	for key := range m.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	out := make([]*metricSeries, 0, len(keys))
+	for _, key := range keys {
+		s := *m.series[key]
+		s.buckets = append([]uint64(nil), s.buckets...)
+		out = append(out, &s)
+	}
+	return out
+}
+
+func (m *labeledMetric) writeHeader(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.name, escapeMetricHelp(m.help), m.name, m.kind)
+}
+
+func (m *labeledMetric) writeSimple(w *bufio.Writer) {
+	m.mu.Lock()
+	series := m.sortedSeries()
+	m.mu.Unlock()
+	if len(series) == 0 && len(m.labelNames) > 0 {
+		return
+	}
+	m.writeHeader(w)
+	if len(series) == 0 {
+		fmt.Fprintf(w, "%s 0\n", m.name)
+		return
+	}
+	for _, s := range series {
+		fmt.Fprintf(w, "%s%s %s\n", m.name, formatMetricLabels(m.labelNames, s.labels, "", ""), formatMetricValue(s.value))
+	}
+}
+
+// CounterVec is a monotonically increasing value partitioned by labels.
+type CounterVec struct{ labeledMetric }
+
+// NewCounterVec registers a counter. It panics if the name is already taken.
+func NewCounterVec(name, help string, labelNames ...string) *CounterVec {
+	c := &CounterVec{newLabeledMetric(name, help, "counter", labelNames)}
+	registerMetric(c)
+	return c
+}
+
+func (c *CounterVec) Add(delta float64, labelValues ...string) {
+	if delta < 0 {
+		return
+	}
+	c.mu.Lock()
+	c.seriesFor(labelValues).value += delta
+	c.mu.Unlock()
+}
+
+func (c *CounterVec) Inc(labelValues ...string) { c.Add(1, labelValues...) }
+
+// Value returns the current value, mainly for tests.
+func (c *CounterVec) Value(labelValues ...string) float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.seriesFor(labelValues).value
+}
+
+func (c *CounterVec) writeTo(w *bufio.Writer) { c.writeSimple(w) }
+
+// GaugeVec is a value that can go up and down, partitioned by labels.
+type GaugeVec struct{ labeledMetric }
+
+func NewGaugeVec(name, help string, labelNames ...string) *GaugeVec {
+	g := &GaugeVec{newLabeledMetric(name, help, "gauge", labelNames)}
+	registerMetric(g)
+	return g
+}
+
+func (g *GaugeVec) Set(value float64, labelValues ...string) {
+	g.mu.Lock()
+	g.seriesFor(labelValues).value = value
+	g.mu.Unlock()
+}
+
+func (g *GaugeVec) Add(delta float64, labelValues ...string) {
+	g.mu.Lock()
+	g.seriesFor(labelValues).value += delta
+	g.mu.Unlock()
+}
+
+func (g *GaugeVec) writeTo(w *bufio.Writer) { g.writeSimple(w) }
+
+// HistogramVec counts observations into cumulative buckets.
+type HistogramVec struct {
+	labeledMetric
+	bounds []float64
+}
+
+func NewHistogramVec(name, help string, buckets []float64, labelNames ...string) *HistogramVec {
+	bounds := append([]float64(nil), buckets...)
+	sort.Float64s(bounds)
+	h := &HistogramVec{labeledMetric: newLabeledMetric(name, help, "histogram", labelNames), bounds: bounds}
+	registerMetric(h)
+	return h
+}
+
+func (h *HistogramVec) Observe(value float64, labelValues ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s := h.seriesFor(labelValues)
+	if s.buckets == nil {
+		s.buckets = make([]uint64, len(h.bounds))
+	}
+	for i, bound := range h.bounds {
+		if value <= bound {
+			s.buckets[i]++
+		}
+	}
+	s.count++
+	s.value += value
+}
+
+func (h *HistogramVec) writeTo(w *bufio.Writer) {
+	h.mu.Lock()
+	series := h.sortedSeries()
+	h.mu.Unlock()
+	if len(series) == 0 {
+		return
+	}
+	h.writeHeader(w)
+	for _, s := range series {
+		for i, bound := range h.bounds {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatMetricLabels(h.labelNames, s.labels, "le", formatMetricValue(bound)), s.buckets[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatMetricLabels(h.labelNames, s.labels, "le", "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, formatMetricLabels(h.labelNames, s.labels, "", ""), formatMetricValue(s.value))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, formatMetricLabels(h.labelNames, s.labels, "", ""), s.count)
+	}
+}
+
+// GaugeFunc is a gauge whose value is computed at scrape time.
+type GaugeFunc struct {
+	name, help string
+	fn         func() float64
+}
+
+func NewGaugeFunc(name, help string, fn func() float64) *GaugeFunc {
+	g := &GaugeFunc{name: name, help: help, fn: fn}
+	registerMetric(g)
+	return g
+}
+
+func (g *GaugeFunc) metricName() string { return g.name }
+
+func (g *GaugeFunc) writeTo(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %s\n", g.name, escapeMetricHelp(g.help), g.name, g.name, formatMetricValue(g.fn()))
+}
+
+func formatMetricLabels(names, values []string, extraName, extraValue string) string {
+	if len(names) == 0 && extraName == "" {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(name)
+		b.WriteString(`="`)
+		b.WriteString(escapeMetricLabel(values[i]))
+		b.WriteByte('"')
+	}
+	if extraName != "" {
+		if len(names) > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(extraName)
+		b.WriteString(`="`)
+		b.WriteString(extraValue)
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+func formatMetricValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func escapeMetricLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+func escapeMetricHelp(value string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(value)
+}
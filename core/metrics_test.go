@@ -0,0 +1,100 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func metricsOutputForTest(t *testing.T) string {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := WriteMetrics(&buf); err != nil {
+		t.Fatalf("WriteMetrics() error = %v", err)
+	}
+	return buf.String()
+}
+
+func TestCounterAndHistogramExposition(t *testing.T) {
+	counter := NewCounterVec("music_dl_test_events_total", "Test events.", "kind")
+	counter.Inc("a")
+	counter.Add(2, "a")
+	counter.Inc(`quote"d`)
+
+	hist := NewHistogramVec("music_dl_test_latency_seconds", "Test latency.", []float64{0.1, 1}, "op")
+	hist.Observe(0.05, "x")
+	hist.Observe(0.5, "x")
+	hist.Observe(5, "x")
+
+	gauge := NewGaugeVec("music_dl_test_level", "Test level.")
+	gauge.Set(7)
+
+	NewGaugeFunc("music_dl_test_func", "Test func.", func() float64 { return 1.5 })
+
+	out := metricsOutputForTest(t)
+	for _, want := range []string{
+		"# TYPE music_dl_test_events_total counter",
+		`music_dl_test_events_total{kind="a"} 3`,
+		`music_dl_test_events_total{kind="quote\"d"} 1`,
+		"# TYPE music_dl_test_latency_seconds histogram",
+		`music_dl_test_latency_seconds_bucket{op="x",le="0.1"} 1`,
+		`music_dl_test_latency_seconds_bucket{op="x",le="1"} 2`,
+		`music_dl_test_latency_seconds_bucket{op="x",le="+Inf"} 3`,
+		`music_dl_test_latency_seconds_sum{op="x"} 5.55`,
+		`music_dl_test_latency_seconds_count{op="x"} 3`,
+		"music_dl_test_level 7",
+		"music_dl_test_func 1.5",
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("metrics output missing %q\n%s", want, out)
+		}
+	}
+	if got := counter.Value("a"); got != 3 {
+		t.Fatalf("counter.Value() = %v, want 3", got)
+	}
+}
+
+func TestRegisterMetricTwicePanics(t *testing.T) {
+	NewCounterVec("music_dl_test_dup_total", "dup")
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected duplicate registration to panic")
+		}
+	}()
+	NewCounterVec("music_dl_test_dup_total", "dup")
+}
+
+func TestInstrumentSourceCountsOutcomes(t *testing.T) {
+	okBefore := sourceRequestsTotal.Value("test-src", "search", "ok")
+	errBefore := sourceRequestsTotal.Value("test-src", "search", "error")
+
+	fail := true
+	fn := instrumentSource1(context.Background(), "test-src", "search", func(keyword string) (int, error) {
+		if fail {
+			return 0, errors.New("boom")
+		}
+		return len(keyword), nil
+	})
+	if _, err := fn("x"); err == nil {
+		t.Fatal("expected wrapped error to be returned")
+	}
+	fail = false
+	if n, err := fn("abc"); err != nil || n != 3 {
+		t.Fatalf("wrapped call = %d, %v", n, err)
+	}
+
+	if got := sourceRequestsTotal.Value("test-src", "search", "ok") - okBefore; got != 1 {
+		t.Fatalf("ok calls = %v, want 1", got)
+	}
+	if got := sourceRequestsTotal.Value("test-src", "search", "error") - errBefore; got != 1 {
+		t.Fatalf("error calls = %v, want 1", got)
+	}
+	if instrumentSource1[string, int](context.Background(), "test-src", "search", nil) != nil {
+		t.Fatal("expected nil function to stay nil")
+	}
+	if GetSearchFunc("no-such-source") != nil {
+		t.Fatal("expected unknown source to have no search func")
+	}
+}
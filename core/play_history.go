@@ -0,0 +1,501 @@
+package core
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Play history events reported by the web player and the TUI.
+const (
+	PlayEventStart    = "start"
+	PlayEventProgress = "progress"
+	PlayEventComplete = "complete"
+	PlayEventSkip     = "skip"
+)
+
+// Play history row states.
+const (
+	PlayStatusPlaying   = "playing"
+	PlayStatusCompleted = "completed"
+	PlayStatusSkipped   = "skipped"
+)
+
+const (
+	// playHistoryCountedSeconds 是一次播放计入排行和回顾的最短收听时长，
+	// 放完的歌不受这个限制。
+	playHistoryCountedSeconds = 30
+	// 续播只提示听过 30 秒以上、离结尾还有 15 秒以上的播放。
+	playHistoryResumeMinSeconds  = 30
+	playHistoryResumeTailSeconds = 15
+	playHistoryMaxLimit          = 200
+)
+
+var (
+	ErrPlayHistoryBadEvent = errors.New("unknown play history event")
+	ErrPlayHistoryNoSong   = errors.New("song id, source and name are required")
+)
+
+// PlayHistory is one listening session: a start event inserts the row and the
+// following progress, complete and skip events update it.
+type PlayHistory struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	SongID    string    `gorm:"size:512;not null;index:idx_play_history_song" json:"song_id"`
+	Source    string    `gorm:"size:64;not null;index:idx_play_history_song" json:"source"`
+	Name      string    `gorm:"size:512;not null" json:"name"`
+	Artist    string    `gorm:"size:512;index" json:"artist"`
+	Album     string    `gorm:"size:512" json:"album"`
+	Cover     string    `gorm:"size:1024" json:"cover"`
+	Extra     string    `gorm:"type:text" json:"extra"`
+	Duration  int       `json:"duration"`
+	Position  int       `json:"position"`
+	Status    string    `gorm:"size:16;not null;index" json:"status"`
+	Client    string    `gorm:"size:16" json:"client"`
+	StartedAt time.Time `gorm:"not null;index" json:"started_at"`
+	UpdatedAt time.Time `gorm:"autoUpdateTime:false" json:"updated_at"`
+	Scrobbled bool      `gorm:"not null;default:false" json:"-"`
+}
+
+// PlayEvent is one player report. PlayID is the row returned by the start
+// event; complete and skip without a PlayID attach to the song's latest
+// unfinished row, or record a new play when there is none.
+type PlayEvent struct {
+	PlayID   uint   `json:"play_id"`
+	Event    string `json:"event"`
+	SongID   string `json:"id"`
+	Source   string `json:"source"`
+	Name     string `json:"name"`
+	Artist   string `json:"artist"`
+	Album    string `json:"album"`
+	Cover    string `json:"cover"`
+	Extra    string `json:"extra"`
+	Duration int    `json:"duration"`
+	Position int    `json:"position"`
+	Client   string `json:"client"`
+}
+
+// PlayTrackStat is one row of the top tracks ranking.
+type PlayTrackStat struct {
+	SongID  string `json:"id"`
+	Source  string `json:"source"`
+	Name    string `json:"name"`
+	Artist  string `json:"artist"`
+	Album   string `json:"album"`
+	Cover   string `json:"cover"`
+	Plays   int    `json:"plays"`
+	Seconds int    `json:"seconds"`
+}
+
+// PlayArtistStat is one row of the top artists ranking.
+type PlayArtistStat struct {
+	Artist  string `json:"artist"`
+	Plays   int    `json:"plays"`
+	Tracks  int    `json:"tracks"`
+	Seconds int    `json:"seconds"`
+}
+
+// PlaySourceStat counts plays per music source.
+type PlaySourceStat struct {
+	Source string `json:"source"`
+	Plays  int    `json:"plays"`
+}
+
+// PlayMonthStat is one month of a yearly recap.
+type PlayMonthStat struct {
+	Month   int `json:"month"`
+	Plays   int `json:"plays"`
+	Seconds int `json:"seconds"`
+}
+
+// PlayRecap summarizes one calendar year of listening.
+type PlayRecap struct {
+	Year            int              `json:"year"`
+	Plays           int              `json:"plays"`
+	Skips           int              `json:"skips"`
+	Seconds         int              `json:"seconds"`
+	Tracks          int              `json:"tracks"`
+	Artists         int              `json:"artists"`
+	TopTracks       []PlayTrackStat  `json:"top_tracks"`
+	TopArtists      []PlayArtistStat `json:"top_artists"`
+	Sources         []PlaySourceStat `json:"sources"`
+	Months          []PlayMonthStat  `json:"months"`
+	BusiestDay      string           `json:"busiest_day,omitempty"`
+	BusiestDayPlays int              `json:"busiest_day_plays,omitempty"`
+	FirstPlay       *PlayHistory     `json:"first_play,omitempty"`
+}
+
+func initPlayHistoryTable() error {
+	if err := ensureConfigDB(); err != nil {
+		return err
+	}
+	return configDB.AutoMigrate(&PlayHistory{})
+}
+
+// RecordPlayEvent applies one player event and returns the affected row. It
+// returns nil without error when play history is disabled in the settings.
+// Starting a play also drops rows older than the retention window. The event
+// is passed on to the configured scrobblers either way.
+func RecordPlayEvent(event PlayEvent, at time.Time) (*PlayHistory, error) {
+	event = normalizePlayEvent(event)
+	settings := GetWebSettings()
+	var record *PlayHistory
+	if !settings.DisablePlayHistory {
+		var err error
+		if record, err = recordPlayHistory(event, settings.PlayHistoryRetentionDays, at); err != nil {
+			return nil, err
+		}
+	}
+	scrobblePlayEvent(event, record, at)
+	return record, nil
+}
+
+func recordPlayHistory(event PlayEvent, retentionDays int, at time.Time) (*PlayHistory, error) {
+	if err := initPlayHistoryTable(); err != nil {
+		return nil, err
+	}
+
+	switch event.Event {
+	case PlayEventStart:
+		record, err := createPlayHistory(event, at)
+		if err != nil {
+			return nil, err
+		}
+		if err := prunePlayHistory(retentionDays, at); err != nil {
+			Logger().Warn("prune play history failed", "error", err)
+		}
+		return record, nil
+	case PlayEventProgress, PlayEventComplete, PlayEventSkip:
+	default:
+		return nil, ErrPlayHistoryBadEvent
+	}
+
+	record, err := findPlayHistoryForEvent(event)
+	if err != nil {
+		return nil, err
+	}
+	if record == nil {
+		if event.Event == PlayEventProgress {
+			// 进度上报找不到对应的行（例如刚清空历史）时不补建，等下一次 start。
+			return nil, nil
+		}
+		if record, err = createPlayHistory(event, at); err != nil {
+			return nil, err
+		}
+	}
+
+	if record.Duration == 0 && event.Duration > 0 {
+		record.Duration = event.Duration
+	}
+	record.Position = event.Position
+	switch event.Event {
+	case PlayEventComplete:
+		record.Status = PlayStatusCompleted
+		if record.Duration > 0 {
+			record.Position = record.Duration
+		}
+	case PlayEventSkip:
+		record.Status = PlayStatusSkipped
+	}
+	if record.Duration > 0 && record.Position > record.Duration {
+		record.Position = record.Duration
+	}
+	record.UpdatedAt = at
+	err = configDB.Model(record).Select("duration", "position", "status", "updated_at").Updates(record).Error
+	return record, err
+}
+
+func normalizePlayEvent(event PlayEvent) PlayEvent {
+	event.Event = strings.ToLower(strings.TrimSpace(event.Event))
+	event.SongID = cleanDownloadRecordText(event.SongID)
+	event.Source = cleanDownloadRecordText(event.Source)
+	event.Name = cleanDownloadRecordText(event.Name)
+	event.Artist = cleanDownloadRecordText(event.Artist)
+	event.Album = cleanDownloadRecordText(event.Album)
+	event.Cover = strings.TrimSpace(event.Cover)
+	event.Client = cleanDownloadRecordText(event.Client)
+	if event.Duration < 0 {
+		event.Duration = 0
+	}
+	if event.Position < 0 {
+		event.Position = 0
+	}
+	return event
+}
+
+func createPlayHistory(event PlayEvent, at time.Time) (*PlayHistory, error) {
+	if event.SongID == "" || event.Source == "" || event.Name == "" {
+		return nil, ErrPlayHistoryNoSong
+	}
+	record := &PlayHistory{
+		SongID:    event.SongID,
+		Source:    event.Source,
+		Name:      event.Name,
+		Artist:    event.Artist,
+		Album:     event.Album,
+		Cover:     event.Cover,
+		Extra:     event.Extra,
+		Duration:  event.Duration,
+		Position:  event.Position,
+		Status:    PlayStatusPlaying,
+		Client:    event.Client,
+		StartedAt: at,
+		UpdatedAt: at,
+	}
+	if err := configDB.Create(record).Error; err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+func findPlayHistoryForEvent(event PlayEvent) (*PlayHistory, error) {
+	var record PlayHistory
+	query := configDB
+	if event.PlayID > 0 {
+		query = query.Where("id = ?", event.PlayID)
+	} else if event.SongID != "" && event.Source != "" {
+		query = query.Where("song_id = ? AND source = ? AND status = ?", event.SongID, event.Source, PlayStatusPlaying).Order("id DESC")
+	} else {
+		return nil, nil
+	}
+	err := query.First(&record).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+func prunePlayHistory(retentionDays int, now time.Time) error {
+	if retentionDays <= 0 {
+		return nil
+	}
+	return configDB.Where("started_at < ?", now.AddDate(0, 0, -retentionDays)).Delete(&PlayHistory{}).Error
+}
+
+// PrunePlayHistory drops rows older than the configured retention window.
+func PrunePlayHistory(now time.Time) error {
+	if err := initPlayHistoryTable(); err != nil {
+		return err
+	}
+	return prunePlayHistory(GetWebSettings().PlayHistoryRetentionDays, now)
+}
+
+// ClearPlayHistory deletes every play history row.
+func ClearPlayHistory() error {
+	if err := initPlayHistoryTable(); err != nil {
+		return err
+	}
+	return configDB.Where("1 = 1").Delete(&PlayHistory{}).Error
+}
+
+// PlayHistoryPeriodStart maps day / week / month / year to the start of a
+// rolling window ending at now. "all" returns the zero time; ok is false for
+// unknown periods.
+func PlayHistoryPeriodStart(period string, now time.Time) (since time.Time, ok bool) {
+	switch strings.ToLower(strings.TrimSpace(period)) {
+	case "day":
+		return now.AddDate(0, 0, -1), true
+	case "week":
+		return now.AddDate(0, 0, -7), true
+	case "", "month":
+		return now.AddDate(0, -1, 0), true
+	case "year":
+		return now.AddDate(-1, 0, 0), true
+	case "all":
+		return time.Time{}, true
+	}
+	return time.Time{}, false
+}
+
+func clampPlayHistoryLimit(limit, fallback int) int {
+	if limit <= 0 {
+		return fallback
+	}
+	if limit > playHistoryMaxLimit {
+		return playHistoryMaxLimit
+	}
+	return limit
+}
+
+// countedPlays scopes to plays that count towards rankings: finished songs
+// and anything listened to for at least playHistoryCountedSeconds.
+func countedPlays(since, until time.Time) *gorm.DB {
+	query := configDB.Model(&PlayHistory{}).Where("(status = ? OR position >= ?)", PlayStatusCompleted, playHistoryCountedSeconds)
+	if !since.IsZero() {
+		query = query.Where("started_at >= ?", since)
+	}
+	if !until.IsZero() {
+		query = query.Where("started_at < ?", until)
+	}
+	return query
+}
+
+func latestPlayPerSong() *gorm.DB {
+	return configDB.Model(&PlayHistory{}).Select("MAX(id)").Group("source, song_id")
+}
+
+// RecentPlays returns one page of recently played songs, newest first, with
+// each song listed once at its latest play.
+func RecentPlays(page, pageSize int) ([]PlayHistory, int64, error) {
+	if err := initPlayHistoryTable(); err != nil {
+		return nil, 0, err
+	}
+	if page < 1 {
+		page = 1
+	}
+	pageSize = clampPlayHistoryLimit(pageSize, 20)
+
+	var total int64
+	if err := configDB.Model(&PlayHistory{}).Where("id IN (?)", latestPlayPerSong()).Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	var records []PlayHistory
+	err := configDB.Where("id IN (?)", latestPlayPerSong()).
+		Order("started_at DESC, id DESC").Offset((page - 1) * pageSize).Limit(pageSize).Find(&records).Error
+	return records, total, err
+}
+
+// ResumablePlays lists songs whose latest play stopped part-way through, most
+// recently interrupted first.
+func ResumablePlays(limit int) ([]PlayHistory, error) {
+	if err := initPlayHistoryTable(); err != nil {
+		return nil, err
+	}
+	var records []PlayHistory
+	err := resumablePlayQuery().Order("updated_at DESC, id DESC").Limit(clampPlayHistoryLimit(limit, 10)).Find(&records).Error
+	return records, err
+}
+
+// PlayResumePosition returns the second to resume a song from, or 0 when its
+// latest play finished or was too short to be worth resuming.
+func PlayResumePosition(source, songID string) (int, error) {
+	if err := initPlayHistoryTable(); err != nil {
+		return 0, err
+	}
+	var records []PlayHistory
+	err := resumablePlayQuery().Where("source = ? AND song_id = ?", strings.TrimSpace(source), strings.TrimSpace(songID)).Limit(1).Find(&records).Error
+	if err != nil || len(records) == 0 {
+		return 0, err
+	}
+	return records[0].Position, nil
+}
+
+func resumablePlayQuery() *gorm.DB {
+	return configDB.Where("id IN (?)", latestPlayPerSong()).
+		Where("status <> ? AND position >= ?", PlayStatusCompleted, playHistoryResumeMinSeconds).
+		Where("(duration = 0 OR position <= duration - ?)", playHistoryResumeTailSeconds)
+}
+
+// TopPlayedTracks ranks songs by counted plays in [since, until); zero times
+// leave that side open.
+func TopPlayedTracks(since, until time.Time, limit int) ([]PlayTrackStat, error) {
+	if err := initPlayHistoryTable(); err != nil {
+		return nil, err
+	}
+	return topPlayedTracks(since, until, limit)
+}
+
+func topPlayedTracks(since, until time.Time, limit int) ([]PlayTrackStat, error) {
+	stats := []PlayTrackStat{}
+	err := countedPlays(since, until).
+		Select("song_id, source, MAX(name) AS name, MAX(artist) AS artist, MAX(album) AS album, MAX(cover) AS cover, COUNT(*) AS plays, SUM(position) AS seconds").
+		Group("source, song_id").Order("plays DESC, seconds DESC, MAX(id) DESC").
+		Limit(clampPlayHistoryLimit(limit, 10)).Scan(&stats).Error
+	return stats, err
+}
+
+// TopPlayedArtists ranks artists by counted plays in [since, until).
+func TopPlayedArtists(since, until time.Time, limit int) ([]PlayArtistStat, error) {
+	if err := initPlayHistoryTable(); err != nil {
+		return nil, err
+	}
+	return topPlayedArtists(since, until, limit)
+}
+
+func topPlayedArtists(since, until time.Time, limit int) ([]PlayArtistStat, error) {
+	stats := []PlayArtistStat{}
+	err := countedPlays(since, until).Where("artist <> ''").
+		Select("artist, COUNT(*) AS plays, COUNT(DISTINCT source || ':' || song_id) AS tracks, SUM(position) AS seconds").
+		Group("artist").Order("plays DESC, seconds DESC, artist").
+		Limit(clampPlayHistoryLimit(limit, 10)).Scan(&stats).Error
+	return stats, err
+}
+
+// YearlyPlayRecap summarizes the listening of one calendar year in loc.
+func YearlyPlayRecap(year int, loc *time.Location, limit int) (*PlayRecap, error) {
+	if err := initPlayHistoryTable(); err != nil {
+		return nil, err
+	}
+	if loc == nil {
+		loc = time.Local
+	}
+	since := time.Date(year, time.January, 1, 0, 0, 0, 0, loc)
+	until := since.AddDate(1, 0, 0)
+	recap := &PlayRecap{Year: year, Months: make([]PlayMonthStat, 12)}
+	for i := range recap.Months {
+		recap.Months[i].Month = i + 1
+	}
+
+	var totals struct {
+		Plays   int
+		Seconds int
+		Tracks  int
+		Artists int
+	}
+	if err := countedPlays(since, until).
+		Select("COUNT(*) AS plays, COALESCE(SUM(position), 0) AS seconds, COUNT(DISTINCT source || ':' || song_id) AS tracks, COUNT(DISTINCT NULLIF(artist, '')) AS artists").
+		Scan(&totals).Error; err != nil {
+		return nil, err
+	}
+	recap.Plays, recap.Seconds, recap.Tracks, recap.Artists = totals.Plays, totals.Seconds, totals.Tracks, totals.Artists
+
+	var skips int64
+	if err := configDB.Model(&PlayHistory{}).Where("status = ? AND started_at >= ? AND started_at < ?", PlayStatusSkipped, since, until).
+		Where("position < ?", playHistoryCountedSeconds).Count(&skips).Error; err != nil {
+		return nil, err
+	}
+	recap.Skips = int(skips)
+
+	var err error
+	if recap.TopTracks, err = topPlayedTracks(since, until, limit); err != nil {
+		return nil, err
+	}
+	if recap.TopArtists, err = topPlayedArtists(since, until, limit); err != nil {
+		return nil, err
+	}
+	recap.Sources = []PlaySourceStat{}
+	if err := countedPlays(since, until).Select("source, COUNT(*) AS plays").
+		Group("source").Order("plays DESC, source").Scan(&recap.Sources).Error; err != nil {
+		return nil, err
+	}
+
+	// 按开始时间在 loc 下的日期归到月份和日，直接在 Go 里算，避免依赖 SQLite 的时区处理。
+	var starts []PlayHistory
+	if err := countedPlays(since, until).Select("id, started_at, position").Order("started_at, id").Find(&starts).Error; err != nil {
+		return nil, err
+	}
+	days := make(map[string]int)
+	for _, play := range starts {
+		local := play.StartedAt.In(loc)
+		month := &recap.Months[int(local.Month())-1]
+		month.Plays++
+		month.Seconds += play.Position
+		day := local.Format("2006-01-02")
+		days[day]++
+		if days[day] > recap.BusiestDayPlays {
+			recap.BusiestDay, recap.BusiestDayPlays = day, days[day]
+		}
+	}
+	if len(starts) > 0 {
+		var first PlayHistory
+		if err := configDB.First(&first, starts[0].ID).Error; err != nil {
+			return nil, err
+		}
+		recap.FirstPlay = &first
+	}
+	return recap, nil
+}
@@ -0,0 +1,143 @@
+package core
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func usePlayHistoryTestDB(t *testing.T) {
+	t.Helper()
+	t.Setenv("MUSIC_DL_CONFIG_DB", filepath.Join(t.TempDir(), "settings.db"))
+	resetConfigStateForTest()
+	t.Cleanup(resetConfigStateForTest)
+}
+
+func playForTest(t *testing.T, at time.Time, id, artist string, duration int, events ...PlayEvent) *PlayHistory {
+	t.Helper()
+	record, err := RecordPlayEvent(PlayEvent{Event: PlayEventStart, SongID: id, Source: "qq", Name: "Song " + id, Artist: artist, Duration: duration, Client: "web"}, at)
+	if err != nil || record == nil {
+		t.Fatalf("start %s: %+v %v", id, record, err)
+	}
+	for _, event := range events {
+		event.PlayID = record.ID
+		if record, err = RecordPlayEvent(event, at.Add(time.Minute)); err != nil {
+			t.Fatalf("%s %s: %v", event.Event, id, err)
+		}
+	}
+	return record
+}
+
+func TestPlayHistoryEventsStatsAndResume(t *testing.T) {
+	usePlayHistoryTestDB(t)
+	now := time.Now()
+
+	playForTest(t, now.Add(-3*time.Hour), "a", "Alpha", 200, PlayEvent{Event: PlayEventComplete})
+	playForTest(t, now.Add(-2*time.Hour), "a", "Alpha", 200, PlayEvent{Event: PlayEventProgress, Position: 90})
+	playForTest(t, now.Add(-time.Hour), "b", "Beta", 180, PlayEvent{Event: PlayEventSkip, Position: 5})
+	playForTest(t, now.Add(-40*24*time.Hour), "c", "Beta", 240, PlayEvent{Event: PlayEventComplete})
+	last := playForTest(t, now.Add(-30*time.Minute), "d", "Alpha", 0, PlayEvent{Event: PlayEventProgress, Position: 95, Duration: 100})
+	if last.Duration != 100 || last.Status != PlayStatusPlaying {
+		t.Fatalf("progress = %+v", last)
+	}
+
+	// 没有 play_id 的 complete 挂到该歌曲最近一次未结束的播放上。
+	record, err := RecordPlayEvent(PlayEvent{Event: PlayEventComplete, SongID: "d", Source: "qq", Position: 300}, now)
+	if err != nil || record.ID != last.ID || record.Status != PlayStatusCompleted || record.Position != 100 {
+		t.Fatalf("complete by song = %+v %v", record, err)
+	}
+	if _, err := RecordPlayEvent(PlayEvent{Event: "pause", SongID: "d", Source: "qq"}, now); err != ErrPlayHistoryBadEvent {
+		t.Fatalf("bad event err = %v", err)
+	}
+	if _, err := RecordPlayEvent(PlayEvent{Event: PlayEventStart, SongID: "e"}, now); err != ErrPlayHistoryNoSong {
+		t.Fatalf("start without song err = %v", err)
+	}
+
+	recent, total, err := RecentPlays(1, 2)
+	if err != nil || total != 4 || len(recent) != 2 || recent[0].SongID != "d" || recent[1].SongID != "b" {
+		t.Fatalf("recent = %+v total=%d %v", recent, total, err)
+	}
+
+	since, _ := PlayHistoryPeriodStart("week", now)
+	tracks, err := TopPlayedTracks(since, time.Time{}, 10)
+	if err != nil || len(tracks) != 2 || tracks[0].SongID != "a" || tracks[0].Plays != 2 || tracks[0].Seconds != 290 {
+		t.Fatalf("top tracks = %+v %v", tracks, err)
+	}
+	artists, err := TopPlayedArtists(time.Time{}, time.Time{}, 10)
+	if err != nil || len(artists) != 2 || artists[0].Artist != "Alpha" || artists[0].Plays != 3 || artists[0].Tracks != 2 || artists[1].Plays != 1 {
+		t.Fatalf("top artists = %+v %v", artists, err)
+	}
+
+	resume, err := ResumablePlays(10)
+	if err != nil || len(resume) != 1 || resume[0].SongID != "a" || resume[0].Position != 90 {
+		t.Fatalf("resume = %+v %v", resume, err)
+	}
+	if position, err := PlayResumePosition("qq", "a"); err != nil || position != 90 {
+		t.Fatalf("resume position = %d %v", position, err)
+	}
+	if position, _ := PlayResumePosition("qq", "d"); position != 0 {
+		t.Fatalf("finished song resume position = %d", position)
+	}
+}
+
+func TestPlayHistoryRecapRetentionAndClear(t *testing.T) {
+	usePlayHistoryTestDB(t)
+	loc := time.FixedZone("UTC+8", 8*3600)
+
+	playForTest(t, time.Date(2025, 3, 1, 9, 0, 0, 0, loc), "a", "Alpha", 200, PlayEvent{Event: PlayEventComplete})
+	playForTest(t, time.Date(2025, 3, 1, 20, 0, 0, 0, loc), "b", "Beta", 200, PlayEvent{Event: PlayEventComplete})
+	playForTest(t, time.Date(2025, 7, 2, 8, 0, 0, 0, loc), "a", "Alpha", 200, PlayEvent{Event: PlayEventProgress, Position: 60})
+	playForTest(t, time.Date(2025, 7, 3, 8, 0, 0, 0, loc), "c", "Gamma", 200, PlayEvent{Event: PlayEventSkip, Position: 3})
+	playForTest(t, time.Date(2024, 12, 31, 23, 0, 0, 0, loc), "z", "Old", 200, PlayEvent{Event: PlayEventComplete})
+
+	recap, err := YearlyPlayRecap(2025, loc, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if recap.Plays != 3 || recap.Skips != 1 || recap.Seconds != 460 || recap.Tracks != 2 || recap.Artists != 2 {
+		t.Fatalf("recap totals = %+v", recap)
+	}
+	if recap.Months[2].Plays != 2 || recap.Months[6].Plays != 1 || recap.BusiestDay != "2025-03-01" || recap.BusiestDayPlays != 2 {
+		t.Fatalf("recap months = %+v busiest %s/%d", recap.Months, recap.BusiestDay, recap.BusiestDayPlays)
+	}
+	if len(recap.TopTracks) == 0 || recap.TopTracks[0].SongID != "a" || recap.FirstPlay == nil || recap.FirstPlay.SongID != "a" {
+		t.Fatalf("recap tops = %+v first = %+v", recap.TopTracks, recap.FirstPlay)
+	}
+	if len(recap.Sources) != 1 || recap.Sources[0].Plays != 3 {
+		t.Fatalf("recap sources = %+v", recap.Sources)
+	}
+
+	settings := GetWebSettings()
+	settings.PlayHistoryRetentionDays = 30
+	if err := SaveWebSettings(settings); err != nil {
+		t.Fatal(err)
+	}
+	playForTest(t, time.Date(2025, 8, 1, 20, 0, 0, 0, loc), "d", "Delta", 200)
+	recent, total, err := RecentPlays(1, 10)
+	if err != nil || total != 2 {
+		t.Fatalf("after retention = %+v total=%d %v", recent, total, err)
+	}
+	var ids []string
+	for _, play := range recent {
+		ids = append(ids, play.SongID)
+	}
+	if strings.Join(ids, ",") != "d,c" {
+		t.Fatalf("kept plays = %v", ids)
+	}
+
+	settings.DisablePlayHistory = true
+	if err := SaveWebSettings(settings); err != nil {
+		t.Fatal(err)
+	}
+	if record, err := RecordPlayEvent(PlayEvent{Event: PlayEventStart, SongID: "e", Source: "qq", Name: "Song e"}, time.Now()); record != nil || err != nil {
+		t.Fatalf("disabled history recorded %+v %v", record, err)
+	}
+
+	if err := ClearPlayHistory(); err != nil {
+		t.Fatal(err)
+	}
+	if _, total, _ := RecentPlays(1, 10); total != 0 {
+		t.Fatalf("total after clear = %d", total)
+	}
+}
@@ -0,0 +1,574 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"math/rand/v2"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 共享播放会话：网页播放器、桌面 / 手机 App 和 TUI 共用的“正在播放”状态，
+// 包括播放队列、当前位置、随机和循环模式。会话存放在配置数据库里，任何一端
+// 都可以读取后接着播放，或者发送命令控制正在播放的那一端。
+
+const (
+	PlaybackRepeatOff = "off"
+	PlaybackRepeatAll = "all"
+	PlaybackRepeatOne = "one"
+
+	// Commands accepted by ApplyPlaybackCommand.
+	PlaybackActionReplace  = "replace"
+	PlaybackActionPlay     = "play"
+	PlaybackActionPause    = "pause"
+	PlaybackActionSeek     = "seek"
+	PlaybackActionProgress = "progress"
+	PlaybackActionNext     = "next"
+	PlaybackActionPrev     = "prev"
+	PlaybackActionEnded    = "ended"
+	PlaybackActionJump     = "jump"
+	PlaybackActionEnqueue  = "enqueue"
+	PlaybackActionRemove   = "remove"
+	PlaybackActionClear    = "clear"
+	PlaybackActionShuffle  = "shuffle"
+	PlaybackActionRepeat   = "repeat"
+
+	playbackSessionKey = "default"
+	playbackQueueMax   = 1000
+	// prev 在播放超过这个秒数时回到本曲开头，而不是上一首。
+	playbackPrevRestartSeconds = 3
+)
+
+var (
+	ErrPlaybackBadCommand = errors.New("invalid playback command")
+	// ErrPlaybackConflict is returned when a command carries a base revision
+	// that is no longer current; the caller should reload and retry.
+	ErrPlaybackConflict = errors.New("playback session changed")
+)
+
+// PlaybackQueueItem is one queued song, in the same shape the players report
+// play events with.
+type PlaybackQueueItem struct {
+	SongID   string `json:"id"`
+	Source   string `json:"source"`
+	Name     string `json:"name"`
+	Artist   string `json:"artist"`
+	Album    string `json:"album"`
+	Cover    string `json:"cover"`
+	Duration int    `json:"duration"`
+	Extra    string `json:"extra"`
+}
+
+// PlaybackSession is the shared "now playing" state. Position is in seconds
+// as of UpdatedAt; GetPlaybackSession advances it while Playing. Device is
+// the client that is playing, or last played, the session. Order is the
+// shuffled play order over queue indexes and is empty when Shuffle is off.
+type PlaybackSession struct {
+	Queue     []PlaybackQueueItem `json:"queue"`
+	Index     int                 `json:"index"`
+	Order     []int               `json:"order,omitempty"`
+	Position  float64             `json:"position"`
+	Playing   bool                `json:"playing"`
+	Shuffle   bool                `json:"shuffle"`
+	Repeat    string              `json:"repeat"`
+	Device    string              `json:"device"`
+	Revision  int64               `json:"revision"`
+	UpdatedAt time.Time           `json:"updated_at"`
+}
+
+// PlaybackCommand changes the session. Device identifies the sender; play
+// and ended move the session to that device. BaseRevision, when set, makes
+// the command fail with ErrPlaybackConflict if someone else changed the
+// session in between.
+type PlaybackCommand struct {
+	Action       string              `json:"action"`
+	Device       string              `json:"device"`
+	Index        *int                `json:"index"`
+	Position     *float64            `json:"position"`
+	Items        []PlaybackQueueItem `json:"items"`
+	Next         bool                `json:"next"`
+	Shuffle      *bool               `json:"shuffle"`
+	Repeat       string              `json:"repeat"`
+	BaseRevision int64               `json:"base_revision"`
+}
+
+// playbackSessionRow stores the session as one JSON document so the queue is
+// written in a single statement.
+type playbackSessionRow struct {
+	Name      string    `gorm:"primaryKey;size:32"`
+	State     string    `gorm:"type:text;not null"`
+	Revision  int64     `gorm:"not null"`
+	UpdatedAt time.Time `gorm:"autoUpdateTime:false"`
+}
+
+func (playbackSessionRow) TableName() string { return "playback_sessions" }
+
+var (
+	playbackSessionMu sync.Mutex
+
+	playbackSubscribersMu sync.Mutex
+	playbackSubscribers   = map[chan PlaybackSession]struct{}{}
+
+	// playbackShuffle is swapped in tests for a predictable order.
+	playbackShuffle = func(order []int) {
+		rand.Shuffle(len(order), func(i, j int) { order[i], order[j] = order[j], order[i] })
+	}
+)
+
+func initPlaybackSessionTable() error {
+	if err := ensureConfigDB(); err != nil {
+		return err
+	}
+	return configDB.AutoMigrate(&playbackSessionRow{})
+}
+
+// Current returns the queue item being played, or nil for an empty queue.
+func (s *PlaybackSession) Current() *PlaybackQueueItem {
+	if s.Index < 0 || s.Index >= len(s.Queue) {
+		return nil
+	}
+	return &s.Queue[s.Index]
+}
+
+// At returns the session with Position advanced to now when it is playing.
+// The position stops at the end of the current song: moving on is up to the
+// player, which reports ended.
+func (s PlaybackSession) At(now time.Time) PlaybackSession {
+	if !s.Playing || s.UpdatedAt.IsZero() || !now.After(s.UpdatedAt) {
+		return s
+	}
+	s.Position += now.Sub(s.UpdatedAt).Seconds()
+	if current := s.Current(); current != nil && current.Duration > 0 && s.Position > float64(current.Duration) {
+		s.Position = float64(current.Duration)
+	}
+	s.UpdatedAt = now
+	return s
+}
+
+func newPlaybackSession() PlaybackSession {
+	return PlaybackSession{Queue: []PlaybackQueueItem{}, Repeat: PlaybackRepeatAll}
+}
+
+func loadPlaybackSession(db *gorm.DB) (PlaybackSession, error) {
+	var row playbackSessionRow
+	err := db.Where("name = ?", playbackSessionKey).Limit(1).Find(&row).Error
+	if err != nil || row.Name == "" {
+		return newPlaybackSession(), err
+	}
+	session := newPlaybackSession()
+	if err := json.Unmarshal([]byte(row.State), &session); err != nil {
+		Logger().Warn("discard unreadable playback session", "error", err)
+		session = newPlaybackSession()
+	}
+	if session.Queue == nil {
+		session.Queue = []PlaybackQueueItem{}
+	}
+	session.Revision = row.Revision
+	session.UpdatedAt = row.UpdatedAt
+	return session, nil
+}
+
+// GetPlaybackSession returns the shared session as of now.
+func GetPlaybackSession(now time.Time) (PlaybackSession, error) {
+	if err := initPlaybackSessionTable(); err != nil {
+		return PlaybackSession{}, err
+	}
+	session, err := loadPlaybackSession(configDB)
+	if err != nil {
+		return PlaybackSession{}, err
+	}
+	return session.At(now), nil
+}
+
+// PlaybackSessionRevision returns the stored revision only. Servers poll it
+// to notice changes made by another process, such as the TUI.
+func PlaybackSessionRevision() (int64, error) {
+	if err := initPlaybackSessionTable(); err != nil {
+		return 0, err
+	}
+	var revisions []int64
+	err := configDB.Model(&playbackSessionRow{}).Where("name = ?", playbackSessionKey).Limit(1).Pluck("revision", &revisions).Error
+	if err != nil || len(revisions) == 0 {
+		return 0, err
+	}
+	return revisions[0], nil
+}
+
+// ApplyPlaybackCommand applies one command to the shared session, stores it
+// and notifies subscribers. On ErrPlaybackConflict the current session is
+// returned along with the error.
+func ApplyPlaybackCommand(cmd PlaybackCommand, now time.Time) (PlaybackSession, error) {
+	if err := initPlaybackSessionTable(); err != nil {
+		return PlaybackSession{}, err
+	}
+	cmd.Action = strings.ToLower(strings.TrimSpace(cmd.Action))
+	cmd.Device = cleanDownloadRecordText(cmd.Device)
+
+	playbackSessionMu.Lock()
+	defer playbackSessionMu.Unlock()
+
+	var session PlaybackSession
+	err := configDB.Transaction(func(tx *gorm.DB) error {
+		stored, err := loadPlaybackSession(tx)
+		if err != nil {
+			return err
+		}
+		session = stored.At(now)
+		if cmd.BaseRevision > 0 && cmd.BaseRevision != stored.Revision {
+			return ErrPlaybackConflict
+		}
+		changed, err := session.apply(cmd)
+		if err != nil || !changed {
+			return err
+		}
+		session.Revision = stored.Revision + 1
+		session.UpdatedAt = now
+		state, err := json.Marshal(session)
+		if err != nil {
+			return err
+		}
+		return tx.Save(&playbackSessionRow{Name: playbackSessionKey, State: string(state), Revision: session.Revision, UpdatedAt: now}).Error
+	})
+	if err != nil {
+		return session, err
+	}
+	publishPlaybackSession(session)
+	return session, nil
+}
+
+// apply mutates the session in place and reports whether anything changed.
+func (s *PlaybackSession) apply(cmd PlaybackCommand) (bool, error) {
+	switch cmd.Action {
+	case PlaybackActionReplace:
+		items, err := normalizePlaybackItems(cmd.Items)
+		if err != nil {
+			return false, err
+		}
+		if cmd.Repeat != "" {
+			if err := s.setRepeat(cmd.Repeat); err != nil {
+				return false, err
+			}
+		}
+		s.Queue = items
+		s.Index = 0
+		if cmd.Index != nil {
+			s.Index = *cmd.Index
+		}
+		if len(s.Queue) == 0 || s.Index < 0 || s.Index >= len(s.Queue) {
+			s.Index = 0
+		}
+		s.Position = 0
+		if cmd.Shuffle != nil {
+			s.Shuffle = *cmd.Shuffle
+		}
+		s.resetOrder()
+		if len(s.Queue) == 0 {
+			s.Playing = false
+		}
+	case PlaybackActionPlay:
+		if len(s.Queue) == 0 {
+			return false, ErrPlaybackBadCommand
+		}
+		if cmd.Index != nil {
+			if err := s.jump(*cmd.Index); err != nil {
+				return false, err
+			}
+		}
+		s.Playing = true
+		if cmd.Device != "" {
+			s.Device = cmd.Device
+		}
+	case PlaybackActionPause:
+		s.Playing = false
+	case PlaybackActionSeek:
+		if cmd.Position == nil {
+			return false, ErrPlaybackBadCommand
+		}
+	case PlaybackActionProgress:
+		// 只接受正在播放的那一端上报的进度，其他设备的旧进度直接忽略。
+		if cmd.Position == nil || (s.Device != "" && cmd.Device != s.Device) {
+			return false, nil
+		}
+	case PlaybackActionNext:
+		if !s.advance() {
+			return false, nil
+		}
+	case PlaybackActionPrev:
+		if s.Position > playbackPrevRestartSeconds || !s.retreat() {
+			s.Position = 0
+		}
+	case PlaybackActionEnded:
+		if cmd.Device != "" && s.Device != "" && cmd.Device != s.Device {
+			return false, nil
+		}
+		if s.Repeat == PlaybackRepeatOne {
+			s.Position = 0
+		} else if !s.advance() {
+			s.Playing = false
+			s.Position = 0
+		}
+	case PlaybackActionJump:
+		if cmd.Index == nil {
+			return false, ErrPlaybackBadCommand
+		}
+		if err := s.jump(*cmd.Index); err != nil {
+			return false, err
+		}
+	case PlaybackActionEnqueue:
+		items, err := normalizePlaybackItems(cmd.Items)
+		if err != nil || len(items) == 0 {
+			return false, ErrPlaybackBadCommand
+		}
+		s.enqueue(items, cmd.Next)
+	case PlaybackActionRemove:
+		if cmd.Index == nil || *cmd.Index < 0 || *cmd.Index >= len(s.Queue) {
+			return false, ErrPlaybackBadCommand
+		}
+		s.remove(*cmd.Index)
+	case PlaybackActionClear:
+		*s = PlaybackSession{Queue: []PlaybackQueueItem{}, Shuffle: s.Shuffle, Repeat: s.Repeat, Device: s.Device}
+	case PlaybackActionShuffle:
+		if cmd.Shuffle == nil {
+			return false, ErrPlaybackBadCommand
+		}
+		s.Shuffle = *cmd.Shuffle
+		s.resetOrder()
+	case PlaybackActionRepeat:
+		if err := s.setRepeat(cmd.Repeat); err != nil {
+			return false, err
+		}
+	default:
+		return false, ErrPlaybackBadCommand
+	}
+
+	if cmd.Position != nil {
+		position := *cmd.Position
+		if position < 0 {
+			position = 0
+		}
+		if current := s.Current(); current != nil && current.Duration > 0 && position > float64(current.Duration) {
+			position = float64(current.Duration)
+		}
+		s.Position = position
+	}
+	return true, nil
+}
+
+func (s *PlaybackSession) setRepeat(repeat string) error {
+	switch repeat = strings.ToLower(strings.TrimSpace(repeat)); repeat {
+	case PlaybackRepeatOff, PlaybackRepeatAll, PlaybackRepeatOne:
+		s.Repeat = repeat
+		return nil
+	}
+	return ErrPlaybackBadCommand
+}
+
+func (s *PlaybackSession) jump(index int) error {
+	if index < 0 || index >= len(s.Queue) {
+		return ErrPlaybackBadCommand
+	}
+	s.Index = index
+	s.Position = 0
+	return nil
+}
+
+// resetOrder rebuilds the shuffle order with the current song first, so
+// turning shuffle on never jumps away from what is playing.
+func (s *PlaybackSession) resetOrder() {
+	s.Order = nil
+	if !s.Shuffle || len(s.Queue) == 0 {
+		return
+	}
+	rest := make([]int, 0, len(s.Queue)-1)
+	for i := range s.Queue {
+		if i != s.Index {
+			rest = append(rest, i)
+		}
+	}
+	playbackShuffle(rest)
+	s.Order = append([]int{s.Index}, rest...)
+}
+
+// cursor is the position of the current song in the play order.
+func (s *PlaybackSession) cursor() int {
+	if !s.Shuffle {
+		return s.Index
+	}
+	for i, index := range s.Order {
+		if index == s.Index {
+			return i
+		}
+	}
+	return 0
+}
+
+func (s *PlaybackSession) orderAt(cursor int) int {
+	if s.Shuffle && cursor < len(s.Order) {
+		return s.Order[cursor]
+	}
+	return cursor
+}
+
+// advance moves to the next song in play order, wrapping at the end of the
+// queue unless repeat is off. Repeat one only affects ended, not next.
+func (s *PlaybackSession) advance() bool {
+	if len(s.Queue) == 0 {
+		return false
+	}
+	cursor := s.cursor() + 1
+	if cursor >= len(s.Queue) {
+		if s.Repeat == PlaybackRepeatOff {
+			return false
+		}
+		cursor = 0
+	}
+	s.Index = s.orderAt(cursor)
+	s.Position = 0
+	return true
+}
+
+func (s *PlaybackSession) retreat() bool {
+	if len(s.Queue) == 0 {
+		return false
+	}
+	cursor := s.cursor() - 1
+	if cursor < 0 {
+		if s.Repeat == PlaybackRepeatOff {
+			return false
+		}
+		cursor = len(s.Queue) - 1
+	}
+	s.Index = s.orderAt(cursor)
+	s.Position = 0
+	return true
+}
+
+func (s *PlaybackSession) enqueue(items []PlaybackQueueItem, next bool) {
+	room := playbackQueueMax - len(s.Queue)
+	if room <= 0 {
+		return
+	}
+	if len(items) > room {
+		items = items[:room]
+	}
+	insertAt := len(s.Queue)
+	if next && len(s.Queue) > 0 {
+		insertAt = s.Index + 1
+	}
+	queue := make([]PlaybackQueueItem, 0, len(s.Queue)+len(items))
+	queue = append(queue, s.Queue[:insertAt]...)
+	queue = append(queue, items...)
+	s.Queue = append(queue, s.Queue[insertAt:]...)
+
+	if !s.Shuffle {
+		return
+	}
+	// 随机模式下插入的歌排在当前歌曲之后（“下一首播放”）或顺序末尾。
+	for i := range s.Order {
+		if s.Order[i] >= insertAt {
+			s.Order[i] += len(items)
+		}
+	}
+	added := make([]int, len(items))
+	for i := range items {
+		added[i] = insertAt + i
+	}
+	if len(s.Order) == 0 {
+		s.Order = added
+		return
+	}
+	at := len(s.Order)
+	if next {
+		at = s.cursor() + 1
+	}
+	order := make([]int, 0, len(s.Order)+len(added))
+	order = append(order, s.Order[:at]...)
+	order = append(order, added...)
+	s.Order = append(order, s.Order[at:]...)
+}
+
+// remove drops one song. Removing the current song moves on to the song that
+// took its place, from the start.
+func (s *PlaybackSession) remove(index int) {
+	s.Queue = append(s.Queue[:index], s.Queue[index+1:]...)
+	if s.Shuffle {
+		order := s.Order[:0]
+		for _, i := range s.Order {
+			switch {
+			case i == index:
+			case i > index:
+				order = append(order, i-1)
+			default:
+				order = append(order, i)
+			}
+		}
+		s.Order = order
+	}
+	switch {
+	case len(s.Queue) == 0:
+		s.Index, s.Position, s.Playing = 0, 0, false
+	case index < s.Index:
+		s.Index--
+	case index == s.Index:
+		if s.Index >= len(s.Queue) {
+			s.Index = 0
+		}
+		s.Position = 0
+	}
+}
+
+func normalizePlaybackItems(items []PlaybackQueueItem) ([]PlaybackQueueItem, error) {
+	if len(items) > playbackQueueMax {
+		items = items[:playbackQueueMax]
+	}
+	normalized := make([]PlaybackQueueItem, 0, len(items))
+	for _, item := range items {
+		item.SongID = cleanDownloadRecordText(item.SongID)
+		item.Source = cleanDownloadRecordText(item.Source)
+		item.Name = cleanDownloadRecordText(item.Name)
+		item.Artist = cleanDownloadRecordText(item.Artist)
+		item.Album = cleanDownloadRecordText(item.Album)
+		item.Cover = strings.TrimSpace(item.Cover)
+		if item.SongID == "" || item.Source == "" || item.Name == "" {
+			return nil, ErrPlaybackBadCommand
+		}
+		if item.Duration < 0 {
+			item.Duration = 0
+		}
+		normalized = append(normalized, item)
+	}
+	return normalized, nil
+}
+
+// SubscribePlaybackSession delivers every session change made in this
+// process. The channel keeps only the latest state, so a slow reader skips
+// intermediate ones. Call cancel when done.
+func SubscribePlaybackSession() (<-chan PlaybackSession, func()) {
+	ch := make(chan PlaybackSession, 1)
+	playbackSubscribersMu.Lock()
+	playbackSubscribers[ch] = struct{}{}
+	playbackSubscribersMu.Unlock()
+	return ch, func() {
+		playbackSubscribersMu.Lock()
+		delete(playbackSubscribers, ch)
+		playbackSubscribersMu.Unlock()
+	}
+}
+
+func publishPlaybackSession(session PlaybackSession) {
+	playbackSubscribersMu.Lock()
+	defer playbackSubscribersMu.Unlock()
+	for ch := range playbackSubscribers {
+		select {
+		case <-ch:
+		default:
+		}
+		select {
+		case ch <- session:
+		default:
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package core
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+func playbackItemsForTest(ids ...string) []PlaybackQueueItem {
+	items := make([]PlaybackQueueItem, 0, len(ids))
+	for _, id := range ids {
+		items = append(items, PlaybackQueueItem{SongID: id, Source: "qq", Name: "Song " + id, Duration: 200})
+	}
+	return items
+}
+
+func playbackQueueIDs(session PlaybackSession) []string {
+	ids := make([]string, 0, len(session.Queue))
+	for _, item := range session.Queue {
+		ids = append(ids, item.SongID)
+	}
+	return ids
+}
+
+func applyPlaybackForTest(t *testing.T, cmd PlaybackCommand, now time.Time) PlaybackSession {
+	t.Helper()
+	session, err := ApplyPlaybackCommand(cmd, now)
+	if err != nil {
+		t.Fatalf("%s: %v", cmd.Action, err)
+	}
+	return session
+}
+
+func TestPlaybackSessionSharedAcrossDevices(t *testing.T) {
+	usePlayHistoryTestDB(t)
+	updates, cancel := SubscribePlaybackSession()
+	defer cancel()
+	now := time.Date(2025, 6, 1, 20, 0, 0, 0, time.UTC)
+	index, position := 1, 30.0
+
+	empty, err := GetPlaybackSession(now)
+	if err != nil || len(empty.Queue) != 0 || empty.Repeat != PlaybackRepeatAll || empty.Revision != 0 {
+		t.Fatalf("empty session = %+v %v", empty, err)
+	}
+	applyPlaybackForTest(t, PlaybackCommand{Action: PlaybackActionReplace, Items: playbackItemsForTest("a", "b", "c"), Index: &index}, now)
+	session := applyPlaybackForTest(t, PlaybackCommand{Action: PlaybackActionPlay, Device: "desktop", Position: &position}, now)
+	if session.Index != 1 || !session.Playing || session.Device != "desktop" || session.Position != 30 || session.Revision != 2 {
+		t.Fatalf("playing = %+v", session)
+	}
+	if got := <-updates; got.Revision != 2 {
+		t.Fatalf("subscriber got revision %d", got.Revision)
+	}
+
+	// 另一台设备读取时位置按经过的时间推算，并停在歌曲末尾。
+	if got, _ := GetPlaybackSession(now.Add(45 * time.Second)); got.Position != 75 {
+		t.Fatalf("position after 45s = %v", got.Position)
+	}
+	if got, _ := GetPlaybackSession(now.Add(time.Hour)); got.Position != 200 {
+		t.Fatalf("position capped = %v", got.Position)
+	}
+	if revision, err := PlaybackSessionRevision(); err != nil || revision != 2 {
+		t.Fatalf("revision = %d %v", revision, err)
+	}
+
+	stale := 10.0
+	if got := applyPlaybackForTest(t, PlaybackCommand{Action: PlaybackActionProgress, Device: "phone", Position: &stale}, now.Add(time.Minute)); got.Revision != 2 {
+		t.Fatalf("progress from idle device changed session: %+v", got)
+	}
+	if _, err := ApplyPlaybackCommand(PlaybackCommand{Action: PlaybackActionPause, BaseRevision: 1}, now); !errors.Is(err, ErrPlaybackConflict) {
+		t.Fatalf("stale base revision err = %v", err)
+	}
+
+	// 手机接管：从推算出的位置继续播放，会话归属切到手机。
+	takeover, _ := GetPlaybackSession(now.Add(time.Minute))
+	session = applyPlaybackForTest(t, PlaybackCommand{Action: PlaybackActionPlay, Device: "phone", Position: &takeover.Position, BaseRevision: takeover.Revision}, now.Add(time.Minute))
+	if session.Device != "phone" || session.Position != 90 || session.Index != 1 {
+		t.Fatalf("takeover = %+v", session)
+	}
+	if got := applyPlaybackForTest(t, PlaybackCommand{Action: PlaybackActionEnded, Device: "desktop"}, now.Add(2*time.Minute)); got.Index != 1 {
+		t.Fatalf("ended from previous device moved on: %+v", got)
+	}
+
+	session = applyPlaybackForTest(t, PlaybackCommand{Action: PlaybackActionPause, Device: "tui"}, now.Add(2*time.Minute))
+	if session.Playing || session.Position != 150 || session.Device != "phone" {
+		t.Fatalf("remote pause = %+v", session)
+	}
+	if got, _ := GetPlaybackSession(now.Add(time.Hour)); got.Position != 150 {
+		t.Fatalf("paused position moved to %v", got.Position)
+	}
+}
+
+func TestPlaybackSessionQueueNavigation(t *testing.T) {
+	usePlayHistoryTestDB(t)
+	now := time.Now()
+	at := func(i int) *int { return &i }
+
+	applyPlaybackForTest(t, PlaybackCommand{Action: PlaybackActionReplace, Items: playbackItemsForTest("a", "b", "c"), Repeat: PlaybackRepeatOff}, now)
+	applyPlaybackForTest(t, PlaybackCommand{Action: PlaybackActionPlay, Device: "web", Index: at(2)}, now)
+	session := applyPlaybackForTest(t, PlaybackCommand{Action: PlaybackActionEnded, Device: "web"}, now)
+	if session.Playing || session.Index != 2 {
+		t.Fatalf("ended at queue end with repeat off = %+v", session)
+	}
+	applyPlaybackForTest(t, PlaybackCommand{Action: PlaybackActionRepeat, Repeat: PlaybackRepeatAll}, now)
+	if session = applyPlaybackForTest(t, PlaybackCommand{Action: PlaybackActionNext}, now); session.Index != 0 {
+		t.Fatalf("next wraps with repeat all = %+v", session)
+	}
+	if session = applyPlaybackForTest(t, PlaybackCommand{Action: PlaybackActionPrev}, now); session.Index != 2 {
+		t.Fatalf("prev wraps = %+v", session)
+	}
+	position := 20.0
+	applyPlaybackForTest(t, PlaybackCommand{Action: PlaybackActionSeek, Position: &position}, now)
+	if session = applyPlaybackForTest(t, PlaybackCommand{Action: PlaybackActionPrev}, now); session.Index != 2 || session.Position != 0 {
+		t.Fatalf("prev after 3s restarts = %+v", session)
+	}
+	applyPlaybackForTest(t, PlaybackCommand{Action: PlaybackActionRepeat, Repeat: PlaybackRepeatOne}, now)
+	applyPlaybackForTest(t, PlaybackCommand{Action: PlaybackActionPlay, Device: "web"}, now)
+	if session = applyPlaybackForTest(t, PlaybackCommand{Action: PlaybackActionEnded, Device: "web"}, now); session.Index != 2 || !session.Playing {
+		t.Fatalf("ended with repeat one = %+v", session)
+	}
+
+	session = applyPlaybackForTest(t, PlaybackCommand{Action: PlaybackActionEnqueue, Items: playbackItemsForTest("d"), Next: true}, now)
+	session = applyPlaybackForTest(t, PlaybackCommand{Action: PlaybackActionEnqueue, Items: playbackItemsForTest("e")}, now)
+	if ids := playbackQueueIDs(session); !slices.Equal(ids, []string{"a", "b", "c", "d", "e"}) || session.Index != 2 {
+		t.Fatalf("enqueue = %v index %d", ids, session.Index)
+	}
+	session = applyPlaybackForTest(t, PlaybackCommand{Action: PlaybackActionRemove, Index: at(0)}, now)
+	if session.Index != 1 || session.Queue[session.Index].SongID != "c" {
+		t.Fatalf("remove before current = %+v", session)
+	}
+	session = applyPlaybackForTest(t, PlaybackCommand{Action: PlaybackActionRemove, Index: at(1)}, now)
+	if session.Index != 1 || session.Queue[session.Index].SongID != "d" {
+		t.Fatalf("remove current = %+v", session)
+	}
+
+	for _, cmd := range []PlaybackCommand{
+		{Action: "rewind"},
+		{Action: PlaybackActionJump, Index: at(9)},
+		{Action: PlaybackActionRepeat, Repeat: "twice"},
+		{Action: PlaybackActionEnqueue, Items: []PlaybackQueueItem{{Name: "no id"}}},
+		{Action: PlaybackActionRemove},
+	} {
+		if _, err := ApplyPlaybackCommand(cmd, now); !errors.Is(err, ErrPlaybackBadCommand) {
+			t.Errorf("%+v err = %v", cmd, err)
+		}
+	}
+
+	session = applyPlaybackForTest(t, PlaybackCommand{Action: PlaybackActionClear}, now)
+	if len(session.Queue) != 0 || session.Playing || session.Repeat != PlaybackRepeatOne {
+		t.Fatalf("clear = %+v", session)
+	}
+	if _, err := ApplyPlaybackCommand(PlaybackCommand{Action: PlaybackActionPlay}, now); !errors.Is(err, ErrPlaybackBadCommand) {
+		t.Fatalf("play empty queue err = %v", err)
+	}
+}
+
+func TestPlaybackSessionShuffleOrder(t *testing.T) {
+	usePlayHistoryTestDB(t)
+	previous := playbackShuffle
+	playbackShuffle = slices.Reverse[[]int]
+	t.Cleanup(func() { playbackShuffle = previous })
+	now := time.Now()
+	on := true
+	index := 1
+
+	session := applyPlaybackForTest(t, PlaybackCommand{Action: PlaybackActionReplace, Items: playbackItemsForTest("a", "b", "c", "d"), Index: &index, Shuffle: &on}, now)
+	if !slices.Equal(session.Order, []int{1, 3, 2, 0}) {
+		t.Fatalf("order = %v", session.Order)
+	}
+	var played []string
+	for range 4 {
+		session = applyPlaybackForTest(t, PlaybackCommand{Action: PlaybackActionNext}, now)
+		played = append(played, session.Queue[session.Index].SongID)
+	}
+	if !slices.Equal(played, []string{"d", "c", "a", "b"}) {
+		t.Fatalf("shuffled play order = %v", played)
+	}
+
+	session = applyPlaybackForTest(t, PlaybackCommand{Action: PlaybackActionEnqueue, Items: playbackItemsForTest("e"), Next: true}, now)
+	if next := applyPlaybackForTest(t, PlaybackCommand{Action: PlaybackActionNext}, now); next.Queue[next.Index].SongID != "e" {
+		t.Fatalf("play next in shuffle = %+v", next)
+	}
+	off := false
+	session = applyPlaybackForTest(t, PlaybackCommand{Action: PlaybackActionShuffle, Shuffle: &off}, now)
+	if len(session.Order) != 0 || session.Queue[session.Index].SongID != "e" {
+		t.Fatalf("shuffle off = %+v", session)
+	}
+}
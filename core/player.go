@@ -6,6 +6,7 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/guohuiyuan/music-lib/model"
@@ -57,14 +58,75 @@ func playbackReferer(source string) string {
 
 // PlaybackArgs 构造 ffplay 的命令行参数，注入与下载一致的 UA / Referer / Cookie。
 func PlaybackArgs(song *model.Song, urlStr string) []string {
+	return PlaybackArgsAt(song, urlStr, PlaybackOptions{Volume: 100})
+}
+
+// PlaybackOptions 是 ffplay 的起始位置、音量和响度均衡设置。
+type PlaybackOptions struct {
+	Start  float64 // 起始秒数
+	Volume int     // 0-100
+	// ReplayGain 是 WebSettings.ReplayGainMode，开启时按 ReplayGainFilter 均衡音量。
+	ReplayGain string
+}
+
+// PlaybackArgsAt 在 PlaybackArgs 的基础上带上 opts，一次 ffplay 从 opts.Start
+// 播到结束。
+func PlaybackArgsAt(song *model.Song, urlStr string, opts PlaybackOptions) []string {
 	args := []string{"-nodisp", "-autoexit", "-loglevel", "quiet"}
+	if opts.Start > 0 {
+		args = append(args, "-ss", strconv.FormatFloat(opts.Start, 'f', 1, 64))
+	}
+	if opts.Volume < 100 {
+		args = append(args, "-volume", strconv.Itoa(max(opts.Volume, 0)))
+	}
+	if filter := ReplayGainFilter(song, opts.ReplayGain); filter != "" {
+		args = append(args, "-af", filter)
+	}
+	args = append(args, playbackRequestArgs(song)...)
+	args = append(args, urlStr)
+	return args
+}
 
+// PlaybackPCMRate 和 PlaybackPCMChannels 是 PlaybackDecodeArgs 输出的
+// s16le PCM 的采样率和声道数。
+const (
+	PlaybackPCMRate     = 44100
+	PlaybackPCMChannels = 2
+)
+
+// PlaybackDecodeArgs 构造 ffmpeg 的命令行参数：从 opts.Start 开始把音频解码成
+// s16le PCM 写到标准输出，响度均衡在这里做，音量（opts.Volume）留给调用方
+// 在写给播放器之前调整。
+func PlaybackDecodeArgs(song *model.Song, urlStr string, opts PlaybackOptions) []string {
+	args := []string{"-nostdin", "-loglevel", "error"}
+	if opts.Start > 0 {
+		args = append(args, "-ss", strconv.FormatFloat(opts.Start, 'f', 1, 64))
+	}
+	args = append(args, playbackRequestArgs(song)...)
+	args = append(args, "-i", urlStr, "-vn")
+	if filter := ReplayGainFilter(song, opts.ReplayGain); filter != "" {
+		args = append(args, "-af", filter)
+	}
+	return append(args,
+		"-f", "s16le", "-acodec", "pcm_s16le",
+		"-ac", strconv.Itoa(PlaybackPCMChannels), "-ar", strconv.Itoa(PlaybackPCMRate),
+		"pipe:1")
+}
+
+// PlaybackSinkArgs 构造从标准输入读 WAV 流播放的 ffplay 参数，配合
+// PlaybackDecodeArgs 使用：暂停、跳转和调音量都不用重启 ffplay。
+func PlaybackSinkArgs() []string {
+	return []string{"-nodisp", "-autoexit", "-loglevel", "quiet", "-f", "wav", "-i", "pipe:0"}
+}
+
+// playbackRequestArgs 注入与下载一致的 UA / Referer / Cookie。
+func playbackRequestArgs(song *model.Song) []string {
 	source := ""
 	if song != nil {
 		source = song.Source
 	}
 
-	args = append(args, "-user_agent", playbackUserAgent(source))
+	args := []string{"-user_agent", playbackUserAgent(source)}
 
 	var headers strings.Builder
 	if referer := playbackReferer(source); referer != "" {
@@ -76,8 +138,6 @@ func PlaybackArgs(song *model.Song, urlStr string) []string {
 	if headers.Len() > 0 {
 		args = append(args, "-headers", headers.String())
 	}
-
-	args = append(args, urlStr)
 	return args
 }
 
@@ -52,6 +52,48 @@ func TestPlaybackArgsMiguUsesMobileUA(t *testing.T) {
 	}
 }
 
+func TestPlaybackArgsAtSetsStartAndVolume(t *testing.T) {
+	song := &model.Song{ID: "2", Source: "kuwo"}
+	args := PlaybackArgsAt(song, "http://example.com/c.mp3", PlaybackOptions{Start: 72.5, Volume: 40})
+	if idx := indexOf(args, "-ss"); idx < 0 || args[idx+1] != "72.5" {
+		t.Fatalf("expected -ss 72.5, got %v", args)
+	}
+	if idx := indexOf(args, "-volume"); idx < 0 || args[idx+1] != "40" {
+		t.Fatalf("expected -volume 40, got %v", args)
+	}
+	if args[len(args)-1] != "http://example.com/c.mp3" {
+		t.Fatalf("url must be last arg, got %v", args)
+	}
+
+	// 从头播放、满音量时不带多余参数。
+	args = PlaybackArgs(song, "http://example.com/c.mp3")
+	if indexOf(args, "-ss") >= 0 || indexOf(args, "-volume") >= 0 {
+		t.Fatalf("unexpected start/volume flags: %v", args)
+	}
+}
+
+func TestPlaybackDecodeArgsWritePCMForTheSink(t *testing.T) {
+	song := &model.Song{ID: "3", Source: "netease"}
+	args := PlaybackDecodeArgs(song, "http://example.com/d.mp3", PlaybackOptions{Start: 12, Volume: 40})
+	if idx := indexOf(args, "-ss"); idx < 0 || args[idx+1] != "12.0" || idx > indexOf(args, "-i") {
+		t.Fatalf("expected -ss before the input, got %v", args)
+	}
+	if idx := indexOf(args, "-i"); idx < 0 || args[idx+1] != "http://example.com/d.mp3" || indexOf(args, "-user_agent") > idx {
+		t.Fatalf("expected request options before the input, got %v", args)
+	}
+	// 音量由播放端缩放，解码参数里不带。
+	if indexOf(args, "-volume") >= 0 || indexOf(args, "volume") >= 0 {
+		t.Fatalf("unexpected volume flags: %v", args)
+	}
+	joined := strings.Join(args, " ")
+	if !strings.Contains(joined, "-f s16le") || !strings.Contains(joined, "-ar 44100") || args[len(args)-1] != "pipe:1" {
+		t.Fatalf("expected s16le PCM on stdout, got %v", args)
+	}
+	if sink := strings.Join(PlaybackSinkArgs(), " "); !strings.Contains(sink, "-nodisp") || !strings.HasSuffix(sink, "-f wav -i pipe:0") {
+		t.Fatalf("unexpected sink args: %s", sink)
+	}
+}
+
 func TestResolveFFplayPathUsesEnv(t *testing.T) {
 	dir := t.TempDir()
 	tool := filepath.Join(dir, "ffplay")
@@ -0,0 +1,331 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+type scrobbleStubRequest struct {
+	Path    string
+	Header  http.Header
+	Payload map[string]any
+	Form    url.Values
+}
+
+type scrobbleStubResponse struct {
+	Status int
+	Body   string
+}
+
+// scrobbleStub stands in for ListenBrainz and Last.fm: it records JSON or form
+// submissions and answers with the queued responses (200 once empty).
+type scrobbleStub struct {
+	*httptest.Server
+	mu        sync.Mutex
+	responses []scrobbleStubResponse
+	requests  []scrobbleStubRequest
+}
+
+func newScrobbleStub(t *testing.T, responses ...scrobbleStubResponse) *scrobbleStub {
+	t.Helper()
+	stub := &scrobbleStub{responses: responses}
+	stub.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		request := scrobbleStubRequest{Path: r.URL.Path, Header: r.Header.Clone()}
+		if r.Header.Get("Content-Type") == "application/x-www-form-urlencoded" {
+			request.Form, _ = url.ParseQuery(string(body))
+		} else {
+			_ = json.Unmarshal(body, &request.Payload)
+		}
+
+		stub.mu.Lock()
+		stub.requests = append(stub.requests, request)
+		response := scrobbleStubResponse{Status: http.StatusOK, Body: `{"status":"ok"}`}
+		if len(stub.responses) > 0 {
+			response = stub.responses[0]
+			stub.responses = stub.responses[1:]
+		}
+		stub.mu.Unlock()
+		w.WriteHeader(response.Status)
+		_, _ = io.WriteString(w, response.Body)
+	}))
+	t.Cleanup(stub.Close)
+	return stub
+}
+
+func (s *scrobbleStub) received() []scrobbleStubRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]scrobbleStubRequest(nil), s.requests...)
+}
+
+func setupScrobbleTest(t *testing.T) {
+	t.Helper()
+	usePlayHistoryTestDB(t)
+	previousDelays := scrobbleRetryDelays
+	scrobbleRetryDelays = []time.Duration{time.Hour}
+	t.Cleanup(func() { scrobbleRetryDelays = previousDelays })
+	t.Cleanup(func() { WaitScrobbles(5 * time.Second) })
+}
+
+func waitScrobblesForTest(t *testing.T) {
+	t.Helper()
+	if !WaitScrobbles(5 * time.Second) {
+		t.Fatal("scrobble submissions did not finish")
+	}
+}
+
+func queuedScrobblesForTest(t *testing.T) []ScrobbleQueueEntry {
+	t.Helper()
+	var entries []ScrobbleQueueEntry
+	if err := configDB.Order("id ASC").Find(&entries).Error; err != nil {
+		t.Fatal(err)
+	}
+	return entries
+}
+
+func TestScrobbleQualifies(t *testing.T) {
+	cases := []struct {
+		duration, played int
+		want             bool
+	}{
+		{200, 100, true},
+		{200, 99, false},
+		{600, 240, true},
+		{600, 239, false},
+		{30, 30, false},
+		{0, 239, false},
+		{0, 240, true},
+	}
+	for _, tc := range cases {
+		if got := ScrobbleQualifies(tc.duration, tc.played); got != tc.want {
+			t.Errorf("ScrobbleQualifies(%d, %d) = %v, want %v", tc.duration, tc.played, got, tc.want)
+		}
+	}
+}
+
+func TestSaveScrobbleTargetValidates(t *testing.T) {
+	setupScrobbleTest(t)
+
+	invalid := []ScrobbleTarget{
+		{Kind: ScrobbleKindListenBrainz},
+		{Kind: ScrobbleKindLastFM, Token: "sk", APIKey: "key"},
+		{Kind: "spotify", Token: "t"},
+		{Kind: ScrobbleKindListenBrainz, Token: "t", URL: "ftp://example.com"},
+	}
+	for _, target := range invalid {
+		if _, err := SaveScrobbleTarget(target); !errors.Is(err, ErrInvalidScrobbleTarget) {
+			t.Errorf("SaveScrobbleTarget(%+v) err = %v", target, err)
+		}
+	}
+
+	saved, err := SaveScrobbleTarget(ScrobbleTarget{Token: " t ", Enabled: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.Kind != ScrobbleKindListenBrainz || saved.URL != DefaultListenBrainzURL || saved.Name != "api.listenbrainz.org" || saved.Token != "t" {
+		t.Fatalf("saved = %+v", saved)
+	}
+	if _, err := SaveScrobbleTarget(ScrobbleTarget{ID: 99, Token: "t"}); !errors.Is(err, ErrScrobbleTargetNotFound) {
+		t.Fatalf("update missing err = %v", err)
+	}
+	if err := DeleteScrobbleTarget(saved.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := DeleteScrobbleTarget(saved.ID); !errors.Is(err, ErrScrobbleTargetNotFound) {
+		t.Fatalf("second delete err = %v", err)
+	}
+}
+
+func TestPlayEventsScrobbleToListenBrainzAndLastFM(t *testing.T) {
+	setupScrobbleTest(t)
+	listenBrainz := newScrobbleStub(t)
+	lastFM := newScrobbleStub(t)
+
+	if _, err := SaveScrobbleTarget(ScrobbleTarget{Name: "Maloja", URL: listenBrainz.URL + "/apis/listenbrainz/", Token: "lb-token", Enabled: true}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := SaveScrobbleTarget(ScrobbleTarget{Kind: ScrobbleKindLastFM, URL: lastFM.URL + "/2.0/", APIKey: "key", APISecret: "secret", Token: "session", Enabled: true}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := SaveScrobbleTarget(ScrobbleTarget{Name: "off", URL: listenBrainz.URL, Token: "unused"}); err != nil {
+		t.Fatal(err)
+	}
+
+	startedAt := time.Date(2025, 5, 1, 12, 0, 0, 0, time.UTC)
+	song := PlayEvent{SongID: "1", Source: "qq", Name: "Song", Artist: "Alpha", Album: "Album", Duration: 200}
+	start := song
+	start.Event = PlayEventStart
+	record, err := RecordPlayEvent(start, startedAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitScrobblesForTest(t)
+
+	progress := PlayEvent{Event: PlayEventProgress, PlayID: record.ID, Position: 60}
+	if _, err := RecordPlayEvent(progress, startedAt.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	waitScrobblesForTest(t)
+	if got := len(listenBrainz.received()) + len(lastFM.received()); got != 2 {
+		t.Fatalf("requests before reaching half = %d, want 2 now playing", got)
+	}
+
+	// 过半后提交一次，之后的 complete 不再重复提交。
+	progress.Position = 100
+	if _, err := RecordPlayEvent(progress, startedAt.Add(2*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	waitScrobblesForTest(t)
+	if _, err := RecordPlayEvent(PlayEvent{Event: PlayEventComplete, PlayID: record.ID}, startedAt.Add(4*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	waitScrobblesForTest(t)
+
+	lb := listenBrainz.received()
+	if len(lb) != 2 {
+		t.Fatalf("ListenBrainz requests = %+v", lb)
+	}
+	if lb[0].Path != "/apis/listenbrainz/1/submit-listens" || lb[0].Header.Get("Authorization") != "Token lb-token" || lb[0].Payload["listen_type"] != "playing_now" {
+		t.Fatalf("now playing = %+v", lb[0])
+	}
+	listen := lb[1].Payload["payload"].([]any)[0].(map[string]any)
+	metadata := listen["track_metadata"].(map[string]any)
+	info := metadata["additional_info"].(map[string]any)
+	if lb[1].Payload["listen_type"] != "single" || listen["listened_at"] != float64(startedAt.Unix()) ||
+		metadata["artist_name"] != "Alpha" || metadata["track_name"] != "Song" || metadata["release_name"] != "Album" || info["duration_ms"] != float64(200000) {
+		t.Fatalf("listen = %+v", lb[1].Payload)
+	}
+
+	fm := lastFM.received()
+	if len(fm) != 2 || fm[0].Form.Get("method") != "track.updateNowPlaying" || fm[1].Form.Get("method") != "track.scrobble" {
+		t.Fatalf("Last.fm requests = %+v", fm)
+	}
+	form := fm[1].Form
+	if form.Get("timestamp") != strconv.FormatInt(startedAt.Unix(), 10) || form.Get("sk") != "session" || form.Get("duration") != "200" || form.Get("format") != "json" {
+		t.Fatalf("scrobble form = %v", form)
+	}
+	params := map[string]string{}
+	for key := range form {
+		if key != "api_sig" && key != "format" {
+			params[key] = form.Get(key)
+		}
+	}
+	if form.Get("api_sig") != lastFMSignature(params, "secret") || len(form.Get("api_sig")) != 32 {
+		t.Fatalf("api_sig = %q", form.Get("api_sig"))
+	}
+	if entries := queuedScrobblesForTest(t); len(entries) != 0 {
+		t.Fatalf("queue after success = %+v", entries)
+	}
+}
+
+func TestScrobbleWithoutPlayHistoryOnlyOnFinish(t *testing.T) {
+	setupScrobbleTest(t)
+	stub := newScrobbleStub(t)
+	if _, err := SaveScrobbleTarget(ScrobbleTarget{URL: stub.URL, Token: "t", Enabled: true}); err != nil {
+		t.Fatal(err)
+	}
+	settings := GetWebSettings()
+	settings.DisablePlayHistory = true
+	if err := SaveWebSettings(settings); err != nil {
+		t.Fatal(err)
+	}
+
+	at := time.Date(2025, 5, 1, 12, 0, 0, 0, time.UTC)
+	song := PlayEvent{SongID: "1", Source: "qq", Name: "Song", Artist: "Alpha", Duration: 200}
+	for _, event := range []string{PlayEventProgress, PlayEventSkip, PlayEventComplete} {
+		song.Event = event
+		song.Position = 20
+		if record, err := RecordPlayEvent(song, at); record != nil || err != nil {
+			t.Fatalf("%s = %+v %v", event, record, err)
+		}
+	}
+	waitScrobblesForTest(t)
+
+	requests := stub.received()
+	if len(requests) != 1 {
+		t.Fatalf("requests = %+v", requests)
+	}
+	listen := requests[0].Payload["payload"].([]any)[0].(map[string]any)
+	if listen["listened_at"] != float64(at.Add(-200*time.Second).Unix()) {
+		t.Fatalf("listened_at = %v", listen["listened_at"])
+	}
+}
+
+func TestScrobbleQueueRetriesWhenOfflineAndDropsRejected(t *testing.T) {
+	setupScrobbleTest(t)
+	stub := newScrobbleStub(t,
+		scrobbleStubResponse{Status: http.StatusServiceUnavailable},
+		scrobbleStubResponse{Status: http.StatusOK},
+		scrobbleStubResponse{Status: http.StatusBadRequest, Body: `{"error":"bad listen"}`},
+	)
+	target, err := SaveScrobbleTarget(ScrobbleTarget{URL: stub.URL, Token: "t", Enabled: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 第一首遇到 503 后，同一轮里不再向这个服务提交第二首。
+	now := time.Now()
+	for i, name := range []string{"One", "Two"} {
+		listenedAt := now.Add(time.Duration(i-10) * time.Minute)
+		entry := ScrobbleQueueEntry{TargetID: target.ID, Artist: "Alpha", Track: name, ListenedAt: listenedAt, NextAttemptAt: listenedAt}
+		if err := configDB.Create(&entry).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	result, err := FlushScrobbleQueue(context.Background(), now)
+	if err != nil || result.Sent != 0 || result.Pending != 2 {
+		t.Fatalf("offline flush = %+v %v", result, err)
+	}
+	if got := len(stub.received()); got != 1 {
+		t.Fatalf("requests while offline = %d", got)
+	}
+	entries := queuedScrobblesForTest(t)
+	if len(entries) != 2 || entries[0].Attempts != 1 || entries[0].LastError == "" || entries[1].Attempts != 0 {
+		t.Fatalf("queue while offline = %+v", entries)
+	}
+	if !entries[0].NextAttemptAt.After(now.Add(59 * time.Minute)) {
+		t.Fatalf("next attempt = %v", entries[0].NextAttemptAt)
+	}
+	stats, err := ScrobbleQueueStats()
+	if err != nil || stats[target.ID].Pending != 2 || stats[target.ID].LastError == "" {
+		t.Fatalf("stats = %+v %v", stats, err)
+	}
+
+	result, err = FlushScrobbleQueue(context.Background(), now.Add(2*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Sent != 1 || result.Dropped != 1 || result.Pending != 0 {
+		t.Fatalf("flush = %+v", result)
+	}
+	requests := stub.received()
+	if len(requests) != 3 {
+		t.Fatalf("requests = %d", len(requests))
+	}
+	sent := requests[1].Payload["payload"].([]any)[0].(map[string]any)["track_metadata"].(map[string]any)
+	if sent["track_name"] != "One" {
+		t.Fatalf("retried out of order: %+v", sent)
+	}
+}
+
+func TestLastFMErrorCodes(t *testing.T) {
+	if err := lastFMResponseError(http.StatusOK, []byte(`{"scrobbles":{}}`)); err != nil {
+		t.Fatalf("ok err = %v", err)
+	}
+	if err := lastFMResponseError(http.StatusOK, []byte(`{"error":6,"message":"Invalid parameters"}`)); !errors.Is(err, errScrobbleRejected) {
+		t.Fatalf("error 6 = %v", err)
+	}
+	if err := lastFMResponseError(http.StatusServiceUnavailable, []byte(`{"error":16,"message":"try again"}`)); err == nil || errors.Is(err, errScrobbleRejected) {
+		t.Fatalf("error 16 = %v", err)
+	}
+}
@@ -0,0 +1,102 @@
+package core
+
+import (
+	_ "embed"
+	"strings"
+	"sync"
+)
+
+//go:generate go run ./searchdata/gen
+
+// 汉字拼音与繁简对照表由 searchdata/gen 借助 ICU 生成，覆盖 CJK 基本区。
+// 多音字以 "~字 默认读音 其他读音" 单独列出。
+var (
+	//go:embed searchdata/pinyin.txt
+	hanPinyinTable string
+	//go:embed searchdata/t2s.txt
+	hanT2STable string
+
+	hanTablesOnce sync.Once
+	hanPinyin     map[rune][]string
+	hanT2S        map[rune]rune
+)
+
+func loadHanTables() {
+	hanTablesOnce.Do(func() {
+		hanPinyin = make(map[rune][]string, 21000)
+		for _, line := range strings.Split(hanPinyinTable, "\n") {
+			if line == "" || line[0] == '#' {
+				continue
+			}
+			fields := strings.Fields(line)
+			if len(fields) < 2 {
+				continue
+			}
+			if strings.HasPrefix(fields[0], "~") {
+				for _, r := range strings.TrimPrefix(fields[0], "~") {
+					hanPinyin[r] = fields[1:]
+				}
+				continue
+			}
+			reading := []string{fields[0]}
+			for _, r := range fields[1] {
+				if _, ok := hanPinyin[r]; !ok {
+					hanPinyin[r] = reading
+				}
+			}
+		}
+
+		hanT2S = make(map[rune]rune, 3000)
+		for _, line := range strings.Split(hanT2STable, "\n") {
+			if line == "" || line[0] == '#' {
+				continue
+			}
+			pair := []rune(line)
+			if len(pair) == 2 {
+				hanT2S[pair[0]] = pair[1]
+			}
+		}
+	})
+}
+
+// FoldChineseVariants maps traditional Chinese characters to their simplified
+// forms character by character, e.g. "周杰倫" -> "周杰伦". Everything else is
+// left untouched.
+func FoldChineseVariants(s string) string {
+	loadHanTables()
+	var b strings.Builder
+	changed := false
+	for i, r := range s {
+		simplified, ok := hanT2S[r]
+		if !ok {
+			if changed {
+				b.WriteRune(r)
+			}
+			continue
+		}
+		if !changed {
+			b.Grow(len(s))
+			b.WriteString(s[:i])
+			changed = true
+		}
+		b.WriteRune(simplified)
+	}
+	if !changed {
+		return s
+	}
+	return b.String()
+}
+
+// HanPinyin returns the toneless pinyin readings of a Han character, most
+// common first, or nil when the rune has none. Traditional characters share
+// the readings of their simplified form.
+func HanPinyin(r rune) []string {
+	loadHanTables()
+	if readings, ok := hanPinyin[r]; ok {
+		return readings
+	}
+	if simplified, ok := hanT2S[r]; ok {
+		return hanPinyin[simplified]
+	}
+	return nil
+}
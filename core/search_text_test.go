@@ -0,0 +1,36 @@
+package core
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFoldChineseVariants(t *testing.T) {
+	cases := map[string]string{
+		"周杰倫":        "周杰伦",
+		"陳奕迅 - 愛情轉移": "陈奕迅 - 爱情转移",
+		"周杰伦":        "周杰伦",
+		"Jay Chou":   "Jay Chou",
+		"":           "",
+	}
+	for in, want := range cases {
+		if got := FoldChineseVariants(in); got != want {
+			t.Fatalf("FoldChineseVariants(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestHanPinyin(t *testing.T) {
+	cases := map[rune][]string{
+		'周': {"zhou"},
+		'倫': {"lun"},
+		'长': {"chang", "zhang"},
+		'乐': {"le", "yue"},
+		'a': nil,
+	}
+	for r, want := range cases {
+		if got := HanPinyin(r); !reflect.DeepEqual(got, want) {
+			t.Fatalf("HanPinyin(%q) = %v, want %v", r, got, want)
+		}
+	}
+}
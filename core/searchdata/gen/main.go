@@ -0,0 +1,155 @@
+// Package main generates the pinyin and traditional-to-simplified tables used
+// by local music search. It needs ICU's uconv on PATH; the output is checked in
+// so normal builds do not.
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+)
+
+// 常见多音字：ICU 只给出一个读音，这里按“首个为默认读音”补全，
+// 让“zc”能找到长城、“yy”能找到音乐。
+var polyphones = map[rune][]string{
+	'长': {"chang", "zhang"},
+	'乐': {"le", "yue"},
+	'重': {"zhong", "chong"},
+	'行': {"xing", "hang"},
+	'还': {"hai", "huan"},
+	'传': {"chuan", "zhuan"},
+	'朝': {"chao", "zhao"},
+	'曾': {"zeng", "ceng"},
+	'单': {"dan", "shan", "chan"},
+	'了': {"le", "liao"},
+	'的': {"de", "di"},
+	'地': {"di", "de"},
+	'得': {"de", "dei"},
+	'和': {"he", "huo", "hu"},
+	'说': {"shuo", "shui"},
+	'藏': {"cang", "zang"},
+	'调': {"diao", "tiao"},
+	'弹': {"tan", "dan"},
+	'薄': {"bo", "bao"},
+	'会': {"hui", "kuai"},
+	'觉': {"jue", "jiao"},
+	'角': {"jiao", "jue"},
+	'降': {"jiang", "xiang"},
+	'差': {"cha", "chai", "ci"},
+	'都': {"dou", "du"},
+	'解': {"jie", "xie"},
+	'着': {"zhe", "zhao", "zhuo"},
+	'落': {"luo", "lao", "la"},
+	'数': {"shu", "shuo"},
+	'省': {"sheng", "xing"},
+	'奇': {"qi", "ji"},
+	'便': {"bian", "pian"},
+	'率': {"lv", "shuai"},
+	'仇': {"chou", "qiu"},
+	'区': {"qu", "ou"},
+	'查': {"cha", "zha"},
+	'盛': {"sheng", "cheng"},
+	'沈': {"shen", "chen"},
+	'尉': {"wei", "yu"},
+	'种': {"zhong", "chong"},
+	'将': {"jiang", "qiang"},
+}
+
+func main() {
+	chars := make([]rune, 0, 0xA000-0x4E00)
+	for r := rune(0x4E00); r < 0xA000; r++ {
+		chars = append(chars, r)
+	}
+
+	readings, err := uconvPerRune(chars, "Han-Latin; Latin-ASCII; Lower")
+	if err != nil {
+		panic(err)
+	}
+	bySyllable := map[string][]rune{}
+	for i, r := range chars {
+		syllable := readings[i]
+		if syllable == "" || !isLowerASCII(syllable) {
+			continue
+		}
+		if alt, ok := polyphones[r]; ok {
+			syllable = alt[0]
+		}
+		bySyllable[syllable] = append(bySyllable[syllable], r)
+	}
+	var pinyin bytes.Buffer
+	pinyin.WriteString("# syllable chars... | ~char primary alternates...  (generated by searchdata/gen)\n")
+	for _, syllable := range sortedKeys(bySyllable) {
+		fmt.Fprintf(&pinyin, "%s %s\n", syllable, string(bySyllable[syllable]))
+	}
+	multi := make([]rune, 0, len(polyphones))
+	for r := range polyphones {
+		multi = append(multi, r)
+	}
+	sort.Slice(multi, func(i, j int) bool { return multi[i] < multi[j] })
+	for _, r := range multi {
+		fmt.Fprintf(&pinyin, "~%c %s\n", r, strings.Join(polyphones[r], " "))
+	}
+
+	simplified, err := uconvPerRune(chars, "Traditional-Simplified")
+	if err != nil {
+		panic(err)
+	}
+	var t2s bytes.Buffer
+	t2s.WriteString("# traditional simplified  (generated by searchdata/gen)\n")
+	for i, r := range chars {
+		out := []rune(simplified[i])
+		if len(out) == 1 && out[0] != r {
+			fmt.Fprintf(&t2s, "%c%c\n", r, out[0])
+		}
+	}
+
+	if err := os.WriteFile("searchdata/pinyin.txt", pinyin.Bytes(), 0644); err != nil {
+		panic(err)
+	}
+	if err := os.WriteFile("searchdata/t2s.txt", t2s.Bytes(), 0644); err != nil {
+		panic(err)
+	}
+}
+
+func uconvPerRune(chars []rune, transform string) ([]string, error) {
+	var input strings.Builder
+	for _, r := range chars {
+		input.WriteRune(r)
+		input.WriteByte('\n')
+	}
+	cmd := exec.Command("uconv", "-x", transform)
+	cmd.Stdin = strings.NewReader(input.String())
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("uconv %q: %w", transform, err)
+	}
+	lines := strings.Split(string(out), "\n")
+	if len(lines) < len(chars) {
+		return nil, fmt.Errorf("uconv %q returned %d lines, want %d", transform, len(lines), len(chars))
+	}
+	for i := range lines {
+		lines[i] = strings.TrimSpace(lines[i])
+	}
+	return lines[:len(chars)], nil
+}
+
+func isLowerASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < 'a' || s[i] > 'z' {
+			return false
+		}
+	}
+	return true
+}
+
+func sortedKeys(m map[string][]rune) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -92,7 +92,7 @@ func (m *CookieManager) Save() {
 	}
 	m.mu.RUnlock()
 
-	_ = configDB.Transaction(func(tx *gorm.DB) error {
+	err := configDB.Transaction(func(tx *gorm.DB) error {
 		if err := tx.Where("1 = 1").Delete(&cookieEntry{}).Error; err != nil {
 			return err
 		}
@@ -101,6 +101,9 @@ func (m *CookieManager) Save() {
 		}
 		return tx.Create(&rows).Error
 	})
+	if err != nil {
+		Logger().Warn("save cookies failed", "error", err)
+	}
 	// 🌟 确保写入前目录存在
 	os.MkdirAll("data", 0755)
 }
@@ -145,7 +148,7 @@ type QRLoginCreateFunc func() (*model.QRLoginSession, error)
 type QRLoginCheckFunc func(string) (*model.QRLoginResult, error)
 type UserPlaylistsFunc func(page, limit int) ([]model.Playlist, error)
 
-func GetSearchFunc(source string) SearchFunc {
+func rawSearchFunc(source string) SearchFunc {
 	c := CM.Get(source)
 	switch source {
 	case "netease":
@@ -177,7 +180,7 @@ func GetSearchFunc(source string) SearchFunc {
 	}
 }
 
-func GetAlbumSearchFunc(source string) SearchPlaylistFunc {
+func rawAlbumSearchFunc(source string) SearchPlaylistFunc {
 	c := CM.Get(source)
 	switch source {
 	case "netease":
@@ -205,7 +208,7 @@ func GetAlbumSearchFunc(source string) SearchPlaylistFunc {
 	}
 }
 
-func GetPlaylistSearchFunc(source string) SearchPlaylistFunc {
+func rawPlaylistSearchFunc(source string) SearchPlaylistFunc {
 	c := CM.Get(source)
 	switch source {
 	case "netease":
@@ -237,7 +240,7 @@ func GetPlaylistSearchFunc(source string) SearchPlaylistFunc {
 	}
 }
 
-func GetAlbumDetailFunc(source string) func(string) ([]model.Song, error) {
+func rawAlbumDetailFunc(source string) func(string) ([]model.Song, error) {
 	c := CM.Get(source)
 	switch source {
 	case "netease":
@@ -265,7 +268,7 @@ func GetAlbumDetailFunc(source string) func(string) ([]model.Song, error) {
 	}
 }
 
-func GetPlaylistDetailFunc(source string) func(string) ([]model.Song, error) {
+func rawPlaylistDetailFunc(source string) func(string) ([]model.Song, error) {
 	c := CM.Get(source)
 	switch source {
 	case "netease":
@@ -297,7 +300,7 @@ func GetPlaylistDetailFunc(source string) func(string) ([]model.Song, error) {
 	}
 }
 
-func GetRecommendFunc(source string) func() ([]model.Playlist, error) {
+func rawRecommendFunc(source string) func() ([]model.Playlist, error) {
 	c := CM.Get(source)
 	switch source {
 	case "netease":
@@ -313,7 +316,7 @@ func GetRecommendFunc(source string) func() ([]model.Playlist, error) {
 	}
 }
 
-func GetPlaylistCategoriesFunc(source string) PlaylistCategoriesFunc {
+func rawPlaylistCategoriesFunc(source string) PlaylistCategoriesFunc {
 	c := CM.Get(source)
 	switch source {
 	case "netease":
@@ -337,7 +340,7 @@ func GetPlaylistCategoriesFunc(source string) PlaylistCategoriesFunc {
 	}
 }
 
-func GetCategoryPlaylistsFunc(source string) CategoryPlaylistsFunc {
+func rawCategoryPlaylistsFunc(source string) CategoryPlaylistsFunc {
 	c := CM.Get(source)
 	switch source {
 	case "netease":
@@ -403,7 +406,7 @@ func GetQRLoginSourceNames() []string {
 	return []string{"netease", "qq", "qq_wx", "kugou", "bilibili"}
 }
 
-func GetUserPlaylistsFunc(source string) UserPlaylistsFunc {
+func rawUserPlaylistsFunc(source string) UserPlaylistsFunc {
 	c := CM.Get(source)
 	switch source {
 	case "netease":
@@ -427,7 +430,7 @@ func GetRecommendSourceNames() []string {
 	return []string{"netease", "qq", "kugou", "kuwo"}
 }
 
-func GetDownloadFunc(source string) func(*model.Song) (string, error) {
+func rawDownloadFunc(source string) func(*model.Song) (string, error) {
 	c := CM.Get(source)
 	switch source {
 	case "netease":
@@ -459,7 +462,7 @@ func GetDownloadFunc(source string) func(*model.Song) (string, error) {
 	}
 }
 
-func GetLyricFunc(source string) func(*model.Song) (string, error) {
+func rawLyricFunc(source string) func(*model.Song) (string, error) {
 	c := CM.Get(source)
 	switch source {
 	case "netease":
@@ -491,7 +494,7 @@ func GetLyricFunc(source string) func(*model.Song) (string, error) {
 	}
 }
 
-func GetParseFunc(source string) func(string) (*model.Song, error) {
+func rawParseFunc(source string) func(string) (*model.Song, error) {
 	c := CM.Get(source)
 	switch source {
 	case "netease":
@@ -523,7 +526,7 @@ func GetParseFunc(source string) func(string) (*model.Song, error) {
 	}
 }
 
-func GetParsePlaylistFunc(source string) func(string) (*model.Playlist, []model.Song, error) {
+func rawParsePlaylistFunc(source string) func(string) (*model.Playlist, []model.Song, error) {
 	c := CM.Get(source)
 	switch source {
 	case "netease":
@@ -555,7 +558,7 @@ func GetParsePlaylistFunc(source string) func(string) (*model.Playlist, []model.
 	}
 }
 
-func GetParseAlbumFunc(source string) func(string) (*model.Playlist, []model.Song, error) {
+func rawParseAlbumFunc(source string) func(string) (*model.Playlist, []model.Song, error) {
 	c := CM.Get(source)
 	switch source {
 	case "netease":
@@ -1191,44 +1194,44 @@ func preservedID3v23Frames(audioData []byte, replace map[string]bool) []byte {
 }
 
 func embedMP3ID3v23Metadata(audioData []byte, title, artist, album, lyric string, coverData []byte, coverMime string) ([]byte, error) {
-	var frames bytes.Buffer
+	var frames [][]byte
 	replaceFrames := map[string]bool{}
 	if title != "" {
 		replaceFrames["TIT2"] = true
+		frames = append(frames, id3v23Frame("TIT2", id3TextFramePayload(title)))
 	}
 	if artist != "" {
 		replaceFrames["TPE1"] = true
+		frames = append(frames, id3v23Frame("TPE1", id3TextFramePayload(artist)))
 	}
 	if album != "" {
 		replaceFrames["TALB"] = true
+		frames = append(frames, id3v23Frame("TALB", id3TextFramePayload(album)))
 	}
 	if lyric != "" {
 		replaceFrames["USLT"] = true
+		frames = append(frames, id3v23Frame("USLT", id3USLTPayload(lyric)))
 	}
 	if len(coverData) > 0 {
 		replaceFrames["APIC"] = true
+		frames = append(frames, id3v23Frame("APIC", id3APICPayload(coverData, coverMime)))
 	}
-	frames.Write(preservedID3v23Frames(audioData, replaceFrames))
+	return rewriteID3v23Tag(audioData, replaceFrames, frames), nil
+}
 
-	if title != "" {
-		frames.Write(id3v23Frame("TIT2", id3TextFramePayload(title)))
-	}
-	if artist != "" {
-		frames.Write(id3v23Frame("TPE1", id3TextFramePayload(artist)))
-	}
-	if album != "" {
-		frames.Write(id3v23Frame("TALB", id3TextFramePayload(album)))
-	}
-	if lyric != "" {
-		frames.Write(id3v23Frame("USLT", id3USLTPayload(lyric)))
-	}
-	if len(coverData) > 0 {
-		frames.Write(id3v23Frame("APIC", id3APICPayload(coverData, coverMime)))
+// rewriteID3v23Tag writes a fresh ID3v2.3 tag made of the existing frames not
+// listed in replace followed by frames. Without any frame the data is
+// returned unchanged.
+func rewriteID3v23Tag(audioData []byte, replace map[string]bool, frames [][]byte) []byte {
+	var buf bytes.Buffer
+	buf.Write(preservedID3v23Frames(audioData, replace))
+	for _, frame := range frames {
+		buf.Write(frame)
 	}
 
-	frameData := frames.Bytes()
+	frameData := buf.Bytes()
 	if len(frameData) == 0 {
-		return audioData, nil
+		return audioData
 	}
 
 	size := id3SynchsafeSize(len(frameData))
@@ -1237,7 +1240,7 @@ func embedMP3ID3v23Metadata(audioData []byte, title, artist, album, lyric string
 	out = append(out, size[:]...)
 	out = append(out, frameData...)
 	out = append(out, stripID3v2Prefix(audioData)...)
-	return out, nil
+	return out
 }
 
 func normalizeCoverMime(coverMime string) string {
@@ -1650,6 +1653,19 @@ func EmbedSongMetadata(audioData []byte, song *model.Song, lyric string, coverDa
 }
 
 func embedAudioMetadataByFFmpeg(audioData []byte, ext, title, artist, album, lyric string, coverData []byte, coverMime string) ([]byte, error) {
+	var metadata [][2]string
+	for _, field := range [][2]string{{"title", title}, {"artist", artist}, {"album", album}, {"lyrics", lyric}} {
+		if field[1] != "" {
+			metadata = append(metadata, field)
+		}
+	}
+	return runFFmpegMetadataEmbed(audioData, ext, metadata, coverData, coverMime, false)
+}
+
+// runFFmpegMetadataEmbed stream-copies audioData through ffmpeg, setting each
+// metadata key (an empty value removes the key). A non-empty coverData
+// replaces the attached picture; dropCover removes it.
+func runFFmpegMetadataEmbed(audioData []byte, ext string, metadata [][2]string, coverData []byte, coverMime string, dropCover bool) ([]byte, error) {
 	ffmpegPath, err := ResolveFFmpegPath()
 	if err != nil {
 		return nil, ErrFFmpegNotFound
@@ -1700,6 +1716,8 @@ func embedAudioMetadataByFFmpeg(audioData []byte, ext, title, artist, album, lyr
 
 	if hasCover {
 		args = append(args, "-map", "0:a:0", "-map", "1:v:0")
+	} else if dropCover {
+		args = append(args, "-map", "0:a")
 	} else {
 		args = append(args, "-map", "0")
 	}
@@ -1712,17 +1730,8 @@ func embedAudioMetadataByFFmpeg(audioData []byte, ext, title, artist, album, lyr
 		args = append(args, "-c", "copy")
 	}
 
-	if title != "" {
-		args = append(args, "-metadata", "title="+title)
-	}
-	if artist != "" {
-		args = append(args, "-metadata", "artist="+artist)
-	}
-	if album != "" {
-		args = append(args, "-metadata", "album="+album)
-	}
-	if lyric != "" {
-		args = append(args, "-metadata", "lyrics="+lyric)
+	for _, field := range metadata {
+		args = append(args, "-metadata", field[0]+"="+field[1])
 	}
 
 	if ext == "mp3" {
@@ -0,0 +1,239 @@
+package core
+
+import (
+	"context"
+	"time"
+
+	"github.com/guohuiyuan/music-lib/model"
+)
+
+var (
+	sourceRequestsTotal = NewCounterVec(
+		"music_dl_source_requests_total",
+		"Upstream music source calls by source, operation and outcome.",
+		"source", "operation", "outcome",
+	)
+	sourceRequestDuration = NewHistogramVec(
+		"music_dl_source_request_duration_seconds",
+		"Latency of upstream music source calls.",
+		DefaultLatencyBuckets,
+		"source", "operation",
+	)
+	downloadsTotal = NewCounterVec(
+		"music_dl_downloads_total",
+		"Song downloads by source and outcome (success, failed, skipped).",
+		"source", "outcome",
+	)
+	downloadBytesTotal = NewCounterVec(
+		"music_dl_download_bytes_total",
+		"Audio bytes fetched from upstream sources, by source and mode (file or stream).",
+		"source", "mode",
+	)
+)
+
+const (
+	DownloadModeFile   = "file"
+	DownloadModeStream = "stream"
+)
+
+func observeSourceCall(ctx context.Context, source, operation string, start time.Time, err error) {
+	elapsed := time.Since(start)
+	outcome := "ok"
+	if err != nil {
+		outcome = "error"
+	}
+	sourceRequestsTotal.Inc(source, operation, outcome)
+	sourceRequestDuration.Observe(elapsed.Seconds(), source, operation)
+
+	logger := LoggerFromContext(ctx)
+	if err != nil {
+		logger.Warn("source call failed", "source", source, "operation", operation, "duration_ms", elapsed.Milliseconds(), "error", err)
+		return
+	}
+	logger.Debug("source call", "source", source, "operation", operation, "duration_ms", elapsed.Milliseconds())
+}
+
+// ObserveDownload records the outcome of one song download.
+func ObserveDownload(source, outcome string) {
+	downloadsTotal.Inc(source, outcome)
+}
+
+// ObserveDownloadBytes adds n transferred audio bytes for source.
+func ObserveDownloadBytes(source, mode string, n int64) {
+	if n > 0 {
+		downloadBytesTotal.Add(float64(n), source, mode)
+	}
+}
+
+func instrumentSource0[R any](ctx context.Context, source, operation string, fn func() (R, error)) func() (R, error) {
+	if fn == nil {
+		return nil
+	}
+	return func() (R, error) {
+		start := time.Now()
+		result, err := fn()
+		observeSourceCall(ctx, source, operation, start, err)
+		return result, err
+	}
+}
+
+func instrumentSource1[A, R any](ctx context.Context, source, operation string, fn func(A) (R, error)) func(A) (R, error) {
+	if fn == nil {
+		return nil
+	}
+	return func(arg A) (R, error) {
+		start := time.Now()
+		result, err := fn(arg)
+		observeSourceCall(ctx, source, operation, start, err)
+		return result, err
+	}
+}
+
+func instrumentSource2[A, B, R any](ctx context.Context, source, operation string, fn func(A, B) (R, error)) func(A, B) (R, error) {
+	if fn == nil {
+		return nil
+	}
+	return func(a A, b B) (R, error) {
+		start := time.Now()
+		result, err := fn(a, b)
+		observeSourceCall(ctx, source, operation, start, err)
+		return result, err
+	}
+}
+
+func instrumentSourceParse(ctx context.Context, source, operation string, fn func(string) (*model.Playlist, []model.Song, error)) func(string) (*model.Playlist, []model.Song, error) {
+	if fn == nil {
+		return nil
+	}
+	return func(link string) (*model.Playlist, []model.Song, error) {
+		start := time.Now()
+		playlist, songs, err := fn(link)
+		observeSourceCall(ctx, source, operation, start, err)
+		return playlist, songs, err
+	}
+}
+
+// The exported factories below wrap the raw per-source functions in service.go
+// so every upstream call is counted, timed and logged. The *Context variants
+// tag the log lines with the request ID carried by ctx.
+
+func GetSearchFunc(source string) SearchFunc {
+	return GetSearchFuncContext(context.Background(), source)
+}
+
+func GetSearchFuncContext(ctx context.Context, source string) SearchFunc {
+	return instrumentSource1(ctx, source, "search", rawSearchFunc(source))
+}
+
+func GetAlbumSearchFunc(source string) SearchPlaylistFunc {
+	return GetAlbumSearchFuncContext(context.Background(), source)
+}
+
+func GetAlbumSearchFuncContext(ctx context.Context, source string) SearchPlaylistFunc {
+	return instrumentSource1(ctx, source, "search_album", rawAlbumSearchFunc(source))
+}
+
+func GetPlaylistSearchFunc(source string) SearchPlaylistFunc {
+	return GetPlaylistSearchFuncContext(context.Background(), source)
+}
+
+func GetPlaylistSearchFuncContext(ctx context.Context, source string) SearchPlaylistFunc {
+	return instrumentSource1(ctx, source, "search_playlist", rawPlaylistSearchFunc(source))
+}
+
+func GetAlbumDetailFunc(source string) func(string) ([]model.Song, error) {
+	return GetAlbumDetailFuncContext(context.Background(), source)
+}
+
+func GetAlbumDetailFuncContext(ctx context.Context, source string) func(string) ([]model.Song, error) {
+	return instrumentSource1(ctx, source, "album_detail", rawAlbumDetailFunc(source))
+}
+
+func GetPlaylistDetailFunc(source string) func(string) ([]model.Song, error) {
+	return GetPlaylistDetailFuncContext(context.Background(), source)
+}
+
+func GetPlaylistDetailFuncContext(ctx context.Context, source string) func(string) ([]model.Song, error) {
+	return instrumentSource1(ctx, source, "playlist_detail", rawPlaylistDetailFunc(source))
+}
+
+func GetRecommendFunc(source string) func() ([]model.Playlist, error) {
+	return GetRecommendFuncContext(context.Background(), source)
+}
+
+func GetRecommendFuncContext(ctx context.Context, source string) func() ([]model.Playlist, error) {
+	return instrumentSource0(ctx, source, "recommend", rawRecommendFunc(source))
+}
+
+func GetPlaylistCategoriesFunc(source string) PlaylistCategoriesFunc {
+	return GetPlaylistCategoriesFuncContext(context.Background(), source)
+}
+
+func GetPlaylistCategoriesFuncContext(ctx context.Context, source string) PlaylistCategoriesFunc {
+	return instrumentSource0(ctx, source, "playlist_categories", rawPlaylistCategoriesFunc(source))
+}
+
+func GetCategoryPlaylistsFunc(source string) CategoryPlaylistsFunc {
+	return GetCategoryPlaylistsFuncContext(context.Background(), source)
+}
+
+func GetCategoryPlaylistsFuncContext(ctx context.Context, source string) CategoryPlaylistsFunc {
+	fn := rawCategoryPlaylistsFunc(source)
+	if fn == nil {
+		return nil
+	}
+	return func(categoryID string, page, limit int) ([]model.Playlist, error) {
+		start := time.Now()
+		playlists, err := fn(categoryID, page, limit)
+		observeSourceCall(ctx, source, "category_playlists", start, err)
+		return playlists, err
+	}
+}
+
+func GetUserPlaylistsFunc(source string) UserPlaylistsFunc {
+	return GetUserPlaylistsFuncContext(context.Background(), source)
+}
+
+func GetUserPlaylistsFuncContext(ctx context.Context, source string) UserPlaylistsFunc {
+	return instrumentSource2(ctx, source, "user_playlists", rawUserPlaylistsFunc(source))
+}
+
+func GetDownloadFunc(source string) func(*model.Song) (string, error) {
+	return GetDownloadFuncContext(context.Background(), source)
+}
+
+func GetDownloadFuncContext(ctx context.Context, source string) func(*model.Song) (string, error) {
+	return instrumentSource1(ctx, source, "download_url", rawDownloadFunc(source))
+}
+
+func GetLyricFunc(source string) func(*model.Song) (string, error) {
+	return GetLyricFuncContext(context.Background(), source)
+}
+
+func GetLyricFuncContext(ctx context.Context, source string) func(*model.Song) (string, error) {
+	return instrumentSource1(ctx, source, "lyric", rawLyricFunc(source))
+}
+
+func GetParseFunc(source string) func(string) (*model.Song, error) {
+	return GetParseFuncContext(context.Background(), source)
+}
+
+func GetParseFuncContext(ctx context.Context, source string) func(string) (*model.Song, error) {
+	return instrumentSource1(ctx, source, "parse", rawParseFunc(source))
+}
+
+func GetParsePlaylistFunc(source string) func(string) (*model.Playlist, []model.Song, error) {
+	return GetParsePlaylistFuncContext(context.Background(), source)
+}
+
+func GetParsePlaylistFuncContext(ctx context.Context, source string) func(string) (*model.Playlist, []model.Song, error) {
+	return instrumentSourceParse(ctx, source, "parse_playlist", rawParsePlaylistFunc(source))
+}
+
+func GetParseAlbumFunc(source string) func(string) (*model.Playlist, []model.Song, error) {
+	return GetParseAlbumFuncContext(context.Background(), source)
+}
+
+func GetParseAlbumFuncContext(ctx context.Context, source string) func(string) (*model.Playlist, []model.Song, error) {
+	return instrumentSourceParse(ctx, source, "parse_album", rawParseAlbumFunc(source))
+}
@@ -0,0 +1,320 @@
+package core
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Download transcode modes (WebSettings.DownloadTranscodeMode). "also" keeps
+// the original next to the converted copy, "convert" replaces it.
+const (
+	TranscodeModeOff     = ""
+	TranscodeModeAlso    = "also"
+	TranscodeModeConvert = "convert"
+)
+
+// TranscodeProfile is one conversion target.
+type TranscodeProfile struct {
+	Name   string `json:"name"`
+	Format string `json:"format"`
+	// Bitrate 单位 kbps，0 表示编码器默认值；无损格式忽略。
+	Bitrate int `json:"bitrate,omitempty"`
+	// SampleRate 单位 Hz，0 表示保持原采样率。
+	SampleRate int `json:"sampleRate,omitempty"`
+}
+
+type transcodeFormat struct {
+	codec   string
+	muxer   string
+	lossy   bool
+	cover   bool
+	maxRate int
+}
+
+// transcodeFormats maps each supported output format to its ffmpeg encoder
+// and muxer. Ogg containers cannot carry an attached picture.
+var transcodeFormats = map[string]transcodeFormat{
+	"mp3":  {codec: "libmp3lame", muxer: "mp3", lossy: true, cover: true, maxRate: 320},
+	"m4a":  {codec: "aac", muxer: "ipod", lossy: true, cover: true, maxRate: 512},
+	"opus": {codec: "libopus", muxer: "opus", lossy: true, maxRate: 512},
+	"ogg":  {codec: "libvorbis", muxer: "ogg", lossy: true, maxRate: 500},
+	"flac": {codec: "flac", muxer: "flac", cover: true},
+	"wav":  {codec: "pcm_s16le", muxer: "wav"},
+}
+
+// TranscodeFormats lists the output formats in display order.
+var TranscodeFormats = []string{"mp3", "m4a", "opus", "ogg", "flac", "wav"}
+
+// BuiltinTranscodeProfiles are always available; a custom profile with the
+// same name replaces the built-in one.
+var BuiltinTranscodeProfiles = []TranscodeProfile{
+	{Name: "mp3-320", Format: "mp3", Bitrate: 320},
+	{Name: "mp3-192", Format: "mp3", Bitrate: 192},
+	{Name: "aac-256", Format: "m4a", Bitrate: 256},
+	{Name: "opus-128", Format: "opus", Bitrate: 128},
+	{Name: "flac", Format: "flac"},
+}
+
+// ErrTranscodeProfileNotFound is returned for an unknown profile name.
+var ErrTranscodeProfileNotFound = errors.New("transcode profile not found")
+
+// Ext returns the file extension of the profile's output, without the dot.
+func (p TranscodeProfile) Ext() string {
+	return p.Format
+}
+
+// normalizeTranscodeProfile lowercases the format (accepting "aac" for m4a),
+// clamps the bitrate and drops it for lossless formats.
+func normalizeTranscodeProfile(p TranscodeProfile) (TranscodeProfile, error) {
+	p.Name = strings.TrimSpace(p.Name)
+	p.Format = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(p.Format), "."))
+	if p.Format == "aac" {
+		p.Format = "m4a"
+	}
+	format, ok := transcodeFormats[p.Format]
+	if !ok {
+		return p, fmt.Errorf("unsupported transcode format %q", p.Format)
+	}
+	if p.Name == "" {
+		p.Name = p.Format
+		if format.lossy && p.Bitrate > 0 {
+			p.Name += "-" + strconv.Itoa(p.Bitrate)
+		}
+	}
+	if !format.lossy || p.Bitrate < 0 {
+		p.Bitrate = 0
+	}
+	if p.Bitrate > format.maxRate {
+		p.Bitrate = format.maxRate
+	}
+	if p.SampleRate < 0 || p.SampleRate > 384000 {
+		p.SampleRate = 0
+	}
+	return p, nil
+}
+
+// NormalizeTranscodeProfile validates a profile given on the command line or
+// in an API request.
+func NormalizeTranscodeProfile(p TranscodeProfile) (TranscodeProfile, error) {
+	return normalizeTranscodeProfile(p)
+}
+
+// normalizeTranscodeProfiles drops invalid entries and duplicate names.
+func normalizeTranscodeProfiles(profiles []TranscodeProfile) []TranscodeProfile {
+	if len(profiles) == 0 {
+		return nil
+	}
+	seen := make(map[string]bool, len(profiles))
+	normalized := make([]TranscodeProfile, 0, len(profiles))
+	for _, profile := range profiles {
+		profile, err := normalizeTranscodeProfile(profile)
+		if err != nil || seen[profile.Name] {
+			continue
+		}
+		seen[profile.Name] = true
+		normalized = append(normalized, profile)
+	}
+	if len(normalized) == 0 {
+		return nil
+	}
+	return normalized
+}
+
+func normalizeTranscodeMode(mode string) string {
+	switch mode = strings.ToLower(strings.TrimSpace(mode)); mode {
+	case TranscodeModeAlso, TranscodeModeConvert:
+		return mode
+	default:
+		return TranscodeModeOff
+	}
+}
+
+// TranscodeProfiles returns the built-in profiles followed by the custom ones
+// from settings.
+func TranscodeProfiles(custom []TranscodeProfile) []TranscodeProfile {
+	custom = normalizeTranscodeProfiles(custom)
+	overridden := make(map[string]bool, len(custom))
+	for _, profile := range custom {
+		overridden[profile.Name] = true
+	}
+	profiles := make([]TranscodeProfile, 0, len(BuiltinTranscodeProfiles)+len(custom))
+	for _, profile := range BuiltinTranscodeProfiles {
+		if !overridden[profile.Name] {
+			profiles = append(profiles, profile)
+		}
+	}
+	return append(profiles, custom...)
+}
+
+// FindTranscodeProfile looks a profile up by name among TranscodeProfiles.
+func FindTranscodeProfile(custom []TranscodeProfile, name string) (TranscodeProfile, error) {
+	name = strings.TrimSpace(name)
+	for _, profile := range TranscodeProfiles(custom) {
+		if profile.Name == name {
+			return profile, nil
+		}
+	}
+	return TranscodeProfile{}, fmt.Errorf("%w: %s", ErrTranscodeProfileNotFound, name)
+}
+
+// transcodeArgs builds the ffmpeg arguments that convert src into dst. Tags
+// are carried over with -map_metadata and the cover as an attached picture
+// where the container supports one.
+func transcodeArgs(src string, dst string, p TranscodeProfile) []string {
+	format := transcodeFormats[p.Format]
+	args := []string{"-y", "-hide_banner", "-nostats", "-progress", "pipe:1", "-i", src, "-map", "0:a:0"}
+	if format.cover {
+		args = append(args, "-map", "0:v?", "-c:v", "copy", "-disposition:v", "attached_pic")
+	} else {
+		args = append(args, "-vn")
+	}
+	args = append(args, "-map_metadata", "0", "-c:a", format.codec)
+	if p.Bitrate > 0 {
+		args = append(args, "-b:a", strconv.Itoa(p.Bitrate)+"k")
+	}
+	if p.SampleRate > 0 {
+		args = append(args, "-ar", strconv.Itoa(p.SampleRate))
+	}
+	if p.Format == "mp3" {
+		args = append(args, "-id3v2_version", "3", "-write_id3v1", "1")
+	}
+	return append(args, "-f", format.muxer, dst)
+}
+
+var ffmpegDurationPattern = regexp.MustCompile(`Duration:\s*(\d+):(\d+):(\d+(?:\.\d+)?)`)
+
+// TranscodeAudioFile converts src into dst with ffmpeg. progress, when not
+// nil, receives values between 0 and 1. The output is written to a temp file
+// next to dst and renamed into place, so a cancelled or failed run never
+// leaves a partial file behind.
+func TranscodeAudioFile(ctx context.Context, src string, dst string, profile TranscodeProfile, progress func(float64)) error {
+	profile, err := normalizeTranscodeProfile(profile)
+	if err != nil {
+		return err
+	}
+	ffmpegPath, err := ResolveFFmpegPath()
+	if err != nil {
+		return ErrFFmpegNotFound
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(dst), ".transcode-*.tmp")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+	_ = tmp.Close()
+	defer os.Remove(tmpPath)
+
+	cmd := exec.CommandContext(ctx, ffmpegPath, transcodeArgs(src, tmpPath, profile)...)
+	HideCommandWindow(cmd)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	// ffmpeg prints the input duration on stderr and the position on stdout.
+	durationCh := make(chan time.Duration, 1)
+	stderrTail := make(chan string, 1)
+	go func() {
+		var tail []string
+		sent := false
+		scanner := bufio.NewScanner(stderr)
+		for scanner.Scan() {
+			line := scanner.Text()
+			if !sent {
+				if m := ffmpegDurationPattern.FindStringSubmatch(line); m != nil {
+					durationCh <- parseFFmpegClock(m[1], m[2], m[3])
+					sent = true
+				}
+			}
+			tail = append(tail, line)
+			if len(tail) > 8 {
+				tail = tail[1:]
+			}
+		}
+		stderrTail <- strings.Join(tail, "\n")
+	}()
+	var duration time.Duration
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), "=")
+		if !ok || progress == nil {
+			continue
+		}
+		if duration == 0 {
+			select {
+			case duration = <-durationCh:
+			default:
+			}
+		}
+		switch key {
+		case "out_time_us", "out_time_ms":
+			// out_time_ms 实际也是微秒，ffmpeg 的历史遗留。
+			us, err := strconv.ParseInt(value, 10, 64)
+			if err == nil && duration > 0 && us > 0 {
+				progress(clampTranscodeProgress(float64(us) / float64(duration.Microseconds())))
+			}
+		case "progress":
+			if value == "end" {
+				progress(1)
+			}
+		}
+	}
+	tail := <-stderrTail
+	if err := cmd.Wait(); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("ffmpeg transcode failed: %v, output: %s", err, strings.TrimSpace(tail))
+	}
+	if info, err := os.Stat(tmpPath); err != nil || info.Size() == 0 {
+		return errors.New("transcoded output is empty")
+	}
+	return os.Rename(tmpPath, dst)
+}
+
+func parseFFmpegClock(hours string, minutes string, seconds string) time.Duration {
+	h, _ := strconv.Atoi(hours)
+	m, _ := strconv.Atoi(minutes)
+	s, _ := strconv.ParseFloat(seconds, 64)
+	return time.Duration(h)*time.Hour + time.Duration(m)*time.Minute + time.Duration(s*float64(time.Second))
+}
+
+func clampTranscodeProgress(value float64) float64 {
+	switch {
+	case value < 0:
+		return 0
+	case value > 1:
+		return 1
+	default:
+		return value
+	}
+}
+
+// TranscodeTargetPath returns where src converted with profile goes: the same
+// name with the profile's extension, inside outDir when given.
+func TranscodeTargetPath(src string, outDir string, profile TranscodeProfile) string {
+	base := strings.TrimSuffix(filepath.Base(src), filepath.Ext(src)) + "." + profile.Ext()
+	if strings.TrimSpace(outDir) == "" {
+		return filepath.Join(filepath.Dir(src), base)
+	}
+	return filepath.Join(outDir, base)
+}
@@ -0,0 +1,132 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeTranscodeProfile(t *testing.T) {
+	cases := []struct {
+		in   TranscodeProfile
+		want TranscodeProfile
+	}{
+		{TranscodeProfile{Format: "AAC", Bitrate: 256}, TranscodeProfile{Name: "m4a-256", Format: "m4a", Bitrate: 256}},
+		{TranscodeProfile{Name: "car", Format: ".mp3", Bitrate: 999}, TranscodeProfile{Name: "car", Format: "mp3", Bitrate: 320}},
+		{TranscodeProfile{Name: "archive", Format: "flac", Bitrate: 320, SampleRate: 48000}, TranscodeProfile{Name: "archive", Format: "flac", SampleRate: 48000}},
+		{TranscodeProfile{Format: "opus", Bitrate: -1, SampleRate: -5}, TranscodeProfile{Name: "opus", Format: "opus"}},
+	}
+	for _, tc := range cases {
+		got, err := NormalizeTranscodeProfile(tc.in)
+		if err != nil || got != tc.want {
+			t.Fatalf("NormalizeTranscodeProfile(%+v) = %+v, %v, want %+v", tc.in, got, err, tc.want)
+		}
+	}
+	if _, err := NormalizeTranscodeProfile(TranscodeProfile{Format: "wma"}); err == nil {
+		t.Fatal("unsupported format should fail")
+	}
+}
+
+func TestTranscodeProfilesOverrideBuiltins(t *testing.T) {
+	custom := []TranscodeProfile{
+		{Name: "mp3-320", Format: "mp3", Bitrate: 256},
+		{Name: "car", Format: "mp3", Bitrate: 192, SampleRate: 44100},
+		{Name: "car", Format: "flac"},
+		{Name: "bad", Format: "wma"},
+	}
+	profiles := TranscodeProfiles(custom)
+	if len(profiles) != len(BuiltinTranscodeProfiles)+1 {
+		t.Fatalf("profiles = %+v", profiles)
+	}
+	got, err := FindTranscodeProfile(custom, "mp3-320")
+	if err != nil || got.Bitrate != 256 {
+		t.Fatalf("overridden profile = %+v, %v", got, err)
+	}
+	got, err = FindTranscodeProfile(custom, "car")
+	if err != nil || got.Format != "mp3" || got.SampleRate != 44100 {
+		t.Fatalf("custom profile = %+v, %v", got, err)
+	}
+	if _, err := FindTranscodeProfile(custom, "bad"); !errors.Is(err, ErrTranscodeProfileNotFound) {
+		t.Fatalf("invalid profile lookup error = %v", err)
+	}
+}
+
+func TestTranscodeArgs(t *testing.T) {
+	args := strings.Join(transcodeArgs("in.flac", "out.tmp", TranscodeProfile{Format: "mp3", Bitrate: 192, SampleRate: 44100}), " ")
+	for _, want := range []string{"-map 0:v? -c:v copy", "-map_metadata 0", "-c:a libmp3lame", "-b:a 192k", "-ar 44100", "-id3v2_version 3", "-f mp3 out.tmp"} {
+		if !strings.Contains(args, want) {
+			t.Fatalf("mp3 args %q missing %q", args, want)
+		}
+	}
+	args = strings.Join(transcodeArgs("in.flac", "out.tmp", TranscodeProfile{Format: "opus", Bitrate: 128}), " ")
+	if !strings.Contains(args, "-vn") || strings.Contains(args, "0:v?") || !strings.Contains(args, "-f opus") {
+		t.Fatalf("opus args %q should drop the cover stream", args)
+	}
+	args = strings.Join(transcodeArgs("in.mp3", "out.tmp", TranscodeProfile{Format: "flac"}), " ")
+	if strings.Contains(args, "-b:a") {
+		t.Fatalf("flac args %q should not set a bitrate", args)
+	}
+}
+
+func TestNormalizeTranscodeSettings(t *testing.T) {
+	settings := normalizeWebSettings(WebSettings{
+		DownloadTranscodeMode: "Convert",
+		TranscodeProfiles:     []TranscodeProfile{{Name: " car ", Format: "aac", Bitrate: 128}, {Format: "ape"}},
+	})
+	if settings.DownloadTranscodeMode != TranscodeModeConvert || settings.DownloadTranscodeProfile != "mp3-320" {
+		t.Fatalf("download transcode = %q / %q", settings.DownloadTranscodeMode, settings.DownloadTranscodeProfile)
+	}
+	if len(settings.TranscodeProfiles) != 1 || settings.TranscodeProfiles[0].Name != "car" || settings.TranscodeProfiles[0].Format != "m4a" {
+		t.Fatalf("profiles = %+v", settings.TranscodeProfiles)
+	}
+	if got := normalizeWebSettings(WebSettings{DownloadTranscodeMode: "sometimes"}); got.DownloadTranscodeMode != TranscodeModeOff {
+		t.Fatalf("unknown mode = %q, want off", got.DownloadTranscodeMode)
+	}
+}
+
+func TestTranscodeTargetPath(t *testing.T) {
+	profile := TranscodeProfile{Format: "m4a"}
+	if got := TranscodeTargetPath(filepath.Join("a", "b", "song.flac"), "", profile); got != filepath.Join("a", "b", "song.m4a") {
+		t.Fatalf("target = %q", got)
+	}
+	if got := TranscodeTargetPath(filepath.Join("a", "song.flac"), "out", profile); got != filepath.Join("out", "song.m4a") {
+		t.Fatalf("target in out dir = %q", got)
+	}
+}
+
+func TestTranscodeAudioFileByFFmpeg(t *testing.T) {
+	if _, err := exec.LookPath("ffmpeg"); err != nil {
+		t.Skip("ffmpeg not available")
+	}
+	dir := t.TempDir()
+	src := filepath.Join(dir, "tone.flac")
+	cmd := exec.Command("ffmpeg", "-y", "-hide_banner", "-loglevel", "error", "-f", "lavfi", "-i", "sine=frequency=440:duration=3",
+		"-metadata", "title=Tone", "-metadata", "artist=Tester", src)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Skipf("ffmpeg encode failed: %v, output: %s", err, string(out))
+	}
+
+	dst := filepath.Join(dir, "tone.mp3")
+	var last float64
+	if err := TranscodeAudioFile(context.Background(), src, dst, TranscodeProfile{Format: "mp3", Bitrate: 128}, func(p float64) { last = p }); err != nil {
+		t.Fatalf("TranscodeAudioFile() error = %v", err)
+	}
+	if last != 1 {
+		t.Fatalf("final progress = %v, want 1", last)
+	}
+	data, err := os.ReadFile(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tags, err := ReadAudioTags(data)
+	if err != nil || tags.Title != "Tone" || tags.Artist != "Tester" {
+		t.Fatalf("tags = %+v, %v", tags, err)
+	}
+	if leftovers, _ := filepath.Glob(filepath.Join(dir, ".transcode-*")); len(leftovers) != 0 {
+		t.Fatalf("temp files left behind: %v", leftovers)
+	}
+}
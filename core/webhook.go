@@ -0,0 +1,579 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Notification events. Per-song download events come from
+// DownloadWithDedupCheckContext; the library and batch events are raised by
+// the web server and the TUI.
+const (
+	EventDownloadSuccess       = "download.success"
+	EventDownloadFailed        = "download.failed"
+	EventDownloadBatchFinished = "download.batch_finished"
+	EventLibraryCached         = "library.cached"
+	EventLibraryCacheFailed    = "library.cache_failed"
+	EventLibraryUploaded       = "library.uploaded"
+	EventCollectionChanged     = "collection.changed"
+	EventWebhookTest           = "webhook.test"
+)
+
+// WebhookEvents lists the events a target can subscribe to.
+var WebhookEvents = []string{
+	EventDownloadSuccess,
+	EventDownloadFailed,
+	EventDownloadBatchFinished,
+	EventLibraryCached,
+	EventLibraryCacheFailed,
+	EventLibraryUploaded,
+	EventCollectionChanged,
+}
+
+// defaultWebhookEvents is used when a target is saved without events. Per-song
+// success is left out so a long playlist does not produce one push per track.
+var defaultWebhookEvents = []string{EventDownloadFailed, EventDownloadBatchFinished, EventLibraryCacheFailed}
+
+// Webhook payload templates.
+const (
+	WebhookKindGeneric = "generic"
+	WebhookKindNtfy    = "ntfy"
+	WebhookKindGotify  = "gotify"
+	WebhookKindBark    = "bark"
+)
+
+const (
+	WebhookDeliverySuccess = "success"
+	WebhookDeliveryFailed  = "failed"
+
+	webhookDeliveryKeep = 500
+)
+
+var (
+	ErrWebhookNotFound = errors.New("webhook not found")
+	ErrInvalidWebhook  = errors.New("invalid webhook")
+)
+
+// NotifyEvent is what gets delivered to webhook targets. Data carries
+// event-specific fields such as song, artist, path or batch counters.
+type NotifyEvent struct {
+	Type      string         `json:"event"`
+	Title     string         `json:"title"`
+	Message   string         `json:"message"`
+	Time      time.Time      `json:"time"`
+	RequestID string         `json:"request_id,omitempty"`
+	Data      map[string]any `json:"data,omitempty"`
+}
+
+// WebhookTarget is one configured notification endpoint.
+type WebhookTarget struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	Name      string    `gorm:"size:128;not null" json:"name"`
+	Kind      string    `gorm:"size:32;not null" json:"kind"`
+	URL       string    `gorm:"size:1024;not null" json:"url"`
+	Token     string    `gorm:"size:256" json:"token,omitempty"`
+	EventList string    `gorm:"column:events;size:512" json:"-"`
+	Events    []string  `gorm:"-" json:"events"`
+	Template  string    `gorm:"type:text" json:"template,omitempty"`
+	Enabled   bool      `gorm:"not null" json:"enabled"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// WebhookDelivery is one entry of the delivery log.
+type WebhookDelivery struct {
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	TargetID   uint      `gorm:"index" json:"target_id"`
+	TargetName string    `gorm:"size:128" json:"target_name"`
+	Event      string    `gorm:"size:64;index" json:"event"`
+	Status     string    `gorm:"size:16;index" json:"status"`
+	StatusCode int       `json:"status_code"`
+	Attempts   int       `json:"attempts"`
+	Error      string    `gorm:"size:1024" json:"error,omitempty"`
+	DurationMs int64     `json:"duration_ms"`
+	RequestID  string    `gorm:"size:64" json:"request_id,omitempty"`
+	CreatedAt  time.Time `gorm:"autoCreateTime;index" json:"created_at"`
+}
+
+var (
+	// webhookClient and webhookRetryDelays are variables so tests can point
+	// deliveries at a local stub and skip the backoff.
+	webhookClient      = &http.Client{Timeout: 10 * time.Second}
+	webhookRetryDelays = []time.Duration{2 * time.Second, 10 * time.Second, 30 * time.Second}
+
+	webhookTargetsMu     sync.Mutex
+	webhookTargetsCache  []WebhookTarget
+	webhookTargetsLoaded bool
+
+	webhookWG sync.WaitGroup
+)
+
+func initWebhookTables() error {
+	if err := ensureConfigDB(); err != nil {
+		return err
+	}
+	return configDB.AutoMigrate(&WebhookTarget{}, &WebhookDelivery{})
+}
+
+func (t *WebhookTarget) unpackEvents() {
+	t.Events = nil
+	for _, event := range strings.Split(t.EventList, ",") {
+		if event = strings.TrimSpace(event); event != "" {
+			t.Events = append(t.Events, event)
+		}
+	}
+}
+
+func (t *WebhookTarget) subscribes(event string) bool {
+	if event == EventWebhookTest {
+		return true
+	}
+	for _, subscribed := range t.Events {
+		if subscribed == event {
+			return true
+		}
+	}
+	return false
+}
+
+// ListWebhookTargets returns every configured target ordered by ID.
+func ListWebhookTargets() ([]WebhookTarget, error) {
+	if err := initWebhookTables(); err != nil {
+		return nil, err
+	}
+	var targets []WebhookTarget
+	if err := configDB.Order("id ASC").Find(&targets).Error; err != nil {
+		return nil, err
+	}
+	for i := range targets {
+		targets[i].unpackEvents()
+	}
+	return targets, nil
+}
+
+// GetWebhookTarget loads a single target.
+func GetWebhookTarget(id uint) (*WebhookTarget, error) {
+	if err := initWebhookTables(); err != nil {
+		return nil, err
+	}
+	var target WebhookTarget
+	if err := configDB.First(&target, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrWebhookNotFound
+		}
+		return nil, err
+	}
+	target.unpackEvents()
+	return &target, nil
+}
+
+func normalizeWebhookTarget(target *WebhookTarget) error {
+	target.Name = strings.TrimSpace(target.Name)
+	target.URL = strings.TrimSpace(target.URL)
+	target.Token = strings.TrimSpace(target.Token)
+	target.Kind = strings.ToLower(strings.TrimSpace(target.Kind))
+	if target.Kind == "" {
+		target.Kind = WebhookKindGeneric
+	}
+	switch target.Kind {
+	case WebhookKindGeneric, WebhookKindNtfy, WebhookKindGotify, WebhookKindBark:
+	default:
+		return fmt.Errorf("%w: unknown kind %q", ErrInvalidWebhook, target.Kind)
+	}
+
+	parsed, err := url.Parse(target.URL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return fmt.Errorf("%w: url must be an absolute http(s) URL", ErrInvalidWebhook)
+	}
+	if target.Name == "" {
+		target.Name = parsed.Host
+	}
+
+	events := make([]string, 0, len(target.Events))
+	seen := make(map[string]struct{})
+	for _, event := range target.Events {
+		event = strings.TrimSpace(event)
+		if event == "" {
+			continue
+		}
+		if !isWebhookEvent(event) {
+			return fmt.Errorf("%w: unknown event %q", ErrInvalidWebhook, event)
+		}
+		if _, ok := seen[event]; ok {
+			continue
+		}
+		seen[event] = struct{}{}
+		events = append(events, event)
+	}
+	if len(events) == 0 {
+		events = append(events, defaultWebhookEvents...)
+	}
+	target.Events = events
+	target.EventList = strings.Join(events, ",")
+
+	if strings.TrimSpace(target.Template) != "" {
+		if target.Kind != WebhookKindGeneric {
+			return fmt.Errorf("%w: custom templates are only supported for generic webhooks", ErrInvalidWebhook)
+		}
+		// Render a sample so a broken template is rejected on save, not on
+		// the first real event.
+		sample := NotifyEvent{Type: EventWebhookTest, Title: "test", Message: "test", Time: time.Now()}
+		if _, err := renderWebhookTemplate(target.Template, sample); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidWebhook, err)
+		}
+	}
+	return nil
+}
+
+func isWebhookEvent(event string) bool {
+	for _, known := range WebhookEvents {
+		if known == event {
+			return true
+		}
+	}
+	return false
+}
+
+// SaveWebhookTarget creates a target (ID 0) or replaces an existing one.
+func SaveWebhookTarget(target WebhookTarget) (*WebhookTarget, error) {
+	if err := normalizeWebhookTarget(&target); err != nil {
+		return nil, err
+	}
+	if err := initWebhookTables(); err != nil {
+		return nil, err
+	}
+	if target.ID != 0 {
+		existing, err := GetWebhookTarget(target.ID)
+		if err != nil {
+			return nil, err
+		}
+		target.CreatedAt = existing.CreatedAt
+	}
+	// Save 会写入 Enabled=false，这里不能用 Updates 以免零值被忽略。
+	if err := configDB.Save(&target).Error; err != nil {
+		return nil, err
+	}
+	invalidateWebhookTargets()
+	return &target, nil
+}
+
+// DeleteWebhookTarget removes a target. Its delivery log is kept.
+func DeleteWebhookTarget(id uint) error {
+	if err := initWebhookTables(); err != nil {
+		return err
+	}
+	result := configDB.Delete(&WebhookTarget{}, id)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrWebhookNotFound
+	}
+	invalidateWebhookTargets()
+	return nil
+}
+
+// GetWebhookDeliveries returns the newest delivery log entries.
+func GetWebhookDeliveries(limit int) ([]WebhookDelivery, error) {
+	if err := initWebhookTables(); err != nil {
+		return nil, err
+	}
+	if limit <= 0 || limit > webhookDeliveryKeep {
+		limit = 100
+	}
+	var deliveries []WebhookDelivery
+	err := configDB.Order("created_at DESC").Order("id DESC").Limit(limit).Find(&deliveries).Error
+	return deliveries, err
+}
+
+func invalidateWebhookTargets() {
+	webhookTargetsMu.Lock()
+	webhookTargetsCache = nil
+	webhookTargetsLoaded = false
+	webhookTargetsMu.Unlock()
+}
+
+// enabledWebhookTargets is consulted on every event, so it is cached until a
+// target is saved or deleted.
+func enabledWebhookTargets() ([]WebhookTarget, error) {
+	webhookTargetsMu.Lock()
+	defer webhookTargetsMu.Unlock()
+	if webhookTargetsLoaded {
+		return webhookTargetsCache, nil
+	}
+	targets, err := ListWebhookTargets()
+	if err != nil {
+		return nil, err
+	}
+	enabled := targets[:0]
+	for _, target := range targets {
+		if target.Enabled {
+			enabled = append(enabled, target)
+		}
+	}
+	webhookTargetsCache = enabled
+	webhookTargetsLoaded = true
+	return enabled, nil
+}
+
+// Notify delivers event to every enabled target subscribed to it. Deliveries
+// run in the background with retries; use WaitWebhookDeliveries to block until
+// they are done (for example before the process exits).
+func Notify(ctx context.Context, event NotifyEvent) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	targets, err := enabledWebhookTargets()
+	if err != nil {
+		LoggerFromContext(ctx).Warn("load webhook targets failed", "error", err)
+		return
+	}
+	if len(targets) == 0 {
+		return
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	if event.RequestID == "" {
+		event.RequestID = RequestIDFromContext(ctx)
+	}
+
+	// 请求结束后 ctx 会被取消，投递需要脱离它但保留请求 ID。
+	detached := context.WithoutCancel(ctx)
+	for _, target := range targets {
+		if !target.subscribes(event.Type) {
+			continue
+		}
+		webhookWG.Add(1)
+		go func(target WebhookTarget) {
+			defer webhookWG.Done()
+			deliverWebhook(detached, target, event)
+		}(target)
+	}
+}
+
+// WaitWebhookDeliveries blocks until background deliveries finish or timeout
+// elapses, and reports whether they all finished.
+func WaitWebhookDeliveries(timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		webhookWG.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// SendWebhookTest synchronously delivers a test event to one target,
+// regardless of its subscriptions or enabled flag.
+func SendWebhookTest(ctx context.Context, id uint) (*WebhookDelivery, error) {
+	target, err := GetWebhookTarget(id)
+	if err != nil {
+		return nil, err
+	}
+	event := NotifyEvent{
+		Type:      EventWebhookTest,
+		Title:     "Go Music DL 通知测试",
+		Message:   fmt.Sprintf("Webhook %q 配置成功", target.Name),
+		Time:      time.Now(),
+		RequestID: RequestIDFromContext(ctx),
+	}
+	delivery := deliverWebhook(ctx, *target, event)
+	return &delivery, nil
+}
+
+// deliverWebhook sends event with retries and records the outcome. Network
+// errors, 429 and 5xx are retried; other 4xx responses are not, since
+// repeating the same payload will not help.
+func deliverWebhook(ctx context.Context, target WebhookTarget, event NotifyEvent) WebhookDelivery {
+	start := time.Now()
+	delivery := WebhookDelivery{
+		TargetID:   target.ID,
+		TargetName: target.Name,
+		Event:      event.Type,
+		RequestID:  event.RequestID,
+	}
+	logger := LoggerFromContext(ctx).With("webhook", target.Name, "event", event.Type)
+
+	for attempt := 1; ; attempt++ {
+		delivery.Attempts = attempt
+		code, err := sendWebhookOnce(ctx, target, event)
+		delivery.StatusCode = code
+		if err == nil {
+			delivery.Status = WebhookDeliverySuccess
+			delivery.Error = ""
+			break
+		}
+		delivery.Status = WebhookDeliveryFailed
+		delivery.Error = cleanDownloadRecordText(err.Error())
+		retryable := code == 0 || code == http.StatusTooManyRequests || code >= 500
+		if !retryable || attempt > len(webhookRetryDelays) {
+			break
+		}
+		logger.Debug("webhook delivery failed, retrying", "attempt", attempt, "error", err)
+		select {
+		case <-ctx.Done():
+		case <-time.After(webhookRetryDelays[attempt-1]):
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	delivery.DurationMs = time.Since(start).Milliseconds()
+
+	if delivery.Status == WebhookDeliveryFailed {
+		logger.Warn("webhook delivery failed", "attempts", delivery.Attempts, "status_code", delivery.StatusCode, "error", delivery.Error)
+	} else {
+		logger.Debug("webhook delivered", "attempts", delivery.Attempts, "status_code", delivery.StatusCode)
+	}
+	if err := saveWebhookDelivery(&delivery); err != nil {
+		logger.Warn("save webhook delivery failed", "error", err)
+	}
+	return delivery
+}
+
+func saveWebhookDelivery(delivery *WebhookDelivery) error {
+	if err := initWebhookTables(); err != nil {
+		return err
+	}
+	if err := configDB.Create(delivery).Error; err != nil {
+		return err
+	}
+	// 只保留最近的投递记录。
+	return configDB.Where("id <= ?", int(delivery.ID)-webhookDeliveryKeep).Delete(&WebhookDelivery{}).Error
+}
+
+func sendWebhookOnce(ctx context.Context, target WebhookTarget, event NotifyEvent) (int, error) {
+	req, err := buildWebhookRequest(ctx, target, event)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("webhook returned HTTP %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// buildWebhookRequest renders the payload for the target's kind:
+//   - generic: the event as JSON, or the target's custom template
+//   - ntfy:    JSON publish to the server root, topic taken from the URL path
+//   - gotify:  {title, message, priority}, token sent as X-Gotify-Key
+//   - bark:    {title, body, group, device_key}, device key from the token
+func buildWebhookRequest(ctx context.Context, target WebhookTarget, event NotifyEvent) (*http.Request, error) {
+	endpoint := target.URL
+	headers := map[string]string{"Content-Type": "application/json"}
+	var body any
+
+	failed := strings.HasSuffix(event.Type, "failed")
+	switch target.Kind {
+	case WebhookKindNtfy:
+		parsed, err := url.Parse(target.URL)
+		if err != nil {
+			return nil, err
+		}
+		topic := strings.Trim(parsed.Path, "/")
+		if topic == "" || strings.Contains(topic, "/") {
+			return nil, fmt.Errorf("ntfy url must end with the topic, e.g. https://ntfy.sh/my-topic")
+		}
+		parsed.Path = "/"
+		endpoint = parsed.String()
+		tags := []string{"musical_note"}
+		priority := 3
+		if failed {
+			tags = []string{"warning"}
+			priority = 4
+		}
+		body = map[string]any{"topic": topic, "title": event.Title, "message": event.Message, "tags": tags, "priority": priority}
+		if target.Token != "" {
+			headers["Authorization"] = "Bearer " + target.Token
+		}
+	case WebhookKindGotify:
+		priority := 5
+		if failed {
+			priority = 8
+		}
+		body = map[string]any{"title": event.Title, "message": event.Message, "priority": priority}
+		if target.Token != "" {
+			headers["X-Gotify-Key"] = target.Token
+		}
+	case WebhookKindBark:
+		payload := map[string]any{"title": event.Title, "body": event.Message, "group": "music-dl"}
+		if target.Token != "" {
+			payload["device_key"] = target.Token
+		}
+		body = payload
+	default:
+		if strings.TrimSpace(target.Template) != "" {
+			rendered, err := renderWebhookTemplate(target.Template, event)
+			if err != nil {
+				return nil, err
+			}
+			body = json.RawMessage(rendered)
+		} else {
+			body = event
+		}
+		if target.Token != "" {
+			headers["Authorization"] = "Bearer " + target.Token
+		}
+	}
+
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "go-music-dl/"+AppVersion)
+	req.Header.Set("X-Music-DL-Event", event.Type)
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	return req, nil
+}
+
+// renderWebhookTemplate executes a text/template against the event. The json
+// helper quotes a value, e.g. {"text": {{json .Message}}}. The result must be
+// valid JSON.
+func renderWebhookTemplate(text string, event NotifyEvent) ([]byte, error) {
+	tmpl, err := template.New("webhook").Option("missingkey=zero").Funcs(template.FuncMap{
+		"json": func(value any) (string, error) {
+			data, err := json.Marshal(value)
+			return string(data), err
+		},
+	}).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("parse template: %w", err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, event); err != nil {
+		return nil, fmt.Errorf("render template: %w", err)
+	}
+	if !json.Valid(buf.Bytes()) {
+		return nil, errors.New("template does not render valid JSON")
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,241 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+type webhookStubRequest struct {
+	Path    string
+	Header  http.Header
+	Payload map[string]any
+}
+
+// webhookStub is a local endpoint that records requests and answers with the
+// queued status codes (200 once the queue is empty).
+type webhookStub struct {
+	*httptest.Server
+	mu       sync.Mutex
+	statuses []int
+	requests []webhookStubRequest
+}
+
+func newWebhookStub(t *testing.T, statuses ...int) *webhookStub {
+	t.Helper()
+	stub := &webhookStub{statuses: statuses}
+	stub.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var payload map[string]any
+		_ = json.Unmarshal(body, &payload)
+
+		stub.mu.Lock()
+		stub.requests = append(stub.requests, webhookStubRequest{Path: r.URL.Path, Header: r.Header.Clone(), Payload: payload})
+		status := http.StatusOK
+		if len(stub.statuses) > 0 {
+			status = stub.statuses[0]
+			stub.statuses = stub.statuses[1:]
+		}
+		stub.mu.Unlock()
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(stub.Close)
+	return stub
+}
+
+func (s *webhookStub) received() []webhookStubRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]webhookStubRequest(nil), s.requests...)
+}
+
+func setupWebhookTest(t *testing.T) {
+	t.Helper()
+	t.Setenv("MUSIC_DL_CONFIG_DB", filepath.Join(t.TempDir(), "settings.db"))
+	resetConfigStateForTest()
+	t.Cleanup(resetConfigStateForTest)
+
+	previousDelays := webhookRetryDelays
+	webhookRetryDelays = []time.Duration{0, 0}
+	t.Cleanup(func() { webhookRetryDelays = previousDelays })
+}
+
+func waitWebhooksForTest(t *testing.T) {
+	t.Helper()
+	if !WaitWebhookDeliveries(5 * time.Second) {
+		t.Fatal("webhook deliveries did not finish")
+	}
+}
+
+func TestSaveWebhookTargetValidates(t *testing.T) {
+	setupWebhookTest(t)
+
+	invalid := []WebhookTarget{
+		{Kind: "slack", URL: "https://example.com/hook"},
+		{URL: "ftp://example.com/hook"},
+		{URL: "https://example.com/hook", Events: []string{"download.exploded"}},
+		{URL: "https://example.com/hook", Template: `{"text": {{.Message}`},
+		{URL: "https://example.com/hook", Template: `not json {{.Message}}`},
+		{Kind: WebhookKindBark, URL: "https://api.day.app/push", Template: `{}`},
+	}
+	for _, target := range invalid {
+		if _, err := SaveWebhookTarget(target); !errors.Is(err, ErrInvalidWebhook) {
+			t.Fatalf("SaveWebhookTarget(%+v) error = %v, want ErrInvalidWebhook", target, err)
+		}
+	}
+
+	saved, err := SaveWebhookTarget(WebhookTarget{URL: " https://example.com/hook ", Enabled: true})
+	if err != nil {
+		t.Fatalf("SaveWebhookTarget() error = %v", err)
+	}
+	if saved.Kind != WebhookKindGeneric || saved.Name != "example.com" || len(saved.Events) != len(defaultWebhookEvents) {
+		t.Fatalf("unexpected defaults: %+v", saved)
+	}
+
+	saved.Enabled = false
+	saved.Events = []string{EventLibraryUploaded, EventLibraryUploaded}
+	if _, err := SaveWebhookTarget(*saved); err != nil {
+		t.Fatalf("update webhook: %v", err)
+	}
+	loaded, err := GetWebhookTarget(saved.ID)
+	if err != nil {
+		t.Fatalf("GetWebhookTarget() error = %v", err)
+	}
+	if loaded.Enabled || len(loaded.Events) != 1 || loaded.Events[0] != EventLibraryUploaded {
+		t.Fatalf("update not persisted: %+v", loaded)
+	}
+
+	if err := DeleteWebhookTarget(saved.ID); err != nil {
+		t.Fatalf("DeleteWebhookTarget() error = %v", err)
+	}
+	if err := DeleteWebhookTarget(saved.ID); !errors.Is(err, ErrWebhookNotFound) {
+		t.Fatalf("second delete error = %v, want ErrWebhookNotFound", err)
+	}
+}
+
+func TestNotifyDeliversTemplatesToSubscribedTargets(t *testing.T) {
+	setupWebhookTest(t)
+	stub := newWebhookStub(t)
+
+	targets := []WebhookTarget{
+		{Name: "generic", URL: stub.URL + "/generic", Events: []string{EventDownloadFailed}, Enabled: true},
+		{Name: "ntfy", Kind: WebhookKindNtfy, URL: stub.URL + "/music", Events: []string{EventDownloadFailed}, Enabled: true},
+		{Name: "gotify", Kind: WebhookKindGotify, URL: stub.URL + "/message", Token: "app-token", Events: []string{EventDownloadFailed}, Enabled: true},
+		{Name: "bark", Kind: WebhookKindBark, URL: stub.URL + "/push", Token: "device", Events: []string{EventDownloadFailed}, Enabled: true},
+		{Name: "custom", URL: stub.URL + "/custom", Template: `{"text": {{json .Message}}, "kind": {{json .Type}}}`, Events: []string{EventDownloadFailed}, Enabled: true},
+		{Name: "other-event", URL: stub.URL + "/other", Events: []string{EventLibraryUploaded}, Enabled: true},
+		{Name: "disabled", URL: stub.URL + "/disabled", Events: []string{EventDownloadFailed}},
+	}
+	for _, target := range targets {
+		if _, err := SaveWebhookTarget(target); err != nil {
+			t.Fatalf("SaveWebhookTarget(%s) error = %v", target.Name, err)
+		}
+	}
+
+	ctx := WithRequestID(context.Background(), "req-hook")
+	Notify(ctx, NotifyEvent{Type: EventDownloadFailed, Title: "下载失败", Message: "晴天 - 周杰伦: boom"})
+	waitWebhooksForTest(t)
+
+	byPath := make(map[string]webhookStubRequest)
+	for _, req := range stub.received() {
+		byPath[req.Path] = req
+	}
+	if len(byPath) != 5 {
+		t.Fatalf("expected 5 deliveries, got %v", byPath)
+	}
+	if _, ok := byPath["/other"]; ok {
+		t.Fatal("target not subscribed to the event was notified")
+	}
+
+	generic := byPath["/generic"]
+	if generic.Payload["event"] != EventDownloadFailed || generic.Payload["request_id"] != "req-hook" {
+		t.Fatalf("generic payload = %v", generic.Payload)
+	}
+	if generic.Header.Get("X-Music-DL-Event") != EventDownloadFailed {
+		t.Fatalf("missing event header: %v", generic.Header)
+	}
+	if ntfy := byPath["/"]; ntfy.Payload["topic"] != "music" || ntfy.Payload["priority"] != float64(4) {
+		t.Fatalf("ntfy payload = %v", ntfy.Payload)
+	}
+	if gotify := byPath["/message"]; gotify.Header.Get("X-Gotify-Key") != "app-token" || gotify.Payload["message"] != "晴天 - 周杰伦: boom" {
+		t.Fatalf("gotify request = %+v", gotify)
+	}
+	if bark := byPath["/push"]; bark.Payload["device_key"] != "device" || bark.Payload["body"] != "晴天 - 周杰伦: boom" {
+		t.Fatalf("bark payload = %v", bark.Payload)
+	}
+	if custom := byPath["/custom"]; custom.Payload["text"] != "晴天 - 周杰伦: boom" || custom.Payload["kind"] != EventDownloadFailed {
+		t.Fatalf("custom payload = %v", custom.Payload)
+	}
+
+	deliveries, err := GetWebhookDeliveries(0)
+	if err != nil || len(deliveries) != 5 {
+		t.Fatalf("GetWebhookDeliveries() = %d, %v", len(deliveries), err)
+	}
+	for _, delivery := range deliveries {
+		if delivery.Status != WebhookDeliverySuccess || delivery.RequestID != "req-hook" {
+			t.Fatalf("unexpected delivery %+v", delivery)
+		}
+	}
+}
+
+func TestWebhookDeliveryRetries(t *testing.T) {
+	setupWebhookTest(t)
+
+	flaky := newWebhookStub(t, http.StatusBadGateway, http.StatusTooManyRequests)
+	rejected := newWebhookStub(t, http.StatusBadRequest)
+	down := newWebhookStub(t, http.StatusInternalServerError, http.StatusInternalServerError, http.StatusInternalServerError)
+
+	flakyTarget, _ := SaveWebhookTarget(WebhookTarget{URL: flaky.URL + "/hook", Enabled: true})
+	rejectedTarget, _ := SaveWebhookTarget(WebhookTarget{URL: rejected.URL + "/hook", Enabled: true})
+	downTarget, _ := SaveWebhookTarget(WebhookTarget{URL: down.URL + "/hook", Enabled: true})
+
+	cases := []struct {
+		id           uint
+		wantStatus   string
+		wantAttempts int
+	}{
+		{flakyTarget.ID, WebhookDeliverySuccess, 3},
+		{rejectedTarget.ID, WebhookDeliveryFailed, 1},
+		{downTarget.ID, WebhookDeliveryFailed, 3},
+	}
+	for _, tc := range cases {
+		delivery, err := SendWebhookTest(context.Background(), tc.id)
+		if err != nil {
+			t.Fatalf("SendWebhookTest(%d) error = %v", tc.id, err)
+		}
+		if delivery.Status != tc.wantStatus || delivery.Attempts != tc.wantAttempts {
+			t.Fatalf("target %d delivery = %+v, want %s after %d attempts", tc.id, delivery, tc.wantStatus, tc.wantAttempts)
+		}
+	}
+	if _, err := SendWebhookTest(context.Background(), 999); !errors.Is(err, ErrWebhookNotFound) {
+		t.Fatalf("SendWebhookTest(999) error = %v", err)
+	}
+}
+
+func TestNotifyBatchDownloadFinished(t *testing.T) {
+	setupWebhookTest(t)
+	stub := newWebhookStub(t)
+	if _, err := SaveWebhookTarget(WebhookTarget{URL: stub.URL + "/hook", Enabled: true}); err != nil {
+		t.Fatalf("SaveWebhookTarget() error = %v", err)
+	}
+
+	NotifyBatchDownloadFinished(context.Background(), BatchDownloadSummary{Name: "通勤", Total: 3, Success: 1, Skipped: 1, Failed: 1, Failures: []string{"稻香 - 周杰伦"}})
+	waitWebhooksForTest(t)
+
+	requests := stub.received()
+	if len(requests) != 1 {
+		t.Fatalf("expected one delivery, got %d", len(requests))
+	}
+	payload := requests[0].Payload
+	data, _ := payload["data"].(map[string]any)
+	if payload["event"] != EventDownloadBatchFinished || data["failed"] != float64(1) || data["total"] != float64(3) {
+		t.Fatalf("unexpected batch payload %v", payload)
+	}
+}
@@ -0,0 +1,490 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/guohuiyuan/go-music-dl/core"
+	"github.com/guohuiyuan/music-lib/model"
+)
+
+const (
+	playerSeekStep   = 10.0 // ←/→ 每次跳转的秒数
+	playerVolumeStep = 10   // +/- 每次调整的音量
+	// playerRestartAfter 之后按上一首只回到歌曲开头，和共享会话的 prev 一致。
+	playerRestartAfter = 3.0
+	// playerSourceMaxAge 之后播放出错结束时先重新解析链接再接着播：
+	// 暂停久了，连接可能被音源断开，签名链接也可能已经过期。
+	playerSourceMaxAge = 30 * time.Second
+)
+
+// playerProcess 是一首歌的播放（见 playerStream）。暂停、跳转和音量都在
+// 同一个进程上调整，不重新启动。
+type playerProcess interface {
+	Stop()
+	Done() <-chan struct{}
+	// Err 是播放自己结束时的错误，Done 关闭后才有效。
+	Err() error
+	SetPaused(paused bool)
+	SetVolume(volume int)
+	Seek(pos float64) error
+}
+
+// 测试中替换，避免解析真实链接和启动 ffplay。
+var (
+	preparePlaybackSource = core.PreparePlaybackSource
+	launchPlayer          = startFFplay
+	playerNow             = time.Now
+)
+
+// playerEndedMsg 表示播放自己结束（播完或出错），generation 用来忽略换歌、
+// 停止时主动结束的旧进程。
+type playerEndedMsg struct {
+	generation int
+	err        error
+}
+
+// playerLoadedMsg 带回后台准备好的音频，seq 不是最近一次加载的结果直接丢弃。
+// resume 表示只是给当前歌曲换了新链接，从原位置接着播。
+type playerLoadedMsg struct {
+	seq      int
+	index    int
+	step     int
+	resume   bool
+	playURL  string
+	tempFile string
+	err      error
+}
+
+type playerTickMsg time.Time
+
+// tuiPlayer 维护 TUI 的播放队列和当前进度。位置按 offset 加上本次启动后经过
+// 的时间推算，暂停时停在 offset。
+type tuiPlayer struct {
+	queue      []model.Song
+	index      int
+	volume     int
+	replayGain string
+
+	proc       playerProcess
+	generation int
+	loadSeq    int
+	loading    bool
+	playURL    string
+	tempFile   string // soda 等临时文件，换歌或停止时删除
+	preparedAt time.Time
+	offset     float64
+	resumedAt  time.Time
+	paused     bool
+	ticking    bool
+}
+
+func newTUIPlayer(replayGain string) *tuiPlayer {
+	return &tuiPlayer{volume: 100, replayGain: replayGain}
+}
+
+// buildPlayQueue 用选中的歌曲（保持列表顺序）组成播放队列，没有选中时从
+// 光标所在歌曲开始播放整个列表。返回队列和起始下标。
+func buildPlayQueue(songs []model.Song, selected map[int]struct{}, cursor int) ([]model.Song, int) {
+	if len(selected) == 0 {
+		if cursor < 0 || cursor >= len(songs) {
+			return nil, 0
+		}
+		return append([]model.Song(nil), songs[cursor:]...), 0
+	}
+	queue := make([]model.Song, 0, len(selected))
+	start := 0
+	for i, song := range songs {
+		if _, ok := selected[i]; !ok {
+			continue
+		}
+		if i == cursor {
+			start = len(queue)
+		}
+		queue = append(queue, song)
+	}
+	return queue, start
+}
+
+func (p *tuiPlayer) current() *model.Song {
+	if p == nil || p.index < 0 || p.index >= len(p.queue) {
+		return nil
+	}
+	return &p.queue[p.index]
+}
+
+// active 表示有正在播放或暂停中的歌曲。
+func (p *tuiPlayer) active() bool {
+	return p != nil && (p.proc != nil || (p.paused && p.current() != nil))
+}
+
+func (p *tuiPlayer) position(now time.Time) float64 {
+	pos := p.offset
+	if p.proc != nil && !p.paused {
+		pos += now.Sub(p.resumedAt).Seconds()
+	}
+	if song := p.current(); song != nil && song.Duration > 0 && pos > float64(song.Duration) {
+		pos = float64(song.Duration)
+	}
+	return max(pos, 0)
+}
+
+// cue 切到第 index 首，位置回到开头；音频由 loadCmd 在后台准备。
+func (p *tuiPlayer) cue(index int) {
+	p.halt()
+	p.clearSource()
+	p.index = index
+	p.offset = 0
+	p.paused = false
+}
+
+// loadCmd 在后台解析第 index 首的音频（可能要请求音源或下载临时文件），
+// 不阻塞界面，完成后发回 playerLoadedMsg。
+func (p *tuiPlayer) loadCmd(index int, step int, resume bool) tea.Cmd {
+	p.loadSeq++
+	p.loading = true
+	seq, song := p.loadSeq, p.queue[index]
+	return func() tea.Msg {
+		playURL, tempFile, err := preparePlaybackSource(&song)
+		return playerLoadedMsg{seq: seq, index: index, step: step, resume: resume, playURL: playURL, tempFile: tempFile, err: err}
+	}
+}
+
+func (p *tuiPlayer) launch(now time.Time) error {
+	proc, err := launchPlayer(p.queue[p.index], p.playURL, core.PlaybackOptions{
+		Start:      p.offset,
+		Volume:     p.volume,
+		ReplayGain: p.replayGain,
+	})
+	if err != nil {
+		return err
+	}
+	p.proc = proc
+	p.generation++
+	p.resumedAt = now
+	p.paused = false
+	return nil
+}
+
+// halt 结束当前播放进程，不改动队列和位置。
+func (p *tuiPlayer) halt() {
+	if p.proc == nil {
+		return
+	}
+	p.proc.Stop()
+	p.proc = nil
+}
+
+func (p *tuiPlayer) clearSource() {
+	if p.tempFile != "" {
+		_ = os.Remove(p.tempFile)
+	}
+	p.playURL, p.tempFile = "", ""
+}
+
+func (p *tuiPlayer) stop() {
+	p.halt()
+	p.clearSource()
+	p.loadSeq++ // 还在准备的音频回来后直接丢弃
+	p.loading = false
+	p.queue = nil
+	p.index = 0
+	p.offset = 0
+	p.paused = false
+}
+
+// togglePause 暂停或继续；歌曲已播完、没有播放进程时从 offset 重新启动，
+// 返回是否启动了新进程。
+func (p *tuiPlayer) togglePause(now time.Time) (bool, error) {
+	if p.paused {
+		if p.proc == nil {
+			return true, p.launch(now)
+		}
+		p.proc.SetPaused(false)
+		p.paused = false
+		p.resumedAt = now
+		return false, nil
+	}
+	p.offset = p.position(now)
+	p.paused = true
+	if p.proc != nil {
+		p.proc.SetPaused(true)
+	}
+	return false, nil
+}
+
+// seek 前后跳转 delta 秒；暂停中也可以跳，继续播放时从新位置开始。
+func (p *tuiPlayer) seek(delta float64, now time.Time) error {
+	pos := max(p.position(now)+delta, 0)
+	if song := p.current(); song != nil && song.Duration > 0 {
+		pos = min(pos, float64(song.Duration))
+	}
+	return p.seekTo(pos, now)
+}
+
+func (p *tuiPlayer) seekTo(pos float64, now time.Time) error {
+	p.offset = pos
+	if p.proc == nil {
+		return nil
+	}
+	p.resumedAt = now
+	return p.proc.Seek(pos)
+}
+
+func (p *tuiPlayer) changeVolume(delta int) {
+	p.volume = min(max(p.volume+delta, 0), 100)
+	if p.proc != nil {
+		p.proc.SetVolume(p.volume)
+	}
+}
+
+// waitCmd 等待当前播放结束，用于播完自动切到下一首。
+func (p *tuiPlayer) waitCmd() tea.Cmd {
+	if p.proc == nil {
+		return nil
+	}
+	proc, generation := p.proc, p.generation
+	return func() tea.Msg {
+		<-proc.Done()
+		return playerEndedMsg{generation: generation, err: proc.Err()}
+	}
+}
+
+// tickCmd 每秒刷新一次进度行；同一时间只保留一条计时链。
+func (p *tuiPlayer) tickCmd() tea.Cmd {
+	if p.ticking {
+		return nil
+	}
+	p.ticking = true
+	return tea.Tick(time.Second, func(t time.Time) tea.Msg { return playerTickMsg(t) })
+}
+
+// progressLine 渲染播放状态、进度条、时间和音量。
+func (p *tuiPlayer) progressLine(now time.Time, width int) string {
+	song := p.current()
+	if !p.active() || song == nil {
+		return ""
+	}
+	icon := "▶"
+	if p.paused {
+		icon = "⏸"
+	}
+	elapsed := p.position(now)
+	clock := formatPlaybackClock(int(elapsed))
+	bar := ""
+	if song.Duration > 0 {
+		clock += " / " + formatPlaybackClock(song.Duration)
+		barWidth := min(max(width-70, 10), 30)
+		filled := int(elapsed / float64(song.Duration) * float64(barWidth))
+		filled = min(max(filled, 0), barWidth)
+		bar = "[" + strings.Repeat("━", filled) + strings.Repeat("─", barWidth-filled) + "] "
+	}
+	return fmt.Sprintf("%s %s  %s%s  (%d/%d)  音量 %d%%", icon, song.Display(), bar, clock, p.index+1, len(p.queue), p.volume)
+}
+
+// --- modelState 上的播放控制 ---
+
+// playQueue 用列表和选中项组成队列并开始播放，返回需要调度的命令。
+func (m *modelState) playQueue(now time.Time) tea.Cmd {
+	queue, start := buildPlayQueue(m.songs, m.selected, m.cursor)
+	if len(queue) == 0 {
+		return nil
+	}
+	m.stopPlayback()
+	if m.player == nil {
+		m.player = newTUIPlayer(m.replayGain)
+	}
+	m.player.queue = queue
+	return m.playFrom(start, 1, now)
+}
+
+// playFrom 切到第 index 首并在后台准备音频；取不到音频时由 loadedTrack 按
+// step 方向跳过这首。
+func (m *modelState) playFrom(index int, step int, now time.Time) tea.Cmd {
+	p := m.player
+	if index < 0 || index >= len(p.queue) {
+		p.stop()
+		m.statusMsg = "⏹ 播放队列已结束"
+		return nil
+	}
+	p.cue(index)
+	m.statusMsg = fmt.Sprintf("⏳ 正在准备: %s", p.queue[index].Display())
+	return p.loadCmd(index, step, false)
+}
+
+// loadedTrack 处理后台准备好的音频并启动 ffplay；ffplay 启动失败则直接结束
+// 队列。
+func (m *modelState) loadedTrack(msg playerLoadedMsg, now time.Time) tea.Cmd {
+	p := m.player
+	if p == nil || msg.seq != p.loadSeq {
+		if msg.tempFile != "" {
+			_ = os.Remove(msg.tempFile)
+		}
+		return nil
+	}
+	p.loading = false
+	if msg.err != nil {
+		core.Logger().Warn("tui playback failed", "song", p.queue[msg.index].Display(), "error", msg.err)
+		if msg.resume {
+			// 重新解析也失败，只能当这首播完了。
+			return m.advanceQueue(now)
+		}
+		if next := msg.index + msg.step; next >= 0 && next < len(p.queue) {
+			return m.playFrom(next, msg.step, now)
+		}
+		p.stop()
+		m.statusMsg = fmt.Sprintf("播放失败: %v", msg.err)
+		return nil
+	}
+
+	p.clearSource()
+	p.playURL, p.tempFile, p.preparedAt = msg.playURL, msg.tempFile, now
+	if err := p.launch(now); err != nil {
+		return m.relaunch(err)
+	}
+	song := p.queue[msg.index]
+	if msg.resume {
+		m.statusMsg = fmt.Sprintf("▶ 继续播放: %s", song.Display())
+	} else {
+		m.startPlayHistory(song, now)
+		m.publishSharedPlayback(p.queue, msg.index, now)
+		m.statusMsg = fmt.Sprintf("▶ 正在播放: %s", song.Display())
+	}
+	return tea.Batch(p.waitCmd(), p.tickCmd())
+}
+
+// advanceQueue 把当前歌曲记为播完并切到下一首，队列播完时停止。
+func (m *modelState) advanceQueue(now time.Time) tea.Cmd {
+	p := m.player
+	m.finishTrack(now, true)
+	if p.index+1 < len(p.queue) {
+		return m.playFrom(p.index+1, 1, now)
+	}
+	m.stopPlayback()
+	m.statusMsg = "⏹ 播放队列已结束"
+	return nil
+}
+
+// skipTrack 切到上一首或下一首；上一首在播放超过几秒时先回到歌曲开头。
+func (m *modelState) skipTrack(step int, now time.Time) tea.Cmd {
+	p := m.player
+	if !p.active() && (p == nil || !p.loading) {
+		return nil
+	}
+	if step < 0 && p.position(now) > playerRestartAfter {
+		if err := p.seekTo(0, now); err != nil {
+			return m.relaunch(err)
+		}
+		return nil
+	}
+	target := p.index + step
+	if target < 0 || target >= len(p.queue) {
+		m.statusMsg = "已经是队列的第一首或最后一首"
+		return nil
+	}
+	m.finishTrack(now, false)
+	return m.playFrom(target, step, now)
+}
+
+// finishTrack 为当前歌曲补记播放历史；ended 表示 ffplay 自然播完，位置停在
+// 歌曲末尾。
+func (m *modelState) finishTrack(now time.Time, ended bool) {
+	if p := m.player; ended {
+		p.offset = p.position(now)
+		if song := p.current(); song != nil && song.Duration > 0 {
+			p.offset = float64(song.Duration)
+		}
+		p.halt()
+		p.paused = true
+	}
+	m.finishPlayHistory(now)
+}
+
+func (m *modelState) relaunch(err error) tea.Cmd {
+	if err != nil {
+		m.stopPlayback()
+		m.statusMsg = fmt.Sprintf("播放失败: %v", err)
+		return nil
+	}
+	return m.player.waitCmd()
+}
+
+func (m *modelState) togglePlayerPause(now time.Time) tea.Cmd {
+	p := m.player
+	if !p.active() {
+		return nil
+	}
+	launched, err := p.togglePause(now)
+	if err != nil {
+		return m.relaunch(err)
+	}
+	m.syncSharedPlayback(now)
+	if p.paused {
+		m.statusMsg = "⏸ 已暂停"
+		return nil
+	}
+	m.statusMsg = fmt.Sprintf("▶ 继续播放: %s", p.current().Display())
+	if launched {
+		return tea.Batch(p.waitCmd(), p.tickCmd())
+	}
+	return p.tickCmd()
+}
+
+func (m *modelState) seekPlayer(delta float64, now time.Time) tea.Cmd {
+	p := m.player
+	if !p.active() {
+		return nil
+	}
+	err := p.seek(delta, now)
+	m.syncSharedPlayback(now)
+	if err != nil {
+		return m.relaunch(err)
+	}
+	return nil
+}
+
+func (m *modelState) changePlayerVolume(delta int) tea.Cmd {
+	p := m.player
+	if !p.active() {
+		return nil
+	}
+	p.changeVolume(delta)
+	m.statusMsg = fmt.Sprintf("音量 %d%%", p.volume)
+	return nil
+}
+
+// updatePlayer 处理播放器的消息，与当前界面状态无关。
+func (m *modelState) updatePlayer(msg tea.Msg) tea.Cmd {
+	p := m.player
+	switch msg := msg.(type) {
+	case playerEndedMsg:
+		if p == nil || p.proc == nil || msg.generation != p.generation {
+			return nil
+		}
+		now := playerNow()
+		if msg.err != nil && now.Sub(p.preparedAt) > playerSourceMaxAge {
+			// 链接解析出来有一阵了（比如暂停了很久），ffmpeg 出错多半是
+			// 连接断开或链接过期：重新解析后从原位置接着播，而不是跳过这首。
+			core.Logger().Info("tui playback source refresh", "song", p.current().Display(), "error", msg.err)
+			p.offset = p.position(now)
+			p.halt()
+			m.statusMsg = fmt.Sprintf("⏳ 正在重新获取: %s", p.current().Display())
+			return p.loadCmd(p.index, 1, true)
+		}
+		return m.advanceQueue(now)
+	case playerLoadedMsg:
+		return m.loadedTrack(msg, playerNow())
+	case playerTickMsg:
+		if p == nil {
+			return nil
+		}
+		p.ticking = false
+		if p.proc != nil {
+			return p.tickCmd()
+		}
+	}
+	return nil
+}
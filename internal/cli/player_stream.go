@@ -0,0 +1,303 @@
+package cli
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/guohuiyuan/go-music-dl/core"
+	"github.com/guohuiyuan/music-lib/model"
+)
+
+// ffplay 在 -nodisp 下不读按键，所以 TUI 播放拆成两段：ffmpeg 把音频解码成
+// PCM，由 playerStream 转交给一直运行的 ffplay。暂停就是暂时不往 ffplay 写
+// 数据，音量在写之前缩放采样，跳转只换一个从新位置解码的 ffmpeg；一首歌
+// 从头到尾只有一个 ffplay 进程。
+
+const (
+	// playerStreamLead 是最多领先实际播放的数据，决定暂停、调音量多快生效。
+	playerStreamLead = 200 * time.Millisecond
+	// playerStreamChunk 是每次转交的字节数，必须是整帧。
+	playerStreamChunk = 4096
+	pcmFrameBytes     = 2 * core.PlaybackPCMChannels
+	pcmBytesPerSecond = core.PlaybackPCMRate * pcmFrameBytes
+)
+
+// pcmDecoder 是一个输出 s16le PCM 的解码进程。
+type pcmDecoder interface {
+	io.Reader
+	// Wait 在读到 EOF 后等待进程退出，返回它的错误。
+	Wait() error
+	// Kill 结束进程，跳转或停止时使用。
+	Kill()
+}
+
+// pcmSink 是从标准输入读 WAV 流的播放进程。
+type pcmSink interface {
+	io.Writer
+	// CloseInput 结束输入，播放进程播完已写入的数据后自己退出。
+	CloseInput() error
+	Kill()
+	Wait() error
+}
+
+// playerStream 实现 playerProcess。
+type playerStream struct {
+	decode func(start float64) (pcmDecoder, error)
+	sink   pcmSink
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	decoder pcmDecoder
+	seq     int // 每次跳转加一，转交线程据此区分跳转和播完
+	paused  bool
+	volume  int
+	stopped bool
+	ended   bool
+
+	done chan struct{}
+	err  error
+}
+
+func newPlayerStream(sink pcmSink, decode func(start float64) (pcmDecoder, error), opts core.PlaybackOptions) (*playerStream, error) {
+	decoder, err := decode(opts.Start)
+	if err != nil {
+		sink.Kill()
+		_ = sink.Wait()
+		return nil, err
+	}
+	s := &playerStream{
+		decode:  decode,
+		sink:    sink,
+		decoder: decoder,
+		volume:  opts.Volume,
+		done:    make(chan struct{}),
+	}
+	s.cond = sync.NewCond(&s.mu)
+	go s.pump()
+	return s, nil
+}
+
+func (s *playerStream) Done() <-chan struct{} { return s.done }
+
+func (s *playerStream) Err() error { return s.err }
+
+func (s *playerStream) Stop() {
+	s.mu.Lock()
+	s.stopped = true
+	decoder := s.decoder
+	s.cond.Broadcast()
+	s.mu.Unlock()
+	decoder.Kill()
+	s.sink.Kill()
+	<-s.done
+}
+
+func (s *playerStream) SetPaused(paused bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.paused = paused
+	s.cond.Broadcast()
+}
+
+func (s *playerStream) SetVolume(volume int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.volume = volume
+}
+
+// Seek 换成从 pos 开始解码的 ffmpeg，已交给 ffplay 的一小段照常播完。
+func (s *playerStream) Seek(pos float64) error {
+	decoder, err := s.decode(pos)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	if s.stopped || s.ended {
+		s.mu.Unlock()
+		decoder.Kill()
+		return nil
+	}
+	old := s.decoder
+	s.decoder = decoder
+	s.seq++
+	s.cond.Broadcast()
+	s.mu.Unlock()
+	old.Kill()
+	return nil
+}
+
+// pump 把解码结果转交给 ffplay，结束后收尾两个进程。解码出错（比如链接过期）
+// 时等 ffplay 播完已写入的部分再报告错误。
+func (s *playerStream) pump() {
+	err := s.feed()
+
+	s.mu.Lock()
+	s.ended = true
+	stopped, decoder := s.stopped, s.decoder
+	s.mu.Unlock()
+	decoder.Kill()
+	if !stopped {
+		_ = s.sink.CloseInput()
+	}
+	sinkErr := s.sink.Wait()
+	switch {
+	case stopped:
+		err = nil
+	case err == nil:
+		err = sinkErr
+	}
+	s.err = err
+	close(s.done)
+}
+
+func (s *playerStream) feed() error {
+	if _, err := s.sink.Write(wavStreamHeader()); err != nil {
+		return err
+	}
+	buf := make([]byte, playerStreamChunk)
+	// clock 和 sent 用来按实际播放速度转交，只领先 playerStreamLead；
+	// 暂停和跳转后重新计时。
+	clock, sent := time.Now(), time.Duration(0)
+	for {
+		s.mu.Lock()
+		if s.paused && !s.stopped {
+			for s.paused && !s.stopped {
+				s.cond.Wait()
+			}
+			clock, sent = time.Now(), 0
+		}
+		if s.stopped {
+			s.mu.Unlock()
+			return nil
+		}
+		decoder, seq, volume := s.decoder, s.seq, s.volume
+		s.mu.Unlock()
+
+		n, readErr := io.ReadFull(decoder, buf)
+		s.mu.Lock()
+		seeked, stopped := seq != s.seq, s.stopped
+		s.mu.Unlock()
+		if stopped {
+			return nil
+		}
+		if seeked {
+			clock, sent = time.Now(), 0
+			continue
+		}
+
+		if n -= n % pcmFrameBytes; n > 0 {
+			scalePCM(buf[:n], volume)
+			sent += time.Duration(n) * time.Second / pcmBytesPerSecond
+			if wait := sent - playerStreamLead - time.Since(clock); wait > 0 {
+				time.Sleep(wait)
+			}
+			if _, err := s.sink.Write(buf[:n]); err != nil {
+				return err
+			}
+		}
+		if readErr != nil {
+			return decoder.Wait()
+		}
+	}
+}
+
+// scalePCM 按 0-100 的音量线性缩放 s16le 采样，与 ffplay -volume 一致。
+func scalePCM(buf []byte, volume int) {
+	if volume >= 100 {
+		return
+	}
+	volume = max(volume, 0)
+	for i := 0; i+1 < len(buf); i += 2 {
+		sample := int32(int16(binary.LittleEndian.Uint16(buf[i:])))
+		binary.LittleEndian.PutUint16(buf[i:], uint16(int16(sample*int32(volume)/100)))
+	}
+}
+
+// wavStreamHeader 是长度未知的 WAV 头，ffplay 会一直读到输入结束。
+func wavStreamHeader() []byte {
+	header := make([]byte, 44)
+	copy(header[0:], "RIFF")
+	binary.LittleEndian.PutUint32(header[4:], 0xFFFFFFFF)
+	copy(header[8:], "WAVEfmt ")
+	binary.LittleEndian.PutUint32(header[16:], 16)
+	binary.LittleEndian.PutUint16(header[20:], 1) // PCM
+	binary.LittleEndian.PutUint16(header[22:], core.PlaybackPCMChannels)
+	binary.LittleEndian.PutUint32(header[24:], core.PlaybackPCMRate)
+	binary.LittleEndian.PutUint32(header[28:], pcmBytesPerSecond)
+	binary.LittleEndian.PutUint16(header[32:], pcmFrameBytes)
+	binary.LittleEndian.PutUint16(header[34:], 16)
+	copy(header[36:], "data")
+	binary.LittleEndian.PutUint32(header[40:], 0xFFFFFFFF)
+	return header
+}
+
+type ffmpegDecoder struct {
+	cmd  *exec.Cmd
+	out  io.Reader
+	once sync.Once
+	err  error
+}
+
+func (d *ffmpegDecoder) Read(p []byte) (int, error) { return d.out.Read(p) }
+
+func (d *ffmpegDecoder) Wait() error {
+	d.once.Do(func() { d.err = d.cmd.Wait() })
+	return d.err
+}
+
+func (d *ffmpegDecoder) Kill() {
+	_ = d.cmd.Process.Kill()
+	_ = d.Wait()
+}
+
+type ffplaySink struct {
+	cmd *exec.Cmd
+	in  io.WriteCloser
+}
+
+func (s *ffplaySink) Write(p []byte) (int, error) { return s.in.Write(p) }
+
+func (s *ffplaySink) CloseInput() error { return s.in.Close() }
+
+func (s *ffplaySink) Kill() { _ = s.cmd.Process.Kill() }
+
+func (s *ffplaySink) Wait() error { return s.cmd.Wait() }
+
+func startFFplay(song model.Song, playURL string, opts core.PlaybackOptions) (playerProcess, error) {
+	ffplayPath, err := core.ResolveFFplayPath()
+	if err != nil || ffplayPath == "" {
+		return nil, fmt.Errorf("未找到 ffplay，请确认已安装 ffmpeg 并在 PATH 中")
+	}
+	ffmpegPath, err := core.ResolveFFmpegPath()
+	if err != nil || ffmpegPath == "" {
+		return nil, fmt.Errorf("未找到 ffmpeg，请确认已安装 ffmpeg 并在 PATH 中")
+	}
+
+	cmd := exec.Command(ffplayPath, core.PlaybackSinkArgs()...)
+	in, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	decode := func(start float64) (pcmDecoder, error) {
+		cmd := exec.Command(ffmpegPath, core.PlaybackDecodeArgs(&song, playURL, core.PlaybackOptions{
+			Start:      start,
+			ReplayGain: opts.ReplayGain,
+		})...)
+		out, err := cmd.StdoutPipe()
+		if err != nil {
+			return nil, err
+		}
+		if err := cmd.Start(); err != nil {
+			return nil, err
+		}
+		return &ffmpegDecoder{cmd: cmd, out: out}, nil
+	}
+	return newPlayerStream(&ffplaySink{cmd: cmd, in: in}, decode, opts)
+}
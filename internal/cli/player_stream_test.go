@@ -0,0 +1,163 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/guohuiyuan/go-music-dl/core"
+)
+
+type fakePCMDecoder struct {
+	*io.PipeReader
+	err error
+}
+
+func (d *fakePCMDecoder) Wait() error { return d.err }
+
+func (d *fakePCMDecoder) Kill() { d.CloseWithError(errors.New("killed")) }
+
+type fakePCMSink struct {
+	mu     sync.Mutex
+	data   bytes.Buffer
+	closed bool
+	exited chan struct{}
+	once   sync.Once
+}
+
+func (s *fakePCMSink) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.data.Write(p)
+}
+
+func (s *fakePCMSink) CloseInput() error {
+	s.mu.Lock()
+	s.closed = true
+	s.mu.Unlock()
+	s.once.Do(func() { close(s.exited) })
+	return nil
+}
+
+func (s *fakePCMSink) Kill() { s.once.Do(func() { close(s.exited) }) }
+
+func (s *fakePCMSink) Wait() error {
+	<-s.exited
+	return nil
+}
+
+func (s *fakePCMSink) waitBytes(t *testing.T, n int) []byte {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		s.mu.Lock()
+		if s.data.Len() >= n {
+			out := append([]byte(nil), s.data.Bytes()...)
+			s.mu.Unlock()
+			return out
+		}
+		s.mu.Unlock()
+		if time.Now().After(deadline) {
+			t.Fatalf("sink got %d bytes, want %d", s.data.Len(), n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// pcmChunkForTest 是一整块取值都为 sample 的 PCM。
+func pcmChunkForTest(sample int16) []byte {
+	chunk := make([]byte, playerStreamChunk)
+	for i := 0; i < len(chunk); i += 2 {
+		binary.LittleEndian.PutUint16(chunk[i:], uint16(sample))
+	}
+	return chunk
+}
+
+// startPlayerStreamForTest 启动一个 playerStream，按起始位置记下每个解码器
+// 的写入端。
+func startPlayerStreamForTest(t *testing.T, volume int, decodeErr error) (*playerStream, *fakePCMSink, chan *io.PipeWriter) {
+	t.Helper()
+	sink := &fakePCMSink{exited: make(chan struct{})}
+	writers := make(chan *io.PipeWriter, 4)
+	decode := func(start float64) (pcmDecoder, error) {
+		r, w := io.Pipe()
+		writers <- w
+		return &fakePCMDecoder{PipeReader: r, err: decodeErr}, nil
+	}
+	stream, err := newPlayerStream(sink, decode, core.PlaybackOptions{Volume: volume})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(stream.Stop)
+	return stream, sink, writers
+}
+
+func TestPlayerStreamScalesSeeksAndEnds(t *testing.T) {
+	stream, sink, writers := startPlayerStreamForTest(t, 50, nil)
+	header := len(wavStreamHeader())
+
+	if _, err := (<-writers).Write(pcmChunkForTest(1000)); err != nil {
+		t.Fatal(err)
+	}
+	got := sink.waitBytes(t, header+playerStreamChunk)
+	if !bytes.Equal(got[:4], []byte("RIFF")) || !bytes.Equal(got[header:], pcmChunkForTest(500)) {
+		t.Fatalf("first chunk was not scaled to half volume")
+	}
+
+	// 跳转换一个解码器，写给 ffplay 的流不断开；音量随时生效。
+	if err := stream.Seek(30); err != nil {
+		t.Fatal(err)
+	}
+	stream.SetVolume(100)
+	next := <-writers
+	if _, err := next.Write(pcmChunkForTest(2000)); err != nil {
+		t.Fatal(err)
+	}
+	got = sink.waitBytes(t, header+2*playerStreamChunk)
+	if !bytes.Equal(got[header+playerStreamChunk:], pcmChunkForTest(2000)) {
+		t.Fatalf("chunk after seek was changed")
+	}
+
+	next.Close()
+	select {
+	case <-stream.Done():
+	case <-time.After(2 * time.Second):
+		t.Fatal("stream did not end with its decoder")
+	}
+	if stream.Err() != nil || !sink.closed {
+		t.Fatalf("end = %v, sink closed %v", stream.Err(), sink.closed)
+	}
+}
+
+func TestPlayerStreamPausesAndReportsDecodeErrors(t *testing.T) {
+	stream, sink, writers := startPlayerStreamForTest(t, 100, errors.New("exit status 1"))
+	header := len(wavStreamHeader())
+	w := <-writers
+	sink.waitBytes(t, header)
+
+	stream.SetPaused(true)
+	// 转交线程可能已经在等这一块，暂停对下一块生效。
+	go func() {
+		_, _ = w.Write(pcmChunkForTest(1))
+		_, _ = w.Write(pcmChunkForTest(2))
+	}()
+	time.Sleep(50 * time.Millisecond)
+	sink.mu.Lock()
+	paused := sink.data.Len()
+	sink.mu.Unlock()
+	if paused > header+playerStreamChunk {
+		t.Fatalf("paused stream kept writing: %d bytes", paused)
+	}
+	stream.SetPaused(false)
+	sink.waitBytes(t, header+2*playerStreamChunk)
+
+	w.Close()
+	<-stream.Done()
+	if err := stream.Err(); err == nil || err.Error() != "exit status 1" {
+		t.Fatalf("err = %v, want the decoder error", err)
+	}
+}
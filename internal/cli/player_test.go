@@ -0,0 +1,266 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/guohuiyuan/go-music-dl/core"
+	"github.com/guohuiyuan/music-lib/model"
+)
+
+type fakePlayerProcess struct {
+	done     chan struct{}
+	stopped  bool
+	err      error
+	controls []string
+}
+
+func (p *fakePlayerProcess) Stop() {
+	if !p.stopped {
+		p.stopped = true
+		close(p.done)
+	}
+}
+
+func (p *fakePlayerProcess) Done() <-chan struct{} { return p.done }
+
+func (p *fakePlayerProcess) Err() error { return p.err }
+
+func (p *fakePlayerProcess) SetPaused(paused bool) {
+	p.controls = append(p.controls, fmt.Sprintf("paused %v", paused))
+}
+
+func (p *fakePlayerProcess) SetVolume(volume int) {
+	p.controls = append(p.controls, fmt.Sprintf("volume %d", volume))
+}
+
+func (p *fakePlayerProcess) Seek(pos float64) error {
+	p.controls = append(p.controls, fmt.Sprintf("seek %g", pos))
+	return nil
+}
+
+type playerLaunch struct {
+	songID     string
+	start      float64
+	volume     int
+	replayGain string
+}
+
+// stubPlayer 替换音频准备、ffplay 启动、播放历史和共享会话，记录每次启动。
+func stubPlayer(t *testing.T, unavailable ...string) (*[]playerLaunch, *[]core.PlayEvent) {
+	t.Helper()
+	var launches []playerLaunch
+	var events []core.PlayEvent
+	previousPrepare, previousLaunch, previousRecord := preparePlaybackSource, launchPlayer, recordPlayEvent
+	previousApply, previousGet := applyPlaybackCommand, getPlaybackSession
+	preparePlaybackSource = func(song *model.Song) (string, string, error) {
+		if slices.Contains(unavailable, song.ID) {
+			return "", "", errors.New("vip only")
+		}
+		return "http://example.com/" + song.ID, "", nil
+	}
+	launchPlayer = func(song model.Song, _ string, opts core.PlaybackOptions) (playerProcess, error) {
+		launches = append(launches, playerLaunch{songID: song.ID, start: opts.Start, volume: opts.Volume, replayGain: opts.ReplayGain})
+		return &fakePlayerProcess{done: make(chan struct{})}, nil
+	}
+	recordPlayEvent = func(event core.PlayEvent, _ time.Time) (*core.PlayHistory, error) {
+		events = append(events, event)
+		return &core.PlayHistory{ID: uint(len(events))}, nil
+	}
+	applyPlaybackCommand = func(cmd core.PlaybackCommand, _ time.Time) (core.PlaybackSession, error) {
+		return core.PlaybackSession{}, nil
+	}
+	getPlaybackSession = func(time.Time) (core.PlaybackSession, error) {
+		return core.PlaybackSession{}, nil
+	}
+	previousNow := playerNow
+	t.Cleanup(func() {
+		playerNow = previousNow
+		preparePlaybackSource, launchPlayer, recordPlayEvent = previousPrepare, previousLaunch, previousRecord
+		applyPlaybackCommand, getPlaybackSession = previousApply, previousGet
+	})
+	return &launches, &events
+}
+
+// loadPlayerForTest 执行后台准备音频的命令并把结果交给播放器，取不到音频
+// 跳到下一首时继续执行，直到不再有加载中的歌曲。
+func loadPlayerForTest(t *testing.T, m *modelState, cmd tea.Cmd) {
+	t.Helper()
+	for cmd != nil && m.player != nil && m.player.loading {
+		msg, ok := cmd().(playerLoadedMsg)
+		if !ok {
+			t.Fatalf("expected a load command, got %T", msg)
+		}
+		cmd = m.updatePlayer(msg)
+	}
+}
+
+func playerSongsForTest(ids ...string) []model.Song {
+	songs := make([]model.Song, 0, len(ids))
+	for _, id := range ids {
+		songs = append(songs, model.Song{ID: id, Source: "qq", Name: "Song " + id, Duration: 100})
+	}
+	return songs
+}
+
+func TestBuildPlayQueue(t *testing.T) {
+	songs := playerSongsForTest("a", "b", "c", "d")
+	queue, start := buildPlayQueue(songs, map[int]struct{}{}, 2)
+	if len(queue) != 2 || queue[0].ID != "c" || start != 0 {
+		t.Fatalf("queue from cursor = %v start %d", queue, start)
+	}
+	queue, start = buildPlayQueue(songs, map[int]struct{}{3: {}, 0: {}, 1: {}}, 1)
+	if len(queue) != 3 || queue[0].ID != "a" || queue[2].ID != "d" || start != 1 {
+		t.Fatalf("queue from selection = %v start %d", queue, start)
+	}
+	// 光标不在选中项上时从第一首选中的歌开始。
+	if _, start = buildPlayQueue(songs, map[int]struct{}{3: {}}, 0); start != 0 {
+		t.Fatalf("start = %d", start)
+	}
+}
+
+func TestPlayerPauseSeekAndVolume(t *testing.T) {
+	launches, _ := stubPlayer(t)
+	now := time.Now()
+	playerNow = func() time.Time { return now }
+	m := &modelState{songs: playerSongsForTest("a", "b"), selected: map[int]struct{}{}, replayGain: core.ReplayGainModeTrack}
+
+	cmd := m.playQueue(now)
+	if m.player.active() || !m.player.loading || len(*launches) != 0 {
+		t.Fatalf("play queue should prepare the source in the background: %+v", m.player)
+	}
+	if loadPlayerForTest(t, m, cmd); !m.player.active() {
+		t.Fatalf("play queue did not start: %+v", m.player)
+	}
+	now = now.Add(30 * time.Second)
+	m.seekPlayer(playerSeekStep, now)
+	if got := m.player.position(now); got != 40 {
+		t.Fatalf("position after seek = %v", got)
+	}
+	now = now.Add(5 * time.Second)
+	proc := m.player.proc.(*fakePlayerProcess)
+	m.togglePlayerPause(now)
+	if !m.player.paused || m.player.proc != proc {
+		t.Fatalf("pause should keep the same process: %+v", m.player)
+	}
+	// 暂停中时间不走，跳转只移动位置。
+	now = now.Add(time.Minute)
+	m.seekPlayer(-playerSeekStep, now)
+	if got := m.player.position(now); got != 35 {
+		t.Fatalf("paused seek = %v", got)
+	}
+	m.changePlayerVolume(-playerVolumeStep)
+	m.togglePlayerPause(now)
+	m.changePlayerVolume(playerVolumeStep * 5)
+	// 暂停、跳转和音量都不重启播放。
+	rg := core.ReplayGainModeTrack
+	if want := []playerLaunch{{"a", 0, 100, rg}}; !slices.Equal(*launches, want) {
+		t.Fatalf("launches = %v, want %v", *launches, want)
+	}
+	controls := []string{"seek 40", "paused true", "seek 35", "volume 90", "paused false", "volume 100"}
+	if !slices.Equal(proc.controls, controls) {
+		t.Fatalf("controls = %v, want %v", proc.controls, controls)
+	}
+
+	line := m.player.progressLine(now.Add(2*time.Second), 80)
+	if !strings.Contains(line, "0:37 / 1:40") || !strings.Contains(line, "(1/2)") || !strings.Contains(line, "音量 100%") {
+		t.Fatalf("progress line = %q", line)
+	}
+}
+
+func TestPlayerAdvancesThroughQueue(t *testing.T) {
+	launches, events := stubPlayer(t, "b")
+	now := time.Now()
+	m := &modelState{songs: playerSongsForTest("a", "b", "c"), selected: map[int]struct{}{}}
+	loadPlayerForTest(t, m, m.playQueue(now))
+
+	// 旧进程的退出消息不会切歌。
+	generation := m.player.generation
+	if m.updatePlayer(playerEndedMsg{generation: generation - 1}); m.player.index != 0 {
+		t.Fatalf("stale ended moved to %d", m.player.index)
+	}
+	// 播完 a 后跳过取不到音频的 b，直接播放 c。
+	loadPlayerForTest(t, m, m.updatePlayer(playerEndedMsg{generation: generation}))
+	if song := m.player.current(); song == nil || song.ID != "c" {
+		t.Fatalf("current after ended = %+v", song)
+	}
+	if got := (*events)[1]; got.Event != core.PlayEventComplete || got.SongID != "a" {
+		t.Fatalf("finished event = %+v", got)
+	}
+
+	// 上一首：播放超过 3 秒先回到开头，否则回到队列里的上一首。
+	m.skipTrack(-1, time.Now().Add(10*time.Second))
+	if proc := m.player.proc.(*fakePlayerProcess); m.player.index != 2 || m.player.offset != 0 || !slices.Equal(proc.controls, []string{"seek 0"}) {
+		t.Fatalf("prev restart = %+v", m.player)
+	}
+	loadPlayerForTest(t, m, m.skipTrack(-1, time.Now()))
+	if song := m.player.current(); song == nil || song.ID != "a" {
+		t.Fatalf("prev = %+v", song)
+	}
+
+	loadPlayerForTest(t, m, m.skipTrack(1, time.Now()))
+	loadPlayerForTest(t, m, m.skipTrack(1, time.Now()))
+	m.updatePlayer(playerEndedMsg{generation: m.player.generation})
+	if m.player.active() || m.statusMsg != "⏹ 播放队列已结束" {
+		t.Fatalf("queue end = %+v %q", m.player, m.statusMsg)
+	}
+	ids := make([]string, 0, len(*launches))
+	for _, launch := range *launches {
+		ids = append(ids, launch.songID)
+	}
+	if !slices.Equal(ids, []string{"a", "c", "a", "c"}) {
+		t.Fatalf("launched = %v", ids)
+	}
+}
+
+func TestPlayerRefreshesExpiredSourceAfterLongPause(t *testing.T) {
+	launches, _ := stubPlayer(t)
+	now := time.Now()
+	playerNow = func() time.Time { return now }
+	prepared := 0
+	preparePlaybackSource = func(song *model.Song) (string, string, error) {
+		prepared++
+		return "http://example.com/" + song.ID, "", nil
+	}
+	m := &modelState{songs: playerSongsForTest("a", "b"), selected: map[int]struct{}{}}
+	loadPlayerForTest(t, m, m.playQueue(now))
+
+	now = now.Add(20 * time.Second)
+	m.togglePlayerPause(now)
+	now = now.Add(10 * time.Minute)
+	m.togglePlayerPause(now)
+
+	// 继续播放时 ffmpeg 的连接已经断开，出错退出：重新解析后从原位置接着播。
+	ended := playerEndedMsg{generation: m.player.generation, err: errors.New("exit status 1")}
+	cmd := m.updatePlayer(ended)
+	if !m.player.loading || m.player.index != 0 {
+		t.Fatalf("expected a source refresh, got %+v", m.player)
+	}
+	loadPlayerForTest(t, m, cmd)
+	want := []playerLaunch{{songID: "a"}, {songID: "a", start: 20}}
+	for i := range want {
+		want[i].volume = 100
+	}
+	if prepared != 2 || !slices.Equal(*launches, want) {
+		t.Fatalf("prepared %d, launches = %v, want %v", prepared, *launches, want)
+	}
+
+	// 刚解析的链接也播不了就不再重试，按播完切到下一首。
+	cmd = m.updatePlayer(playerEndedMsg{generation: m.player.generation, err: errors.New("exit status 1")})
+	loadPlayerForTest(t, m, cmd)
+	if song := m.player.current(); song == nil || song.ID != "b" || prepared != 3 {
+		t.Fatalf("current = %+v, prepared %d", song, prepared)
+	}
+
+	// 停止后才回来的加载结果直接丢弃。
+	cmd = m.playFrom(0, 1, now)
+	m.stopPlayback()
+	if m.updatePlayer(cmd()); m.player.active() || len(*launches) != 3 {
+		t.Fatalf("stale load started playback: %+v", m.player)
+	}
+}
@@ -1,10 +1,10 @@
 package cli
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"net/http"
-	"os"
-	"os/exec"
 	"sort"
 	"strings"
 	"sync"
@@ -571,6 +571,7 @@ type modelState struct {
 	downloaded    int                 // 成功完成数量
 	skipped       int                 // 已存在跳过数量
 	failed        int                 // 失败数量
+	failedSongs   []string            // 失败歌曲，用于批量完成通知
 	allSongsSet   map[string]struct{} // SQLite 去重集合，批量下载时复用
 
 	// 换源队列管理
@@ -581,10 +582,17 @@ type modelState struct {
 	err       error
 	statusMsg string // 底部状态栏消息
 
-	// 试听播放 (ffplay)
-	playCmd      *exec.Cmd // 当前 ffplay 进程
-	playingName  string    // 正在播放的歌名，用于状态栏
-	playTempFile string    // soda 等临时文件，停止时删除
+	// 试听播放 (ffplay)：播放队列、暂停、跳转和音量
+	player *tuiPlayer
+	// replayGain 是设置里的响度均衡模式，启动 ffplay 时加上对应的滤镜。
+	replayGain string
+
+	// 播放历史：每首歌开始时记下的行号和开始时间，切歌或停止时据此补记完成或跳过。
+	playSong      model.Song
+	playHistoryID uint
+	playStartedAt time.Time
+	// sharedPlayback 表示共享播放会话当前记的是 TUI 的试听。
+	sharedPlayback bool
 
 	windowWidth  int
 	windowHeight int
@@ -633,12 +641,18 @@ func StartUI(initialKeyword string, sources []string, outDir string, withCover b
 		withCover:  withCover,
 		withLyrics: withLyrics,
 		pageSize:   pageSize,
+		replayGain: settings.ReplayGainMode,
 	}
 
 	p := tea.NewProgram(m, tea.WithAltScreen())
 	if _, err := p.Run(); err != nil {
+		core.Logger().Error("tui exited with error", "error", err)
 		fmt.Println("Error running program:", err)
 	}
+	// 退出前等待通知投递完成，避免最后一批下载的推送丢失。
+	core.WaitWebhookDeliveries(15 * time.Second)
+	// 没提交完的 scrobble 留在队列里，下次播放或 Web 服务启动后重试。
+	core.WaitScrobbles(5 * time.Second)
 }
 
 func (m modelState) Init() tea.Cmd {
@@ -658,6 +672,9 @@ func (m modelState) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			return m, tea.Quit
 		}
 
+	case playerEndedMsg, playerLoadedMsg, playerTickMsg:
+		return m, m.updatePlayer(msg)
+
 	case tea.WindowSizeMsg:
 		m.windowWidth = msg.Width
 		m.windowHeight = msg.Height
@@ -881,15 +898,34 @@ func (m modelState) updateList(msg tea.Msg) (tea.Model, tea.Cmd) {
 			if len(m.songs) == 0 || m.cursor < 0 || m.cursor >= len(m.songs) {
 				return m, nil
 			}
-			m.stopPlayback()
-			if err := m.startPlayback(m.songs[m.cursor]); err != nil {
-				m.statusMsg = fmt.Sprintf("播放失败: %v", err)
+			return m, m.playQueue(time.Now())
+		case "x":
+			return m, m.togglePlayerPause(time.Now())
+		case "left":
+			return m, m.seekPlayer(-playerSeekStep, time.Now())
+		case "right":
+			return m, m.seekPlayer(playerSeekStep, time.Now())
+		case "+", "=":
+			return m, m.changePlayerVolume(playerVolumeStep)
+		case "-":
+			return m, m.changePlayerVolume(-playerVolumeStep)
+		case ">", ".":
+			return m, m.skipTrack(1, time.Now())
+		case "<", ",":
+			return m, m.skipTrack(-1, time.Now())
+		case "n":
+			session, err := getPlaybackSession(time.Now())
+			if err != nil {
+				m.statusMsg = fmt.Sprintf("读取共享播放会话失败: %v", err)
 			} else {
-				m.statusMsg = fmt.Sprintf("▶ 正在播放: %s", m.playingName)
+				m.statusMsg = describePlaybackSession(session)
 			}
 			return m, nil
+		case "N":
+			m.statusMsg = toggleRemotePlayback(time.Now())
+			return m, nil
 		case "s":
-			if m.playCmd != nil {
+			if m.player.active() {
 				m.stopPlayback()
 				m.statusMsg = "⏹ 已停止播放"
 			}
@@ -910,7 +946,11 @@ func (m modelState) updateList(msg tea.Msg) (tea.Model, tea.Cmd) {
 			m.downloaded = 0
 			m.skipped = 0
 			m.failed = 0
-			m.allSongsSet, _ = core.LoadDownloadDedupSet()
+			m.failedSongs = nil
+			var dedupErr error
+			if m.allSongsSet, dedupErr = core.LoadDownloadDedupSet(); dedupErr != nil {
+				core.Logger().Warn("load download dedup set failed", "error", dedupErr)
+			}
 
 			skipCount := core.CountSkippable(m.downloadQueue, m.allSongsSet)
 			m.state = stateConfirmDownload
@@ -986,6 +1026,7 @@ func (m modelState) updateDownloading(msg tea.Msg) (tea.Model, tea.Cmd) {
 			m.statusMsg = fmt.Sprintf("⏭ 已跳过: %s - %s (已存在)", msg.song.Name, msg.song.Artist)
 		} else if msg.err != nil {
 			m.failed++
+			m.failedSongs = append(m.failedSongs, msg.song.Name+" - "+msg.song.Artist)
 			m.statusMsg = fmt.Sprintf("❌ 失败: %s - %s (%v)", msg.song.Name, msg.song.Artist, msg.err)
 		} else {
 			m.downloaded++
@@ -1003,6 +1044,13 @@ func (m modelState) updateDownloading(msg tea.Msg) (tea.Model, tea.Cmd) {
 			m.state = stateList
 			m.selected = make(map[int]struct{})
 			m.statusMsg = fmt.Sprintf("✅ 任务结束  成功: %d | 跳过: %d | 失败: %d", m.downloaded, m.skipped, m.failed)
+			core.NotifyBatchDownloadFinished(context.Background(), core.BatchDownloadSummary{
+				Total:    m.totalToDl,
+				Success:  m.downloaded,
+				Skipped:  m.skipped,
+				Failed:   m.failed,
+				Failures: m.failedSongs,
+			})
 			return m, nil
 		}
 
@@ -1163,6 +1211,7 @@ func probeSongsBatch(songs []model.Song) {
 // 异步搜索/解析命令 (修改版)
 func searchCmd(keyword string, searchType string, sources []string) tea.Cmd {
 	return func() tea.Msg {
+		logger := core.Logger().With("request_id", core.NewRequestID(), "search_type", searchType)
 		// 1. 链接解析模式
 		if strings.HasPrefix(keyword, "http") {
 			src := detectSource(keyword)
@@ -1219,14 +1268,17 @@ func searchCmd(keyword string, searchType string, sources []string) tea.Cmd {
 				wg.Add(1)
 				go func(s string, f func(string) ([]model.Playlist, error)) {
 					defer wg.Done()
-					if res, err := f(keyword); err == nil {
-						for i := range res {
-							res[i].Source = s
-						}
-						mu.Lock()
-						allPlaylists = append(allPlaylists, res...)
-						mu.Unlock()
+					res, err := f(keyword)
+					if err != nil {
+						logger.Warn("playlist search failed", "source", s, "error", err)
+						return
 					}
+					for i := range res {
+						res[i].Source = s
+					}
+					mu.Lock()
+					allPlaylists = append(allPlaylists, res...)
+					mu.Unlock()
 				}(src, fn)
 			}
 			wg.Wait()
@@ -1247,14 +1299,17 @@ func searchCmd(keyword string, searchType string, sources []string) tea.Cmd {
 				wg.Add(1)
 				go func(s string, f func(string) ([]model.Playlist, error)) {
 					defer wg.Done()
-					if res, err := f(keyword); err == nil {
-						for i := range res {
-							res[i].Source = s
-						}
-						mu.Lock()
-						allAlbums = append(allAlbums, res...)
-						mu.Unlock()
+					res, err := f(keyword)
+					if err != nil {
+						logger.Warn("album search failed", "source", s, "error", err)
+						return
 					}
+					for i := range res {
+						res[i].Source = s
+					}
+					mu.Lock()
+					allAlbums = append(allAlbums, res...)
+					mu.Unlock()
 				}(src, fn)
 			}
 			wg.Wait()
@@ -1275,7 +1330,11 @@ func searchCmd(keyword string, searchType string, sources []string) tea.Cmd {
 			go func(s string, f func(string) ([]model.Song, error)) {
 				defer wg.Done()
 				res, err := f(keyword)
-				if err == nil && len(res) > 0 {
+				if err != nil {
+					logger.Warn("song search failed", "source", s, "error", err)
+					return
+				}
+				if len(res) > 0 {
 					for i := range res {
 						res[i].Source = s
 					}
@@ -1370,7 +1429,8 @@ func downloadNextCmd(queue []model.Song, outDir string, withCover bool, withLyri
 			return nil
 		}
 		target := queue[0]
-		result, err := core.DownloadWithDedupCheck(&target, outDir, withCover, withLyrics, allSongsSet)
+		ctx := core.WithRequestID(context.Background(), core.NewRequestID())
+		result, err := core.DownloadWithDedupCheckContext(ctx, &target, outDir, withCover, withLyrics, "", allSongsSet)
 		return downloadOneFinishedMsg{
 			err:     err,
 			song:    target,
@@ -1387,44 +1447,221 @@ func switchSourceCmd(index int, song model.Song) tea.Cmd {
 	}
 }
 
-// stopPlayback 停止当前 ffplay 进程并清理临时文件。
+// stopPlayback 停止播放队列、结束 ffplay 进程并清理临时文件。
 func (m *modelState) stopPlayback() {
-	if m.playCmd != nil && m.playCmd.Process != nil {
-		_ = m.playCmd.Process.Kill()
-		_ = m.playCmd.Wait()
+	now := time.Now()
+	m.pauseSharedPlayback(now)
+	m.finishPlayHistory(now)
+	if m.player != nil {
+		m.player.stop()
 	}
-	m.playCmd = nil
-	if m.playTempFile != "" {
-		_ = os.Remove(m.playTempFile)
-		m.playTempFile = ""
+}
+
+// playbackPosition 返回当前歌曲已播放的秒数；没有播放器时按开始时间推算。
+func (m *modelState) playbackPosition(now time.Time) float64 {
+	if m.player.active() {
+		return m.player.position(now)
 	}
-	m.playingName = ""
+	return now.Sub(m.playStartedAt).Seconds()
 }
 
-// startPlayback 启动 ffplay 试听指定歌曲。
-func (m *modelState) startPlayback(song model.Song) error {
-	ffplayPath, err := core.ResolveFFplayPath()
-	if err != nil || ffplayPath == "" {
-		return fmt.Errorf("未找到 ffplay，请确认已安装 ffmpeg 并在 PATH 中")
+// recordPlayEvent 可在测试中替换，避免写入真实的播放历史。
+var recordPlayEvent = core.RecordPlayEvent
+
+// startPlayHistory 为刚开始的试听写一条播放历史，失败只记日志。
+func (m *modelState) startPlayHistory(song model.Song, now time.Time) {
+	m.playSong = song
+	m.playHistoryID = 0
+	m.playStartedAt = now
+	event := playEventForSong(song, core.PlayEventStart)
+	record, err := recordPlayEvent(event, now)
+	if err != nil {
+		core.Logger().Warn("record play history failed", "event", event.Event, "error", err)
+		return
+	}
+	if record != nil {
+		m.playHistoryID = record.ID
+	}
+}
+
+// finishPlayHistory 在切歌或停止试听时补记结果。ffplay 进程不会回报进度，
+// 所以按播放器推算的位置判断：接近歌曲时长算听完，否则算跳过。
+func (m *modelState) finishPlayHistory(now time.Time) {
+	if m.playHistoryID == 0 {
+		return
+	}
+	event := playEventForSong(m.playSong, core.PlayEventSkip)
+	event.PlayID = m.playHistoryID
+	event.Position = int(m.playbackPosition(now))
+	if m.playSong.Duration > 0 && event.Position >= m.playSong.Duration-2 {
+		event.Event = core.PlayEventComplete
+	}
+	m.playHistoryID = 0
+	if _, err := recordPlayEvent(event, now); err != nil {
+		core.Logger().Warn("record play history failed", "event", event.Event, "error", err)
+	}
+}
+
+func playEventForSong(song model.Song, eventType string) core.PlayEvent {
+	return core.PlayEvent{
+		Event:    eventType,
+		SongID:   song.ID,
+		Source:   song.Source,
+		Name:     song.Name,
+		Artist:   song.Artist,
+		Album:    song.Album,
+		Cover:    song.Cover,
+		Extra:    songExtraJSON(song),
+		Duration: song.Duration,
+		Client:   "tui",
 	}
+}
 
-	playURL, tempFile, err := core.PreparePlaybackSource(&song)
+// songExtraJSON 把 Extra 编码成网页端使用的 JSON 字符串。
+func songExtraJSON(song model.Song) string {
+	if len(song.Extra) == 0 {
+		return ""
+	}
+	data, err := json.Marshal(song.Extra)
 	if err != nil {
-		return err
+		return ""
 	}
+	return string(data)
+}
+
+// playbackDevice 是 TUI 在共享播放会话里的设备名。
+const playbackDevice = "tui"
+
+// 共享播放会话同样存放在配置数据库里，测试中替换。
+var (
+	applyPlaybackCommand = core.ApplyPlaybackCommand
+	getPlaybackSession   = core.GetPlaybackSession
+)
 
-	cmd := exec.Command(ffplayPath, core.PlaybackArgs(&song, playURL)...)
-	if err := cmd.Start(); err != nil {
-		if tempFile != "" {
-			_ = os.Remove(tempFile)
+// publishSharedPlayback 把当前列表和正在试听的歌曲写入共享播放会话，网页端
+// 和 App 可以从这里接着播放。失败只记日志，不影响试听。
+func (m *modelState) publishSharedPlayback(songs []model.Song, index int, now time.Time) {
+	items := make([]core.PlaybackQueueItem, 0, len(songs))
+	current := -1
+	for i, song := range songs {
+		if song.ID == "" || song.Source == "" || song.Name == "" {
+			continue
+		}
+		if i == index {
+			current = len(items)
+		}
+		items = append(items, core.PlaybackQueueItem{
+			SongID:   song.ID,
+			Source:   song.Source,
+			Name:     song.Name,
+			Artist:   song.Artist,
+			Album:    song.Album,
+			Cover:    song.Cover,
+			Duration: song.Duration,
+			Extra:    songExtraJSON(song),
+		})
+	}
+	if current < 0 {
+		return
+	}
+	start := 0.0
+	for _, cmd := range []core.PlaybackCommand{
+		{Action: core.PlaybackActionReplace, Items: items, Index: &current},
+		{Action: core.PlaybackActionPlay, Device: playbackDevice, Position: &start},
+	} {
+		if _, err := applyPlaybackCommand(cmd, now); err != nil {
+			core.Logger().Warn("update playback session failed", "action", cmd.Action, "error", err)
+			return
 		}
-		return err
 	}
+	m.sharedPlayback = true
+}
+
+// pauseSharedPlayback 在停止试听时暂停共享会话；会话已被其他设备接管时不动它。
+func (m *modelState) pauseSharedPlayback(now time.Time) {
+	if !m.sharedPlayback {
+		return
+	}
+	m.sharedPlayback = false
+	session, err := getPlaybackSession(now)
+	if err != nil || session.Device != playbackDevice || !session.Playing {
+		return
+	}
+	position := m.playbackPosition(now)
+	if _, err := applyPlaybackCommand(core.PlaybackCommand{Action: core.PlaybackActionPause, Device: playbackDevice, Position: &position, BaseRevision: session.Revision}, now); err != nil {
+		core.Logger().Warn("update playback session failed", "action", core.PlaybackActionPause, "error", err)
+	}
+}
+
+// syncSharedPlayback 把暂停、继续和跳转同步到共享会话；会话已被其他设备接管时不动它。
+func (m *modelState) syncSharedPlayback(now time.Time) {
+	if !m.sharedPlayback || !m.player.active() {
+		return
+	}
+	session, err := getPlaybackSession(now)
+	if err != nil || session.Device != playbackDevice {
+		return
+	}
+	cmd := core.PlaybackCommand{Action: core.PlaybackActionPlay, Device: playbackDevice, BaseRevision: session.Revision}
+	if m.player.paused {
+		cmd.Action = core.PlaybackActionPause
+	}
+	position := m.player.position(now)
+	cmd.Position = &position
+	if _, err := applyPlaybackCommand(cmd, now); err != nil {
+		core.Logger().Warn("update playback session failed", "action", cmd.Action, "error", err)
+	}
+}
+
+func describePlaybackSession(session core.PlaybackSession) string {
+	current := session.Current()
+	if current == nil {
+		return "共享播放会话为空"
+	}
+	state := "已暂停"
+	if session.Playing {
+		state = "播放中"
+	}
+	device := session.Device
+	if device == "" {
+		device = "未知设备"
+	}
+	position := formatPlaybackClock(int(session.Position))
+	if current.Duration > 0 {
+		position += " / " + formatPlaybackClock(current.Duration)
+	}
+	name := current.Name
+	if current.Artist != "" {
+		name += " - " + current.Artist
+	}
+	return fmt.Sprintf("共享会话 [%s · %s] %s  %s  (%d/%d)", device, state, name, position, session.Index+1, len(session.Queue))
+}
+
+// toggleRemotePlayback 暂停或继续其他设备上的共享会话，由正在播放的那一端执行。
+func toggleRemotePlayback(now time.Time) string {
+	session, err := getPlaybackSession(now)
+	if err != nil {
+		return fmt.Sprintf("读取共享播放会话失败: %v", err)
+	}
+	if session.Current() == nil || session.Device == "" || session.Device == playbackDevice {
+		return "没有其他设备上的播放会话"
+	}
+	cmd := core.PlaybackCommand{Action: core.PlaybackActionPlay, Device: session.Device, BaseRevision: session.Revision}
+	if session.Playing {
+		cmd = core.PlaybackCommand{Action: core.PlaybackActionPause, Device: playbackDevice, BaseRevision: session.Revision}
+	}
+	updated, err := applyPlaybackCommand(cmd, now)
+	if err != nil {
+		return fmt.Sprintf("控制共享播放会话失败: %v", err)
+	}
+	return describePlaybackSession(updated)
+}
 
-	m.playCmd = cmd
-	m.playTempFile = tempFile
-	m.playingName = song.Display()
-	return nil
+func formatPlaybackClock(seconds int) string {
+	if seconds < 0 {
+		seconds = 0
+	}
+	return fmt.Sprintf("%d:%02d", seconds/60, seconds%60)
 }
 
 // 内部下载实现（支持去重检查和记录）
@@ -1742,10 +1979,14 @@ func (m modelState) View() string {
 	case stateList:
 		s.WriteString(m.renderTable())
 		s.WriteString("\n")
+		if line := m.player.progressLine(time.Now(), m.windowWidth); line != "" {
+			s.WriteString(lipgloss.NewStyle().Foreground(greenColor).Render(line))
+			s.WriteString("\n")
+		}
 		statusStyle := lipgloss.NewStyle().Foreground(subtleColor)
 		s.WriteString(statusStyle.Render(m.statusMsg))
 		s.WriteString("\n\n")
-		s.WriteString(statusStyle.Render("↑/↓: 移动 • PgUp/PgDn: 翻页 • 空格: 选择 • a: 全选/清空 • p: 播放 • s: 停止 • r: 换源 • Enter: 下载 • b: 返回 • q: 退出"))
+		s.WriteString(statusStyle.Render("↑/↓: 移动 • PgUp/PgDn: 翻页 • 空格: 选择 • a: 全选/清空 • p: 播放队列 • x: 暂停 • ←/→: 快退/快进 • </>: 上/下一首 • +/-: 音量 • s: 停止 • n/N: 共享会话/远程暂停 • r: 换源 • Enter: 下载 • b: 返回 • q: 退出"))
 	case statePlaylistResult: // 新增
 		s.WriteString(m.renderCollectionTable())
 		s.WriteString("\n")
@@ -2008,6 +2249,9 @@ func (m modelState) maxRowsForListView() int {
 		return 0
 	}
 	available := m.windowHeight - listViewReservedRows
+	if m.player.active() {
+		available-- // 播放进度行
+	}
 	if available < 1 {
 		return 1
 	}
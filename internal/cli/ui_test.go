@@ -4,8 +4,10 @@ import (
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/guohuiyuan/go-music-dl/core"
+	"github.com/guohuiyuan/music-lib/model"
 )
 
 func TestNextSearchTypeCyclesAllModes(t *testing.T) {
@@ -69,3 +71,82 @@ func TestAlbumFunctionsAreWiredForSupportedSources(t *testing.T) {
 		}
 	}
 }
+
+func TestPlaybackRecordsPlayHistory(t *testing.T) {
+	var events []core.PlayEvent
+	previous := recordPlayEvent
+	recordPlayEvent = func(event core.PlayEvent, _ time.Time) (*core.PlayHistory, error) {
+		events = append(events, event)
+		return &core.PlayHistory{ID: uint(len(events))}, nil
+	}
+	t.Cleanup(func() { recordPlayEvent = previous })
+
+	start := time.Now()
+	m := &modelState{}
+	song := model.Song{ID: "1", Source: "qq", Name: "Song", Artist: "Artist", Duration: 200, Extra: map[string]string{"mid": "x"}}
+	m.startPlayHistory(song, start)
+	m.finishPlayHistory(start.Add(40 * time.Second))
+	m.startPlayHistory(song, start)
+	m.finishPlayHistory(start.Add(199 * time.Second))
+	// 已经补记过的播放不会重复上报。
+	m.finishPlayHistory(start.Add(300 * time.Second))
+
+	if len(events) != 4 {
+		t.Fatalf("events = %+v", events)
+	}
+	if events[0].Event != core.PlayEventStart || events[0].Client != "tui" || events[0].Extra != `{"mid":"x"}` {
+		t.Fatalf("start event = %+v", events[0])
+	}
+	if events[1].Event != core.PlayEventSkip || events[1].PlayID != 1 || events[1].Position != 40 {
+		t.Fatalf("skip event = %+v", events[1])
+	}
+	if events[3].Event != core.PlayEventComplete || events[3].PlayID != 3 {
+		t.Fatalf("complete event = %+v", events[3])
+	}
+}
+
+func TestPlaybackPublishesSharedSession(t *testing.T) {
+	var commands []core.PlaybackCommand
+	owner := playbackDevice
+	previousApply, previousGet := applyPlaybackCommand, getPlaybackSession
+	applyPlaybackCommand = func(cmd core.PlaybackCommand, _ time.Time) (core.PlaybackSession, error) {
+		commands = append(commands, cmd)
+		return core.PlaybackSession{}, nil
+	}
+	getPlaybackSession = func(time.Time) (core.PlaybackSession, error) {
+		return core.PlaybackSession{Device: owner, Playing: true, Revision: 5}, nil
+	}
+	t.Cleanup(func() { applyPlaybackCommand, getPlaybackSession = previousApply, previousGet })
+
+	start := time.Now()
+	m := &modelState{playStartedAt: start}
+	songs := []model.Song{
+		{ID: "1", Source: "qq", Name: "One"},
+		{Name: "missing id"},
+		{ID: "3", Source: "qq", Name: "Three", Extra: map[string]string{"mid": "x"}},
+	}
+	m.publishSharedPlayback(songs, 2, start)
+	if len(commands) != 2 || commands[0].Action != core.PlaybackActionReplace || len(commands[0].Items) != 2 || *commands[0].Index != 1 || commands[0].Items[1].Extra != `{"mid":"x"}` {
+		t.Fatalf("replace = %+v", commands)
+	}
+	if commands[1].Action != core.PlaybackActionPlay || commands[1].Device != playbackDevice || !m.sharedPlayback {
+		t.Fatalf("play = %+v", commands[1])
+	}
+
+	m.pauseSharedPlayback(start.Add(30 * time.Second))
+	if len(commands) != 3 || commands[2].Action != core.PlaybackActionPause || *commands[2].Position != 30 || commands[2].BaseRevision != 5 {
+		t.Fatalf("pause = %+v", commands)
+	}
+	// 会话已被网页端接管时，TUI 停止试听不会暂停它。
+	owner = "web-1"
+	m.sharedPlayback = true
+	m.pauseSharedPlayback(start.Add(time.Minute))
+	if len(commands) != 3 {
+		t.Fatalf("paused a session owned by another device: %+v", commands[3:])
+	}
+
+	session := core.PlaybackSession{Queue: []core.PlaybackQueueItem{{Name: "Song", Artist: "Artist", Duration: 200}}, Position: 65, Playing: true, Device: "web-1"}
+	if got := describePlaybackSession(session); got != "共享会话 [web-1 · 播放中] Song - Artist  1:05 / 3:20  (1/1)" {
+		t.Fatalf("describe = %q", got)
+	}
+}
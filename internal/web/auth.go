@@ -250,6 +250,17 @@ func wantsHTML(c *gin.Context) bool {
 	return accept == "" || strings.Contains(accept, "text/html")
 }
 
+// requestAuthenticated reports whether c carries a valid login session. Unlike
+// authRequired it never rejects the request.
+func requestAuthenticated(c *gin.Context, provider authSettingsProvider) bool {
+	settings, err := provider()
+	if err != nil || !authConfigured(settings) {
+		return false
+	}
+	value, err := c.Cookie(authCookieName)
+	return err == nil && validateSessionValue(settings, value, time.Now())
+}
+
 func authRequired(provider authSettingsProvider) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		settings, err := provider()
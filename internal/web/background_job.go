@@ -0,0 +1,189 @@
+package web
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 曲库和歌单里的长任务（识别、指纹、转码、歌单导入、迁移、响度分析、整理）
+// 都是同一个形状：一批条目交给几个 worker 逐个处理，前端轮询快照看进度，
+// 随时可以取消。backgroundJob 是它们共用的部分，各任务把它嵌进自己的结构体，
+// 只补充自己的计数和结果；JSON 里这些字段和任务自己的字段平铺在一起。
+
+type backgroundJob struct {
+	ID         string     `json:"id"`
+	Status     string     `json:"status"`
+	Total      int        `json:"total"`
+	Processed  int        `json:"processed"`
+	Failed     int        `json:"failed"`
+	LastError  string     `json:"last_error,omitempty"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+
+	mu   sync.Mutex
+	ctx  context.Context
+	stop context.CancelFunc
+	done chan struct{}
+}
+
+// newBackgroundJob starts a job of total items in the given status, usually
+// running; queued jobs switch to running when their turn comes.
+func newBackgroundJob(status string, total int) backgroundJob {
+	ctx, stop := context.WithCancel(context.Background())
+	return backgroundJob{
+		ID:        strconv.FormatInt(time.Now().UnixNano(), 36),
+		Status:    status,
+		Total:     total,
+		StartedAt: time.Now(),
+		ctx:       ctx,
+		stop:      stop,
+		done:      make(chan struct{}),
+	}
+}
+
+func (job *backgroundJob) running() bool {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	return job.Status == "running"
+}
+
+func (job *backgroundJob) finished() bool {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	return job.FinishedAt != nil
+}
+
+func (job *backgroundJob) cancel() {
+	job.stop()
+}
+
+func (job *backgroundJob) isCancelled() bool {
+	return job.ctx.Err() != nil
+}
+
+// failLocked records one failed item; callers hold job.mu.
+func (job *backgroundJob) failLocked(err error) {
+	job.Failed++
+	job.LastError = err.Error()
+}
+
+// snapshotLocked copies the shared fields for JSON while workers keep
+// updating the job. Callers hold job.mu and copy their own fields next to it.
+func (job *backgroundJob) snapshotLocked() backgroundJob {
+	return backgroundJob{
+		ID:         job.ID,
+		Status:     job.Status,
+		Total:      job.Total,
+		Processed:  job.Processed,
+		Failed:     job.Failed,
+		LastError:  job.LastError,
+		StartedAt:  job.StartedAt,
+		FinishedAt: job.FinishedAt,
+		done:       job.done,
+	}
+}
+
+// process hands the indexes 0..Total-1 to workers goroutines. item handles
+// one index outside the lock; the record func it returns, if any, runs under
+// job.mu together with the Processed count. Nothing new is handed out once
+// the job is cancelled.
+func (job *backgroundJob) process(workers int, item func(index int) (record func())) {
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for worker := 0; worker < workers; worker++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range indexes {
+				record := item(index)
+				job.mu.Lock()
+				job.Processed++
+				if record != nil {
+					record()
+				}
+				job.mu.Unlock()
+			}
+		}()
+	}
+	for index := 0; index < job.Total; index++ {
+		if job.isCancelled() {
+			break
+		}
+		indexes <- index
+	}
+	close(indexes)
+	wg.Wait()
+}
+
+// finish settles the final status: cancelled wins, then err marks the job
+// failed. done is closed last so waiters see the final state.
+func (job *backgroundJob) finish(err error) {
+	now := time.Now()
+	job.mu.Lock()
+	switch {
+	case job.isCancelled():
+		job.Status = "cancelled"
+	case err != nil:
+		job.Status = "failed"
+		job.LastError = err.Error()
+	default:
+		job.Status = "done"
+	}
+	job.FinishedAt = &now
+	job.mu.Unlock()
+	job.stop()
+	close(job.done)
+}
+
+// backgroundJobSlot keeps the latest job of one kind. Only one may run at a
+// time; a finished one stays around so the page can still show its results.
+type backgroundJobSlot[J interface{ running() bool }] struct {
+	mu      sync.Mutex
+	current J
+	set     bool
+	busy    error
+}
+
+func newBackgroundJobSlot[J interface{ running() bool }](busy string) *backgroundJobSlot[J] {
+	return &backgroundJobSlot[J]{busy: errors.New(busy)}
+}
+
+func (slot *backgroundJobSlot[J]) get() J {
+	slot.mu.Lock()
+	defer slot.mu.Unlock()
+	return slot.current
+}
+
+// install makes job the current one unless another is still running.
+func (slot *backgroundJobSlot[J]) install(job J) error {
+	slot.mu.Lock()
+	defer slot.mu.Unlock()
+	if slot.set && slot.current.running() {
+		return slot.busy
+	}
+	slot.current, slot.set = job, true
+	return nil
+}
+
+func (slot *backgroundJobSlot[J]) reset() {
+	slot.mu.Lock()
+	defer slot.mu.Unlock()
+	var zero J
+	slot.current, slot.set = zero, false
+}
+
+// startError writes a failed start: 409 while another job of this kind is
+// running, 400 otherwise.
+func (slot *backgroundJobSlot[J]) startError(c *gin.Context, err error) {
+	status := http.StatusBadRequest
+	if errors.Is(err, slot.busy) {
+		status = http.StatusConflict
+	}
+	c.JSON(status, gin.H{"error": err.Error()})
+}
@@ -24,6 +24,7 @@ const (
 
 	collectionKindManual   = "manual"
 	collectionKindImported = "imported"
+	collectionKindSmart    = "smart"
 
 	collectionContentPlaylist = "playlist"
 	collectionContentAlbum    = "album"
@@ -38,21 +39,33 @@ var (
 
 // Collection stores local entries shown in "My Collections".
 // Manual collections persist songs in SavedSong. Imported entries only keep
-// metadata and fetch songs on demand from the upstream source.
+// metadata and fetch songs on demand from the upstream source. Smart
+// collections keep a rule set evaluated against the local library index.
 type Collection struct {
-	ID          uint        `gorm:"primaryKey" json:"id"`
-	Name        string      `gorm:"not null" json:"name"`
-	Description string      `json:"description"`
-	Cover       string      `json:"cover"`
-	Kind        string      `gorm:"not null;default:manual" json:"kind"`
-	ContentType string      `gorm:"column:content_type;not null;default:playlist" json:"content_type"`
-	Source      string      `gorm:"not null;default:local" json:"source"`
-	ExternalID  string      `json:"external_id"`
-	Link        string      `json:"link"`
-	Creator     string      `json:"creator"`
-	TrackCount  int         `json:"track_count"`
-	CreatedAt   time.Time   `json:"created_at"`
-	SavedSongs  []SavedSong `gorm:"constraint:OnDelete:CASCADE;" json:"-"`
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	Name        string    `gorm:"not null" json:"name"`
+	Description string    `json:"description"`
+	Cover       string    `json:"cover"`
+	Kind        string    `gorm:"not null;default:manual" json:"kind"`
+	ContentType string    `gorm:"column:content_type;not null;default:playlist" json:"content_type"`
+	Source      string    `gorm:"not null;default:local" json:"source"`
+	ExternalID  string    `json:"external_id"`
+	Link        string    `json:"link"`
+	Creator     string    `json:"creator"`
+	TrackCount  int       `json:"track_count"`
+	CreatedAt   time.Time `json:"created_at"`
+	Rules       string    `json:"rules,omitempty"` // 智能歌单的规则（smartCollectionRules 的 JSON）
+	// 分组文件夹（可用 / 嵌套，例如“运动/跑步”）和逗号分隔的标签。
+	Folder string `gorm:"index" json:"folder"`
+	Tags   string `json:"tags"`
+	// 导入歌单的同步设置，SyncIntervalHours 为 0 时只手动同步。
+	SyncIntervalHours int                   `json:"sync_interval_hours"`
+	SyncAutoDownload  bool                  `json:"sync_auto_download"`
+	LastSyncedAt      *time.Time            `json:"last_synced_at,omitempty"`
+	LastSyncError     string                `json:"last_sync_error,omitempty"`
+	SavedSongs        []SavedSong           `gorm:"constraint:OnDelete:CASCADE;" json:"-"`
+	Migrations        []CollectionMigration `gorm:"constraint:OnDelete:CASCADE;" json:"-"`
+	Snapshots         []CollectionSnapshot  `gorm:"constraint:OnDelete:CASCADE;" json:"-"`
 }
 
 type SavedSong struct {
@@ -66,6 +79,10 @@ type SavedSong struct {
 	Cover        string    `json:"cover"`
 	Duration     int       `json:"duration"`
 	AddedAt      time.Time `json:"added_at"`
+	// Position 越大越靠前；新加入的歌曲取当前最大值 + 1，见 BeforeCreate。
+	Position int    `gorm:"not null;default:0" json:"position"`
+	Note     string `json:"note"`
+	Rating   int    `json:"rating"`
 }
 
 type importCollectionRequest struct {
@@ -81,8 +98,11 @@ type importCollectionRequest struct {
 }
 
 func (c Collection) normalizedKind() string {
-	if strings.TrimSpace(c.Kind) == collectionKindImported {
+	switch strings.TrimSpace(c.Kind) {
+	case collectionKindImported:
 		return collectionKindImported
+	case collectionKindSmart:
+		return collectionKindSmart
 	}
 	return collectionKindManual
 }
@@ -110,7 +130,7 @@ func (c Collection) isImported() bool {
 }
 
 func (c Collection) isManual() bool {
-	return !c.isImported()
+	return c.normalizedKind() == collectionKindManual
 }
 
 func (c Collection) editable() bool {
@@ -134,6 +154,9 @@ func (c Collection) displayCreator() string {
 		}
 		return "外部导入歌单"
 	}
+	if c.isSmart() {
+		return "智能歌单"
+	}
 	return "我自己"
 }
 
@@ -152,12 +175,16 @@ func (c Collection) playlistCard() model.Playlist {
 	trackCount := c.TrackCount
 	if c.isManual() {
 		trackCount = countSavedSongs(c.ID)
+	} else if c.isSmart() {
+		trackCount = countSmartCollectionSongs(c)
 	}
 
 	extra := map[string]string{
 		"collection_kind": c.normalizedKind(),
 		"content_type":    c.normalizedContentType(),
 		"editable":        fmt.Sprintf("%t", c.editable()),
+		"folder":          c.Folder,
+		"tags":            c.Tags,
 	}
 	if remoteSource := c.normalizedSource(); c.isImported() && remoteSource != "" {
 		extra["remote_source"] = remoteSource
@@ -188,9 +215,25 @@ func InitDB() {
 		panic("Failed to connect to SQLite: " + err.Error())
 	}
 
-	if err := db.AutoMigrate(&Collection{}, &SavedSong{}, &LocalMusicIndex{}); err != nil {
+	if err := migrateLocalMusicIndexSeq(db); err != nil {
+		panic("Failed to migrate local music index: " + err.Error())
+	}
+	if err := db.AutoMigrate(&Collection{}, &SavedSong{}, &LocalMusicIndex{}, &LocalMusicPlay{}, &LocalMusicTagEdit{}, &LocalMusicIdentity{}, &CollectionMigration{}, &CollectionSnapshot{}); err != nil {
 		panic("Failed to migrate database: " + err.Error())
 	}
+	if err := migrateLocalMusicIndexRoots(); err != nil {
+		panic("Failed to migrate local music index: " + err.Error())
+	}
+	if err := migrateLocalMusicIndexAddedAt(); err != nil {
+		panic("Failed to migrate local music index: " + err.Error())
+	}
+	if err := migrateLocalMusicIdentities(); err != nil {
+		panic("Failed to migrate local music identities: " + err.Error())
+	}
+	resetLocalMusicIdentityCache()
+	if err := migrateLocalMusicFTS(db); err != nil {
+		core.Logger().Warn("local music full-text index unavailable, falling back to LIKE search", "error", err)
+	}
 
 	if err := migrateLegacyFavorites(dbPath); err != nil {
 		panic("Failed to migrate legacy favorites database: " + err.Error())
@@ -201,6 +244,9 @@ func InitDB() {
 }
 
 func CloseDB() {
+	if err := flushLocalMusicIdentities(); err != nil {
+		core.Logger().Warn("save local music identities failed", "error", err)
+	}
 	if db != nil {
 		sqlDB, err := db.DB()
 		if err == nil {
@@ -358,14 +404,24 @@ func loadCollectionSongs(collection *Collection) ([]model.Song, error) {
 		return nil, fmt.Errorf("collection is nil")
 	}
 	if collection.isImported() {
-		return loadImportedCollectionSongs(collection)
+		songs, err := loadImportedCollectionSongs(collection)
+		if err != nil || len(songs) == 0 {
+			// 上游拉不到时退回到最后一次同步的快照。
+			if snapshot, ok := loadCollectionSnapshotSongs(collection.ID); ok {
+				return snapshot, nil
+			}
+		}
+		return songs, err
+	}
+	if collection.isSmart() {
+		return loadSmartCollectionSongs(collection)
 	}
 	return loadSavedSongs(collection.ID)
 }
 
 func loadSavedSongs(collectionID uint) ([]model.Song, error) {
 	var savedSongs []SavedSong
-	if err := db.Where("collection_id = ?", collectionID).Order("id DESC").Find(&savedSongs).Error; err != nil {
+	if err := db.Where("collection_id = ?", collectionID).Order(savedSongOrder).Find(&savedSongs).Error; err != nil {
 		return nil, err
 	}
 
@@ -554,8 +610,8 @@ func collectionSongsJSON(collection *Collection) ([]gin.H, error) {
 		return nil, fmt.Errorf("collection is nil")
 	}
 
-	if collection.isImported() {
-		songs, err := loadImportedCollectionSongs(collection)
+	if !collection.isManual() {
+		songs, err := loadCollectionSongs(collection)
 		if err != nil {
 			return nil, err
 		}
@@ -579,7 +635,7 @@ func collectionSongsJSON(collection *Collection) ([]gin.H, error) {
 	}
 
 	var savedSongs []SavedSong
-	if err := db.Where("collection_id = ?", collection.ID).Order("id DESC").Find(&savedSongs).Error; err != nil {
+	if err := db.Where("collection_id = ?", collection.ID).Order(savedSongOrder).Find(&savedSongs).Error; err != nil {
 		return nil, err
 	}
 
@@ -600,6 +656,9 @@ func collectionSongsJSON(collection *Collection) ([]gin.H, error) {
 			"duration":      s.Duration,
 			"link":          extraMapValue(extraMap, "link"),
 			"added_at":      s.AddedAt,
+			"position":      s.Position,
+			"note":          s.Note,
+			"rating":        s.Rating,
 		})
 	}
 	return resp, nil
@@ -613,6 +672,13 @@ func RegisterCollectionRoutes(api *gin.RouterGroup) {
 			return
 		}
 
+		folders, tags := collectionFoldersAndTags(collections)
+		folder, tag := normalizeCollectionFolder(c.Query("folder")), strings.TrimSpace(c.Query("tag"))
+		c.Set("CollectionFolders", folders)
+		c.Set("CollectionTags", tags)
+		c.Set("CollectionFilter", gin.H{"Folder": folder, "Tag": tag})
+
+		collections = organizeCollectionsForGrid(collections, folder, tag)
 		playlists := make([]model.Playlist, 0, len(collections))
 		for _, collection := range collections {
 			playlists = append(playlists, collection.playlistCard())
@@ -786,6 +852,10 @@ func RegisterCollectionRoutes(api *gin.RouterGroup) {
 			c.JSON(404, gin.H{"error": "歌单不存在"})
 			return
 		}
+		if collection.isSmart() {
+			c.JSON(400, gin.H{"error": smartCollectionReadonlyError})
+			return
+		}
 		if collection.isImported() {
 			c.JSON(400, gin.H{"error": "外部导入歌单/专辑不保存歌曲明细，不能直接加入歌曲"})
 			return
@@ -837,6 +907,10 @@ func RegisterCollectionRoutes(api *gin.RouterGroup) {
 			c.JSON(404, gin.H{"error": "歌单不存在"})
 			return
 		}
+		if collection.isSmart() {
+			c.JSON(400, gin.H{"error": smartCollectionReadonlyError})
+			return
+		}
 		if collection.isImported() {
 			c.JSON(400, gin.H{"error": "外部导入歌单/专辑不保存歌曲明细，不能直接加入歌曲"})
 			return
@@ -910,6 +984,10 @@ func RegisterCollectionRoutes(api *gin.RouterGroup) {
 			c.JSON(404, gin.H{"error": "歌单不存在"})
 			return
 		}
+		if collection.isSmart() {
+			c.JSON(400, gin.H{"error": smartCollectionReadonlyError})
+			return
+		}
 		if collection.isImported() {
 			c.JSON(400, gin.H{"error": "外部导入歌单/专辑没有本地歌曲明细可删除"})
 			return
@@ -972,4 +1050,10 @@ func RegisterCollectionRoutes(api *gin.RouterGroup) {
 		}
 		c.JSON(200, gin.H{"status": "ok"})
 	})
+
+	registerCollectionPlaylistRoutes(colAPI)
+	registerCollectionMigrationRoutes(colAPI)
+	registerCollectionSyncRoutes(colAPI)
+	registerCollectionSmartRoutes(colAPI)
+	registerCollectionOrganizeRoutes(colAPI)
 }
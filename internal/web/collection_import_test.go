@@ -24,6 +24,12 @@ func initCollectionDBForTest(t *testing.T) {
 	t.Setenv("MUSIC_DL_FAVORITES_DB", legacyDB)
 	resetCollectionStateForTest()
 	t.Cleanup(resetCollectionStateForTest)
+	// Keep index writes away from the process-wide core settings database.
+	originalSearchOptions := localMusicSearchOptionsProvider
+	localMusicSearchOptionsProvider = func() localMusicSearchOptions {
+		return localMusicSearchOptions{Pinyin: true, FoldVariants: true}
+	}
+	t.Cleanup(func() { localMusicSearchOptionsProvider = originalSearchOptions })
 
 	InitDB()
 }
@@ -0,0 +1,712 @@
+package web
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/guohuiyuan/go-music-dl/core"
+	"github.com/guohuiyuan/music-lib/model"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// 跨平台歌单迁移：把一个在线歌单 / 专辑或本地歌单逐首换到一个或多个目标
+// 平台上。每首歌复用换源的搜索、排序和可播放校验，结果写成新的本地歌单，
+// 同时保存一份匹配报告（已匹配 / 低置信度 / 未找到），之后可以在报告里
+// 换候选、重新搜索或导出。
+
+const (
+	collectionMigrationDefaultThreshold = 0.9
+	collectionMigrationMaxTracks        = 2000
+	collectionMigrationMaxCandidates    = 5
+	collectionMigrationWorkers          = 3
+
+	migrationStatusPending       = "pending"
+	migrationStatusMatched       = "matched"
+	migrationStatusLowConfidence = "low_confidence"
+	migrationStatusNotFound      = "not_found"
+)
+
+var (
+	errCollectionMigrationNoJob = errors.New("没有歌单迁移任务")
+
+	collectionMigrationJobs = newBackgroundJobSlot[*collectionMigrationJob]("已有歌单迁移任务在进行中")
+)
+
+// CollectionMigration 是一次迁移的报告，Report 是 collectionMigrationTrack
+// 列表的 JSON。报告随生成的歌单一起删除。
+type CollectionMigration struct {
+	ID            uint      `gorm:"primaryKey" json:"id"`
+	CollectionID  uint      `gorm:"index" json:"collection_id"`
+	Name          string    `json:"name"`
+	SourceKind    string    `json:"source_kind"`
+	Source        string    `json:"source"`
+	SourceRef     string    `json:"source_ref"`
+	Targets       string    `json:"targets"`
+	Threshold     float64   `json:"threshold"`
+	Total         int       `json:"total"`
+	Matched       int       `json:"matched"`
+	LowConfidence int       `json:"low_confidence"`
+	NotFound      int       `json:"not_found"`
+	Report        string    `json:"-"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+type collectionMigrationRequest struct {
+	Kind         string   `json:"kind"`
+	Source       string   `json:"source"`
+	ID           string   `json:"id"`
+	Link         string   `json:"link"`
+	CollectionID uint     `json:"collection_id"`
+	Name         string   `json:"name"`
+	Targets      []string `json:"targets"`
+	Threshold    float64  `json:"threshold"`
+	// Validate 为 nil 时默认校验候选能否播放。
+	Validate *bool `json:"validate"`
+}
+
+type collectionMigrationCandidate struct {
+	ID       string            `json:"id"`
+	Source   string            `json:"source"`
+	Name     string            `json:"name"`
+	Artist   string            `json:"artist"`
+	Album    string            `json:"album"`
+	Cover    string            `json:"cover"`
+	Duration int               `json:"duration"`
+	Score    float64           `json:"score"`
+	DurDiff  int               `json:"dur_diff"`
+	Extra    map[string]string `json:"extra,omitempty"`
+}
+
+// collectionMigrationTrack 是报告的一行。Selected 是已放进歌单的候选，
+// -1 表示这首没有放进歌单（低置信度的候选要用户确认后才加入）。
+type collectionMigrationTrack struct {
+	Index      int                            `json:"index"`
+	Name       string                         `json:"name"`
+	Artist     string                         `json:"artist"`
+	Album      string                         `json:"album"`
+	Duration   int                            `json:"duration"`
+	Source     string                         `json:"source"`
+	SongID     string                         `json:"song_id"`
+	Status     string                         `json:"status"`
+	Score      float64                        `json:"score"`
+	Selected   int                            `json:"selected"`
+	Candidates []collectionMigrationCandidate `json:"candidates"`
+
+	song model.Song
+}
+
+type collectionMigrationJob struct {
+	backgroundJob
+	Name          string                      `json:"name"`
+	Targets       []string                    `json:"targets"`
+	Threshold     float64                     `json:"threshold"`
+	Validate      bool                        `json:"validate"`
+	Matched       int                         `json:"matched"`
+	LowConfidence int                         `json:"low_confidence"`
+	NotFound      int                         `json:"not_found"`
+	MigrationID   uint                        `json:"migration_id,omitempty"`
+	CollectionID  uint                        `json:"collection_id,omitempty"`
+	Tracks        []*collectionMigrationTrack `json:"tracks"`
+
+	sourceKind string
+	source     string
+	sourceRef  string
+}
+
+func registerCollectionMigrationRoutes(colAPI *gin.RouterGroup) {
+	colAPI.GET("/migrate/targets", func(c *gin.Context) {
+		targets := make([]gin.H, 0)
+		for _, source := range switchCandidateSources("", "") {
+			targets = append(targets, gin.H{"name": source, "label": core.GetSourceDescription(source)})
+		}
+		c.JSON(200, gin.H{"targets": targets})
+	})
+
+	colAPI.POST("/migrate", requireSameOriginWrite, func(c *gin.Context) {
+		var req collectionMigrationRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": "参数错误"})
+			return
+		}
+		job, err := startCollectionMigration(req)
+		if err != nil {
+			collectionMigrationJobs.startError(c, err)
+			return
+		}
+		c.JSON(200, gin.H{"status": "started", "job": job.snapshot()})
+	})
+
+	colAPI.GET("/migrate", func(c *gin.Context) {
+		job := collectionMigrationJobs.get()
+		if job == nil {
+			c.JSON(200, gin.H{"job": nil})
+			return
+		}
+		c.JSON(200, gin.H{"job": job.snapshot()})
+	})
+
+	colAPI.POST("/migrate/cancel", requireSameOriginWrite, func(c *gin.Context) {
+		job := collectionMigrationJobs.get()
+		if job == nil {
+			c.JSON(404, gin.H{"error": errCollectionMigrationNoJob.Error()})
+			return
+		}
+		job.cancel()
+		c.JSON(200, gin.H{"status": "ok"})
+	})
+
+	colAPI.GET("/migrations", func(c *gin.Context) {
+		var migrations []CollectionMigration
+		query := db.Omit("report").Order("id DESC")
+		if id := c.Query("collection_id"); id != "" {
+			query = query.Where("collection_id = ?", id)
+		}
+		if err := query.Find(&migrations).Error; err != nil {
+			c.JSON(500, gin.H{"error": "获取迁移报告失败"})
+			return
+		}
+		c.JSON(200, gin.H{"migrations": migrations})
+	})
+
+	colAPI.GET("/migrations/:id", func(c *gin.Context) {
+		migration, tracks, err := loadCollectionMigration(c.Param("id"))
+		if err != nil {
+			c.JSON(404, gin.H{"error": "迁移报告不存在"})
+			return
+		}
+		c.JSON(200, gin.H{"migration": migration, "tracks": tracks})
+	})
+
+	colAPI.GET("/migrations/:id/report", func(c *gin.Context) {
+		migration, tracks, err := loadCollectionMigration(c.Param("id"))
+		if err != nil {
+			c.JSON(404, gin.H{"error": "迁移报告不存在"})
+			return
+		}
+		switch format := c.DefaultQuery("format", "csv"); format {
+		case "csv":
+			body, err := collectionMigrationReportCSV(tracks)
+			if err != nil {
+				c.JSON(500, gin.H{"error": err.Error()})
+				return
+			}
+			setDownloadHeader(c, migration.Name+" 迁移报告.csv")
+			// 带 BOM，Excel 才能认出 UTF-8。
+			c.Data(http.StatusOK, "text/csv; charset=utf-8", append([]byte("\xef\xbb\xbf"), body...))
+		case "json":
+			setDownloadHeader(c, migration.Name+" 迁移报告.json")
+			c.JSON(200, gin.H{"migration": migration, "tracks": tracks})
+		default:
+			c.JSON(400, gin.H{"error": "不支持的导出格式: " + format})
+		}
+	})
+
+	colAPI.PUT("/migrations/:id/tracks/:index", requireSameOriginWrite, func(c *gin.Context) {
+		var req struct {
+			Candidate *int `json:"candidate"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil || req.Candidate == nil {
+			c.JSON(400, gin.H{"error": "参数错误"})
+			return
+		}
+		index, _ := strconv.Atoi(c.Param("index"))
+		track, err := selectCollectionMigrationCandidate(c.Param("id"), index, *req.Candidate)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(200, gin.H{"status": "ok", "track": track})
+	})
+
+	colAPI.POST("/migrations/:id/tracks/:index/search", requireSameOriginWrite, func(c *gin.Context) {
+		var req struct {
+			Keyword string `json:"keyword"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": "参数错误"})
+			return
+		}
+		index, _ := strconv.Atoi(c.Param("index"))
+		track, err := researchCollectionMigrationTrack(c.Param("id"), index, req.Keyword)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(200, gin.H{"status": "ok", "track": track})
+	})
+}
+
+// collectionMigrationTargets keeps the requested targets that can be
+// searched, in request order.
+func collectionMigrationTargets(requested []string) []string {
+	allowed := switchCandidateSources("", "")
+	targets := make([]string, 0, len(requested))
+	for _, target := range requested {
+		target = strings.TrimSpace(target)
+		if containsString(allowed, target) && !containsString(targets, target) {
+			targets = append(targets, target)
+		}
+	}
+	return targets
+}
+
+// loadCollectionMigrationSource reads the songs to migrate and a default name.
+func loadCollectionMigrationSource(req collectionMigrationRequest) (string, string, string, []model.Song, error) {
+	switch req.Kind {
+	case "collection":
+		collection, err := loadCollection(strconv.FormatUint(uint64(req.CollectionID), 10))
+		if err != nil {
+			return "", "", "", nil, errors.New("歌单不存在")
+		}
+		songs, err := loadCollectionSongs(collection)
+		return collection.Name, collection.normalizedSource(), strconv.FormatUint(uint64(collection.ID), 10), songs, err
+	case collectionContentPlaylist, collectionContentAlbum:
+		source := strings.TrimSpace(req.Source)
+		externalID, link := strings.TrimSpace(req.ID), strings.TrimSpace(req.Link)
+		if source == "" || (externalID == "" && link == "") {
+			return "", "", "", nil, errors.New("缺少来源平台或歌单 ID / 链接")
+		}
+		ref := externalID
+		if ref == "" {
+			ref = link
+		}
+		songs, err := loadImportedCollectionSongs(&Collection{
+			Kind: collectionKindImported, ContentType: req.Kind, Source: source, ExternalID: externalID, Link: link,
+		})
+		name := fmt.Sprintf("%s 歌单 %s", core.GetSourceDescription(source), externalID)
+		if req.Kind == collectionContentAlbum {
+			name = fmt.Sprintf("%s 专辑 %s", core.GetSourceDescription(source), externalID)
+		}
+		return strings.TrimSpace(name), source, ref, songs, err
+	}
+	return "", "", "", nil, errors.New("不支持的迁移来源")
+}
+
+func startCollectionMigration(req collectionMigrationRequest) (*collectionMigrationJob, error) {
+	targets := collectionMigrationTargets(req.Targets)
+	if len(targets) == 0 {
+		return nil, errors.New("请选择至少一个可搜索的目标平台")
+	}
+	sourceName, source, ref, songs, err := loadCollectionMigrationSource(req)
+	if err != nil {
+		return nil, err
+	}
+	if len(songs) == 0 {
+		return nil, errors.New("来源里没有歌曲")
+	}
+	if len(songs) > collectionMigrationMaxTracks {
+		return nil, fmt.Errorf("一次最多迁移 %d 首", collectionMigrationMaxTracks)
+	}
+
+	threshold := req.Threshold
+	if threshold <= 0 || threshold > 1 {
+		threshold = collectionMigrationDefaultThreshold
+	}
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		name = sourceName
+	}
+	labels := make([]string, 0, len(targets))
+	for _, target := range targets {
+		labels = append(labels, core.GetSourceDescription(target))
+	}
+	name = fmt.Sprintf("%s → %s", name, strings.Join(labels, "/"))
+
+	job := &collectionMigrationJob{
+		backgroundJob: newBackgroundJob("running", len(songs)),
+		Name:          name,
+		Targets:       targets,
+		Threshold:     threshold,
+		Validate:      req.Validate == nil || *req.Validate,
+		Tracks:        make([]*collectionMigrationTrack, 0, len(songs)),
+		sourceKind:    req.Kind,
+		source:        source,
+		sourceRef:     ref,
+	}
+	for i, song := range songs {
+		job.Tracks = append(job.Tracks, &collectionMigrationTrack{
+			Index:      i,
+			Name:       strings.TrimSpace(song.Name),
+			Artist:     strings.TrimSpace(song.Artist),
+			Album:      strings.TrimSpace(song.Album),
+			Duration:   song.Duration,
+			Source:     song.Source,
+			SongID:     song.ID,
+			Status:     migrationStatusPending,
+			Selected:   -1,
+			Candidates: []collectionMigrationCandidate{},
+			song:       song,
+		})
+	}
+
+	if err := collectionMigrationJobs.install(job); err != nil {
+		return nil, err
+	}
+	go job.run()
+	return job, nil
+}
+
+func (job *collectionMigrationJob) snapshot() *collectionMigrationJob {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	out := &collectionMigrationJob{
+		backgroundJob: job.snapshotLocked(),
+		Name:          job.Name,
+		Targets:       job.Targets,
+		Threshold:     job.Threshold,
+		Validate:      job.Validate,
+		Matched:       job.Matched,
+		LowConfidence: job.LowConfidence,
+		NotFound:      job.NotFound,
+		MigrationID:   job.MigrationID,
+		CollectionID:  job.CollectionID,
+		Tracks:        make([]*collectionMigrationTrack, 0, len(job.Tracks)),
+	}
+	for _, track := range job.Tracks {
+		copied := *track
+		out.Tracks = append(out.Tracks, &copied)
+	}
+	return out
+}
+
+func (job *collectionMigrationJob) run() {
+	job.process(collectionMigrationWorkers, func(index int) func() {
+		job.mu.Lock()
+		track := *job.Tracks[index]
+		job.mu.Unlock()
+
+		matchCollectionMigrationTrack(&track, "", job.Targets, job.Threshold, job.Validate)
+		return func() {
+			job.Tracks[index] = &track
+			switch track.Status {
+			case migrationStatusMatched:
+				job.Matched++
+			case migrationStatusLowConfidence:
+				job.LowConfidence++
+			default:
+				job.NotFound++
+			}
+		}
+	})
+
+	var err error
+	if !job.isCancelled() {
+		var migration *CollectionMigration
+		if migration, err = job.save(); err == nil {
+			job.mu.Lock()
+			job.MigrationID, job.CollectionID = migration.ID, migration.CollectionID
+			job.mu.Unlock()
+		}
+	}
+	job.finish(err)
+}
+
+// matchCollectionMigrationTrack searches the targets for one track. A track
+// already on a target source is kept as it is.
+func matchCollectionMigrationTrack(track *collectionMigrationTrack, keyword string, targets []string, threshold float64, validate bool) {
+	track.Candidates = []collectionMigrationCandidate{}
+	track.Status, track.Score, track.Selected = migrationStatusNotFound, 0, -1
+	if keyword == "" && containsString(targets, track.Source) && track.SongID != "" {
+		song := track.song
+		song.ID, song.Source = track.SongID, track.Source
+		track.Candidates = append(track.Candidates, newCollectionMigrationCandidate(switchCandidate{song: song, score: 1}))
+		track.Status, track.Score, track.Selected = migrationStatusMatched, 1, 0
+		return
+	}
+	if track.Name == "" {
+		return
+	}
+	// 手动重搜时按关键词打分，也不再按时长过滤。
+	name, artist, duration := keyword, "", 0
+	if keyword == "" {
+		keyword = strings.TrimSpace(track.Name + " " + track.Artist)
+		name, artist, duration = track.Name, track.Artist, track.Duration
+	}
+
+	var mu sync.Mutex
+	var candidates []switchCandidate
+	var wg sync.WaitGroup
+	for _, target := range targets {
+		fn := switchSearchFuncProvider(target)
+		if fn == nil {
+			continue
+		}
+		wg.Add(1)
+		go func(target string, fn func(string) ([]model.Song, error)) {
+			defer wg.Done()
+			found := searchSwitchSourceCandidates(target, fn, keyword, name, artist, duration)
+			mu.Lock()
+			candidates = append(candidates, found...)
+			mu.Unlock()
+		}(target, fn)
+	}
+	wg.Wait()
+	if len(candidates) == 0 {
+		return
+	}
+	sortSwitchCandidates(candidates)
+
+	if validate {
+		song, _, ok := validateSwitchCandidates(candidates)
+		if !ok {
+			// 一个都播不了：候选留着给用户手动选。
+			track.Candidates = newCollectionMigrationCandidates(candidates)
+			return
+		}
+		// 把通过校验的那个排到最前。
+		for i := range candidates {
+			if candidates[i].song.ID == song.ID && candidates[i].song.Source == song.Source {
+				best := candidates[i]
+				copy(candidates[1:i+1], candidates[:i])
+				candidates[0] = best
+				break
+			}
+		}
+	}
+
+	track.Candidates = newCollectionMigrationCandidates(candidates)
+	best := candidates[0]
+	track.Score = best.score
+	if best.score >= threshold && best.durDiff <= 3 {
+		track.Status, track.Selected = migrationStatusMatched, 0
+	} else {
+		track.Status = migrationStatusLowConfidence
+	}
+}
+
+func newCollectionMigrationCandidates(candidates []switchCandidate) []collectionMigrationCandidate {
+	if len(candidates) > collectionMigrationMaxCandidates {
+		candidates = candidates[:collectionMigrationMaxCandidates]
+	}
+	out := make([]collectionMigrationCandidate, 0, len(candidates))
+	for _, candidate := range candidates {
+		out = append(out, newCollectionMigrationCandidate(candidate))
+	}
+	return out
+}
+
+func newCollectionMigrationCandidate(candidate switchCandidate) collectionMigrationCandidate {
+	song := candidate.song
+	return collectionMigrationCandidate{
+		ID:       song.ID,
+		Source:   song.Source,
+		Name:     strings.TrimSpace(song.Name),
+		Artist:   strings.TrimSpace(song.Artist),
+		Album:    strings.TrimSpace(song.Album),
+		Cover:    strings.TrimSpace(song.Cover),
+		Duration: song.Duration,
+		Score:    candidate.score,
+		DurDiff:  candidate.durDiff,
+		Extra:    savedSongExtra(song),
+	}
+}
+
+func (candidate collectionMigrationCandidate) savedSong(collectionID uint) SavedSong {
+	extra := ""
+	if len(candidate.Extra) > 0 {
+		if raw, err := json.Marshal(candidate.Extra); err == nil {
+			extra = string(raw)
+		}
+	}
+	return SavedSong{
+		CollectionID: collectionID,
+		SongID:       candidate.ID,
+		Source:       candidate.Source,
+		Extra:        extra,
+		Name:         candidate.Name,
+		Artist:       candidate.Artist,
+		Cover:        candidate.Cover,
+		Duration:     candidate.Duration,
+		AddedAt:      time.Now(),
+	}
+}
+
+// save creates the target collection with every matched track and stores the
+// report. Songs are inserted in reverse because collections list id DESC.
+func (job *collectionMigrationJob) save() (*CollectionMigration, error) {
+	job.mu.Lock()
+	tracks := make([]collectionMigrationTrack, 0, len(job.Tracks))
+	for _, track := range job.Tracks {
+		tracks = append(tracks, *track)
+	}
+	migration := &CollectionMigration{
+		Name:          job.Name,
+		SourceKind:    job.sourceKind,
+		Source:        job.source,
+		SourceRef:     job.sourceRef,
+		Targets:       strings.Join(job.Targets, ","),
+		Threshold:     job.Threshold,
+		Total:         job.Total,
+		Matched:       job.Matched,
+		LowConfidence: job.LowConfidence,
+		NotFound:      job.NotFound,
+	}
+	job.mu.Unlock()
+
+	report, err := json.Marshal(tracks)
+	if err != nil {
+		return nil, err
+	}
+	migration.Report = string(report)
+	err = db.Transaction(func(tx *gorm.DB) error {
+		collection := Collection{Name: migration.Name, Kind: collectionKindManual, ContentType: collectionContentPlaylist, Source: "local"}
+		if err := tx.Create(&collection).Error; err != nil {
+			return err
+		}
+		songs := make([]SavedSong, 0, len(tracks))
+		for i := len(tracks) - 1; i >= 0; i-- {
+			if track := tracks[i]; track.Selected >= 0 && track.Selected < len(track.Candidates) {
+				songs = append(songs, track.Candidates[track.Selected].savedSong(collection.ID))
+			}
+		}
+		if len(songs) > 0 {
+			if err := tx.Clauses(clause.OnConflict{DoNothing: true}).CreateInBatches(&songs, 200).Error; err != nil {
+				return err
+			}
+		}
+		migration.CollectionID = collection.ID
+		return tx.Create(migration).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return migration, nil
+}
+
+func loadCollectionMigration(id string) (*CollectionMigration, []collectionMigrationTrack, error) {
+	var migration CollectionMigration
+	if err := db.First(&migration, id).Error; err != nil {
+		return nil, nil, err
+	}
+	var tracks []collectionMigrationTrack
+	if err := json.Unmarshal([]byte(migration.Report), &tracks); err != nil {
+		return nil, nil, err
+	}
+	return &migration, tracks, nil
+}
+
+func saveCollectionMigrationReport(tx *gorm.DB, migration *CollectionMigration, tracks []collectionMigrationTrack) error {
+	report, err := json.Marshal(tracks)
+	if err != nil {
+		return err
+	}
+	return tx.Model(migration).Update("report", string(report)).Error
+}
+
+// selectCollectionMigrationCandidate swaps the song a report row put into
+// the collection; candidate -1 removes it.
+func selectCollectionMigrationCandidate(id string, index int, candidate int) (*collectionMigrationTrack, error) {
+	migration, tracks, err := loadCollectionMigration(id)
+	if err != nil {
+		return nil, errors.New("迁移报告不存在")
+	}
+	if index < 0 || index >= len(tracks) {
+		return nil, errors.New("报告里没有这一行")
+	}
+	track := &tracks[index]
+	if candidate < -1 || candidate >= len(track.Candidates) {
+		return nil, errors.New("候选不存在")
+	}
+	if candidate == track.Selected {
+		return track, nil
+	}
+	if _, err := loadCollection(strconv.FormatUint(uint64(migration.CollectionID), 10)); err != nil {
+		return nil, errors.New("迁移生成的歌单已被删除")
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if track.Selected >= 0 && track.Selected < len(track.Candidates) {
+			old := track.Candidates[track.Selected]
+			if err := tx.Where("collection_id = ? AND song_id = ? AND source = ?", migration.CollectionID, old.ID, old.Source).
+				Delete(&SavedSong{}).Error; err != nil {
+				return err
+			}
+		}
+		if candidate >= 0 {
+			song := track.Candidates[candidate].savedSong(migration.CollectionID)
+			if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&song).Error; err != nil {
+				return err
+			}
+		}
+		track.Selected = candidate
+		return saveCollectionMigrationReport(tx, migration, tracks)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return track, nil
+}
+
+// researchCollectionMigrationTrack searches again with a user supplied
+// keyword, replacing the row's candidates but not what is in the collection.
+func researchCollectionMigrationTrack(id string, index int, keyword string) (*collectionMigrationTrack, error) {
+	migration, tracks, err := loadCollectionMigration(id)
+	if err != nil {
+		return nil, errors.New("迁移报告不存在")
+	}
+	if index < 0 || index >= len(tracks) {
+		return nil, errors.New("报告里没有这一行")
+	}
+	keyword = strings.TrimSpace(keyword)
+	if keyword == "" {
+		return nil, errors.New("请输入搜索关键词")
+	}
+	targets := collectionMigrationTargets(strings.Split(migration.Targets, ","))
+	if len(targets) == 0 {
+		return nil, errors.New("目标平台当前不可用")
+	}
+
+	track := &tracks[index]
+	var selected *collectionMigrationCandidate
+	if track.Selected >= 0 && track.Selected < len(track.Candidates) {
+		current := track.Candidates[track.Selected]
+		selected = &current
+	}
+	status, score := track.Status, track.Score
+	matchCollectionMigrationTrack(track, keyword, targets, migration.Threshold, false)
+	track.Status, track.Score = status, score
+	track.Selected = -1
+	if selected != nil {
+		// 歌单里的那首保持不变，放回候选首位。
+		track.Candidates = append([]collectionMigrationCandidate{*selected}, track.Candidates...)
+		if len(track.Candidates) > collectionMigrationMaxCandidates {
+			track.Candidates = track.Candidates[:collectionMigrationMaxCandidates]
+		}
+		track.Selected = 0
+	}
+	if err := saveCollectionMigrationReport(db, migration, tracks); err != nil {
+		return nil, err
+	}
+	return track, nil
+}
+
+func collectionMigrationReportCSV(tracks []collectionMigrationTrack) ([]byte, error) {
+	var buf bytes.Buffer
+	writer := csv.NewWriter(&buf)
+	writer.Write([]string{"index", "status", "score", "name", "artist", "album", "source", "song_id", "target_source", "target_id", "target_name", "target_artist", "target_album"})
+	for _, track := range tracks {
+		row := []string{
+			strconv.Itoa(track.Index + 1), track.Status, strconv.FormatFloat(track.Score, 'f', 2, 64),
+			track.Name, track.Artist, track.Album, track.Source, track.SongID,
+		}
+		if track.Selected >= 0 && track.Selected < len(track.Candidates) {
+			picked := track.Candidates[track.Selected]
+			row = append(row, picked.Source, picked.ID, picked.Name, picked.Artist, picked.Album)
+		} else {
+			row = append(row, "", "", "", "", "")
+		}
+		writer.Write(row)
+	}
+	writer.Flush()
+	return buf.Bytes(), writer.Error()
+}
@@ -0,0 +1,168 @@
+package web
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/guohuiyuan/music-lib/model"
+)
+
+func withCollectionMigrationTestSources(t *testing.T, results map[string]map[string][]model.Song) {
+	t.Helper()
+	withSwitchSourceTestHooks(t)
+	switchDefaultSourceNames = func() []string { return nil }
+	switchAllSourceNames = func() []string { return []string{"netease", "qq", "kugou"} }
+	switchSearchFuncProvider = func(source string) func(string) ([]model.Song, error) {
+		return func(keyword string) ([]model.Song, error) {
+			return results[source][keyword], nil
+		}
+	}
+	switchValidatePlayable = func(song *model.Song) bool { return song.ID != "dead" }
+	t.Cleanup(func() {
+		collectionMigrationJobs.reset()
+	})
+}
+
+func waitCollectionMigrationJobForTest(t *testing.T) *collectionMigrationJob {
+	t.Helper()
+	job := collectionMigrationJobs.get()
+	if job == nil {
+		t.Fatal("no migration job")
+	}
+	waitJobDoneForTest(t, "migration", job.done)
+	return job.snapshot()
+}
+
+func TestCollectionMigrationBuildsCollectionAndEditableReport(t *testing.T) {
+	initCollectionDBForTest(t)
+	withCollectionMigrationTestSources(t, map[string]map[string][]model.Song{
+		"qq": {
+			"晴天 周杰伦": {
+				{ID: "dead", Name: "晴天", Artist: "周杰伦", Duration: 269},
+				{ID: "q1", Name: "晴天", Artist: "周杰伦", Duration: 270, Album: "叶惠美"},
+			},
+			"夜曲 周杰伦": {{ID: "q2", Name: "夜曲", Artist: "周杰伦", Duration: 226}},
+		},
+		"kugou": {
+			"晴天 周杰伦": {{ID: "k1", Name: "晴天", Artist: "周杰伦", Duration: 290}},
+			"夜曲 周杰伦": {{ID: "k2", Name: "夜曲 (Live)", Artist: "周杰伦 / 某乐队", Duration: 240}},
+			"七里香 周杰伦": {
+				{ID: "k3", Name: "七里香 (伴奏)", Artist: "群星", Duration: 299},
+				{ID: "k4", Name: "七里香", Artist: "周杰伦", Duration: 299},
+			},
+		},
+	})
+
+	source := Collection{Name: "周杰伦精选", Kind: collectionKindManual, ContentType: collectionContentPlaylist, Source: "local"}
+	if err := db.Create(&source).Error; err != nil {
+		t.Fatal(err)
+	}
+	// loadSavedSongs 按 id 倒序，倒着插保证来源顺序是 晴天、七里香、夜曲、Unknown。
+	for _, song := range []SavedSong{
+		{SongID: "n4", Source: "netease", Name: "Unknown Song", Artist: "Nobody"},
+		{SongID: "q2", Source: "qq", Name: "夜曲", Artist: "周杰伦", Duration: 226},
+		{SongID: "n2", Source: "netease", Name: "七里香", Artist: "周杰伦", Duration: 300},
+		{SongID: "n1", Source: "netease", Name: "晴天", Artist: "周杰伦", Duration: 269},
+	} {
+		song.CollectionID = source.ID
+		if err := db.Create(&song).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var started struct {
+		Status string `json:"status"`
+	}
+	req := gin.H{"kind": "collection", "collection_id": source.ID, "targets": []string{"qq", "kugou", "local", "missing"}}
+	if code := postLocalMusicTagsJSON(t, "/collections/migrate", req, &started); code != http.StatusOK || started.Status != "started" {
+		t.Fatalf("start migration = %d %+v", code, started)
+	}
+	job := waitCollectionMigrationJobForTest(t)
+	if job.Status != "done" || strings.Join(job.Targets, ",") != "qq,kugou" || job.Total != 4 || job.Matched != 3 || job.LowConfidence != 0 || job.NotFound != 1 {
+		t.Fatalf("job = %+v", job)
+	}
+	if tr := job.Tracks[0]; tr.Status != migrationStatusMatched || tr.Candidates[0].ID != "q1" || tr.Candidates[0].Extra["album"] != "叶惠美" {
+		t.Fatalf("validated track = %+v", tr)
+	}
+	if tr := job.Tracks[1]; tr.Status != migrationStatusMatched || tr.Candidates[0].ID != "k4" {
+		t.Fatalf("best scored track = %+v", tr)
+	}
+	if tr := job.Tracks[2]; tr.Status != migrationStatusMatched || tr.Score != 1 || len(tr.Candidates) != 1 || tr.Candidates[0].Source != "qq" {
+		t.Fatalf("already on target = %+v", tr)
+	}
+	if tr := job.Tracks[3]; tr.Status != migrationStatusNotFound || tr.Selected != -1 {
+		t.Fatalf("not found = %+v", tr)
+	}
+
+	songs, err := loadSavedSongs(job.CollectionID)
+	if err != nil || len(songs) != 3 || songs[0].ID != "q1" || songs[1].ID != "k4" || songs[2].ID != "q2" || songs[0].Album != "叶惠美" {
+		t.Fatalf("migrated songs = %+v %v", songs, err)
+	}
+
+	// 报告里换一个候选，歌单跟着换。
+	migrationPath := "/collections/migrations/" + jsonNumberForTest(job.MigrationID)
+	body, _ := json.Marshal(gin.H{"candidate": 1})
+	rec := httptest.NewRecorder()
+	selectReq := httptest.NewRequest(http.MethodPut, RoutePrefix+migrationPath+"/tracks/1", bytes.NewReader(body))
+	selectReq.Header.Set("X-Requested-With", "XMLHttpRequest")
+	newLocalMusicTestRouter().ServeHTTP(rec, selectReq)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("select candidate = %d %s", rec.Code, rec.Body.String())
+	}
+	songs, _ = loadSavedSongs(job.CollectionID)
+	if len(songs) != 3 || songs[0].ID != "k3" {
+		t.Fatalf("songs after select = %+v", songs)
+	}
+
+	var researched struct {
+		Track collectionMigrationTrack `json:"track"`
+	}
+	if code := postLocalMusicTagsJSON(t, migrationPath+"/tracks/3/search", gin.H{"keyword": "夜曲 周杰伦"}, &researched); code != http.StatusOK {
+		t.Fatalf("research = %d", code)
+	}
+	if tr := researched.Track; tr.Selected != -1 || len(tr.Candidates) != 2 || tr.Candidates[0].ID != "q2" || tr.Status != migrationStatusNotFound {
+		t.Fatalf("researched track = %+v", tr)
+	}
+
+	rec = httptest.NewRecorder()
+	newLocalMusicTestRouter().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, RoutePrefix+migrationPath+"/report?format=csv", nil))
+	csv := rec.Body.String()
+	if rec.Code != http.StatusOK || !strings.Contains(csv, "index,status,score") || !strings.Contains(csv, "2,matched,") || !strings.Contains(csv, ",kugou,k3,") || !strings.Contains(csv, "4,not_found,0.00,Unknown Song") {
+		t.Fatalf("csv report = %d %s", rec.Code, csv)
+	}
+
+	// 删掉生成的歌单，报告一起删掉。
+	if err := db.Delete(&Collection{}, job.CollectionID).Error; err != nil {
+		t.Fatal(err)
+	}
+	var count int64
+	db.Model(&CollectionMigration{}).Count(&count)
+	if count != 0 {
+		t.Fatalf("migration reports after delete = %d", count)
+	}
+}
+
+func TestCollectionMigrationRejectsUnknownTargetsAndLowConfidence(t *testing.T) {
+	initCollectionDBForTest(t)
+	withCollectionMigrationTestSources(t, map[string]map[string][]model.Song{
+		"qq": {"夜曲 周杰伦": {{ID: "q9", Name: "夜曲 (Live)", Artist: "周杰伦 / 某乐队", Duration: 240}}},
+	})
+
+	var failed struct {
+		Error string `json:"error"`
+	}
+	if code := postLocalMusicTagsJSON(t, "/collections/migrate", gin.H{"kind": "collection", "targets": []string{"local"}}, &failed); code != http.StatusBadRequest {
+		t.Fatalf("local target = %d %+v", code, failed)
+	}
+
+	track := collectionMigrationTrack{Name: "夜曲", Artist: "周杰伦", Duration: 226, Source: "netease", SongID: "n1"}
+	matchCollectionMigrationTrack(&track, "", []string{"qq"}, collectionMigrationDefaultThreshold, true)
+	if track.Status != migrationStatusLowConfidence || track.Selected != -1 || len(track.Candidates) != 1 {
+		t.Fatalf("low confidence track = %+v", track)
+	}
+}
@@ -0,0 +1,380 @@
+package web
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// 歌单整理：自建歌单内的手动排序、歌曲在歌单之间移动 / 复制、每首歌的备注和
+// 评分，以及给歌单分文件夹、打标签，在“我的本地歌单”里分组显示。
+
+const (
+	// savedSongOrder 是歌单内的显示顺序；position 相同（旧数据都是 0）时新加入的在前。
+	savedSongOrder       = "position DESC, id DESC"
+	savedSongExportOrder = "position ASC, id ASC"
+
+	collectionSongNoteMaxLen = 500
+	collectionSongMaxRating  = 5
+)
+
+var errCollectionTransferTarget = errors.New("目标歌单需为其他的自建歌单")
+
+type savedSongKey struct {
+	SongID string `json:"id"`
+	Source string `json:"source"`
+}
+
+// BeforeCreate puts a newly added song on top of its collection. Rows that
+// already carry a position (archive imports) keep it.
+func (s *SavedSong) BeforeCreate(tx *gorm.DB) error {
+	if s.Position != 0 || s.CollectionID == 0 {
+		return nil
+	}
+	var top int
+	if err := tx.Session(&gorm.Session{NewDB: true}).Model(&SavedSong{}).
+		Where("collection_id = ?", s.CollectionID).
+		Select("COALESCE(MAX(position), 0)").Scan(&top).Error; err != nil {
+		return err
+	}
+	s.Position = top + 1
+	return nil
+}
+
+func normalizeCollectionFolder(raw string) string {
+	parts := strings.Split(strings.ReplaceAll(raw, "\\", "/"), "/")
+	cleaned := make([]string, 0, len(parts))
+	for _, part := range parts {
+		if part = strings.TrimSpace(part); part != "" {
+			cleaned = append(cleaned, part)
+		}
+	}
+	return strings.Join(cleaned, "/")
+}
+
+func normalizeCollectionTags(tags []string) string {
+	seen := make(map[string]bool, len(tags))
+	cleaned := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		for _, part := range strings.FieldsFunc(tag, func(r rune) bool { return r == ',' || r == '，' }) {
+			part = strings.TrimSpace(part)
+			key := strings.ToLower(part)
+			if part == "" || seen[key] {
+				continue
+			}
+			seen[key] = true
+			cleaned = append(cleaned, part)
+		}
+	}
+	return strings.Join(cleaned, ",")
+}
+
+func (c Collection) tagList() []string {
+	return splitCollectionTags(c.Tags)
+}
+
+func splitCollectionTags(raw string) []string {
+	if strings.TrimSpace(raw) == "" {
+		return nil
+	}
+	return strings.Split(raw, ",")
+}
+
+// inFolder reports whether the collection sits in folder or one of its
+// subfolders.
+func (c Collection) inFolder(folder string) bool {
+	return c.Folder == folder || strings.HasPrefix(c.Folder, folder+"/")
+}
+
+// organizeCollectionsForGrid filters collections by folder / tag and orders
+// them folder by folder, collections without a folder last.
+func organizeCollectionsForGrid(collections []Collection, folder string, tag string) []Collection {
+	folder = normalizeCollectionFolder(folder)
+	tag = strings.TrimSpace(tag)
+	out := make([]Collection, 0, len(collections))
+	for _, collection := range collections {
+		if folder != "" && !collection.inFolder(folder) {
+			continue
+		}
+		if tag != "" && !containsFoldString(collection.tagList(), tag) {
+			continue
+		}
+		out = append(out, collection)
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		a, b := out[i].Folder, out[j].Folder
+		if (a == "") != (b == "") {
+			return a != ""
+		}
+		return strings.ToLower(a) < strings.ToLower(b)
+	})
+	return out
+}
+
+func containsFoldString(values []string, target string) bool {
+	for _, value := range values {
+		if strings.EqualFold(value, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// collectionFoldersAndTags lists every folder (parents included) and tag in
+// use, for the filter bar on the collections page.
+func collectionFoldersAndTags(collections []Collection) ([]string, []string) {
+	folderSet := map[string]bool{}
+	tagSet := map[string]string{}
+	for _, collection := range collections {
+		if collection.Folder != "" {
+			parts := strings.Split(collection.Folder, "/")
+			for i := range parts {
+				folderSet[strings.Join(parts[:i+1], "/")] = true
+			}
+		}
+		for _, tag := range collection.tagList() {
+			if _, ok := tagSet[strings.ToLower(tag)]; !ok {
+				tagSet[strings.ToLower(tag)] = tag
+			}
+		}
+	}
+	folders := make([]string, 0, len(folderSet))
+	for folder := range folderSet {
+		folders = append(folders, folder)
+	}
+	tags := make([]string, 0, len(tagSet))
+	for _, tag := range tagSet {
+		tags = append(tags, tag)
+	}
+	sort.Strings(folders)
+	sort.Strings(tags)
+	return folders, tags
+}
+
+// reorderSavedSongs rearranges the listed songs of a manual collection among
+// the slots they already occupy, so a single page of a long collection can
+// be reordered on its own. Ties (older rows all sit at position 0) are
+// numbered out first.
+func reorderSavedSongs(collectionID uint, keys []savedSongKey) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		var rows []SavedSong
+		if err := tx.Where("collection_id = ?", collectionID).Order(savedSongOrder).Find(&rows).Error; err != nil {
+			return err
+		}
+		positions := make(map[uint]int, len(rows))
+		byKey := make(map[savedSongKey]uint, len(rows))
+		for i, row := range rows {
+			positions[row.ID] = len(rows) - i
+			byKey[savedSongKey{SongID: row.SongID, Source: row.Source}] = row.ID
+		}
+
+		listed := make([]uint, 0, len(keys))
+		seen := make(map[uint]bool, len(keys))
+		for _, key := range keys {
+			if id, ok := byKey[key]; ok && !seen[id] {
+				seen[id] = true
+				listed = append(listed, id)
+			}
+		}
+		slots := make([]int, 0, len(listed))
+		for _, id := range listed {
+			slots = append(slots, positions[id])
+		}
+		sort.Sort(sort.Reverse(sort.IntSlice(slots)))
+		for i, id := range listed {
+			positions[id] = slots[i]
+		}
+
+		for _, row := range rows {
+			if row.Position == positions[row.ID] {
+				continue
+			}
+			if err := tx.Model(&SavedSong{}).Where("id = ?", row.ID).Update("position", positions[row.ID]).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// transferSavedSongs copies (or moves) songs into another manual collection,
+// keeping their notes and ratings. Songs the target already has count as
+// duplicates; a move still takes them out of the source.
+func transferSavedSongs(source *Collection, target *Collection, keys []savedSongKey, move bool) (int, int, error) {
+	if target == nil || !target.isManual() || target.ID == source.ID {
+		return 0, 0, errCollectionTransferTarget
+	}
+	added, duplicate := 0, 0
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var rows []SavedSong
+		for _, key := range keys {
+			var row SavedSong
+			err := tx.Where("collection_id = ? AND song_id = ? AND source = ?", source.ID, key.SongID, key.Source).First(&row).Error
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			rows = append(rows, row)
+		}
+		// 倒着插入，让选中的第一首在目标歌单里也排在最前。
+		for i := len(rows) - 1; i >= 0; i-- {
+			copied := rows[i]
+			copied.ID = 0
+			copied.CollectionID = target.ID
+			copied.Position = 0
+			copied.AddedAt = time.Now()
+			result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&copied)
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected == 0 {
+				duplicate++
+			} else {
+				added++
+			}
+		}
+		if !move {
+			return nil
+		}
+		for _, row := range rows {
+			if err := tx.Delete(&SavedSong{}, row.ID).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return added, duplicate, err
+}
+
+func registerCollectionOrganizeRoutes(colAPI *gin.RouterGroup) {
+	colAPI.PUT("/:id/songs/order", requireSameOriginWrite, func(c *gin.Context) {
+		collection, err := loadCollection(c.Param("id"))
+		if err != nil {
+			c.JSON(404, gin.H{"error": "歌单不存在"})
+			return
+		}
+		if !collection.isManual() {
+			c.JSON(400, gin.H{"error": "只有自建歌单可以调整顺序"})
+			return
+		}
+		var req struct {
+			Songs []savedSongKey `json:"songs"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil || len(req.Songs) == 0 {
+			c.JSON(400, gin.H{"error": "缺少歌曲顺序"})
+			return
+		}
+		if err := reorderSavedSongs(collection.ID, req.Songs); err != nil {
+			c.JSON(500, gin.H{"error": "保存顺序失败: " + err.Error()})
+			return
+		}
+		c.JSON(200, gin.H{"status": "ok"})
+	})
+
+	colAPI.POST("/:id/songs/transfer", requireSameOriginWrite, func(c *gin.Context) {
+		collection, err := loadCollection(c.Param("id"))
+		if err != nil {
+			c.JSON(404, gin.H{"error": "歌单不存在"})
+			return
+		}
+		if !collection.isManual() {
+			c.JSON(400, gin.H{"error": "只能从自建歌单移动或复制歌曲"})
+			return
+		}
+		var req struct {
+			TargetID uint           `json:"target_id"`
+			Mode     string         `json:"mode"`
+			Songs    []savedSongKey `json:"songs"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil || len(req.Songs) == 0 || (req.Mode != "move" && req.Mode != "copy") {
+			c.JSON(400, gin.H{"error": "参数错误，需要 target_id、mode（move/copy）和歌曲列表"})
+			return
+		}
+		var target Collection
+		if err := db.First(&target, req.TargetID).Error; err != nil {
+			c.JSON(404, gin.H{"error": "目标歌单不存在"})
+			return
+		}
+		added, duplicate, err := transferSavedSongs(collection, &target, req.Songs, req.Mode == "move")
+		if errors.Is(err, errCollectionTransferTarget) {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(500, gin.H{"error": "操作失败: " + err.Error()})
+			return
+		}
+		c.JSON(200, gin.H{"status": "ok", "added": added, "duplicate": duplicate})
+	})
+
+	colAPI.PUT("/:id/songs/note", requireSameOriginWrite, func(c *gin.Context) {
+		collection, err := loadCollection(c.Param("id"))
+		if err != nil {
+			c.JSON(404, gin.H{"error": "歌单不存在"})
+			return
+		}
+		if !collection.isManual() {
+			c.JSON(400, gin.H{"error": "只有自建歌单可以写备注"})
+			return
+		}
+		var req struct {
+			savedSongKey
+			Note   string `json:"note"`
+			Rating int    `json:"rating"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil || req.SongID == "" || req.Source == "" {
+			c.JSON(400, gin.H{"error": "参数错误，缺少 id 或 source"})
+			return
+		}
+		req.Note = strings.TrimSpace(req.Note)
+		if utf8.RuneCountInString(req.Note) > collectionSongNoteMaxLen || req.Rating < 0 || req.Rating > collectionSongMaxRating {
+			c.JSON(400, gin.H{"error": "备注最多 500 字，评分为 0-5"})
+			return
+		}
+		result := db.Model(&SavedSong{}).
+			Where("collection_id = ? AND song_id = ? AND source = ?", collection.ID, req.SongID, req.Source).
+			Updates(map[string]interface{}{"note": req.Note, "rating": req.Rating})
+		if result.Error != nil {
+			c.JSON(500, gin.H{"error": "保存失败"})
+			return
+		}
+		if result.RowsAffected == 0 {
+			c.JSON(404, gin.H{"error": "歌曲不在歌单中"})
+			return
+		}
+		c.JSON(200, gin.H{"status": "ok"})
+	})
+
+	colAPI.PUT("/:id/group", requireSameOriginWrite, func(c *gin.Context) {
+		collection, err := loadCollection(c.Param("id"))
+		if err != nil {
+			c.JSON(404, gin.H{"error": "歌单不存在"})
+			return
+		}
+		var req struct {
+			Folder string   `json:"folder"`
+			Tags   []string `json:"tags"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": "参数错误"})
+			return
+		}
+		folder, tags := normalizeCollectionFolder(req.Folder), normalizeCollectionTags(req.Tags)
+		if err := db.Model(&Collection{}).Where("id = ?", collection.ID).Updates(map[string]interface{}{
+			"folder": folder,
+			"tags":   tags,
+		}).Error; err != nil {
+			c.JSON(500, gin.H{"error": "更新失败"})
+			return
+		}
+		c.JSON(200, gin.H{"status": "ok", "folder": folder, "tags": tags})
+	})
+}
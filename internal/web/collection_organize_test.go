@@ -0,0 +1,190 @@
+package web
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/guohuiyuan/music-lib/model"
+)
+
+func putCollectionJSONForTest(t *testing.T, target string, payload interface{}) int {
+	t.Helper()
+	body, _ := json.Marshal(payload)
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPut, RoutePrefix+target, bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Requested-With", "XMLHttpRequest")
+	newLocalMusicTestRouter().ServeHTTP(rec, req)
+	return rec.Code
+}
+
+func collectionSongIDsForTest(t *testing.T, collectionID uint) string {
+	t.Helper()
+	songs, err := loadSavedSongs(collectionID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := make([]string, 0, len(songs))
+	for _, song := range songs {
+		ids = append(ids, song.ID)
+	}
+	return strings.Join(ids, ",")
+}
+
+func TestCollectionSongOrderNotesAndTransfer(t *testing.T) {
+	initCollectionDBForTest(t)
+	source := Collection{Name: "Source", Kind: collectionKindManual, Source: "local"}
+	target := Collection{Name: "Target", Kind: collectionKindManual, Source: "local"}
+	smart := Collection{Name: "Smart", Kind: collectionKindSmart, Source: "local"}
+	for _, collection := range []*Collection{&source, &target, &smart} {
+		if err := db.Create(collection).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	sourcePath := "/collections/" + jsonNumberForTest(source.ID)
+
+	songs := []gin.H{{"id": "a", "source": "qq"}, {"id": "b", "source": "qq"}, {"id": "c", "source": "qq"}}
+	if code := postLocalMusicTagsJSON(t, sourcePath+"/songs/batch", gin.H{"songs": songs}, nil); code != http.StatusOK {
+		t.Fatalf("batch add = %d", code)
+	}
+	if got := collectionSongIDsForTest(t, source.ID); got != "c,b,a" {
+		t.Fatalf("new songs order = %s, want newest first", got)
+	}
+
+	// 只调换 a 和 c：两首在它们原来占的位置里互换，b 不动。
+	if code := putCollectionJSONForTest(t, sourcePath+"/songs/order", gin.H{"songs": []gin.H{{"id": "a", "source": "qq"}, {"id": "c", "source": "qq"}}}); code != http.StatusOK {
+		t.Fatalf("reorder = %d", code)
+	}
+	if got := collectionSongIDsForTest(t, source.ID); got != "a,b,c" {
+		t.Fatalf("order after reorder = %s", got)
+	}
+	// 旧数据 position 都是 0 时按加入顺序先编号再调整。
+	db.Model(&SavedSong{}).Where("collection_id = ?", source.ID).Update("position", 0)
+	if code := putCollectionJSONForTest(t, sourcePath+"/songs/order", gin.H{"songs": []gin.H{{"id": "a", "source": "qq"}, {"id": "b", "source": "qq"}, {"id": "c", "source": "qq"}}}); code != http.StatusOK {
+		t.Fatalf("reorder legacy = %d", code)
+	}
+	if got := collectionSongIDsForTest(t, source.ID); got != "a,b,c" {
+		t.Fatalf("order after legacy reorder = %s", got)
+	}
+
+	if code := putCollectionJSONForTest(t, sourcePath+"/songs/note", gin.H{"id": "b", "source": "qq", "note": " 跑步听 ", "rating": 4}); code != http.StatusOK {
+		t.Fatalf("note = %d", code)
+	}
+	if code := putCollectionJSONForTest(t, sourcePath+"/songs/note", gin.H{"id": "b", "source": "qq", "rating": 9}); code != http.StatusBadRequest {
+		t.Fatalf("bad rating = %d", code)
+	}
+	resp, err := collectionSongsJSON(&source)
+	if err != nil || len(resp) != 3 || resp[1]["note"] != "跑步听" || resp[1]["rating"] != 4 || resp[0]["position"] != 3 {
+		t.Fatalf("songs json = %+v %v", resp, err)
+	}
+
+	var result struct {
+		Added     int `json:"added"`
+		Duplicate int `json:"duplicate"`
+	}
+	transfer := func(mode string, ids ...string) int {
+		keys := make([]gin.H, 0, len(ids))
+		for _, id := range ids {
+			keys = append(keys, gin.H{"id": id, "source": "qq"})
+		}
+		result.Added, result.Duplicate = 0, 0
+		return postLocalMusicTagsJSON(t, sourcePath+"/songs/transfer", gin.H{"target_id": target.ID, "mode": mode, "songs": keys}, &result)
+	}
+	if code := transfer("copy", "a", "b"); code != http.StatusOK || result.Added != 2 {
+		t.Fatalf("copy = %d %+v", code, result)
+	}
+	if got := collectionSongIDsForTest(t, target.ID); got != "a,b" {
+		t.Fatalf("target after copy = %s", got)
+	}
+	if code := transfer("move", "b", "c"); code != http.StatusOK || result.Added != 1 || result.Duplicate != 1 {
+		t.Fatalf("move = %d %+v", code, result)
+	}
+	if got := collectionSongIDsForTest(t, source.ID); got != "a" {
+		t.Fatalf("source after move = %s", got)
+	}
+	var moved SavedSong
+	if err := db.Where("collection_id = ? AND song_id = ?", target.ID, "b").First(&moved).Error; err != nil || moved.Note != "跑步听" || moved.Rating != 4 {
+		t.Fatalf("moved song = %+v %v", moved, err)
+	}
+	if code := postLocalMusicTagsJSON(t, sourcePath+"/songs/transfer", gin.H{"target_id": smart.ID, "mode": "copy", "songs": []gin.H{{"id": "a", "source": "qq"}}}, nil); code != http.StatusBadRequest {
+		t.Fatalf("transfer into smart collection = %d", code)
+	}
+}
+
+func TestCollectionFoldersAndTags(t *testing.T) {
+	initCollectionDBForTest(t)
+	collections := []Collection{
+		{Name: "Loose", Kind: collectionKindManual, Source: "local"},
+		{Name: "Run", Kind: collectionKindManual, Source: "local"},
+		{Name: "Work", Kind: collectionKindImported, Source: "qq", ExternalID: "1"},
+	}
+	for i := range collections {
+		if err := db.Create(&collections[i]).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if code := putCollectionJSONForTest(t, "/collections/"+jsonNumberForTest(collections[1].ID)+"/group", gin.H{"folder": " 运动 / 跑步 ", "tags": []string{"睡前，工作", "睡前"}}); code != http.StatusOK {
+		t.Fatalf("group = %d", code)
+	}
+	if code := putCollectionJSONForTest(t, "/collections/"+jsonNumberForTest(collections[2].ID)+"/group", gin.H{"folder": "工作", "tags": []string{"工作"}}); code != http.StatusOK {
+		t.Fatalf("group imported = %d", code)
+	}
+
+	var all []Collection
+	db.Order("id DESC").Find(&all)
+	folders, tags := collectionFoldersAndTags(all)
+	if strings.Join(folders, ",") != "工作,运动,运动/跑步" || len(tags) != 2 {
+		t.Fatalf("folders = %v tags = %v", folders, tags)
+	}
+	names := func(list []Collection) string {
+		out := make([]string, 0, len(list))
+		for _, collection := range list {
+			out = append(out, collection.Name+":"+collection.Folder)
+		}
+		return strings.Join(out, ",")
+	}
+	if got := names(organizeCollectionsForGrid(all, "", "")); got != "Work:工作,Run:运动/跑步,Loose:" {
+		t.Fatalf("grid order = %s", got)
+	}
+	if got := names(organizeCollectionsForGrid(all, "运动", "")); got != "Run:运动/跑步" {
+		t.Fatalf("folder filter = %s", got)
+	}
+	if got := names(organizeCollectionsForGrid(all, "", "工作")); got != "Work:工作,Run:运动/跑步" {
+		t.Fatalf("tag filter = %s", got)
+	}
+}
+
+func TestMyCollectionsGroupsCardsByFolder(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	router := gin.New()
+	router.SetHTMLTemplate(newTestTemplate(t))
+	router.GET(RoutePrefix+"/my_collections", func(c *gin.Context) {
+		c.Set("CollectionFolders", []string{"运动"})
+		c.Set("CollectionTags", []string{"睡前"})
+		c.Set("CollectionFilter", gin.H{"Folder": "", "Tag": ""})
+		renderIndex(c, nil, []model.Playlist{
+			{ID: "1", Name: "Run", Source: "local", Extra: map[string]string{"collection_kind": "manual", "folder": "运动", "tags": "睡前,工作"}},
+			{ID: "2", Name: "Walk", Source: "local", Extra: map[string]string{"collection_kind": "manual", "folder": "运动"}},
+			{ID: "3", Name: "Loose", Source: "local", Extra: map[string]string{"collection_kind": "manual"}},
+		}, "我的本地歌单", nil, "", "playlist", "", "", "", true, "", nil)
+	})
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, RoutePrefix+"/my_collections", nil))
+	body := rec.Body.String()
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d", rec.Code)
+	}
+	if strings.Count(body, `class="collection-folder-heading"`) != 2 || !strings.Contains(body, "未分组") {
+		t.Fatalf("folder headings missing: %s", body)
+	}
+	if !strings.Contains(body, `my_collections?tag=%E5%B7%A5%E4%BD%9C`) {
+		t.Fatalf("card tag chip missing: %s", body)
+	}
+}
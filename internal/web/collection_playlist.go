@@ -0,0 +1,1107 @@
+package web
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/guohuiyuan/music-lib/model"
+	"gorm.io/gorm/clause"
+)
+
+// 歌单文件导入导出：本地歌单可导出为 M3U8（能在曲库找到的歌指向本地文件，
+// 否则指向 /download 流地址）、XSPF 和无损 JSON；反过来可从 M3U/M3U8/XSPF/
+// JSON/CSV 导入。文件里不认识的条目先在本地曲库找，再去在线源搜索，按
+// 相似度打分，低于阈值的留给用户在审核列表里确认。
+
+const (
+	collectionPlaylistJSONFormat  = "go-music-dl-collection"
+	collectionPlaylistJSONVersion = 1
+
+	collectionImportMaxUploadBytes   = 8 << 20
+	collectionImportMaxEntries       = 2000
+	collectionImportDefaultThreshold = 0.85
+	collectionImportMaxCandidates    = 5
+	collectionImportWorkers          = 3
+)
+
+var (
+	errCollectionImportNoJob = errors.New("没有歌单文件导入任务")
+
+	collectionImportJobs = newBackgroundJobSlot[*collectionImportJob]("已有歌单文件导入任务在进行中")
+)
+
+// collectionPlaylistDocument 是无损 JSON 导出格式，与配置归档里的歌单段一致。
+type collectionPlaylistDocument struct {
+	Format      string                   `json:"format"`
+	Version     int                      `json:"version"`
+	Collections []collectionArchiveEntry `json:"collections"`
+}
+
+type xspfPlaylist struct {
+	XMLName xml.Name    `xml:"playlist"`
+	Version string      `xml:"version,attr"`
+	Xmlns   string      `xml:"xmlns,attr"`
+	Title   string      `xml:"title,omitempty"`
+	Tracks  []xspfTrack `xml:"trackList>track"`
+}
+
+type xspfTrack struct {
+	Location []string `xml:"location"`
+	Title    string   `xml:"title,omitempty"`
+	Creator  string   `xml:"creator,omitempty"`
+	Album    string   `xml:"album,omitempty"`
+	Duration int      `xml:"duration,omitempty"`
+	Image    string   `xml:"image,omitempty"`
+}
+
+// playlistFileEntry 是从歌单文件里读出的一条。Source/SongID 来自本程序
+// 导出的 /download 地址或 JSON，能直接还原，不需要搜索。
+type playlistFileEntry struct {
+	Title    string
+	Artist   string
+	Album    string
+	Duration int
+	Location string
+	Source   string
+	SongID   string
+	Extra    map[string]string
+}
+
+type collectionImportCandidate struct {
+	ID         string            `json:"id"`
+	Source     string            `json:"source"`
+	Name       string            `json:"name"`
+	Artist     string            `json:"artist"`
+	Album      string            `json:"album"`
+	Cover      string            `json:"cover"`
+	Duration   int               `json:"duration"`
+	Confidence float64           `json:"confidence"`
+	Local      bool              `json:"local"`
+	Extra      map[string]string `json:"extra,omitempty"`
+}
+
+// collectionImportEntry 的 Status：matched 达到阈值，review 有候选但需要
+// 确认，unmatched 没有候选。Selected 是默认选中的候选，-1 表示没有。
+type collectionImportEntry struct {
+	Index      int                         `json:"index"`
+	Title      string                      `json:"title"`
+	Artist     string                      `json:"artist"`
+	Album      string                      `json:"album"`
+	Duration   int                         `json:"duration"`
+	Location   string                      `json:"location,omitempty"`
+	Status     string                      `json:"status"`
+	Confidence float64                     `json:"confidence"`
+	Selected   int                         `json:"selected"`
+	Candidates []collectionImportCandidate `json:"candidates"`
+
+	file playlistFileEntry
+}
+
+type collectionImportJob struct {
+	backgroundJob
+	Name         string                   `json:"name"`
+	Format       string                   `json:"format"`
+	Matched      int                      `json:"matched"`
+	Review       int                      `json:"review"`
+	Unmatched    int                      `json:"unmatched"`
+	Threshold    float64                  `json:"threshold"`
+	Online       bool                     `json:"online"`
+	CollectionID uint                     `json:"collection_id,omitempty"`
+	Entries      []*collectionImportEntry `json:"entries"`
+
+	sources []string
+}
+
+type collectionImportApplyRequest struct {
+	JobID        string `json:"job_id"`
+	Name         string `json:"name"`
+	CollectionID uint   `json:"collection_id"`
+	// Items 为空时导入全部 matched 条目的默认候选。
+	Items []struct {
+		Index     int `json:"index"`
+		Candidate int `json:"candidate"`
+	} `json:"items"`
+}
+
+func registerCollectionPlaylistRoutes(colAPI *gin.RouterGroup) {
+	colAPI.GET("/:id/export", func(c *gin.Context) {
+		collection, err := loadCollection(c.Param("id"))
+		if err != nil {
+			c.JSON(404, gin.H{"error": "歌单不存在"})
+			return
+		}
+		format := strings.ToLower(strings.TrimSpace(c.DefaultQuery("format", "m3u8")))
+		var body []byte
+		var contentType string
+		switch format {
+		case "m3u8", "m3u":
+			format, contentType = "m3u8", "audio/x-mpegurl; charset=utf-8"
+			body, err = exportCollectionM3U8(collection, requestBaseURL(c))
+		case "xspf":
+			contentType = "application/xspf+xml; charset=utf-8"
+			body, err = exportCollectionXSPF(collection, requestBaseURL(c))
+		case "json":
+			contentType = "application/json; charset=utf-8"
+			body, err = exportCollectionJSON(collection)
+		default:
+			c.JSON(400, gin.H{"error": "不支持的导出格式: " + format})
+			return
+		}
+		if err != nil {
+			c.JSON(500, gin.H{"error": "导出失败: " + err.Error()})
+			return
+		}
+		setDownloadHeader(c, collection.Name+"."+format)
+		c.Data(http.StatusOK, contentType, body)
+	})
+
+	colAPI.POST("/import_file", requireSameOriginWrite, func(c *gin.Context) {
+		file, err := c.FormFile("file")
+		if err != nil {
+			c.JSON(400, gin.H{"error": "请选择歌单文件"})
+			return
+		}
+		if file.Size > collectionImportMaxUploadBytes {
+			c.JSON(400, gin.H{"error": fmt.Sprintf("歌单文件不能超过 %d MB", collectionImportMaxUploadBytes>>20)})
+			return
+		}
+		reader, err := file.Open()
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		data, err := io.ReadAll(io.LimitReader(reader, collectionImportMaxUploadBytes))
+		reader.Close()
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+
+		format, title, entries, document, err := parsePlaylistFile(file.Filename, data)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		if document != nil {
+			created, err := importCollectionArchiveEntries(document.Collections)
+			if err != nil {
+				c.JSON(500, gin.H{"error": "导入失败: " + err.Error()})
+				return
+			}
+			c.JSON(200, gin.H{"status": "imported", "created": created, "collections": len(document.Collections)})
+			return
+		}
+
+		name := strings.TrimSpace(c.PostForm("name"))
+		if name == "" {
+			name = title
+		}
+		if name == "" {
+			name = strings.TrimSuffix(filepath.Base(file.Filename), filepath.Ext(file.Filename))
+		}
+		threshold, _ := strconv.ParseFloat(c.PostForm("threshold"), 64)
+		job, err := startCollectionImport(name, format, entries, threshold, c.DefaultPostForm("online", "1") != "0")
+		if err != nil {
+			collectionImportJobs.startError(c, err)
+			return
+		}
+		c.JSON(200, gin.H{"status": "started", "job": job.snapshot()})
+	})
+
+	colAPI.GET("/import_file", func(c *gin.Context) {
+		job := collectionImportJobs.get()
+		if job == nil {
+			c.JSON(200, gin.H{"job": nil})
+			return
+		}
+		c.JSON(200, gin.H{"job": job.snapshot()})
+	})
+
+	colAPI.POST("/import_file/cancel", requireSameOriginWrite, func(c *gin.Context) {
+		job := collectionImportJobs.get()
+		if job == nil {
+			c.JSON(404, gin.H{"error": errCollectionImportNoJob.Error()})
+			return
+		}
+		job.cancel()
+		c.JSON(200, gin.H{"status": "ok"})
+	})
+
+	colAPI.POST("/import_file/apply", requireSameOriginWrite, func(c *gin.Context) {
+		var req collectionImportApplyRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": "参数错误"})
+			return
+		}
+		job := collectionImportJobs.get()
+		if job == nil || job.ID != req.JobID {
+			c.JSON(404, gin.H{"error": "导入任务不存在或已被新的任务替换"})
+			return
+		}
+		if job.running() {
+			c.JSON(409, gin.H{"error": "匹配尚未完成"})
+			return
+		}
+		collection, added, err := job.apply(req)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(200, gin.H{"status": "ok", "id": collection.ID, "name": collection.Name, "added": added})
+	})
+}
+
+// requestBaseURL 是浏览器访问本服务用的地址，导出的流地址以它开头。
+func requestBaseURL(c *gin.Context) string {
+	scheme := "http"
+	if c.Request.TLS != nil {
+		scheme = "https"
+	}
+	if proto := strings.TrimSpace(strings.Split(c.GetHeader("X-Forwarded-Proto"), ",")[0]); proto == "http" || proto == "https" {
+		scheme = proto
+	}
+	return scheme + "://" + c.Request.Host
+}
+
+// collectionExportLocation 指向本地文件（绝对路径）或 /download 流地址。
+func collectionExportLocation(song model.Song, baseURL string) (string, bool) {
+	if isLocalMusicSource(song.Source) {
+		if track, err := localMusicTrackByID(song.ID); err == nil {
+			return track.absPath, true
+		}
+	} else if row, absPath, err := findLocalMusicMatch(song.Name, song.Artist); err == nil && row != nil {
+		return absPath, true
+	}
+
+	query := url.Values{}
+	query.Set("id", song.ID)
+	query.Set("source", song.Source)
+	query.Set("name", song.Name)
+	query.Set("artist", song.Artist)
+	if song.Album != "" {
+		query.Set("album", song.Album)
+	}
+	if len(song.Extra) > 0 {
+		if raw, err := json.Marshal(song.Extra); err == nil {
+			query.Set("extra", string(raw))
+		}
+	}
+	query.Set("stream", "1")
+	return baseURL + RoutePrefix + "/download?" + query.Encode(), false
+}
+
+func exportCollectionM3U8(collection *Collection, baseURL string) ([]byte, error) {
+	songs, err := loadCollectionSongs(collection)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	buf.WriteString("#EXTM3U\n")
+	fmt.Fprintf(&buf, "#PLAYLIST:%s\n", m3uLine(collection.Name))
+	for _, song := range songs {
+		location, _ := collectionExportLocation(song, baseURL)
+		duration := song.Duration
+		if duration <= 0 {
+			duration = -1
+		}
+		display := song.Name
+		if song.Artist != "" {
+			display = song.Artist + " - " + song.Name
+		}
+		fmt.Fprintf(&buf, "#EXTINF:%d,%s\n", duration, m3uLine(display))
+		if song.Album != "" {
+			fmt.Fprintf(&buf, "#EXTALB:%s\n", m3uLine(song.Album))
+		}
+		buf.WriteString(location + "\n")
+	}
+	return buf.Bytes(), nil
+}
+
+func m3uLine(value string) string {
+	return strings.NewReplacer("\r", " ", "\n", " ").Replace(strings.TrimSpace(value))
+}
+
+func exportCollectionXSPF(collection *Collection, baseURL string) ([]byte, error) {
+	songs, err := loadCollectionSongs(collection)
+	if err != nil {
+		return nil, err
+	}
+	playlist := xspfPlaylist{Version: "1", Xmlns: "http://xspf.org/ns/0/", Title: collection.Name, Tracks: make([]xspfTrack, 0, len(songs))}
+	for _, song := range songs {
+		location, local := collectionExportLocation(song, baseURL)
+		if local {
+			location = fileURIFromPath(location)
+		}
+		track := xspfTrack{
+			Location: []string{location},
+			Title:    song.Name,
+			Creator:  song.Artist,
+			Album:    song.Album,
+			Duration: song.Duration * 1000,
+		}
+		if strings.HasPrefix(song.Cover, "http://") || strings.HasPrefix(song.Cover, "https://") {
+			track.Image = song.Cover
+		} else if strings.HasPrefix(song.Cover, "/") {
+			track.Image = baseURL + song.Cover
+		}
+		playlist.Tracks = append(playlist.Tracks, track)
+	}
+	out, err := xml.MarshalIndent(playlist, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), append(out, '\n')...), nil
+}
+
+func exportCollectionJSON(collection *Collection) ([]byte, error) {
+	songs := []SavedSong{}
+	if collection.isManual() {
+		if err := db.Where("collection_id = ?", collection.ID).Order(savedSongExportOrder).Find(&songs).Error; err != nil {
+			return nil, err
+		}
+	}
+	return json.MarshalIndent(collectionPlaylistDocument{
+		Format:      collectionPlaylistJSONFormat,
+		Version:     collectionPlaylistJSONVersion,
+		Collections: []collectionArchiveEntry{{Collection: *collection, Songs: songs}},
+	}, "", "  ")
+}
+
+func fileURIFromPath(path string) string {
+	slashed := filepath.ToSlash(path)
+	if !strings.HasPrefix(slashed, "/") {
+		slashed = "/" + slashed
+	}
+	return (&url.URL{Scheme: "file", Path: slashed}).String()
+}
+
+// playlistLocationPath 把 file:// URI 或普通路径还原成本机路径；网络地址返回 false。
+func playlistLocationPath(location string) (string, bool) {
+	location = strings.TrimSpace(location)
+	if location == "" {
+		return "", false
+	}
+	if strings.HasPrefix(strings.ToLower(location), "file:") {
+		parsed, err := url.Parse(location)
+		if err != nil {
+			return "", false
+		}
+		path := parsed.Path
+		if runtime.GOOS == "windows" && len(path) > 2 && path[0] == '/' && path[2] == ':' {
+			path = path[1:]
+		}
+		return filepath.FromSlash(path), true
+	}
+	if strings.Contains(location, "://") {
+		return "", false
+	}
+	return filepath.FromSlash(location), true
+}
+
+// parsePlaylistFile 按扩展名（无法判断时按内容）解析歌单文件。document 非空
+// 表示这是本程序导出的无损 JSON，可以原样导入。
+func parsePlaylistFile(filename string, data []byte) (string, string, []playlistFileEntry, *collectionPlaylistDocument, error) {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	format := strings.TrimPrefix(strings.ToLower(filepath.Ext(filename)), ".")
+	switch format {
+	case "m3u", "m3u8", "xspf", "json", "csv":
+	default:
+		format = sniffPlaylistFormat(data)
+	}
+
+	var title string
+	var entries []playlistFileEntry
+	var document *collectionPlaylistDocument
+	var err error
+	switch format {
+	case "xspf":
+		title, entries, err = parseXSPFPlaylist(data)
+	case "json":
+		document, title, entries, err = parseJSONPlaylist(data)
+	case "csv":
+		entries, err = parseCSVPlaylist(data)
+	default:
+		title, entries = parseM3UPlaylist(data)
+	}
+	if err != nil {
+		return format, title, nil, nil, err
+	}
+	if document != nil {
+		return format, title, nil, document, nil
+	}
+	if len(entries) == 0 {
+		return format, title, nil, nil, errors.New("歌单文件里没有可导入的歌曲")
+	}
+	if len(entries) > collectionImportMaxEntries {
+		return format, title, nil, nil, fmt.Errorf("一次最多导入 %d 首", collectionImportMaxEntries)
+	}
+	return format, title, entries, nil, nil
+}
+
+func sniffPlaylistFormat(data []byte) string {
+	trimmed := bytes.TrimSpace(data)
+	switch {
+	case bytes.HasPrefix(trimmed, []byte("<")):
+		return "xspf"
+	case bytes.HasPrefix(trimmed, []byte("{")), bytes.HasPrefix(trimmed, []byte("[")):
+		return "json"
+	case bytes.HasPrefix(trimmed, []byte("#EXTM3U")):
+		return "m3u"
+	}
+	firstLine, _, _ := bytes.Cut(trimmed, []byte("\n"))
+	if bytes.Contains(firstLine, []byte(",")) && !bytes.Contains(firstLine, []byte("://")) {
+		return "csv"
+	}
+	return "m3u"
+}
+
+func parseM3UPlaylist(data []byte) (string, []playlistFileEntry) {
+	var title string
+	var entries []playlistFileEntry
+	var pending playlistFileEntry
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, "#PLAYLIST:"):
+			title = strings.TrimSpace(strings.TrimPrefix(line, "#PLAYLIST:"))
+		case strings.HasPrefix(line, "#EXTINF:"):
+			pending = parseM3UExtInf(strings.TrimPrefix(line, "#EXTINF:"))
+		case strings.HasPrefix(line, "#EXTALB:"):
+			pending.Album = strings.TrimSpace(strings.TrimPrefix(line, "#EXTALB:"))
+		case strings.HasPrefix(line, "#EXTART:"):
+			pending.Artist = strings.TrimSpace(strings.TrimPrefix(line, "#EXTART:"))
+		case strings.HasPrefix(line, "#"):
+		default:
+			pending.Location = line
+			entries = append(entries, completePlaylistEntry(pending))
+			pending = playlistFileEntry{}
+		}
+	}
+	return title, entries
+}
+
+// parseM3UExtInf 读取 “180 tvg-id="x",Artist - Title”；属性里的引号可能含逗号。
+func parseM3UExtInf(value string) playlistFileEntry {
+	var entry playlistFileEntry
+	inQuote := false
+	split := -1
+	for i, r := range value {
+		if r == '"' {
+			inQuote = !inQuote
+		} else if r == ',' && !inQuote {
+			split = i
+			break
+		}
+	}
+	if split < 0 {
+		return entry
+	}
+	head := strings.Fields(value[:split])
+	if len(head) > 0 {
+		if seconds, err := strconv.Atoi(head[0]); err == nil && seconds > 0 {
+			entry.Duration = seconds
+		}
+	}
+	entry.Artist, entry.Title = splitPlaylistDisplayName(value[split+1:])
+	return entry
+}
+
+// splitPlaylistDisplayName 把 “Artist - Title” 拆开，没有分隔符时整体当标题。
+func splitPlaylistDisplayName(display string) (string, string) {
+	display = strings.TrimSpace(display)
+	if artist, name, ok := strings.Cut(display, " - "); ok && strings.TrimSpace(artist) != "" && strings.TrimSpace(name) != "" {
+		return strings.TrimSpace(artist), strings.TrimSpace(name)
+	}
+	return "", display
+}
+
+// completePlaylistEntry 用地址补全条目：本程序导出的 /download 地址带着
+// 源和歌曲 ID；没有标题时从文件名猜。
+func completePlaylistEntry(entry playlistFileEntry) playlistFileEntry {
+	if parsed, err := url.Parse(entry.Location); err == nil && (parsed.Scheme == "http" || parsed.Scheme == "https") && strings.HasSuffix(parsed.Path, "/download") {
+		query := parsed.Query()
+		if id, source := strings.TrimSpace(query.Get("id")), strings.TrimSpace(query.Get("source")); id != "" && source != "" {
+			entry.SongID, entry.Source = id, source
+			entry.Extra = parseSongExtraQuery(query.Get("extra"))
+			if entry.Title == "" {
+				entry.Title, entry.Artist = query.Get("name"), query.Get("artist")
+			}
+			if entry.Album == "" {
+				entry.Album = query.Get("album")
+			}
+		}
+	}
+	if entry.Title == "" {
+		if path, ok := playlistLocationPath(entry.Location); ok {
+			// 歌单可能来自另一个系统，两种分隔符都认。
+			base := path[strings.LastIndexAny(path, `/\`)+1:]
+			entry.Artist, entry.Title = splitPlaylistDisplayName(strings.TrimSuffix(base, filepath.Ext(base)))
+		}
+	}
+	return entry
+}
+
+func parseXSPFPlaylist(data []byte) (string, []playlistFileEntry, error) {
+	var playlist xspfPlaylist
+	if err := xml.Unmarshal(data, &playlist); err != nil {
+		return "", nil, fmt.Errorf("无法解析 XSPF: %w", err)
+	}
+	entries := make([]playlistFileEntry, 0, len(playlist.Tracks))
+	for _, track := range playlist.Tracks {
+		entry := playlistFileEntry{
+			Title:    strings.TrimSpace(track.Title),
+			Artist:   strings.TrimSpace(track.Creator),
+			Album:    strings.TrimSpace(track.Album),
+			Duration: (track.Duration + 500) / 1000,
+		}
+		if len(track.Location) > 0 {
+			entry.Location = strings.TrimSpace(track.Location[0])
+		}
+		if entry.Title == "" && entry.Location == "" {
+			continue
+		}
+		entries = append(entries, completePlaylistEntry(entry))
+	}
+	return strings.TrimSpace(playlist.Title), entries, nil
+}
+
+// parseJSONPlaylist 接受本程序的无损格式，或者常见的 [{title, artist, ...}]
+// 数组（也可以包在 tracks/songs 字段里）。
+func parseJSONPlaylist(data []byte) (*collectionPlaylistDocument, string, []playlistFileEntry, error) {
+	var document collectionPlaylistDocument
+	if err := json.Unmarshal(data, &document); err == nil && document.Format == collectionPlaylistJSONFormat {
+		if document.Version > collectionPlaylistJSONVersion {
+			return nil, "", nil, fmt.Errorf("不支持的歌单文件版本 %d", document.Version)
+		}
+		return &document, "", nil, nil
+	}
+
+	var title string
+	var items []map[string]interface{}
+	if err := json.Unmarshal(data, &items); err != nil {
+		var wrapper map[string]json.RawMessage
+		if err := json.Unmarshal(data, &wrapper); err != nil {
+			return nil, "", nil, fmt.Errorf("无法解析 JSON 歌单: %w", err)
+		}
+		for _, key := range []string{"name", "title"} {
+			if raw, ok := wrapper[key]; ok {
+				_ = json.Unmarshal(raw, &title)
+				break
+			}
+		}
+		for _, key := range []string{"tracks", "songs", "items"} {
+			if raw, ok := wrapper[key]; ok {
+				if err := json.Unmarshal(raw, &items); err != nil {
+					return nil, "", nil, fmt.Errorf("无法解析 JSON 歌单: %w", err)
+				}
+				break
+			}
+		}
+	}
+
+	entries := make([]playlistFileEntry, 0, len(items))
+	for _, item := range items {
+		entry := playlistFileEntry{
+			Title:    jsonPlaylistString(item, "name", "title", "track"),
+			Artist:   jsonPlaylistString(item, "artist", "artists", "creator", "singer"),
+			Album:    jsonPlaylistString(item, "album"),
+			Location: jsonPlaylistString(item, "location", "path", "url"),
+			Source:   jsonPlaylistString(item, "source"),
+			SongID:   jsonPlaylistString(item, "id", "song_id"),
+		}
+		if entry.Source == "" || entry.SongID == "" {
+			entry.Source, entry.SongID = "", ""
+		}
+		if value, ok := item["duration"].(float64); ok && value > 0 {
+			entry.Duration = int(value)
+			// 超过一天的秒数只可能是毫秒。
+			if entry.Duration > 86400 {
+				entry.Duration /= 1000
+			}
+		} else if value, ok := item["duration"].(string); ok {
+			entry.Duration = parsePlaylistDuration(value, false)
+		}
+		if entry.Title == "" && entry.Location == "" {
+			continue
+		}
+		entries = append(entries, completePlaylistEntry(entry))
+	}
+	return nil, strings.TrimSpace(title), entries, nil
+}
+
+func jsonPlaylistString(item map[string]interface{}, keys ...string) string {
+	for _, key := range keys {
+		switch value := item[key].(type) {
+		case string:
+			if value = strings.TrimSpace(value); value != "" {
+				return value
+			}
+		case float64:
+			return strconv.FormatFloat(value, 'f', -1, 64)
+		case []interface{}:
+			names := make([]string, 0, len(value))
+			for _, v := range value {
+				switch v := v.(type) {
+				case string:
+					names = append(names, v)
+				case map[string]interface{}:
+					if name, ok := v["name"].(string); ok {
+						names = append(names, name)
+					}
+				}
+			}
+			if len(names) > 0 {
+				return strings.Join(names, "/")
+			}
+		}
+	}
+	return ""
+}
+
+// parseCSVPlaylist 识别常见导出工具的表头（Title/Track Name/歌名、Artist/
+// Artist Name(s)/歌手、Album、Duration/Duration (ms)）；没有表头时按
+// 标题、歌手、专辑三列读取。
+func parseCSVPlaylist(data []byte) ([]playlistFileEntry, error) {
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	reader.TrimLeadingSpace = true
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("无法解析 CSV: %w", err)
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+
+	columns := map[string]int{"title": 0, "artist": 1, "album": 2, "duration": -1}
+	durationMillis := false
+	header := map[string]int{}
+	for i, cell := range records[0] {
+		header[strings.ToLower(strings.TrimSpace(cell))] = i
+	}
+	find := func(names ...string) int {
+		for _, name := range names {
+			if i, ok := header[name]; ok {
+				return i
+			}
+		}
+		return -1
+	}
+	if title := find("title", "name", "track name", "track", "song", "song name", "歌名", "歌曲", "歌曲名", "标题"); title >= 0 {
+		columns["title"] = title
+		columns["artist"] = find("artist", "artists", "artist name(s)", "artist name", "singer", "歌手", "艺术家")
+		columns["album"] = find("album", "album name", "专辑")
+		columns["duration"] = find("duration", "length", "时长")
+		if ms := find("duration (ms)", "duration_ms", "track duration (ms)"); ms >= 0 {
+			columns["duration"], durationMillis = ms, true
+		}
+		records = records[1:]
+	}
+
+	cell := func(record []string, key string) string {
+		if i := columns[key]; i >= 0 && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+	entries := make([]playlistFileEntry, 0, len(records))
+	for _, record := range records {
+		entry := playlistFileEntry{
+			Title:    cell(record, "title"),
+			Artist:   cell(record, "artist"),
+			Album:    cell(record, "album"),
+			Duration: parsePlaylistDuration(cell(record, "duration"), durationMillis),
+		}
+		if entry.Title == "" {
+			continue
+		}
+		// Spotify 导出用 “, ” 分隔多位歌手。
+		entry.Artist = strings.ReplaceAll(entry.Artist, ", ", "/")
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// parsePlaylistDuration 接受秒数、毫秒数或 mm:ss / hh:mm:ss。
+func parsePlaylistDuration(raw string, millis bool) int {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return 0
+	}
+	if strings.Contains(raw, ":") {
+		total := 0
+		for _, part := range strings.Split(raw, ":") {
+			n, err := strconv.Atoi(strings.TrimSpace(part))
+			if err != nil {
+				return 0
+			}
+			total = total*60 + n
+		}
+		return total
+	}
+	value, err := strconv.ParseFloat(raw, 64)
+	if err != nil || value <= 0 {
+		return 0
+	}
+	if millis {
+		value /= 1000
+	}
+	return int(value + 0.5)
+}
+
+func startCollectionImport(name string, format string, entries []playlistFileEntry, threshold float64, online bool) (*collectionImportJob, error) {
+	if threshold <= 0 || threshold > 1 {
+		threshold = collectionImportDefaultThreshold
+	}
+	var sources []string
+	if online {
+		sources = localMusicIdentifySources(nil)
+	}
+	job := &collectionImportJob{
+		backgroundJob: newBackgroundJob("running", len(entries)),
+		Name:          name,
+		Format:        format,
+		Threshold:     threshold,
+		Online:        len(sources) > 0,
+		Entries:       make([]*collectionImportEntry, 0, len(entries)),
+		sources:       sources,
+	}
+	for i, entry := range entries {
+		job.Entries = append(job.Entries, &collectionImportEntry{
+			Index:      i,
+			Title:      entry.Title,
+			Artist:     entry.Artist,
+			Album:      entry.Album,
+			Duration:   entry.Duration,
+			Location:   entry.Location,
+			Status:     "pending",
+			Selected:   -1,
+			Candidates: []collectionImportCandidate{},
+			file:       entry,
+		})
+	}
+
+	if err := collectionImportJobs.install(job); err != nil {
+		return nil, err
+	}
+	go job.run()
+	return job, nil
+}
+
+func (job *collectionImportJob) snapshot() *collectionImportJob {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	out := &collectionImportJob{
+		backgroundJob: job.snapshotLocked(),
+		Name:          job.Name,
+		Format:        job.Format,
+		Matched:       job.Matched,
+		Review:        job.Review,
+		Unmatched:     job.Unmatched,
+		Threshold:     job.Threshold,
+		Online:        job.Online,
+		CollectionID:  job.CollectionID,
+		Entries:       make([]*collectionImportEntry, 0, len(job.Entries)),
+	}
+	for _, entry := range job.Entries {
+		copied := *entry
+		out.Entries = append(out.Entries, &copied)
+	}
+	return out
+}
+
+func (job *collectionImportJob) run() {
+	job.process(collectionImportWorkers, func(index int) func() {
+		job.mu.Lock()
+		entry := *job.Entries[index]
+		job.mu.Unlock()
+
+		matchCollectionImportEntry(&entry, job.sources, job.Threshold)
+		return func() {
+			job.Entries[index] = &entry
+			switch entry.Status {
+			case "matched":
+				job.Matched++
+			case "review":
+				job.Review++
+			default:
+				job.Unmatched++
+			}
+		}
+	})
+	job.finish(nil)
+}
+
+// matchCollectionImportEntry 依次尝试：文件自带的源和 ID、曲库里的本地文件
+// 路径、本地曲库按名称查找，最后才去在线源搜索。
+func matchCollectionImportEntry(entry *collectionImportEntry, sources []string, threshold float64) {
+	entry.Candidates = []collectionImportCandidate{}
+	entry.Status, entry.Selected, entry.Confidence = "unmatched", -1, 0
+	settle := func() {
+		sort.SliceStable(entry.Candidates, func(i, j int) bool {
+			return entry.Candidates[i].Confidence > entry.Candidates[j].Confidence
+		})
+		if len(entry.Candidates) > collectionImportMaxCandidates {
+			entry.Candidates = entry.Candidates[:collectionImportMaxCandidates]
+		}
+		if len(entry.Candidates) == 0 {
+			return
+		}
+		entry.Selected, entry.Confidence = 0, entry.Candidates[0].Confidence
+		if entry.Confidence >= threshold {
+			entry.Status = "matched"
+		} else {
+			entry.Status = "review"
+		}
+	}
+
+	file := entry.file
+	if file.Source != "" && file.SongID != "" {
+		if isLocalMusicSource(file.Source) {
+			if track, err := localMusicTrackByID(file.SongID); err == nil {
+				entry.Candidates = append(entry.Candidates, localTrackImportCandidate(track, 1))
+			}
+		} else {
+			entry.Candidates = append(entry.Candidates, collectionImportCandidate{
+				ID: file.SongID, Source: file.Source, Name: file.Title, Artist: file.Artist, Album: file.Album,
+				Duration: file.Duration, Confidence: 1, Extra: file.Extra,
+			})
+		}
+		if len(entry.Candidates) > 0 {
+			settle()
+			return
+		}
+	}
+	if track := localMusicTrackForLocation(file.Location); track != nil {
+		entry.Candidates = append(entry.Candidates, localTrackImportCandidate(track, 1))
+		settle()
+		return
+	}
+
+	hints := collectionImportHints(file)
+	if len(hints) == 0 {
+		return
+	}
+	best := 0.0
+	seenLocal := map[string]bool{}
+	for _, hint := range hints {
+		row, _, err := findLocalMusicMatch(hint.Name, hint.Artist)
+		if err != nil || row == nil || seenLocal[row.ID] {
+			continue
+		}
+		seenLocal[row.ID] = true
+		confidence, _, ok := scoreLocalMusicIdentifyCandidate(hints, file.Duration, model.Song{Name: row.Name, Artist: row.Artist, Duration: row.Duration})
+		if !ok {
+			continue
+		}
+		entry.Candidates = append(entry.Candidates, localIndexImportCandidate(row, confidence))
+		if confidence > best {
+			best = confidence
+		}
+	}
+
+	if best < threshold && len(sources) > 0 {
+		keyword := strings.TrimSpace(hints[0].Name + " " + hints[0].Artist)
+		for _, candidate := range searchIdentifyCandidates(keyword, hints, file.Duration, sources) {
+			entry.Candidates = append(entry.Candidates, onlineImportCandidate(candidate.song, candidate.confidence))
+			if len(entry.Candidates) >= collectionImportMaxCandidates*2 {
+				break
+			}
+		}
+	}
+	settle()
+}
+
+// collectionImportHints 与 localMusicIdentifyHints 同理：没有歌手的
+// “A - B” 两种读法都试。
+func collectionImportHints(file playlistFileEntry) []localMusicIdentifyHint {
+	name := strings.TrimSpace(localMusicTrackNumberPrefix.ReplaceAllString(file.Title, ""))
+	if name == "" {
+		return nil
+	}
+	if file.Artist != "" {
+		return []localMusicIdentifyHint{{Name: name, Artist: file.Artist}}
+	}
+	if left, right, ok := strings.Cut(name, " - "); ok {
+		left, right = strings.TrimSpace(left), strings.TrimSpace(right)
+		if left != "" && right != "" {
+			return []localMusicIdentifyHint{{Name: right, Artist: left}, {Name: left, Artist: right}}
+		}
+	}
+	return []localMusicIdentifyHint{{Name: name}}
+}
+
+// localMusicTrackForLocation 找到歌单里指向曲库内文件的本地路径；相对路径
+// 按各曲库目录解析。
+func localMusicTrackForLocation(location string) *localMusicTrack {
+	path, ok := playlistLocationPath(location)
+	if !ok {
+		return nil
+	}
+	for _, root := range localLibraryRoots() {
+		absPath := path
+		if !filepath.IsAbs(absPath) {
+			absPath = filepath.Join(root.Abs, absPath)
+		}
+		if !isPathInside(root.Abs, absPath) {
+			continue
+		}
+		rel, err := filepath.Rel(root.Abs, absPath)
+		if err != nil {
+			continue
+		}
+		if track, err := localMusicTrackAt(root.Key, filepath.ToSlash(rel)); err == nil {
+			return track
+		}
+	}
+	return nil
+}
+
+func localTrackImportCandidate(track *localMusicTrack, confidence float64) collectionImportCandidate {
+	return collectionImportCandidate{
+		ID: track.ID, Source: localMusicSource, Name: track.Name, Artist: track.Artist, Album: track.Album,
+		Cover: track.Cover, Duration: track.Duration, Confidence: confidence, Local: true, Extra: track.Extra,
+	}
+}
+
+func localIndexImportCandidate(row *LocalMusicIndex, confidence float64) collectionImportCandidate {
+	extra := localMusicIndexExtra(row)
+	if row.Album != "" {
+		extra["album"] = row.Album
+	}
+	candidate := collectionImportCandidate{
+		ID: row.ID, Source: localMusicSource, Name: row.Name, Artist: row.Artist, Album: row.Album,
+		Duration: row.Duration, Confidence: confidence, Local: true, Extra: extra,
+	}
+	if row.HasCover {
+		candidate.Cover = localMusicCoverURL(row.ID)
+	}
+	return candidate
+}
+
+func onlineImportCandidate(song model.Song, confidence float64) collectionImportCandidate {
+	return collectionImportCandidate{
+		ID: song.ID, Source: song.Source, Name: strings.TrimSpace(song.Name), Artist: strings.TrimSpace(song.Artist),
+		Album: strings.TrimSpace(song.Album), Cover: strings.TrimSpace(song.Cover), Duration: song.Duration,
+		Confidence: confidence, Extra: savedSongExtra(song),
+	}
+}
+
+// savedSongExtra 把专辑、专辑 ID 和链接并进 Extra，loadSavedSongs 从这里读回。
+func savedSongExtra(song model.Song) map[string]string {
+	extra := make(map[string]string, len(song.Extra)+3)
+	for key, value := range song.Extra {
+		extra[key] = value
+	}
+	for key, value := range map[string]string{"album": song.Album, "album_id": song.AlbumID, "link": song.Link} {
+		if value = strings.TrimSpace(value); value != "" {
+			extra[key] = value
+		}
+	}
+	return extra
+}
+
+// apply 把选中的候选写进手动歌单（新建或已有）。歌单按 id 倒序展示，所以
+// 倒着插入，展示顺序才与文件一致。
+func (job *collectionImportJob) apply(req collectionImportApplyRequest) (*Collection, int, error) {
+	job.mu.Lock()
+	picked := make([]collectionImportCandidate, 0, len(job.Entries))
+	if len(req.Items) == 0 {
+		for _, entry := range job.Entries {
+			if entry.Status == "matched" && entry.Selected >= 0 && entry.Selected < len(entry.Candidates) {
+				picked = append(picked, entry.Candidates[entry.Selected])
+			}
+		}
+	} else {
+		items := append(req.Items[:0:0], req.Items...)
+		sort.SliceStable(items, func(i, j int) bool { return items[i].Index < items[j].Index })
+		for _, item := range items {
+			if item.Index < 0 || item.Index >= len(job.Entries) {
+				continue
+			}
+			entry := job.Entries[item.Index]
+			if item.Candidate >= 0 && item.Candidate < len(entry.Candidates) {
+				picked = append(picked, entry.Candidates[item.Candidate])
+			}
+		}
+	}
+	name := job.Name
+	job.mu.Unlock()
+	if len(picked) == 0 {
+		return nil, 0, errors.New("没有选中要导入的歌曲")
+	}
+
+	var collection *Collection
+	if req.CollectionID > 0 {
+		existing, err := loadCollection(strconv.FormatUint(uint64(req.CollectionID), 10))
+		if err != nil {
+			return nil, 0, errors.New("歌单不存在")
+		}
+		if existing.isSmart() {
+			return nil, 0, errors.New(smartCollectionReadonlyError)
+		}
+		if !existing.isManual() {
+			return nil, 0, errors.New("外部导入歌单/专辑不保存歌曲明细，不能直接加入歌曲")
+		}
+		collection = existing
+	} else {
+		if strings.TrimSpace(req.Name) != "" {
+			name = strings.TrimSpace(req.Name)
+		}
+		if name == "" {
+			name = "导入的歌单"
+		}
+		collection = &Collection{Name: name, Kind: collectionKindManual, ContentType: collectionContentPlaylist, Source: "local"}
+		if err := db.Create(collection).Error; err != nil {
+			return nil, 0, err
+		}
+	}
+
+	now := time.Now()
+	songs := make([]SavedSong, 0, len(picked))
+	for i := len(picked) - 1; i >= 0; i-- {
+		candidate := picked[i]
+		extra := ""
+		if len(candidate.Extra) > 0 {
+			if raw, err := json.Marshal(candidate.Extra); err == nil {
+				extra = string(raw)
+			}
+		}
+		songs = append(songs, SavedSong{
+			CollectionID: collection.ID,
+			SongID:       candidate.ID,
+			Source:       candidate.Source,
+			Extra:        extra,
+			Name:         candidate.Name,
+			Artist:       candidate.Artist,
+			Cover:        candidate.Cover,
+			Duration:     candidate.Duration,
+			AddedAt:      now,
+		})
+	}
+	tx := db.Clauses(clause.OnConflict{DoNothing: true}).CreateInBatches(&songs, 200)
+	if tx.Error != nil {
+		return nil, 0, tx.Error
+	}
+
+	job.mu.Lock()
+	job.CollectionID = collection.ID
+	job.mu.Unlock()
+	return collection, int(tx.RowsAffected), nil
+}
@@ -0,0 +1,247 @@
+package web
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/guohuiyuan/go-music-dl/core"
+	"github.com/guohuiyuan/music-lib/model"
+)
+
+func uploadPlaylistFileForTest(t *testing.T, filename string, content string, fields map[string]string) (int, map[string]json.RawMessage) {
+	t.Helper()
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	part, err := writer.CreateFormFile("file", filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write([]byte(content))
+	for key, value := range fields {
+		writer.WriteField(key, value)
+	}
+	writer.Close()
+
+	req := httptest.NewRequest(http.MethodPost, RoutePrefix+"/collections/import_file", &body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	req.Header.Set("X-Requested-With", "XMLHttpRequest")
+	rec := httptest.NewRecorder()
+	newLocalMusicTestRouter().ServeHTTP(rec, req)
+	var resp map[string]json.RawMessage
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("upload response %d %s", rec.Code, rec.Body.String())
+	}
+	return rec.Code, resp
+}
+
+func exportCollectionForTest(t *testing.T, id uint, format string) string {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, RoutePrefix+"/collections/"+jsonNumberForTest(id)+"/export?format="+format, nil)
+	req.Host = "music.test:8080"
+	newLocalMusicTestRouter().ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("export %s = %d %s", format, rec.Code, rec.Body.String())
+	}
+	if got := rec.Header().Get("Content-Disposition"); !strings.Contains(got, "."+format) {
+		t.Fatalf("Content-Disposition = %q", got)
+	}
+	return rec.Body.String()
+}
+
+func jsonNumberForTest(id uint) string {
+	raw, _ := json.Marshal(id)
+	return string(raw)
+}
+
+func waitCollectionImportJobForTest(t *testing.T) *collectionImportJob {
+	t.Helper()
+	job := collectionImportJobs.get()
+	if job == nil {
+		t.Fatal("no import job")
+	}
+	waitJobDoneForTest(t, "import", job.done)
+	return job.snapshot()
+}
+
+func TestParsePlaylistFileFormats(t *testing.T) {
+	m3u := "\xef\xbb\xbf#EXTM3U\n#PLAYLIST:Road Trip\n#EXTINF:215 tvg-name=\"a,b\",周杰伦 - 晴天\n#EXTALB:叶惠美\n/music/a.mp3\n" +
+		"#EXTINF:-1,Ignored - Name\nhttp://host/music/download?id=42&source=netease&name=%E7%A8%BB%E9%A6%99&artist=%E5%91%A8%E6%9D%B0%E4%BC%A6&stream=1\n" +
+		"C:\\Music\\Artist - Song.flac\n"
+	format, title, entries, _, err := parsePlaylistFile("list.m3u8", []byte(m3u))
+	if err != nil || format != "m3u8" || title != "Road Trip" || len(entries) != 3 {
+		t.Fatalf("m3u = %s %q %+v %v", format, title, entries, err)
+	}
+	if e := entries[0]; e.Title != "晴天" || e.Artist != "周杰伦" || e.Album != "叶惠美" || e.Duration != 215 || e.Location != "/music/a.mp3" {
+		t.Fatalf("m3u entry 0 = %+v", e)
+	}
+	if e := entries[1]; e.Source != "netease" || e.SongID != "42" || e.Title != "Name" || e.Duration != 0 {
+		t.Fatalf("m3u entry 1 = %+v", e)
+	}
+	if e := entries[2]; e.Title != "Song" || e.Artist != "Artist" {
+		t.Fatalf("m3u entry 2 = %+v", e)
+	}
+
+	xspf := `<?xml version="1.0" encoding="UTF-8"?>
+<playlist version="1" xmlns="http://xspf.org/ns/0/"><title>Mix</title><trackList>
+<track><location>file:///music/x.mp3</location><title>X</title><creator>Y</creator><album>Z</album><duration>183600</duration></track>
+</trackList></playlist>`
+	format, title, entries, _, err = parsePlaylistFile("mix", []byte(xspf))
+	if err != nil || format != "xspf" || title != "Mix" || len(entries) != 1 {
+		t.Fatalf("xspf = %s %q %+v %v", format, title, entries, err)
+	}
+	if e := entries[0]; e.Title != "X" || e.Artist != "Y" || e.Album != "Z" || e.Duration != 184 || e.Location != "file:///music/x.mp3" {
+		t.Fatalf("xspf entry = %+v", e)
+	}
+
+	generic := `{"name":"Exported","tracks":[{"title":"A","artists":[{"name":"B"},{"name":"C"}],"duration":"3:05"},{"name":"D","artist":"E","duration":245000},{"album":"only"}]}`
+	_, title, entries, _, err = parsePlaylistFile("x.json", []byte(generic))
+	if err != nil || title != "Exported" || len(entries) != 2 || entries[0].Artist != "B/C" || entries[0].Duration != 185 || entries[1].Duration != 245 {
+		t.Fatalf("json = %q %+v %v", title, entries, err)
+	}
+
+	spotify := "Track Name,Artist Name(s),Album Name,Duration (ms)\n\"Hello, World\",\"A, B\",Album,200400\n"
+	_, _, entries, _, err = parsePlaylistFile("liked.csv", []byte(spotify))
+	if err != nil || len(entries) != 1 || entries[0].Title != "Hello, World" || entries[0].Artist != "A/B" || entries[0].Duration != 200 {
+		t.Fatalf("spotify csv = %+v %v", entries, err)
+	}
+	_, _, entries, _, err = parsePlaylistFile("plain.txt", []byte("晴天,周杰伦,叶惠美\n稻香,周杰伦\n"))
+	if err != nil || len(entries) != 2 || entries[1].Title != "稻香" || entries[1].Album != "" {
+		t.Fatalf("headerless csv = %+v %v", entries, err)
+	}
+
+	if _, _, _, _, err := parsePlaylistFile("empty.m3u", []byte("#EXTM3U\n")); err == nil {
+		t.Fatal("empty playlist should fail")
+	}
+}
+
+func TestCollectionExportAndJSONRoundTrip(t *testing.T) {
+	initCollectionDBForTest(t)
+	downloadDir := t.TempDir()
+	withLocalMusicDownloadDir(t, downloadDir)
+
+	writeTaggedMP3ForTest(t, filepath.Join(downloadDir, "qt.mp3"), core.AudioTags{Title: "晴天", Artist: "周杰伦"})
+	localID := encodeLocalMusicID("qt.mp3")
+	track, err := localMusicTrackByID(localID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	upsertLocalMusicIndexRow(track)
+
+	collection := Collection{Name: "Mix", Kind: collectionKindManual, ContentType: collectionContentPlaylist, Source: "local"}
+	db.Create(&collection)
+	db.Create(&SavedSong{CollectionID: collection.ID, SongID: "n1", Source: "netease", Name: "晴天", Artist: "周杰伦", Duration: 269})
+	db.Create(&SavedSong{CollectionID: collection.ID, SongID: "q2", Source: "qq", Name: "Other", Artist: "Someone", Extra: `{"album":"Alb","mid":"x"}`})
+	db.Create(&SavedSong{CollectionID: collection.ID, SongID: localID, Source: localMusicSource, Name: "晴天", Artist: "周杰伦"})
+
+	m3u := exportCollectionForTest(t, collection.ID, "m3u8")
+	lines := strings.Split(strings.TrimSpace(m3u), "\n")
+	if lines[0] != "#EXTM3U" || lines[1] != "#PLAYLIST:Mix" {
+		t.Fatalf("m3u8 header = %q", lines[:2])
+	}
+	localPath := filepath.Join(downloadDir, "qt.mp3")
+	if lines[3] != localPath || lines[5] != "#EXTALB:Alb" || !strings.HasPrefix(lines[6], "http://music.test:8080"+RoutePrefix+"/download?") || lines[8] != localPath {
+		t.Fatalf("m3u8 body = %q", lines)
+	}
+	_, _, parsed, _, err := parsePlaylistFile("mix.m3u8", []byte(m3u))
+	if err != nil || len(parsed) != 3 || parsed[1].Source != "qq" || parsed[1].SongID != "q2" || parsed[1].Extra["mid"] != "x" {
+		t.Fatalf("re-parsed m3u8 = %+v %v", parsed, err)
+	}
+
+	xspf := exportCollectionForTest(t, collection.ID, "xspf")
+	if !strings.Contains(xspf, "<location>"+fileURIFromPath(localPath)+"</location>") || !strings.Contains(xspf, "<duration>269000</duration>") {
+		t.Fatalf("xspf = %s", xspf)
+	}
+
+	raw := exportCollectionForTest(t, collection.ID, "json")
+	db.Delete(&Collection{}, collection.ID)
+	db.Where("collection_id = ?", collection.ID).Delete(&SavedSong{})
+	code, resp := uploadPlaylistFileForTest(t, "mix.json", raw, nil)
+	if code != http.StatusOK || string(resp["status"]) != `"imported"` || string(resp["created"]) != "1" {
+		t.Fatalf("json import = %d %v", code, resp)
+	}
+	var restored Collection
+	if err := db.First(&restored, "name = ?", "Mix").Error; err != nil {
+		t.Fatal(err)
+	}
+	songs, err := loadSavedSongs(restored.ID)
+	if err != nil || len(songs) != 3 || songs[0].ID != localID || songs[1].Album != "Alb" || songs[2].ID != "n1" {
+		t.Fatalf("restored songs = %+v %v", songs, err)
+	}
+}
+
+func TestCollectionImportFileMatchesAndApplies(t *testing.T) {
+	initCollectionDBForTest(t)
+	downloadDir := t.TempDir()
+	withLocalMusicDownloadDir(t, downloadDir)
+	withLocalMusicIdentifyTestSources(t, []model.Song{
+		{ID: "7", Name: "七里香", Artist: "周杰伦", Album: "七里香", Duration: 299},
+		{ID: "8", Name: "夜曲", Artist: "周杰伦", Duration: 226},
+	})
+	t.Cleanup(func() {
+		collectionImportJobs.reset()
+	})
+
+	writeTaggedMP3ForTest(t, filepath.Join(downloadDir, "qt.mp3"), core.AudioTags{Title: "晴天", Artist: "周杰伦"})
+	localID := encodeLocalMusicID("qt.mp3")
+	track, err := localMusicTrackByID(localID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	upsertLocalMusicIndexRow(track)
+	writeTaggedMP3ForTest(t, filepath.Join(downloadDir, "by-path.mp3"), core.AudioTags{Title: "Path"})
+
+	csv := "title,artist,duration\n晴天,周杰伦,\n七里香,周杰伦,4:59\n夜曲,Someone Else,\nZzz Unknown,Nobody,\n"
+	code, resp := uploadPlaylistFileForTest(t, "list.csv", csv, map[string]string{"name": "From CSV"})
+	if code != http.StatusOK || string(resp["status"]) != `"started"` {
+		t.Fatalf("start import = %d %v", code, resp)
+	}
+	job := waitCollectionImportJobForTest(t)
+	if job.Status != "done" || job.Total != 4 || job.Matched != 2 || job.Review != 1 || job.Unmatched != 1 {
+		t.Fatalf("job = %+v", job)
+	}
+	if e := job.Entries[0]; !e.Candidates[0].Local || e.Candidates[0].ID != localID || len(e.Candidates) != 1 {
+		t.Fatalf("local entry = %+v", e)
+	}
+	if e := job.Entries[1]; e.Candidates[0].Source != "fake" || e.Candidates[0].ID != "7" || e.Candidates[0].Extra["album"] != "七里香" {
+		t.Fatalf("online entry = %+v", e)
+	}
+	if e := job.Entries[2]; e.Status != "review" || e.Selected != 0 || e.Candidates[0].ID != "8" {
+		t.Fatalf("review entry = %+v", e)
+	}
+
+	var applied struct {
+		ID    uint   `json:"id"`
+		Name  string `json:"name"`
+		Added int    `json:"added"`
+	}
+	if code := postLocalMusicTagsJSON(t, "/collections/import_file/apply", gin.H{"job_id": job.ID}, &applied); code != http.StatusOK || applied.Added != 2 || applied.Name != "From CSV" {
+		t.Fatalf("apply = %d %+v", code, applied)
+	}
+	songs, err := loadSavedSongs(applied.ID)
+	if err != nil || len(songs) != 2 || songs[0].ID != localID || songs[0].Source != localMusicSource || songs[1].ID != "7" || songs[1].Album != "七里香" {
+		t.Fatalf("saved songs = %+v %v", songs, err)
+	}
+
+	// 审核后把低置信度的一条加进同一个歌单。
+	items := []gin.H{{"index": 2, "candidate": 0}, {"index": 3, "candidate": 0}}
+	if code := postLocalMusicTagsJSON(t, "/collections/import_file/apply", gin.H{"job_id": job.ID, "collection_id": applied.ID, "items": items}, &applied); code != http.StatusOK || applied.Added != 1 {
+		t.Fatalf("apply reviewed = %d %+v", code, applied)
+	}
+
+	m3u := "#EXTM3U\n" + filepath.Join(downloadDir, "by-path.mp3") + "\n"
+	if code, _ := uploadPlaylistFileForTest(t, "p.m3u", m3u, map[string]string{"online": "0"}); code != http.StatusOK {
+		t.Fatalf("m3u import = %d", code)
+	}
+	job = waitCollectionImportJobForTest(t)
+	if e := job.Entries[0]; e.Status != "matched" || e.Candidates[0].ID != encodeLocalMusicID("by-path.mp3") || job.Online {
+		t.Fatalf("path entry = %+v, online = %v", e, job.Online)
+	}
+}
@@ -0,0 +1,446 @@
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/guohuiyuan/go-music-dl/core"
+	"github.com/guohuiyuan/music-lib/model"
+	"gorm.io/gorm"
+)
+
+// 智能歌单：不保存歌曲明细，只保存一组规则，每次查看时对本地曲库索引
+// （LocalMusicIndex）和下载记录（download_records）求值。规则可以按全部满足
+// 或任一满足组合，另外带排序方式和数量上限。
+
+const (
+	smartCollectionDefaultLimit = 500
+	smartCollectionMaxLimit     = 5000
+	smartCollectionMaxRules     = 20
+	smartCollectionPreviewSize  = 50
+
+	smartCollectionReadonlyError = "智能歌单的歌曲由规则生成，不能手动增删歌曲"
+)
+
+// smartCollectionBitrateExpr 和 estimateLocalMusicBitrate 一样按文件大小和时长估算 kbps。
+const smartCollectionBitrateExpr = "(local_music_index.size * 8 / local_music_index.duration / 1000)"
+
+type smartCollectionRule struct {
+	Field string `json:"field"`
+	Op    string `json:"op"`
+	// Values 用于文本字段；Value 是数值、天数或 between 的下限，Max 是 between 的上限。
+	Values []string `json:"values,omitempty"`
+	Value  float64  `json:"value,omitempty"`
+	Max    float64  `json:"max,omitempty"`
+}
+
+type smartCollectionRules struct {
+	// Match 为 all（全部满足）或 any（任一满足）。
+	Match string                `json:"match"`
+	Rules []smartCollectionRule `json:"rules"`
+	Sort  string                `json:"sort"`
+	Limit int                   `json:"limit"`
+}
+
+type smartCollectionRequest struct {
+	Name        string               `json:"name"`
+	Description string               `json:"description"`
+	Cover       string               `json:"cover"`
+	Rules       smartCollectionRules `json:"rules"`
+}
+
+const (
+	smartFieldText   = "text"
+	smartFieldNumber = "number"
+	smartFieldDate   = "date"
+	smartFieldBool   = "bool"
+)
+
+var smartCollectionFields = map[string]struct {
+	kind string
+	expr string
+}{
+	"name":         {smartFieldText, "local_music_index.name"},
+	"artist":       {smartFieldText, "local_music_index.artist"},
+	"album":        {smartFieldText, "local_music_index.album"},
+	"album_artist": {smartFieldText, localMusicAlbumArtistExpr},
+	"format":       {smartFieldText, "local_music_index.ext"},
+	"bitrate":      {smartFieldNumber, smartCollectionBitrateExpr},
+	"duration":     {smartFieldNumber, "local_music_index.duration"},
+	"added":        {smartFieldDate, "local_music_index.added_at"},
+	"modified":     {smartFieldDate, "local_music_index.mod_time"},
+	"downloaded":   {smartFieldDate, ""},
+	"has_lyric":    {smartFieldBool, "local_music_index.has_lyric"},
+	"has_cover":    {smartFieldBool, "local_music_index.has_cover"},
+}
+
+var smartCollectionOps = map[string][]string{
+	smartFieldText:   {"in", "not_in", "contains"},
+	smartFieldNumber: {"lt", "gt", "between"},
+	smartFieldDate:   {"within_days", "older_than_days"},
+	smartFieldBool:   {"yes", "no"},
+}
+
+var smartCollectionSorts = map[string]string{
+	"added_desc":    "local_music_index.added_at DESC",
+	"added_asc":     "local_music_index.added_at ASC",
+	"modified_desc": "local_music_index.mod_time DESC",
+	"name":          "local_music_index.name COLLATE NOCASE",
+	"artist":        "local_music_index.artist COLLATE NOCASE, local_music_index.album COLLATE NOCASE",
+	"album":         "local_music_index.album COLLATE NOCASE, local_music_index.rel_path",
+	"duration_desc": "local_music_index.duration DESC",
+	"duration_asc":  "local_music_index.duration ASC",
+	"bitrate_desc":  "local_music_index.size * 8 / MAX(local_music_index.duration, 1) DESC",
+	"bitrate_asc":   "local_music_index.size * 8 / MAX(local_music_index.duration, 1) ASC",
+	"random":        "RANDOM()",
+}
+
+func (c Collection) isSmart() bool {
+	return c.normalizedKind() == collectionKindSmart
+}
+
+func (c Collection) smartRules() (smartCollectionRules, error) {
+	var rules smartCollectionRules
+	if strings.TrimSpace(c.Rules) != "" {
+		if err := json.Unmarshal([]byte(c.Rules), &rules); err != nil {
+			return rules, fmt.Errorf("智能歌单规则损坏: %w", err)
+		}
+	}
+	return normalizeSmartCollectionRules(rules)
+}
+
+// normalizeSmartCollectionRules validates rules coming from the UI or the
+// database and fills in the match mode, sort and limit defaults.
+func normalizeSmartCollectionRules(rules smartCollectionRules) (smartCollectionRules, error) {
+	rules.Match = strings.ToLower(strings.TrimSpace(rules.Match))
+	if rules.Match == "" {
+		rules.Match = "all"
+	}
+	if rules.Match != "all" && rules.Match != "any" {
+		return rules, fmt.Errorf("未知的匹配方式: %s", rules.Match)
+	}
+	if len(rules.Rules) > smartCollectionMaxRules {
+		return rules, fmt.Errorf("规则最多 %d 条", smartCollectionMaxRules)
+	}
+	rules.Sort = strings.TrimSpace(rules.Sort)
+	if rules.Sort == "" {
+		rules.Sort = "added_desc"
+	}
+	if _, ok := smartCollectionSorts[rules.Sort]; !ok {
+		return rules, fmt.Errorf("未知的排序方式: %s", rules.Sort)
+	}
+	if rules.Limit <= 0 {
+		rules.Limit = smartCollectionDefaultLimit
+	}
+	if rules.Limit > smartCollectionMaxLimit {
+		rules.Limit = smartCollectionMaxLimit
+	}
+
+	normalized := make([]smartCollectionRule, 0, len(rules.Rules))
+	for i, rule := range rules.Rules {
+		rule.Field = strings.TrimSpace(rule.Field)
+		rule.Op = strings.TrimSpace(rule.Op)
+		field, ok := smartCollectionFields[rule.Field]
+		if !ok {
+			return rules, fmt.Errorf("第 %d 条规则的字段未知: %s", i+1, rule.Field)
+		}
+		if !containsString(smartCollectionOps[field.kind], rule.Op) {
+			return rules, fmt.Errorf("第 %d 条规则的条件 %s 不适用于 %s", i+1, rule.Op, rule.Field)
+		}
+		switch field.kind {
+		case smartFieldText:
+			values := make([]string, 0, len(rule.Values))
+			for _, value := range rule.Values {
+				value = strings.TrimSpace(value)
+				if rule.Field == "format" {
+					value = strings.TrimPrefix(strings.ToLower(value), ".")
+				}
+				if value != "" {
+					values = append(values, value)
+				}
+			}
+			if len(values) == 0 {
+				return rules, fmt.Errorf("第 %d 条规则缺少取值", i+1)
+			}
+			rule.Values, rule.Value, rule.Max = values, 0, 0
+		case smartFieldNumber:
+			if rule.Value < 0 || (rule.Op == "between" && rule.Max < rule.Value) {
+				return rules, fmt.Errorf("第 %d 条规则的数值范围无效", i+1)
+			}
+			rule.Values = nil
+			if rule.Op != "between" {
+				rule.Max = 0
+			}
+		case smartFieldDate:
+			if rule.Value <= 0 {
+				return rules, fmt.Errorf("第 %d 条规则的天数需大于 0", i+1)
+			}
+			rule.Values, rule.Max = nil, 0
+		case smartFieldBool:
+			rule.Values, rule.Value, rule.Max = nil, 0, 0
+		}
+		normalized = append(normalized, rule)
+	}
+	rules.Rules = normalized
+	return rules, nil
+}
+
+// smartCollectionRuleCondition turns one rule into a SQL condition on
+// local_music_index.
+func smartCollectionRuleCondition(rule smartCollectionRule, now time.Time, hasDownloads bool) (string, []interface{}) {
+	field := smartCollectionFields[rule.Field]
+	switch field.kind {
+	case smartFieldText:
+		switch rule.Op {
+		case "contains":
+			parts := make([]string, 0, len(rule.Values))
+			args := make([]interface{}, 0, len(rule.Values))
+			for _, value := range rule.Values {
+				parts = append(parts, "LOWER("+field.expr+") LIKE ? ESCAPE '\\'")
+				args = append(args, "%"+escapeSmartCollectionLike(strings.ToLower(value))+"%")
+			}
+			return "(" + strings.Join(parts, " OR ") + ")", args
+		case "not_in":
+			return "LOWER(" + field.expr + ") NOT IN ?", []interface{}{lowerSmartCollectionValues(rule.Values)}
+		default:
+			return "LOWER(" + field.expr + ") IN ?", []interface{}{lowerSmartCollectionValues(rule.Values)}
+		}
+	case smartFieldNumber:
+		guard := ""
+		if rule.Field == "bitrate" {
+			// 时长未知时估不出码率，不参与比较。
+			guard = "local_music_index.duration > 0 AND "
+		}
+		switch rule.Op {
+		case "lt":
+			return "(" + guard + field.expr + " < ?)", []interface{}{rule.Value}
+		case "gt":
+			return "(" + guard + field.expr + " > ?)", []interface{}{rule.Value}
+		default:
+			return "(" + guard + field.expr + " BETWEEN ? AND ?)", []interface{}{rule.Value, rule.Max}
+		}
+	case smartFieldDate:
+		cutoff := now.Add(-time.Duration(rule.Value * float64(24*time.Hour)))
+		if rule.Field == "downloaded" {
+			if !hasDownloads {
+				if rule.Op == "within_days" {
+					return "1 = 0", nil
+				}
+				return "1 = 1", nil
+			}
+			exists := "EXISTS (SELECT 1 FROM download_records WHERE download_records.status = ? AND download_records.created_at >= ?" +
+				" AND LOWER(download_records.name) = LOWER(local_music_index.name)" +
+				" AND LOWER(download_records.artist) = LOWER(local_music_index.artist))"
+			if rule.Op == "older_than_days" {
+				exists = "NOT " + exists
+			}
+			return exists, []interface{}{core.DownloadStatusSuccess, cutoff}
+		}
+		if rule.Op == "within_days" {
+			return field.expr + " >= ?", []interface{}{cutoff}
+		}
+		return field.expr + " < ?", []interface{}{cutoff}
+	default:
+		return field.expr + " = ?", []interface{}{rule.Op == "yes"}
+	}
+}
+
+func escapeSmartCollectionLike(value string) string {
+	return strings.NewReplacer("\\", "\\\\", "%", "\\%", "_", "\\_").Replace(value)
+}
+
+func lowerSmartCollectionValues(values []string) []string {
+	out := make([]string, len(values))
+	for i, value := range values {
+		out[i] = strings.ToLower(value)
+	}
+	return out
+}
+
+// smartCollectionQuery builds the index query and order clause for rules
+// that already went through normalizeSmartCollectionRules.
+func smartCollectionQuery(rules smartCollectionRules, now time.Time) (*gorm.DB, string) {
+	query := db.Model(&LocalMusicIndex{})
+	if len(rules.Rules) > 0 {
+		hasDownloads := db.Migrator().HasTable(&core.DownloadRecord{})
+		parts := make([]string, 0, len(rules.Rules))
+		args := make([]interface{}, 0, len(rules.Rules))
+		for _, rule := range rules.Rules {
+			cond, condArgs := smartCollectionRuleCondition(rule, now, hasDownloads)
+			parts = append(parts, cond)
+			args = append(args, condArgs...)
+		}
+		joiner := " AND "
+		if rules.Match == "any" {
+			joiner = " OR "
+		}
+		query = query.Where("("+strings.Join(parts, joiner)+")", args...)
+	}
+	return query, smartCollectionSorts[rules.Sort] + ", local_music_index.rel_path"
+}
+
+// evaluateSmartCollection returns up to rules.Limit matching tracks and the
+// number of matches before the limit.
+func evaluateSmartCollection(rules smartCollectionRules, limit int) ([]*localMusicTrack, int, error) {
+	if db == nil {
+		return []*localMusicTrack{}, 0, nil
+	}
+	if limit <= 0 || limit > rules.Limit {
+		limit = rules.Limit
+	}
+	query, order := smartCollectionQuery(rules, time.Now())
+	tracks, total, err := localMusicIndexTrackPage(query, order, 0, limit)
+	if err != nil {
+		return nil, 0, err
+	}
+	if total > rules.Limit {
+		total = rules.Limit
+	}
+	return tracks, total, nil
+}
+
+func loadSmartCollectionSongs(collection *Collection) ([]model.Song, error) {
+	rules, err := collection.smartRules()
+	if err != nil {
+		return nil, err
+	}
+	tracks, _, err := evaluateSmartCollection(rules, 0)
+	if err != nil {
+		return nil, err
+	}
+	return localMusicTracksToSongs(tracks), nil
+}
+
+func countSmartCollectionSongs(collection Collection) int {
+	rules, err := collection.smartRules()
+	if err != nil || db == nil {
+		return 0
+	}
+	query, _ := smartCollectionQuery(rules, time.Now())
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return 0
+	}
+	if int(total) > rules.Limit {
+		return rules.Limit
+	}
+	return int(total)
+}
+
+func bindSmartCollectionRequest(c *gin.Context) (*smartCollectionRequest, string, error) {
+	var req smartCollectionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		return nil, "", errors.New("参数错误")
+	}
+	rules, err := normalizeSmartCollectionRules(req.Rules)
+	if err != nil {
+		return nil, "", err
+	}
+	req.Rules = rules
+	encoded, err := json.Marshal(rules)
+	if err != nil {
+		return nil, "", err
+	}
+	return &req, string(encoded), nil
+}
+
+func registerCollectionSmartRoutes(colAPI *gin.RouterGroup) {
+	colAPI.POST("/smart/preview", requireSameOriginWrite, func(c *gin.Context) {
+		req, _, err := bindSmartCollectionRequest(c)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		tracks, total, err := evaluateSmartCollection(req.Rules, smartCollectionPreviewSize)
+		if err != nil {
+			c.JSON(500, gin.H{"error": "规则求值失败: " + err.Error()})
+			return
+		}
+		c.JSON(200, gin.H{"total": total, "songs": localMusicTracksToSongs(tracks)})
+	})
+
+	colAPI.POST("/smart", requireSameOriginWrite, func(c *gin.Context) {
+		req, encoded, err := bindSmartCollectionRequest(c)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		if strings.TrimSpace(req.Name) == "" {
+			c.JSON(400, gin.H{"error": "参数错误，必须提供歌单名"})
+			return
+		}
+		collection := Collection{
+			Name:        strings.TrimSpace(req.Name),
+			Description: strings.TrimSpace(req.Description),
+			Cover:       strings.TrimSpace(req.Cover),
+			Kind:        collectionKindSmart,
+			ContentType: collectionContentPlaylist,
+			Source:      "local",
+			Rules:       encoded,
+		}
+		if err := db.Create(&collection).Error; err != nil {
+			c.JSON(500, gin.H{"error": "创建失败: " + err.Error()})
+			return
+		}
+		c.JSON(200, gin.H{"id": collection.ID, "name": collection.Name})
+	})
+
+	colAPI.GET("/:id/rules", func(c *gin.Context) {
+		collection, err := loadCollection(c.Param("id"))
+		if err != nil {
+			c.JSON(404, gin.H{"error": "歌单不存在"})
+			return
+		}
+		if !collection.isSmart() {
+			c.JSON(400, gin.H{"error": "只有智能歌单有规则"})
+			return
+		}
+		rules, err := collection.smartRules()
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(200, gin.H{
+			"id":          collection.ID,
+			"name":        collection.Name,
+			"description": collection.Description,
+			"cover":       collection.Cover,
+			"rules":       rules,
+		})
+	})
+
+	colAPI.PUT("/:id/rules", requireSameOriginWrite, func(c *gin.Context) {
+		collection, err := loadCollection(c.Param("id"))
+		if err != nil {
+			c.JSON(404, gin.H{"error": "歌单不存在"})
+			return
+		}
+		if !collection.isSmart() {
+			c.JSON(400, gin.H{"error": "只有智能歌单有规则"})
+			return
+		}
+		req, encoded, err := bindSmartCollectionRequest(c)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		name := strings.TrimSpace(req.Name)
+		if name == "" {
+			name = collection.Name
+		}
+		if err := db.Model(&Collection{}).Where("id = ?", collection.ID).Updates(map[string]interface{}{
+			"name":        name,
+			"description": strings.TrimSpace(req.Description),
+			"cover":       strings.TrimSpace(req.Cover),
+			"rules":       encoded,
+		}).Error; err != nil {
+			c.JSON(500, gin.H{"error": "更新失败"})
+			return
+		}
+		c.JSON(200, gin.H{"status": "ok"})
+	})
+}
@@ -0,0 +1,156 @@
+package web
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/guohuiyuan/go-music-dl/core"
+)
+
+func seedSmartCollectionLibrary(t *testing.T) {
+	t.Helper()
+	initCollectionDBForTest(t)
+	downloadDir := t.TempDir()
+	withLocalMusicDownloadDir(t, downloadDir)
+
+	now := time.Now()
+	for _, track := range []*localMusicTrack{
+		{RelPath: "a.flac", Name: "Song A", Artist: "周杰伦", Ext: "flac", Size: 30_000_000, Duration: 200, Extra: map[string]string{"lyric": "true"}, modTime: now.AddDate(0, 0, -10)},
+		{RelPath: "b.mp3", Name: "Song B", Artist: "周杰伦", Ext: "mp3", Size: 3_200_000, Duration: 200, modTime: now.AddDate(0, 0, -400)},
+		{RelPath: "c.mp3", Name: "Song C", Artist: "Beta", Ext: "mp3", Size: 8_000_000, Duration: 250, modTime: now.AddDate(0, 0, -400)},
+		{RelPath: "d.mp3", Name: "Song D", Artist: "Beta", Ext: "mp3", Size: 1_000, modTime: now.AddDate(0, 0, -2)},
+	} {
+		track.ID = encodeLocalMusicID(track.RelPath)
+		writeLocalMusicFileForTest(t, filepath.Join(downloadDir, track.RelPath), "audio")
+		upsertLocalMusicIndexRow(track)
+	}
+
+	if err := db.AutoMigrate(&core.DownloadRecord{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Create(&core.DownloadRecord{Name: "song c", Artist: "Beta", Source: "qq", Status: core.DownloadStatusSuccess, CreatedAt: now.AddDate(0, 0, -5)}).Error; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func smartCollectionNamesForTest(t *testing.T, rules smartCollectionRules) string {
+	t.Helper()
+	normalized, err := normalizeSmartCollectionRules(rules)
+	if err != nil {
+		t.Fatalf("normalize %+v: %v", rules, err)
+	}
+	tracks, _, err := evaluateSmartCollection(normalized, 0)
+	if err != nil {
+		t.Fatalf("evaluate %+v: %v", rules, err)
+	}
+	names := make([]string, 0, len(tracks))
+	for _, track := range tracks {
+		names = append(names, track.Name)
+	}
+	return strings.Join(names, ",")
+}
+
+func TestSmartCollectionRules(t *testing.T) {
+	seedSmartCollectionLibrary(t)
+
+	for _, tc := range []struct {
+		name  string
+		rules smartCollectionRules
+		want  string
+	}{
+		{"artist and format", smartCollectionRules{Rules: []smartCollectionRule{
+			{Field: "artist", Op: "in", Values: []string{"周杰伦"}},
+			{Field: "format", Op: "in", Values: []string{".FLAC"}},
+		}}, "Song A"},
+		{"low bitrate skips unknown duration", smartCollectionRules{Sort: "name", Rules: []smartCollectionRule{
+			{Field: "bitrate", Op: "lt", Value: 192},
+		}}, "Song B"},
+		{"missing lyrics", smartCollectionRules{Sort: "name", Rules: []smartCollectionRule{
+			{Field: "has_lyric", Op: "no"},
+		}}, "Song B,Song C,Song D"},
+		{"added recently", smartCollectionRules{Rules: []smartCollectionRule{
+			{Field: "added", Op: "within_days", Value: 30},
+		}}, "Song D,Song A"},
+		{"stale and not downloaded", smartCollectionRules{Sort: "name", Rules: []smartCollectionRule{
+			{Field: "downloaded", Op: "older_than_days", Value: 180},
+			{Field: "modified", Op: "older_than_days", Value: 180},
+		}}, "Song B"},
+		{"any with limit", smartCollectionRules{Match: "any", Sort: "duration_desc", Limit: 2, Rules: []smartCollectionRule{
+			{Field: "duration", Op: "between", Value: 100, Max: 300},
+			{Field: "artist", Op: "contains", Values: []string{"bet"}},
+		}}, "Song C,Song A"},
+	} {
+		if got := smartCollectionNamesForTest(t, tc.rules); got != tc.want {
+			t.Errorf("%s = %q, want %q", tc.name, got, tc.want)
+		}
+	}
+
+	for _, bad := range []smartCollectionRules{
+		{Rules: []smartCollectionRule{{Field: "bitrate", Op: "in", Values: []string{"1"}}}},
+		{Rules: []smartCollectionRule{{Field: "artist", Op: "in"}}},
+		{Rules: []smartCollectionRule{{Field: "added", Op: "within_days"}}},
+		{Sort: "bogus"},
+	} {
+		if _, err := normalizeSmartCollectionRules(bad); err == nil {
+			t.Errorf("rules %+v should be rejected", bad)
+		}
+	}
+}
+
+func TestSmartCollectionRoutes(t *testing.T) {
+	seedSmartCollectionLibrary(t)
+
+	rules := gin.H{"sort": "name", "rules": []gin.H{{"field": "artist", "op": "in", "values": []string{"周杰伦"}}}}
+	var preview struct {
+		Total int `json:"total"`
+	}
+	if code := postLocalMusicTagsJSON(t, "/collections/smart/preview", gin.H{"rules": rules}, &preview); code != http.StatusOK || preview.Total != 2 {
+		t.Fatalf("preview = %d %+v", code, preview)
+	}
+	var created struct {
+		ID uint `json:"id"`
+	}
+	if code := postLocalMusicTagsJSON(t, "/collections/smart", gin.H{"name": "Jay", "rules": rules}, &created); code != http.StatusOK || created.ID == 0 {
+		t.Fatalf("create = %d %+v", code, created)
+	}
+	if code := postLocalMusicTagsJSON(t, "/collections/smart", gin.H{"name": "Bad", "rules": gin.H{"rules": []gin.H{{"field": "nope", "op": "in"}}}}, nil); code != http.StatusBadRequest {
+		t.Fatalf("invalid rules = %d", code)
+	}
+
+	collection, err := loadCollection(jsonNumberForTest(created.ID))
+	if err != nil || !collection.isSmart() || collection.editable() || collection.playlistCard().TrackCount != 2 {
+		t.Fatalf("collection = %+v %v", collection, err)
+	}
+	songs, err := collectionSongsJSON(collection)
+	if err != nil || len(songs) != 2 || songs[0]["name"] != "Song A" || songs[0]["source"] != localMusicSource {
+		t.Fatalf("songs = %+v %v", songs, err)
+	}
+
+	collectionPath := "/collections/" + jsonNumberForTest(created.ID)
+	if code := postLocalMusicTagsJSON(t, collectionPath+"/songs", gin.H{"id": "x", "source": "qq"}, nil); code != http.StatusBadRequest {
+		t.Fatalf("manual add to smart collection = %d", code)
+	}
+
+	// 改成按低码率筛选，歌单内容跟着变。
+	body := gin.H{"name": "Low bitrate", "rules": gin.H{"rules": []gin.H{{"field": "bitrate", "op": "lt", "value": 192}}}}
+	payload, _ := json.Marshal(body)
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPut, RoutePrefix+collectionPath+"/rules", bytes.NewReader(payload))
+	req.Header.Set("X-Requested-With", "XMLHttpRequest")
+	newLocalMusicTestRouter().ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("update rules = %d %s", rec.Code, rec.Body.String())
+	}
+	collection, _ = loadCollection(jsonNumberForTest(created.ID))
+	loaded, err := loadCollectionSongs(collection)
+	if err != nil || collection.Name != "Low bitrate" || len(loaded) != 1 || loaded[0].Name != "Song B" {
+		t.Fatalf("songs after update = %+v %v", loaded, err)
+	}
+}
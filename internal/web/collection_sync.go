@@ -0,0 +1,397 @@
+package web
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/guohuiyuan/go-music-dl/core"
+	"github.com/guohuiyuan/music-lib/model"
+	"gorm.io/gorm"
+)
+
+// 导入歌单同步：导入的歌单 / 专辑每次查看都从上游重新拉取，这里把曲目列表
+// 定期存成快照，和上一次比较出新增、移除的曲目。上游拉取失败时保留最后一次
+// 成功的快照，查看歌单也会退回到这份快照；可选把新增曲目下载进本地曲库。
+
+const (
+	collectionSyncMaxSnapshots = 30
+	collectionSyncMaxHours     = 24 * 30
+)
+
+var (
+	// collectionSyncCheckInterval 是后台检查哪些歌单到期要同步的间隔。
+	collectionSyncCheckInterval = 10 * time.Minute
+	// collectionSyncNotify is swapped in tests, webhooks live in the
+	// process-wide settings database.
+	collectionSyncNotify = core.Notify
+
+	collectionSyncMu sync.Mutex
+
+	collectionSyncLoopMu   sync.Mutex
+	collectionSyncLoopStop chan struct{}
+	collectionSyncLoopDone chan struct{}
+)
+
+// CollectionSnapshot is the upstream track list of an imported collection at
+// one sync. A snapshot is only written when the list changed; the first one
+// is the baseline and has no diff.
+type CollectionSnapshot struct {
+	ID             uint      `gorm:"primaryKey" json:"id"`
+	CollectionID   uint      `gorm:"index" json:"collection_id"`
+	Baseline       bool      `json:"baseline"`
+	TrackCount     int       `json:"track_count"`
+	AddedCount     int       `json:"added_count"`
+	RemovedCount   int       `json:"removed_count"`
+	Downloaded     int       `json:"downloaded"`
+	DownloadFailed int       `json:"download_failed"`
+	Songs          string    `json:"-"`
+	Added          string    `json:"-"`
+	Removed        string    `json:"-"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+type collectionSyncResult struct {
+	Changed  bool                `json:"changed"`
+	Snapshot *CollectionSnapshot `json:"snapshot,omitempty"`
+	Added    []model.Song        `json:"added"`
+	Removed  []model.Song        `json:"removed"`
+}
+
+func registerCollectionSyncRoutes(colAPI *gin.RouterGroup) {
+	colAPI.POST("/:id/sync", requireSameOriginWrite, func(c *gin.Context) {
+		collection, err := loadCollection(c.Param("id"))
+		if err != nil {
+			c.JSON(404, gin.H{"error": "歌单不存在"})
+			return
+		}
+		if !collection.isImported() {
+			c.JSON(400, gin.H{"error": "只有导入的歌单可以同步"})
+			return
+		}
+		result, err := syncImportedCollection(c.Request.Context(), collection)
+		if err != nil {
+			c.JSON(502, gin.H{"error": "同步失败，已保留上次的快照: " + err.Error()})
+			return
+		}
+		c.JSON(200, result)
+	})
+
+	colAPI.PUT("/:id/sync", requireSameOriginWrite, func(c *gin.Context) {
+		var req struct {
+			IntervalHours *int  `json:"interval_hours"`
+			AutoDownload  *bool `json:"auto_download"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": "参数错误"})
+			return
+		}
+		collection, err := loadCollection(c.Param("id"))
+		if err != nil {
+			c.JSON(404, gin.H{"error": "歌单不存在"})
+			return
+		}
+		if !collection.isImported() {
+			c.JSON(400, gin.H{"error": "只有导入的歌单可以同步"})
+			return
+		}
+		updates := map[string]interface{}{}
+		if req.IntervalHours != nil {
+			if *req.IntervalHours < 0 || *req.IntervalHours > collectionSyncMaxHours {
+				c.JSON(400, gin.H{"error": fmt.Sprintf("同步间隔需在 0-%d 小时之间", collectionSyncMaxHours)})
+				return
+			}
+			updates["sync_interval_hours"] = *req.IntervalHours
+		}
+		if req.AutoDownload != nil {
+			updates["sync_auto_download"] = *req.AutoDownload
+		}
+		if len(updates) > 0 {
+			if err := db.Model(&Collection{}).Where("id = ?", collection.ID).Updates(updates).Error; err != nil {
+				c.JSON(500, gin.H{"error": "保存失败"})
+				return
+			}
+		}
+		collection, _ = loadCollection(c.Param("id"))
+		c.JSON(200, gin.H{"status": "ok", "collection": collection})
+	})
+
+	colAPI.GET("/:id/snapshots", func(c *gin.Context) {
+		collection, err := loadCollection(c.Param("id"))
+		if err != nil {
+			c.JSON(404, gin.H{"error": "歌单不存在"})
+			return
+		}
+		var snapshots []CollectionSnapshot
+		if err := db.Omit("songs", "added", "removed").Where("collection_id = ?", collection.ID).
+			Order("id DESC").Find(&snapshots).Error; err != nil {
+			c.JSON(500, gin.H{"error": "获取快照失败"})
+			return
+		}
+		c.JSON(200, gin.H{"collection": collection, "snapshots": snapshots})
+	})
+
+	colAPI.GET("/:id/snapshots/:sid", func(c *gin.Context) {
+		var snapshot CollectionSnapshot
+		if err := db.Where("collection_id = ?", c.Param("id")).First(&snapshot, c.Param("sid")).Error; err != nil {
+			c.JSON(404, gin.H{"error": "快照不存在"})
+			return
+		}
+		c.JSON(200, gin.H{
+			"snapshot": snapshot,
+			"songs":    decodeCollectionSnapshotSongs(snapshot.Songs),
+			"added":    decodeCollectionSnapshotSongs(snapshot.Added),
+			"removed":  decodeCollectionSnapshotSongs(snapshot.Removed),
+		})
+	})
+}
+
+func collectionSnapshotSongKey(song model.Song) string {
+	return strings.TrimSpace(song.Source) + ":" + strings.TrimSpace(song.ID)
+}
+
+func encodeCollectionSnapshotSongs(songs []model.Song) string {
+	if len(songs) == 0 {
+		return "[]"
+	}
+	raw, err := json.Marshal(songs)
+	if err != nil {
+		return "[]"
+	}
+	return string(raw)
+}
+
+func decodeCollectionSnapshotSongs(raw string) []model.Song {
+	songs := []model.Song{}
+	if strings.TrimSpace(raw) != "" {
+		_ = json.Unmarshal([]byte(raw), &songs)
+	}
+	return songs
+}
+
+func latestCollectionSnapshot(collectionID uint) (*CollectionSnapshot, error) {
+	var snapshot CollectionSnapshot
+	if err := db.Where("collection_id = ?", collectionID).Order("id DESC").First(&snapshot).Error; err != nil {
+		return nil, err
+	}
+	return &snapshot, nil
+}
+
+// loadCollectionSnapshotSongs is the fallback when the upstream of an
+// imported collection cannot be fetched.
+func loadCollectionSnapshotSongs(collectionID uint) ([]model.Song, bool) {
+	snapshot, err := latestCollectionSnapshot(collectionID)
+	if err != nil {
+		return nil, false
+	}
+	return decodeCollectionSnapshotSongs(snapshot.Songs), true
+}
+
+// diffCollectionSnapshotSongs returns the songs in current but not in
+// previous, and the other way round, keeping each list's order.
+func diffCollectionSnapshotSongs(previous, current []model.Song) ([]model.Song, []model.Song) {
+	before := make(map[string]struct{}, len(previous))
+	for _, song := range previous {
+		before[collectionSnapshotSongKey(song)] = struct{}{}
+	}
+	after := make(map[string]struct{}, len(current))
+	added := make([]model.Song, 0)
+	for _, song := range current {
+		key := collectionSnapshotSongKey(song)
+		after[key] = struct{}{}
+		if _, ok := before[key]; !ok {
+			added = append(added, song)
+		}
+	}
+	removed := make([]model.Song, 0)
+	for _, song := range previous {
+		if _, ok := after[collectionSnapshotSongKey(song)]; !ok {
+			removed = append(removed, song)
+		}
+	}
+	return added, removed
+}
+
+// syncImportedCollection fetches the upstream track list and stores a new
+// snapshot when it changed. A failed fetch only records the error.
+func syncImportedCollection(ctx context.Context, collection *Collection) (*collectionSyncResult, error) {
+	collectionSyncMu.Lock()
+	defer collectionSyncMu.Unlock()
+
+	now := time.Now()
+	songs, err := loadImportedCollectionSongs(collection)
+	if err == nil && len(songs) == 0 {
+		err = errors.New("上游返回了空列表")
+	}
+	if err != nil {
+		db.Model(collection).Updates(map[string]interface{}{"last_synced_at": now, "last_sync_error": err.Error()})
+		core.LoggerFromContext(ctx).Warn("collection sync failed", "collection", collection.ID, "error", err)
+		return nil, err
+	}
+
+	result := &collectionSyncResult{Added: []model.Song{}, Removed: []model.Song{}}
+	previous, prevErr := latestCollectionSnapshot(collection.ID)
+	snapshot := &CollectionSnapshot{
+		CollectionID: collection.ID,
+		TrackCount:   len(songs),
+		Songs:        encodeCollectionSnapshotSongs(songs),
+	}
+	switch {
+	case errors.Is(prevErr, gorm.ErrRecordNotFound):
+		snapshot.Baseline = true
+		result.Changed = true
+	case prevErr != nil:
+		return nil, prevErr
+	default:
+		result.Added, result.Removed = diffCollectionSnapshotSongs(decodeCollectionSnapshotSongs(previous.Songs), songs)
+		result.Changed = len(result.Added) > 0 || len(result.Removed) > 0
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if result.Changed {
+			snapshot.AddedCount, snapshot.RemovedCount = len(result.Added), len(result.Removed)
+			snapshot.Added = encodeCollectionSnapshotSongs(result.Added)
+			snapshot.Removed = encodeCollectionSnapshotSongs(result.Removed)
+			if err := tx.Create(snapshot).Error; err != nil {
+				return err
+			}
+			if err := pruneCollectionSnapshots(tx, collection.ID); err != nil {
+				return err
+			}
+		}
+		return tx.Model(collection).Updates(map[string]interface{}{
+			"track_count":     len(songs),
+			"last_synced_at":  now,
+			"last_sync_error": "",
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !result.Changed {
+		return result, nil
+	}
+	result.Snapshot = snapshot
+
+	if !snapshot.Baseline {
+		collectionSyncNotify(ctx, core.NotifyEvent{
+			Type:    core.EventCollectionChanged,
+			Title:   "导入歌单有更新",
+			Message: fmt.Sprintf("%s: 新增 %d 首，移除 %d 首", collection.Name, len(result.Added), len(result.Removed)),
+			Data: gin.H{
+				"collection_id": collection.ID,
+				"name":          collection.Name,
+				"added":         len(result.Added),
+				"removed":       len(result.Removed),
+			},
+		})
+		if collection.SyncAutoDownload && len(result.Added) > 0 {
+			go downloadCollectionSyncSongs(context.WithoutCancel(ctx), snapshot.ID, result.Added)
+		}
+	}
+	return result, nil
+}
+
+// pruneCollectionSnapshots keeps the newest snapshots of a collection. The
+// baseline goes too once it is old enough, later snapshots carry full lists.
+func pruneCollectionSnapshots(tx *gorm.DB, collectionID uint) error {
+	var ids []uint
+	if err := tx.Model(&CollectionSnapshot{}).Where("collection_id = ?", collectionID).
+		Order("id DESC").Offset(collectionSyncMaxSnapshots).Pluck("id", &ids).Error; err != nil {
+		return err
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	return tx.Delete(&CollectionSnapshot{}, ids).Error
+}
+
+// downloadCollectionSyncSongs saves newly added upstream tracks into the
+// library with the same pipeline as auto cache, skipping songs the library
+// already has.
+func downloadCollectionSyncSongs(ctx context.Context, snapshotID uint, songs []model.Song) {
+	settings := autoCacheSettingsProvider()
+	downloaded, failed := 0, 0
+	for i := range songs {
+		song := songs[i]
+		if match, _, _ := findLocalMusicMatch(song.Name, song.Artist); match != nil {
+			continue
+		}
+		result, err := autoCacheSaveSong(&song, settings.DownloadDir, true, true, settings.DownloadFilenameTemplate)
+		if err != nil || result == nil {
+			failed++
+			core.LoggerFromContext(ctx).Warn("collection sync download failed", "source", song.Source, "song", song.Name, "error", err)
+			continue
+		}
+		downloaded++
+		autoCacheIndexSavedSong(result, settings.DownloadDir)
+	}
+	db.Model(&CollectionSnapshot{ID: snapshotID}).Updates(map[string]interface{}{"downloaded": downloaded, "download_failed": failed})
+}
+
+// dueCollectionSyncs lists imported collections whose sync interval passed.
+func dueCollectionSyncs(now time.Time) ([]Collection, error) {
+	var collections []Collection
+	if err := db.Where("kind = ? AND sync_interval_hours > 0", collectionKindImported).Find(&collections).Error; err != nil {
+		return nil, err
+	}
+	due := collections[:0]
+	for _, collection := range collections {
+		interval := time.Duration(collection.SyncIntervalHours) * time.Hour
+		if collection.LastSyncedAt == nil || !now.Before(collection.LastSyncedAt.Add(interval)) {
+			due = append(due, collection)
+		}
+	}
+	return due, nil
+}
+
+func runDueCollectionSyncs() {
+	collections, err := dueCollectionSyncs(time.Now())
+	if err != nil {
+		core.Logger().Warn("load collections to sync failed", "error", err)
+		return
+	}
+	for i := range collections {
+		_, _ = syncImportedCollection(context.Background(), &collections[i])
+	}
+}
+
+// startCollectionSyncLoop runs periodic syncs while the web server is up.
+func startCollectionSyncLoop() {
+	collectionSyncLoopMu.Lock()
+	defer collectionSyncLoopMu.Unlock()
+	if collectionSyncLoopStop != nil {
+		return
+	}
+	stop, done := make(chan struct{}), make(chan struct{})
+	collectionSyncLoopStop, collectionSyncLoopDone = stop, done
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(collectionSyncCheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				runDueCollectionSyncs()
+			}
+		}
+	}()
+}
+
+func stopCollectionSyncLoop() {
+	collectionSyncLoopMu.Lock()
+	defer collectionSyncLoopMu.Unlock()
+	if collectionSyncLoopStop == nil {
+		return
+	}
+	close(collectionSyncLoopStop)
+	<-collectionSyncLoopDone
+	collectionSyncLoopStop, collectionSyncLoopDone = nil, nil
+}
@@ -0,0 +1,177 @@
+package web
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/guohuiyuan/go-music-dl/core"
+	"github.com/guohuiyuan/music-lib/model"
+)
+
+func TestImportedCollectionSyncSnapshotsAndDiffs(t *testing.T) {
+	initCollectionDBForTest(t)
+	downloadDir := t.TempDir()
+	withLocalMusicDownloadDir(t, downloadDir)
+	withAutoCacheSettings(t, core.WebSettings{DownloadDir: downloadDir})
+
+	var upstreamMu sync.Mutex
+	upstream := []model.Song{
+		{ID: "1", Name: "Song One", Artist: "A"},
+		{ID: "2", Name: "Song Two", Artist: "B"},
+	}
+	var upstreamErr error
+	origPlaylistDetail := playlistDetailFuncProvider
+	playlistDetailFuncProvider = func(string) func(string) ([]model.Song, error) {
+		return func(string) ([]model.Song, error) {
+			upstreamMu.Lock()
+			defer upstreamMu.Unlock()
+			return append([]model.Song(nil), upstream...), upstreamErr
+		}
+	}
+	origParsePlaylist := parsePlaylistFuncProvider
+	parsePlaylistFuncProvider = func(string) func(string) (*model.Playlist, []model.Song, error) { return nil }
+	origNotify := collectionSyncNotify
+	notified := make(chan core.NotifyEvent, 4)
+	collectionSyncNotify = func(_ context.Context, event core.NotifyEvent) { notified <- event }
+	origSave, origIndex := autoCacheSaveSong, autoCacheIndexSavedSong
+	saved := make(chan string, 4)
+	autoCacheSaveSong = func(song *model.Song, _ string, _ bool, _ bool, _ string) (*core.DownloadedSong, error) {
+		saved <- song.ID
+		return &core.DownloadedSong{}, nil
+	}
+	autoCacheIndexSavedSong = func(*core.DownloadedSong, string) {}
+	t.Cleanup(func() {
+		playlistDetailFuncProvider = origPlaylistDetail
+		parsePlaylistFuncProvider = origParsePlaylist
+		collectionSyncNotify = origNotify
+		autoCacheSaveSong, autoCacheIndexSavedSong = origSave, origIndex
+	})
+
+	collection := Collection{Name: "Upstream", Kind: collectionKindImported, ContentType: collectionContentPlaylist, Source: "qq", ExternalID: "p1"}
+	if err := db.Create(&collection).Error; err != nil {
+		t.Fatal(err)
+	}
+	syncPath := "/collections/" + jsonNumberForTest(collection.ID) + "/sync"
+
+	var result collectionSyncResult
+	if code := postLocalMusicTagsJSON(t, syncPath, gin.H{}, &result); code != http.StatusOK || !result.Changed || result.Snapshot == nil || !result.Snapshot.Baseline || len(result.Added) != 0 {
+		t.Fatalf("baseline sync = %d %+v", code, result)
+	}
+	result = collectionSyncResult{}
+	if code := postLocalMusicTagsJSON(t, syncPath, gin.H{}, &result); code != http.StatusOK || result.Changed {
+		t.Fatalf("unchanged sync = %d %+v", code, result)
+	}
+
+	body, _ := json.Marshal(gin.H{"interval_hours": 24, "auto_download": true})
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPut, RoutePrefix+syncPath, bytes.NewReader(body))
+	req.Header.Set("X-Requested-With", "XMLHttpRequest")
+	newLocalMusicTestRouter().ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("sync settings = %d %s", rec.Code, rec.Body.String())
+	}
+
+	upstreamMu.Lock()
+	upstream = []model.Song{{ID: "2", Name: "Song Two", Artist: "B"}, {ID: "3", Name: "Song Three", Artist: "C"}}
+	upstreamMu.Unlock()
+	result = collectionSyncResult{}
+	if code := postLocalMusicTagsJSON(t, syncPath, gin.H{}, &result); code != http.StatusOK || !result.Changed || len(result.Added) != 1 || result.Added[0].ID != "3" || len(result.Removed) != 1 || result.Removed[0].ID != "1" {
+		t.Fatalf("changed sync = %d %+v", code, result)
+	}
+	if event := <-notified; event.Type != core.EventCollectionChanged || event.Data["added"] != 1 {
+		t.Fatalf("notify = %+v", event)
+	}
+	select {
+	case id := <-saved:
+		if id != "3" {
+			t.Fatalf("auto download song = %q, want 3", id)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("added song was not downloaded")
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		var snapshot CollectionSnapshot
+		db.First(&snapshot, result.Snapshot.ID)
+		if snapshot.Downloaded == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("snapshot download counters = %+v", snapshot)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// 上游失败：报错但保留快照，查看歌单时退回到最后一次的快照。
+	upstreamMu.Lock()
+	upstreamErr = errors.New("upstream down")
+	upstreamMu.Unlock()
+	var failed struct {
+		Error string `json:"error"`
+	}
+	if code := postLocalMusicTagsJSON(t, syncPath, gin.H{}, &failed); code != http.StatusBadGateway {
+		t.Fatalf("failed sync = %d %+v", code, failed)
+	}
+	reloaded, _ := loadCollection(jsonNumberForTest(collection.ID))
+	if reloaded.LastSyncError == "" || reloaded.TrackCount != 2 || reloaded.SyncIntervalHours != 24 || !reloaded.SyncAutoDownload {
+		t.Fatalf("collection after failed sync = %+v", reloaded)
+	}
+	songs, err := loadCollectionSongs(reloaded)
+	if err != nil || len(songs) != 2 || songs[1].ID != "3" {
+		t.Fatalf("fallback songs = %+v %v", songs, err)
+	}
+
+	var list struct {
+		Snapshots []CollectionSnapshot `json:"snapshots"`
+	}
+	rec = httptest.NewRecorder()
+	newLocalMusicTestRouter().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, RoutePrefix+"/collections/"+jsonNumberForTest(collection.ID)+"/snapshots", nil))
+	if err := json.Unmarshal(rec.Body.Bytes(), &list); err != nil {
+		t.Fatalf("snapshots = %d %s", rec.Code, rec.Body.String())
+	}
+	if len(list.Snapshots) != 2 || list.Snapshots[0].AddedCount != 1 || list.Snapshots[0].RemovedCount != 1 || !list.Snapshots[1].Baseline {
+		t.Fatalf("snapshots = %+v", list.Snapshots)
+	}
+}
+
+func TestDueCollectionSyncsHonoursInterval(t *testing.T) {
+	initCollectionDBForTest(t)
+	now := time.Now()
+	recent, old := now.Add(-time.Hour), now.Add(-7*time.Hour)
+	for _, collection := range []Collection{
+		{Name: "never", Kind: collectionKindImported, Source: "qq", ExternalID: "1", SyncIntervalHours: 6},
+		{Name: "recent", Kind: collectionKindImported, Source: "qq", ExternalID: "2", SyncIntervalHours: 6, LastSyncedAt: &recent},
+		{Name: "old", Kind: collectionKindImported, Source: "qq", ExternalID: "3", SyncIntervalHours: 6, LastSyncedAt: &old},
+		{Name: "off", Kind: collectionKindImported, Source: "qq", ExternalID: "4"},
+		{Name: "manual", Kind: collectionKindManual, Source: "local", SyncIntervalHours: 6},
+	} {
+		if err := db.Create(&collection).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	due, err := dueCollectionSyncs(now)
+	if err != nil || len(due) != 2 || due[0].Name != "never" || due[1].Name != "old" {
+		t.Fatalf("due = %+v %v", due, err)
+	}
+
+	origPlaylistDetail, origParsePlaylist := playlistDetailFuncProvider, parsePlaylistFuncProvider
+	playlistDetailFuncProvider = func(string) func(string) ([]model.Song, error) { return nil }
+	parsePlaylistFuncProvider = func(string) func(string) (*model.Playlist, []model.Song, error) { return nil }
+	t.Cleanup(func() {
+		playlistDetailFuncProvider, parsePlaylistFuncProvider = origPlaylistDetail, origParsePlaylist
+	})
+	if _, err := syncImportedCollection(context.Background(), &due[0]); err == nil {
+		t.Fatal("sync without upstream should fail")
+	}
+	if due, _ = dueCollectionSyncs(now.Add(time.Minute)); len(due) != 1 || due[0].Name != "old" {
+		t.Fatalf("due after failed attempt = %+v", due)
+	}
+}
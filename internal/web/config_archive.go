@@ -0,0 +1,333 @@
+package web
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/guohuiyuan/go-music-dl/core"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	configArchiveCollectionsSection = "collections"
+	configArchiveMaxUploadBytes     = 128 << 20
+)
+
+// ConfigArchiveOptions extends the core archive options with the sections
+// owned by the web package.
+type ConfigArchiveOptions struct {
+	core.ConfigArchiveOptions
+	Collections bool
+}
+
+// DefaultConfigArchiveOptions exports everything except cookies.
+func DefaultConfigArchiveOptions() ConfigArchiveOptions {
+	return ConfigArchiveOptions{ConfigArchiveOptions: core.DefaultConfigArchiveOptions(), Collections: true}
+}
+
+type collectionArchiveEntry struct {
+	Collection Collection  `json:"collection"`
+	Songs      []SavedSong `json:"songs"`
+}
+
+// ExportConfigArchive writes settings, profiles, the dedup index, collections
+// and optionally encrypted cookies as one zip archive. InitDB must have run.
+func ExportConfigArchive(w io.Writer, opts ConfigArchiveOptions) error {
+	archive, err := core.BuildConfigArchive(opts.ConfigArchiveOptions)
+	if err != nil {
+		return err
+	}
+	if opts.Collections {
+		entries, err := exportCollectionArchiveEntries()
+		if err != nil {
+			return err
+		}
+		if err := archive.SetSection(configArchiveCollectionsSection, entries); err != nil {
+			return err
+		}
+	}
+	return core.WriteConfigArchive(w, archive, opts.Passphrase)
+}
+
+// ImportConfigArchive applies an archive written by ExportConfigArchive.
+func ImportConfigArchive(data []byte, opts ConfigArchiveOptions) (core.ConfigImportResult, error) {
+	archive, err := core.ReadConfigArchive(data, opts.ConfigArchiveOptions)
+	if err != nil {
+		return core.ConfigImportResult{}, err
+	}
+
+	result, err := core.ApplyConfigArchive(archive, opts.ConfigArchiveOptions)
+	if err != nil {
+		return result, err
+	}
+	if raw, ok := archive.Sections[configArchiveCollectionsSection]; ok && opts.Collections {
+		var entries []collectionArchiveEntry
+		if err := json.Unmarshal(raw, &entries); err != nil {
+			return result, fmt.Errorf("invalid collections section: %w", err)
+		}
+		added, err := importCollectionArchiveEntries(entries)
+		if err != nil {
+			return result, err
+		}
+		if result.Sections == nil {
+			result.Sections = make(map[string]int)
+		}
+		result.Sections[configArchiveCollectionsSection] = added
+	}
+	if result.Settings {
+		refreshLocalMusicAfterSettingsChange()
+	}
+	return result, nil
+}
+
+func exportCollectionArchiveEntries() ([]collectionArchiveEntry, error) {
+	if db == nil {
+		return nil, errors.New("database is not initialized")
+	}
+	var collections []Collection
+	if err := db.Order("id ASC").Find(&collections).Error; err != nil {
+		return nil, err
+	}
+
+	entries := make([]collectionArchiveEntry, 0, len(collections))
+	for _, collection := range collections {
+		var songs []SavedSong
+		if collection.isManual() {
+			if err := db.Where("collection_id = ?", collection.ID).Order(savedSongExportOrder).Find(&songs).Error; err != nil {
+				return nil, err
+			}
+		}
+		if songs == nil {
+			songs = []SavedSong{}
+		}
+		entries = append(entries, collectionArchiveEntry{Collection: collection, Songs: songs})
+	}
+	return entries, nil
+}
+
+// importCollectionArchiveEntries merges collections into the local database.
+// Imported entries are matched by their upstream identity and manual ones by
+// name, so importing the same archive twice does not duplicate anything.
+// It returns the number of collections created.
+func importCollectionArchiveEntries(entries []collectionArchiveEntry) (int, error) {
+	if db == nil {
+		return 0, errors.New("database is not initialized")
+	}
+	created := 0
+	err := db.Transaction(func(tx *gorm.DB) error {
+		for _, entry := range entries {
+			collection := entry.Collection
+			collection.ID = 0
+			collection.SavedSongs = nil
+			collection.LastSyncedAt, collection.LastSyncError = nil, ""
+			collection.Name = strings.TrimSpace(collection.Name)
+			if collection.Name == "" {
+				continue
+			}
+			collection.Kind = collection.normalizedKind()
+			collection.ContentType = collection.normalizedContentType()
+
+			var existing Collection
+			var query *gorm.DB
+			if collection.isImported() {
+				query = tx.Where(
+					"kind = ? AND content_type = ? AND source = ? AND external_id = ?",
+					collectionKindImported, collection.ContentType, collection.Source, collection.ExternalID,
+				)
+			} else if collection.isSmart() {
+				collection.Source = "local"
+				query = tx.Where("kind = ? AND name = ?", collectionKindSmart, collection.Name)
+			} else {
+				collection.Source = "local"
+				query = tx.Where("(kind = ? OR kind = '' OR kind IS NULL) AND name = ?", collectionKindManual, collection.Name)
+			}
+			err := query.First(&existing).Error
+			switch {
+			case err == nil:
+				collection = existing
+			case errors.Is(err, gorm.ErrRecordNotFound):
+				if collection.CreatedAt.IsZero() {
+					collection.CreatedAt = time.Now()
+				}
+				if err := tx.Create(&collection).Error; err != nil {
+					return err
+				}
+				created++
+			default:
+				return err
+			}
+
+			if !collection.isManual() || len(entry.Songs) == 0 {
+				continue
+			}
+			songs := make([]SavedSong, 0, len(entry.Songs))
+			for _, song := range entry.Songs {
+				song.ID = 0
+				song.CollectionID = collection.ID
+				song.SongID = strings.TrimSpace(song.SongID)
+				song.Source = strings.TrimSpace(song.Source)
+				if song.SongID == "" || song.Source == "" {
+					continue
+				}
+				songs = append(songs, song)
+			}
+			if len(songs) == 0 {
+				continue
+			}
+			if err := tx.Clauses(clause.OnConflict{DoNothing: true}).CreateInBatches(&songs, 200).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return created, err
+}
+
+// refreshLocalMusicAfterSettingsChange drops cached scans because the
+// download directory may have changed, rebuilds the index in background and
+// points the directory watcher at the new location.
+func refreshLocalMusicAfterSettingsChange() {
+	invalidateLocalMusicScanCache()
+	syncLocalMusicIndexAsync()
+	restartLocalMusicWatcher()
+}
+
+func configArchiveOptionsFromForm(c *gin.Context) ConfigArchiveOptions {
+	flag := func(name string, fallback bool) bool {
+		raw := strings.TrimSpace(strings.ToLower(c.PostForm(name)))
+		if raw == "" {
+			return fallback
+		}
+		return raw == "1" || raw == "true" || raw == "on"
+	}
+	defaults := DefaultConfigArchiveOptions()
+	opts := ConfigArchiveOptions{
+		ConfigArchiveOptions: core.ConfigArchiveOptions{
+			Settings:   flag("settings", defaults.Settings),
+			Profiles:   flag("profiles", defaults.Profiles),
+			Cookies:    flag("cookies", defaults.Cookies),
+			Dedup:      flag("dedup", defaults.Dedup),
+			Passphrase: c.PostForm("passphrase"),
+		},
+		Collections: flag("collections", defaults.Collections),
+	}
+	return opts
+}
+
+func RegisterConfigRoutes(configAPI *gin.RouterGroup) {
+	configAPI.GET("/config/profiles", func(c *gin.Context) {
+		profiles, err := core.GetConfigProfiles()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, profiles)
+	})
+
+	configAPI.POST("/config/profiles", func(c *gin.Context) {
+		var req struct {
+			Name string `json:"name"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid profile payload"})
+			return
+		}
+		profile, err := core.SaveConfigProfile(req.Name, core.GetWebSettings())
+		if err != nil {
+			status := http.StatusInternalServerError
+			if errors.Is(err, core.ErrInvalidConfigProfile) {
+				status = http.StatusBadRequest
+			}
+			c.JSON(status, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, profile)
+	})
+
+	configAPI.POST("/config/profiles/activate", func(c *gin.Context) {
+		var req struct {
+			Name string `json:"name"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid profile payload"})
+			return
+		}
+		settings, err := core.ActivateConfigProfile(req.Name)
+		if err != nil {
+			status := http.StatusInternalServerError
+			if errors.Is(err, core.ErrConfigProfileNotFound) {
+				status = http.StatusNotFound
+			}
+			c.JSON(status, gin.H{"error": err.Error()})
+			return
+		}
+		refreshLocalMusicAfterSettingsChange()
+		c.JSON(http.StatusOK, settings)
+	})
+
+	configAPI.DELETE("/config/profiles", func(c *gin.Context) {
+		if err := core.DeleteConfigProfile(c.Query("name")); err != nil {
+			status := http.StatusInternalServerError
+			if errors.Is(err, core.ErrConfigProfileNotFound) {
+				status = http.StatusNotFound
+			}
+			c.JSON(status, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "ok"})
+	})
+
+	configAPI.POST("/config/export", func(c *gin.Context) {
+		opts := configArchiveOptionsFromForm(c)
+		var buf bytes.Buffer
+		if err := ExportConfigArchive(&buf, opts); err != nil {
+			status := http.StatusInternalServerError
+			if errors.Is(err, core.ErrConfigArchivePassphrase) {
+				status = http.StatusBadRequest
+			}
+			c.JSON(status, gin.H{"error": err.Error()})
+			return
+		}
+		setDownloadHeader(c, fmt.Sprintf("go-music-dl-config-%s.zip", time.Now().Format("20060102-150405")))
+		c.Data(http.StatusOK, "application/zip", buf.Bytes())
+	})
+
+	configAPI.POST("/config/import", func(c *gin.Context) {
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, configArchiveMaxUploadBytes)
+		file, err := c.FormFile("file")
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "请选择要导入的配置文件"})
+			return
+		}
+		src, err := file.Open()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		data, err := io.ReadAll(src)
+		_ = src.Close()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		result, err := ImportConfigArchive(data, configArchiveOptionsFromForm(c))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		resp := gin.H{"status": "ok", "result": result}
+		if result.Settings {
+			resp["settings"] = core.GetWebSettings()
+		}
+		c.JSON(http.StatusOK, resp)
+	})
+}
@@ -0,0 +1,148 @@
+package web
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/guohuiyuan/go-music-dl/core"
+)
+
+func collectionsOnlyArchiveOptions() ConfigArchiveOptions {
+	return ConfigArchiveOptions{Collections: true}
+}
+
+func TestConfigArchiveCollectionsRoundTrip(t *testing.T) {
+	initCollectionDBForTest(t)
+
+	manual := Collection{Name: "通勤", Kind: collectionKindManual, ContentType: collectionContentPlaylist, Source: "local"}
+	imported := Collection{Name: "Imported", Kind: collectionKindImported, ContentType: collectionContentAlbum, Source: "qq", ExternalID: "album-1"}
+	if err := db.Create(&manual).Error; err != nil {
+		t.Fatalf("create manual collection: %v", err)
+	}
+	if err := db.Create(&imported).Error; err != nil {
+		t.Fatalf("create imported collection: %v", err)
+	}
+	songs := []SavedSong{
+		{CollectionID: manual.ID, SongID: "1", Source: "netease", Name: "晴天", Artist: "周杰伦"},
+		{CollectionID: manual.ID, SongID: "2", Source: "qq", Name: "稻香", Artist: "周杰伦"},
+	}
+	if err := db.Create(&songs).Error; err != nil {
+		t.Fatalf("create songs: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := ExportConfigArchive(&buf, collectionsOnlyArchiveOptions()); err != nil {
+		t.Fatalf("ExportConfigArchive() error = %v", err)
+	}
+	data := buf.Bytes()
+
+	initCollectionDBForTest(t)
+	result, err := ImportConfigArchive(data, collectionsOnlyArchiveOptions())
+	if err != nil {
+		t.Fatalf("ImportConfigArchive() error = %v", err)
+	}
+	if got := result.Sections[configArchiveCollectionsSection]; got != 2 {
+		t.Fatalf("expected 2 created collections, got %d", got)
+	}
+
+	var restored Collection
+	if err := db.Where("name = ?", "通勤").First(&restored).Error; err != nil {
+		t.Fatalf("manual collection not restored: %v", err)
+	}
+	var count int64
+	db.Model(&SavedSong{}).Where("collection_id = ?", restored.ID).Count(&count)
+	if count != 2 {
+		t.Fatalf("expected 2 restored songs, got %d", count)
+	}
+
+	// A second import must merge into the existing rows instead of duplicating.
+	result, err = ImportConfigArchive(data, collectionsOnlyArchiveOptions())
+	if err != nil {
+		t.Fatalf("second ImportConfigArchive() error = %v", err)
+	}
+	if got := result.Sections[configArchiveCollectionsSection]; got != 0 {
+		t.Fatalf("expected no new collections on re-import, got %d", got)
+	}
+	db.Model(&Collection{}).Count(&count)
+	if count != 2 {
+		t.Fatalf("expected 2 collections after re-import, got %d", count)
+	}
+	db.Model(&SavedSong{}).Count(&count)
+	if count != 2 {
+		t.Fatalf("expected 2 songs after re-import, got %d", count)
+	}
+}
+
+func TestConfigArchiveRoutesExportAndImport(t *testing.T) {
+	initCollectionDBForTest(t)
+	if err := db.Create(&Collection{Name: "Road Trip", Kind: collectionKindManual, ContentType: collectionContentPlaylist, Source: "local"}).Error; err != nil {
+		t.Fatalf("create collection: %v", err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	RegisterConfigRoutes(r.Group(RoutePrefix))
+
+	form := "settings=0&profiles=0&dedup=0&collections=1"
+	req := httptest.NewRequest(http.MethodPost, RoutePrefix+"/config/export", bytes.NewBufferString(form))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("export status = %d, body = %s", w.Code, w.Body.String())
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/zip" {
+		t.Fatalf("unexpected content type %q", ct)
+	}
+	archive := w.Body.Bytes()
+
+	req = httptest.NewRequest(http.MethodPost, RoutePrefix+"/config/export", bytes.NewBufferString("cookies=1"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected cookies without passphrase to be rejected, got %d", w.Code)
+	}
+
+	initCollectionDBForTest(t)
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for key, value := range map[string]string{"settings": "0", "profiles": "0", "dedup": "0"} {
+		_ = mw.WriteField(key, value)
+	}
+	part, err := mw.CreateFormFile("file", "config.zip")
+	if err != nil {
+		t.Fatalf("create form file: %v", err)
+	}
+	_, _ = part.Write(archive)
+	_ = mw.Close()
+
+	req = httptest.NewRequest(http.MethodPost, RoutePrefix+"/config/import", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("import status = %d, body = %s", w.Code, w.Body.String())
+	}
+	var resp struct {
+		Result core.ConfigImportResult `json:"result"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode import response: %v", err)
+	}
+	if resp.Result.Sections[configArchiveCollectionsSection] != 1 {
+		t.Fatalf("unexpected import result: %+v", resp.Result)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, RoutePrefix+"/config/import", bytes.NewBufferString(""))
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected missing file to be rejected, got %d", w.Code)
+	}
+}
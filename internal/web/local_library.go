@@ -0,0 +1,297 @@
+package web
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/guohuiyuan/go-music-dl/core"
+)
+
+// 本地曲库由多个根目录组成：下载目录始终是第一个根（key 为空、可写），
+// 其余来自 WebSettings.LibraryRoots，可设为只读并用 include/exclude 过滤。
+var localLibraryRootsProvider = func() []core.LibraryRoot {
+	return core.GetWebSettings().LibraryRoots
+}
+
+var errLocalLibraryReadOnly = errors.New("该曲库目录为只读，不能修改其中的文件")
+
+type localLibraryRoot struct {
+	Key      string
+	Label    string
+	Path     string
+	Abs      string
+	ReadOnly bool
+	Include  []string
+	Exclude  []string
+}
+
+// localLibraryRootInfo is how a root is presented to the UI.
+type localLibraryRootInfo struct {
+	Key      string   `json:"key"`
+	Label    string   `json:"label"`
+	Path     string   `json:"path"`
+	ReadOnly bool     `json:"read_only"`
+	Download bool     `json:"download"`
+	Include  []string `json:"include,omitempty"`
+	Exclude  []string `json:"exclude,omitempty"`
+	Exists   bool     `json:"exists"`
+	Tracks   int64    `json:"tracks"`
+	Error    string   `json:"error,omitempty"`
+}
+
+func localLibraryRoots() []localLibraryRoot {
+	downloadDir := localMusicDownloadDir()
+	roots := []localLibraryRoot{{
+		Key:   core.DownloadLibraryRootKey,
+		Label: "下载目录",
+		Path:  downloadDir,
+		Abs:   absOrClean(downloadDir),
+	}}
+	for _, root := range localLibraryRootsProvider() {
+		path := strings.TrimSpace(root.Path)
+		if path == "" || root.Key == "" {
+			continue
+		}
+		abs := absOrClean(path)
+		if abs == roots[0].Abs {
+			continue
+		}
+		roots = append(roots, localLibraryRoot{
+			Key:      root.Key,
+			Label:    root.Label,
+			Path:     path,
+			Abs:      abs,
+			ReadOnly: root.ReadOnly,
+			Include:  root.Include,
+			Exclude:  root.Exclude,
+		})
+	}
+	return roots
+}
+
+func absOrClean(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return filepath.Clean(path)
+}
+
+func localLibraryRootByKey(key string) (localLibraryRoot, bool) {
+	for _, root := range localLibraryRoots() {
+		if root.Key == key {
+			return root, true
+		}
+	}
+	return localLibraryRoot{}, false
+}
+
+func localDownloadLibraryRoot() localLibraryRoot {
+	return localLibraryRoots()[0]
+}
+
+// localLibraryRootLabels maps root keys to labels for decorating index rows.
+func localLibraryRootLabels() map[string]localLibraryRoot {
+	roots := localLibraryRoots()
+	byKey := make(map[string]localLibraryRoot, len(roots))
+	for _, root := range roots {
+		byKey[root.Key] = root
+	}
+	return byKey
+}
+
+// resolve joins a slash separated relative path onto the root and refuses
+// anything that would escape it.
+func (r localLibraryRoot) resolve(rel string) (string, error) {
+	cleanRel := filepath.Clean(filepath.FromSlash(strings.TrimSpace(rel)))
+	if cleanRel == "" || filepath.IsAbs(cleanRel) || cleanRel == "." || cleanRel == ".." || strings.HasPrefix(cleanRel, ".."+string(filepath.Separator)) {
+		return "", errors.New("invalid local music path")
+	}
+	absPath := filepath.Join(r.Abs, cleanRel)
+	if !isPathInside(r.Abs, absPath) {
+		return "", errors.New("local music path escaped root")
+	}
+	return absPath, nil
+}
+
+// allows applies the root's include / exclude globs to a file's relative path.
+func (r localLibraryRoot) allows(rel string) bool {
+	rel = filepath.ToSlash(rel)
+	for _, glob := range r.Exclude {
+		if matchLocalLibraryGlob(glob, rel) {
+			return false
+		}
+	}
+	if len(r.Include) == 0 {
+		return true
+	}
+	for _, glob := range r.Include {
+		if matchLocalLibraryGlob(glob, rel) {
+			return true
+		}
+	}
+	return false
+}
+
+// excludesDir lets a walk skip whole directories matched by an exclude glob.
+func (r localLibraryRoot) excludesDir(rel string) bool {
+	rel = filepath.ToSlash(rel)
+	for _, glob := range r.Exclude {
+		if matchLocalLibraryGlob(glob, rel) {
+			return true
+		}
+	}
+	return false
+}
+
+var localLibraryGlobCache sync.Map
+
+// matchLocalLibraryGlob matches gitignore-like globs, case-insensitively:
+// "*" and "?" stay within one path segment, "**" crosses segments. A glob
+// without "/" matches any segment ("*.wav", "Podcasts"); one with "/" is
+// anchored at the root ("Archive/Old/**"). Matching a directory also matches
+// everything below it.
+func matchLocalLibraryGlob(glob string, rel string) bool {
+	cached, ok := localLibraryGlobCache.Load(glob)
+	if !ok {
+		cached, _ = localLibraryGlobCache.LoadOrStore(glob, compileLocalLibraryGlob(glob))
+	}
+	return cached.(*regexp.Regexp).MatchString(rel)
+}
+
+func compileLocalLibraryGlob(glob string) *regexp.Regexp {
+	glob = strings.Trim(filepath.ToSlash(strings.TrimSpace(glob)), "/")
+	var b strings.Builder
+	for i := 0; i < len(glob); i++ {
+		switch ch := glob[i]; ch {
+		case '*':
+			if i+1 < len(glob) && glob[i+1] == '*' {
+				i++
+				if i+1 < len(glob) && glob[i+1] == '/' {
+					i++
+					b.WriteString("(?:.*/)?")
+				} else {
+					b.WriteString(".*")
+				}
+				continue
+			}
+			b.WriteString("[^/]*")
+		case '?':
+			b.WriteString("[^/]")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(ch)))
+		}
+	}
+	prefix := "^"
+	if !strings.Contains(glob, "/") {
+		prefix = "^(?:.*/)?"
+	}
+	return regexp.MustCompile("(?i)" + prefix + b.String() + "(?:/.*)?$")
+}
+
+// encodeLocalLibraryID builds a track ID. Download-dir tracks keep the plain
+// base64(rel_path) form so existing collections stay valid; other roots are
+// encoded as base64("/<key>/<rel_path>"), which can never be a relative path.
+func encodeLocalLibraryID(rootKey string, relPath string) string {
+	if rootKey == core.DownloadLibraryRootKey {
+		return encodeLocalMusicID(relPath)
+	}
+	return base64.RawURLEncoding.EncodeToString([]byte("/" + rootKey + "/" + filepath.ToSlash(relPath)))
+}
+
+func decodeLocalLibraryID(id string) (string, string, error) {
+	raw, err := decodeLocalMusicID(id)
+	if err != nil {
+		return "", "", err
+	}
+	if !strings.HasPrefix(raw, "/") {
+		return core.DownloadLibraryRootKey, raw, nil
+	}
+	key, rel, ok := strings.Cut(strings.TrimPrefix(raw, "/"), "/")
+	if !ok || key == "" {
+		return "", "", errors.New("invalid local music id")
+	}
+	return key, rel, nil
+}
+
+// localMusicIndexRowPath resolves an index row to its file. ok is false when
+// the row's root is no longer configured.
+func localMusicIndexRowPath(roots map[string]localLibraryRoot, row *LocalMusicIndex) (string, bool) {
+	root, ok := roots[row.Root]
+	if !ok {
+		return "", false
+	}
+	absPath, err := root.resolve(row.RelPath)
+	if err != nil {
+		return "", false
+	}
+	return absPath, true
+}
+
+// writableLocalLibraryRoot returns the root uploads should go to; an empty key
+// means the download directory.
+func writableLocalLibraryRoot(key string) (localLibraryRoot, error) {
+	root, ok := localLibraryRootByKey(strings.TrimSpace(key))
+	if !ok {
+		return localLibraryRoot{}, fmt.Errorf("曲库目录 %q 不存在", key)
+	}
+	if root.ReadOnly {
+		return localLibraryRoot{}, errLocalLibraryReadOnly
+	}
+	return root, nil
+}
+
+// registerLocalLibraryRoutes lists the library roots with their reachability
+// and track counts, for the settings panel and the upload target picker.
+func registerLocalLibraryRoutes(api *gin.RouterGroup) {
+	api.GET("/local_music/roots", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"roots": localLibraryRootInfos()})
+	})
+}
+
+func localLibraryRootInfos() []localLibraryRootInfo {
+	counts := make(map[string]int64)
+	if db != nil {
+		var rows []struct {
+			Root  string
+			Count int64
+		}
+		if err := db.Model(&LocalMusicIndex{}).Select("root, COUNT(*) AS count").Group("root").Scan(&rows).Error; err == nil {
+			for _, row := range rows {
+				counts[row.Root] = row.Count
+			}
+		}
+	}
+	roots := localLibraryRoots()
+	infos := make([]localLibraryRootInfo, 0, len(roots))
+	for _, root := range roots {
+		info := localLibraryRootInfo{
+			Key:      root.Key,
+			Label:    root.Label,
+			Path:     filepath.ToSlash(root.Path),
+			ReadOnly: root.ReadOnly,
+			Download: root.Key == core.DownloadLibraryRootKey,
+			Include:  root.Include,
+			Exclude:  root.Exclude,
+			Tracks:   counts[root.Key],
+		}
+		if stat, err := os.Stat(root.Abs); err != nil {
+			if !os.IsNotExist(err) {
+				info.Error = err.Error()
+			}
+		} else if !stat.IsDir() {
+			info.Error = "不是目录"
+		} else {
+			info.Exists = true
+		}
+		infos = append(infos, info)
+	}
+	return infos
+}
@@ -0,0 +1,224 @@
+package web
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/guohuiyuan/go-music-dl/core"
+)
+
+func withLocalLibraryRoots(t *testing.T, roots ...core.LibraryRoot) {
+	t.Helper()
+	original := localLibraryRootsProvider
+	localLibraryRootsProvider = func() []core.LibraryRoot { return roots }
+	t.Cleanup(func() {
+		localLibraryRootsProvider = original
+	})
+}
+
+func TestMatchLocalLibraryGlob(t *testing.T) {
+	cases := []struct {
+		glob string
+		rel  string
+		want bool
+	}{
+		{"*.wav", "a.wav", true},
+		{"*.wav", "Album/B.WAV", true},
+		{"*.wav", "a.flac", false},
+		{"Podcasts", "Podcasts/ep1.mp3", true},
+		{"Podcasts", "Music/Podcasts/ep1.mp3", true},
+		{"Podcasts", "MyPodcasts/ep1.mp3", false},
+		{"Archive/Old/**", "Archive/Old/x/y.mp3", true},
+		{"Archive/Old", "Archive/Old/y.mp3", true},
+		{"Archive/Old", "Other/Archive/Old/y.mp3", false},
+		{"**/live/*.flac", "a/b/live/c.flac", true},
+		{"**/live/*.flac", "live/c.flac", true},
+		{"Disc ?/*.mp3", "Disc 1/a.mp3", true},
+		{"Disc ?/*.mp3", "Disc 10/a.mp3", false},
+	}
+	for _, tc := range cases {
+		if got := matchLocalLibraryGlob(tc.glob, tc.rel); got != tc.want {
+			t.Fatalf("matchLocalLibraryGlob(%q, %q) = %v, want %v", tc.glob, tc.rel, got, tc.want)
+		}
+	}
+}
+
+func TestLocalLibraryIDRoundTrip(t *testing.T) {
+	if got := encodeLocalLibraryID("", "A/b.mp3"); got != encodeLocalMusicID("A/b.mp3") {
+		t.Fatalf("download root id = %q, want legacy encoding", got)
+	}
+	id := encodeLocalLibraryID("nas", "A/b.mp3")
+	key, rel, err := decodeLocalLibraryID(id)
+	if err != nil || key != "nas" || rel != "A/b.mp3" {
+		t.Fatalf("decode = %q, %q, %v", key, rel, err)
+	}
+	key, rel, err = decodeLocalLibraryID(encodeLocalMusicID("A/b.mp3"))
+	if err != nil || key != "" || rel != "A/b.mp3" {
+		t.Fatalf("decode legacy = %q, %q, %v", key, rel, err)
+	}
+}
+
+func TestLocalLibraryScanSpansRootsWithGlobs(t *testing.T) {
+	initCollectionDBForTest(t)
+
+	downloadDir := t.TempDir()
+	archiveDir := t.TempDir()
+	withLocalMusicDownloadDir(t, downloadDir)
+	withLocalLibraryRoots(t,
+		core.LibraryRoot{Key: "archive", Label: "旧硬盘", Path: archiveDir, ReadOnly: true, Exclude: []string{"Podcasts"}},
+		core.LibraryRoot{Key: "dup", Label: "重复", Path: downloadDir},
+	)
+
+	writeLocalMusicFileForTest(t, filepath.Join(downloadDir, "Fresh.mp3"), "fresh")
+	writeLocalMusicFileForTest(t, filepath.Join(archiveDir, "Old", "Archived Song.flac"), "old")
+	writeLocalMusicFileForTest(t, filepath.Join(archiveDir, "Podcasts", "Episode.mp3"), "ep")
+
+	tracks, _, _, err := scanLocalMusicTracks()
+	if err != nil {
+		t.Fatalf("scan local music: %v", err)
+	}
+	if len(tracks) != 2 {
+		t.Fatalf("tracks = %d, want 2 (download root listed once, podcasts excluded)", len(tracks))
+	}
+	if err := syncTracksToIndex(tracks); err != nil {
+		t.Fatalf("sync index: %v", err)
+	}
+
+	archivedID := encodeLocalLibraryID("archive", "Old/Archived Song.flac")
+	var row LocalMusicIndex
+	if err := db.First(&row, "id = ?", archivedID).Error; err != nil {
+		t.Fatalf("archive row missing: %v", err)
+	}
+	if row.Root != "archive" || row.RelPath != "Old/Archived Song.flac" {
+		t.Fatalf("archive row = %+v", row)
+	}
+
+	songs := localMusicSearchSongs("Archived", 10)
+	if len(songs) != 1 || songs[0].ID != archivedID {
+		t.Fatalf("search across roots = %+v, want archived song", songs)
+	}
+
+	track, err := localMusicTrackByID(archivedID)
+	if err != nil {
+		t.Fatalf("resolve archive id: %v", err)
+	}
+	if track.Root != "archive" || track.RootLabel != "旧硬盘" || !track.ReadOnly {
+		t.Fatalf("archive track root fields = %q/%q/%v", track.Root, track.RootLabel, track.ReadOnly)
+	}
+	if _, err := localMusicTrackByID(encodeLocalLibraryID("archive", "Podcasts/Episode.mp3")); err == nil {
+		t.Fatal("excluded file resolved by id")
+	}
+
+	// Removing the archive disk must not sweep its rows on the next sync.
+	if err := os.RemoveAll(archiveDir); err != nil {
+		t.Fatalf("remove archive root: %v", err)
+	}
+	tracks, _, _, err = scanLocalMusicTracks()
+	if err != nil {
+		t.Fatalf("rescan local music: %v", err)
+	}
+	if err := syncTracksToIndex(tracks); err != nil {
+		t.Fatalf("resync index: %v", err)
+	}
+	var count int64
+	db.Model(&LocalMusicIndex{}).Where("root = ?", "archive").Count(&count)
+	if count != 1 {
+		t.Fatalf("archive rows after going offline = %d, want 1", count)
+	}
+}
+
+func TestLocalLibraryReadOnlyRootRejectsWrites(t *testing.T) {
+	initCollectionDBForTest(t)
+
+	downloadDir := t.TempDir()
+	archiveDir := t.TempDir()
+	nasDir := t.TempDir()
+	withLocalMusicDownloadDir(t, downloadDir)
+	withLocalLibraryRoots(t,
+		core.LibraryRoot{Key: "archive", Label: "Archive", Path: archiveDir, ReadOnly: true},
+		core.LibraryRoot{Key: "nas", Label: "NAS", Path: nasDir},
+	)
+
+	archived := filepath.Join(archiveDir, "Keep.mp3")
+	writeLocalMusicFileForTest(t, archived, "keep")
+	if err := deleteLocalMusicTrack(encodeLocalLibraryID("archive", "Keep.mp3")); !errors.Is(err, errLocalLibraryReadOnly) {
+		t.Fatalf("delete read-only track err = %v, want errLocalLibraryReadOnly", err)
+	}
+	if _, err := os.Stat(archived); err != nil {
+		t.Fatalf("read-only file was touched: %v", err)
+	}
+
+	// Upload through saveUploadedLocalMusic directly: the HTTP handler also
+	// records a dedup entry in the process-wide config DB.
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	part, err := writer.CreateFormFile("file", "Shared.mp3")
+	if err != nil {
+		t.Fatalf("create multipart file: %v", err)
+	}
+	part.Write([]byte("shared audio"))
+	writer.Close()
+	form, err := multipart.NewReader(&body, writer.Boundary()).ReadForm(1 << 20)
+	if err != nil {
+		t.Fatalf("read multipart form: %v", err)
+	}
+	file := form.File["file"][0]
+
+	if _, err := saveUploadedLocalMusic(file, "archive"); !errors.Is(err, errLocalLibraryReadOnly) {
+		t.Fatalf("upload to read-only root err = %v, want errLocalLibraryReadOnly", err)
+	}
+	track, err := saveUploadedLocalMusic(file, "nas")
+	if err != nil {
+		t.Fatalf("upload to nas: %v", err)
+	}
+	if track.ID != encodeLocalLibraryID("nas", "Shared.mp3") || track.Root != "nas" {
+		t.Fatalf("uploaded track = %+v", track)
+	}
+	if _, err := os.Stat(filepath.Join(nasDir, "Shared.mp3")); err != nil {
+		t.Fatalf("uploaded file missing from nas root: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodDelete, RoutePrefix+"/local_music?id="+url.QueryEscape(track.ID), nil)
+	rec := httptest.NewRecorder()
+	newLocalMusicTestRouter().ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("delete from writable root status = %d, body=%s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestLocalLibraryRootsEndpointReportsRoots(t *testing.T) {
+	initCollectionDBForTest(t)
+
+	downloadDir := t.TempDir()
+	withLocalMusicDownloadDir(t, downloadDir)
+	withLocalLibraryRoots(t,
+		core.LibraryRoot{Key: "nas", Label: "NAS", Path: filepath.Join(t.TempDir(), "missing"), ReadOnly: true},
+	)
+	upsertLocalMusicIndexRow(&localMusicTrack{ID: encodeLocalLibraryID("nas", "a.mp3"), RelPath: "a.mp3", Name: "a", Root: "nas"})
+
+	rec := httptest.NewRecorder()
+	newLocalMusicTestRouter().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, RoutePrefix+"/local_music/roots", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body=%s", rec.Code, rec.Body.String())
+	}
+	var resp struct {
+		Roots []localLibraryRootInfo `json:"roots"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode roots: %v", err)
+	}
+	if len(resp.Roots) != 2 || !resp.Roots[0].Download || !resp.Roots[0].Exists {
+		t.Fatalf("roots = %+v, want download root first", resp.Roots)
+	}
+	if nas := resp.Roots[1]; nas.Key != "nas" || nas.Exists || !nas.ReadOnly || nas.Tracks != 1 {
+		t.Fatalf("nas root = %+v", nas)
+	}
+}
@@ -1,6 +1,7 @@
 package web
 
 import (
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
@@ -46,6 +47,7 @@ var localMusicAudioExts = map[string]struct{}{
 	".m4a":  {},
 	".mp3":  {},
 	".ogg":  {},
+	".opus": {},
 	".wav":  {},
 	".wma":  {},
 }
@@ -79,6 +81,7 @@ type localMusicTrack struct {
 	Name         string            `json:"name"`
 	Artist       string            `json:"artist"`
 	Album        string            `json:"album"`
+	AlbumArtist  string            `json:"album_artist,omitempty"`
 	Cover        string            `json:"cover"`
 	Duration     int               `json:"duration"`
 	Filename     string            `json:"filename"`
@@ -89,20 +92,40 @@ type localMusicTrack struct {
 	ModifiedAt   time.Time         `json:"modified_at"`
 	Missing      []string          `json:"missing"`
 	AlreadyAdded bool              `json:"already_added,omitempty"`
+	Root         string            `json:"root,omitempty"`
+	RootLabel    string            `json:"root_label,omitempty"`
+	ReadOnly     bool              `json:"read_only,omitempty"`
 	Extra        map[string]string `json:"extra"`
 
 	absPath string
 	modTime time.Time
+	// lyricText 是歌词纯文本（去掉时间轴），只用于写入全文索引。
+	lyricText string
 }
 
 type localMusicDupItem struct {
-	ID       string `json:"id"`
-	Name     string `json:"name"`
-	Artist   string `json:"artist"`
-	Size     int64  `json:"size"`
-	Duration int    `json:"duration"`
-	Ext      string `json:"ext"`
-	RelPath  string `json:"rel_path"`
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	Artist    string `json:"artist"`
+	Size      int64  `json:"size"`
+	Duration  int    `json:"duration"`
+	Ext       string `json:"ext"`
+	RelPath   string `json:"rel_path"`
+	Root      string `json:"root,omitempty"`
+	RootLabel string `json:"root_label,omitempty"`
+	ReadOnly  bool   `json:"read_only,omitempty"`
+	// Bitrate 是按大小和时长估算的 kbps，用来挑保留哪一份。
+	Bitrate int `json:"bitrate,omitempty"`
+	// Similarity 是与建议保留的那份的指纹相似度（仅指纹模式）。
+	Similarity float64 `json:"similarity,omitempty"`
+	Keep       bool    `json:"keep,omitempty"`
+}
+
+type localMusicDupGroup struct {
+	Name   string              `json:"name"`
+	Artist string              `json:"artist"`
+	Keep   string              `json:"keep,omitempty"`
+	Songs  []localMusicDupItem `json:"songs"`
 }
 
 type autoCacheRequest struct {
@@ -157,16 +180,19 @@ func reserveAutoCache(key string) string {
 	autoCacheMu.Lock()
 	defer autoCacheMu.Unlock()
 
+	status := "busy"
 	if _, exists := autoCacheInFlight[key]; exists {
-		return "in_progress"
-	}
-	select {
-	case autoCacheSlots <- struct{}{}:
-		autoCacheInFlight[key] = struct{}{}
-		return "started"
-	default:
-		return "busy"
+		status = "in_progress"
+	} else {
+		select {
+		case autoCacheSlots <- struct{}{}:
+			autoCacheInFlight[key] = struct{}{}
+			status = "started"
+		default:
+		}
 	}
+	autoCacheTotal.Inc(status)
+	return status
 }
 
 func releaseAutoCache(key string) {
@@ -191,7 +217,9 @@ func indexAutoCachedLocalMusic(result *core.DownloadedSong, downloadDir string)
 	if err != nil {
 		return
 	}
-	track, err := buildLocalMusicTrackFast(rootAbs, result.SavedPath)
+	root := localDownloadLibraryRoot()
+	root.Path, root.Abs = rootDir, rootAbs
+	track, err := buildLocalMusicTrackFast(root, result.SavedPath)
 	if err != nil {
 		return
 	}
@@ -209,6 +237,16 @@ func extractBitrateFromAudioFile(absPath string) int {
 }
 
 func RegisterLocalMusicRoutes(api *gin.RouterGroup) {
+	registerLocalMusicWatcherRoutes(api)
+	registerLocalLibraryRoutes(api)
+	registerLocalMusicBrowseRoutes(api)
+	registerLocalMusicTagRoutes(api)
+	registerLocalMusicIdentifyRoutes(api)
+	registerLocalMusicFingerprintRoutes(api)
+	registerLocalMusicLoudnessRoutes(api)
+	registerLocalMusicOrganizeRoutes(api)
+	registerTranscodeRoutes(api)
+
 	api.GET("/local_music_page", func(c *gin.Context) {
 		errMsg := ""
 		tracks := []*localMusicTrack{}
@@ -243,8 +281,10 @@ func RegisterLocalMusicRoutes(api *gin.RouterGroup) {
 					"refreshing":   false,
 					"scanned_at":   time.Now(),
 				})
-				// 后台异步刷新文件系统变更
-				refreshLocalMusicScanAsync(localMusicDownloadDir())
+				// 后台异步刷新文件系统变更；目录监听在跑时索引已是最新，无需再扫。
+				if !localMusicWatcherCovers(localMusicDownloadDir()) {
+					refreshLocalMusicScanAsync(localMusicDownloadDir())
+				}
 				return
 			}
 		}
@@ -261,7 +301,11 @@ func RegisterLocalMusicRoutes(api *gin.RouterGroup) {
 
 		// 扫描完成后同步到 SQLite 索引（异步），空扫描也要清除旧行。
 		if !refreshing {
-			go func() { _ = syncTracksToIndex(tracks) }()
+			go func() {
+				if err := syncTracksToIndex(tracks); err != nil {
+					core.Logger().Warn("sync scanned tracks to index failed", "error", err)
+				}
+			}()
 		}
 
 		c.JSON(http.StatusOK, gin.H{
@@ -313,7 +357,7 @@ func RegisterLocalMusicRoutes(api *gin.RouterGroup) {
 			return
 		}
 
-		track, err := saveUploadedLocalMusic(file)
+		track, err := saveUploadedLocalMusic(file, c.PostForm("root"))
 		if err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
@@ -323,6 +367,12 @@ func RegisterLocalMusicRoutes(api *gin.RouterGroup) {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "音乐文件已保存，但曲库索引写入失败: " + err.Error()})
 			return
 		}
+		core.Notify(c.Request.Context(), core.NotifyEvent{
+			Type:    core.EventLibraryUploaded,
+			Title:   "已上传到本地曲库",
+			Message: songLabel(track.Name, track.Artist),
+			Data:    gin.H{"name": track.Name, "artist": track.Artist, "path": track.RelPath},
+		})
 
 		c.JSON(http.StatusOK, gin.H{
 			"status": "ok",
@@ -408,11 +458,9 @@ func RegisterLocalMusicRoutes(api *gin.RouterGroup) {
 			c.JSON(http.StatusOK, gin.H{"groups": []interface{}{}, "page": page, "page_size": pageSize, "total": 0, "total_pages": 1})
 			return
 		}
-
-		type dupGroup struct {
-			Name   string              `json:"name"`
-			Artist string              `json:"artist"`
-			Songs  []localMusicDupItem `json:"songs"`
+		if c.Query("mode") == "fingerprint" {
+			serveLocalMusicFingerprintDuplicates(c, page, pageSize)
+			return
 		}
 
 		type dupRow struct {
@@ -454,32 +502,41 @@ func RegisterLocalMusicRoutes(api *gin.RouterGroup) {
 			return
 		}
 
-		groups := make([]dupGroup, 0, len(rows))
+		roots := localLibraryRootLabels()
+		groups := make([]localMusicDupGroup, 0, len(rows))
 		for _, row := range rows {
 			var songs []LocalMusicIndex
 			db.Where("name = ? AND artist = ?", row.Name, row.Artist).
 				Order("size DESC").
 				Find(&songs)
 
-			rootAbs, _ := filepath.Abs(localMusicDownloadDir())
 			items := make([]localMusicDupItem, 0, len(songs))
-			for _, s := range songs {
-				absPath := filepath.Join(rootAbs, filepath.FromSlash(s.RelPath))
+			for i := range songs {
+				s := &songs[i]
+				absPath, ok := localMusicIndexRowPath(roots, s)
+				if !ok {
+					continue
+				}
 				if info, statErr := os.Stat(absPath); statErr != nil || info.IsDir() {
 					continue
 				}
 				items = append(items, localMusicDupItem{
-					ID:       s.ID,
-					Name:     s.Name,
-					Artist:   s.Artist,
-					Size:     s.Size,
-					Duration: s.Duration,
-					Ext:      s.Ext,
-					RelPath:  s.RelPath,
+					ID:        s.ID,
+					Name:      s.Name,
+					Artist:    s.Artist,
+					Size:      s.Size,
+					Duration:  s.Duration,
+					Ext:       s.Ext,
+					RelPath:   s.RelPath,
+					Root:      s.Root,
+					RootLabel: roots[s.Root].Label,
+					ReadOnly:  roots[s.Root].ReadOnly,
+					Bitrate:   estimateLocalMusicBitrate(s.Size, s.Duration),
 				})
 			}
 			if len(items) >= 2 {
-				groups = append(groups, dupGroup{Name: row.Name, Artist: row.Artist, Songs: items})
+				keep := markLocalMusicDuplicateKeep(items)
+				groups = append(groups, localMusicDupGroup{Name: row.Name, Artist: row.Artist, Keep: keep, Songs: items})
 			}
 		}
 
@@ -543,13 +600,32 @@ func RegisterLocalMusicRoutes(api *gin.RouterGroup) {
 			Extra:  parseAutoCacheExtra(req.Extra),
 		}
 
+		ctx := context.WithoutCancel(c.Request.Context())
 		go func() {
 			defer releaseAutoCache(cacheKey)
 			result, err := autoCacheSaveSong(song, settings.DownloadDir, true, true, settings.DownloadFilenameTemplate)
 			if err != nil || result == nil {
+				autoCacheTotal.Inc("failed")
+				if err == nil {
+					err = errors.New("no audio saved")
+				}
+				core.LoggerFromContext(ctx).Warn("auto cache failed", "source", song.Source, "song", song.Name, "error", err)
+				core.Notify(ctx, core.NotifyEvent{
+					Type:    core.EventLibraryCacheFailed,
+					Title:   "边播边缓存失败",
+					Message: songLabel(song.Name, song.Artist) + ": " + err.Error(),
+					Data:    gin.H{"name": song.Name, "artist": song.Artist, "source": song.Source, "error": err.Error()},
+				})
 				return
 			}
+			autoCacheTotal.Inc("saved")
 			autoCacheIndexSavedSong(result, settings.DownloadDir)
+			core.Notify(ctx, core.NotifyEvent{
+				Type:    core.EventLibraryCached,
+				Title:   "已缓存到本地曲库",
+				Message: songLabel(song.Name, song.Artist),
+				Data:    gin.H{"name": song.Name, "artist": song.Artist, "source": song.Source, "path": result.SavedPath},
+			})
 		}()
 
 		c.JSON(http.StatusOK, gin.H{"status": "started"})
@@ -575,6 +651,10 @@ func RegisterLocalMusicRoutes(api *gin.RouterGroup) {
 			c.JSON(http.StatusNotFound, gin.H{"error": "歌单不存在"})
 			return
 		}
+		if collection.isSmart() {
+			c.JSON(http.StatusBadRequest, gin.H{"error": smartCollectionReadonlyError})
+			return
+		}
 		if collection.isImported() {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "外部导入歌单/专辑不支持直接添加本地音乐"})
 			return
@@ -626,6 +706,10 @@ func RegisterLocalMusicRoutes(api *gin.RouterGroup) {
 			c.JSON(http.StatusNotFound, gin.H{"error": "歌单不存在"})
 			return
 		}
+		if collection.isSmart() {
+			c.JSON(http.StatusBadRequest, gin.H{"error": smartCollectionReadonlyError})
+			return
+		}
 		if collection.isImported() {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "外部导入歌单/专辑不支持直接添加本地音乐"})
 			return
@@ -706,66 +790,88 @@ func localMusicTracksToSongs(tracks []*localMusicTrack) []model.Song {
 	return songs
 }
 
+// scanLocalMusicTracks walks every library root. dir and exists describe the
+// download directory; other roots that are missing or unreadable (an offline
+// NAS, an unplugged disk) are skipped so they do not hide the rest.
 func scanLocalMusicTracks() ([]*localMusicTrack, string, bool, error) {
 	dir := localMusicDownloadDir()
-	info, err := os.Stat(dir)
+	roots := localLibraryRoots()
+	tracks, exists, err := scanLocalLibraryRoot(roots[0])
 	if err != nil {
-		if os.IsNotExist(err) {
-			return []*localMusicTrack{}, dir, false, nil
-		}
-		return nil, dir, false, err
+		return nil, dir, exists, err
 	}
-	if !info.IsDir() {
-		return nil, dir, false, fmt.Errorf("本地下载路径不是目录: %s", dir)
+	for _, root := range roots[1:] {
+		rootTracks, _, rootErr := scanLocalLibraryRoot(root)
+		if rootErr != nil {
+			core.Logger().Warn("scan local library root failed", "root", root.Label, "path", root.Path, "error", rootErr)
+			continue
+		}
+		tracks = append(tracks, rootTracks...)
 	}
+	sortLocalMusicTracks(tracks)
+	return tracks, dir, exists, nil
+}
 
-	rootAbs, err := filepath.Abs(dir)
+func scanLocalLibraryRoot(root localLibraryRoot) ([]*localMusicTrack, bool, error) {
+	info, err := os.Stat(root.Abs)
 	if err != nil {
-		return nil, dir, true, err
+		if os.IsNotExist(err) {
+			return []*localMusicTrack{}, false, nil
+		}
+		return nil, false, err
+	}
+	if !info.IsDir() {
+		return nil, false, fmt.Errorf("本地曲库路径不是目录: %s", root.Path)
 	}
 
 	tracks := make([]*localMusicTrack, 0)
-	err = filepath.WalkDir(rootAbs, func(path string, entry fs.DirEntry, walkErr error) error {
+	err = filepath.WalkDir(root.Abs, func(path string, entry fs.DirEntry, walkErr error) error {
 		if walkErr != nil {
 			return nil
 		}
+		if path == root.Abs {
+			return nil
+		}
+		rel, relErr := filepath.Rel(root.Abs, path)
+		if relErr != nil {
+			return nil
+		}
 		if entry.IsDir() {
-			if entry.Name() == ".git" || strings.HasPrefix(entry.Name(), ".") {
-				if path != rootAbs {
-					return filepath.SkipDir
-				}
+			if strings.HasPrefix(entry.Name(), ".") || root.excludesDir(rel) {
+				return filepath.SkipDir
 			}
 			return nil
 		}
-		if !isLocalMusicAudioFile(path) {
+		if !isLocalMusicAudioFile(path) || !root.allows(rel) {
 			return nil
 		}
 
-		track, err := buildLocalMusicTrackFast(rootAbs, path)
+		track, err := buildLocalMusicTrackFast(root, path)
 		if err == nil {
 			tracks = append(tracks, track)
 		}
 		return nil
 	})
 	if err != nil {
-		return nil, dir, true, err
+		return nil, true, err
 	}
+	return tracks, true, nil
+}
 
+func sortLocalMusicTracks(tracks []*localMusicTrack) {
 	sort.SliceStable(tracks, func(i, j int) bool {
 		if !tracks[i].modTime.Equal(tracks[j].modTime) {
 			return tracks[i].modTime.After(tracks[j].modTime)
 		}
 		return strings.ToLower(tracks[i].RelPath) < strings.ToLower(tracks[j].RelPath)
 	})
-
-	return tracks, dir, true, nil
 }
 
 func scanLocalMusicTracksCached(force bool) ([]*localMusicTrack, string, bool, error, bool, time.Time) {
 	dir := localMusicDownloadDir()
 	if !force {
 		if snapshot, ok := cachedLocalMusicScanSnapshot(dir, false); ok {
-			if time.Since(snapshot.ScannedAt) < localMusicScanCacheTTL {
+			if time.Since(snapshot.ScannedAt) < localMusicScanCacheTTL || localMusicWatcherCovers(dir) {
 				return snapshot.Tracks, snapshot.Dir, snapshot.Exists, snapshot.Err, false, snapshot.ScannedAt
 			}
 			if snapshot.Err == nil {
@@ -855,11 +961,14 @@ func cachedLocalMusicScanSnapshot(dir string, freshOnly bool) (localMusicScanSna
 	localMusicScanCacheMu.RUnlock()
 
 	if strings.TrimSpace(snapshot.Dir) == "" || filepath.Clean(snapshot.Dir) != filepath.Clean(dir) {
+		observeCacheLookup("local_scan", false)
 		return localMusicScanSnapshot{}, false
 	}
 	if freshOnly && time.Since(snapshot.ScannedAt) >= localMusicScanCacheTTL {
+		observeCacheLookup("local_scan", false)
 		return localMusicScanSnapshot{}, false
 	}
+	observeCacheLookup("local_scan", true)
 	snapshot.Tracks = cloneLocalMusicTrackSlice(snapshot.Tracks)
 	return snapshot, true
 }
@@ -907,7 +1016,7 @@ func markAlreadyAddedLocalTracks(collectionID string, tracks []*localMusicTrack)
 	}
 
 	collection, err := loadCollection(collectionID)
-	if err != nil || collection.isImported() {
+	if err != nil || !collection.isManual() {
 		return
 	}
 
@@ -948,20 +1057,34 @@ func isLocalMusicAudioFile(path string) bool {
 	return ok
 }
 
-func buildLocalMusicTrackFast(rootAbs string, audioPath string) (*localMusicTrack, error) {
-	track, err := buildLocalMusicTrackFallback(rootAbs, audioPath)
+func buildLocalMusicTrackFast(root localLibraryRoot, audioPath string) (*localMusicTrack, error) {
+	track, err := buildLocalMusicTrackFallback(root, audioPath)
 	if err != nil {
 		return nil, err
 	}
-	if cached := getCachedLocalMusicTrack(rootAbs, track.RelPath, track.Size, track.modTime); cached != nil {
+	if cached := getCachedLocalMusicTrack(root.Abs, track.RelPath, track.Size, track.modTime); cached != nil {
 		cached.absPath = track.absPath
 		cached.modTime = track.modTime
+		cached.applyLibraryRoot(root)
 		return cached, nil
 	}
-	return buildLocalMusicTrack(rootAbs, audioPath)
+	return buildLocalMusicTrack(root, audioPath)
+}
+
+// applyLibraryRoot records which root a track lives in. Labels and the
+// read-only flag can change without touching the file, so cached tracks are
+// re-stamped on every use.
+func (track *localMusicTrack) applyLibraryRoot(root localLibraryRoot) {
+	track.Root = root.Key
+	track.RootLabel = root.Label
+	track.ReadOnly = root.ReadOnly
+	if root.Key != "" && track.Extra != nil {
+		track.Extra["library_root"] = root.Key
+	}
 }
 
-func buildLocalMusicTrackFallback(rootAbs string, audioPath string) (*localMusicTrack, error) {
+func buildLocalMusicTrackFallback(root localLibraryRoot, audioPath string) (*localMusicTrack, error) {
+	rootAbs := root.Abs
 	absPath, err := filepath.Abs(audioPath)
 	if err != nil {
 		return nil, err
@@ -987,7 +1110,7 @@ func buildLocalMusicTrackFallback(rootAbs string, audioPath string) (*localMusic
 	filename := info.Name()
 	ext := strings.TrimPrefix(strings.ToLower(filepath.Ext(filename)), ".")
 	fallbackName := strings.TrimSuffix(filename, filepath.Ext(filename))
-	id := encodeLocalMusicID(rel)
+	id := localMusicIDForPath(root, rel, absPath, info)
 	extra := map[string]string{
 		"local_music": "true",
 		"file_id":     id,
@@ -1007,7 +1130,7 @@ func buildLocalMusicTrackFallback(rootAbs string, audioPath string) (*localMusic
 		extra["lyric_source"] = "sidecar"
 	}
 
-	return &localMusicTrack{
+	track := &localMusicTrack{
 		ID:         id,
 		Source:     localMusicSource,
 		Name:       strings.TrimSpace(fallbackName),
@@ -1025,10 +1148,13 @@ func buildLocalMusicTrackFallback(rootAbs string, audioPath string) (*localMusic
 		Extra:      extra,
 		absPath:    absPath,
 		modTime:    info.ModTime(),
-	}, nil
+	}
+	track.applyLibraryRoot(root)
+	return track, nil
 }
 
-func buildLocalMusicTrack(rootAbs string, audioPath string) (*localMusicTrack, error) {
+func buildLocalMusicTrack(root localLibraryRoot, audioPath string) (*localMusicTrack, error) {
+	rootAbs := root.Abs
 	absPath, err := filepath.Abs(audioPath)
 	if err != nil {
 		return nil, err
@@ -1056,24 +1182,27 @@ func buildLocalMusicTrack(rootAbs string, audioPath string) (*localMusicTrack, e
 	if cached := getCachedLocalMusicTrack(rootAbs, rel, info.Size(), info.ModTime()); cached != nil {
 		cached.absPath = absPath
 		cached.modTime = info.ModTime()
+		cached.applyLibraryRoot(root)
 		return cached, nil
 	}
 	fallbackName := strings.TrimSuffix(filename, filepath.Ext(filename))
 	name := ""
 	artist := ""
 	album := ""
+	albumArtist := ""
 	hasEmbeddedCover := false
-	hasEmbeddedLyric := false
+	embeddedLyric := ""
 
 	if file, err := os.Open(absPath); err == nil {
 		if metadata, readErr := tag.ReadFrom(file); readErr == nil {
 			name = strings.TrimSpace(metadata.Title())
 			artist = strings.TrimSpace(metadata.Artist())
 			album = strings.TrimSpace(metadata.Album())
+			albumArtist = strings.TrimSpace(metadata.AlbumArtist())
 			if picture := metadata.Picture(); picture != nil && len(picture.Data) > 0 {
 				hasEmbeddedCover = true
 			}
-			hasEmbeddedLyric = strings.TrimSpace(metadata.Lyrics()) != ""
+			embeddedLyric = strings.TrimSpace(metadata.Lyrics())
 		}
 		_ = file.Close()
 	}
@@ -1091,7 +1220,7 @@ func buildLocalMusicTrack(rootAbs string, audioPath string) (*localMusicTrack, e
 		missing = append(missing, "album")
 	}
 
-	id := encodeLocalMusicID(rel)
+	id := localMusicIDForPath(root, rel, absPath, info)
 	extra := map[string]string{
 		"local_music": "true",
 		"file_id":     id,
@@ -1115,33 +1244,41 @@ func buildLocalMusicTrack(rootAbs string, audioPath string) (*localMusicTrack, e
 		extra["cover_source"] = "sidecar"
 	}
 
-	if hasEmbeddedLyric {
+	lyricText := ""
+	if embeddedLyric != "" {
 		extra["lyric"] = "true"
 		extra["lyric_source"] = "embedded"
-	} else if _, _, ok := localMusicSidecarFile(absPath, localMusicLyricExts); ok {
+		lyricText = localMusicLyricSearchBody(embeddedLyric)
+	} else if sidecar, _, ok := localMusicSidecarFile(absPath, localMusicLyricExts); ok {
 		extra["lyric"] = "true"
 		extra["lyric_source"] = "sidecar"
+		if data, err := os.ReadFile(sidecar); err == nil {
+			lyricText = localMusicLyricSearchBody(string(data))
+		}
 	}
 
 	track := &localMusicTrack{
-		ID:         id,
-		Source:     localMusicSource,
-		Name:       strings.TrimSpace(name),
-		Artist:     strings.TrimSpace(artist),
-		Album:      strings.TrimSpace(album),
-		Cover:      cover,
-		Duration:   0,
-		Filename:   filename,
-		RelPath:    rel,
-		Ext:        ext,
-		Size:       info.Size(),
-		SizeText:   core.FormatSize(info.Size()),
-		ModifiedAt: info.ModTime(),
-		Missing:    missing,
-		Extra:      extra,
-		absPath:    absPath,
-		modTime:    info.ModTime(),
-	}
+		ID:          id,
+		Source:      localMusicSource,
+		Name:        strings.TrimSpace(name),
+		Artist:      strings.TrimSpace(artist),
+		Album:       strings.TrimSpace(album),
+		AlbumArtist: albumArtist,
+		Cover:       cover,
+		Duration:    0,
+		Filename:    filename,
+		RelPath:     rel,
+		Ext:         ext,
+		Size:        info.Size(),
+		SizeText:    core.FormatSize(info.Size()),
+		ModifiedAt:  info.ModTime(),
+		Missing:     missing,
+		Extra:       extra,
+		absPath:     absPath,
+		modTime:     info.ModTime(),
+		lyricText:   lyricText,
+	}
+	track.applyLibraryRoot(root)
 	if probe, err := probeLocalMusicTrack(track); err == nil && probe != nil {
 		applyLocalProbeResult(track, probe)
 	}
@@ -1154,8 +1291,10 @@ func getCachedLocalMusicTrack(rootAbs string, relPath string, size int64, modTim
 	defer localMusicMetaCacheMu.RUnlock()
 	track := localMusicMetaCache[localMusicMetaCacheKey(rootAbs, relPath)]
 	if track == nil || track.Size != size || !track.modTime.Equal(modTime) {
+		observeCacheLookup("local_meta", false)
 		return nil
 	}
+	observeCacheLookup("local_meta", true)
 	return cloneLocalMusicTrack(track)
 }
 
@@ -1168,6 +1307,14 @@ func cacheLocalMusicTrack(rootAbs string, track *localMusicTrack) {
 	localMusicMetaCacheMu.Unlock()
 }
 
+// forgetLocalMusicTrack drops the cached metadata of one file. Sidecar
+// changes do not touch the audio file's size or mtime, so they need this.
+func forgetLocalMusicTrack(rootAbs string, relPath string) {
+	localMusicMetaCacheMu.Lock()
+	delete(localMusicMetaCache, localMusicMetaCacheKey(rootAbs, relPath))
+	localMusicMetaCacheMu.Unlock()
+}
+
 func localMusicMetaCacheKey(rootAbs string, relPath string) string {
 	root, err := filepath.Abs(rootAbs)
 	if err != nil {
@@ -1207,35 +1354,45 @@ func cloneLocalMusicTrackSlice(tracks []*localMusicTrack) []*localMusicTrack {
 	return cloned
 }
 
+// localMusicTrackByID resolves a stable ID through the identity table. IDs
+// without an identity are old path-based IDs: they are decoded as a path, and
+// references to them are moved over to that file's current ID.
 func localMusicTrackByID(id string) (*localMusicTrack, error) {
-	rel, err := decodeLocalMusicID(id)
+	if key, rel, ok := localMusicIdentityLocation(id); ok {
+		return localMusicTrackAt(key, rel)
+	}
+	key, rel, err := decodeLocalLibraryID(id)
+	if err != nil {
+		return nil, err
+	}
+	track, err := localMusicTrackAt(key, rel)
 	if err != nil {
 		return nil, err
 	}
+	if track.ID != id {
+		relinkLocalMusicID(id, track)
+	}
+	return track, nil
+}
+
+func localMusicTrackAt(key string, rel string) (*localMusicTrack, error) {
 	rel = strings.TrimSpace(rel)
 	if rel == "" {
 		return nil, errors.New("empty local music id")
 	}
 
-	cleanRel := filepath.Clean(filepath.FromSlash(rel))
-	if filepath.IsAbs(cleanRel) || cleanRel == "." || cleanRel == ".." || strings.HasPrefix(cleanRel, ".."+string(filepath.Separator)) {
-		return nil, errors.New("invalid local music path")
-	}
-
-	rootAbs, err := filepath.Abs(localMusicDownloadDir())
-	if err != nil {
-		return nil, err
+	root, ok := localLibraryRootByKey(key)
+	if !ok {
+		return nil, errors.New("local library root is not configured")
 	}
-	audioPath := filepath.Join(rootAbs, cleanRel)
-	absPath, err := filepath.Abs(audioPath)
+	absPath, err := root.resolve(rel)
 	if err != nil {
 		return nil, err
 	}
-	if !isPathInside(rootAbs, absPath) {
-		return nil, errors.New("local music path escaped root")
+	if !root.allows(rel) {
+		return nil, errors.New("local music path is excluded from the library")
 	}
-
-	return buildLocalMusicTrack(rootAbs, absPath)
+	return buildLocalMusicTrack(root, absPath)
 }
 
 func encodeLocalMusicID(relPath string) string {
@@ -1258,21 +1415,22 @@ func isPathInside(rootAbs string, targetAbs string) bool {
 	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) && !filepath.IsAbs(rel)
 }
 
-func saveUploadedLocalMusic(file *multipart.FileHeader) (*localMusicTrack, error) {
+// saveUploadedLocalMusic stores an upload in the given writable root; an
+// empty key means the download directory.
+func saveUploadedLocalMusic(file *multipart.FileHeader, rootKey string) (*localMusicTrack, error) {
 	filename, err := sanitizeLocalMusicUploadName(file.Filename)
 	if err != nil {
 		return nil, err
 	}
 
-	dir := localMusicDownloadDir()
-	if err := os.MkdirAll(dir, 0755); err != nil {
+	root, err := writableLocalLibraryRoot(rootKey)
+	if err != nil {
 		return nil, err
 	}
-	rootAbs, err := filepath.Abs(dir)
-	if err != nil {
+	if err := os.MkdirAll(root.Abs, 0755); err != nil {
 		return nil, err
 	}
-	dstPath := uniqueLocalMusicPath(rootAbs, filename)
+	dstPath := uniqueLocalMusicPath(root.Abs, filename)
 
 	src, err := file.Open()
 	if err != nil {
@@ -1295,7 +1453,7 @@ func saveUploadedLocalMusic(file *multipart.FileHeader) (*localMusicTrack, error
 		return nil, closeErr
 	}
 
-	track, err := buildLocalMusicTrack(rootAbs, dstPath)
+	track, err := buildLocalMusicTrack(root, dstPath)
 	if err == nil {
 		invalidateLocalMusicScanCache()
 	}
@@ -1320,15 +1478,24 @@ func sanitizeLocalMusicUploadName(name string) (string, error) {
 }
 
 func uniqueLocalMusicPath(dir string, filename string) string {
+	return uniqueLocalMusicPathFunc(dir, filename, func(candidate string) bool {
+		_, err := os.Stat(candidate)
+		return !os.IsNotExist(err)
+	})
+}
+
+// uniqueLocalMusicPathFunc appends " (n)" to filename until taken reports
+// the path as free.
+func uniqueLocalMusicPathFunc(dir string, filename string, taken func(string) bool) string {
 	ext := filepath.Ext(filename)
 	base := strings.TrimSuffix(filename, ext)
 	candidate := filepath.Join(dir, filename)
-	if _, err := os.Stat(candidate); os.IsNotExist(err) {
+	if !taken(candidate) {
 		return candidate
 	}
 	for i := 1; ; i++ {
 		candidate = filepath.Join(dir, fmt.Sprintf("%s (%d)%s", base, i, ext))
-		if _, err := os.Stat(candidate); os.IsNotExist(err) {
+		if !taken(candidate) {
 			return candidate
 		}
 	}
@@ -1340,6 +1507,8 @@ type localProbeResult struct {
 	Title    string
 	Artist   string
 	Album    string
+	// AlbumArtist 只在标签库没读到时补上。
+	AlbumArtist string
 }
 
 func probeLocalMusicTrack(track *localMusicTrack) (*localProbeResult, error) {
@@ -1376,11 +1545,12 @@ func probeLocalMusicTrack(track *localMusicTrack) (*localProbeResult, error) {
 	}
 
 	result := &localProbeResult{
-		Duration: secondsFromProbe(payload.Format.Duration),
-		Bitrate:  kbpsFromProbe(payload.Format.BitRate),
-		Title:    probeTag(payload.Format.Tags, "title"),
-		Artist:   probeTag(payload.Format.Tags, "artist"),
-		Album:    probeTag(payload.Format.Tags, "album"),
+		Duration:    secondsFromProbe(payload.Format.Duration),
+		Bitrate:     kbpsFromProbe(payload.Format.BitRate),
+		Title:       probeTag(payload.Format.Tags, "title"),
+		Artist:      probeTag(payload.Format.Tags, "artist"),
+		Album:       probeTag(payload.Format.Tags, "album"),
+		AlbumArtist: probeTag(payload.Format.Tags, "album_artist"),
 	}
 
 	for _, stream := range payload.Streams {
@@ -1402,6 +1572,9 @@ func probeLocalMusicTrack(track *localMusicTrack) (*localProbeResult, error) {
 		if result.Album == "" {
 			result.Album = probeTag(stream.Tags, "album")
 		}
+		if result.AlbumArtist == "" {
+			result.AlbumArtist = probeTag(stream.Tags, "album_artist")
+		}
 		break
 	}
 
@@ -1434,6 +1607,9 @@ func applyLocalProbeResult(track *localMusicTrack, probe *localProbeResult) {
 		track.Extra["album"] = probe.Album
 		track.Missing = removeString(track.Missing, "album")
 	}
+	if probe.AlbumArtist != "" && track.AlbumArtist == "" {
+		track.AlbumArtist = probe.AlbumArtist
+	}
 	if probe.Bitrate > 0 {
 		track.Extra["bitrate"] = strconv.Itoa(probe.Bitrate)
 	}
@@ -1687,8 +1863,8 @@ func inspectLocalMusicFile(id string, duration string) (gin.H, error) {
 	if probe, err := probeLocalMusicTrack(track); err == nil && probe != nil {
 		applyLocalProbeResult(track, probe)
 	}
-	if rootAbs, err := filepath.Abs(localMusicDownloadDir()); err == nil {
-		cacheLocalMusicTrack(rootAbs, track)
+	if root, ok := localLibraryRootByKey(track.Root); ok {
+		cacheLocalMusicTrack(root.Abs, track)
 	}
 
 	bitrate := "-"
@@ -1743,12 +1919,16 @@ func deleteLocalMusicTrack(id string) error {
 	if err != nil {
 		return errors.New("本地音乐不存在或已不在下载目录内")
 	}
+	if track.ReadOnly {
+		return errLocalLibraryReadOnly
+	}
 	// 硬删除：删磁盘文件 + 删索引行。收藏歌单里的引用条目保留，
 	// 之后在歌单详情页会显示为失效，可换源到在线源。
 	if err := os.Remove(track.absPath); err != nil {
 		return err
 	}
 	deleteLocalMusicIndexRow(track.ID)
+	forgetLocalMusicIdentity(track.ID)
 	invalidateLocalMusicScanCache()
 	return nil
 }
@@ -1788,6 +1968,8 @@ func localAudioMimeByExt(ext string) string {
 		return "audio/aac"
 	case "wav":
 		return "audio/wav"
+	case "opus":
+		return "audio/ogg"
 	default:
 		return core.AudioMimeByExt(ext)
 	}
@@ -0,0 +1,452 @@
+package web
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// 本地曲库浏览：按歌手、专辑、文件夹聚合，以及“最近添加 / 从未播放”智能列表。
+// 全部直接在 local_music_index 上做 SQL 聚合与分页，不在内存里展开整个曲库。
+
+const (
+	localMusicBrowseDefaultLimit = 100
+	localMusicBrowseMaxLimit     = 500
+
+	// 专辑按 专辑名 + 专辑艺术家 分组，没有专辑艺术家标签时退回歌手。
+	localMusicAlbumArtistExpr = "CASE WHEN album_artist <> '' THEN album_artist ELSE artist END"
+)
+
+var errLocalMusicBrowseBadPath = errors.New("invalid folder path")
+
+// LocalMusicPlay 记录本地歌曲的播放次数。与索引行分表保存，
+// 重扫或重建索引不会清掉播放记录。
+type LocalMusicPlay struct {
+	TrackID      string    `gorm:"column:track_id;primaryKey"`
+	PlayCount    int       `gorm:"column:play_count;not null;default:0"`
+	LastPlayedAt time.Time `gorm:"column:last_played_at;index"`
+}
+
+func (LocalMusicPlay) TableName() string { return "local_music_plays" }
+
+type localMusicArtistGroup struct {
+	Name       string `json:"name"`
+	TrackCount int    `json:"track_count"`
+	AlbumCount int    `json:"album_count"`
+	Cover      string `json:"cover"`
+}
+
+type localMusicAlbumGroup struct {
+	Album       string    `json:"album"`
+	AlbumArtist string    `json:"album_artist"`
+	TrackCount  int       `json:"track_count"`
+	Duration    int       `json:"duration"`
+	Cover       string    `json:"cover"`
+	AddedAt     time.Time `json:"added_at"`
+}
+
+type localMusicFolderEntry struct {
+	Name       string `json:"name"`
+	Root       string `json:"root"`
+	Path       string `json:"path"`
+	TrackCount int    `json:"track_count"`
+}
+
+// localMusicTrackFilter selects the tracks of one browse view.
+type localMusicTrackFilter struct {
+	Artist      *string
+	Album       *string
+	AlbumArtist *string
+	Smart       string
+}
+
+func registerLocalMusicBrowseRoutes(api *gin.RouterGroup) {
+	api.GET("/local_music/browse/artists", func(c *gin.Context) {
+		offset, limit := localMusicBrowseRange(c)
+		groups, total, err := localMusicArtistGroups(c.Query("q"), c.Query("sort"), offset, limit)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, localMusicBrowsePage(gin.H{"items": groups}, len(groups), total, offset, limit))
+	})
+
+	api.GET("/local_music/browse/albums", func(c *gin.Context) {
+		offset, limit := localMusicBrowseRange(c)
+		groups, total, err := localMusicAlbumGroups(optionalQuery(c, "artist"), c.Query("q"), offset, limit)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, localMusicBrowsePage(gin.H{"items": groups}, len(groups), total, offset, limit))
+	})
+
+	api.GET("/local_music/browse/tracks", func(c *gin.Context) {
+		filter := localMusicTrackFilter{
+			Artist:      optionalQuery(c, "artist"),
+			Album:       optionalQuery(c, "album"),
+			AlbumArtist: optionalQuery(c, "album_artist"),
+			Smart:       c.Query("smart"),
+		}
+		if filter.Smart != "" && filter.Smart != "recent" && filter.Smart != "never_played" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "unknown smart list"})
+			return
+		}
+		offset, limit := localMusicBrowseRange(c)
+		tracks, total, err := localMusicBrowseTracks(filter, offset, limit)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		markAlreadyAddedLocalTracks(c.Query("collection_id"), tracks)
+		c.JSON(http.StatusOK, localMusicBrowsePage(gin.H{"tracks": tracks}, len(tracks), total, offset, limit))
+	})
+
+	api.GET("/local_music/browse/folders", func(c *gin.Context) {
+		offset, limit := localMusicBrowseRange(c)
+		rootKey, hasRoot := c.GetQuery("root")
+		if !hasRoot {
+			if roots := localLibraryRoots(); len(roots) > 1 {
+				c.JSON(http.StatusOK, localMusicBrowsePage(gin.H{
+					"folders": localMusicRootFolders(roots),
+					"tracks":  []*localMusicTrack{},
+				}, 0, 0, offset, limit))
+				return
+			}
+		}
+		folder, err := cleanLocalMusicFolderPath(c.Query("path"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		folders, tracks, total, err := localMusicFolderListing(rootKey, folder, offset, limit)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		page := localMusicBrowsePage(gin.H{"folders": folders, "tracks": tracks}, len(tracks), total, offset, limit)
+		page["root"] = rootKey
+		page["path"] = folder
+		c.JSON(http.StatusOK, page)
+	})
+
+	api.POST("/local_music/played", requireSameOriginWrite, func(c *gin.Context) {
+		var req struct {
+			ID string `json:"id"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil || strings.TrimSpace(req.ID) == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "id is required"})
+			return
+		}
+		if err := recordLocalMusicPlay(req.ID, time.Now()); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "ok"})
+	})
+}
+
+// optionalQuery distinguishes "?artist=" (the empty artist) from no filter.
+func optionalQuery(c *gin.Context, key string) *string {
+	if value, ok := c.GetQuery(key); ok {
+		return &value
+	}
+	return nil
+}
+
+func localMusicBrowseRange(c *gin.Context) (int, int) {
+	offset := parseLocalMusicRangeInt(c.Query("offset"), 0)
+	limit := parseLocalMusicRangeInt(c.Query("limit"), localMusicBrowseDefaultLimit)
+	if limit <= 0 {
+		limit = localMusicBrowseDefaultLimit
+	}
+	if limit > localMusicBrowseMaxLimit {
+		limit = localMusicBrowseMaxLimit
+	}
+	return offset, limit
+}
+
+func localMusicBrowsePage(body gin.H, count int, total int, offset int, limit int) gin.H {
+	body["total"] = total
+	body["offset"] = offset
+	body["limit"] = limit
+	body["has_more"] = offset+count < total
+	return body
+}
+
+func localMusicCoverURL(id string) string {
+	if id == "" {
+		return ""
+	}
+	return RoutePrefix + "/local_music/cover?id=" + url.QueryEscape(id)
+}
+
+func localMusicArtistGroups(keyword string, sort string, offset int, limit int) ([]localMusicArtistGroup, int, error) {
+	groups := []localMusicArtistGroup{}
+	if db == nil {
+		return groups, 0, nil
+	}
+	query := db.Model(&LocalMusicIndex{})
+	if keyword = strings.TrimSpace(keyword); keyword != "" {
+		query = query.Where("artist LIKE ?", "%"+keyword+"%")
+	}
+	var total int64
+	if err := query.Session(&gorm.Session{}).Distinct("artist").Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	order := "artist COLLATE NOCASE"
+	if sort == "tracks" {
+		order = "track_count DESC, " + order
+	}
+	var rows []struct {
+		Artist     string
+		TrackCount int
+		AlbumCount int
+		CoverID    string
+	}
+	err := query.
+		Select("artist, COUNT(*) AS track_count, COUNT(DISTINCT NULLIF(album, '')) AS album_count, " +
+			"COALESCE(MAX(CASE WHEN has_cover THEN id END), '') AS cover_id").
+		Group("artist").
+		Order(order).
+		Offset(offset).
+		Limit(limit).
+		Scan(&rows).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	for _, row := range rows {
+		groups = append(groups, localMusicArtistGroup{
+			Name:       row.Artist,
+			TrackCount: row.TrackCount,
+			AlbumCount: row.AlbumCount,
+			Cover:      localMusicCoverURL(row.CoverID),
+		})
+	}
+	return groups, int(total), nil
+}
+
+// localMusicAlbumGroups lists albums, optionally only those where artist is
+// the track artist or the album artist. Tracks without an album tag are left
+// to the artist and folder views.
+func localMusicAlbumGroups(artist *string, keyword string, offset int, limit int) ([]localMusicAlbumGroup, int, error) {
+	groups := []localMusicAlbumGroup{}
+	if db == nil {
+		return groups, 0, nil
+	}
+	query := db.Model(&LocalMusicIndex{}).Where("album <> ''")
+	if artist != nil {
+		query = query.Where("artist = ? OR album_artist = ?", *artist, *artist)
+	}
+	if keyword = strings.TrimSpace(keyword); keyword != "" {
+		query = query.Where("album LIKE ?", "%"+keyword+"%")
+	}
+
+	var total int64
+	err := db.Table("(?) AS albums", query.Session(&gorm.Session{}).
+		Select("album, "+localMusicAlbumArtistExpr+" AS group_artist").
+		Group("album, group_artist")).
+		Count(&total).Error
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var rows []struct {
+		Album       string
+		GroupArtist string
+		TrackCount  int
+		Duration    int
+		CoverID     string
+		AddedAt     string
+	}
+	err = query.
+		Select("album, " + localMusicAlbumArtistExpr + " AS group_artist, COUNT(*) AS track_count, " +
+			"COALESCE(SUM(duration), 0) AS duration, COALESCE(MAX(CASE WHEN has_cover THEN id END), '') AS cover_id, " +
+			"MAX(added_at) AS added_at").
+		Group("album, group_artist").
+		Order("album COLLATE NOCASE, group_artist COLLATE NOCASE").
+		Offset(offset).
+		Limit(limit).
+		Scan(&rows).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	for _, row := range rows {
+		groups = append(groups, localMusicAlbumGroup{
+			Album:       row.Album,
+			AlbumArtist: row.GroupArtist,
+			TrackCount:  row.TrackCount,
+			Duration:    row.Duration,
+			Cover:       localMusicCoverURL(row.CoverID),
+			AddedAt:     parseLocalMusicSQLTime(row.AddedAt),
+		})
+	}
+	return groups, int(total), nil
+}
+
+// parseLocalMusicSQLTime reads a time produced by an aggregate, which the
+// SQLite driver hands back as text rather than a time.Time.
+func parseLocalMusicSQLTime(raw string) time.Time {
+	for _, layout := range []string{"2006-01-02 15:04:05.999999999-07:00", time.RFC3339Nano, "2006-01-02 15:04:05"} {
+		if t, err := time.Parse(layout, raw); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+func localMusicBrowseTracks(filter localMusicTrackFilter, offset int, limit int) ([]*localMusicTrack, int, error) {
+	if db == nil {
+		return []*localMusicTrack{}, 0, nil
+	}
+	query := db.Model(&LocalMusicIndex{})
+	order := "local_music_index.album COLLATE NOCASE, local_music_index.rel_path"
+	if filter.Artist != nil {
+		query = query.Where("local_music_index.artist = ?", *filter.Artist)
+	}
+	if filter.Album != nil {
+		query = query.Where("local_music_index.album = ?", *filter.Album)
+		order = "local_music_index.rel_path"
+	}
+	if filter.AlbumArtist != nil {
+		query = query.Where(localMusicAlbumArtistExpr+" = ?", *filter.AlbumArtist)
+	}
+	switch filter.Smart {
+	case "recent":
+		order = "local_music_index.added_at DESC, local_music_index.rel_path"
+	case "never_played":
+		query = query.
+			Joins("LEFT JOIN local_music_plays ON local_music_plays.track_id = local_music_index.id").
+			Where("local_music_plays.track_id IS NULL")
+		order = "local_music_index.added_at DESC, local_music_index.rel_path"
+	}
+	return localMusicIndexTrackPage(query, order, offset, limit)
+}
+
+// localMusicIndexTrackPage runs a filtered index query page and prunes rows
+// whose file has disappeared, the same way the plain list does.
+func localMusicIndexTrackPage(query *gorm.DB, order string, offset int, limit int) ([]*localMusicTrack, int, error) {
+	var total int64
+	if err := query.Session(&gorm.Session{}).Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	var rows []LocalMusicIndex
+	if err := query.Select("local_music_index.*").Order(order).Offset(offset).Limit(limit).Find(&rows).Error; err != nil {
+		return nil, 0, err
+	}
+	tracks, missingIDs := localMusicIndexRowsToTracks(rows)
+	if len(missingIDs) > 0 {
+		if err := db.Where("id IN ?", missingIDs).Delete(&LocalMusicIndex{}).Error; err != nil {
+			return nil, 0, err
+		}
+		total -= int64(len(missingIDs))
+	}
+	return tracks, int(total), nil
+}
+
+// cleanLocalMusicFolderPath normalises a slash-separated folder below a
+// library root; "" is the root itself.
+func cleanLocalMusicFolderPath(raw string) (string, error) {
+	raw = strings.Trim(strings.ReplaceAll(strings.TrimSpace(raw), "\\", "/"), "/")
+	if raw == "" {
+		return "", nil
+	}
+	for _, part := range strings.Split(raw, "/") {
+		if part == ".." {
+			return "", errLocalMusicBrowseBadPath
+		}
+	}
+	cleaned := path.Clean(raw)
+	if cleaned == "." {
+		return "", nil
+	}
+	return cleaned, nil
+}
+
+// localMusicFolderListing returns the direct subfolders of folder (with the
+// number of tracks below each) and one page of the tracks directly inside it.
+// SQLite's substr counts characters, so prefix lengths are rune counts.
+func localMusicFolderListing(rootKey string, folder string, offset int, limit int) ([]localMusicFolderEntry, []*localMusicTrack, int, error) {
+	folders := []localMusicFolderEntry{}
+	if db == nil {
+		return folders, []*localMusicTrack{}, 0, nil
+	}
+	prefix := ""
+	if folder != "" {
+		prefix = folder + "/"
+	}
+	prefixLen := utf8.RuneCountInString(prefix)
+	inFolder := db.Model(&LocalMusicIndex{}).Where("root = ?", rootKey)
+	if prefixLen > 0 {
+		inFolder = inFolder.Where("substr(rel_path, 1, ?) = ?", prefixLen, prefix)
+	}
+
+	var rows []struct {
+		Name       string
+		TrackCount int
+	}
+	err := db.Table("(?) AS entries", inFolder.Session(&gorm.Session{}).Select("substr(rel_path, ?) AS rest", prefixLen+1)).
+		Select("substr(rest, 1, instr(rest, '/') - 1) AS name, COUNT(*) AS track_count").
+		Where("instr(rest, '/') > 0").
+		Group("name").
+		Order("name COLLATE NOCASE").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	for _, row := range rows {
+		folders = append(folders, localMusicFolderEntry{
+			Name:       row.Name,
+			Root:       rootKey,
+			Path:       prefix + row.Name,
+			TrackCount: row.TrackCount,
+		})
+	}
+
+	direct := inFolder.Where("instr(substr(rel_path, ?), '/') = 0", prefixLen+1)
+	tracks, total, err := localMusicIndexTrackPage(direct, "rel_path COLLATE NOCASE", offset, limit)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	return folders, tracks, total, nil
+}
+
+// localMusicRootFolders is the top of the folder tree when several library
+// roots are configured: one entry per root.
+func localMusicRootFolders(roots []localLibraryRoot) []localMusicFolderEntry {
+	counts := make(map[string]int)
+	for _, info := range localLibraryRootInfos() {
+		counts[info.Key] = int(info.Tracks)
+	}
+	folders := make([]localMusicFolderEntry, 0, len(roots))
+	for _, root := range roots {
+		folders = append(folders, localMusicFolderEntry{
+			Name:       root.Label,
+			Root:       root.Key,
+			TrackCount: counts[root.Key],
+		})
+	}
+	return folders
+}
+
+func recordLocalMusicPlay(id string, playedAt time.Time) error {
+	if db == nil {
+		return nil
+	}
+	return db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "track_id"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"play_count":     gorm.Expr("play_count + 1"),
+			"last_played_at": playedAt,
+		}),
+	}).Create(&LocalMusicPlay{TrackID: id, PlayCount: 1, LastPlayedAt: playedAt}).Error
+}
@@ -0,0 +1,204 @@
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/guohuiyuan/go-music-dl/core"
+)
+
+func seedLocalMusicBrowseLibrary(t *testing.T) string {
+	t.Helper()
+	initCollectionDBForTest(t)
+	downloadDir := t.TempDir()
+	withLocalMusicDownloadDir(t, downloadDir)
+
+	base := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, track := range []*localMusicTrack{
+		{RelPath: "Jay/叶惠美/01 晴天.mp3", Name: "晴天", Artist: "周杰伦", Album: "叶惠美"},
+		{RelPath: "Jay/叶惠美/02 以父之名.mp3", Name: "以父之名", Artist: "周杰伦", Album: "叶惠美", Extra: map[string]string{"cover": "true"}},
+		{RelPath: "Hits/a.mp3", Name: "Song A", Artist: "Alpha", Album: "Hits", AlbumArtist: "Various Artists"},
+		{RelPath: "Hits/b.mp3", Name: "Song B", Artist: "Beta", Album: "Hits", AlbumArtist: "Various Artists"},
+		{RelPath: "loose.mp3", Name: "Loose", Artist: "Beta"},
+	} {
+		track.ID = encodeLocalMusicID(track.RelPath)
+		track.modTime = base.Add(time.Duration(i) * time.Hour)
+		writeLocalMusicFileForTest(t, filepath.Join(downloadDir, filepath.FromSlash(track.RelPath)), "audio")
+		upsertLocalMusicIndexRow(track)
+	}
+	return downloadDir
+}
+
+func getLocalMusicBrowseJSON(t *testing.T, target string, out interface{}) int {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	newLocalMusicTestRouter().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, RoutePrefix+target, nil))
+	if rec.Code == http.StatusOK && out != nil {
+		if err := json.Unmarshal(rec.Body.Bytes(), out); err != nil {
+			t.Fatalf("decode %s: %v", target, err)
+		}
+	}
+	return rec.Code
+}
+
+func TestLocalMusicBrowseArtistsAndAlbums(t *testing.T) {
+	seedLocalMusicBrowseLibrary(t)
+
+	var artists struct {
+		Items []localMusicArtistGroup `json:"items"`
+		Total int                     `json:"total"`
+	}
+	getLocalMusicBrowseJSON(t, "/local_music/browse/artists?sort=tracks", &artists)
+	if artists.Total != 3 || len(artists.Items) != 3 {
+		t.Fatalf("artists = %+v", artists)
+	}
+	// Equal track counts fall back to name order.
+	beta, jay := artists.Items[0], artists.Items[1]
+	if beta.Name != "Beta" || beta.TrackCount != 2 || beta.AlbumCount != 1 || beta.Cover != "" {
+		t.Fatalf("first artist = %+v", beta)
+	}
+	if jay.Name != "周杰伦" || jay.TrackCount != 2 || jay.AlbumCount != 1 || jay.Cover == "" {
+		t.Fatalf("second artist = %+v, want 周杰伦 with 2 tracks, 1 album and a cover", jay)
+	}
+	if artists.Items[2].Name != "Alpha" || artists.Items[2].TrackCount != 1 {
+		t.Fatalf("third artist = %+v", artists.Items[2])
+	}
+
+	var albums struct {
+		Items []localMusicAlbumGroup `json:"items"`
+		Total int                    `json:"total"`
+	}
+	getLocalMusicBrowseJSON(t, "/local_music/browse/albums", &albums)
+	if albums.Total != 2 || len(albums.Items) != 2 {
+		t.Fatalf("albums = %+v", albums)
+	}
+	hits := albums.Items[0]
+	if hits.Album != "Hits" || hits.AlbumArtist != "Various Artists" || hits.TrackCount != 2 || hits.AddedAt.IsZero() {
+		t.Fatalf("compilation album = %+v, want one group under the album artist", hits)
+	}
+	if albums.Items[1].AlbumArtist != "周杰伦" || albums.Items[1].TrackCount != 2 {
+		t.Fatalf("album without album artist = %+v, want grouped by artist", albums.Items[1])
+	}
+
+	getLocalMusicBrowseJSON(t, "/local_music/browse/albums?artist=Beta", &albums)
+	if albums.Total != 1 || albums.Items[0].Album != "Hits" {
+		t.Fatalf("albums of Beta = %+v", albums)
+	}
+
+	var tracks struct {
+		Tracks []localMusicTrack `json:"tracks"`
+		Total  int               `json:"total"`
+	}
+	getLocalMusicBrowseJSON(t, "/local_music/browse/tracks?album=Hits&album_artist="+url.QueryEscape("Various Artists"), &tracks)
+	if tracks.Total != 2 || tracks.Tracks[0].Name != "Song A" || tracks.Tracks[1].Name != "Song B" {
+		t.Fatalf("album tracks = %+v", tracks)
+	}
+	getLocalMusicBrowseJSON(t, "/local_music/browse/tracks?artist=Beta&limit=1", &tracks)
+	if tracks.Total != 2 || len(tracks.Tracks) != 1 {
+		t.Fatalf("paged artist tracks = %+v", tracks)
+	}
+}
+
+func TestLocalMusicBrowseFolders(t *testing.T) {
+	seedLocalMusicBrowseLibrary(t)
+
+	var listing struct {
+		Folders []localMusicFolderEntry `json:"folders"`
+		Tracks  []localMusicTrack       `json:"tracks"`
+		Total   int                     `json:"total"`
+	}
+	getLocalMusicBrowseJSON(t, "/local_music/browse/folders", &listing)
+	if len(listing.Folders) != 2 || listing.Folders[0].Name != "Hits" || listing.Folders[1].Path != "Jay" || listing.Folders[1].TrackCount != 2 {
+		t.Fatalf("root folders = %+v", listing.Folders)
+	}
+	if listing.Total != 1 || listing.Tracks[0].Name != "Loose" {
+		t.Fatalf("root tracks = %+v", listing.Tracks)
+	}
+
+	getLocalMusicBrowseJSON(t, "/local_music/browse/folders?path=Jay", &listing)
+	if len(listing.Folders) != 1 || listing.Folders[0].Path != "Jay/叶惠美" || listing.Total != 0 {
+		t.Fatalf("Jay folder = %+v", listing)
+	}
+	getLocalMusicBrowseJSON(t, "/local_music/browse/folders?path="+url.QueryEscape("Jay/叶惠美/"), &listing)
+	if len(listing.Folders) != 0 || listing.Total != 2 || listing.Tracks[0].Name != "晴天" {
+		t.Fatalf("album folder = %+v", listing)
+	}
+	if code := getLocalMusicBrowseJSON(t, "/local_music/browse/folders?path=../etc", nil); code != http.StatusBadRequest {
+		t.Fatalf("escaping path status = %d, want 400", code)
+	}
+
+	withLocalLibraryRoots(t, core.LibraryRoot{Key: "nas", Label: "NAS", Path: t.TempDir()})
+	getLocalMusicBrowseJSON(t, "/local_music/browse/folders", &listing)
+	if len(listing.Folders) != 2 || listing.Folders[0].TrackCount != 5 || listing.Folders[1].Root != "nas" {
+		t.Fatalf("multi-root top level = %+v", listing.Folders)
+	}
+	getLocalMusicBrowseJSON(t, "/local_music/browse/folders?root=&path=Hits", &listing)
+	if listing.Total != 2 {
+		t.Fatalf("download root Hits folder = %+v", listing)
+	}
+}
+
+func TestLocalMusicBrowseSmartLists(t *testing.T) {
+	downloadDir := seedLocalMusicBrowseLibrary(t)
+
+	var tracks struct {
+		Tracks []localMusicTrack `json:"tracks"`
+		Total  int               `json:"total"`
+	}
+	getLocalMusicBrowseJSON(t, "/local_music/browse/tracks?smart=recent", &tracks)
+	if tracks.Total != 5 || tracks.Tracks[0].Name != "Loose" || tracks.Tracks[4].Name != "晴天" {
+		t.Fatalf("recent = %+v", tracks.Tracks)
+	}
+
+	// Rescanning a file keeps its original added time.
+	rescanned := &localMusicTrack{ID: encodeLocalMusicID("Jay/叶惠美/01 晴天.mp3"), RelPath: "Jay/叶惠美/01 晴天.mp3", Name: "晴天", Artist: "周杰伦", Album: "叶惠美", modTime: time.Now()}
+	upsertLocalMusicIndexRow(rescanned)
+	getLocalMusicBrowseJSON(t, "/local_music/browse/tracks?smart=recent", &tracks)
+	if tracks.Tracks[4].Name != "晴天" {
+		t.Fatalf("rescan moved track in recent list: %+v", tracks.Tracks)
+	}
+
+	for i := 0; i < 2; i++ {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, RoutePrefix+"/local_music/played", strings.NewReader(`{"id":"`+rescanned.ID+`"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Requested-With", "XMLHttpRequest")
+		newLocalMusicTestRouter().ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("played status = %d, body=%s", rec.Code, rec.Body.String())
+		}
+	}
+	var play LocalMusicPlay
+	if err := db.First(&play, "track_id = ?", rescanned.ID).Error; err != nil || play.PlayCount != 2 {
+		t.Fatalf("play row = %+v, %v; want 2 plays", play, err)
+	}
+
+	getLocalMusicBrowseJSON(t, "/local_music/browse/tracks?smart=never_played", &tracks)
+	if tracks.Total != 4 {
+		t.Fatalf("never played = %+v", tracks.Tracks)
+	}
+	for _, track := range tracks.Tracks {
+		if track.ID == rescanned.ID {
+			t.Fatal("played track listed as never played")
+		}
+	}
+
+	// Files deleted behind the index's back are pruned from browse pages.
+	if err := os.Remove(filepath.Join(downloadDir, "loose.mp3")); err != nil {
+		t.Fatal(err)
+	}
+	getLocalMusicBrowseJSON(t, "/local_music/browse/tracks?smart=never_played", &tracks)
+	if tracks.Total != 3 || len(tracks.Tracks) != 3 {
+		t.Fatalf("never played after delete = %+v", tracks)
+	}
+	if code := getLocalMusicBrowseJSON(t, "/local_music/browse/tracks?smart=bogus", nil); code != http.StatusBadRequest {
+		t.Fatalf("unknown smart list status = %d", code)
+	}
+}
@@ -0,0 +1,486 @@
+package web
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/guohuiyuan/go-music-dl/core"
+)
+
+// 音频指纹查重：按标签聚合会漏掉标签不一致的副本（未知歌手、错别字、繁简），
+// 也会把同名的不同录音误判成重复。这里用 core.ComputeFingerprint 给每个文件
+// 算一份 Chromaprint 兼容的指纹存进索引，按音频相似度聚类，再按格式、码率、
+// 大小建议保留哪一份；其余副本可以批量删除或移到根目录下的 .duplicates。
+
+const (
+	localMusicFingerprintDefaultThreshold = 0.85
+	localMusicFingerprintWorkers          = 2
+	// localMusicFingerprintDurationSlack 内的文件才两两比对指纹。
+	localMusicFingerprintDurationSlack = 10
+	localMusicDuplicatesDir            = ".duplicates"
+)
+
+var (
+	localMusicFingerprintFile  = core.FingerprintAudioFile
+	localMusicFingerprintReady = func() error {
+		if _, err := core.ResolveFFmpegPath(); err != nil {
+			return errors.New("计算音频指纹需要 ffmpeg")
+		}
+		return nil
+	}
+
+	localMusicFingerprintJobs = newBackgroundJobSlot[*localMusicFingerprintJob]("已有指纹计算任务在进行中")
+)
+
+type localMusicFingerprintJob struct {
+	backgroundJob
+	Computed int `json:"computed"`
+
+	ids []string
+}
+
+type localMusicDuplicateResolveRequest struct {
+	// Action 为 delete 或 move（移到所在根目录的 .duplicates 下）。
+	Action string `json:"action"`
+	Groups []struct {
+		Keep   string   `json:"keep"`
+		Remove []string `json:"remove"`
+	} `json:"groups"`
+}
+
+func registerLocalMusicFingerprintRoutes(api *gin.RouterGroup) {
+	api.GET("/local_music/fingerprints", func(c *gin.Context) {
+		total, fingerprinted := countLocalMusicFingerprints()
+		body := gin.H{"total": total, "fingerprinted": fingerprinted, "job": nil}
+		if err := localMusicFingerprintReady(); err != nil {
+			body["error"] = err.Error()
+		}
+		if job := localMusicFingerprintJobs.get(); job != nil {
+			body["job"] = job.snapshot()
+		}
+		c.JSON(http.StatusOK, body)
+	})
+
+	api.POST("/local_music/fingerprints", requireSameOriginWrite, func(c *gin.Context) {
+		var req struct {
+			IDs []string `json:"ids"`
+		}
+		if c.Request.ContentLength > 0 {
+			if err := c.ShouldBindJSON(&req); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+				return
+			}
+		}
+		job, err := startLocalMusicFingerprintJob(req.IDs)
+		if err != nil {
+			localMusicFingerprintJobs.startError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "started", "job": job.snapshot()})
+	})
+
+	api.POST("/local_music/fingerprints/cancel", requireSameOriginWrite, func(c *gin.Context) {
+		job := localMusicFingerprintJobs.get()
+		if job == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "没有指纹计算任务"})
+			return
+		}
+		job.cancel()
+		c.JSON(http.StatusOK, gin.H{"status": "ok"})
+	})
+
+	api.POST("/local_music/duplicates/resolve", requireSameOriginWrite, func(c *gin.Context) {
+		var req localMusicDuplicateResolveRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+			return
+		}
+		resolved, failed, err := resolveLocalMusicDuplicates(req)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "ok", "resolved": resolved, "failed": failed})
+	})
+}
+
+// localMusicFingerprintKey ties a fingerprint to the file version it was
+// computed from.
+func localMusicFingerprintKey(size int64, modTime time.Time) string {
+	return strconv.FormatInt(size, 10) + ":" + strconv.FormatInt(modTime.UnixNano(), 10)
+}
+
+func (row *LocalMusicIndex) hasFreshFingerprint() bool {
+	return row.Fingerprint != "" && row.FingerprintKey == localMusicFingerprintKey(row.Size, row.ModTime)
+}
+
+func countLocalMusicFingerprints() (total int, fingerprinted int) {
+	if db == nil {
+		return 0, 0
+	}
+	var rows []LocalMusicIndex
+	if err := db.Select("id", "size", "mod_time", "fingerprint_key").Find(&rows).Error; err != nil {
+		return 0, 0
+	}
+	for i := range rows {
+		// 只比对 key，不把整份指纹读出来。
+		if rows[i].FingerprintKey != "" && rows[i].FingerprintKey == localMusicFingerprintKey(rows[i].Size, rows[i].ModTime) {
+			fingerprinted++
+		}
+	}
+	return len(rows), fingerprinted
+}
+
+// startLocalMusicFingerprintJob fingerprints ids, or every indexed file
+// without a fresh fingerprint when ids is empty.
+func startLocalMusicFingerprintJob(ids []string) (*localMusicFingerprintJob, error) {
+	if db == nil {
+		return nil, errors.New("曲库索引不可用")
+	}
+	if err := localMusicFingerprintReady(); err != nil {
+		return nil, err
+	}
+	targets := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if id = strings.TrimSpace(id); id != "" && !containsString(targets, id) {
+			targets = append(targets, id)
+		}
+	}
+	if len(targets) == 0 {
+		var rows []LocalMusicIndex
+		if err := db.Select("id", "size", "mod_time", "fingerprint_key").Order("rel_path").Find(&rows).Error; err != nil {
+			return nil, err
+		}
+		for i := range rows {
+			if rows[i].FingerprintKey != localMusicFingerprintKey(rows[i].Size, rows[i].ModTime) {
+				targets = append(targets, rows[i].ID)
+			}
+		}
+	}
+	if len(targets) == 0 {
+		return nil, errors.New("所有本地音乐都已有指纹")
+	}
+
+	job := &localMusicFingerprintJob{
+		backgroundJob: newBackgroundJob("running", len(targets)),
+		ids:           targets,
+	}
+	if err := localMusicFingerprintJobs.install(job); err != nil {
+		return nil, err
+	}
+	go job.run()
+	return job, nil
+}
+
+func (job *localMusicFingerprintJob) snapshot() *localMusicFingerprintJob {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	return &localMusicFingerprintJob{backgroundJob: job.snapshotLocked(), Computed: job.Computed}
+}
+
+func (job *localMusicFingerprintJob) run() {
+	job.process(localMusicFingerprintWorkers, func(index int) func() {
+		err := fingerprintLocalMusicTrack(job.ids[index])
+		return func() {
+			if err != nil {
+				job.failLocked(err)
+			} else {
+				job.Computed++
+			}
+		}
+	})
+	job.finish(nil)
+}
+
+func fingerprintLocalMusicTrack(id string) error {
+	track, err := localMusicTrackByID(id)
+	if err != nil {
+		return fmt.Errorf("%s: 本地音乐不存在", id)
+	}
+	info, err := os.Stat(track.absPath)
+	if err != nil {
+		return err
+	}
+	items, err := localMusicFingerprintFile(track.absPath)
+	if err != nil {
+		return fmt.Errorf("%s: %w", track.RelPath, err)
+	}
+	localMusicIndexWriteMu.Lock()
+	defer localMusicIndexWriteMu.Unlock()
+	return db.Model(&LocalMusicIndex{}).Where("id = ?", track.ID).Updates(map[string]interface{}{
+		"fingerprint":     core.EncodeFingerprint(items),
+		"fingerprint_key": localMusicFingerprintKey(info.Size(), info.ModTime()),
+	}).Error
+}
+
+type localMusicFingerprintEntry struct {
+	row   LocalMusicIndex
+	items []uint32
+}
+
+// serveLocalMusicFingerprintDuplicates groups fingerprinted files whose audio
+// is at least threshold similar, regardless of their tags.
+func serveLocalMusicFingerprintDuplicates(c *gin.Context, page, pageSize int) {
+	threshold, err := strconv.ParseFloat(c.Query("threshold"), 64)
+	if err != nil || threshold <= 0 || threshold > 1 {
+		threshold = localMusicFingerprintDefaultThreshold
+	}
+	groups, err := localMusicFingerprintDuplicateGroups(threshold)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	total, fingerprinted := countLocalMusicFingerprints()
+
+	totalPages := 1
+	if len(groups) > 0 {
+		totalPages = (len(groups) + pageSize - 1) / pageSize
+	}
+	if page > totalPages {
+		page = totalPages
+	}
+	start := (page - 1) * pageSize
+	end := start + pageSize
+	if end > len(groups) {
+		end = len(groups)
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"mode":          "fingerprint",
+		"threshold":     threshold,
+		"groups":        groups[start:end],
+		"page":          page,
+		"page_size":     pageSize,
+		"total":         len(groups),
+		"total_pages":   totalPages,
+		"tracks":        total,
+		"fingerprinted": fingerprinted,
+	})
+}
+
+func localMusicFingerprintDuplicateGroups(threshold float64) ([]localMusicDupGroup, error) {
+	var rows []LocalMusicIndex
+	if err := db.Select("id", "root", "rel_path", "name", "artist", "duration", "size", "ext", "mod_time", "fingerprint", "fingerprint_key").
+		Where("fingerprint <> ''").
+		Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	entries := make([]localMusicFingerprintEntry, 0, len(rows))
+	for _, row := range rows {
+		if !row.hasFreshFingerprint() {
+			continue
+		}
+		items, err := core.DecodeFingerprint(row.Fingerprint)
+		if err != nil || len(items) == 0 {
+			continue
+		}
+		row.Fingerprint = ""
+		entries = append(entries, localMusicFingerprintEntry{row: row, items: items})
+	}
+	// 按时长排序后只和时长相近的比；时长未知（0）的排在最前，和所有文件比。
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].row.Duration != entries[j].row.Duration {
+			return entries[i].row.Duration < entries[j].row.Duration
+		}
+		return entries[i].row.ID < entries[j].row.ID
+	})
+
+	parent := make([]int, len(entries))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+	for i := range entries {
+		for j := i + 1; j < len(entries); j++ {
+			if entries[i].row.Duration > 0 && entries[j].row.Duration-entries[i].row.Duration > localMusicFingerprintDurationSlack {
+				break
+			}
+			if find(i) == find(j) {
+				continue
+			}
+			if core.FingerprintSimilarity(entries[i].items, entries[j].items) >= threshold {
+				parent[find(j)] = find(i)
+			}
+		}
+	}
+
+	clusters := make(map[int][]int)
+	for i := range entries {
+		root := find(i)
+		clusters[root] = append(clusters[root], i)
+	}
+	roots := localLibraryRootLabels()
+	groups := make([]localMusicDupGroup, 0)
+	for _, members := range clusters {
+		if len(members) < 2 {
+			continue
+		}
+		items := make([]localMusicDupItem, 0, len(members))
+		prints := make(map[string][]uint32, len(members))
+		for _, idx := range members {
+			row := &entries[idx].row
+			absPath, ok := localMusicIndexRowPath(roots, row)
+			if !ok {
+				continue
+			}
+			if info, err := os.Stat(absPath); err != nil || info.IsDir() {
+				continue
+			}
+			prints[row.ID] = entries[idx].items
+			items = append(items, localMusicDupItem{
+				ID:        row.ID,
+				Name:      row.Name,
+				Artist:    row.Artist,
+				Size:      row.Size,
+				Duration:  row.Duration,
+				Ext:       row.Ext,
+				RelPath:   row.RelPath,
+				Root:      row.Root,
+				RootLabel: roots[row.Root].Label,
+				ReadOnly:  roots[row.Root].ReadOnly,
+				Bitrate:   estimateLocalMusicBitrate(row.Size, row.Duration),
+			})
+		}
+		if len(items) < 2 {
+			continue
+		}
+		keep := markLocalMusicDuplicateKeep(items)
+		for i := range items {
+			items[i].Similarity = core.FingerprintSimilarity(prints[keep], prints[items[i].ID])
+		}
+		groups = append(groups, localMusicDupGroup{Name: items[0].Name, Artist: items[0].Artist, Keep: keep, Songs: items})
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		if len(groups[i].Songs) != len(groups[j].Songs) {
+			return len(groups[i].Songs) > len(groups[j].Songs)
+		}
+		if groups[i].Name != groups[j].Name {
+			return groups[i].Name < groups[j].Name
+		}
+		return groups[i].Keep < groups[j].Keep
+	})
+	return groups, nil
+}
+
+// estimateLocalMusicBitrate returns the average kbps, or 0 when the duration
+// is unknown.
+func estimateLocalMusicBitrate(size int64, duration int) int {
+	if size <= 0 || duration <= 0 {
+		return 0
+	}
+	return int(size * 8 / int64(duration) / 1000)
+}
+
+func isLosslessAudioExt(ext string) bool {
+	switch strings.ToLower(strings.TrimPrefix(ext, ".")) {
+	case "flac", "wav", "ape", "alac", "aiff":
+		return true
+	}
+	return false
+}
+
+// betterLocalMusicCopy reports whether a should be kept over b: lossless
+// first, then the higher bitrate, then the larger file.
+func betterLocalMusicCopy(a, b localMusicDupItem) bool {
+	if la, lb := isLosslessAudioExt(a.Ext), isLosslessAudioExt(b.Ext); la != lb {
+		return la
+	}
+	if a.Bitrate != b.Bitrate {
+		return a.Bitrate > b.Bitrate
+	}
+	if a.Size != b.Size {
+		return a.Size > b.Size
+	}
+	return a.RelPath < b.RelPath
+}
+
+// markLocalMusicDuplicateKeep flags and returns the copy worth keeping.
+func markLocalMusicDuplicateKeep(items []localMusicDupItem) string {
+	best := 0
+	for i := 1; i < len(items); i++ {
+		if betterLocalMusicCopy(items[i], items[best]) {
+			best = i
+		}
+	}
+	for i := range items {
+		items[i].Keep = i == best
+	}
+	return items[best].ID
+}
+
+func resolveLocalMusicDuplicates(req localMusicDuplicateResolveRequest) (int, []localMusicTagFailure, error) {
+	action := strings.TrimSpace(req.Action)
+	if action != "delete" && action != "move" {
+		return 0, nil, errors.New("action 只能是 delete 或 move")
+	}
+	if len(req.Groups) == 0 {
+		return 0, nil, errors.New("没有要处理的重复组")
+	}
+	resolved := 0
+	failed := make([]localMusicTagFailure, 0)
+	for _, group := range req.Groups {
+		keep := strings.TrimSpace(group.Keep)
+		if keep == "" {
+			return 0, nil, errors.New("每组都需要指定保留的文件")
+		}
+		for _, id := range group.Remove {
+			if id = strings.TrimSpace(id); id == "" || id == keep {
+				continue
+			}
+			var err error
+			if action == "delete" {
+				err = deleteLocalMusicTrack(id)
+			} else {
+				err = moveLocalMusicDuplicate(id)
+			}
+			if err != nil {
+				failed = append(failed, localMusicTagFailure{ID: id, Error: err.Error()})
+				continue
+			}
+			resolved++
+		}
+	}
+	return resolved, failed, nil
+}
+
+// moveLocalMusicDuplicate moves a file into its root's .duplicates folder,
+// keeping the relative path. Dot folders are never scanned.
+func moveLocalMusicDuplicate(id string) error {
+	track, err := localMusicTrackByID(id)
+	if err != nil {
+		return errors.New("本地音乐不存在或已不在下载目录内")
+	}
+	if track.ReadOnly {
+		return errLocalLibraryReadOnly
+	}
+	root, ok := localLibraryRootByKey(track.Root)
+	if !ok {
+		return errors.New("曲库根目录不存在")
+	}
+	dest := filepath.Join(root.Abs, localMusicDuplicatesDir, filepath.FromSlash(track.RelPath))
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+	dest = uniqueLocalMusicPath(filepath.Dir(dest), filepath.Base(dest))
+	if err := os.Rename(track.absPath, dest); err != nil {
+		return err
+	}
+	forgetLocalMusicTrack(root.Abs, track.RelPath)
+	deleteLocalMusicIndexRow(track.ID)
+	forgetLocalMusicIdentity(track.ID)
+	invalidateLocalMusicScanCache()
+	return nil
+}
@@ -0,0 +1,191 @@
+package web
+
+import (
+	"encoding/json"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/guohuiyuan/go-music-dl/core"
+)
+
+func withLocalMusicFingerprintTestFiles(t *testing.T, prints map[string][]uint32) {
+	t.Helper()
+	origFile, origReady := localMusicFingerprintFile, localMusicFingerprintReady
+	localMusicFingerprintReady = func() error { return nil }
+	localMusicFingerprintFile = func(path string) ([]uint32, error) {
+		items, ok := prints[filepath.Base(path)]
+		if !ok {
+			return nil, core.ErrFingerprintTooShort
+		}
+		return items, nil
+	}
+	t.Cleanup(func() {
+		localMusicFingerprintFile, localMusicFingerprintReady = origFile, origReady
+		localMusicFingerprintJobs.reset()
+	})
+}
+
+func randomFingerprintForTest(seed int64, n int) []uint32 {
+	rng := rand.New(rand.NewSource(seed))
+	items := make([]uint32, n)
+	for i := range items {
+		items[i] = rng.Uint32()
+	}
+	return items
+}
+
+func TestLocalMusicFingerprintDuplicatesAndResolve(t *testing.T) {
+	initCollectionDBForTest(t)
+	downloadDir := t.TempDir()
+	withLocalMusicDownloadDir(t, downloadDir)
+
+	original := randomFingerprintForTest(1, 200)
+	reencoded := append([]uint32(nil), original...)
+	for i := 0; i < len(reencoded); i += 4 {
+		reencoded[i] ^= 0x0101
+	}
+	withLocalMusicFingerprintTestFiles(t, map[string][]uint32{
+		"晴天.mp3":        original,
+		"track01.flac":  reencoded,
+		"晴天 (Live).mp3": randomFingerprintForTest(2, 200),
+	})
+
+	// The flac copy has no tags, the live recording shares the studio tags.
+	writeTaggedMP3ForTest(t, filepath.Join(downloadDir, "晴天.mp3"), core.AudioTags{Title: "晴天", Artist: "周杰伦"})
+	writeLocalMusicFileForTest(t, filepath.Join(downloadDir, "track01.flac"), string(make([]byte, 2048)))
+	writeTaggedMP3ForTest(t, filepath.Join(downloadDir, "晴天 (Live).mp3"), core.AudioTags{Title: "晴天", Artist: "周杰伦"})
+	files := []string{"晴天.mp3", "track01.flac", "晴天 (Live).mp3"}
+	for _, name := range files {
+		track, err := localMusicTrackByID(encodeLocalMusicID(name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		upsertLocalMusicIndexRow(track)
+	}
+	studio, flac := encodeLocalMusicID(files[0]), encodeLocalMusicID(files[1])
+
+	if code := postLocalMusicTagsJSON(t, "/local_music/fingerprints", gin.H{}, nil); code != http.StatusOK {
+		t.Fatalf("start fingerprint job status = %d", code)
+	}
+	job := localMusicFingerprintJobs.get()
+	waitJobDoneForTest(t, "fingerprint", job.done)
+	if got := job.snapshot(); got.Computed != 3 || got.Failed != 0 {
+		t.Fatalf("job = %+v", got)
+	}
+	if _, fingerprinted := countLocalMusicFingerprints(); fingerprinted != 3 {
+		t.Fatalf("fingerprinted = %d, want 3", fingerprinted)
+	}
+	// Every file is fresh, so a second run has nothing to do.
+	if code := postLocalMusicTagsJSON(t, "/local_music/fingerprints", gin.H{}, nil); code != http.StatusBadRequest {
+		t.Fatalf("second job status = %d, want 400", code)
+	}
+
+	rec := httptest.NewRecorder()
+	newLocalMusicTestRouter().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, RoutePrefix+"/local_music/duplicates?mode=fingerprint", nil))
+	var dup struct {
+		Groups []localMusicDupGroup `json:"groups"`
+		Total  int                  `json:"total"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &dup); err != nil {
+		t.Fatalf("decode duplicates: %v, body=%s", err, rec.Body.String())
+	}
+	if dup.Total != 1 || len(dup.Groups[0].Songs) != 2 {
+		t.Fatalf("fingerprint groups = %+v", dup.Groups)
+	}
+	group := dup.Groups[0]
+	if group.Keep != flac {
+		t.Fatalf("keep = %s, want the lossless copy", group.Keep)
+	}
+	for _, song := range group.Songs {
+		if song.ID == studio && (song.Keep || song.Similarity < 0.9 || song.Similarity >= 1) {
+			t.Fatalf("mp3 copy = %+v", song)
+		}
+	}
+
+	if code := postLocalMusicTagsJSON(t, "/local_music/duplicates/resolve", gin.H{"action": "trash", "groups": []gin.H{{"keep": flac, "remove": []string{studio}}}}, nil); code != http.StatusBadRequest {
+		t.Fatalf("unknown action status = %d, want 400", code)
+	}
+	var resolved struct {
+		Resolved int                    `json:"resolved"`
+		Failed   []localMusicTagFailure `json:"failed"`
+	}
+	payload := gin.H{"action": "move", "groups": []gin.H{{"keep": flac, "remove": []string{studio, flac}}}}
+	if code := postLocalMusicTagsJSON(t, "/local_music/duplicates/resolve", payload, &resolved); code != http.StatusOK {
+		t.Fatalf("resolve status = %d", code)
+	}
+	if resolved.Resolved != 1 || len(resolved.Failed) != 0 {
+		t.Fatalf("resolve = %+v", resolved)
+	}
+	if _, err := os.Stat(filepath.Join(downloadDir, localMusicDuplicatesDir, "晴天.mp3")); err != nil {
+		t.Fatalf("moved copy missing: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(downloadDir, "track01.flac")); err != nil {
+		t.Fatalf("kept copy missing: %v", err)
+	}
+	var count int64
+	db.Model(&LocalMusicIndex{}).Where("id = ?", studio).Count(&count)
+	if count != 0 {
+		t.Fatal("moved copy should leave the index")
+	}
+}
+
+func TestLocalMusicFingerprintGoesStaleWhenFileChanges(t *testing.T) {
+	initCollectionDBForTest(t)
+	downloadDir := t.TempDir()
+	withLocalMusicDownloadDir(t, downloadDir)
+	withLocalMusicFingerprintTestFiles(t, map[string][]uint32{"a.mp3": randomFingerprintForTest(3, 50)})
+
+	path := filepath.Join(downloadDir, "a.mp3")
+	writeTaggedMP3ForTest(t, path, core.AudioTags{Title: "A"})
+	id := encodeLocalMusicID("a.mp3")
+	track, err := localMusicTrackByID(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	upsertLocalMusicIndexRow(track)
+	if err := fingerprintLocalMusicTrack(id); err != nil {
+		t.Fatalf("fingerprintLocalMusicTrack() error = %v", err)
+	}
+	if _, fingerprinted := countLocalMusicFingerprints(); fingerprinted != 1 {
+		t.Fatalf("fingerprinted = %d, want 1", fingerprinted)
+	}
+
+	writeTaggedMP3ForTest(t, path, core.AudioTags{Title: "A", Album: "Longer tags change the size"})
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+	track, err = localMusicTrackByID(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	upsertLocalMusicIndexRow(track)
+	var row LocalMusicIndex
+	if err := db.First(&row, "id = ?", id).Error; err != nil || row.Fingerprint == "" || row.hasFreshFingerprint() {
+		t.Fatalf("row after rescan: has fingerprint %v, key %q, %v", row.Fingerprint != "", row.FingerprintKey, err)
+	}
+}
+
+func TestMarkLocalMusicDuplicateKeep(t *testing.T) {
+	items := []localMusicDupItem{
+		{ID: "mp3-320", Ext: ".mp3", Bitrate: 320, Size: 9 << 20},
+		{ID: "m4a-256", Ext: ".m4a", Bitrate: 256, Size: 7 << 20},
+		{ID: "mp3-128", Ext: ".mp3", Bitrate: 128, Size: 4 << 20},
+	}
+	if keep := markLocalMusicDuplicateKeep(items); keep != "mp3-320" || !items[0].Keep || items[1].Keep {
+		t.Fatalf("keep = %s, items = %+v", keep, items)
+	}
+	items = append(items, localMusicDupItem{ID: "wav", Ext: ".wav", Size: 40 << 20})
+	if keep := markLocalMusicDuplicateKeep(items); keep != "wav" || items[0].Keep {
+		t.Fatalf("lossless should win, keep = %s", keep)
+	}
+	if got := estimateLocalMusicBitrate(4_000_000, 100); got != 320 {
+		t.Fatalf("estimateLocalMusicBitrate = %d, want 320", got)
+	}
+}
@@ -0,0 +1,566 @@
+package web
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/guohuiyuan/go-music-dl/core"
+	"github.com/guohuiyuan/music-lib/model"
+)
+
+// 本地音乐自动识别：对标签不全的本地文件，用已有标签或文件名去各在线源
+// 搜索，按 CalcSongSimilarity + 时长打分，给出标题 / 歌手 / 专辑 / 封面 /
+// 歌词的修正建议；置信度达到阈值时可以自动写入。相当于 findBestSwitchSong
+// 的本地版：目标不是换一个可播放的源，而是把自己已有的文件补全。
+// 写入复用标签编辑器，一次识别任务的写入合成一条撤销记录。
+
+const (
+	localMusicIdentifyDefaultThreshold = 0.9
+	localMusicIdentifyWorkers          = 3
+	localMusicUnknownArtist            = "未知歌手"
+)
+
+var (
+	errLocalMusicIdentifyNoJob = errors.New("没有识别任务")
+
+	// 文件名开头补零的音轨号：“01 ”、“01. ”、“003-”。
+	localMusicTrackNumberPrefix = regexp.MustCompile(`^\d{2,3}(\s*[-._、]\s*|\s+)`)
+
+	identifyFetchCover  = core.FetchBytesWithMime
+	identifyFetchLyrics = func(song *model.Song) (string, error) {
+		fn := core.GetLyricFunc(song.Source)
+		if fn == nil {
+			return "", fmt.Errorf("source %s does not provide lyrics", song.Source)
+		}
+		return fn(song)
+	}
+
+	localMusicIdentifyJobs = newBackgroundJobSlot[*localMusicIdentifyJob]("已有识别任务在进行中")
+)
+
+type localMusicIdentifyRequest struct {
+	IDs           []string `json:"ids"`
+	AllIncomplete bool     `json:"all_incomplete"`
+	AutoApply     bool     `json:"auto_apply"`
+	Threshold     float64  `json:"threshold"`
+	Sources       []string `json:"sources"`
+}
+
+// localMusicIdentifyProposal 是一个候选结果，Fields 是与本地不同、建议修改的字段。
+type localMusicIdentifyProposal struct {
+	Source   string   `json:"source"`
+	SongID   string   `json:"song_id"`
+	Name     string   `json:"name"`
+	Artist   string   `json:"artist"`
+	Album    string   `json:"album"`
+	Cover    string   `json:"cover"`
+	Duration int      `json:"duration"`
+	Fields   []string `json:"fields"`
+
+	song model.Song
+}
+
+type localMusicIdentifyResult struct {
+	ID         string                      `json:"id"`
+	Filename   string                      `json:"filename"`
+	RelPath    string                      `json:"rel_path"`
+	Name       string                      `json:"name"`
+	Artist     string                      `json:"artist"`
+	Album      string                      `json:"album"`
+	Duration   int                         `json:"duration"`
+	Query      string                      `json:"query"`
+	Status     string                      `json:"status"`
+	Confidence float64                     `json:"confidence"`
+	Proposal   *localMusicIdentifyProposal `json:"proposal,omitempty"`
+	Error      string                      `json:"error,omitempty"`
+}
+
+type localMusicIdentifyJob struct {
+	backgroundJob
+	Matched   int                         `json:"matched"`
+	Applied   int                         `json:"applied"`
+	AutoApply bool                        `json:"auto_apply"`
+	Threshold float64                     `json:"threshold"`
+	EditID    uint                        `json:"edit_id,omitempty"`
+	Results   []*localMusicIdentifyResult `json:"results"`
+
+	sources []string
+}
+
+// localMusicIdentifyHint is one reading of what a file is. A filename like
+// "A - B" yields both "B by A" and "A by B".
+type localMusicIdentifyHint struct {
+	Name   string
+	Artist string
+}
+
+func registerLocalMusicIdentifyRoutes(api *gin.RouterGroup) {
+	api.POST("/local_music/identify", requireSameOriginWrite, func(c *gin.Context) {
+		var req localMusicIdentifyRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+			return
+		}
+		job, err := startLocalMusicIdentify(req)
+		if err != nil {
+			localMusicIdentifyJobs.startError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "started", "job": job.snapshot()})
+	})
+
+	api.GET("/local_music/identify", func(c *gin.Context) {
+		job := localMusicIdentifyJobs.get()
+		if job == nil {
+			c.JSON(http.StatusOK, gin.H{"job": nil})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"job": job.snapshot()})
+	})
+
+	api.POST("/local_music/identify/cancel", requireSameOriginWrite, func(c *gin.Context) {
+		job := localMusicIdentifyJobs.get()
+		if job == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": errLocalMusicIdentifyNoJob.Error()})
+			return
+		}
+		job.cancel()
+		c.JSON(http.StatusOK, gin.H{"status": "ok"})
+	})
+
+	api.POST("/local_music/identify/apply", requireSameOriginWrite, func(c *gin.Context) {
+		var req struct {
+			JobID  string   `json:"job_id"`
+			IDs    []string `json:"ids"`
+			Fields []string `json:"fields"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil || len(req.IDs) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "请选择要应用的识别结果"})
+			return
+		}
+		job := localMusicIdentifyJobs.get()
+		if job == nil || job.ID != req.JobID {
+			c.JSON(http.StatusNotFound, gin.H{"error": "识别任务不存在或已被新的任务替换"})
+			return
+		}
+		edit, updated, failed, err := job.apply(req.IDs, req.Fields)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		body := gin.H{"status": "ok", "tracks": updated, "failed": failed}
+		if edit != nil {
+			body["edit_id"] = edit.ID
+		}
+		c.JSON(http.StatusOK, body)
+	})
+}
+
+func startLocalMusicIdentify(req localMusicIdentifyRequest) (*localMusicIdentifyJob, error) {
+	ids := make([]string, 0, len(req.IDs))
+	for _, id := range req.IDs {
+		if id = strings.TrimSpace(id); id != "" && !containsString(ids, id) {
+			ids = append(ids, id)
+		}
+	}
+	if req.AllIncomplete {
+		incomplete, err := incompleteLocalMusicIDs(localMusicTagMaxTracks)
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range incomplete {
+			if !containsString(ids, id) {
+				ids = append(ids, id)
+			}
+		}
+	}
+	if len(ids) == 0 {
+		return nil, errors.New("没有需要识别的本地音乐")
+	}
+	if len(ids) > localMusicTagMaxTracks {
+		return nil, fmt.Errorf("一次最多识别 %d 首", localMusicTagMaxTracks)
+	}
+
+	threshold := req.Threshold
+	if threshold <= 0 || threshold > 1 {
+		threshold = localMusicIdentifyDefaultThreshold
+	}
+	sources := localMusicIdentifySources(req.Sources)
+	if len(sources) == 0 {
+		return nil, errors.New("没有可用于识别的在线音源")
+	}
+
+	job := &localMusicIdentifyJob{
+		backgroundJob: newBackgroundJob("running", len(ids)),
+		AutoApply:     req.AutoApply,
+		Threshold:     threshold,
+		Results:       make([]*localMusicIdentifyResult, 0, len(ids)),
+		sources:       sources,
+	}
+	for _, id := range ids {
+		job.Results = append(job.Results, &localMusicIdentifyResult{ID: id, Status: "pending"})
+	}
+
+	if err := localMusicIdentifyJobs.install(job); err != nil {
+		return nil, err
+	}
+	go job.run()
+	return job, nil
+}
+
+// incompleteLocalMusicIDs lists writable indexed files missing an album, an
+// artist or a cover.
+func incompleteLocalMusicIDs(limit int) ([]string, error) {
+	if db == nil {
+		return nil, nil
+	}
+	var rows []LocalMusicIndex
+	err := db.Select("id", "root").
+		Where("album = '' OR artist IN ? OR has_cover = ?", []string{"", localMusicUnknownArtist}, false).
+		Where("ext IN ?", core.TagWritableAudioExts).
+		Order("rel_path").
+		Find(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	roots := localLibraryRootLabels()
+	ids := make([]string, 0, len(rows))
+	for _, row := range rows {
+		if root, ok := roots[row.Root]; !ok || root.ReadOnly {
+			continue
+		}
+		ids = append(ids, row.ID)
+		if len(ids) == limit {
+			break
+		}
+	}
+	return ids, nil
+}
+
+func localMusicIdentifySources(requested []string) []string {
+	candidates := switchCandidateSources(localMusicSource, "")
+	if len(requested) == 0 {
+		return candidates
+	}
+	sources := make([]string, 0, len(requested))
+	for _, source := range requested {
+		if containsString(candidates, source) && !containsString(sources, source) {
+			sources = append(sources, source)
+		}
+	}
+	return sources
+}
+
+func (job *localMusicIdentifyJob) snapshot() *localMusicIdentifyJob {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	out := &localMusicIdentifyJob{
+		backgroundJob: job.snapshotLocked(),
+		Matched:       job.Matched,
+		Applied:       job.Applied,
+		AutoApply:     job.AutoApply,
+		Threshold:     job.Threshold,
+		EditID:        job.EditID,
+		Results:       make([]*localMusicIdentifyResult, 0, len(job.Results)),
+	}
+	for _, result := range job.Results {
+		copied := *result
+		out.Results = append(out.Results, &copied)
+	}
+	return out
+}
+
+func (job *localMusicIdentifyJob) run() {
+	job.process(localMusicIdentifyWorkers, func(index int) func() {
+		job.mu.Lock()
+		id := job.Results[index].ID
+		job.mu.Unlock()
+
+		result := identifyLocalMusicTrack(id, job.sources)
+		return func() {
+			job.Results[index] = result
+			if result.Proposal != nil {
+				job.Matched++
+			}
+		}
+	})
+
+	if job.AutoApply && !job.isCancelled() {
+		ids := make([]string, 0)
+		job.mu.Lock()
+		for _, result := range job.Results {
+			if result.Proposal != nil && result.Confidence >= job.Threshold {
+				ids = append(ids, result.ID)
+			}
+		}
+		job.mu.Unlock()
+		if len(ids) > 0 {
+			if _, _, _, err := job.apply(ids, nil); err != nil {
+				core.Logger().Warn("auto-apply local music identify results failed", "job", job.ID, "error", err)
+			}
+		}
+	}
+	job.finish(nil)
+}
+
+// identifyLocalMusicTrack searches every source for one file and keeps the
+// best scoring candidate.
+func identifyLocalMusicTrack(id string, sources []string) *localMusicIdentifyResult {
+	result := &localMusicIdentifyResult{ID: id, Status: "no_match"}
+	track, err := localMusicTrackByID(id)
+	if err != nil {
+		result.Status, result.Error = "failed", "本地音乐不存在或已不在曲库目录内"
+		return result
+	}
+	result.Filename, result.RelPath = track.Filename, track.RelPath
+	result.Name, result.Artist, result.Album = track.Name, track.Artist, track.Album
+	result.Duration = track.Duration
+	if result.Duration <= 0 {
+		if probe, err := probeLocalMusicTrack(track); err == nil && probe != nil {
+			result.Duration = probe.Duration
+		}
+	}
+
+	hints := localMusicIdentifyHints(track)
+	if len(hints) == 0 {
+		return result
+	}
+	keyword := strings.TrimSpace(hints[0].Name + " " + hints[0].Artist)
+	result.Query = keyword
+
+	candidates := searchIdentifyCandidates(keyword, hints, result.Duration, sources)
+	if len(candidates) == 0 {
+		return result
+	}
+	best := candidates[0]
+	result.Status = "matched"
+	result.Confidence = best.confidence
+	result.Proposal = newLocalMusicIdentifyProposal(track, best.song)
+	return result
+}
+
+// identifyCandidate is one online search result scored against the hints.
+type identifyCandidate struct {
+	song       model.Song
+	confidence float64
+	durDiff    int
+}
+
+// searchIdentifyCandidates searches keyword on every source in parallel and
+// returns the plausible results, best first.
+func searchIdentifyCandidates(keyword string, hints []localMusicIdentifyHint, duration int, sources []string) []identifyCandidate {
+	var mu sync.Mutex
+	var candidates []identifyCandidate
+	var wg sync.WaitGroup
+	for _, source := range sources {
+		fn := switchSearchFuncProvider(source)
+		if fn == nil {
+			continue
+		}
+		wg.Add(1)
+		go func(source string, fn func(string) ([]model.Song, error)) {
+			defer wg.Done()
+			songs, err := searchSourceWithTimeout(fn, keyword)
+			if err != nil {
+				return
+			}
+			if len(songs) > switchMaxCandidatesPerSource {
+				songs = songs[:switchMaxCandidatesPerSource]
+			}
+			for _, song := range songs {
+				song.Source = source
+				confidence, durDiff, ok := scoreLocalMusicIdentifyCandidate(hints, duration, song)
+				if !ok {
+					continue
+				}
+				mu.Lock()
+				candidates = append(candidates, identifyCandidate{song: song, confidence: confidence, durDiff: durDiff})
+				mu.Unlock()
+			}
+		}(source, fn)
+	}
+	wg.Wait()
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].confidence == candidates[j].confidence {
+			return candidates[i].durDiff < candidates[j].durDiff
+		}
+		return candidates[i].confidence > candidates[j].confidence
+	})
+	return candidates
+}
+
+// localMusicIdentifyHints reads name/artist from the tags when the title tag
+// is present, otherwise from the filename.
+func localMusicIdentifyHints(track *localMusicTrack) []localMusicIdentifyHint {
+	artist := track.Artist
+	if containsString(track.Missing, "artist") || artist == localMusicUnknownArtist {
+		artist = ""
+	}
+	if !containsString(track.Missing, "title") && strings.TrimSpace(track.Name) != "" {
+		return []localMusicIdentifyHint{{Name: track.Name, Artist: artist}}
+	}
+
+	stem := strings.TrimSuffix(path.Base(track.RelPath), path.Ext(track.RelPath))
+	stem = strings.TrimSpace(localMusicTrackNumberPrefix.ReplaceAllString(stem, ""))
+	if stem == "" {
+		return nil
+	}
+	if left, right, ok := strings.Cut(stem, " - "); ok {
+		left, right = strings.TrimSpace(left), strings.TrimSpace(right)
+		if left != "" && right != "" {
+			// 默认命名模板是 “{artist} - {name}”，但也常见反过来的写法。
+			return []localMusicIdentifyHint{{Name: right, Artist: left}, {Name: left, Artist: right}}
+		}
+	}
+	return []localMusicIdentifyHint{{Name: stem, Artist: artist}}
+}
+
+// scoreLocalMusicIdentifyCandidate returns the best similarity over the hints,
+// discounted when the durations differ by more than a few seconds or cannot
+// be compared. Candidates with clearly different durations are dropped.
+func scoreLocalMusicIdentifyCandidate(hints []localMusicIdentifyHint, duration int, song model.Song) (float64, int, bool) {
+	best := 0.0
+	for _, hint := range hints {
+		if score := core.CalcSongSimilarity(hint.Name, hint.Artist, song.Name, song.Artist); score > best {
+			best = score
+		}
+	}
+	if best <= 0 {
+		return 0, 0, false
+	}
+	if duration <= 0 || song.Duration <= 0 {
+		return best * 0.95, 0, true
+	}
+	if !core.IsDurationClose(duration, song.Duration) {
+		return 0, 0, false
+	}
+	durDiff := core.IntAbs(duration - song.Duration)
+	if durDiff > 3 {
+		best *= 0.9
+	}
+	return best, durDiff, true
+}
+
+func newLocalMusicIdentifyProposal(track *localMusicTrack, song model.Song) *localMusicIdentifyProposal {
+	proposal := &localMusicIdentifyProposal{
+		Source:   song.Source,
+		SongID:   song.ID,
+		Name:     strings.TrimSpace(song.Name),
+		Artist:   strings.TrimSpace(song.Artist),
+		Album:    strings.TrimSpace(song.Album),
+		Cover:    strings.TrimSpace(song.Cover),
+		Duration: song.Duration,
+		Fields:   []string{},
+		song:     song,
+	}
+	if proposal.Name != "" && (proposal.Name != track.Name || containsString(track.Missing, "title")) {
+		proposal.Fields = append(proposal.Fields, "title")
+	}
+	if proposal.Artist != "" && (proposal.Artist != track.Artist || containsString(track.Missing, "artist")) {
+		proposal.Fields = append(proposal.Fields, "artist")
+	}
+	if proposal.Album != "" && proposal.Album != track.Album {
+		proposal.Fields = append(proposal.Fields, "album")
+	}
+	if proposal.Cover != "" && track.Extra["cover"] != "true" {
+		proposal.Fields = append(proposal.Fields, "cover")
+	}
+	if track.Extra["lyric"] != "true" {
+		proposal.Fields = append(proposal.Fields, "lyrics")
+	}
+	return proposal
+}
+
+// apply writes the proposals of the given files. fields limits what is
+// written; empty means every proposed field.
+func (job *localMusicIdentifyJob) apply(ids []string, fields []string) (*LocalMusicTagEdit, []*localMusicTrack, []localMusicTagFailure, error) {
+	job.mu.Lock()
+	results := make([]*localMusicIdentifyResult, 0, len(ids))
+	for _, result := range job.Results {
+		if containsString(ids, result.ID) && result.Proposal != nil && result.Status != "applied" {
+			results = append(results, result)
+		}
+	}
+	job.mu.Unlock()
+
+	items := make([]localMusicTagEditItem, 0, len(results))
+	failed := []localMusicTagFailure{}
+	for _, result := range results {
+		item, err := localMusicIdentifyTagItem(result, fields)
+		if err != nil {
+			failed = append(failed, localMusicTagFailure{ID: result.ID, Error: err.Error()})
+			continue
+		}
+		items = append(items, item)
+	}
+	if len(items) == 0 {
+		return nil, []*localMusicTrack{}, failed, nil
+	}
+
+	edit, updated, writeFailed, err := applyLocalMusicTagEditItems(items)
+	failed = append(failed, writeFailed...)
+
+	job.mu.Lock()
+	for _, result := range results {
+		for _, failure := range failed {
+			if failure.ID == result.ID {
+				result.Status, result.Error = "failed", failure.Error
+			}
+		}
+		if result.Status != "failed" {
+			result.Status = "applied"
+			job.Applied++
+		}
+	}
+	if edit != nil {
+		job.EditID = edit.ID
+	}
+	job.mu.Unlock()
+	return edit, updated, failed, err
+}
+
+// localMusicIdentifyTagItem turns a proposal into a tag edit, downloading the
+// cover and lyrics. A failed download only drops that field.
+func localMusicIdentifyTagItem(result *localMusicIdentifyResult, fields []string) (localMusicTagEditItem, error) {
+	proposal := result.Proposal
+	req := localMusicTagRequest{IDs: []string{result.ID}, Changes: map[string]string{}}
+	for _, field := range proposal.Fields {
+		if len(fields) > 0 && !containsString(fields, field) {
+			continue
+		}
+		switch field {
+		case "title":
+			req.Changes["title"] = proposal.Name
+		case "artist":
+			req.Changes["artist"] = proposal.Artist
+		case "album":
+			req.Changes["album"] = proposal.Album
+		case "cover":
+			data, mimeType, err := identifyFetchCover(proposal.Cover, proposal.Source)
+			if err != nil || len(data) == 0 {
+				core.Logger().Debug("identify cover download failed", "source", proposal.Source, "error", err)
+				continue
+			}
+			req.cover, req.CoverMime = data, mimeType
+		case "lyrics":
+			song := proposal.song
+			lyric, err := identifyFetchLyrics(&song)
+			if err != nil || strings.TrimSpace(lyric) == "" {
+				continue
+			}
+			req.Changes["lyrics"] = strings.TrimSpace(lyric)
+		}
+	}
+	if len(req.Changes) == 0 && req.cover == nil {
+		return localMusicTagEditItem{}, errors.New("没有可写入的字段")
+	}
+	return localMusicTagEditItem{ID: result.ID, Req: req}, nil
+}
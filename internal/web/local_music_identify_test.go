@@ -0,0 +1,199 @@
+package web
+
+import (
+	"bytes"
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/guohuiyuan/go-music-dl/core"
+	"github.com/guohuiyuan/music-lib/model"
+)
+
+func withLocalMusicIdentifyTestSources(t *testing.T, songs []model.Song) {
+	t.Helper()
+	withSwitchSourceTestHooks(t)
+	switchDefaultSourceNames = func() []string { return []string{"fake"} }
+	switchAllSourceNames = func() []string { return []string{"fake"} }
+	switchSearchFuncProvider = func(source string) func(string) ([]model.Song, error) {
+		if source != "fake" {
+			return nil
+		}
+		return func(string) ([]model.Song, error) { return songs, nil }
+	}
+
+	origCover, origLyrics := identifyFetchCover, identifyFetchLyrics
+	identifyFetchCover = func(string, string) ([]byte, string, error) {
+		return []byte{0xff, 0xd8, 0xff, 0xd9}, "image/jpeg", nil
+	}
+	identifyFetchLyrics = func(song *model.Song) (string, error) {
+		return "[00:01.00]" + song.Name, nil
+	}
+	t.Cleanup(func() {
+		identifyFetchCover, identifyFetchLyrics = origCover, origLyrics
+		localMusicIdentifyJobs.reset()
+	})
+}
+
+func waitLocalMusicIdentifyJob(t *testing.T) *localMusicIdentifyJob {
+	t.Helper()
+	job := localMusicIdentifyJobs.get()
+	if job == nil {
+		t.Fatal("no identify job")
+	}
+	waitJobDoneForTest(t, "identify", job.done)
+	return job.snapshot()
+}
+
+func TestLocalMusicIdentifyHints(t *testing.T) {
+	cases := []struct {
+		track *localMusicTrack
+		want  []localMusicIdentifyHint
+	}{
+		{
+			&localMusicTrack{RelPath: "周杰伦 - 晴天.mp3", Name: "周杰伦 - 晴天", Artist: "未知歌手", Missing: []string{"title", "artist"}},
+			[]localMusicIdentifyHint{{Name: "晴天", Artist: "周杰伦"}, {Name: "周杰伦", Artist: "晴天"}},
+		},
+		{
+			&localMusicTrack{RelPath: "Album/03. Yesterday.flac", Name: "03. Yesterday", Artist: "The Beatles", Missing: []string{"title"}},
+			[]localMusicIdentifyHint{{Name: "Yesterday", Artist: "The Beatles"}},
+		},
+		{
+			&localMusicTrack{RelPath: "7 Years.mp3", Name: "7 Years", Artist: "未知歌手", Missing: []string{"title", "artist"}},
+			[]localMusicIdentifyHint{{Name: "7 Years"}},
+		},
+		{
+			&localMusicTrack{RelPath: "x.mp3", Name: "稻香", Artist: "周杰伦", Missing: []string{"album"}},
+			[]localMusicIdentifyHint{{Name: "稻香", Artist: "周杰伦"}},
+		},
+	}
+	for _, tc := range cases {
+		got := localMusicIdentifyHints(tc.track)
+		if len(got) != len(tc.want) {
+			t.Fatalf("hints(%s) = %+v, want %+v", tc.track.RelPath, got, tc.want)
+		}
+		for i := range got {
+			if got[i] != tc.want[i] {
+				t.Fatalf("hints(%s) = %+v, want %+v", tc.track.RelPath, got, tc.want)
+			}
+		}
+	}
+}
+
+func TestScoreLocalMusicIdentifyCandidate(t *testing.T) {
+	hints := []localMusicIdentifyHint{{Name: "晴天", Artist: "周杰伦"}, {Name: "周杰伦", Artist: "晴天"}}
+	exact, _, ok := scoreLocalMusicIdentifyCandidate(hints, 269, model.Song{Name: "晴天", Artist: "周杰伦", Duration: 270})
+	if !ok || exact != 1 {
+		t.Fatalf("exact match confidence = %v, %v", exact, ok)
+	}
+	drift, _, ok := scoreLocalMusicIdentifyCandidate(hints, 269, model.Song{Name: "晴天", Artist: "周杰伦", Duration: 280})
+	if !ok || drift >= exact {
+		t.Fatalf("10s drift confidence = %v, want below %v", drift, exact)
+	}
+	unknown, _, _ := scoreLocalMusicIdentifyCandidate(hints, 0, model.Song{Name: "晴天", Artist: "周杰伦", Duration: 270})
+	if unknown >= exact || unknown <= drift {
+		t.Fatalf("unknown duration confidence = %v, want between %v and %v", unknown, drift, exact)
+	}
+	if _, _, ok := scoreLocalMusicIdentifyCandidate(hints, 269, model.Song{Name: "晴天", Artist: "周杰伦", Duration: 400}); ok {
+		t.Fatal("candidate with a far off duration should be dropped")
+	}
+}
+
+func TestLocalMusicIdentifyProposesAndApplies(t *testing.T) {
+	initCollectionDBForTest(t)
+	downloadDir := t.TempDir()
+	withLocalMusicDownloadDir(t, downloadDir)
+	withLocalMusicIdentifyTestSources(t, []model.Song{
+		{ID: "1", Name: "晴天", Artist: "周杰伦", Album: "叶惠美", Cover: "http://img.test/1.jpg"},
+		{ID: "2", Name: "晴天 (Live)", Artist: "周杰伦", Album: "Live"},
+	})
+
+	writeTaggedMP3ForTest(t, filepath.Join(downloadDir, "周杰伦 - 晴天.mp3"), core.AudioTags{})
+	writeTaggedMP3ForTest(t, filepath.Join(downloadDir, "tagged.mp3"), core.AudioTags{Title: "晴天", Artist: "周杰伦", Album: "叶惠美", Genre: "Pop"})
+	untagged, tagged := encodeLocalMusicID("周杰伦 - 晴天.mp3"), encodeLocalMusicID("tagged.mp3")
+
+	var started struct {
+		Job localMusicIdentifyJob `json:"job"`
+	}
+	if code := postLocalMusicTagsJSON(t, "/local_music/identify", gin.H{"ids": []string{untagged, tagged}}, &started); code != http.StatusOK {
+		t.Fatalf("start status = %d", code)
+	}
+	job := waitLocalMusicIdentifyJob(t)
+	if job.Status != "done" || job.Processed != 2 || job.Matched != 2 || job.Applied != 0 {
+		t.Fatalf("job = %+v", job)
+	}
+	first := job.Results[0]
+	if first.Confidence != 0.95 || first.Proposal == nil || first.Proposal.SongID != "1" {
+		t.Fatalf("untagged result = %+v", first)
+	}
+	if got := first.Proposal.Fields; len(got) != 5 {
+		t.Fatalf("untagged proposal fields = %v, want title, artist, album, cover and lyrics", got)
+	}
+	if got := job.Results[1].Proposal.Fields; len(got) != 2 || got[0] != "cover" || got[1] != "lyrics" {
+		t.Fatalf("tagged proposal fields = %v, want cover and lyrics only", got)
+	}
+
+	var applied struct {
+		EditID uint                   `json:"edit_id"`
+		Tracks []localMusicTrack      `json:"tracks"`
+		Failed []localMusicTagFailure `json:"failed"`
+	}
+	payload := gin.H{"job_id": job.ID, "ids": []string{untagged}, "fields": []string{"title", "artist", "album", "cover"}}
+	if code := postLocalMusicTagsJSON(t, "/local_music/identify/apply", payload, &applied); code != http.StatusOK {
+		t.Fatalf("apply status = %d", code)
+	}
+	if applied.EditID == 0 || len(applied.Tracks) != 1 || len(applied.Failed) != 0 {
+		t.Fatalf("apply = %+v", applied)
+	}
+	tags := readTagsForTest(t, filepath.Join(downloadDir, "周杰伦 - 晴天.mp3"))
+	if tags.Title != "晴天" || tags.Artist != "周杰伦" || tags.Album != "叶惠美" || !bytes.Equal(tags.Cover, []byte{0xff, 0xd8, 0xff, 0xd9}) || tags.Lyrics != "" {
+		t.Fatalf("applied tags = %+v", tags)
+	}
+	if got := localMusicIdentifyJobs.get().snapshot(); got.Applied != 1 || got.Results[0].Status != "applied" {
+		t.Fatalf("job after apply = %+v", got)
+	}
+
+	// The identify edit goes through the tag editor and can be undone.
+	if code := postLocalMusicTagsJSON(t, "/local_music/tags/undo", gin.H{"edit_id": applied.EditID}, nil); code != http.StatusOK {
+		t.Fatalf("undo status = %d", code)
+	}
+	if tags := readTagsForTest(t, filepath.Join(downloadDir, "周杰伦 - 晴天.mp3")); tags.Title != "" || len(tags.Cover) != 0 {
+		t.Fatalf("tags after undo = %+v", tags)
+	}
+}
+
+func TestLocalMusicIdentifyAutoAppliesAboveThreshold(t *testing.T) {
+	initCollectionDBForTest(t)
+	downloadDir := t.TempDir()
+	withLocalMusicDownloadDir(t, downloadDir)
+	withLocalMusicIdentifyTestSources(t, []model.Song{{ID: "1", Name: "稻香", Artist: "周杰伦", Album: "魔杰座"}})
+
+	writeTaggedMP3ForTest(t, filepath.Join(downloadDir, "稻香.mp3"), core.AudioTags{Title: "稻香", Artist: "周杰伦"})
+	writeTaggedMP3ForTest(t, filepath.Join(downloadDir, "other.mp3"), core.AudioTags{Title: "稻草人", Artist: "周杰伦"})
+	for _, track := range []string{"稻香.mp3", "other.mp3"} {
+		built, err := localMusicTrackByID(encodeLocalMusicID(track))
+		if err != nil {
+			t.Fatal(err)
+		}
+		upsertLocalMusicIndexRow(built)
+	}
+
+	if code := postLocalMusicTagsJSON(t, "/local_music/identify", gin.H{"all_incomplete": true, "auto_apply": true, "threshold": 0.9}, nil); code != http.StatusOK {
+		t.Fatalf("start status = %d", code)
+	}
+	job := waitLocalMusicIdentifyJob(t)
+	if job.Total != 2 || job.Applied != 1 || job.EditID == 0 {
+		t.Fatalf("job = %+v", job)
+	}
+	if got := readTagsForTest(t, filepath.Join(downloadDir, "稻香.mp3")); got.Album != "魔杰座" || got.Lyrics != "[00:01.00]稻香" {
+		t.Fatalf("auto-applied tags = %+v", got)
+	}
+	if got := readTagsForTest(t, filepath.Join(downloadDir, "other.mp3")); got.Album != "" {
+		t.Fatalf("low-confidence match was applied: %+v", got)
+	}
+	var row LocalMusicIndex
+	if err := db.First(&row, "id = ?", encodeLocalMusicID("稻香.mp3")).Error; err != nil || row.Album != "魔杰座" {
+		t.Fatalf("index row = %+v, %v", row, err)
+	}
+}
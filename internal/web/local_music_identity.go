@@ -0,0 +1,485 @@
+package web
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/guohuiyuan/go-music-dl/core"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// LocalMusicIdentity 是本地曲目的持久身份。ID 在首次见到文件时按路径生成
+// （与 encodeLocalLibraryID 一致），之后改名、移动、整理曲库都沿用原 ID，
+// 收藏、播放次数等按 ID 记录的数据因此不会失效。Inode/Size/ContentHash 是
+// 把新出现的路径认回已消失文件的线索；RelPath 为空表示原路径已被别的文件
+// 占用，只等它在别处出现。
+type LocalMusicIdentity struct {
+	ID          string    `gorm:"column:id;primaryKey"`
+	Root        string    `gorm:"column:root;not null;default:'';index:idx_local_music_identity_path,priority:1"`
+	RelPath     string    `gorm:"column:rel_path;not null;index:idx_local_music_identity_path,priority:2"`
+	Inode       int64     `gorm:"column:inode;not null;default:0;index"`
+	Size        int64     `gorm:"column:size"`
+	ContentHash string    `gorm:"column:content_hash;not null;default:'';index"`
+	SeenAt      time.Time `gorm:"column:seen_at;index"`
+}
+
+func (LocalMusicIdentity) TableName() string { return "local_music_identities" }
+
+// localMusicIdentityRetention is how long an identity outlives its file, so a
+// file moved out of the library and back later still gets its old ID.
+const localMusicIdentityRetention = 30 * 24 * time.Hour
+
+// localMusicContentHashBytes is how much of the end of the file is hashed.
+// Tags usually live at the start (ID3v2, FLAC), so the tail survives edits.
+const localMusicContentHashBytes = 64 << 10
+
+const (
+	// localMusicIdentityFlushBatch 和 localMusicIdentityFlushDelay 控制缓存里
+	// 的改动何时写回：攒够一批立刻写，否则停顿片刻后一起写。
+	localMusicIdentityFlushBatch = 500
+	localMusicIdentityFlushDelay = 2 * time.Second
+)
+
+var (
+	localMusicIdentityMu sync.Mutex
+	// localMusicIdentities 是整张身份表的内存副本，第一次查找时一条查询载入，
+	// 扫描时按路径查身份、认回移动过的文件都不再逐个查库。
+	localMusicIdentities       = newLocalMusicIdentityCache()
+	localMusicIdentityFlushing *time.Timer
+)
+
+type localMusicInodeKey struct {
+	inode int64
+	size  int64
+}
+
+// localMusicIdentityCache indexes identities by ID, path, inode and content
+// hash. pending holds the IDs changed since the last flush.
+type localMusicIdentityCache struct {
+	loaded  bool
+	byID    map[string]LocalMusicIdentity
+	byPath  map[string]string
+	byInode map[localMusicInodeKey]map[string]struct{}
+	byHash  map[string]map[string]struct{}
+	pending map[string]struct{}
+}
+
+func newLocalMusicIdentityCache() *localMusicIdentityCache {
+	return &localMusicIdentityCache{
+		byID:    map[string]LocalMusicIdentity{},
+		byPath:  map[string]string{},
+		byInode: map[localMusicInodeKey]map[string]struct{}{},
+		byHash:  map[string]map[string]struct{}{},
+		pending: map[string]struct{}{},
+	}
+}
+
+// load reads the whole identity table once.
+func (c *localMusicIdentityCache) load(database *gorm.DB) error {
+	if c.loaded {
+		return nil
+	}
+	var identities []LocalMusicIdentity
+	if err := database.Find(&identities).Error; err != nil {
+		return err
+	}
+	for _, identity := range identities {
+		c.put(identity)
+	}
+	c.loaded = true
+	return nil
+}
+
+func (c *localMusicIdentityCache) put(identity LocalMusicIdentity) {
+	c.remove(identity.ID)
+	c.byID[identity.ID] = identity
+	if identity.RelPath != "" {
+		c.byPath[localMusicIdentityCacheKey(identity.Root, identity.RelPath)] = identity.ID
+	}
+	if identity.Inode != 0 {
+		key := localMusicInodeKey{identity.Inode, identity.Size}
+		if c.byInode[key] == nil {
+			c.byInode[key] = map[string]struct{}{}
+		}
+		c.byInode[key][identity.ID] = struct{}{}
+	}
+	if identity.ContentHash != "" {
+		if c.byHash[identity.ContentHash] == nil {
+			c.byHash[identity.ContentHash] = map[string]struct{}{}
+		}
+		c.byHash[identity.ContentHash][identity.ID] = struct{}{}
+	}
+}
+
+func (c *localMusicIdentityCache) remove(id string) {
+	identity, ok := c.byID[id]
+	if !ok {
+		return
+	}
+	delete(c.byID, id)
+	if key := localMusicIdentityCacheKey(identity.Root, identity.RelPath); c.byPath[key] == id {
+		delete(c.byPath, key)
+	}
+	key := localMusicInodeKey{identity.Inode, identity.Size}
+	delete(c.byInode[key], id)
+	if len(c.byInode[key]) == 0 {
+		delete(c.byInode, key)
+	}
+	delete(c.byHash[identity.ContentHash], id)
+	if len(c.byHash[identity.ContentHash]) == 0 {
+		delete(c.byHash, identity.ContentHash)
+	}
+}
+
+// save updates the cache and queues the identity for the next flush.
+// Callers hold localMusicIdentityMu.
+func (c *localMusicIdentityCache) save(identity LocalMusicIdentity) {
+	c.put(identity)
+	c.pending[identity.ID] = struct{}{}
+	if len(c.pending) >= localMusicIdentityFlushBatch {
+		if err := c.flush(db); err != nil {
+			core.Logger().Warn("save local music identities failed", "error", err)
+		}
+		return
+	}
+	if localMusicIdentityFlushing == nil {
+		localMusicIdentityFlushing = time.AfterFunc(localMusicIdentityFlushDelay, func() {
+			if err := flushLocalMusicIdentities(); err != nil {
+				core.Logger().Warn("save local music identities failed", "error", err)
+			}
+		})
+	}
+}
+
+// flush writes the pending identities in one transaction.
+func (c *localMusicIdentityCache) flush(database *gorm.DB) error {
+	if len(c.pending) == 0 || database == nil {
+		return nil
+	}
+	rows := make([]LocalMusicIdentity, 0, len(c.pending))
+	for id := range c.pending {
+		if identity, ok := c.byID[id]; ok {
+			rows = append(rows, identity)
+		}
+	}
+	if err := database.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "id"}},
+		UpdateAll: true,
+	}).CreateInBatches(rows, 200).Error; err != nil {
+		return err
+	}
+	c.pending = map[string]struct{}{}
+	return nil
+}
+
+// candidates lists identities a file with this inode, size and content hash
+// may have moved from, inode matches first. The hash only covers the tail of
+// the file, so a hash match also needs the same size.
+func (c *localMusicIdentityCache) candidates(inode int64, size int64, hash string) []LocalMusicIdentity {
+	var candidates []LocalMusicIdentity
+	if inode != 0 {
+		for _, id := range sortedLocalMusicIdentityIDs(c.byInode[localMusicInodeKey{inode, size}]) {
+			candidates = append(candidates, c.byID[id])
+		}
+	}
+	if hash != "" {
+		for _, id := range sortedLocalMusicIdentityIDs(c.byHash[hash]) {
+			if identity := c.byID[id]; identity.Size == size {
+				candidates = append(candidates, identity)
+			}
+		}
+	}
+	return candidates
+}
+
+func sortedLocalMusicIdentityIDs(set map[string]struct{}) []string {
+	ids := make([]string, 0, len(set))
+	for id := range set {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// migrateLocalMusicIdentities seeds identities from the index the first time
+// the table exists, so IDs that collections already reference stay the same.
+func migrateLocalMusicIdentities() error {
+	var count int64
+	if err := db.Model(&LocalMusicIdentity{}).Count(&count).Error; err != nil || count > 0 {
+		return err
+	}
+	return db.Exec(`INSERT OR IGNORE INTO local_music_identities (id, root, rel_path, inode, size, content_hash, seen_at)
+		SELECT id, root, rel_path, 0, size, '', scanned_at FROM local_music_index`).Error
+}
+
+// resetLocalMusicIdentityCache drops the cache and any unsaved changes; the
+// next lookup reloads the table.
+func resetLocalMusicIdentityCache() {
+	localMusicIdentityMu.Lock()
+	localMusicIdentities = newLocalMusicIdentityCache()
+	if localMusicIdentityFlushing != nil {
+		localMusicIdentityFlushing.Stop()
+		localMusicIdentityFlushing = nil
+	}
+	localMusicIdentityMu.Unlock()
+}
+
+// flushLocalMusicIdentities writes the identities changed since the last flush.
+func flushLocalMusicIdentities() error {
+	localMusicIdentityMu.Lock()
+	defer localMusicIdentityMu.Unlock()
+	return flushLocalMusicIdentitiesLocked()
+}
+
+func flushLocalMusicIdentitiesLocked() error {
+	if localMusicIdentityFlushing != nil {
+		localMusicIdentityFlushing.Stop()
+		localMusicIdentityFlushing = nil
+	}
+	return localMusicIdentities.flush(db)
+}
+
+func localMusicIdentityCacheKey(rootKey string, relPath string) string {
+	return rootKey + "|" + relPath
+}
+
+// localMusicIDForPath returns the stable ID of the file at rel. A path seen
+// before keeps its ID; a new path first tries to take over the identity of a
+// file that has vanished (same inode and size, or same content hash), and
+// only then gets a fresh path-based ID.
+func localMusicIDForPath(root localLibraryRoot, rel string, absPath string, info os.FileInfo) string {
+	pathID := encodeLocalLibraryID(root.Key, rel)
+	if db == nil {
+		return pathID
+	}
+	key := localMusicIdentityCacheKey(root.Key, rel)
+	inode := localMusicFileInode(info)
+	known := func() (string, bool, error) {
+		if err := localMusicIdentities.load(db); err != nil {
+			return "", false, err
+		}
+		identity, ok := localMusicIdentities.byID[localMusicIdentities.byPath[key]]
+		if ok && identity.Inode == inode && identity.Size == info.Size() && identity.ContentHash != "" {
+			return identity.ID, true, nil
+		}
+		return "", false, nil
+	}
+
+	localMusicIdentityMu.Lock()
+	id, ok, err := known()
+	localMusicIdentityMu.Unlock()
+	if err != nil {
+		core.Logger().Warn("load local music identities failed", "error", err)
+		return pathID
+	}
+	if ok {
+		return id
+	}
+
+	// 读文件尾部算哈希时不占着锁，其他 worker 的命中查找不用排队。
+	hash := localMusicContentHash(absPath)
+
+	localMusicIdentityMu.Lock()
+	defer localMusicIdentityMu.Unlock()
+	if id, ok, err := known(); err != nil || ok {
+		if err != nil {
+			return pathID
+		}
+		return id
+	}
+	cache := localMusicIdentities
+	now := time.Now()
+	if identity, ok := cache.byID[cache.byPath[key]]; ok {
+		if identity.Inode != 0 && inode != 0 && identity.Inode != inode && identity.ContentHash != "" && hash != identity.ContentHash {
+			// 换了 inode 又换了内容：原文件被挪走、这里放了另一个文件。
+			identity.RelPath = ""
+			cache.save(identity)
+		} else {
+			identity.Inode, identity.Size, identity.ContentHash, identity.SeenAt = inode, info.Size(), hash, now
+			cache.save(identity)
+			return identity.ID
+		}
+	}
+	return adoptLocalMusicIdentity(cache, root, rel, info, inode, hash, pathID, now).ID
+}
+
+// adoptLocalMusicIdentity finds the vanished file a new path belongs to, or
+// creates a new identity. Callers hold localMusicIdentityMu.
+func adoptLocalMusicIdentity(cache *localMusicIdentityCache, root localLibraryRoot, rel string, info os.FileInfo, inode int64, hash string, pathID string, now time.Time) LocalMusicIdentity {
+	for _, candidate := range cache.candidates(inode, info.Size(), hash) {
+		if !localMusicIdentityVanished(candidate) {
+			continue
+		}
+		candidate.Root, candidate.RelPath = root.Key, rel
+		candidate.Inode, candidate.Size, candidate.ContentHash, candidate.SeenAt = inode, info.Size(), hash, now
+		cache.save(candidate)
+		return candidate
+	}
+
+	// 路径 ID 可能已被移走的旧文件占用，此时加序号；'~' 不在 base64url
+	// 字符集里，不会与任何路径 ID 冲突。
+	id := pathID
+	for n := 2; ; n++ {
+		if _, taken := cache.byID[id]; !taken {
+			break
+		}
+		id = fmt.Sprintf("%s~%d", pathID, n)
+	}
+	identity := LocalMusicIdentity{ID: id, Root: root.Key, RelPath: rel, Inode: inode, Size: info.Size(), ContentHash: hash, SeenAt: now}
+	cache.save(identity)
+	return identity
+}
+
+// localMusicIdentityVanished reports whether an identity's file is gone from
+// its path: missing, or replaced by a file with another inode. A file on an
+// unreachable root is not gone, so it is never taken over.
+func localMusicIdentityVanished(identity LocalMusicIdentity) bool {
+	root, ok := localLibraryRootByKey(identity.Root)
+	if !ok || identity.RelPath == "" {
+		return true
+	}
+	if info, err := os.Stat(root.Abs); err != nil || !info.IsDir() {
+		return false
+	}
+	absPath, err := root.resolve(identity.RelPath)
+	if err != nil {
+		return true
+	}
+	info, err := os.Stat(absPath)
+	if err != nil {
+		return os.IsNotExist(err)
+	}
+	return identity.Inode != 0 && localMusicFileInode(info) != identity.Inode
+}
+
+// localMusicIdentityLocation looks up where an identity's file currently is.
+func localMusicIdentityLocation(id string) (string, string, bool) {
+	if db == nil {
+		return "", "", false
+	}
+	localMusicIdentityMu.Lock()
+	defer localMusicIdentityMu.Unlock()
+	if err := localMusicIdentities.load(db); err != nil {
+		return "", "", false
+	}
+	identity, ok := localMusicIdentities.byID[id]
+	if !ok {
+		return "", "", false
+	}
+	return identity.Root, identity.RelPath, true
+}
+
+// localMusicIdentityIDAt returns the ID of the identity at a path, if any.
+func localMusicIdentityIDAt(rootKey string, rel string) (string, bool) {
+	if db == nil {
+		return "", false
+	}
+	localMusicIdentityMu.Lock()
+	defer localMusicIdentityMu.Unlock()
+	if err := localMusicIdentities.load(db); err != nil {
+		return "", false
+	}
+	id, ok := localMusicIdentities.byPath[localMusicIdentityCacheKey(rootKey, rel)]
+	return id, ok
+}
+
+// moveLocalMusicIdentity points an identity at a new path after the app
+// itself moved the file, so the next build of the track keeps its ID.
+func moveLocalMusicIdentity(id string, rootKey string, rel string) {
+	if db == nil {
+		return
+	}
+	localMusicIdentityMu.Lock()
+	defer localMusicIdentityMu.Unlock()
+	cache := localMusicIdentities
+	if err := cache.load(db); err != nil {
+		return
+	}
+	identity, ok := cache.byID[id]
+	if !ok {
+		return
+	}
+	// 目标路径上残留的旧身份（文件早已不在）让位给被移动的文件。
+	if stale, ok := cache.byPath[localMusicIdentityCacheKey(rootKey, rel)]; ok && stale != id {
+		if err := db.Delete(&LocalMusicIdentity{}, "id = ?", stale).Error; err != nil {
+			core.Logger().Warn("delete local music identity failed", "id", stale, "error", err)
+			return
+		}
+		cache.remove(stale)
+		delete(cache.pending, stale)
+	}
+	// inode 和内容线索在下次见到文件时重新记录。
+	identity.Root, identity.RelPath = rootKey, rel
+	identity.Inode, identity.ContentHash, identity.SeenAt = 0, "", time.Now()
+	cache.save(identity)
+	if err := flushLocalMusicIdentitiesLocked(); err != nil {
+		core.Logger().Warn("move local music identity failed", "id", id, "error", err)
+	}
+}
+
+// forgetLocalMusicIdentity drops an identity once its file has deliberately
+// left the library (deleted or moved aside).
+func forgetLocalMusicIdentity(id string) {
+	if db == nil || id == "" {
+		return
+	}
+	localMusicIdentityMu.Lock()
+	defer localMusicIdentityMu.Unlock()
+	localMusicIdentities.remove(id)
+	delete(localMusicIdentities.pending, id)
+	if err := db.Delete(&LocalMusicIdentity{}, "id = ?", id).Error; err != nil {
+		core.Logger().Warn("delete local music identity failed", "id", id, "error", err)
+	}
+}
+
+// touchLocalMusicIdentities marks every indexed identity as seen and drops
+// identities whose file has been missing longer than the retention window.
+func touchLocalMusicIdentities(database *gorm.DB, now time.Time) error {
+	localMusicIdentityMu.Lock()
+	defer localMusicIdentityMu.Unlock()
+	if err := flushLocalMusicIdentitiesLocked(); err != nil {
+		return err
+	}
+	if err := database.Exec("UPDATE local_music_identities SET seen_at = ? WHERE id IN (SELECT id FROM local_music_index)", now).Error; err != nil {
+		return err
+	}
+	expired := database.Where("seen_at < ?", now.Add(-localMusicIdentityRetention)).Delete(&LocalMusicIdentity{})
+	if expired.Error != nil {
+		return expired.Error
+	}
+	// seen_at 和过期删除都是直接改的表，下次查找重新载入。
+	localMusicIdentities = newLocalMusicIdentityCache()
+	return nil
+}
+
+// localMusicContentHash hashes the last localMusicContentHashBytes of a file.
+// It is only a hint for recognising moved files, not a checksum.
+func localMusicContentHash(path string) string {
+	file, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return ""
+	}
+	if info.Size() > localMusicContentHashBytes {
+		if _, err := file.Seek(-localMusicContentHashBytes, io.SeekEnd); err != nil {
+			return ""
+		}
+	}
+	h := sha1.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
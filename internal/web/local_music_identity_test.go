@@ -0,0 +1,177 @@
+package web
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/guohuiyuan/go-music-dl/core"
+)
+
+func TestLocalMusicIDSurvivesRenameAndRescan(t *testing.T) {
+	initCollectionDBForTest(t)
+	downloadDir := t.TempDir()
+	withLocalMusicDownloadDir(t, downloadDir)
+
+	writeTaggedMP3ForTest(t, filepath.Join(downloadDir, "a.mp3"), core.AudioTags{Title: "A", Artist: "X"})
+	if err := syncLocalMusicIndex(); err != nil {
+		t.Fatal(err)
+	}
+	id := encodeLocalMusicID("a.mp3")
+	db.Create(&LocalMusicPlay{TrackID: id, PlayCount: 2, LastPlayedAt: time.Now()})
+
+	if err := os.MkdirAll(filepath.Join(downloadDir, "X"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(filepath.Join(downloadDir, "a.mp3"), filepath.Join(downloadDir, "X", "A.mp3")); err != nil {
+		t.Fatal(err)
+	}
+	// A new file at the old path must not take over the moved file's ID.
+	writeTaggedMP3ForTest(t, filepath.Join(downloadDir, "a.mp3"), core.AudioTags{Title: "Other", Artist: "Y"})
+	invalidateLocalMusicScanCache()
+	if err := syncLocalMusicIndex(); err != nil {
+		t.Fatal(err)
+	}
+
+	var rows []LocalMusicIndex
+	db.Order("rel_path").Find(&rows)
+	if len(rows) != 2 || rows[0].RelPath != "X/A.mp3" || rows[0].ID != id {
+		t.Fatalf("rows = %+v", rows)
+	}
+	if rows[1].RelPath != "a.mp3" || rows[1].ID == id {
+		t.Fatalf("new file at old path = %+v", rows[1])
+	}
+	track, err := localMusicTrackByID(id)
+	if err != nil || track.RelPath != "X/A.mp3" || track.Name != "A" {
+		t.Fatalf("track by stable id = %+v, %v", track, err)
+	}
+	if other, err := localMusicTrackByID(rows[1].ID); err != nil || other.Name != "Other" {
+		t.Fatalf("track by new id = %+v, %v", other, err)
+	}
+}
+
+func TestLocalMusicIDMatchesMovedFileByContent(t *testing.T) {
+	initCollectionDBForTest(t)
+	downloadDir := t.TempDir()
+	withLocalMusicDownloadDir(t, downloadDir)
+
+	path := filepath.Join(downloadDir, "song.mp3")
+	writeTaggedMP3ForTest(t, path, core.AudioTags{Title: "Song"})
+	track, err := localMusicTrackByID(encodeLocalMusicID("song.mp3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Copy then delete, as across file systems: a new inode, same content.
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	writeLocalMusicFileForTest(t, filepath.Join(downloadDir, "sub", "renamed.mp3"), string(data))
+	// 模拟重启：CloseDB 写回缓存里的身份，InitDB 清空缓存。
+	if err := flushLocalMusicIdentities(); err != nil {
+		t.Fatal(err)
+	}
+	resetLocalMusicIdentityCache()
+	moved, err := buildLocalMusicTrack(localLibraryRoots()[0], filepath.Join(downloadDir, "sub", "renamed.mp3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if moved.ID != track.ID {
+		t.Fatalf("id after copy = %s, want %s", moved.ID, track.ID)
+	}
+}
+
+func TestLocalMusicIDNeedsSameSizeForContentMatch(t *testing.T) {
+	initCollectionDBForTest(t)
+	downloadDir := t.TempDir()
+	withLocalMusicDownloadDir(t, downloadDir)
+
+	// Only the tail is hashed, so two files that share it hash the same.
+	tail := strings.Repeat("t", localMusicContentHashBytes)
+	path := filepath.Join(downloadDir, "song.mp3")
+	writeLocalMusicFileForTest(t, path, strings.Repeat("a", 1024)+tail)
+	track, err := localMusicTrackByID(encodeLocalMusicID("song.mp3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash := localMusicContentHash(path)
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	other := filepath.Join(downloadDir, "other.mp3")
+	writeLocalMusicFileForTest(t, other, strings.Repeat("b", 2048)+tail)
+	if localMusicContentHash(other) != hash {
+		t.Fatal("test files should share the content hash")
+	}
+	got, err := buildLocalMusicTrack(localLibraryRoots()[0], other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID == track.ID {
+		t.Fatalf("file with another size adopted id %s", track.ID)
+	}
+}
+
+func TestLocalMusicLegacyIDIsMigrated(t *testing.T) {
+	initCollectionDBForTest(t)
+	downloadDir := t.TempDir()
+	withLocalMusicDownloadDir(t, downloadDir)
+
+	writeTaggedMP3ForTest(t, filepath.Join(downloadDir, "b.mp3"), core.AudioTags{Title: "B"})
+	// b.mp3 already belongs to an identity with a different ID, e.g. after
+	// a move; a collection still holds the old path-based ID.
+	db.Create(&LocalMusicIdentity{ID: "stable", Root: "", RelPath: "b.mp3", SeenAt: time.Now()})
+	legacy := encodeLocalMusicID("b.mp3")
+	collection := Collection{Name: "Fav", Kind: collectionKindManual, ContentType: collectionContentPlaylist, Source: "local"}
+	db.Create(&collection)
+	db.Create(&SavedSong{CollectionID: collection.ID, SongID: legacy, Source: localMusicSource, Name: "B"})
+
+	track, err := localMusicTrackByID(legacy)
+	if err != nil || track.ID != "stable" || track.Name != "B" {
+		t.Fatalf("track by legacy id = %+v, %v", track, err)
+	}
+	var saved SavedSong
+	if err := db.First(&saved, "collection_id = ?", collection.ID).Error; err != nil || saved.SongID != "stable" {
+		t.Fatalf("saved song = %+v, %v", saved, err)
+	}
+}
+
+func TestLocalMusicIdentitiesArePreloadedAndWrittenInBatches(t *testing.T) {
+	initCollectionDBForTest(t)
+	downloadDir := t.TempDir()
+	withLocalMusicDownloadDir(t, downloadDir)
+
+	// 表里已有的身份在第一次查找时整表载入。
+	writeLocalMusicFileForTest(t, filepath.Join(downloadDir, "known.mp3"), "known")
+	db.Create(&LocalMusicIdentity{ID: "known", RelPath: "known.mp3", SeenAt: time.Now()})
+	for _, name := range []string{"a.mp3", "b.mp3"} {
+		writeLocalMusicFileForTest(t, filepath.Join(downloadDir, name), name)
+	}
+	root := localLibraryRoots()[0]
+	for name, want := range map[string]string{"known.mp3": "known", "a.mp3": encodeLocalMusicID("a.mp3"), "b.mp3": encodeLocalMusicID("b.mp3")} {
+		track, err := buildLocalMusicTrack(root, filepath.Join(downloadDir, name))
+		if err != nil || track.ID != want {
+			t.Fatalf("%s id = %+v, %v; want %s", name, track, err, want)
+		}
+	}
+
+	// 新身份先留在缓存里，写回时一起落库。
+	var count int64
+	db.Model(&LocalMusicIdentity{}).Count(&count)
+	if count != 1 {
+		t.Fatalf("identities before flush = %d, want 1", count)
+	}
+	if err := flushLocalMusicIdentities(); err != nil {
+		t.Fatal(err)
+	}
+	var known LocalMusicIdentity
+	db.Model(&LocalMusicIdentity{}).Count(&count)
+	if err := db.First(&known, "id = ?", "known").Error; err != nil || count != 3 || known.ContentHash == "" {
+		t.Fatalf("identities after flush = %d, known = %+v, %v", count, known, err)
+	}
+}
@@ -5,10 +5,14 @@ import (
 	"net/url"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/guohuiyuan/go-music-dl/core"
 	"github.com/guohuiyuan/music-lib/model"
+	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
 )
 
@@ -18,26 +22,123 @@ func formatSizeForIndex(size int64) string {
 	}
 	mb := float64(size) / 1024 / 1024
 	return fmt.Sprintf("%.2f MB", mb)
-} // LocalMusicIndex 是下载目录的搜索索引行。磁盘文件仍是唯一真相，
-// 该表只用于加速对大量本地文件的关键词搜索。主键沿用 base64(相对路径)。
+} // LocalMusicIndex 是各曲库根目录的搜索索引行。磁盘文件仍是唯一真相，
+// 该表只用于加速对大量本地文件的关键词搜索。ID 是 LocalMusicIdentity 的稳定 ID。
 type LocalMusicIndex struct {
-	ID        string    `gorm:"column:id;primaryKey"`
-	RelPath   string    `gorm:"column:rel_path;uniqueIndex;not null"`
-	Name      string    `gorm:"column:name;index"`
-	Artist    string    `gorm:"column:artist;index"`
-	Album     string    `gorm:"column:album;index"`
-	Duration  int       `gorm:"column:duration"`
-	Size      int64     `gorm:"column:size"`
-	Ext       string    `gorm:"column:ext"`
-	Cover     string    `gorm:"column:cover"`
-	HasCover  bool      `gorm:"column:has_cover"`
-	HasLyric  bool      `gorm:"column:has_lyric"`
-	ModTime   time.Time `gorm:"column:mod_time"`
-	ScannedAt time.Time `gorm:"column:scanned_at;index"`
+	// Seq 是 rowid 的别名，给 local_music_fts 当 content_rowid。没有 INTEGER
+	// PRIMARY KEY 的表在 VACUUM 后 rowid 可能被重排，全文索引就会对不上行。
+	Seq     int64  `gorm:"column:seq;primaryKey;autoIncrement" json:"-"`
+	ID      string `gorm:"column:id;not null;uniqueIndex:idx_local_music_index_id"`
+	Root    string `gorm:"column:root;not null;default:'';uniqueIndex:idx_local_music_index_root_rel_path,priority:1"`
+	RelPath string `gorm:"column:rel_path;not null;uniqueIndex:idx_local_music_index_root_rel_path,priority:2"`
+	Name    string `gorm:"column:name;index"`
+	Artist  string `gorm:"column:artist;index"`
+	Album   string `gorm:"column:album;index"`
+	// AlbumArtist 为空时按 Artist 分组专辑。
+	AlbumArtist string    `gorm:"column:album_artist;not null;default:''"`
+	Duration    int       `gorm:"column:duration"`
+	Size        int64     `gorm:"column:size"`
+	Ext         string    `gorm:"column:ext"`
+	Cover       string    `gorm:"column:cover"`
+	HasCover    bool      `gorm:"column:has_cover"`
+	HasLyric    bool      `gorm:"column:has_lyric"`
+	ModTime     time.Time `gorm:"column:mod_time"`
+	ScannedAt   time.Time `gorm:"column:scanned_at;index"`
+	// AddedAt 是首次入库时间（取文件修改时间与首次扫描时间中较早者），
+	// 之后的重扫不再改动，用于“最近添加”。
+	AddedAt time.Time `gorm:"column:added_at;index"`
+	// Fingerprint 是可选的音频指纹（core.EncodeFingerprint），FingerprintKey
+	// 记录计算时的大小和修改时间，文件变了指纹就作废；重扫不会覆盖这两列。
+	Fingerprint    string `gorm:"column:fingerprint;not null;default:''"`
+	FingerprintKey string `gorm:"column:fingerprint_key;not null;default:''"`
+	// Loudness* 是 ebur128 测出的整体响度（LUFS）、响度范围（LU）和真峰值
+	// （dBTP），LoudnessKey 和 FingerprintKey 一样标记测量时的文件版本。
+	Loudness      float64 `gorm:"column:loudness;not null;default:0"`
+	LoudnessRange float64 `gorm:"column:loudness_range;not null;default:0"`
+	LoudnessPeak  float64 `gorm:"column:loudness_peak;not null;default:0"`
+	LoudnessKey   string  `gorm:"column:loudness_key;not null;default:''"`
+
+	// search_* 是归一化后的检索文本，由 local_music_fts 的触发器同步到全文索引。
+	SearchName   string `gorm:"column:search_name;not null;default:''"`
+	SearchArtist string `gorm:"column:search_artist;not null;default:''"`
+	SearchAlbum  string `gorm:"column:search_album;not null;default:''"`
+	SearchFile   string `gorm:"column:search_file;not null;default:''"`
+	SearchLyrics string `gorm:"column:search_lyrics;not null;default:''"`
+	SearchPinyin string `gorm:"column:search_pinyin;not null;default:''"`
 }
 
 func (LocalMusicIndex) TableName() string { return "local_music_index" }
 
+// migrateLocalMusicIndexRoots drops the single-root unique index on rel_path;
+// the same relative path may now exist under several library roots.
+func migrateLocalMusicIndexRoots() error {
+	migrator := db.Migrator()
+	if migrator.HasIndex(&LocalMusicIndex{}, "idx_local_music_index_rel_path") {
+		return migrator.DropIndex(&LocalMusicIndex{}, "idx_local_music_index_rel_path")
+	}
+	return nil
+}
+
+// migrateLocalMusicIndexSeq rebuilds an index table created with the TEXT id
+// as primary key into the current layout with a seq rowid alias. It runs
+// before AutoMigrate, which cannot add a primary key column in SQLite. The
+// old full-text table and triggers are dropped so migrateLocalMusicFTS
+// rebuilds them against seq.
+func migrateLocalMusicIndexSeq(database *gorm.DB) error {
+	migrator := database.Migrator()
+	if !migrator.HasTable(&LocalMusicIndex{}) || migrator.HasColumn(&LocalMusicIndex{}, "seq") {
+		return nil
+	}
+	const legacyTable = "local_music_index_legacy"
+	return database.Transaction(func(tx *gorm.DB) error {
+		for _, stmt := range []string{
+			"DROP TRIGGER IF EXISTS local_music_fts_ai",
+			"DROP TRIGGER IF EXISTS local_music_fts_ad",
+			"DROP TRIGGER IF EXISTS local_music_fts_au",
+			"DROP TABLE IF EXISTS " + localMusicFTSTable,
+			"ALTER TABLE local_music_index RENAME TO " + legacyTable,
+		} {
+			if err := tx.Exec(stmt).Error; err != nil {
+				return err
+			}
+		}
+		// 改名后索引名不变，先删掉才能在新表上按原名重建。
+		var indexes []string
+		if err := tx.Raw("SELECT name FROM sqlite_master WHERE type = 'index' AND tbl_name = ? AND sql IS NOT NULL", legacyTable).Scan(&indexes).Error; err != nil {
+			return err
+		}
+		for _, name := range indexes {
+			if err := tx.Exec(`DROP INDEX "` + name + `"`).Error; err != nil {
+				return err
+			}
+		}
+		if err := tx.Migrator().CreateTable(&LocalMusicIndex{}); err != nil {
+			return err
+		}
+
+		var legacyColumns, columns []string
+		if err := tx.Raw("SELECT name FROM pragma_table_info(?)", legacyTable).Scan(&legacyColumns).Error; err != nil {
+			return err
+		}
+		for _, column := range legacyColumns {
+			if column != "seq" && tx.Migrator().HasColumn(&LocalMusicIndex{}, column) {
+				columns = append(columns, column)
+			}
+		}
+		list := strings.Join(columns, ", ")
+		if err := tx.Exec("INSERT INTO local_music_index (" + list + ") SELECT " + list + " FROM " + legacyTable + " ORDER BY rowid").Error; err != nil {
+			return err
+		}
+		return tx.Exec("DROP TABLE " + legacyTable).Error
+	})
+}
+
+// migrateLocalMusicIndexAddedAt fills added_at for rows indexed before the
+// column existed, using the file modification time.
+func migrateLocalMusicIndexAddedAt() error {
+	return db.Exec("UPDATE local_music_index SET added_at = COALESCE(mod_time, scanned_at) WHERE added_at IS NULL").Error
+}
+
 func containsLocalSource(sources []string) bool {
 	for _, s := range sources {
 		if isLocalMusicSource(s) {
@@ -60,10 +161,18 @@ func localMusicIndexExtra(row *LocalMusicIndex) map[string]string {
 	if row.HasLyric {
 		extra["lyric"] = "true"
 	}
+	if row.Root != "" {
+		extra["library_root"] = row.Root
+	}
+	if row.hasFreshLoudness() {
+		loudness := row.loudness()
+		extra[core.ExtraReplayGainTrackGain] = strconv.FormatFloat(loudness.TrackGain(), 'f', 2, 64)
+		extra[core.ExtraReplayGainTrackPeak] = strconv.FormatFloat(loudness.PeakRatio(), 'f', 6, 64)
+	}
 	return extra
 }
 
-func localMusicTrackToIndexRow(track *localMusicTrack, scannedAt time.Time) LocalMusicIndex {
+func localMusicTrackToIndexRow(track *localMusicTrack, scannedAt time.Time, opts localMusicSearchOptions) LocalMusicIndex {
 	hasCover := strings.TrimSpace(track.Cover) != ""
 	hasLyric := false
 	if track.Extra != nil {
@@ -72,33 +181,52 @@ func localMusicTrackToIndexRow(track *localMusicTrack, scannedAt time.Time) Loca
 		}
 		hasLyric = track.Extra["lyric"] == "true"
 	}
-	return LocalMusicIndex{
-		ID:        track.ID,
-		RelPath:   track.RelPath,
-		Name:      track.Name,
-		Artist:    track.Artist,
-		Album:     track.Album,
-		Duration:  track.Duration,
-		Size:      track.Size,
-		Ext:       track.Ext,
-		Cover:     track.Cover,
-		HasCover:  hasCover,
-		HasLyric:  hasLyric,
-		ModTime:   track.modTime,
-		ScannedAt: scannedAt,
-	}
+	row := LocalMusicIndex{
+		ID:          track.ID,
+		Root:        track.Root,
+		RelPath:     track.RelPath,
+		Name:        track.Name,
+		Artist:      track.Artist,
+		Album:       track.Album,
+		AlbumArtist: track.AlbumArtist,
+		Duration:    track.Duration,
+		Size:        track.Size,
+		Ext:         track.Ext,
+		Cover:       track.Cover,
+		HasCover:    hasCover,
+		HasLyric:    hasLyric,
+		ModTime:     track.modTime,
+		ScannedAt:   scannedAt,
+		AddedAt:     scannedAt,
+	}
+	if !track.modTime.IsZero() && track.modTime.Before(scannedAt) {
+		row.AddedAt = track.modTime
+	}
+	row.setSearchColumns(opts, track.lyricText)
+	return row
 }
 
 // syncLocalMusicIndex 全量扫描下载目录并把结果 upsert 进索引表，
 // 同时清扫掉本轮未出现（文件已消失）的行。
-func syncLocalMusicIndex() error {
+func syncLocalMusicIndex() (err error) {
 	if db == nil {
 		return nil
 	}
+	start := time.Now()
+	defer func() {
+		outcome := "ok"
+		if err != nil {
+			outcome = "error"
+		}
+		localMusicScansTotal.Inc(outcome)
+		localMusicScanDuration.Observe(time.Since(start).Seconds())
+	}()
+
 	tracks, dir, exists, err := scanLocalMusicTracks()
 	if err != nil {
 		return err
 	}
+	localMusicScanTracks.Set(float64(len(tracks)))
 	if err := syncTracksToIndex(tracks); err != nil {
 		return err
 	}
@@ -114,7 +242,9 @@ func syncLocalMusicIndex() error {
 // syncLocalMusicIndexAsync 在后台跑一次全量同步，不阻塞调用方（启动时用）。
 func syncLocalMusicIndexAsync() {
 	go func() {
-		_ = syncLocalMusicIndex()
+		if err := syncLocalMusicIndex(); err != nil {
+			core.Logger().Warn("local music index sync failed", "error", err)
+		}
 	}()
 }
 
@@ -138,13 +268,34 @@ func loadTracksFromIndex(offset int, limit int) ([]*localMusicTrack, int, bool)
 		return nil, 0, false
 	}
 
-	rootAbs, _ := filepath.Abs(localMusicDownloadDir())
+	tracks, missingIDs := localMusicIndexRowsToTracks(rows)
+	if len(missingIDs) > 0 {
+		if err := db.Where("id IN ?", missingIDs).Delete(&LocalMusicIndex{}).Error; err != nil {
+			return nil, 0, false
+		}
+		total -= int64(len(missingIDs))
+	}
+
+	if len(tracks) == 0 {
+		return nil, 0, false
+	}
+	return tracks, int(total), true
+}
+
+// localMusicIndexRowsToTracks turns index rows into list tracks, skipping rows
+// whose file is gone. The skipped IDs are returned so callers can prune them.
+func localMusicIndexRowsToTracks(rows []LocalMusicIndex) ([]*localMusicTrack, []string) {
+	roots := localLibraryRootLabels()
 	tracks := make([]*localMusicTrack, 0, len(rows))
 	missingIDs := make([]string, 0)
 	for i := range rows {
 		row := &rows[i]
 		// 快速校验文件是否还在磁盘上
-		absPath := filepath.Join(rootAbs, filepath.FromSlash(row.RelPath))
+		absPath, ok := localMusicIndexRowPath(roots, row)
+		if !ok {
+			missingIDs = append(missingIDs, row.ID)
+			continue
+		}
 		if info, statErr := os.Stat(absPath); statErr != nil || info.IsDir() {
 			missingIDs = append(missingIDs, row.ID)
 			continue
@@ -154,61 +305,100 @@ func loadTracksFromIndex(offset int, limit int) ([]*localMusicTrack, int, bool)
 			cover = RoutePrefix + "/local_music/cover?id=" + url.QueryEscape(row.ID)
 		}
 		tracks = append(tracks, &localMusicTrack{
-			ID:       row.ID,
-			Source:   localMusicSource,
-			Name:     row.Name,
-			Artist:   row.Artist,
-			Album:    row.Album,
-			Cover:    cover,
-			Duration: row.Duration,
-			Filename: filepath.Base(row.RelPath),
-			RelPath:  row.RelPath,
-			Ext:      row.Ext,
-			Size:     row.Size,
-			SizeText: formatSizeForIndex(row.Size),
-			Extra:    localMusicIndexExtra(row),
+			ID:          row.ID,
+			Source:      localMusicSource,
+			Name:        row.Name,
+			Artist:      row.Artist,
+			Album:       row.Album,
+			AlbumArtist: row.AlbumArtist,
+			Cover:       cover,
+			Duration:    row.Duration,
+			Filename:    filepath.Base(row.RelPath),
+			RelPath:     row.RelPath,
+			Ext:         row.Ext,
+			Size:        row.Size,
+			SizeText:    formatSizeForIndex(row.Size),
+			ModifiedAt:  row.ModTime,
+			Root:        row.Root,
+			RootLabel:   roots[row.Root].Label,
+			ReadOnly:    roots[row.Root].ReadOnly,
+			Extra:       localMusicIndexExtra(row),
 		})
 	}
-	if len(missingIDs) > 0 {
-		if err := db.Where("id IN ?", missingIDs).Delete(&LocalMusicIndex{}).Error; err != nil {
-			return nil, 0, false
-		}
-		total -= int64(len(missingIDs))
-	}
-
-	if len(tracks) == 0 {
-		return nil, 0, false
-	}
-	return tracks, int(total), true
+	return tracks, missingIDs
 }
 
 // syncTracksToIndex writes one completed scan and removes rows that did not
 // appear in that scan, including the case where the directory is now empty.
+// Rows of roots that are currently unreachable are kept, so an offline NAS
+// does not empty its part of the index; rows of removed roots are dropped.
 func syncTracksToIndex(tracks []*localMusicTrack) error {
 	database := db
 	if database == nil {
 		return nil
 	}
 	runStart := time.Now()
+	opts := localMusicSearchOptionsProvider()
 	rows := make([]LocalMusicIndex, 0, len(tracks))
 	for _, t := range tracks {
 		if t == nil {
 			continue
 		}
-		rows = append(rows, localMusicTrackToIndexRow(t, runStart))
+		rows = append(rows, localMusicTrackToIndexRow(t, runStart, opts))
 	}
 	if len(rows) > 0 {
+		if err := parkLocalMusicIndexPathConflicts(database, rows); err != nil {
+			return err
+		}
 		if err := database.Clauses(clause.OnConflict{
-			Columns: []clause.Column{{Name: "id"}},
-			DoUpdates: clause.AssignmentColumns([]string{
-				"rel_path", "name", "artist", "album", "duration", "size",
-				"ext", "cover", "has_cover", "has_lyric", "mod_time", "scanned_at",
-			}),
+			Columns:   []clause.Column{{Name: "id"}},
+			DoUpdates: clause.AssignmentColumns(localMusicIndexUpdateColumns),
 		}).CreateInBatches(rows, 200).Error; err != nil {
 			return err
 		}
 	}
-	return database.Where("scanned_at < ?", runStart).Delete(&LocalMusicIndex{}).Error
+	if err := database.Where("scanned_at < ? AND root NOT IN ?", runStart, unreachableLocalLibraryRootKeys()).Delete(&LocalMusicIndex{}).Error; err != nil {
+		return err
+	}
+	return touchLocalMusicIdentities(database, runStart)
+}
+
+// unreachableLocalLibraryRootKeys lists configured extra roots whose directory
+// cannot be read right now. It always holds the placeholder "/" (never a valid
+// key) so it can be used in a NOT IN clause.
+func unreachableLocalLibraryRootKeys() []string {
+	keys := []string{"/"}
+	for _, root := range localLibraryRoots()[1:] {
+		if info, err := os.Stat(root.Abs); err != nil || !info.IsDir() {
+			keys = append(keys, root.Key)
+		}
+	}
+	return keys
+}
+
+// syncLocalLibraryRootIndex rescans one root and sweeps only that root's rows.
+// The watcher uses it for its periodic reconciliation.
+func syncLocalLibraryRootIndex(root localLibraryRoot) error {
+	database := db
+	if database == nil {
+		return nil
+	}
+	tracks, exists, err := scanLocalLibraryRoot(root)
+	if err != nil {
+		return err
+	}
+	if !exists && root.Key != "" {
+		return fmt.Errorf("library root %s is not reachable", root.Path)
+	}
+	runStart := time.Now()
+	opts := localMusicSearchOptionsProvider()
+	for _, track := range tracks {
+		row := localMusicTrackToIndexRow(track, runStart, opts)
+		if err := upsertLocalMusicIndex(database, &row); err != nil {
+			return err
+		}
+	}
+	return database.Where("root = ? AND scanned_at < ?", root.Key, runStart).Delete(&LocalMusicIndex{}).Error
 }
 
 // findLocalMusicMatch finds a real file among plausible index candidates.
@@ -218,11 +408,7 @@ func findLocalMusicMatch(name string, artist string) (*LocalMusicIndex, string,
 	if db == nil {
 		return nil, "", nil
 	}
-	rootAbs, err := filepath.Abs(localMusicDownloadDir())
-	if err != nil {
-		return nil, "", err
-	}
-
+	roots := localLibraryRootLabels()
 	seenIDs := make(map[string]struct{})
 	staleIDs := make([]string, 0)
 	findExisting := func(rows []LocalMusicIndex) (*LocalMusicIndex, string) {
@@ -233,7 +419,11 @@ func findLocalMusicMatch(name string, artist string) (*LocalMusicIndex, string,
 			}
 			seenIDs[row.ID] = struct{}{}
 
-			absPath := filepath.Join(rootAbs, filepath.FromSlash(row.RelPath))
+			absPath, ok := localMusicIndexRowPath(roots, &row)
+			if !ok {
+				staleIDs = append(staleIDs, row.ID)
+				continue
+			}
 			if info, statErr := os.Stat(absPath); statErr != nil || info.IsDir() {
 				staleIDs = append(staleIDs, row.ID)
 				continue
@@ -250,9 +440,27 @@ func findLocalMusicMatch(name string, artist string) (*LocalMusicIndex, string,
 		row, absPath := findExisting(rows)
 		return row, absPath, nil
 	}
+	fuzzy := func(name string, artist string) (*LocalMusicIndex, string, error) {
+		if localMusicFTSReady.Load() {
+			if match, ok := localMusicFuzzyMatchQuery(name, artist, localMusicSearchOptionsProvider()); ok {
+				rows, err := searchLocalMusicFTS(db, match, 20)
+				if err == nil {
+					row, absPath := findExisting(rows)
+					return row, absPath, nil
+				}
+				core.Logger().Warn("local music full-text match failed", "query", match, "error", err)
+			}
+		}
+		if artist != "" {
+			return lookup("name LIKE ? AND artist = ?", "%"+name+"%", artist)
+		}
+		return lookup("name LIKE ?", "%"+name+"%")
+	}
 	cleanupStale := func() {
 		if len(staleIDs) > 0 {
-			_ = db.Where("id IN ?", staleIDs).Delete(&LocalMusicIndex{}).Error
+			if err := db.Where("id IN ?", staleIDs).Delete(&LocalMusicIndex{}).Error; err != nil {
+				core.Logger().Warn("delete stale local music index rows failed", "count", len(staleIDs), "error", err)
+			}
 		}
 	}
 
@@ -265,7 +473,7 @@ func findLocalMusicMatch(name string, artist string) (*LocalMusicIndex, string,
 		// A title alone is not enough when the search result has an artist.
 		// Keep the fuzzy-title fallback within that same artist to avoid
 		// redirecting one artist's song to a different local recording.
-		row, absPath, err = lookup("name LIKE ? AND artist = ?", "%"+name+"%", artist)
+		row, absPath, err = fuzzy(name, artist)
 		cleanupStale()
 		return row, absPath, err
 	}
@@ -274,24 +482,91 @@ func findLocalMusicMatch(name string, artist string) (*LocalMusicIndex, string,
 		cleanupStale()
 		return row, absPath, err
 	}
-	row, absPath, err = lookup("name LIKE ?", "%"+name+"%")
+	row, absPath, err = fuzzy(name, "")
 	cleanupStale()
 	return row, absPath, err
 }
 
+// localMusicFuzzyMatchQuery builds the FTS expression behind the fuzzy step
+// of findLocalMusicMatch: every title word as a prefix in the title column
+// and, when given, every artist word exactly in the artist column, so
+// "周杰倫" still pins the match to 周杰伦 but "Jay" does not match "Jayden".
+func localMusicFuzzyMatchQuery(name string, artist string, opts localMusicSearchOptions) (string, bool) {
+	opts.Pinyin = false
+	match, ok := localMusicFTSQuery(name, opts, true, "search_name")
+	if !ok || artist == "" {
+		return match, ok
+	}
+	artistMatch, ok := localMusicFTSQuery(artist, opts, false, "search_artist")
+	if !ok {
+		return "", false
+	}
+	return match + " AND " + artistMatch, true
+}
+
 // upsertLocalMusicIndexRow 针对单个文件做定向 upsert（上传后用）。
 func upsertLocalMusicIndexRow(track *localMusicTrack) {
 	if db == nil || track == nil {
 		return
 	}
-	row := localMusicTrackToIndexRow(track, time.Now())
-	_ = db.Clauses(clause.OnConflict{
-		Columns: []clause.Column{{Name: "id"}},
-		DoUpdates: clause.AssignmentColumns([]string{
-			"rel_path", "name", "artist", "album", "duration", "size",
-			"ext", "cover", "has_cover", "has_lyric", "mod_time", "scanned_at",
-		}),
-	}).Create(&row).Error
+	row := localMusicTrackToIndexRow(track, time.Now(), localMusicSearchOptionsProvider())
+	if err := upsertLocalMusicIndex(db, &row); err != nil {
+		core.Logger().Warn("upsert local music index row failed", "path", track.RelPath, "error", err)
+	}
+}
+
+func upsertLocalMusicIndex(database *gorm.DB, row *LocalMusicIndex) error {
+	if err := parkLocalMusicIndexPathConflicts(database, []LocalMusicIndex{*row}); err != nil {
+		return err
+	}
+	return database.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "id"}},
+		DoUpdates: clause.AssignmentColumns(localMusicIndexUpdateColumns),
+	}).Create(row).Error
+}
+
+// parkLocalMusicIndexPathConflicts moves rows off the paths about to be
+// written under another ID. That happens when a file moved and a different
+// file took its place: the moved row gets its real path back once its file is
+// seen again, otherwise the listing and the sweep prune it as missing. The
+// placeholder "../<id>" never resolves inside a root.
+func parkLocalMusicIndexPathConflicts(database *gorm.DB, rows []LocalMusicIndex) error {
+	var conflicts []LocalMusicIndex
+	if len(rows) == 1 {
+		if err := database.Select("id").Where("root = ? AND rel_path = ? AND id <> ?", rows[0].Root, rows[0].RelPath, rows[0].ID).Find(&conflicts).Error; err != nil {
+			return err
+		}
+	} else {
+		var existing []LocalMusicIndex
+		if err := database.Select("id", "root", "rel_path").Find(&existing).Error; err != nil {
+			return err
+		}
+		byPath := make(map[string]string, len(existing))
+		for _, row := range existing {
+			byPath[row.Root+"|"+row.RelPath] = row.ID
+		}
+		for _, row := range rows {
+			if id, ok := byPath[row.Root+"|"+row.RelPath]; ok && id != row.ID {
+				conflicts = append(conflicts, LocalMusicIndex{ID: id})
+			}
+		}
+	}
+	for _, row := range conflicts {
+		if err := database.Model(&LocalMusicIndex{}).Where("id = ?", row.ID).Update("rel_path", "../"+row.ID).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// localMusicIndexWriteMu 让指纹、响度等后台 worker 依次写索引：两个连接同时
+// 从读锁升级成写锁时 SQLite 直接返回 SQLITE_BUSY，不走 busy_timeout。
+var localMusicIndexWriteMu sync.Mutex
+
+var localMusicIndexUpdateColumns = []string{
+	"root", "rel_path", "name", "artist", "album", "album_artist", "duration", "size",
+	"ext", "cover", "has_cover", "has_lyric", "mod_time", "scanned_at",
+	"search_name", "search_artist", "search_album", "search_file", "search_lyrics", "search_pinyin",
 }
 
 // deleteLocalMusicIndexRow 删除单个索引行（删除文件后用）。
@@ -299,7 +574,19 @@ func deleteLocalMusicIndexRow(id string) {
 	if db == nil || strings.TrimSpace(id) == "" {
 		return
 	}
-	_ = db.Delete(&LocalMusicIndex{}, "id = ?", id).Error
+	if err := db.Delete(&LocalMusicIndex{}, "id = ?", id).Error; err != nil {
+		core.Logger().Warn("delete local music index row failed", "id", id, "error", err)
+	}
+}
+
+// deleteLocalMusicIndexRowAt 按路径删除索引行；文件已不在时无法再算出它的 ID。
+func deleteLocalMusicIndexRowAt(rootKey string, relPath string) {
+	if db == nil {
+		return
+	}
+	if err := db.Delete(&LocalMusicIndex{}, "root = ? AND rel_path = ?", rootKey, relPath).Error; err != nil {
+		core.Logger().Warn("delete local music index row failed", "path", relPath, "error", err)
+	}
 }
 
 // localMusicSearchSongs 在索引表里按关键词搜索本地歌曲。对返回的每行做
@@ -314,29 +601,23 @@ func localMusicSearchSongs(keyword string, limit int) []model.Song {
 		limit = 200
 	}
 
-	like := "%" + keyword + "%"
-	var rows []LocalMusicIndex
-	if err := db.Where("name LIKE ? OR artist LIKE ? OR album LIKE ?", like, like, like).
-		Order("mod_time DESC").
-		Limit(limit).
-		Find(&rows).Error; err != nil {
-		return nil
-	}
-
-	rootAbs, err := filepath.Abs(localMusicDownloadDir())
+	rows, err := searchLocalMusicIndex(keyword, limit)
 	if err != nil {
-		rootAbs = ""
+		return nil
 	}
 
+	roots := localLibraryRootLabels()
 	songs := make([]model.Song, 0, len(rows))
 	for i := range rows {
 		row := &rows[i]
-		if rootAbs != "" {
-			absPath := filepath.Join(rootAbs, filepath.FromSlash(row.RelPath))
-			if info, statErr := os.Stat(absPath); statErr != nil || info.IsDir() {
-				deleteLocalMusicIndexRow(row.ID)
-				continue
-			}
+		absPath, ok := localMusicIndexRowPath(roots, row)
+		if !ok {
+			deleteLocalMusicIndexRow(row.ID)
+			continue
+		}
+		if info, statErr := os.Stat(absPath); statErr != nil || info.IsDir() {
+			deleteLocalMusicIndexRow(row.ID)
+			continue
 		}
 		cover := row.Cover
 		if cover == "" && row.HasCover {
@@ -356,6 +637,28 @@ func localMusicSearchSongs(keyword string, limit int) []model.Song {
 	return songs
 }
 
+// searchLocalMusicIndex ranks rows through local_music_fts, so results can
+// match pinyin, initials, the other Chinese script, lyrics or words in any
+// order. Without FTS5 it falls back to substring matching.
+func searchLocalMusicIndex(keyword string, limit int) ([]LocalMusicIndex, error) {
+	if localMusicFTSReady.Load() {
+		if match, ok := localMusicFTSQuery(keyword, localMusicSearchOptionsProvider(), true); ok {
+			rows, err := searchLocalMusicFTS(db, match, limit)
+			if err == nil {
+				return rows, nil
+			}
+			core.Logger().Warn("local music full-text search failed", "query", match, "error", err)
+		}
+	}
+	like := "%" + keyword + "%"
+	var rows []LocalMusicIndex
+	err := db.Where("name LIKE ? OR artist LIKE ? OR album LIKE ?", like, like, like).
+		Order("mod_time DESC").
+		Limit(limit).
+		Find(&rows).Error
+	return rows, err
+}
+
 // localCollectionSearchPlaylists 在本地歌单（Collection）里按名称/描述/创建者搜索，
 // 返回 model.Playlist 卡片（Source=local），用于"歌单搜索 + 勾选 local"。
 func localCollectionSearchPlaylists(keyword string) []model.Playlist {
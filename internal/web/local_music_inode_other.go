@@ -0,0 +1,19 @@
+//go:build !windows
+
+package web
+
+import (
+	"os"
+	"syscall"
+)
+
+// localMusicFileInode returns the file's inode, or 0 when it is unknown.
+func localMusicFileInode(info os.FileInfo) int64 {
+	if info == nil {
+		return 0
+	}
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return int64(stat.Ino)
+	}
+	return 0
+}
@@ -0,0 +1,11 @@
+//go:build windows
+
+package web
+
+import "os"
+
+// localMusicFileInode returns 0: os.FileInfo carries no file index on
+// Windows, so moved files are recognised by content hash alone.
+func localMusicFileInode(info os.FileInfo) int64 {
+	return 0
+}
@@ -0,0 +1,288 @@
+package web
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/guohuiyuan/go-music-dl/core"
+)
+
+// 响度分析：不同来源的音量差得很多（B 站转录和 QQ 母带能差十几 dB）。这里用
+// ffmpeg 的 ebur128 测出每个文件的 EBU R128 整体响度和真峰值存进索引，
+// 播放器据此按 ReplayGain 2.0（-18 LUFS）调整音量；可选地把
+// REPLAYGAIN_TRACK_* 标签写回 mp3/flac，供其他播放器使用。
+
+const (
+	localMusicLoudnessWorkers = 2
+	// localMusicReplayGainMaxIDs 限制一次查询的曲目数，播放列表再长也分批取。
+	localMusicReplayGainMaxIDs = 500
+)
+
+var (
+	localMusicLoudnessAnalyze = core.AnalyzeLoudness
+	localMusicLoudnessReady   = func() error {
+		if _, err := core.ResolveFFmpegPath(); err != nil {
+			return errors.New("响度分析需要 ffmpeg")
+		}
+		return nil
+	}
+
+	localMusicLoudnessJobs = newBackgroundJobSlot[*localMusicLoudnessJob]("已有响度分析任务在进行中")
+)
+
+type localMusicLoudnessJob struct {
+	backgroundJob
+	WriteTags bool `json:"write_tags"`
+	Analyzed  int  `json:"analyzed"`
+	Tagged    int  `json:"tagged"`
+
+	ids []string
+}
+
+// localMusicReplayGain is one track's gain as served to the web player.
+type localMusicReplayGain struct {
+	// Gain 是 ReplayGain 单曲增益（dB），Peak 是线性真峰值，Adjust 是
+	// 为避免削波而限制后的实际增益。
+	Gain     float64       `json:"gain"`
+	Peak     float64       `json:"peak"`
+	Adjust   float64       `json:"adjust"`
+	Loudness core.Loudness `json:"loudness"`
+}
+
+func registerLocalMusicLoudnessRoutes(api *gin.RouterGroup) {
+	api.GET("/local_music/loudness", func(c *gin.Context) {
+		total, analyzed := countLocalMusicLoudness()
+		body := gin.H{"total": total, "analyzed": analyzed, "job": nil, "tag_exts": core.ReplayGainWritableExts}
+		if err := localMusicLoudnessReady(); err != nil {
+			body["error"] = err.Error()
+		}
+		if job := localMusicLoudnessJobs.get(); job != nil {
+			body["job"] = job.snapshot()
+		}
+		c.JSON(http.StatusOK, body)
+	})
+
+	api.POST("/local_music/loudness", requireSameOriginWrite, func(c *gin.Context) {
+		var req struct {
+			IDs       []string `json:"ids"`
+			WriteTags bool     `json:"write_tags"`
+		}
+		if c.Request.ContentLength > 0 {
+			if err := c.ShouldBindJSON(&req); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+				return
+			}
+		}
+		job, err := startLocalMusicLoudnessJob(req.IDs, req.WriteTags)
+		if err != nil {
+			localMusicLoudnessJobs.startError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "started", "job": job.snapshot()})
+	})
+
+	api.POST("/local_music/loudness/cancel", requireSameOriginWrite, func(c *gin.Context) {
+		job := localMusicLoudnessJobs.get()
+		if job == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "没有响度分析任务"})
+			return
+		}
+		job.cancel()
+		c.JSON(http.StatusOK, gin.H{"status": "ok"})
+	})
+
+	// GET /local_music/replaygain?ids=a&ids=b 返回已分析曲目的增益，未分析或
+	// 文件已变动的曲目不出现在结果里。
+	api.GET("/local_music/replaygain", func(c *gin.Context) {
+		ids := c.QueryArray("ids")
+		if len(ids) > localMusicReplayGainMaxIDs {
+			ids = ids[:localMusicReplayGainMaxIDs]
+		}
+		c.JSON(http.StatusOK, gin.H{"tracks": localMusicReplayGains(ids)})
+	})
+}
+
+func (row *LocalMusicIndex) hasFreshLoudness() bool {
+	return row.LoudnessKey != "" && row.LoudnessKey == localMusicFingerprintKey(row.Size, row.ModTime)
+}
+
+func (row *LocalMusicIndex) loudness() core.Loudness {
+	return core.Loudness{Integrated: row.Loudness, Range: row.LoudnessRange, TruePeak: row.LoudnessPeak}
+}
+
+func countLocalMusicLoudness() (total int, analyzed int) {
+	if db == nil {
+		return 0, 0
+	}
+	var rows []LocalMusicIndex
+	if err := db.Select("id", "size", "mod_time", "loudness_key").Find(&rows).Error; err != nil {
+		return 0, 0
+	}
+	for i := range rows {
+		if rows[i].hasFreshLoudness() {
+			analyzed++
+		}
+	}
+	return len(rows), analyzed
+}
+
+func localMusicReplayGains(ids []string) map[string]localMusicReplayGain {
+	gains := make(map[string]localMusicReplayGain)
+	if db == nil || len(ids) == 0 {
+		return gains
+	}
+	var rows []LocalMusicIndex
+	if err := db.Select("id", "size", "mod_time", "loudness", "loudness_range", "loudness_peak", "loudness_key").
+		Where("id IN ?", ids).Find(&rows).Error; err != nil {
+		return gains
+	}
+	for i := range rows {
+		if !rows[i].hasFreshLoudness() {
+			continue
+		}
+		loudness := rows[i].loudness()
+		gain, peak := loudness.TrackGain(), loudness.PeakRatio()
+		gains[rows[i].ID] = localMusicReplayGain{
+			Gain:     gain,
+			Peak:     peak,
+			Adjust:   core.ReplayGainAdjustment(gain, peak),
+			Loudness: loudness,
+		}
+	}
+	return gains
+}
+
+// startLocalMusicLoudnessJob analyses ids, or every indexed file without a
+// fresh measurement when ids is empty. writeTags also stores the result as
+// REPLAYGAIN_* tags in writable mp3/flac files.
+func startLocalMusicLoudnessJob(ids []string, writeTags bool) (*localMusicLoudnessJob, error) {
+	if db == nil {
+		return nil, errors.New("曲库索引不可用")
+	}
+	if err := localMusicLoudnessReady(); err != nil {
+		return nil, err
+	}
+	targets := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if id = strings.TrimSpace(id); id != "" && !containsString(targets, id) {
+			targets = append(targets, id)
+		}
+	}
+	if len(targets) == 0 {
+		var rows []LocalMusicIndex
+		if err := db.Select("id", "size", "mod_time", "loudness_key").Order("rel_path").Find(&rows).Error; err != nil {
+			return nil, err
+		}
+		for i := range rows {
+			if !rows[i].hasFreshLoudness() {
+				targets = append(targets, rows[i].ID)
+			}
+		}
+	}
+	if len(targets) == 0 {
+		return nil, errors.New("所有本地音乐都已分析过响度")
+	}
+
+	job := &localMusicLoudnessJob{
+		backgroundJob: newBackgroundJob("running", len(targets)),
+		WriteTags:     writeTags,
+		ids:           targets,
+	}
+	if err := localMusicLoudnessJobs.install(job); err != nil {
+		return nil, err
+	}
+	go job.run()
+	return job, nil
+}
+
+func (job *localMusicLoudnessJob) snapshot() *localMusicLoudnessJob {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	return &localMusicLoudnessJob{
+		backgroundJob: job.snapshotLocked(),
+		WriteTags:     job.WriteTags,
+		Analyzed:      job.Analyzed,
+		Tagged:        job.Tagged,
+	}
+}
+
+func (job *localMusicLoudnessJob) run() {
+	job.process(localMusicLoudnessWorkers, func(index int) func() {
+		tagged, err := analyzeLocalMusicLoudness(job.ids[index], job.WriteTags)
+		return func() {
+			if err != nil {
+				job.failLocked(err)
+				return
+			}
+			job.Analyzed++
+			if tagged {
+				job.Tagged++
+			}
+		}
+	})
+	job.mu.Lock()
+	tagged := job.Tagged > 0
+	job.mu.Unlock()
+	if tagged {
+		invalidateLocalMusicScanCache()
+	}
+	job.finish(nil)
+}
+
+// analyzeLocalMusicLoudness measures one file and stores the result; tagged
+// reports whether REPLAYGAIN_* tags were written to it.
+func analyzeLocalMusicLoudness(id string, writeTags bool) (tagged bool, err error) {
+	track, err := localMusicTrackByID(id)
+	if err != nil {
+		return false, fmt.Errorf("%s: 本地音乐不存在", id)
+	}
+	loudness, err := localMusicLoudnessAnalyze(track.absPath)
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", track.RelPath, err)
+	}
+	// 只读曲库和 mp3/flac 以外的格式只记进索引，不算失败。
+	if writeTags && !track.ReadOnly && containsString(core.ReplayGainWritableExts, strings.ToLower(track.Ext)) {
+		if err := writeLocalMusicReplayGainTags(track, loudness); err != nil {
+			return false, fmt.Errorf("%s: 写入 ReplayGain 标签失败: %w", track.RelPath, err)
+		}
+		tagged = true
+	}
+	// 写标签会改变大小和修改时间，要在写完之后再取。
+	info, err := os.Stat(track.absPath)
+	if err != nil {
+		return false, err
+	}
+	localMusicIndexWriteMu.Lock()
+	defer localMusicIndexWriteMu.Unlock()
+	return tagged, db.Model(&LocalMusicIndex{}).Where("id = ?", track.ID).Updates(map[string]interface{}{
+		"loudness":       loudness.Integrated,
+		"loudness_range": loudness.Range,
+		"loudness_peak":  loudness.TruePeak,
+		"loudness_key":   localMusicFingerprintKey(info.Size(), info.ModTime()),
+	}).Error
+}
+
+func writeLocalMusicReplayGainTags(track *localMusicTrack, loudness core.Loudness) error {
+	// 和标签编辑共用一把锁，避免两边同时整文件重写。
+	localMusicTagWriteMu.Lock()
+	defer localMusicTagWriteMu.Unlock()
+	data, err := os.ReadFile(track.absPath)
+	if err != nil {
+		return err
+	}
+	written, err := core.WriteReplayGainTags(data, track.Ext, loudness)
+	if err != nil {
+		return err
+	}
+	if err := writeLocalMusicFileAtomic(track.absPath, written); err != nil {
+		return err
+	}
+	localMusicIndexWriteMu.Lock()
+	defer localMusicIndexWriteMu.Unlock()
+	_, err = reindexEditedLocalMusicTrack(track)
+	return err
+}
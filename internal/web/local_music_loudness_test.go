@@ -0,0 +1,139 @@
+package web
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/guohuiyuan/go-music-dl/core"
+)
+
+func withLocalMusicLoudnessTestFiles(t *testing.T, results map[string]core.Loudness) {
+	t.Helper()
+	origAnalyze, origReady := localMusicLoudnessAnalyze, localMusicLoudnessReady
+	localMusicLoudnessReady = func() error { return nil }
+	localMusicLoudnessAnalyze = func(path string) (core.Loudness, error) {
+		result, ok := results[filepath.Base(path)]
+		if !ok {
+			return core.Loudness{}, core.ErrLoudnessSilent
+		}
+		return result, nil
+	}
+	t.Cleanup(func() {
+		localMusicLoudnessAnalyze, localMusicLoudnessReady = origAnalyze, origReady
+		localMusicLoudnessJobs.reset()
+	})
+}
+
+func waitLocalMusicLoudnessJobForTest(t *testing.T) *localMusicLoudnessJob {
+	t.Helper()
+	job := localMusicLoudnessJobs.get()
+	waitJobDoneForTest(t, "loudness", job.done)
+	return job.snapshot()
+}
+
+func TestLocalMusicLoudnessJobAndReplayGain(t *testing.T) {
+	initCollectionDBForTest(t)
+	downloadDir := t.TempDir()
+	withLocalMusicDownloadDir(t, downloadDir)
+	withLocalMusicLoudnessTestFiles(t, map[string]core.Loudness{
+		"loud.mp3":  {Integrated: -9.4, Range: 5.3, TruePeak: 0.8},
+		"quiet.m4a": {Integrated: -26, Range: 8, TruePeak: -12},
+	})
+
+	writeTaggedMP3ForTest(t, filepath.Join(downloadDir, "loud.mp3"), core.AudioTags{Title: "Loud", Artist: "A"})
+	writeLocalMusicFileForTest(t, filepath.Join(downloadDir, "quiet.m4a"), string(make([]byte, 2048)))
+	writeLocalMusicFileForTest(t, filepath.Join(downloadDir, "silent.mp3"), string(make([]byte, 2048)))
+	for _, name := range []string{"loud.mp3", "quiet.m4a", "silent.mp3"} {
+		track, err := localMusicTrackByID(encodeLocalMusicID(name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		upsertLocalMusicIndexRow(track)
+	}
+	loud, quiet := encodeLocalMusicID("loud.mp3"), encodeLocalMusicID("quiet.m4a")
+
+	if code := postLocalMusicTagsJSON(t, "/local_music/loudness", gin.H{"write_tags": true}, nil); code != http.StatusOK {
+		t.Fatalf("start loudness job status = %d", code)
+	}
+	job := waitLocalMusicLoudnessJobForTest(t)
+	// m4a 只记进索引；静音文件测不出响度算失败。
+	if job.Analyzed != 2 || job.Tagged != 1 || job.Failed != 1 || job.Status != "done" {
+		t.Fatalf("job = %+v", job)
+	}
+	if _, analyzed := countLocalMusicLoudness(); analyzed != 2 {
+		t.Fatalf("analyzed = %d, want 2", analyzed)
+	}
+
+	data, err := os.ReadFile(filepath.Join(downloadDir, "loud.mp3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var gainTag []byte
+	for _, r := range "REPLAYGAIN_TRACK_GAIN" {
+		gainTag = append(gainTag, byte(r), 0)
+	}
+	if !bytes.Contains(data, gainTag) {
+		t.Fatal("mp3 should carry REPLAYGAIN_TRACK_GAIN")
+	}
+	if tags, err := core.ReadAudioTags(data); err != nil || tags.Title != "Loud" {
+		t.Fatalf("tags after ReplayGain = %+v, %v", tags, err)
+	}
+
+	var row LocalMusicIndex
+	if err := db.Where("id = ?", loud).First(&row).Error; err != nil {
+		t.Fatal(err)
+	}
+	// 写标签后索引已按新文件刷新，测量结果仍然有效。
+	if extra := localMusicIndexExtra(&row); extra[core.ExtraReplayGainTrackGain] != "-8.60" {
+		t.Fatalf("extra = %v", extra)
+	}
+
+	var body struct {
+		Tracks map[string]localMusicReplayGain `json:"tracks"`
+	}
+	fetch := func() {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		path := RoutePrefix + "/local_music/replaygain?ids=" + loud + "&ids=" + quiet + "&ids=missing"
+		newLocalMusicTestRouter().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		body.Tracks = nil
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			t.Fatalf("decode replaygain: %v, body=%s", err, rec.Body.String())
+		}
+	}
+	fetch()
+	if len(body.Tracks) != 2 {
+		t.Fatalf("replaygain = %+v", body.Tracks)
+	}
+	// 峰值超过满幅时增益被压到不削波。
+	if got := body.Tracks[loud]; got.Gain != -8.6 || got.Adjust != -8.6 {
+		t.Fatalf("loud = %+v", got)
+	}
+	if got := body.Tracks[quiet]; got.Gain != 8 || got.Adjust != 8 {
+		t.Fatalf("quiet = %+v", got)
+	}
+
+	// 文件变了之后旧的测量作废，批量任务会重新分析它。
+	writeLocalMusicFileForTest(t, filepath.Join(downloadDir, "quiet.m4a"), string(make([]byte, 4096)))
+	track, err := localMusicTrackByID(quiet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	upsertLocalMusicIndexRow(track)
+	fetch()
+	if _, ok := body.Tracks[quiet]; ok || len(body.Tracks) != 1 {
+		t.Fatalf("stale replaygain = %+v", body.Tracks)
+	}
+	if code := postLocalMusicTagsJSON(t, "/local_music/loudness", gin.H{}, nil); code != http.StatusOK {
+		t.Fatalf("rerun status = %d", code)
+	}
+	if job := waitLocalMusicLoudnessJobForTest(t); job.Total != 2 || job.Analyzed != 1 || job.WriteTags {
+		t.Fatalf("rerun job = %+v", job)
+	}
+}
@@ -0,0 +1,453 @@
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/guohuiyuan/go-music-dl/core"
+	"github.com/guohuiyuan/music-lib/model"
+)
+
+// 整理曲库：按下载文件名模板（core.BuildDownloadFilename）和文件标签算出
+// 每首歌应在的位置，先给出 dry-run 预览，确认后连同同名的 .lrc / 封面一起
+// 移动。预览和整理都是后台任务，逐首进行，可以看进度、随时取消。
+// 本地音乐的 ID 是 LocalMusicIdentity 里的稳定身份：首次见到文件时按路径
+// 生成（下载目录是相对路径的 base64，其他根目录是 "/<key>/<rel>" 的
+// base64），移动后沿用原 ID，只需同步索引行和收藏歌单里缓存的路径。
+
+var (
+	localMusicOrganizeTemplateProvider = func() string {
+		return core.GetWebSettings().DownloadFilenameTemplate
+	}
+
+	localMusicOrganizeJobs = newBackgroundJobSlot[*localMusicOrganizeJob]("已有整理曲库任务在进行中")
+)
+
+type localMusicOrganizeRequest struct {
+	IDs []string `json:"ids"`
+	// Template 为空时用设置里的下载文件名模板。
+	Template string `json:"template"`
+}
+
+type localMusicOrganizeMove struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+type localMusicOrganizeItem struct {
+	ID       string                   `json:"id"`
+	NewID    string                   `json:"new_id,omitempty"`
+	Root     string                   `json:"root,omitempty"`
+	From     string                   `json:"from"`
+	To       string                   `json:"to,omitempty"`
+	Sidecars []localMusicOrganizeMove `json:"sidecars,omitempty"`
+	// Status: move（待移动）、unchanged、skipped、moved、failed。
+	Status string `json:"status"`
+	Reason string `json:"reason,omitempty"`
+
+	track    *localMusicTrack
+	root     localLibraryRoot
+	target   string
+	sidecars [][2]string
+}
+
+// localMusicOrganizeJob previews or applies one organise run. Items only
+// lists tracks that move or cannot be organised; targets are unique both on
+// disk and within the run.
+type localMusicOrganizeJob struct {
+	backgroundJob
+	// Apply 为 false 时只预览，不动文件。
+	Apply     bool                      `json:"apply"`
+	Template  string                    `json:"template"`
+	Items     []*localMusicOrganizeItem `json:"items"`
+	Moves     int                       `json:"moves"`
+	Moved     int                       `json:"moved"`
+	Unchanged int                       `json:"unchanged"`
+	Skipped   int                       `json:"skipped"`
+
+	ids     []string
+	claimed map[string]bool
+}
+
+func registerLocalMusicOrganizeRoutes(api *gin.RouterGroup) {
+	start := func(apply bool) gin.HandlerFunc {
+		return func(c *gin.Context) {
+			var req localMusicOrganizeRequest
+			if c.Request.ContentLength > 0 {
+				if err := c.ShouldBindJSON(&req); err != nil {
+					c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+					return
+				}
+			}
+			job, err := startLocalMusicOrganize(req, apply)
+			if err != nil {
+				localMusicOrganizeJobs.startError(c, err)
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{"status": "started", "job": job.snapshot()})
+		}
+	}
+	api.POST("/local_music/organize/preview", requireSameOriginWrite, start(false))
+	api.POST("/local_music/organize", requireSameOriginWrite, start(true))
+
+	api.GET("/local_music/organize", func(c *gin.Context) {
+		job := localMusicOrganizeJobs.get()
+		if job == nil {
+			c.JSON(http.StatusOK, gin.H{"job": nil})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"job": job.snapshot()})
+	})
+
+	api.POST("/local_music/organize/cancel", requireSameOriginWrite, func(c *gin.Context) {
+		job := localMusicOrganizeJobs.get()
+		if job == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "没有整理曲库任务"})
+			return
+		}
+		job.cancel()
+		c.JSON(http.StatusOK, gin.H{"status": "ok"})
+	})
+}
+
+// startLocalMusicOrganize previews or organises ids, or the whole library
+// when ids is empty.
+func startLocalMusicOrganize(req localMusicOrganizeRequest, apply bool) (*localMusicOrganizeJob, error) {
+	template := strings.TrimSpace(req.Template)
+	if template == "" {
+		template = strings.TrimSpace(localMusicOrganizeTemplateProvider())
+	}
+	if template == "" {
+		template = core.DefaultDownloadFilenameTemplate
+	}
+
+	ids := make([]string, 0, len(req.IDs))
+	for _, id := range req.IDs {
+		if id = strings.TrimSpace(id); id != "" && !containsString(ids, id) {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		if db == nil {
+			return nil, errors.New("曲库索引不可用")
+		}
+		var rows []LocalMusicIndex
+		if err := db.Select("id").Order("root, rel_path").Find(&rows).Error; err != nil {
+			return nil, err
+		}
+		for _, row := range rows {
+			ids = append(ids, row.ID)
+		}
+	}
+
+	job := &localMusicOrganizeJob{
+		backgroundJob: newBackgroundJob("running", len(ids)),
+		Apply:         apply,
+		Template:      template,
+		Items:         make([]*localMusicOrganizeItem, 0),
+		ids:           ids,
+		claimed:       make(map[string]bool),
+	}
+	if err := localMusicOrganizeJobs.install(job); err != nil {
+		return nil, err
+	}
+	go job.run()
+	return job, nil
+}
+
+func (job *localMusicOrganizeJob) snapshot() *localMusicOrganizeJob {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	return &localMusicOrganizeJob{
+		backgroundJob: job.snapshotLocked(),
+		Apply:         job.Apply,
+		Template:      job.Template,
+		Items:         append([]*localMusicOrganizeItem(nil), job.Items...),
+		Moves:         job.Moves,
+		Moved:         job.Moved,
+		Unchanged:     job.Unchanged,
+		Skipped:       job.Skipped,
+	}
+}
+
+// run plans each track and, when applying, moves it right away. The tag
+// write lock is taken per track so tag edits are not blocked for the whole
+// library.
+func (job *localMusicOrganizeJob) run() {
+	job.process(1, func(index int) func() {
+		localMusicTagWriteMu.Lock()
+		item := planLocalMusicOrganizeItem(job.ids[index], job.Template, job.claimed)
+		var err error
+		if job.Apply && item.Status == "move" {
+			if err = moveOrganizedLocalMusic(item); err != nil {
+				item.Status, item.Reason = "failed", err.Error()
+			} else {
+				item.Status = "moved"
+			}
+		}
+		localMusicTagWriteMu.Unlock()
+
+		return func() {
+			switch item.Status {
+			case "unchanged":
+				job.Unchanged++
+				return
+			case "move":
+				job.Moves++
+			case "moved":
+				job.Moves++
+				job.Moved++
+			case "failed":
+				job.Moves++
+				job.failLocked(err)
+			default:
+				job.Skipped++
+			}
+			job.Items = append(job.Items, item)
+		}
+	})
+
+	job.mu.Lock()
+	moved := job.Moved > 0
+	job.mu.Unlock()
+	if moved {
+		invalidateLocalMusicScanCache()
+	}
+	job.finish(nil)
+}
+
+func planLocalMusicOrganizeItem(id string, template string, claimed map[string]bool) *localMusicOrganizeItem {
+	item := &localMusicOrganizeItem{ID: id, Status: "skipped"}
+	track, err := localMusicTrackByID(id)
+	if err != nil {
+		item.Reason = "本地音乐不存在"
+		return item
+	}
+	item.From, item.Root, item.track = track.RelPath, track.Root, track
+	if track.ReadOnly {
+		item.Reason = errLocalLibraryReadOnly.Error()
+		return item
+	}
+	// 没有标题或歌手标签时文件名往往比 “Unknown” 更有信息量，保持不动。
+	if containsString(track.Missing, "title") || containsString(track.Missing, "artist") {
+		item.Reason = "缺少标题或歌手标签"
+		return item
+	}
+	root, ok := localLibraryRootByKey(track.Root)
+	if !ok {
+		item.Reason = "曲库根目录不存在"
+		return item
+	}
+	item.root = root
+
+	song := &model.Song{Name: track.Name, Artist: track.Artist, Album: track.Album, Source: localMusicSource}
+	rel := core.BuildDownloadFilename(song, track.Ext, template)
+	target, err := root.resolve(rel)
+	if err != nil || !root.allows(filepath.ToSlash(rel)) {
+		item.Reason = "目标路径不在曲库范围内"
+		return item
+	}
+	if target == track.absPath {
+		item.Status = "unchanged"
+		return item
+	}
+	taken := func(candidate string) bool {
+		if claimed[candidate] {
+			return true
+		}
+		// 只改大小写时目标就是文件自己。
+		if strings.EqualFold(candidate, track.absPath) {
+			return false
+		}
+		_, err := os.Stat(candidate)
+		return err == nil
+	}
+	target = uniqueLocalMusicPathFunc(filepath.Dir(target), filepath.Base(target), taken)
+	if target == track.absPath {
+		item.Status = "unchanged"
+		return item
+	}
+	claimed[target] = true
+
+	item.Status, item.target = "move", target
+	item.To = localMusicOrganizeRel(root, target)
+	targetBase := strings.TrimSuffix(target, filepath.Ext(target))
+	for _, exts := range [][]string{localMusicLyricExts, localMusicCoverExts} {
+		sidecar, ext, ok := localMusicSidecarFile(track.absPath, exts)
+		if !ok {
+			continue
+		}
+		dest := targetBase + ext
+		item.sidecars = append(item.sidecars, [2]string{sidecar, dest})
+		item.Sidecars = append(item.Sidecars, localMusicOrganizeMove{
+			From: localMusicOrganizeRel(root, sidecar),
+			To:   localMusicOrganizeRel(root, dest),
+		})
+	}
+	return item
+}
+
+func localMusicOrganizeRel(root localLibraryRoot, absPath string) string {
+	rel, err := filepath.Rel(root.Abs, absPath)
+	if err != nil {
+		return filepath.ToSlash(absPath)
+	}
+	return filepath.ToSlash(rel)
+}
+
+func moveOrganizedLocalMusic(item *localMusicOrganizeItem) error {
+	track, root := item.track, item.root
+	if err := os.MkdirAll(filepath.Dir(item.target), 0755); err != nil {
+		return err
+	}
+	// 预览之后目标可能被别的文件占了。
+	if _, err := os.Stat(item.target); err == nil && !strings.EqualFold(item.target, track.absPath) {
+		item.target = uniqueLocalMusicPath(filepath.Dir(item.target), filepath.Base(item.target))
+		item.To = localMusicOrganizeRel(root, item.target)
+	}
+	if err := os.Rename(track.absPath, item.target); err != nil {
+		return err
+	}
+	targetBase := strings.TrimSuffix(item.target, filepath.Ext(item.target))
+	for i, sidecar := range item.sidecars {
+		dest := targetBase + filepath.Ext(sidecar[1])
+		if _, err := os.Stat(dest); err == nil {
+			continue
+		}
+		if err := os.Rename(sidecar[0], dest); err != nil {
+			core.Logger().Warn("move local music sidecar failed", "path", sidecar[0], "error", err)
+			continue
+		}
+		item.Sidecars[i].To = localMusicOrganizeRel(root, dest)
+	}
+	removeEmptyLocalMusicDirs(root.Abs, filepath.Dir(track.absPath))
+	forgetLocalMusicTrack(root.Abs, track.RelPath)
+	moveLocalMusicIdentity(track.ID, root.Key, item.To)
+
+	next, err := buildLocalMusicTrack(root, item.target)
+	if err != nil {
+		deleteLocalMusicIndexRow(track.ID)
+		return nil
+	}
+	item.NewID = next.ID
+	relinkLocalMusicID(track.ID, next)
+	return nil
+}
+
+// removeEmptyLocalMusicDirs removes dir and its parents while they are
+// empty, stopping at the library root.
+func removeEmptyLocalMusicDirs(rootAbs string, dir string) {
+	for dir != rootAbs && isPathInside(rootAbs, dir) {
+		entries, err := os.ReadDir(dir)
+		if err != nil || len(entries) > 0 {
+			return
+		}
+		if err := os.Remove(dir); err != nil {
+			return
+		}
+		dir = filepath.Dir(dir)
+	}
+}
+
+// relinkLocalMusicID points everything keyed by a local track ID at the
+// track's current file. When the ID is unchanged only the index row and the
+// paths cached in saved songs are refreshed; an old path-based ID also moves
+// the index row (keeping added_at and the fingerprint), play counts and saved
+// songs in collections to the new ID.
+func relinkLocalMusicID(oldID string, next *localMusicTrack) {
+	if db == nil {
+		return
+	}
+	if oldID == next.ID {
+		upsertLocalMusicIndexRow(next)
+	} else {
+		relinkLocalMusicRows(oldID, next)
+	}
+
+	var saved []SavedSong
+	if err := db.Where("source IN ? AND song_id = ?", []string{localMusicSource, legacyLocalMusicSource}, oldID).Find(&saved).Error; err != nil {
+		core.Logger().Warn("load saved local songs failed", "id", oldID, "error", err)
+		return
+	}
+	oldCover := url.QueryEscape(oldID)
+	for _, song := range saved {
+		updates := map[string]interface{}{}
+		if oldID != next.ID {
+			var dup int64
+			db.Model(&SavedSong{}).Where("collection_id = ? AND source = ? AND song_id = ?", song.CollectionID, song.Source, next.ID).Count(&dup)
+			if dup > 0 {
+				db.Delete(&SavedSong{}, song.ID)
+				continue
+			}
+			updates["song_id"] = next.ID
+			if strings.Contains(song.Cover, oldCover) {
+				updates["cover"] = strings.ReplaceAll(song.Cover, oldCover, url.QueryEscape(next.ID))
+			}
+		}
+		if extra := relinkLocalMusicExtra(song.Extra, next); extra != "" && extra != song.Extra {
+			updates["extra"] = extra
+		}
+		if len(updates) == 0 {
+			continue
+		}
+		if err := db.Model(&SavedSong{}).Where("id = ?", song.ID).Updates(updates).Error; err != nil {
+			core.Logger().Warn("relink saved local song failed", "id", song.ID, "error", err)
+		}
+	}
+}
+
+func relinkLocalMusicRows(oldID string, next *localMusicTrack) {
+	var old LocalMusicIndex
+	hadRow := db.First(&old, "id = ?", oldID).Error == nil
+	deleteLocalMusicIndexRow(oldID)
+	upsertLocalMusicIndexRow(next)
+	if hadRow {
+		db.Model(&LocalMusicIndex{}).Where("id = ?", next.ID).Updates(map[string]interface{}{
+			"added_at":        old.AddedAt,
+			"fingerprint":     old.Fingerprint,
+			"fingerprint_key": old.FingerprintKey,
+		})
+	}
+
+	var play LocalMusicPlay
+	if db.First(&play, "track_id = ?", oldID).Error == nil {
+		db.Delete(&LocalMusicPlay{}, "track_id = ?", oldID)
+		var existing LocalMusicPlay
+		if db.First(&existing, "track_id = ?", next.ID).Error == nil {
+			existing.PlayCount += play.PlayCount
+			if play.LastPlayedAt.After(existing.LastPlayedAt) {
+				existing.LastPlayedAt = play.LastPlayedAt
+			}
+			db.Save(&existing)
+		} else {
+			play.TrackID = next.ID
+			db.Create(&play)
+		}
+	}
+}
+
+func relinkLocalMusicExtra(raw string, next *localMusicTrack) string {
+	if strings.TrimSpace(raw) == "" {
+		return ""
+	}
+	var extra map[string]interface{}
+	if err := json.Unmarshal([]byte(raw), &extra); err != nil || extra == nil {
+		return ""
+	}
+	for key, value := range map[string]string{"file_id": next.ID, "rel_path": next.RelPath, "filename": next.Filename} {
+		if _, ok := extra[key]; ok {
+			extra[key] = value
+		}
+	}
+	b, err := json.Marshal(extra)
+	if err != nil {
+		return ""
+	}
+	return string(b)
+}
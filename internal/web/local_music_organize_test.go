@@ -0,0 +1,165 @@
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/guohuiyuan/go-music-dl/core"
+)
+
+func withLocalMusicOrganizeTemplate(t *testing.T, template string) {
+	t.Helper()
+	original := localMusicOrganizeTemplateProvider
+	localMusicOrganizeTemplateProvider = func() string { return template }
+	t.Cleanup(func() {
+		localMusicOrganizeTemplateProvider = original
+		localMusicOrganizeJobs.reset()
+	})
+}
+
+// runLocalMusicOrganizeForTest starts a preview or organise job and waits
+// for its final snapshot.
+func runLocalMusicOrganizeForTest(t *testing.T, target string, payload interface{}) *localMusicOrganizeJob {
+	t.Helper()
+	if code := postLocalMusicTagsJSON(t, target, payload, nil); code != http.StatusOK {
+		t.Fatalf("%s status = %d", target, code)
+	}
+	job := localMusicOrganizeJobs.get()
+	waitJobDoneForTest(t, "organize", job.done)
+	return job.snapshot()
+}
+
+func TestLocalMusicOrganizePreviewAndApply(t *testing.T) {
+	initCollectionDBForTest(t)
+	downloadDir := t.TempDir()
+	withLocalMusicDownloadDir(t, downloadDir)
+	withLocalMusicOrganizeTemplate(t, "{artist}/{album}/{name}")
+
+	sunny := core.AudioTags{Title: "晴天", Artist: "周杰伦", Album: "叶惠美"}
+	writeTaggedMP3ForTest(t, filepath.Join(downloadDir, "old name.mp3"), sunny)
+	writeLocalMusicFileForTest(t, filepath.Join(downloadDir, "old name.lrc"), "[00:01.00]故事的小黄花")
+	writeLocalMusicFileForTest(t, filepath.Join(downloadDir, "old name.jpg"), "jpg")
+	writeTaggedMP3ForTest(t, filepath.Join(downloadDir, "z-copy", "copy.mp3"), sunny)
+	writeTaggedMP3ForTest(t, filepath.Join(downloadDir, "周杰伦", "魔杰座", "稻香.mp3"), core.AudioTags{Title: "稻香", Artist: "周杰伦", Album: "魔杰座"})
+	writeTaggedMP3ForTest(t, filepath.Join(downloadDir, "untagged.mp3"), core.AudioTags{})
+	for _, rel := range []string{"old name.mp3", "z-copy/copy.mp3", "周杰伦/魔杰座/稻香.mp3", "untagged.mp3"} {
+		track, err := localMusicTrackByID(encodeLocalMusicID(rel))
+		if err != nil {
+			t.Fatal(err)
+		}
+		upsertLocalMusicIndexRow(track)
+	}
+	oldID := encodeLocalMusicID("old name.mp3")
+	db.Model(&LocalMusicIndex{}).Where("id = ?", oldID).Updates(map[string]interface{}{"fingerprint": "AAAAAA==", "fingerprint_key": "k"})
+	db.Create(&LocalMusicPlay{TrackID: oldID, PlayCount: 3, LastPlayedAt: time.Now()})
+	collection := Collection{Name: "Fav", Kind: collectionKindManual, ContentType: collectionContentPlaylist, Source: "local"}
+	db.Create(&collection)
+	db.Create(&SavedSong{
+		CollectionID: collection.ID,
+		SongID:       oldID,
+		Source:       localMusicSource,
+		Name:         "晴天",
+		Cover:        RoutePrefix + "/local_music/cover?id=" + oldID,
+		Extra:        `{"file_id":"` + oldID + `","rel_path":"old name.mp3","local_music":"true"}`,
+	})
+
+	plan := runLocalMusicOrganizeForTest(t, "/local_music/organize/preview", gin.H{})
+	if plan.Status != "done" || plan.Apply || plan.Processed != 4 || plan.Moves != 2 || plan.Unchanged != 1 || plan.Skipped != 1 || plan.Template != "{artist}/{album}/{name}" {
+		t.Fatalf("plan = %+v", plan)
+	}
+	items := make(map[string]*localMusicOrganizeItem)
+	for _, item := range plan.Items {
+		items[item.From] = item
+	}
+	first, second := items["old name.mp3"], items["z-copy/copy.mp3"]
+	if first.To != "周杰伦/叶惠美/晴天.mp3" || len(first.Sidecars) != 2 || first.Sidecars[0].To != "周杰伦/叶惠美/晴天.lrc" {
+		t.Fatalf("first move = %+v", first)
+	}
+	if second.To != "周杰伦/叶惠美/晴天 (1).mp3" {
+		t.Fatalf("colliding move = %+v, want a numbered name", second)
+	}
+	if untagged := items["untagged.mp3"]; untagged == nil || untagged.Status != "skipped" {
+		t.Fatalf("untagged item = %+v", untagged)
+	}
+	if _, err := os.Stat(filepath.Join(downloadDir, "old name.mp3")); err != nil {
+		t.Fatal("preview must not move files")
+	}
+
+	applied := runLocalMusicOrganizeForTest(t, "/local_music/organize", gin.H{})
+	if applied.Status != "done" || !applied.Apply || applied.Moved != 2 || applied.Failed != 0 {
+		t.Fatalf("organize = %+v", applied)
+	}
+	for _, rel := range []string{"周杰伦/叶惠美/晴天.mp3", "周杰伦/叶惠美/晴天.lrc", "周杰伦/叶惠美/晴天.jpg", "周杰伦/叶惠美/晴天 (1).mp3"} {
+		if _, err := os.Stat(filepath.Join(downloadDir, filepath.FromSlash(rel))); err != nil {
+			t.Fatalf("%s missing after organize: %v", rel, err)
+		}
+	}
+	for _, rel := range []string{"old name.mp3", "old name.lrc", "z-copy"} {
+		if _, err := os.Stat(filepath.Join(downloadDir, rel)); !os.IsNotExist(err) {
+			t.Fatalf("%s should be gone, stat err = %v", rel, err)
+		}
+	}
+
+	// The moved file keeps its ID, so collections and play counts still point at it.
+	var moved *localMusicOrganizeItem
+	for _, item := range applied.Items {
+		if item.From == "old name.mp3" {
+			moved = item
+		}
+	}
+	if moved == nil || moved.NewID != oldID {
+		t.Fatalf("moved item = %+v, want id %s", moved, oldID)
+	}
+	var row LocalMusicIndex
+	if err := db.First(&row, "id = ?", oldID).Error; err != nil || row.RelPath != "周杰伦/叶惠美/晴天.mp3" || row.Fingerprint != "AAAAAA==" || !row.HasLyric {
+		t.Fatalf("index row = %+v, %v", row, err)
+	}
+	var count int64
+	db.Model(&LocalMusicIndex{}).Where("rel_path = ?", "old name.mp3").Count(&count)
+	if count != 0 {
+		t.Fatal("old path should leave the index")
+	}
+	var play LocalMusicPlay
+	if err := db.First(&play, "track_id = ?", oldID).Error; err != nil || play.PlayCount != 3 {
+		t.Fatalf("play = %+v, %v", play, err)
+	}
+	var saved SavedSong
+	if err := db.First(&saved, "collection_id = ?", collection.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	var extra map[string]string
+	_ = json.Unmarshal([]byte(saved.Extra), &extra)
+	if saved.SongID != oldID || extra["file_id"] != oldID || extra["rel_path"] != "周杰伦/叶惠美/晴天.mp3" || !strings.HasSuffix(saved.Cover, "id="+oldID) {
+		t.Fatalf("saved song = %+v", saved)
+	}
+	if track, err := localMusicTrackByID(oldID); err != nil || track.RelPath != "周杰伦/叶惠美/晴天.mp3" {
+		t.Fatalf("track by old id = %+v, %v", track, err)
+	}
+
+	// Running it again finds nothing left to move.
+	if plan = runLocalMusicOrganizeForTest(t, "/local_music/organize/preview", gin.H{}); plan.Moves != 0 {
+		t.Fatalf("second preview = %+v", plan)
+	}
+}
+
+func TestLocalMusicOrganizeSkipsReadOnlyRoots(t *testing.T) {
+	initCollectionDBForTest(t)
+	downloadDir := t.TempDir()
+	withLocalMusicDownloadDir(t, downloadDir)
+	archiveDir := t.TempDir()
+	withLocalLibraryRoots(t, core.LibraryRoot{Key: "archive", Label: "Archive", Path: archiveDir, ReadOnly: true})
+	withLocalMusicOrganizeTemplate(t, "")
+
+	writeTaggedMP3ForTest(t, filepath.Join(archiveDir, "keep.mp3"), core.AudioTags{Title: "Keep", Artist: "Me"})
+	id := encodeLocalLibraryID("archive", "keep.mp3")
+	plan := runLocalMusicOrganizeForTest(t, "/local_music/organize/preview", gin.H{"ids": []string{id}, "template": "{name}"})
+	if plan.Skipped != 1 || plan.Items[0].Reason != errLocalLibraryReadOnly.Error() {
+		t.Fatalf("plan = %+v", plan.Items)
+	}
+}
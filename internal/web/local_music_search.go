@@ -0,0 +1,302 @@
+package web
+
+import (
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"unicode"
+
+	"github.com/guohuiyuan/go-music-dl/core"
+	"golang.org/x/text/width"
+	"gorm.io/gorm"
+)
+
+// 本地音乐全文搜索：local_music_fts 是挂在 local_music_index 上的 FTS5
+// 外部内容表，由触发器随索引行的增删改自动维护。归一化（全半角、繁简、
+// 拼音 / 首字母）在 Go 里写入 search_* 列时完成，SQLite 只负责分词与排序。
+// FTS5 不可用时退回原来的 LIKE 查询。
+
+const localMusicFTSTable = "local_music_fts"
+
+// localMusicFTSColumns 的顺序与 bm25 权重一一对应：标题 > 歌手 > 专辑 >
+// 拼音 > 文件名 > 歌词。
+var localMusicFTSColumns = []string{
+	"search_name", "search_artist", "search_album", "search_file", "search_lyrics", "search_pinyin",
+}
+
+const localMusicFTSRank = "bm25(local_music_fts, 10.0, 6.0, 4.0, 2.0, 1.0, 3.0)"
+
+var localMusicFTSReady atomic.Bool
+
+type localMusicSearchOptions struct {
+	Pinyin       bool
+	FoldVariants bool
+}
+
+var localMusicSearchOptionsProvider = func() localMusicSearchOptions {
+	settings := core.GetWebSettings()
+	return localMusicSearchOptions{
+		Pinyin:       settings.LocalMusicSearchPinyin,
+		FoldVariants: settings.LocalMusicSearchFoldVariants,
+	}
+}
+
+// migrateLocalMusicFTS creates the FTS table and its triggers. A freshly
+// created table is backfilled from the existing index rows; lyrics arrive
+// with the next scan.
+func migrateLocalMusicFTS(database *gorm.DB) error {
+	localMusicFTSReady.Store(false)
+	var existing int64
+	if err := database.Raw("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?", localMusicFTSTable).Scan(&existing).Error; err != nil {
+		return err
+	}
+	columns := strings.Join(localMusicFTSColumns, ", ")
+	if existing == 0 {
+		if err := database.Exec("CREATE VIRTUAL TABLE " + localMusicFTSTable + " USING fts5(" + columns +
+			", content='local_music_index', content_rowid='seq', tokenize='unicode61 remove_diacritics 2')").Error; err != nil {
+			return err
+		}
+		if err := backfillLocalMusicSearchColumns(database); err != nil {
+			return err
+		}
+		if err := database.Exec("INSERT INTO " + localMusicFTSTable + "(" + localMusicFTSTable + ") VALUES ('rebuild')").Error; err != nil {
+			return err
+		}
+	}
+
+	newValues := "new." + strings.Join(localMusicFTSColumns, ", new.")
+	oldValues := "old." + strings.Join(localMusicFTSColumns, ", old.")
+	insertNew := "INSERT INTO " + localMusicFTSTable + "(rowid, " + columns + ") VALUES (new.seq, " + newValues + ");"
+	deleteOld := "INSERT INTO " + localMusicFTSTable + "(" + localMusicFTSTable + ", rowid, " + columns + ") VALUES ('delete', old.seq, " + oldValues + ");"
+	for _, trigger := range []string{
+		"CREATE TRIGGER IF NOT EXISTS local_music_fts_ai AFTER INSERT ON local_music_index BEGIN " + insertNew + " END",
+		"CREATE TRIGGER IF NOT EXISTS local_music_fts_ad AFTER DELETE ON local_music_index BEGIN " + deleteOld + " END",
+		"CREATE TRIGGER IF NOT EXISTS local_music_fts_au AFTER UPDATE ON local_music_index BEGIN " + deleteOld + " " + insertNew + " END",
+	} {
+		if err := database.Exec(trigger).Error; err != nil {
+			return err
+		}
+	}
+	localMusicFTSReady.Store(true)
+	return nil
+}
+
+func backfillLocalMusicSearchColumns(database *gorm.DB) error {
+	var rows []LocalMusicIndex
+	var opts *localMusicSearchOptions
+	return database.Where("search_name = ''").FindInBatches(&rows, 200, func(tx *gorm.DB, batch int) error {
+		if opts == nil {
+			current := localMusicSearchOptionsProvider()
+			opts = &current
+		}
+		for i := range rows {
+			row := &rows[i]
+			row.setSearchColumns(*opts, "")
+			if err := tx.Model(&LocalMusicIndex{}).Where("id = ?", row.ID).Updates(map[string]interface{}{
+				"search_name":   row.SearchName,
+				"search_artist": row.SearchArtist,
+				"search_album":  row.SearchAlbum,
+				"search_file":   row.SearchFile,
+				"search_pinyin": row.SearchPinyin,
+			}).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	}).Error
+}
+
+// setSearchColumns fills the normalised copies of the searchable fields.
+func (row *LocalMusicIndex) setSearchColumns(opts localMusicSearchOptions, lyrics string) {
+	row.SearchName = localMusicSearchText(row.Name, opts.FoldVariants)
+	row.SearchArtist = localMusicSearchText(row.Artist, opts.FoldVariants)
+	row.SearchAlbum = localMusicSearchText(row.Album, opts.FoldVariants)
+	row.SearchFile = localMusicSearchText(row.RelPath, opts.FoldVariants)
+	row.SearchLyrics = localMusicSearchText(lyrics, opts.FoldVariants)
+	row.SearchPinyin = ""
+	if opts.Pinyin {
+		row.SearchPinyin = localMusicPinyinText(row.Name, row.Artist, row.Album)
+	}
+}
+
+func isLocalMusicCJK(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul)
+}
+
+// localMusicSearchText folds full-width forms (and optionally traditional
+// characters), drops punctuation and puts every CJK character in its own
+// token, so "周杰倫《晴天》" is indexed as "周 杰 伦 晴 天" and can be found by
+// any substring.
+func localMusicSearchText(s string, fold bool) string {
+	if strings.TrimSpace(s) == "" {
+		return ""
+	}
+	s = width.Fold.String(s)
+	if fold {
+		s = core.FoldChineseVariants(s)
+	}
+	var b strings.Builder
+	b.Grow(len(s) * 2)
+	space := true
+	for _, r := range s {
+		switch {
+		case isLocalMusicCJK(r):
+			if !space {
+				b.WriteByte(' ')
+			}
+			b.WriteRune(r)
+			b.WriteByte(' ')
+			space = true
+		case unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.Is(unicode.Mn, r):
+			b.WriteRune(r)
+			space = false
+		default:
+			if !space {
+				b.WriteByte(' ')
+				space = true
+			}
+		}
+	}
+	return strings.TrimSpace(b.String())
+}
+
+const (
+	localMusicPinyinVariants  = 4
+	localMusicPinyinMaxTokens = 200
+)
+
+// localMusicPinyinText spells every Han run in the given fields as full
+// pinyin and initials, each also from every later syllable, so "周杰伦"
+// yields "zhoujielun zjl jielun jl lun". Polyphonic characters add up to
+// localMusicPinyinVariants alternative spellings per run.
+func localMusicPinyinText(fields ...string) string {
+	seen := make(map[string]struct{})
+	tokens := make([]string, 0, 16)
+	add := func(token string) {
+		if _, ok := seen[token]; ok || len(tokens) >= localMusicPinyinMaxTokens {
+			return
+		}
+		seen[token] = struct{}{}
+		tokens = append(tokens, token)
+	}
+	for _, field := range fields {
+		var run [][]string
+		flush := func() {
+			for _, spelling := range localMusicPinyinSpellings(run) {
+				for i := range spelling {
+					add(strings.Join(spelling[i:], ""))
+					if len(spelling)-i > 1 {
+						var initials strings.Builder
+						for _, syllable := range spelling[i:] {
+							initials.WriteByte(syllable[0])
+						}
+						add(initials.String())
+					}
+				}
+			}
+			run = run[:0]
+		}
+		for _, r := range field {
+			if readings := core.HanPinyin(r); len(readings) > 0 {
+				run = append(run, readings)
+				continue
+			}
+			flush()
+		}
+		flush()
+	}
+	return strings.Join(tokens, " ")
+}
+
+func localMusicPinyinSpellings(run [][]string) [][]string {
+	if len(run) == 0 {
+		return nil
+	}
+	spellings := [][]string{{}}
+	for _, readings := range run {
+		next := make([][]string, 0, localMusicPinyinVariants)
+		for _, prefix := range spellings {
+			for _, reading := range readings {
+				if len(next) == localMusicPinyinVariants {
+					break
+				}
+				spelling := append(append(make([]string, 0, len(prefix)+1), prefix...), reading)
+				next = append(next, spelling)
+			}
+		}
+		spellings = next
+	}
+	return spellings
+}
+
+var localMusicLyricTagPattern = regexp.MustCompile(`\[[^\]]*\]`)
+
+const localMusicLyricSearchLimit = 4000
+
+// localMusicLyricSearchBody strips LRC time tags and caps the text so a long
+// lyric file does not dominate the index.
+func localMusicLyricSearchBody(raw string) string {
+	raw = localMusicLyricTagPattern.ReplaceAllString(raw, " ")
+	text := strings.Join(strings.Fields(raw), " ")
+	if runes := []rune(text); len(runes) > localMusicLyricSearchLimit {
+		text = string(runes[:localMusicLyricSearchLimit])
+	}
+	return text
+}
+
+// localMusicFTSQuery turns user input into an FTS5 expression: CJK runs
+// become phrases, other words become terms (prefix terms when prefix is
+// set), and all parts are ANDed so word order does not matter. columns
+// restricts the match to the given search_* columns. ok is false when the
+// input has nothing searchable.
+func localMusicFTSQuery(keyword string, opts localMusicSearchOptions, prefix bool, columns ...string) (string, bool) {
+	quote := func(s string) string { return `"` + strings.ReplaceAll(s, `"`, `""`) + `"` }
+	var parts []string
+	var phrase []string
+	flush := func() {
+		if len(phrase) > 0 {
+			parts = append(parts, quote(strings.Join(phrase, " ")))
+			phrase = phrase[:0]
+		}
+	}
+	// 用户输入的空格是词边界："晴天 周杰伦" 是两个短语，可以落在不同列上。
+	for _, word := range strings.Fields(keyword) {
+		for _, token := range strings.Fields(localMusicSearchText(word, opts.FoldVariants)) {
+			if r := []rune(token); len(r) == 1 && isLocalMusicCJK(r[0]) {
+				phrase = append(phrase, token)
+				continue
+			}
+			flush()
+			if prefix {
+				parts = append(parts, quote(token)+"*")
+			} else {
+				parts = append(parts, quote(token))
+			}
+		}
+		flush()
+	}
+	if len(parts) == 0 {
+		return "", false
+	}
+
+	if len(columns) == 0 {
+		columns = localMusicFTSColumns
+		if !opts.Pinyin {
+			columns = localMusicFTSColumns[:len(localMusicFTSColumns)-1]
+		}
+	}
+	return "{" + strings.Join(columns, " ") + "} : (" + strings.Join(parts, " AND ") + ")", true
+}
+
+// searchLocalMusicFTS runs a match and returns index rows best first.
+func searchLocalMusicFTS(database *gorm.DB, match string, limit int) ([]LocalMusicIndex, error) {
+	var rows []LocalMusicIndex
+	err := database.Table(localMusicFTSTable).
+		Select("local_music_index.*").
+		Joins("JOIN local_music_index ON local_music_index.seq = "+localMusicFTSTable+".rowid").
+		Where(localMusicFTSTable+" MATCH ?", match).
+		Order(localMusicFTSRank + ", local_music_index.mod_time DESC").
+		Limit(limit).
+		Find(&rows).Error
+	return rows, err
+}
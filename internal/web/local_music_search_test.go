@@ -0,0 +1,251 @@
+package web
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestLocalMusicSearchText(t *testing.T) {
+	cases := []struct {
+		in   string
+		fold bool
+		want string
+	}{
+		{"周杰倫《晴天》", true, "周 杰 伦 晴 天"},
+		{"周杰倫", false, "周 杰 倫"},
+		{"ＪＡＹ Chou - 七里香.mp3", true, "JAY Chou 七 里 香 mp3"},
+		{"Beyoncé", true, "Beyoncé"},
+		{"  ", true, ""},
+	}
+	for _, tc := range cases {
+		if got := localMusicSearchText(tc.in, tc.fold); got != tc.want {
+			t.Fatalf("localMusicSearchText(%q, %v) = %q, want %q", tc.in, tc.fold, got, tc.want)
+		}
+	}
+}
+
+func TestLocalMusicPinyinText(t *testing.T) {
+	tokens := strings.Fields(localMusicPinyinText("周杰倫", "长城"))
+	has := func(token string) bool {
+		for _, got := range tokens {
+			if got == token {
+				return true
+			}
+		}
+		return false
+	}
+	for _, want := range []string{"zhoujielun", "zjl", "jielun", "changcheng", "zhangcheng", "cc"} {
+		if !has(want) {
+			t.Fatalf("pinyin tokens %v missing %q", tokens, want)
+		}
+	}
+	if got := localMusicPinyinText("Jay Chou"); got != "" {
+		t.Fatalf("pinyin for latin text = %q, want empty", got)
+	}
+}
+
+func TestLocalMusicFTSQuery(t *testing.T) {
+	opts := localMusicSearchOptions{Pinyin: true, FoldVariants: true}
+	got, ok := localMusicFTSQuery(`晴天 周杰倫 "jay`, opts, true)
+	want := `{search_name search_artist search_album search_file search_lyrics search_pinyin} : ("晴 天" AND "周 杰 伦" AND "jay"*)`
+	if !ok || got != want {
+		t.Fatalf("query = %q, want %q", got, want)
+	}
+	got, _ = localMusicFTSQuery("zjl", localMusicSearchOptions{}, false)
+	if strings.Contains(got, "search_pinyin") || !strings.HasSuffix(got, `: ("zjl")`) {
+		t.Fatalf("query without pinyin = %q", got)
+	}
+	if _, ok := localMusicFTSQuery(" -- ", opts, true); ok {
+		t.Fatal("punctuation-only query should not be searchable")
+	}
+}
+
+func seedLocalMusicSearchLibrary(t *testing.T) string {
+	t.Helper()
+	initCollectionDBForTest(t)
+	if !localMusicFTSReady.Load() {
+		t.Fatal("FTS5 index was not created")
+	}
+	downloadDir := t.TempDir()
+	withLocalMusicDownloadDir(t, downloadDir)
+	for _, track := range []*localMusicTrack{
+		{RelPath: "jay/qingtian.mp3", Name: "晴天", Artist: "周杰倫", Album: "葉惠美"},
+		{RelPath: "jay/qilixiang.flac", Name: "七里香", Artist: "周杰伦", Album: "七里香"},
+		{RelPath: "other/cover.mp3", Name: "Sunny Day", Artist: "Cover Band", lyricText: "故事的小黄花 从出生那年就飘着 晴天"},
+		{RelPath: "other/loveletter.mp3", Name: "Love Letter", Artist: "Someone"},
+	} {
+		track.ID = encodeLocalMusicID(track.RelPath)
+		writeLocalMusicFileForTest(t, filepath.Join(downloadDir, filepath.FromSlash(track.RelPath)), "audio")
+		upsertLocalMusicIndexRow(track)
+	}
+	return downloadDir
+}
+
+func localMusicSearchNames(keyword string) []string {
+	names := []string{}
+	for _, song := range localMusicSearchSongs(keyword, 20) {
+		names = append(names, song.Name)
+	}
+	return names
+}
+
+func sortedLocalMusicNames(names []string) string {
+	names = append([]string(nil), names...)
+	sort.Strings(names)
+	return strings.Join(names, "|")
+}
+
+func TestLocalMusicSearchSongsUsesFullTextIndex(t *testing.T) {
+	seedLocalMusicSearchLibrary(t)
+
+	cases := []struct {
+		keyword string
+		want    []string
+	}{
+		{"zjl", []string{"晴天", "七里香"}},
+		{"周杰倫", []string{"晴天", "七里香"}},
+		{"周杰伦 晴天", []string{"晴天"}},
+		{"晴天 周杰伦", []string{"晴天"}},
+		{"qingt", []string{"晴天"}},
+		{"叶惠美", []string{"晴天"}},
+		{"小黄花", []string{"Sunny Day"}},
+		{"lov", []string{"Love Letter"}},
+		{"letter love", []string{"Love Letter"}},
+	}
+	for _, tc := range cases {
+		got := localMusicSearchNames(tc.keyword)
+		if sortedLocalMusicNames(got) != sortedLocalMusicNames(tc.want) {
+			t.Fatalf("search %q = %v, want %v", tc.keyword, got, tc.want)
+		}
+	}
+
+	// A title hit outranks a lyric hit.
+	if got := localMusicSearchNames("晴"); len(got) != 2 || got[0] != "晴天" {
+		t.Fatalf("ranked results = %v, want title match first", got)
+	}
+}
+
+func TestLocalMusicSearchPinyinCanBeDisabled(t *testing.T) {
+	seedLocalMusicSearchLibrary(t)
+	localMusicSearchOptionsProvider = func() localMusicSearchOptions {
+		return localMusicSearchOptions{FoldVariants: true}
+	}
+	if got := localMusicSearchNames("zjl"); len(got) != 0 {
+		t.Fatalf("pinyin search disabled but got %v", got)
+	}
+	if got := localMusicSearchNames("周杰倫"); len(got) != 2 {
+		t.Fatalf("variant folding should still work, got %v", got)
+	}
+}
+
+func TestFindLocalMusicMatchFoldsChineseVariants(t *testing.T) {
+	seedLocalMusicSearchLibrary(t)
+
+	row, _, err := findLocalMusicMatch("七里香", "周杰倫")
+	if err != nil || row == nil || row.Name != "七里香" {
+		t.Fatalf("match = %+v, %v; want 七里香", row, err)
+	}
+	row, _, err = findLocalMusicMatch("七里香", "林俊杰")
+	if err != nil || row != nil {
+		t.Fatalf("other artist should not match, got %+v, %v", row, err)
+	}
+	row, _, err = findLocalMusicMatch("Love", "")
+	if err != nil || row == nil || row.Name != "Love Letter" {
+		t.Fatalf("title prefix match = %+v, %v", row, err)
+	}
+}
+
+func TestMigrateLocalMusicFTSBackfillsExistingRows(t *testing.T) {
+	downloadDir := seedLocalMusicSearchLibrary(t)
+	writeLocalMusicFileForTest(t, filepath.Join(downloadDir, "legacy.mp3"), "audio")
+
+	// Simulate an index written before the full-text table existed.
+	for _, stmt := range []string{
+		"DROP TRIGGER local_music_fts_ai",
+		"DROP TRIGGER local_music_fts_ad",
+		"DROP TRIGGER local_music_fts_au",
+		"DROP TABLE local_music_fts",
+	} {
+		if err := db.Exec(stmt).Error; err != nil {
+			t.Fatalf("%s: %v", stmt, err)
+		}
+	}
+	if err := db.Create(&LocalMusicIndex{ID: encodeLocalMusicID("legacy.mp3"), RelPath: "legacy.mp3", Name: "稻香", Artist: "周杰伦"}).Error; err != nil {
+		t.Fatalf("create legacy row: %v", err)
+	}
+
+	if err := migrateLocalMusicFTS(db); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	if got := localMusicSearchNames("daox"); len(got) != 1 || got[0] != "稻香" {
+		t.Fatalf("backfilled search = %v, want 稻香", got)
+	}
+	if got := localMusicSearchNames("zjl"); len(got) != 3 {
+		t.Fatalf("rebuilt index = %v, want all three 周杰伦 tracks", got)
+	}
+}
+
+func TestLocalMusicFTSSurvivesVacuum(t *testing.T) {
+	seedLocalMusicSearchLibrary(t)
+
+	// 删掉最早的一行再 VACUUM：隐式 rowid 可能被重排，seq 不会。
+	deleteLocalMusicIndexRow(encodeLocalMusicID("jay/qingtian.mp3"))
+	if err := db.Exec("VACUUM").Error; err != nil {
+		t.Fatalf("vacuum: %v", err)
+	}
+	if got := localMusicSearchNames("lov"); len(got) != 1 || got[0] != "Love Letter" {
+		t.Fatalf("search after vacuum = %v, want Love Letter", got)
+	}
+	if got := localMusicSearchNames("zjl"); len(got) != 1 || got[0] != "七里香" {
+		t.Fatalf("search after vacuum = %v, want 七里香", got)
+	}
+}
+
+func TestMigrateLocalMusicIndexSeqRebuildsLegacyTable(t *testing.T) {
+	seedLocalMusicSearchLibrary(t)
+
+	// Simulate an index whose primary key is the TEXT id, with the old
+	// rowid-based full-text table on top of it.
+	for _, stmt := range []string{
+		"DROP TRIGGER local_music_fts_ai",
+		"DROP TRIGGER local_music_fts_ad",
+		"DROP TRIGGER local_music_fts_au",
+		"DROP TABLE local_music_fts",
+		"ALTER TABLE local_music_index RENAME TO seeded",
+		"CREATE TABLE local_music_index (id text PRIMARY KEY, root text NOT NULL DEFAULT '', rel_path text NOT NULL, name text, artist text, album text, fingerprint text NOT NULL DEFAULT '')",
+		"INSERT INTO local_music_index SELECT id, root, rel_path, name, artist, album, 'fp-' || name FROM seeded",
+		"DROP TABLE seeded",
+		"CREATE INDEX idx_local_music_index_name ON local_music_index(name)",
+		"CREATE VIRTUAL TABLE local_music_fts USING fts5(name, content='local_music_index', content_rowid='rowid')",
+	} {
+		if err := db.Exec(stmt).Error; err != nil {
+			t.Fatalf("%s: %v", stmt, err)
+		}
+	}
+
+	if err := migrateLocalMusicIndexSeq(db); err != nil {
+		t.Fatalf("migrate seq: %v", err)
+	}
+	if err := db.AutoMigrate(&LocalMusicIndex{}); err != nil {
+		t.Fatalf("auto migrate: %v", err)
+	}
+	if err := migrateLocalMusicFTS(db); err != nil {
+		t.Fatalf("migrate fts: %v", err)
+	}
+
+	var row LocalMusicIndex
+	if err := db.Where("id = ?", encodeLocalMusicID("jay/qilixiang.flac")).First(&row).Error; err != nil {
+		t.Fatalf("load migrated row: %v", err)
+	}
+	if row.Seq == 0 || row.Name != "七里香" || row.Fingerprint != "fp-七里香" {
+		t.Fatalf("migrated row = %+v", row)
+	}
+	if got := sortedLocalMusicNames(localMusicSearchNames("zjl")); got != "七里香|晴天" {
+		t.Fatalf("search after migration = %v", got)
+	}
+	if err := migrateLocalMusicIndexSeq(db); err != nil {
+		t.Fatalf("second migrate seq: %v", err)
+	}
+}
@@ -0,0 +1,594 @@
+package web
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/guohuiyuan/go-music-dl/core"
+)
+
+// 本地音乐标签编辑：单曲或批量修改标题、歌手、专辑等标签与封面。
+// mp3 走内置的 ID3v2.3 写入，flac/m4a/wma 走 ffmpeg；写入先落到同目录
+// 临时文件再 rename，随后刷新元数据缓存与曲库索引。每次批量修改都把
+// 修改前的标签存进 local_music_tag_edits，最近一次修改可以撤销。
+
+const (
+	localMusicTagMaxTracks       = 500
+	localMusicTagMaxRequestBytes = 16 << 20
+	localMusicTagEditHistory     = 20
+)
+
+// localMusicTagFields 是可编辑的文字字段，顺序即界面上的顺序。
+var localMusicTagFields = []string{
+	"title", "artist", "album", "album_artist", "track", "disc", "year", "genre", "lyrics",
+}
+
+var (
+	localMusicTagNumberPattern = regexp.MustCompile(`^\d+(/\d+)?$`)
+	localMusicTagYearPattern   = regexp.MustCompile(`^\d{4}$`)
+
+	errLocalMusicTagEditStale = errors.New("只能撤销最近一次未撤销的标签修改")
+
+	// 标签写入会整文件重写，同一时间只允许一个编辑任务。
+	localMusicTagWriteMu sync.Mutex
+)
+
+// LocalMusicTagEdit 是一次标签修改的撤销记录，Snapshot 保存修改前的标签。
+type LocalMusicTagEdit struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	Tracks    int       `gorm:"not null;default:0" json:"tracks"`
+	Fields    string    `gorm:"not null;default:''" json:"fields"`
+	Undone    bool      `gorm:"not null;default:false" json:"undone"`
+	Snapshot  string    `gorm:"type:text" json:"-"`
+}
+
+func (LocalMusicTagEdit) TableName() string { return "local_music_tag_edits" }
+
+type localMusicTagSnapshot struct {
+	ID     string         `json:"id"`
+	Before core.AudioTags `json:"before"`
+	// RestoreCover 只在这次修改动过封面时为 true；Cover 为空表示原来没有封面。
+	RestoreCover bool   `json:"restore_cover,omitempty"`
+	Cover        string `json:"cover,omitempty"`
+	CoverMime    string `json:"cover_mime,omitempty"`
+}
+
+type localMusicTagRequest struct {
+	IDs         []string          `json:"ids"`
+	Changes     map[string]string `json:"changes"`
+	CoverData   string            `json:"cover_data"`
+	CoverMime   string            `json:"cover_mime"`
+	RemoveCover bool              `json:"remove_cover"`
+
+	cover []byte
+}
+
+type localMusicTagPreview struct {
+	ID       string         `json:"id"`
+	Name     string         `json:"name"`
+	Filename string         `json:"filename"`
+	RelPath  string         `json:"rel_path"`
+	Before   core.AudioTags `json:"before"`
+	After    core.AudioTags `json:"after"`
+	Changed  []string       `json:"changed"`
+	Error    string         `json:"error,omitempty"`
+}
+
+type localMusicTagFailure struct {
+	ID    string `json:"id"`
+	Error string `json:"error"`
+}
+
+func registerLocalMusicTagRoutes(api *gin.RouterGroup) {
+	api.GET("/local_music/tags", func(c *gin.Context) {
+		track, err := localMusicTrackByID(c.Query("id"))
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "本地音乐不存在或已不在曲库目录内"})
+			return
+		}
+		tags, err := readLocalMusicTags(track)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "读取标签失败: " + err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"id":        track.ID,
+			"filename":  track.Filename,
+			"rel_path":  track.RelPath,
+			"tags":      tags,
+			"has_cover": len(tags.Cover) > 0,
+			"cover":     localMusicCoverURL(track.ID),
+			"writable":  localMusicTagWritableError(track) == nil,
+		})
+	})
+
+	api.POST("/local_music/tags/preview", func(c *gin.Context) {
+		req, ok := bindLocalMusicTagRequest(c)
+		if !ok {
+			return
+		}
+		items := make([]localMusicTagPreview, 0, len(req.IDs))
+		for _, id := range req.IDs {
+			items = append(items, previewLocalMusicTagEdit(id, req))
+		}
+		c.JSON(http.StatusOK, gin.H{"items": items})
+	})
+
+	api.POST("/local_music/tags", requireSameOriginWrite, func(c *gin.Context) {
+		req, ok := bindLocalMusicTagRequest(c)
+		if !ok {
+			return
+		}
+		edit, updated, failed, err := applyLocalMusicTagEdit(req)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		body := gin.H{"status": "ok", "tracks": updated, "failed": failed}
+		if edit != nil {
+			body["edit_id"] = edit.ID
+		}
+		c.JSON(http.StatusOK, body)
+	})
+
+	api.POST("/local_music/tags/undo", requireSameOriginWrite, func(c *gin.Context) {
+		var req struct {
+			EditID uint `json:"edit_id"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil || req.EditID == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "缺少 edit_id"})
+			return
+		}
+		restored, failed, err := undoLocalMusicTagEdit(req.EditID)
+		if errors.Is(err, errLocalMusicTagEditStale) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "ok", "tracks": restored, "failed": failed})
+	})
+
+	api.GET("/local_music/tags/history", func(c *gin.Context) {
+		edits := []LocalMusicTagEdit{}
+		if db != nil {
+			if err := db.Order("id DESC").Limit(localMusicTagEditHistory).Find(&edits).Error; err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+		}
+		undoable := uint(0)
+		for _, edit := range edits {
+			if !edit.Undone {
+				undoable = edit.ID
+				break
+			}
+		}
+		c.JSON(http.StatusOK, gin.H{"edits": edits, "undoable": undoable})
+	})
+}
+
+func bindLocalMusicTagRequest(c *gin.Context) (localMusicTagRequest, bool) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, localMusicTagMaxRequestBytes)
+	var req localMusicTagRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "请求过大，封面图片请控制在 16MB 以内"})
+			return req, false
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		return req, false
+	}
+	if err := req.normalize(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return req, false
+	}
+	return req, true
+}
+
+func (req *localMusicTagRequest) normalize() error {
+	ids := make([]string, 0, len(req.IDs))
+	for _, id := range req.IDs {
+		if id = strings.TrimSpace(id); id != "" && !containsString(ids, id) {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return errors.New("请选择要编辑的本地音乐")
+	}
+	if len(ids) > localMusicTagMaxTracks {
+		return fmt.Errorf("一次最多编辑 %d 首", localMusicTagMaxTracks)
+	}
+	req.IDs = ids
+
+	changes := make(map[string]string, len(req.Changes))
+	for field, value := range req.Changes {
+		if !containsString(localMusicTagFields, field) {
+			return fmt.Errorf("不支持的标签字段 %q", field)
+		}
+		value = strings.TrimSpace(value)
+		switch field {
+		case "track", "disc":
+			if value != "" && !localMusicTagNumberPattern.MatchString(value) {
+				return fmt.Errorf("%s 应为数字或 数字/总数", field)
+			}
+		case "year":
+			if value != "" && !localMusicTagYearPattern.MatchString(value) {
+				return errors.New("年份应为四位数字")
+			}
+		}
+		changes[field] = value
+	}
+	req.Changes = changes
+
+	if data := strings.TrimSpace(req.CoverData); data != "" {
+		// 兼容 data URL：data:image/png;base64,....
+		if strings.HasPrefix(data, "data:") {
+			if comma := strings.IndexByte(data, ','); comma > 0 {
+				if req.CoverMime == "" {
+					req.CoverMime = strings.TrimSuffix(strings.TrimPrefix(data[:comma], "data:"), ";base64")
+				}
+				data = data[comma+1:]
+			}
+		}
+		cover, err := base64.StdEncoding.DecodeString(data)
+		if err != nil || len(cover) == 0 {
+			return errors.New("封面图片数据无效")
+		}
+		req.cover = cover
+		req.RemoveCover = false
+	}
+	if len(changes) == 0 && req.cover == nil && !req.RemoveCover {
+		return errors.New("没有要修改的标签")
+	}
+	return nil
+}
+
+func (req localMusicTagRequest) changesCover() bool {
+	return req.cover != nil || req.RemoveCover
+}
+
+// localMusicTagField returns the field of tags named by a request key.
+func localMusicTagField(tags *core.AudioTags, field string) *string {
+	switch field {
+	case "title":
+		return &tags.Title
+	case "artist":
+		return &tags.Artist
+	case "album":
+		return &tags.Album
+	case "album_artist":
+		return &tags.AlbumArtist
+	case "track":
+		return &tags.Track
+	case "disc":
+		return &tags.Disc
+	case "year":
+		return &tags.Year
+	case "genre":
+		return &tags.Genre
+	case "lyrics":
+		return &tags.Lyrics
+	}
+	return nil
+}
+
+// mergedTags applies the request to the current tags of one file.
+func (req localMusicTagRequest) mergedTags(current core.AudioTags) core.AudioTags {
+	next := current
+	next.Cover, next.CoverMime = nil, ""
+	for field, value := range req.Changes {
+		*localMusicTagField(&next, field) = value
+	}
+	if req.cover != nil {
+		next.Cover, next.CoverMime = req.cover, req.CoverMime
+	}
+	next.RemoveCover = req.RemoveCover
+	return next
+}
+
+func changedLocalMusicTagFields(before core.AudioTags, after core.AudioTags, req localMusicTagRequest) []string {
+	changed := make([]string, 0, len(localMusicTagFields)+1)
+	for _, field := range localMusicTagFields {
+		if *localMusicTagField(&before, field) != *localMusicTagField(&after, field) {
+			changed = append(changed, field)
+		}
+	}
+	if req.cover != nil || (req.RemoveCover && len(before.Cover) > 0) {
+		changed = append(changed, "cover")
+	}
+	return changed
+}
+
+func localMusicTagWritableError(track *localMusicTrack) error {
+	if track.ReadOnly {
+		return errLocalLibraryReadOnly
+	}
+	if !containsString(core.TagWritableAudioExts, strings.ToLower(track.Ext)) {
+		return fmt.Errorf("暂不支持编辑 %s 格式的标签", track.Ext)
+	}
+	return nil
+}
+
+func readLocalMusicTags(track *localMusicTrack) (core.AudioTags, error) {
+	data, err := os.ReadFile(track.absPath)
+	if err != nil {
+		return core.AudioTags{}, err
+	}
+	return core.ReadAudioTags(data)
+}
+
+func previewLocalMusicTagEdit(id string, req localMusicTagRequest) localMusicTagPreview {
+	item := localMusicTagPreview{ID: id, Changed: []string{}}
+	track, err := localMusicTrackByID(id)
+	if err != nil {
+		item.Error = "本地音乐不存在或已不在曲库目录内"
+		return item
+	}
+	item.Name, item.Filename, item.RelPath = track.Name, track.Filename, track.RelPath
+	if err := localMusicTagWritableError(track); err != nil {
+		item.Error = err.Error()
+		return item
+	}
+	before, err := readLocalMusicTags(track)
+	if err != nil {
+		item.Error = "读取标签失败: " + err.Error()
+		return item
+	}
+	after := req.mergedTags(before)
+	item.Changed = changedLocalMusicTagFields(before, after, req)
+	// 预览只回传文字字段，封面变化体现在 changed 里。
+	item.Before, item.After = before, after
+	item.Before.Cover, item.Before.CoverMime = nil, ""
+	item.After.Cover, item.After.CoverMime, item.After.RemoveCover = nil, "", false
+	return item
+}
+
+// localMusicTagEditItem is one file of an edit. Batch edits share one
+// request; auto-tagging gives every file its own values.
+type localMusicTagEditItem struct {
+	ID  string
+	Req localMusicTagRequest
+}
+
+// applyLocalMusicTagEdit writes the request to every file and records the
+// previous tags for undo. Files that cannot be edited are reported in failed
+// and do not stop the rest.
+func applyLocalMusicTagEdit(req localMusicTagRequest) (*LocalMusicTagEdit, []*localMusicTrack, []localMusicTagFailure, error) {
+	items := make([]localMusicTagEditItem, 0, len(req.IDs))
+	for _, id := range req.IDs {
+		items = append(items, localMusicTagEditItem{ID: id, Req: req})
+	}
+	return applyLocalMusicTagEditItems(items)
+}
+
+// applyLocalMusicTagEditItems is applyLocalMusicTagEdit with per-file
+// requests; all files still share one undo record.
+func applyLocalMusicTagEditItems(items []localMusicTagEditItem) (*LocalMusicTagEdit, []*localMusicTrack, []localMusicTagFailure, error) {
+	localMusicTagWriteMu.Lock()
+	defer localMusicTagWriteMu.Unlock()
+
+	updated := make([]*localMusicTrack, 0, len(items))
+	failed := []localMusicTagFailure{}
+	snapshots := make([]localMusicTagSnapshot, 0, len(items))
+	fields := make([]string, 0, len(localMusicTagFields)+1)
+	for _, item := range items {
+		track, snapshot, changed, err := writeLocalMusicTagEdit(item.ID, item.Req)
+		if err != nil {
+			failed = append(failed, localMusicTagFailure{ID: item.ID, Error: err.Error()})
+			continue
+		}
+		updated = append(updated, track)
+		if len(changed) == 0 {
+			continue
+		}
+		snapshots = append(snapshots, snapshot)
+		for _, field := range changed {
+			if !containsString(fields, field) {
+				fields = append(fields, field)
+			}
+		}
+	}
+	if len(updated) > 0 {
+		invalidateLocalMusicScanCache()
+	}
+	if len(snapshots) == 0 || db == nil {
+		return nil, updated, failed, nil
+	}
+
+	raw, err := json.Marshal(snapshots)
+	if err != nil {
+		return nil, updated, failed, err
+	}
+	edit := &LocalMusicTagEdit{Tracks: len(snapshots), Fields: strings.Join(fields, ","), Snapshot: string(raw)}
+	if err := db.Create(edit).Error; err != nil {
+		return nil, updated, failed, fmt.Errorf("标签已写入，但撤销记录保存失败: %w", err)
+	}
+	pruneLocalMusicTagEdits()
+	return edit, updated, failed, nil
+}
+
+func writeLocalMusicTagEdit(id string, req localMusicTagRequest) (*localMusicTrack, localMusicTagSnapshot, []string, error) {
+	snapshot := localMusicTagSnapshot{ID: id}
+	track, err := localMusicTrackByID(id)
+	if err != nil {
+		return nil, snapshot, nil, errors.New("本地音乐不存在或已不在曲库目录内")
+	}
+	if err := localMusicTagWritableError(track); err != nil {
+		return nil, snapshot, nil, err
+	}
+	data, err := os.ReadFile(track.absPath)
+	if err != nil {
+		return nil, snapshot, nil, err
+	}
+	before, err := core.ReadAudioTags(data)
+	if err != nil {
+		return nil, snapshot, nil, fmt.Errorf("读取标签失败: %w", err)
+	}
+	after := req.mergedTags(before)
+	changed := changedLocalMusicTagFields(before, after, req)
+	if len(changed) == 0 {
+		return track, snapshot, nil, nil
+	}
+
+	written, err := core.WriteAudioTags(data, track.Ext, after)
+	if err != nil {
+		return nil, snapshot, nil, fmt.Errorf("写入标签失败: %w", err)
+	}
+	if err := writeLocalMusicFileAtomic(track.absPath, written); err != nil {
+		return nil, snapshot, nil, err
+	}
+	refreshed, err := reindexEditedLocalMusicTrack(track)
+	if err != nil {
+		return nil, snapshot, nil, err
+	}
+
+	snapshot.Before = before
+	snapshot.Before.Cover, snapshot.Before.CoverMime = nil, ""
+	if req.changesCover() {
+		snapshot.RestoreCover = true
+		if len(before.Cover) > 0 {
+			snapshot.Cover = base64.StdEncoding.EncodeToString(before.Cover)
+			snapshot.CoverMime = before.CoverMime
+		}
+	}
+	return refreshed, snapshot, changed, nil
+}
+
+// undoLocalMusicTagEdit restores the tags saved by the latest edit. Older
+// edits cannot be undone on their own: later edits may have touched the same
+// files.
+func undoLocalMusicTagEdit(editID uint) ([]*localMusicTrack, []localMusicTagFailure, error) {
+	if db == nil {
+		return nil, nil, errors.New("database is not initialized")
+	}
+	localMusicTagWriteMu.Lock()
+	defer localMusicTagWriteMu.Unlock()
+
+	var latest LocalMusicTagEdit
+	if err := db.Where("undone = ?", false).Order("id DESC").First(&latest).Error; err != nil || latest.ID != editID {
+		return nil, nil, errLocalMusicTagEditStale
+	}
+	var snapshots []localMusicTagSnapshot
+	if err := json.Unmarshal([]byte(latest.Snapshot), &snapshots); err != nil {
+		return nil, nil, fmt.Errorf("撤销记录已损坏: %w", err)
+	}
+
+	restored := make([]*localMusicTrack, 0, len(snapshots))
+	failed := []localMusicTagFailure{}
+	for _, snapshot := range snapshots {
+		track, err := restoreLocalMusicTagSnapshot(snapshot)
+		if err != nil {
+			failed = append(failed, localMusicTagFailure{ID: snapshot.ID, Error: err.Error()})
+			continue
+		}
+		restored = append(restored, track)
+	}
+	if len(restored) > 0 {
+		invalidateLocalMusicScanCache()
+	}
+	if err := db.Model(&LocalMusicTagEdit{}).Where("id = ?", latest.ID).Update("undone", true).Error; err != nil {
+		return restored, failed, err
+	}
+	return restored, failed, nil
+}
+
+func restoreLocalMusicTagSnapshot(snapshot localMusicTagSnapshot) (*localMusicTrack, error) {
+	track, err := localMusicTrackByID(snapshot.ID)
+	if err != nil {
+		return nil, errors.New("本地音乐不存在或已不在曲库目录内")
+	}
+	if err := localMusicTagWritableError(track); err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(track.absPath)
+	if err != nil {
+		return nil, err
+	}
+	tags := snapshot.Before
+	if snapshot.RestoreCover {
+		if snapshot.Cover == "" {
+			tags.RemoveCover = true
+		} else if tags.Cover, err = base64.StdEncoding.DecodeString(snapshot.Cover); err != nil {
+			return nil, fmt.Errorf("撤销记录里的封面无效: %w", err)
+		}
+		tags.CoverMime = snapshot.CoverMime
+	}
+	written, err := core.WriteAudioTags(data, track.Ext, tags)
+	if err != nil {
+		return nil, fmt.Errorf("写入标签失败: %w", err)
+	}
+	if err := writeLocalMusicFileAtomic(track.absPath, written); err != nil {
+		return nil, err
+	}
+	return reindexEditedLocalMusicTrack(track)
+}
+
+func reindexEditedLocalMusicTrack(track *localMusicTrack) (*localMusicTrack, error) {
+	root, ok := localLibraryRootByKey(track.Root)
+	if !ok {
+		return nil, errors.New("local library root is not configured")
+	}
+	forgetLocalMusicTrack(root.Abs, track.RelPath)
+	// 原子替换换了 inode 和文件内容，先把身份钉在原路径上，免得被当成另一个文件。
+	moveLocalMusicIdentity(track.ID, root.Key, track.RelPath)
+	refreshed, err := buildLocalMusicTrack(root, track.absPath)
+	if err != nil {
+		return nil, err
+	}
+	upsertLocalMusicIndexRow(refreshed)
+	return refreshed, nil
+}
+
+// writeLocalMusicFileAtomic replaces path with data through a temp file in
+// the same directory, so a crash never leaves a half-written audio file. The
+// temp name has no audio extension and is ignored by scans and the watcher.
+func writeLocalMusicFileAtomic(path string, data []byte) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tagedit-*.tmp")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+	defer os.Remove(tmpPath)
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmpPath, info.Mode().Perm()); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+func pruneLocalMusicTagEdits() {
+	err := db.Where("id NOT IN (?)", db.Model(&LocalMusicTagEdit{}).Select("id").Order("id DESC").Limit(localMusicTagEditHistory)).
+		Delete(&LocalMusicTagEdit{}).Error
+	if err != nil {
+		core.Logger().Warn("prune local music tag edits failed", "error", err)
+	}
+}
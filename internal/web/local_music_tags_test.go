@@ -0,0 +1,197 @@
+package web
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/guohuiyuan/go-music-dl/core"
+)
+
+func writeTaggedMP3ForTest(t *testing.T, path string, tags core.AudioTags) {
+	t.Helper()
+	audio := append([]byte{0xff, 0xfb, 0x90, 0x64}, make([]byte, 256)...)
+	data, err := core.WriteAudioTags(audio, "mp3", tags)
+	if err != nil {
+		t.Fatalf("WriteAudioTags: %v", err)
+	}
+	writeLocalMusicFileForTest(t, path, string(data))
+}
+
+func readTagsForTest(t *testing.T, path string) core.AudioTags {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read %s: %v", path, err)
+	}
+	tags, err := core.ReadAudioTags(data)
+	if err != nil {
+		t.Fatalf("ReadAudioTags(%s): %v", path, err)
+	}
+	return tags
+}
+
+func postLocalMusicTagsJSON(t *testing.T, target string, payload interface{}, out interface{}) int {
+	t.Helper()
+	body, _ := json.Marshal(payload)
+	req := httptest.NewRequest(http.MethodPost, "http://music.test"+RoutePrefix+target, bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Requested-With", "XMLHttpRequest")
+	req.Header.Set("Origin", "http://music.test")
+	rec := httptest.NewRecorder()
+	newLocalMusicTestRouter().ServeHTTP(rec, req)
+	if out != nil && rec.Code == http.StatusOK {
+		if err := json.Unmarshal(rec.Body.Bytes(), out); err != nil {
+			t.Fatalf("decode %s: %v", target, err)
+		}
+	}
+	return rec.Code
+}
+
+func TestLocalMusicTagBatchEditPreviewAndUndo(t *testing.T) {
+	initCollectionDBForTest(t)
+	downloadDir := t.TempDir()
+	withLocalMusicDownloadDir(t, downloadDir)
+
+	cover := []byte{0xff, 0xd8, 0xff, 0xd9}
+	first := filepath.Join(downloadDir, "a.mp3")
+	second := filepath.Join(downloadDir, "b.mp3")
+	writeTaggedMP3ForTest(t, first, core.AudioTags{Title: "Song A", Artist: "Alpha", Album: "Old", Cover: cover, CoverMime: "image/jpeg"})
+	writeTaggedMP3ForTest(t, second, core.AudioTags{Title: "Song B", Artist: "Beta", Genre: "Rock"})
+	ids := []string{encodeLocalMusicID("a.mp3"), encodeLocalMusicID("b.mp3")}
+	changes := map[string]string{"album": "Hits", "album_artist": "Various Artists", "year": "2024", "genre": ""}
+
+	var preview struct {
+		Items []localMusicTagPreview `json:"items"`
+	}
+	if code := postLocalMusicTagsJSON(t, "/local_music/tags/preview", gin.H{"ids": ids, "changes": changes}, &preview); code != http.StatusOK {
+		t.Fatalf("preview status = %d", code)
+	}
+	if len(preview.Items) != 2 || preview.Items[0].Before.Album != "Old" || preview.Items[0].After.Album != "Hits" {
+		t.Fatalf("preview = %+v", preview.Items)
+	}
+	if got := preview.Items[1].Changed; len(got) != 4 || got[3] != "genre" {
+		t.Fatalf("second file changed fields = %v, want album, album_artist, year, genre", got)
+	}
+	if readTagsForTest(t, first).Album != "Old" {
+		t.Fatal("preview must not write files")
+	}
+
+	var applied struct {
+		EditID uint                   `json:"edit_id"`
+		Tracks []localMusicTrack      `json:"tracks"`
+		Failed []localMusicTagFailure `json:"failed"`
+	}
+	if code := postLocalMusicTagsJSON(t, "/local_music/tags", gin.H{"ids": ids, "changes": changes}, &applied); code != http.StatusOK {
+		t.Fatalf("apply status = %d", code)
+	}
+	if applied.EditID == 0 || len(applied.Tracks) != 2 || len(applied.Failed) != 0 {
+		t.Fatalf("apply = %+v", applied)
+	}
+	tags := readTagsForTest(t, first)
+	if tags.Title != "Song A" || tags.Album != "Hits" || tags.AlbumArtist != "Various Artists" || tags.Year != "2024" || !bytes.Equal(tags.Cover, cover) {
+		t.Fatalf("edited tags = %+v", tags)
+	}
+	if readTagsForTest(t, second).Genre != "" {
+		t.Fatal("empty change should clear the genre")
+	}
+	var row LocalMusicIndex
+	if err := db.First(&row, "id = ?", ids[1]).Error; err != nil || row.Album != "Hits" || row.AlbumArtist != "Various Artists" {
+		t.Fatalf("index row = %+v, %v", row, err)
+	}
+	if leftovers, _ := filepath.Glob(filepath.Join(downloadDir, ".tagedit-*")); len(leftovers) != 0 {
+		t.Fatalf("temp files left behind: %v", leftovers)
+	}
+
+	// A cover-only edit becomes the latest; the album edit can no longer be undone first.
+	newCover := []byte{0x89, 'P', 'N', 'G'}
+	var coverEdit struct {
+		EditID uint `json:"edit_id"`
+	}
+	postLocalMusicTagsJSON(t, "/local_music/tags", gin.H{"ids": ids[:1], "cover_data": "data:image/png;base64," + base64.StdEncoding.EncodeToString(newCover)}, &coverEdit)
+	if !bytes.Equal(readTagsForTest(t, first).Cover, newCover) {
+		t.Fatal("cover was not replaced")
+	}
+	if code := postLocalMusicTagsJSON(t, "/local_music/tags/undo", gin.H{"edit_id": applied.EditID}, nil); code != http.StatusConflict {
+		t.Fatalf("undo of older edit status = %d, want 409", code)
+	}
+	if code := postLocalMusicTagsJSON(t, "/local_music/tags/undo", gin.H{"edit_id": coverEdit.EditID}, nil); code != http.StatusOK {
+		t.Fatalf("undo cover edit status = %d", code)
+	}
+	if !bytes.Equal(readTagsForTest(t, first).Cover, cover) {
+		t.Fatal("undo should restore the original cover")
+	}
+	if code := postLocalMusicTagsJSON(t, "/local_music/tags/undo", gin.H{"edit_id": applied.EditID}, nil); code != http.StatusOK {
+		t.Fatalf("undo album edit status = %d", code)
+	}
+	tags = readTagsForTest(t, first)
+	if tags.Album != "Old" || tags.AlbumArtist != "" || tags.Year != "" || !bytes.Equal(tags.Cover, cover) {
+		t.Fatalf("undone tags = %+v", tags)
+	}
+	if readTagsForTest(t, second).Genre != "Rock" {
+		t.Fatal("undo should restore the cleared genre")
+	}
+	row = LocalMusicIndex{}
+	if err := db.First(&row, "id = ?", ids[0]).Error; err != nil || row.Album != "Old" {
+		t.Fatalf("index row after undo = %+v, %v", row, err)
+	}
+	if code := postLocalMusicTagsJSON(t, "/local_music/tags/undo", gin.H{"edit_id": applied.EditID}, nil); code != http.StatusConflict {
+		t.Fatalf("second undo status = %d, want 409", code)
+	}
+}
+
+func TestLocalMusicTagEditValidationAndReadOnlyRoots(t *testing.T) {
+	initCollectionDBForTest(t)
+	downloadDir := t.TempDir()
+	withLocalMusicDownloadDir(t, downloadDir)
+	archiveDir := t.TempDir()
+	withLocalLibraryRoots(t, core.LibraryRoot{Key: "archive", Label: "Archive", Path: archiveDir, ReadOnly: true})
+
+	writeTaggedMP3ForTest(t, filepath.Join(downloadDir, "a.mp3"), core.AudioTags{Title: "A"})
+	writeTaggedMP3ForTest(t, filepath.Join(archiveDir, "keep.mp3"), core.AudioTags{Title: "Keep"})
+	writeLocalMusicFileForTest(t, filepath.Join(downloadDir, "c.wav"), "RIFF")
+	id := encodeLocalMusicID("a.mp3")
+
+	for _, payload := range []gin.H{
+		{"ids": []string{id}, "changes": map[string]string{"track": "3 of 10"}},
+		{"ids": []string{id}, "changes": map[string]string{"year": "24"}},
+		{"ids": []string{id}, "changes": map[string]string{"comment": "x"}},
+		{"ids": []string{id}, "changes": map[string]string{}},
+		{"ids": []string{}, "changes": map[string]string{"title": "x"}},
+	} {
+		if code := postLocalMusicTagsJSON(t, "/local_music/tags", payload, nil); code != http.StatusBadRequest {
+			t.Fatalf("payload %v status = %d, want 400", payload, code)
+		}
+	}
+
+	var applied struct {
+		Tracks []localMusicTrack      `json:"tracks"`
+		Failed []localMusicTagFailure `json:"failed"`
+	}
+	ids := []string{id, encodeLocalLibraryID("archive", "keep.mp3"), encodeLocalMusicID("c.wav")}
+	if code := postLocalMusicTagsJSON(t, "/local_music/tags", gin.H{"ids": ids, "changes": map[string]string{"track": "2/9"}}, &applied); code != http.StatusOK {
+		t.Fatalf("apply status = %d", code)
+	}
+	if len(applied.Tracks) != 1 || len(applied.Failed) != 2 || applied.Failed[0].Error != errLocalLibraryReadOnly.Error() {
+		t.Fatalf("apply = %+v", applied)
+	}
+	if readTagsForTest(t, filepath.Join(archiveDir, "keep.mp3")).Track != "" {
+		t.Fatal("read-only root file was modified")
+	}
+
+	rec := httptest.NewRecorder()
+	newLocalMusicTestRouter().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, RoutePrefix+"/local_music/tags?id="+id, nil))
+	var current struct {
+		Tags     core.AudioTags `json:"tags"`
+		Writable bool           `json:"writable"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &current); err != nil || current.Tags.Track != "2/9" || !current.Writable {
+		t.Fatalf("GET tags = %s, %v", rec.Body.String(), err)
+	}
+}
@@ -23,11 +23,14 @@ func withLocalMusicDownloadDir(t *testing.T, dir string) {
 	t.Helper()
 
 	original := localMusicDownloadDirProvider
+	originalRoots := localLibraryRootsProvider
 	localMusicDownloadDirProvider = func() string {
 		return dir
 	}
+	localLibraryRootsProvider = func() []core.LibraryRoot { return nil }
 	t.Cleanup(func() {
 		localMusicDownloadDirProvider = original
+		localLibraryRootsProvider = originalRoots
 	})
 }
 
@@ -59,6 +62,15 @@ func withAutoCacheSettings(t *testing.T, settings core.WebSettings) {
 	})
 }
 
+func waitJobDoneForTest(t *testing.T, what string, done <-chan struct{}) {
+	t.Helper()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("%s job did not finish", what)
+	}
+}
+
 func waitForAutoCacheIdle(t *testing.T) {
 	t.Helper()
 	deadline := time.Now().Add(time.Second)
@@ -737,6 +749,60 @@ func TestAutoCacheEndpointRequiresSameOrigin(t *testing.T) {
 	}
 }
 
+func TestLocalMusicWriteRoutesRequireSameOrigin(t *testing.T) {
+	routes := []struct{ method, path string }{
+		{http.MethodPost, "/local_music/tags"},
+		{http.MethodPost, "/local_music/tags/undo"},
+		{http.MethodPost, "/local_music/identify"},
+		{http.MethodPost, "/local_music/identify/cancel"},
+		{http.MethodPost, "/local_music/identify/apply"},
+		{http.MethodPost, "/local_music/fingerprints"},
+		{http.MethodPost, "/local_music/fingerprints/cancel"},
+		{http.MethodPost, "/local_music/duplicates/resolve"},
+		{http.MethodPost, "/local_music/organize"},
+		{http.MethodPost, "/local_music/organize/preview"},
+		{http.MethodPost, "/local_music/organize/cancel"},
+		{http.MethodPost, "/transcode/jobs"},
+		{http.MethodPost, "/transcode/jobs/1/cancel"},
+		{http.MethodPost, "/transcode/jobs/clear"},
+		{http.MethodPost, "/local_music/loudness"},
+		{http.MethodPost, "/local_music/loudness/cancel"},
+		{http.MethodPost, "/local_music/played"},
+		{http.MethodPost, "/collections/import_file"},
+		{http.MethodPost, "/collections/import_file/cancel"},
+		{http.MethodPost, "/collections/import_file/apply"},
+		{http.MethodPost, "/collections/migrate"},
+		{http.MethodPost, "/collections/migrate/cancel"},
+		{http.MethodPut, "/collections/migrations/1/tracks/0"},
+		{http.MethodPost, "/collections/migrations/1/tracks/0/search"},
+		{http.MethodPost, "/collections/1/sync"},
+		{http.MethodPut, "/collections/1/sync"},
+		{http.MethodPost, "/collections/smart/preview"},
+		{http.MethodPost, "/collections/smart"},
+		{http.MethodPut, "/collections/1/rules"},
+		{http.MethodPut, "/collections/1/songs/order"},
+		{http.MethodPost, "/collections/1/songs/transfer"},
+		{http.MethodPut, "/collections/1/songs/note"},
+		{http.MethodPut, "/collections/1/group"},
+	}
+	for _, route := range routes {
+		for name, header := range map[string][2]string{
+			"cross origin": {"XMLHttpRequest", "https://evil.example"},
+			"missing xhr":  {"", "http://music.test"},
+		} {
+			req := httptest.NewRequest(route.method, "http://music.test"+RoutePrefix+route.path, strings.NewReader(`{}`))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("X-Requested-With", header[0])
+			req.Header.Set("Origin", header[1])
+			rec := httptest.NewRecorder()
+			newLocalMusicTestRouter().ServeHTTP(rec, req)
+			if rec.Code != http.StatusForbidden {
+				t.Fatalf("%s %s %s status = %d, want %d", name, route.method, route.path, rec.Code, http.StatusForbidden)
+			}
+		}
+	}
+}
+
 func TestAutoCacheEndpointRejectsOversizedRequest(t *testing.T) {
 	body := []byte(`{"id":"song-1","source":"qq","name":"` + strings.Repeat("x", autoCacheMaxRequestBytes) + `"}`)
 	rec := httptest.NewRecorder()
@@ -1279,7 +1345,7 @@ func TestLocalMusicClientQueuesPageChangesAndRefreshesAfterDuplicateDeletion(t *
 	js := string(content)
 	for _, want := range []string{
 		"let queuedLocalMusicPageLoad = null;",
-		"async function fetchLocalMusicPagePayload(params)",
+		"async function fetchLocalMusicPagePayload(params, endpoint)",
 		`cache: "no-store"`,
 		"persistWebSettingsCache();",
 		"await refreshLocalMusicPageAfterMutation();",
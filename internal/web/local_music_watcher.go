@@ -0,0 +1,813 @@
+package web
+
+import (
+	"errors"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/gin-gonic/gin"
+	"github.com/guohuiyuan/go-music-dl/core"
+)
+
+// 本地音乐目录监听：每个曲库根目录一个 watcher，优先用 fsnotify 把增删改、
+// 重命名增量写进索引表，不可用时退回定期 stat 轮询；两种模式都保留低频全量对账兜底。
+var (
+	localMusicWatchDebounce          = 2 * time.Second
+	localMusicWatchPollInterval      = 30 * time.Second
+	localMusicWatchReconcileInterval = time.Hour
+	localMusicWatchModeProvider      = func() string {
+		return core.GetWebSettings().LocalMusicWatchMode
+	}
+	// localMusicWatchNewFSNotify is swapped in tests to exercise the fallback.
+	localMusicWatchNewFSNotify = fsnotify.NewWatcher
+)
+
+var (
+	localMusicWatcherMu sync.Mutex
+	// localMusicWatchers holds one watcher per library root, download dir first.
+	localMusicWatchers []*localMusicWatcher
+	// localMusicWatcherWanted is set while the web server runs, so settings
+	// changes only restart a watcher the server actually started.
+	localMusicWatcherWanted bool
+)
+
+type localMusicFileStamp struct {
+	size    int64
+	modTime time.Time
+}
+
+type localMusicWatcher struct {
+	lib  localLibraryRoot
+	root string
+	mode string
+
+	fs          *fsnotify.Watcher
+	watchedDirs map[string]struct{}
+	pending     map[string]time.Time
+	pollState   map[string]localMusicFileStamp
+
+	stop chan struct{}
+	done chan struct{}
+
+	mu              sync.Mutex
+	active          string
+	fallbackReason  string
+	startedAt       time.Time
+	lastEventAt     time.Time
+	lastReconcileAt time.Time
+	reconcileErr    string
+	lastError       string
+	lastErrorAt     time.Time
+	errorCount      int64
+	added           int64
+	updated         int64
+	removed         int64
+	pendingCount    int
+	watchedCount    int
+}
+
+// LocalMusicWatcherStatus is served by GET /local_music/watcher. The top level
+// aggregates all library roots; Roots has one entry per root.
+type LocalMusicWatcherStatus struct {
+	Key               string     `json:"key,omitempty"`
+	Label             string     `json:"label,omitempty"`
+	Mode              string     `json:"mode"`
+	Active            string     `json:"active"`
+	Running           bool       `json:"running"`
+	Healthy           bool       `json:"healthy"`
+	Root              string     `json:"root"`
+	FallbackReason    string     `json:"fallback_reason,omitempty"`
+	WatchedDirs       int        `json:"watched_dirs"`
+	Pending           int        `json:"pending"`
+	Added             int64      `json:"added"`
+	Updated           int64      `json:"updated"`
+	Removed           int64      `json:"removed"`
+	Errors            int64      `json:"errors"`
+	LastError         string     `json:"last_error,omitempty"`
+	LastErrorAt       *time.Time `json:"last_error_at,omitempty"`
+	LastEventAt       *time.Time `json:"last_event_at,omitempty"`
+	LastReconcileAt   *time.Time `json:"last_reconcile_at,omitempty"`
+	ReconcileError    string     `json:"reconcile_error,omitempty"`
+	StartedAt         *time.Time `json:"started_at,omitempty"`
+	DebounceMs        int64      `json:"debounce_ms"`
+	PollIntervalSec   int64      `json:"poll_interval_sec"`
+	ReconcileInterval int64      `json:"reconcile_interval_sec"`
+
+	Roots []LocalMusicWatcherStatus `json:"roots,omitempty"`
+}
+
+// startLocalMusicWatcher (re)starts watching every library root using the
+// configured mode. It is called once when the server starts.
+func startLocalMusicWatcher() {
+	localMusicWatcherMu.Lock()
+	defer localMusicWatcherMu.Unlock()
+	localMusicWatcherWanted = true
+	replaceLocalMusicWatcherLocked()
+}
+
+// stopLocalMusicWatcher stops the watcher and waits for its loop to exit.
+func stopLocalMusicWatcher() {
+	localMusicWatcherMu.Lock()
+	defer localMusicWatcherMu.Unlock()
+	localMusicWatcherWanted = false
+	closeLocalMusicWatchersLocked()
+}
+
+func closeLocalMusicWatchersLocked() {
+	for _, w := range localMusicWatchers {
+		w.close()
+	}
+	localMusicWatchers = nil
+}
+
+// restartLocalMusicWatcher picks up changed library roots or watch mode. It does nothing when the server never started a watcher.
+func restartLocalMusicWatcher() {
+	localMusicWatcherMu.Lock()
+	defer localMusicWatcherMu.Unlock()
+	if localMusicWatcherWanted {
+		replaceLocalMusicWatcherLocked()
+	}
+}
+
+func replaceLocalMusicWatcherLocked() {
+	closeLocalMusicWatchersLocked()
+	mode := localMusicWatchMode()
+	if mode == core.LocalMusicWatchOff {
+		return
+	}
+	for _, root := range localLibraryRoots() {
+		w := newLocalMusicWatcher(root, mode)
+		localMusicWatchers = append(localMusicWatchers, w)
+		go w.run()
+	}
+}
+
+func localMusicWatchMode() string {
+	switch mode := strings.ToLower(strings.TrimSpace(localMusicWatchModeProvider())); mode {
+	case core.LocalMusicWatchFSNotify, core.LocalMusicWatchPoll, core.LocalMusicWatchOff:
+		return mode
+	}
+	return core.LocalMusicWatchAuto
+}
+
+func currentLocalMusicWatchers() []*localMusicWatcher {
+	localMusicWatcherMu.Lock()
+	defer localMusicWatcherMu.Unlock()
+	return append([]*localMusicWatcher(nil), localMusicWatchers...)
+}
+
+// currentLocalMusicWatcher returns the download directory's watcher.
+func currentLocalMusicWatcher() *localMusicWatcher {
+	if watchers := currentLocalMusicWatchers(); len(watchers) > 0 {
+		return watchers[0]
+	}
+	return nil
+}
+
+// localMusicWatcherCovers reports whether healthy watchers keep every root of
+// dir's library current, in which case TTL based rescans are unnecessary.
+func localMusicWatcherCovers(dir string) bool {
+	watchers := currentLocalMusicWatchers()
+	roots := localLibraryRoots()
+	if len(watchers) != len(roots) || absOrClean(dir) != roots[0].Abs {
+		return false
+	}
+	for i, w := range watchers {
+		if w.root != roots[i].Abs || !w.status().Healthy {
+			return false
+		}
+	}
+	return true
+}
+
+func newLocalMusicWatcher(lib localLibraryRoot, mode string) *localMusicWatcher {
+	root := lib.Abs
+	w := &localMusicWatcher{
+		lib:         lib,
+		root:        root,
+		mode:        mode,
+		watchedDirs: make(map[string]struct{}),
+		pending:     make(map[string]time.Time),
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+		startedAt:   time.Now(),
+	}
+	if mode == core.LocalMusicWatchPoll {
+		w.active = core.LocalMusicWatchPoll
+		w.pollState = w.pollSnapshot()
+		return w
+	}
+
+	err := w.openFSNotify()
+	if err == nil {
+		w.active = core.LocalMusicWatchFSNotify
+		return w
+	}
+	w.closeFSNotify()
+	if mode == core.LocalMusicWatchFSNotify {
+		// 明确要求 fsnotify 时不静默降级，状态接口会显示为不健康。
+		w.recordError(err)
+		return w
+	}
+	w.active = core.LocalMusicWatchPoll
+	w.fallbackReason = err.Error()
+	w.pollState = w.pollSnapshot()
+	core.Logger().Info("local music watcher falls back to polling", "root", root, "reason", err)
+	return w
+}
+
+func (w *localMusicWatcher) openFSNotify() error {
+	info, err := os.Stat(w.root)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return errors.New("download dir is not a directory")
+	}
+	watcher, err := localMusicWatchNewFSNotify()
+	if err != nil {
+		return err
+	}
+	w.fs = watcher
+	return w.watchTree(w.root, false)
+}
+
+func (w *localMusicWatcher) closeFSNotify() {
+	if w.fs != nil {
+		_ = w.fs.Close()
+		w.fs = nil
+	}
+	w.watchedDirs = make(map[string]struct{})
+	w.mu.Lock()
+	w.watchedCount = 0
+	w.mu.Unlock()
+}
+
+func (w *localMusicWatcher) close() {
+	close(w.stop)
+	<-w.done
+}
+
+func (w *localMusicWatcher) run() {
+	defer close(w.done)
+	defer w.closeFSNotify()
+
+	core.Logger().Info("local music watcher started", "root", w.root, "mode", w.mode, "active", w.active)
+
+	flushEvery := localMusicWatchDebounce / 4
+	if flushEvery < 10*time.Millisecond {
+		flushEvery = 10 * time.Millisecond
+	}
+	flush := time.NewTicker(flushEvery)
+	defer flush.Stop()
+	reconcile := time.NewTicker(localMusicWatchReconcileInterval)
+	defer reconcile.Stop()
+
+	var events chan fsnotify.Event
+	var watchErrors chan error
+	if w.fs != nil {
+		events, watchErrors = w.fs.Events, w.fs.Errors
+	}
+	var poll *time.Ticker
+	var pollC <-chan time.Time
+	startPolling := func() {
+		if poll != nil {
+			return
+		}
+		if w.pollState == nil {
+			w.pollState = w.pollSnapshot()
+		}
+		poll = time.NewTicker(localMusicWatchPollInterval)
+		pollC = poll.C
+	}
+	defer func() {
+		if poll != nil {
+			poll.Stop()
+		}
+	}()
+	if w.currentActive() == core.LocalMusicWatchPoll {
+		startPolling()
+	}
+	// fallBack 在运行中 fsnotify 失效（目录被删、监听数耗尽）时切到轮询。
+	fallBack := func(reason string) {
+		if w.mode != core.LocalMusicWatchAuto || w.currentActive() == core.LocalMusicWatchPoll {
+			return
+		}
+		core.Logger().Warn("local music watcher falls back to polling", "root", w.root, "reason", reason)
+		w.closeFSNotify()
+		events, watchErrors = nil, nil
+		w.mu.Lock()
+		w.active = core.LocalMusicWatchPoll
+		w.fallbackReason = reason
+		w.mu.Unlock()
+		startPolling()
+		// 切换期间可能漏掉事件，先全量对账一次。
+		w.reconcile()
+	}
+
+	for {
+		select {
+		case <-w.stop:
+			return
+		case event, ok := <-events:
+			if !ok {
+				fallBack("fsnotify event channel closed")
+				continue
+			}
+			if event.Has(fsnotify.Chmod) && !event.Has(fsnotify.Create) && !event.Has(fsnotify.Write) {
+				continue
+			}
+			if event.Name == w.root && (event.Has(fsnotify.Remove) || event.Has(fsnotify.Rename)) {
+				fallBack("download dir was removed or renamed")
+				continue
+			}
+			w.queue(event.Name)
+		case err, ok := <-watchErrors:
+			if !ok {
+				fallBack("fsnotify error channel closed")
+				continue
+			}
+			w.recordError(err)
+			if errors.Is(err, fsnotify.ErrEventOverflow) {
+				// 丢了事件就没法增量追平，直接全量对账。
+				w.reconcile()
+			}
+		case <-flush.C:
+			w.flush(false)
+			if w.fs != nil && w.watchErr() != nil {
+				fallBack(w.watchErr().Error())
+			}
+		case <-pollC:
+			w.pollOnce()
+		case <-reconcile.C:
+			w.reconcile()
+		}
+	}
+}
+
+func (w *localMusicWatcher) currentActive() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.active
+}
+
+// watchErr reports a failure to add watches (e.g. the inotify limit), which
+// means events for part of the tree would be missed.
+func (w *localMusicWatcher) watchErr() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if strings.HasPrefix(w.lastError, "watch ") && w.lastErrorAt.After(w.lastReconcileAt) {
+		return errors.New(w.lastError)
+	}
+	return nil
+}
+
+func (w *localMusicWatcher) queue(path string) {
+	if w.ignored(path) {
+		return
+	}
+	w.pending[path] = time.Now()
+	w.mu.Lock()
+	w.lastEventAt = time.Now()
+	w.pendingCount = len(w.pending)
+	w.mu.Unlock()
+}
+
+// flush applies queued paths that have been quiet for the debounce window, so
+// a file still being written is indexed once, after the last write.
+func (w *localMusicWatcher) flush(all bool) {
+	if len(w.pending) == 0 {
+		return
+	}
+	now := time.Now()
+	ready := make([]string, 0, len(w.pending))
+	for path, at := range w.pending {
+		if all || now.Sub(at) >= localMusicWatchDebounce {
+			ready = append(ready, path)
+		}
+	}
+	if len(ready) == 0 {
+		return
+	}
+	sort.Strings(ready)
+	for _, path := range ready {
+		delete(w.pending, path)
+	}
+	w.mu.Lock()
+	w.pendingCount = len(w.pending)
+	w.mu.Unlock()
+
+	changed := false
+	for _, path := range ready {
+		if w.apply(path) {
+			changed = true
+		}
+	}
+	if changed {
+		invalidateLocalMusicScanCache()
+	}
+}
+
+func (w *localMusicWatcher) ignored(path string) bool {
+	rel, err := filepath.Rel(w.root, path)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return true
+	}
+	for _, part := range strings.Split(filepath.ToSlash(rel), "/") {
+		if strings.HasPrefix(part, ".") {
+			return true
+		}
+	}
+	return w.lib.excludesDir(rel)
+}
+
+// apply brings the index in line with one path and reports whether it changed.
+func (w *localMusicWatcher) apply(path string) bool {
+	rel, err := filepath.Rel(w.root, path)
+	if err != nil {
+		return false
+	}
+	rel = filepath.ToSlash(rel)
+
+	info, err := os.Stat(path)
+	switch {
+	case err != nil:
+		if isLocalMusicAudioFile(path) {
+			deleteLocalMusicIndexRowAt(w.lib.Key, rel)
+			w.count("removed", 1)
+			return true
+		}
+		if isLocalMusicSidecarFile(path) {
+			return w.refreshSidecarOwners(path)
+		}
+		// 目录被删除或移走：清掉它下面的所有行，对应的 watch 由 fsnotify 自行移除。
+		w.forgetDir(path)
+		if removed := deleteLocalMusicIndexRowsUnder(w.lib.Key, rel); removed > 0 {
+			w.count("removed", removed)
+			return true
+		}
+		return false
+	case info.IsDir():
+		if w.fs != nil {
+			if err := w.watchTree(path, true); err != nil {
+				w.recordError(err)
+			}
+			return true
+		}
+		return w.indexTree(path)
+	case isLocalMusicAudioFile(path):
+		if !w.lib.allows(rel) {
+			// 不再符合 include 规则（例如改名）时从索引里移除。
+			deleteLocalMusicIndexRowAt(w.lib.Key, rel)
+			return true
+		}
+		return w.upsert(path)
+	case isLocalMusicSidecarFile(path):
+		return w.refreshSidecarOwners(path)
+	}
+	return false
+}
+
+func (w *localMusicWatcher) upsert(path string) bool {
+	track, err := buildLocalMusicTrackFast(w.lib, path)
+	if err != nil {
+		return false
+	}
+	op := "added"
+	if db != nil {
+		var count int64
+		if db.Model(&LocalMusicIndex{}).Where("id = ?", track.ID).Count(&count).Error == nil && count > 0 {
+			op = "updated"
+		}
+	}
+	upsertLocalMusicIndexRow(track)
+	w.count(op, 1)
+	return true
+}
+
+// refreshSidecarOwners re-indexes audio files sharing the sidecar's stem so
+// their has_cover / has_lyric flags follow the sidecar.
+func (w *localMusicWatcher) refreshSidecarOwners(sidecar string) bool {
+	stem := strings.TrimSuffix(sidecar, filepath.Ext(sidecar))
+	changed := false
+	for ext := range localMusicAudioExts {
+		for _, candidate := range []string{stem + ext, stem + strings.ToUpper(ext)} {
+			if info, err := os.Stat(candidate); err == nil && !info.IsDir() {
+				if rel, err := filepath.Rel(w.root, candidate); err == nil {
+					forgetLocalMusicTrack(w.root, filepath.ToSlash(rel))
+				}
+				if w.upsert(candidate) {
+					changed = true
+				}
+			}
+		}
+	}
+	return changed
+}
+
+// watchTree adds fsnotify watches for dir and its visible subdirectories.
+// With index set, audio files found on the way are indexed too (a directory
+// moved into the library arrives as a single Create event).
+func (w *localMusicWatcher) watchTree(dir string, index bool) error {
+	var firstErr error
+	_ = filepath.WalkDir(dir, func(path string, entry fs.DirEntry, walkErr error) error {
+		if walkErr != nil {
+			return nil
+		}
+		if entry.IsDir() {
+			if path != w.root && w.ignored(path) {
+				return filepath.SkipDir
+			}
+			if _, ok := w.watchedDirs[path]; ok {
+				return nil
+			}
+			if err := w.fs.Add(path); err != nil {
+				if firstErr == nil {
+					firstErr = errors.New("watch " + path + ": " + err.Error())
+				}
+				return filepath.SkipDir
+			}
+			w.watchedDirs[path] = struct{}{}
+			return nil
+		}
+		if index && w.indexable(path) {
+			w.upsert(path)
+		}
+		return nil
+	})
+	w.mu.Lock()
+	w.watchedCount = len(w.watchedDirs)
+	w.mu.Unlock()
+	return firstErr
+}
+
+func (w *localMusicWatcher) indexTree(dir string) bool {
+	changed := false
+	_ = filepath.WalkDir(dir, func(path string, entry fs.DirEntry, walkErr error) error {
+		if walkErr != nil {
+			return nil
+		}
+		if entry.IsDir() {
+			if path != w.root && w.ignored(path) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if w.indexable(path) && w.upsert(path) {
+			changed = true
+		}
+		return nil
+	})
+	return changed
+}
+
+// indexable reports whether path is an audio file this root indexes.
+func (w *localMusicWatcher) indexable(path string) bool {
+	if !isLocalMusicAudioFile(path) {
+		return false
+	}
+	rel, err := filepath.Rel(w.root, path)
+	return err == nil && w.lib.allows(rel)
+}
+
+func (w *localMusicWatcher) forgetDir(dir string) {
+	prefix := dir + string(filepath.Separator)
+	for path := range w.watchedDirs {
+		if path == dir || strings.HasPrefix(path, prefix) {
+			delete(w.watchedDirs, path)
+		}
+	}
+	w.mu.Lock()
+	w.watchedCount = len(w.watchedDirs)
+	w.mu.Unlock()
+}
+
+// pollSnapshot stats every audio and sidecar file under root. It never opens
+// files, so a poll over a large library stays cheap.
+func (w *localMusicWatcher) pollSnapshot() map[string]localMusicFileStamp {
+	state := make(map[string]localMusicFileStamp)
+	_ = filepath.WalkDir(w.root, func(path string, entry fs.DirEntry, walkErr error) error {
+		if walkErr != nil {
+			return nil
+		}
+		if entry.IsDir() {
+			if path != w.root && w.ignored(path) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !isLocalMusicAudioFile(path) && !isLocalMusicSidecarFile(path) {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil
+		}
+		state[path] = localMusicFileStamp{size: info.Size(), modTime: info.ModTime()}
+		return nil
+	})
+	return state
+}
+
+func (w *localMusicWatcher) pollOnce() {
+	next := w.pollSnapshot()
+	changedPaths := make([]string, 0)
+	for path, stamp := range next {
+		if previous, ok := w.pollState[path]; !ok || previous != stamp {
+			changedPaths = append(changedPaths, path)
+		}
+	}
+	for path := range w.pollState {
+		if _, ok := next[path]; !ok {
+			changedPaths = append(changedPaths, path)
+		}
+	}
+	w.pollState = next
+	if len(changedPaths) == 0 {
+		return
+	}
+	w.mu.Lock()
+	w.lastEventAt = time.Now()
+	w.mu.Unlock()
+	sort.Strings(changedPaths)
+	changed := false
+	for _, path := range changedPaths {
+		if w.apply(path) {
+			changed = true
+		}
+	}
+	if changed {
+		invalidateLocalMusicScanCache()
+	}
+}
+
+// reconcile runs a full index sync as a safety net for missed events, and
+// re-adds watches for directories that appeared while events were lost.
+func (w *localMusicWatcher) reconcile() {
+	w.flush(true)
+	err := syncLocalLibraryRootIndex(w.lib)
+	invalidateLocalMusicScanCache()
+	if w.fs != nil {
+		if watchErr := w.watchTree(w.root, false); watchErr != nil {
+			w.recordError(watchErr)
+		}
+	}
+	if w.pollState != nil {
+		w.pollState = w.pollSnapshot()
+	}
+	w.mu.Lock()
+	w.lastReconcileAt = time.Now()
+	w.reconcileErr = ""
+	if err != nil {
+		w.reconcileErr = err.Error()
+	}
+	w.mu.Unlock()
+	if err != nil {
+		core.Logger().Warn("local music reconcile failed", "root", w.root, "error", err)
+	}
+}
+
+func (w *localMusicWatcher) count(op string, n int64) {
+	localMusicWatchEventsTotal.Add(float64(n), op)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	switch op {
+	case "added":
+		w.added += n
+	case "updated":
+		w.updated += n
+	case "removed":
+		w.removed += n
+	}
+}
+
+func (w *localMusicWatcher) recordError(err error) {
+	core.Logger().Warn("local music watcher error", "root", w.root, "error", err)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.errorCount++
+	w.lastError = err.Error()
+	w.lastErrorAt = time.Now()
+}
+
+func (w *localMusicWatcher) status() LocalMusicWatcherStatus {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	status := LocalMusicWatcherStatus{
+		Key:            w.lib.Key,
+		Label:          w.lib.Label,
+		Mode:           w.mode,
+		Active:         w.active,
+		Running:        w.active != "",
+		Root:           filepath.ToSlash(w.root),
+		FallbackReason: w.fallbackReason,
+		WatchedDirs:    w.watchedCount,
+		Pending:        w.pendingCount,
+		Added:          w.added,
+		Updated:        w.updated,
+		Removed:        w.removed,
+		Errors:         w.errorCount,
+		LastError:      w.lastError,
+		ReconcileError: w.reconcileErr,
+		LastErrorAt:    optionalTime(w.lastErrorAt),
+		LastEventAt:    optionalTime(w.lastEventAt),
+		StartedAt:      optionalTime(w.startedAt),
+	}
+	status.LastReconcileAt = optionalTime(w.lastReconcileAt)
+	status.Healthy = status.Running && w.reconcileErr == ""
+	if w.active == core.LocalMusicWatchFSNotify && strings.HasPrefix(w.lastError, "watch ") && w.lastErrorAt.After(w.lastReconcileAt) {
+		status.Healthy = false
+	}
+	return status
+}
+
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+// localMusicWatcherStatus aggregates the per-root watchers. The top-level
+// mode, active backend and root describe the download directory; counters are
+// summed and the library is healthy only when every root is.
+func localMusicWatcherStatus() LocalMusicWatcherStatus {
+	status := LocalMusicWatcherStatus{Mode: localMusicWatchMode()}
+	watchers := currentLocalMusicWatchers()
+	if len(watchers) == 0 {
+		status.Root = filepath.ToSlash(localDownloadLibraryRoot().Abs)
+	}
+	for i, w := range watchers {
+		rootStatus := w.status()
+		status.Roots = append(status.Roots, rootStatus)
+		if i == 0 {
+			status.Mode, status.Active, status.Root = rootStatus.Mode, rootStatus.Active, rootStatus.Root
+			status.Running, status.Healthy = rootStatus.Running, rootStatus.Healthy
+			status.FallbackReason, status.StartedAt = rootStatus.FallbackReason, rootStatus.StartedAt
+		}
+		status.Running = status.Running && rootStatus.Running
+		status.Healthy = status.Healthy && rootStatus.Healthy
+		status.WatchedDirs += rootStatus.WatchedDirs
+		status.Pending += rootStatus.Pending
+		status.Added += rootStatus.Added
+		status.Updated += rootStatus.Updated
+		status.Removed += rootStatus.Removed
+		status.Errors += rootStatus.Errors
+		if rootStatus.LastErrorAt != nil && (status.LastErrorAt == nil || rootStatus.LastErrorAt.After(*status.LastErrorAt)) {
+			status.LastError, status.LastErrorAt = rootStatus.LastError, rootStatus.LastErrorAt
+		}
+		if rootStatus.LastEventAt != nil && (status.LastEventAt == nil || rootStatus.LastEventAt.After(*status.LastEventAt)) {
+			status.LastEventAt = rootStatus.LastEventAt
+		}
+		if rootStatus.LastReconcileAt != nil && (status.LastReconcileAt == nil || rootStatus.LastReconcileAt.Before(*status.LastReconcileAt)) {
+			status.LastReconcileAt = rootStatus.LastReconcileAt
+		}
+		if rootStatus.ReconcileError != "" {
+			status.ReconcileError = rootStatus.ReconcileError
+		}
+	}
+	status.DebounceMs = localMusicWatchDebounce.Milliseconds()
+	status.PollIntervalSec = int64(localMusicWatchPollInterval / time.Second)
+	status.ReconcileInterval = int64(localMusicWatchReconcileInterval / time.Second)
+	return status
+}
+
+func isLocalMusicSidecarFile(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	return containsString(localMusicLyricExts, ext) || containsString(localMusicCoverExts, ext)
+}
+
+// deleteLocalMusicIndexRowsUnder removes every row of rootKey at or below rel
+// (a directory that disappeared) and returns how many were removed.
+func deleteLocalMusicIndexRowsUnder(rootKey string, rel string) int64 {
+	if db == nil {
+		return 0
+	}
+	rel = strings.Trim(rel, "/")
+	query := db.Where("root = ?", rootKey)
+	if rel != "" && rel != "." {
+		prefix := rel + "/"
+		query = query.Where("rel_path = ? OR substr(rel_path, 1, ?) = ?", rel, utf8.RuneCountInString(prefix), prefix)
+	}
+	result := query.Delete(&LocalMusicIndex{})
+	if result.Error != nil {
+		core.Logger().Warn("delete local music index rows failed", "prefix", rel, "error", result.Error)
+		return 0
+	}
+	return result.RowsAffected
+}
+
+// registerLocalMusicWatcherRoutes exposes watcher health for the UI and probes.
+func registerLocalMusicWatcherRoutes(api *gin.RouterGroup) {
+	api.GET("/local_music/watcher", func(c *gin.Context) {
+		c.JSON(http.StatusOK, localMusicWatcherStatus())
+	})
+}
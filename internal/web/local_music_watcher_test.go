@@ -0,0 +1,233 @@
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/guohuiyuan/go-music-dl/core"
+)
+
+func withLocalMusicWatcherForTest(t *testing.T, mode string) *localMusicWatcher {
+	t.Helper()
+
+	debounce, poll, reconcile, provider := localMusicWatchDebounce, localMusicWatchPollInterval, localMusicWatchReconcileInterval, localMusicWatchModeProvider
+	localMusicWatchDebounce = 50 * time.Millisecond
+	localMusicWatchPollInterval = 50 * time.Millisecond
+	localMusicWatchReconcileInterval = time.Hour
+	localMusicWatchModeProvider = func() string { return mode }
+	t.Cleanup(func() {
+		stopLocalMusicWatcher()
+		localMusicWatchDebounce, localMusicWatchPollInterval, localMusicWatchReconcileInterval, localMusicWatchModeProvider = debounce, poll, reconcile, provider
+	})
+
+	startLocalMusicWatcher()
+	w := currentLocalMusicWatcher()
+	if w == nil {
+		t.Fatalf("watcher not started for mode %q", mode)
+	}
+	return w
+}
+
+func localMusicIndexHasRow(relPath string) bool {
+	var count int64
+	db.Model(&LocalMusicIndex{}).Where("root = ? AND rel_path = ?", "", relPath).Count(&count)
+	return count > 0
+}
+
+func waitForLocalMusicIndex(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %s", what)
+}
+
+func writeLocalMusicFileForTest(t *testing.T, path string, body string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if err := os.WriteFile(path, []byte(body), 0644); err != nil {
+		t.Fatalf("write %s: %v", path, err)
+	}
+}
+
+func TestLocalMusicWatcherAppliesFSNotifyEvents(t *testing.T) {
+	initCollectionDBForTest(t)
+	downloadDir := t.TempDir()
+	withLocalMusicDownloadDir(t, downloadDir)
+	w := withLocalMusicWatcherForTest(t, core.LocalMusicWatchFSNotify)
+	if status := w.status(); status.Active != core.LocalMusicWatchFSNotify || !status.Healthy {
+		t.Fatalf("status = %+v, want healthy fsnotify watcher", status)
+	}
+
+	song := filepath.Join(downloadDir, "Song A.mp3")
+	writeLocalMusicFileForTest(t, song, "a")
+	waitForLocalMusicIndex(t, "created file to be indexed", func() bool {
+		return localMusicIndexHasRow("Song A.mp3")
+	})
+
+	renamed := filepath.Join(downloadDir, "Song B.mp3")
+	if err := os.Rename(song, renamed); err != nil {
+		t.Fatalf("rename: %v", err)
+	}
+	waitForLocalMusicIndex(t, "rename to move the row", func() bool {
+		return !localMusicIndexHasRow("Song A.mp3") && localMusicIndexHasRow("Song B.mp3")
+	})
+
+	// A directory moved in arrives as one Create event; its files must be
+	// indexed and the new directory watched.
+	staging := t.TempDir()
+	writeLocalMusicFileForTest(t, filepath.Join(staging, "Album", "Track 1.flac"), "1")
+	if err := os.Rename(filepath.Join(staging, "Album"), filepath.Join(downloadDir, "Album")); err != nil {
+		t.Fatalf("move album in: %v", err)
+	}
+	waitForLocalMusicIndex(t, "moved-in directory to be indexed", func() bool {
+		return localMusicIndexHasRow("Album/Track 1.flac")
+	})
+	writeLocalMusicFileForTest(t, filepath.Join(downloadDir, "Album", "Track 2.flac"), "2")
+	waitForLocalMusicIndex(t, "file in new directory to be indexed", func() bool {
+		return localMusicIndexHasRow("Album/Track 2.flac")
+	})
+
+	if err := os.Remove(renamed); err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+	if err := os.RemoveAll(filepath.Join(downloadDir, "Album")); err != nil {
+		t.Fatalf("remove album: %v", err)
+	}
+	waitForLocalMusicIndex(t, "deleted files to leave the index", func() bool {
+		var count int64
+		db.Model(&LocalMusicIndex{}).Count(&count)
+		return count == 0
+	})
+
+	status := w.status()
+	if status.Added < 4 || status.Removed < 4 || status.LastEventAt == nil {
+		t.Fatalf("status counters = %+v, want >=4 added and removed", status)
+	}
+}
+
+func TestLocalMusicWatcherHiddenFilesAreIgnored(t *testing.T) {
+	initCollectionDBForTest(t)
+	downloadDir := t.TempDir()
+	withLocalMusicDownloadDir(t, downloadDir)
+	withLocalMusicWatcherForTest(t, core.LocalMusicWatchFSNotify)
+
+	writeLocalMusicFileForTest(t, filepath.Join(downloadDir, ".trash", "Gone.mp3"), "x")
+	writeLocalMusicFileForTest(t, filepath.Join(downloadDir, "Visible.mp3"), "v")
+	waitForLocalMusicIndex(t, "visible file to be indexed", func() bool {
+		return localMusicIndexHasRow("Visible.mp3")
+	})
+	if localMusicIndexHasRow(".trash/Gone.mp3") {
+		t.Fatal("file under hidden directory was indexed")
+	}
+}
+
+func TestLocalMusicWatcherPollingDetectsChanges(t *testing.T) {
+	initCollectionDBForTest(t)
+	downloadDir := t.TempDir()
+	withLocalMusicDownloadDir(t, downloadDir)
+	w := withLocalMusicWatcherForTest(t, core.LocalMusicWatchPoll)
+	if w.status().Active != core.LocalMusicWatchPoll {
+		t.Fatalf("active = %q, want poll", w.status().Active)
+	}
+
+	song := filepath.Join(downloadDir, "Sub", "Polled.m4a")
+	writeLocalMusicFileForTest(t, song, "p")
+	waitForLocalMusicIndex(t, "polled file to be indexed", func() bool {
+		return localMusicIndexHasRow("Sub/Polled.m4a")
+	})
+
+	writeLocalMusicFileForTest(t, filepath.Join(downloadDir, "Sub", "Polled.lrc"), "[00:00.00]hi")
+	waitForLocalMusicIndex(t, "lyric sidecar to update the row", func() bool {
+		var row LocalMusicIndex
+		return db.First(&row, "id = ?", encodeLocalMusicID("Sub/Polled.m4a")).Error == nil && row.HasLyric
+	})
+
+	if err := os.Remove(song); err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+	waitForLocalMusicIndex(t, "polled deletion to leave the index", func() bool {
+		return !localMusicIndexHasRow("Sub/Polled.m4a")
+	})
+}
+
+func TestLocalMusicWatcherAutoFallsBackToPolling(t *testing.T) {
+	initCollectionDBForTest(t)
+	downloadDir := t.TempDir()
+	withLocalMusicDownloadDir(t, downloadDir)
+
+	original := localMusicWatchNewFSNotify
+	localMusicWatchNewFSNotify = func() (*fsnotify.Watcher, error) {
+		return nil, errors.New("too many open files")
+	}
+	t.Cleanup(func() { localMusicWatchNewFSNotify = original })
+
+	withLocalMusicWatcherForTest(t, core.LocalMusicWatchAuto)
+
+	rec := httptest.NewRecorder()
+	newLocalMusicTestRouter().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, RoutePrefix+"/local_music/watcher", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status code = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	var status LocalMusicWatcherStatus
+	if err := json.Unmarshal(rec.Body.Bytes(), &status); err != nil {
+		t.Fatalf("decode status: %v", err)
+	}
+	if status.Mode != core.LocalMusicWatchAuto || status.Active != core.LocalMusicWatchPoll || !status.Healthy {
+		t.Fatalf("status = %+v, want healthy auto watcher on polling", status)
+	}
+	if status.FallbackReason != "too many open files" || status.DebounceMs != 50 {
+		t.Fatalf("status = %+v, want fallback reason and debounce", status)
+	}
+
+	writeLocalMusicFileForTest(t, filepath.Join(downloadDir, "Fallback.mp3"), "f")
+	waitForLocalMusicIndex(t, "fallback poller to index file", func() bool {
+		return localMusicIndexHasRow("Fallback.mp3")
+	})
+}
+
+func TestLocalMusicWatcherStatusWhenOff(t *testing.T) {
+	withLocalMusicDownloadDir(t, t.TempDir())
+	original := localMusicWatchModeProvider
+	localMusicWatchModeProvider = func() string { return core.LocalMusicWatchOff }
+	t.Cleanup(func() {
+		stopLocalMusicWatcher()
+		localMusicWatchModeProvider = original
+	})
+
+	startLocalMusicWatcher()
+	if currentLocalMusicWatcher() != nil {
+		t.Fatal("watcher started although mode is off")
+	}
+	status := localMusicWatcherStatus()
+	if status.Mode != core.LocalMusicWatchOff || status.Running || status.Healthy {
+		t.Fatalf("status = %+v, want stopped watcher in off mode", status)
+	}
+}
+
+func TestDeleteLocalMusicIndexRowsUnderMatchesWholeDirectory(t *testing.T) {
+	initCollectionDBForTest(t)
+	for _, rel := range []string{"专辑/a.mp3", "专辑/内/b.mp3", "专辑二/c.mp3", "x.mp3"} {
+		upsertLocalMusicIndexRow(&localMusicTrack{ID: encodeLocalMusicID(rel), RelPath: rel, Name: rel})
+	}
+
+	if removed := deleteLocalMusicIndexRowsUnder("", "专辑"); removed != 2 {
+		t.Fatalf("removed = %d, want 2", removed)
+	}
+	if !localMusicIndexHasRow("专辑二/c.mp3") || !localMusicIndexHasRow("x.mp3") {
+		t.Fatal("rows outside the removed directory were deleted")
+	}
+}
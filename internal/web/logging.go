@@ -0,0 +1,88 @@
+package web
+
+import (
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/guohuiyuan/go-music-dl/core"
+)
+
+const requestIDHeader = "X-Request-ID"
+
+// validRequestID keeps client supplied IDs short and printable so they are
+// safe to echo back and to write into logs.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+	for _, r := range id {
+		if r <= ' ' || r > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// quietAccessLogPath reports paths whose successful hits are only logged at
+// debug level: embedded assets and monitoring probes are frequent and rarely
+// useful when diagnosing a problem.
+func quietAccessLogPath(path string) bool {
+	switch strings.TrimPrefix(path, RoutePrefix) {
+	case "/healthz", "/metrics":
+		return true
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".css", ".js", ".png", ".ico", ".svg", ".woff2":
+		return true
+	}
+	return false
+}
+
+// requestLogger returns the logger tagged with the current request ID.
+func requestLogger(c *gin.Context) *slog.Logger {
+	return core.LoggerFromContext(c.Request.Context())
+}
+
+// requestLogMiddleware assigns every request an ID (reusing a sane incoming
+// X-Request-ID), stores it in the request context so upstream source calls and
+// download records can pick it up, and writes one access log line per request.
+func requestLogMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		id := strings.TrimSpace(c.GetHeader(requestIDHeader))
+		if !validRequestID(id) {
+			id = core.NewRequestID()
+		}
+		c.Request = c.Request.WithContext(core.WithRequestID(c.Request.Context(), id))
+		c.Header(requestIDHeader, id)
+
+		c.Next()
+
+		status := c.Writer.Status()
+		level := slog.LevelInfo
+		switch {
+		case status >= 500:
+			level = slog.LevelError
+		case status >= 400:
+			level = slog.LevelWarn
+		case quietAccessLogPath(c.Request.URL.Path):
+			level = slog.LevelDebug
+		}
+		attrs := []any{
+			"method", c.Request.Method,
+			"path", c.Request.URL.Path,
+			"route", c.FullPath(),
+			"status", status,
+			"duration_ms", time.Since(start).Milliseconds(),
+			"client_ip", c.ClientIP(),
+			"bytes", c.Writer.Size(),
+		}
+		if len(c.Errors) > 0 {
+			attrs = append(attrs, "error", c.Errors.String())
+		}
+		requestLogger(c).Log(c.Request.Context(), level, "http request", attrs...)
+	}
+}
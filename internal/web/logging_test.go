@@ -0,0 +1,57 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/guohuiyuan/go-music-dl/core"
+)
+
+func TestRequestLogMiddlewareAssignsAndPropagatesRequestID(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(requestLogMiddleware())
+	var seen string
+	r.GET(RoutePrefix+"/ping", func(c *gin.Context) {
+		seen = core.RequestIDFromContext(c.Request.Context())
+		c.String(http.StatusOK, "pong")
+	})
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, RoutePrefix+"/ping", nil))
+	generated := w.Header().Get(requestIDHeader)
+	if generated == "" || generated != seen {
+		t.Fatalf("generated ID %q, handler saw %q", generated, seen)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, RoutePrefix+"/ping", nil)
+	req.Header.Set(requestIDHeader, "client-trace-1")
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if got := w.Header().Get(requestIDHeader); got != "client-trace-1" || seen != "client-trace-1" {
+		t.Fatalf("incoming ID not propagated: header %q, handler %q", got, seen)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, RoutePrefix+"/ping", nil)
+	req.Header.Set(requestIDHeader, "bad id\nwith newline")
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if got := w.Header().Get(requestIDHeader); got == "" || got == "bad id\nwith newline" {
+		t.Fatalf("invalid incoming ID should be replaced, got %q", got)
+	}
+}
+
+func TestQuietAccessLogPath(t *testing.T) {
+	for path, want := range map[string]bool{
+		RoutePrefix + "/app.js":  true,
+		RoutePrefix + "/healthz": true,
+		RoutePrefix + "/search":  false,
+		RoutePrefix + "/lyric":   false,
+	} {
+		if got := quietAccessLogPath(path); got != want {
+			t.Fatalf("quietAccessLogPath(%q) = %v, want %v", path, got, want)
+		}
+	}
+}
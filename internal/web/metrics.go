@@ -0,0 +1,254 @@
+package web
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/guohuiyuan/go-music-dl/core"
+)
+
+var (
+	httpRequestsTotal = core.NewCounterVec(
+		"music_dl_http_requests_total",
+		"HTTP requests handled by the web server, by method, route and status.",
+		"method", "route", "status",
+	)
+	httpRequestDuration = core.NewHistogramVec(
+		"music_dl_http_request_duration_seconds",
+		"HTTP request latency by method and route.",
+		core.DefaultLatencyBuckets,
+		"method", "route",
+	)
+	autoCacheTotal = core.NewCounterVec(
+		"music_dl_auto_cache_total",
+		"Auto-cache-on-play requests by outcome (started, in_progress, busy, saved, failed).",
+		"outcome",
+	)
+	localMusicScansTotal = core.NewCounterVec(
+		"music_dl_local_music_scans_total",
+		"Full local music index syncs by outcome.",
+		"outcome",
+	)
+	localMusicScanDuration = core.NewHistogramVec(
+		"music_dl_local_music_scan_duration_seconds",
+		"Duration of full local music index syncs.",
+		[]float64{0.1, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300},
+	)
+	localMusicScanTracks = core.NewGaugeVec(
+		"music_dl_local_music_scan_tracks",
+		"Tracks found by the most recent local music scan.",
+	)
+	localMusicWatchEventsTotal = core.NewCounterVec(
+		"music_dl_local_music_watch_events_total",
+		"Index changes applied by the local music watcher, by operation (added, updated, removed).",
+		"op",
+	)
+	cacheLookupsTotal = core.NewCounterVec(
+		"music_dl_cache_lookups_total",
+		"In-memory cache lookups by cache and result (hit or miss).",
+		"cache", "result",
+	)
+	_ = core.NewGaugeFunc(
+		"music_dl_local_music_index_tracks",
+		"Rows in the local music SQLite index.",
+		func() float64 {
+			if db == nil {
+				return 0
+			}
+			var count int64
+			db.Model(&LocalMusicIndex{}).Count(&count)
+			return float64(count)
+		},
+	)
+	_ = core.NewGaugeFunc(
+		"music_dl_videogen_render_sessions",
+		"Active videogen render sessions.",
+		func() float64 {
+			sessMu.Lock()
+			defer sessMu.Unlock()
+			return float64(len(sessions))
+		},
+	)
+	_ = core.NewGaugeFunc(
+		"music_dl_auto_cache_in_flight",
+		"Auto-cache downloads currently running.",
+		func() float64 {
+			autoCacheMu.Lock()
+			defer autoCacheMu.Unlock()
+			return float64(len(autoCacheInFlight))
+		},
+	)
+)
+
+func observeCacheLookup(cache string, hit bool) {
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+	cacheLookupsTotal.Inc(cache, result)
+}
+
+// observeStreamedBytes counts audio proxied straight to the client.
+func observeStreamedBytes(c *gin.Context, source string) {
+	if size := c.Writer.Size(); size > 0 {
+		core.ObserveDownloadBytes(source, core.DownloadModeStream, int64(size))
+	}
+}
+
+// metricsMiddleware records request count and latency labelled by the route
+// template (c.FullPath), which keeps label cardinality bounded.
+func metricsMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		method := c.Request.Method
+		httpRequestsTotal.Inc(method, route, strconv.Itoa(c.Writer.Status()))
+		httpRequestDuration.Observe(time.Since(start).Seconds(), method, route)
+	}
+}
+
+type healthCheck struct {
+	Name     string `json:"name"`
+	OK       bool   `json:"ok"`
+	Required bool   `json:"required"`
+	Detail   string `json:"detail,omitempty"`
+}
+
+// category groups a failed check for callers that may not see the details
+// (paths, driver errors): database, storage or ffmpeg.
+func (check healthCheck) category() string {
+	switch check.Name {
+	case "config_db", "library_db":
+		return "database"
+	case "download_dir":
+		return "storage"
+	}
+	return check.Name
+}
+
+// readinessChecks lists what a ready instance needs. ffmpeg is reported but
+// not required: without it downloads still work, only tag embedding and
+// conversions are skipped.
+func readinessChecks() []healthCheck {
+	checks := make([]healthCheck, 0, 4)
+
+	add := func(name string, required bool, err error, detail string) {
+		check := healthCheck{Name: name, OK: err == nil, Required: required, Detail: detail}
+		if err != nil {
+			check.Detail = err.Error()
+		}
+		checks = append(checks, check)
+	}
+
+	add("config_db", true, core.PingConfigDB(), core.ConfigDBPath())
+	add("library_db", true, pingCollectionDB(), "")
+
+	ffmpegPath, ffmpegErr := core.ResolveFFmpegPath()
+	add("ffmpeg", false, ffmpegErr, ffmpegPath)
+
+	dir := localMusicDownloadDir()
+	add("download_dir", true, checkDirWritable(dir), dir)
+	return checks
+}
+
+func pingCollectionDB() error {
+	if db == nil {
+		return errors.New("database is not initialized")
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.Ping()
+}
+
+// checkDirWritable looks at the directory's permission bits instead of
+// writing to it, so frequent probes stay read-only. The directory is only
+// created when it is missing.
+func checkDirWritable(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	info, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+	if info.Mode().Perm()&0222 == 0 {
+		return fmt.Errorf("%s is read-only", dir)
+	}
+	return nil
+}
+
+func wantsReadiness(c *gin.Context) bool {
+	switch c.Query("ready") {
+	case "1", "true":
+		return true
+	}
+	return c.Query("mode") == "ready"
+}
+
+// RegisterHealthRoutes registers /healthz and /metrics. Plain /healthz stays a
+// cheap liveness probe; /healthz?ready=1 runs the readiness checks and answers
+// 503 when a required one fails. The probe needs no login, so without a
+// session it only reports the status and the category of the first failure;
+// the checks themselves include local paths.
+func RegisterHealthRoutes(api *gin.RouterGroup, opts StartOptions) {
+	api.GET("/healthz", func(c *gin.Context) {
+		if !wantsReadiness(c) {
+			c.JSON(http.StatusOK, gin.H{
+				"app":    "go-music-dl",
+				"status": "ok",
+			})
+			return
+		}
+
+		checks := readinessChecks()
+		status, code := "ok", http.StatusOK
+		category := ""
+		for _, check := range checks {
+			if check.OK {
+				continue
+			}
+			if check.Required {
+				status, code = "unavailable", http.StatusServiceUnavailable
+				category = check.category()
+				break
+			}
+			if status == "ok" {
+				status, category = "degraded", check.category()
+			}
+		}
+		if !opts.DisableAuth && !requestAuthenticated(c, core.GetWebAuthSettings) {
+			resp := gin.H{"app": "go-music-dl", "status": status}
+			if category != "" {
+				resp["error"] = category
+			}
+			c.JSON(code, resp)
+			return
+		}
+		c.JSON(code, gin.H{
+			"app":    "go-music-dl",
+			"status": status,
+			"checks": checks,
+		})
+	})
+
+	api.GET("/metrics", func(c *gin.Context) {
+		c.Header("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		c.Status(http.StatusOK)
+		_ = core.WriteMetrics(c.Writer)
+	})
+}
@@ -0,0 +1,133 @@
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func newMetricsTestRouter(opts StartOptions) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(metricsMiddleware())
+	api := r.Group(RoutePrefix)
+	RegisterHealthRoutes(api, opts)
+	api.GET("/items/:id", func(c *gin.Context) { c.String(http.StatusOK, "ok") })
+	return r
+}
+
+func TestMetricsMiddlewareLabelsByRouteTemplate(t *testing.T) {
+	r := newMetricsTestRouter(StartOptions{})
+	route := RoutePrefix + "/items/:id"
+	before := httpRequestsTotal.Value(http.MethodGet, route, "200")
+
+	for _, id := range []string{"1", "2", "3"} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, RoutePrefix+"/items/"+id, nil))
+	}
+	if got := httpRequestsTotal.Value(http.MethodGet, route, "200") - before; got != 3 {
+		t.Fatalf("requests for %s = %v, want 3", route, got)
+	}
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, RoutePrefix+"/metrics", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /metrics status = %d", w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Fatalf("unexpected content type %q", ct)
+	}
+	body := w.Body.String()
+	for _, want := range []string{
+		`music_dl_http_requests_total{method="GET",route="/music/items/:id",status="200"}`,
+		"music_dl_http_request_duration_seconds_bucket",
+		"music_dl_videogen_render_sessions",
+		"music_dl_local_music_index_tracks",
+	} {
+		if !strings.Contains(body, want) {
+			t.Fatalf("metrics output missing %q\n%s", want, body)
+		}
+	}
+}
+
+func TestHealthzLivenessAndReadiness(t *testing.T) {
+	initCollectionDBForTest(t)
+	downloadDir := filepath.Join(t.TempDir(), "downloads")
+	withLocalMusicDownloadDir(t, downloadDir)
+	r := newMetricsTestRouter(StartOptions{DisableAuth: true})
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, RoutePrefix+"/healthz", nil))
+	if w.Code != http.StatusOK || strings.Contains(w.Body.String(), "checks") {
+		t.Fatalf("liveness = %d %s", w.Code, w.Body.String())
+	}
+
+	var resp struct {
+		Status string        `json:"status"`
+		Checks []healthCheck `json:"checks"`
+	}
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, RoutePrefix+"/healthz?ready=1", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("readiness status = %d, body = %s", w.Code, w.Body.String())
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode readiness: %v", err)
+	}
+	if resp.Status != "ok" && resp.Status != "degraded" {
+		t.Fatalf("unexpected readiness status %q", resp.Status)
+	}
+	if len(resp.Checks) != 4 {
+		t.Fatalf("expected 4 checks, got %+v", resp.Checks)
+	}
+	if _, err := os.Stat(downloadDir); err != nil {
+		t.Fatalf("expected readiness to create the download dir: %v", err)
+	}
+
+	// A download "dir" that is actually a file cannot be written to.
+	blocker := filepath.Join(t.TempDir(), "not-a-dir")
+	if err := os.WriteFile(blocker, []byte("x"), 0644); err != nil {
+		t.Fatalf("write blocker: %v", err)
+	}
+	withLocalMusicDownloadDir(t, blocker)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, RoutePrefix+"/healthz?mode=ready", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503 for unwritable download dir, got %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestHealthzReadinessHidesDetailsWithoutLogin(t *testing.T) {
+	initCollectionDBForTest(t)
+	readOnly := filepath.Join(t.TempDir(), "downloads")
+	if err := os.Mkdir(readOnly, 0555); err != nil {
+		t.Fatal(err)
+	}
+	withLocalMusicDownloadDir(t, readOnly)
+	r := newMetricsTestRouter(StartOptions{})
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, RoutePrefix+"/healthz?ready=1", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503 for a read-only download dir, got %d %s", w.Code, w.Body.String())
+	}
+	var resp map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp["status"] != "unavailable" || resp["error"] != "storage" || resp["checks"] != nil {
+		t.Fatalf("unauthenticated readiness = %v", resp)
+	}
+	if strings.Contains(w.Body.String(), readOnly) {
+		t.Fatalf("readiness leaked the download path: %s", w.Body.String())
+	}
+	if entries, _ := os.ReadDir(readOnly); len(entries) != 0 {
+		t.Fatalf("readiness wrote into the download dir: %v", entries)
+	}
+}
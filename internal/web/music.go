@@ -358,7 +358,7 @@ func RegisterMusicRoutes(api, configAPI *gin.RouterGroup) {
 	api.GET("/recommend", func(c *gin.Context) {
 		sources := filterAvailableSources(c.QueryArray("sources"), core.GetRecommendSourceNames())
 		tabs, errMsg := loadPlaylistSourceTabs(sources, func(src string) ([]model.Playlist, error) {
-			fn := core.GetRecommendFunc(src)
+			fn := core.GetRecommendFuncContext(c.Request.Context(), src)
 			if fn == nil {
 				return nil, fmt.Errorf("该源不支持推荐歌单")
 			}
@@ -378,7 +378,7 @@ func RegisterMusicRoutes(api, configAPI *gin.RouterGroup) {
 	api.GET("/user_playlists", func(c *gin.Context) {
 		sources := filterAvailableSources(c.QueryArray("sources"), core.GetUserPlaylistSourceNames())
 		tabs, errMsg := loadPlaylistSourceTabs(sources, func(src string) ([]model.Playlist, error) {
-			fn := core.GetUserPlaylistsFunc(src)
+			fn := core.GetUserPlaylistsFuncContext(c.Request.Context(), src)
 			if fn == nil {
 				return nil, fmt.Errorf("该源不支持个人歌单")
 			}
@@ -413,7 +413,7 @@ func RegisterMusicRoutes(api, configAPI *gin.RouterGroup) {
 			categoryName = "全部"
 		}
 
-		fn := core.GetCategoryPlaylistsFunc(source)
+		fn := core.GetCategoryPlaylistsFuncContext(c.Request.Context(), source)
 		if source == "" || fn == nil {
 			renderIndex(c, nil, nil, "", nil, "该源不支持歌单分类", "playlist", "", "", "", false, "", nil)
 			return
@@ -460,7 +460,7 @@ func RegisterMusicRoutes(api, configAPI *gin.RouterGroup) {
 				errorMsg = "不支持该链接的解析，或无法识别来源"
 			} else {
 				parsed := false
-				parseFn := core.GetParseFunc(src)
+				parseFn := core.GetParseFuncContext(c.Request.Context(), src)
 				if parseFn != nil {
 					if song, err := parseFn(keyword); err == nil {
 						allSongs = append(allSongs, *song)
@@ -469,7 +469,7 @@ func RegisterMusicRoutes(api, configAPI *gin.RouterGroup) {
 					}
 				}
 				if !parsed {
-					parsePlaylistFn := core.GetParsePlaylistFunc(src)
+					parsePlaylistFn := core.GetParsePlaylistFuncContext(c.Request.Context(), src)
 					if parsePlaylistFn != nil {
 						if playlist, songs, err := parsePlaylistFn(keyword); err == nil {
 							if searchType == "playlist" {
@@ -488,7 +488,7 @@ func RegisterMusicRoutes(api, configAPI *gin.RouterGroup) {
 					}
 				}
 				if !parsed {
-					parseAlbumFn := core.GetParseAlbumFunc(src)
+					parseAlbumFn := core.GetParseAlbumFuncContext(c.Request.Context(), src)
 					if parseAlbumFn != nil {
 						if album, songs, err := parseAlbumFn(keyword); err == nil {
 							if searchType == "album" {
@@ -513,6 +513,11 @@ func RegisterMusicRoutes(api, configAPI *gin.RouterGroup) {
 		} else {
 			var wg sync.WaitGroup
 			var mu sync.Mutex
+			// 单个音源失败不影响其他音源的结果，只记日志方便排查。
+			logger := core.LoggerFromContext(c.Request.Context())
+			logSearchSourceError := func(source string, err error) {
+				logger.Warn("search source failed", "source", source, "type", searchType, "keyword", keyword, "error", err)
+			}
 
 			for _, src := range sources {
 				if isLocalMusicSource(src) {
@@ -522,10 +527,12 @@ func RegisterMusicRoutes(api, configAPI *gin.RouterGroup) {
 				go func(s string) {
 					defer wg.Done()
 					if searchType == "playlist" {
-						fn := core.GetPlaylistSearchFunc(s)
+						fn := core.GetPlaylistSearchFuncContext(c.Request.Context(), s)
 						if fn != nil {
 							res, err := fn(keyword)
-							if err == nil {
+							if err != nil {
+								logSearchSourceError(s, err)
+							} else {
 								for i := range res {
 									res[i].Source = s
 								}
@@ -535,10 +542,12 @@ func RegisterMusicRoutes(api, configAPI *gin.RouterGroup) {
 							}
 						}
 					} else if searchType == "album" {
-						fn := core.GetAlbumSearchFunc(s)
+						fn := core.GetAlbumSearchFuncContext(c.Request.Context(), s)
 						if fn != nil {
 							res, err := fn(keyword)
-							if err == nil {
+							if err != nil {
+								logSearchSourceError(s, err)
+							} else {
 								for i := range res {
 									res[i].Source = s
 								}
@@ -548,10 +557,12 @@ func RegisterMusicRoutes(api, configAPI *gin.RouterGroup) {
 							}
 						}
 					} else {
-						fn := core.GetSearchFunc(s)
+						fn := core.GetSearchFuncContext(c.Request.Context(), s)
 						if fn != nil {
 							res, err := fn(keyword)
-							if err == nil {
+							if err != nil {
+								logSearchSourceError(s, err)
+							} else {
 								for i := range res {
 									res[i].Source = s
 								}
@@ -597,7 +608,7 @@ func RegisterMusicRoutes(api, configAPI *gin.RouterGroup) {
 			renderIndex(c, nil, nil, "", nil, "缺少参数", "song", "", "", "", false, "", nil)
 			return
 		}
-		fn := core.GetPlaylistDetailFunc(src)
+		fn := core.GetPlaylistDetailFuncContext(c.Request.Context(), src)
 		if fn == nil {
 			renderIndex(c, nil, nil, "", nil, "该源不支持查看歌单详情", "song", "", "", "", false, "", nil)
 			return
@@ -622,7 +633,7 @@ func RegisterMusicRoutes(api, configAPI *gin.RouterGroup) {
 			renderIndex(c, nil, nil, "", nil, "缺少参数", "album", "", "", "", false, "", nil)
 			return
 		}
-		fn := core.GetAlbumDetailFunc(src)
+		fn := core.GetAlbumDetailFuncContext(c.Request.Context(), src)
 		if fn == nil {
 			renderIndex(c, nil, nil, "", nil, "该源不支持查看专辑详情", "album", "", "", "", false, "", nil)
 			return
@@ -646,7 +657,7 @@ func RegisterMusicRoutes(api, configAPI *gin.RouterGroup) {
 			return
 		}
 
-		fn := core.GetAlbumSearchFunc(src)
+		fn := core.GetAlbumSearchFuncContext(c.Request.Context(), src)
 		if fn == nil {
 			renderIndex(c, nil, nil, name, []string{src}, "该源不支持查看专辑详情", "album", "", "", "", false, "", nil)
 			return
@@ -701,7 +712,7 @@ func RegisterMusicRoutes(api, configAPI *gin.RouterGroup) {
 			}
 			urlStr = info.URL
 		} else {
-			fn := core.GetDownloadFunc(src)
+			fn := core.GetDownloadFuncContext(c.Request.Context(), src)
 			if fn == nil {
 				c.JSON(200, gin.H{"valid": false})
 				return
@@ -720,7 +731,7 @@ func RegisterMusicRoutes(api, configAPI *gin.RouterGroup) {
 		}
 
 		client := &http.Client{Timeout: 5 * time.Second}
-		resp, err := client.Do(req)
+		resp, err := client.Do(req.WithContext(c.Request.Context()))
 
 		valid := false
 		var size int64 = 0
@@ -830,9 +841,12 @@ func RegisterMusicRoutes(api, configAPI *gin.RouterGroup) {
 
 		if saveLocal {
 			// 加载 SQLite 去重集合。
-			allSongsSet, _ := core.LoadDownloadDedupSet()
+			allSongsSet, dedupErr := core.LoadDownloadDedupSet()
+			if dedupErr != nil {
+				requestLogger(c).Warn("load download dedup set failed", "error", dedupErr)
+			}
 
-			result, err := core.DownloadWithDedupCheckWithTemplate(tempSong, settings.DownloadDir, embedMeta, embedMeta, settings.DownloadFilenameTemplate, allSongsSet)
+			result, err := core.DownloadWithDedupCheckContext(c.Request.Context(), tempSong, settings.DownloadDir, embedMeta, embedMeta, settings.DownloadFilenameTemplate, allSongsSet)
 			if err != nil {
 				c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
 				return
@@ -848,6 +862,15 @@ func RegisterMusicRoutes(api, configAPI *gin.RouterGroup) {
 			if result.Warning != "" {
 				payload["warning"] = result.Warning
 			}
+			if !result.Skipped {
+				// transcode=off|also|convert 与 transcode_profile 覆盖设置里的下载转码。
+				job, err := enqueueDownloadTranscode(result.SavedPath, c.Query("transcode"), c.Query("transcode_profile"))
+				if err != nil {
+					payload["transcode_error"] = err.Error()
+				} else if job != nil {
+					payload["transcode_job"] = job.ID
+				}
+			}
 			c.JSON(200, payload)
 			return
 		}
@@ -880,7 +903,7 @@ func RegisterMusicRoutes(api, configAPI *gin.RouterGroup) {
 				c.String(502, "Soda request error")
 				return
 			}
-			resp, err := (&http.Client{}).Do(req)
+			resp, err := (&http.Client{}).Do(req.WithContext(c.Request.Context()))
 			if err != nil {
 				c.String(502, "Soda stream error")
 				return
@@ -898,10 +921,11 @@ func RegisterMusicRoutes(api, configAPI *gin.RouterGroup) {
 				setDownloadHeader(c, filename)
 			}
 			http.ServeContent(c.Writer, c.Request, filename, time.Now(), bytes.NewReader(finalData))
+			observeStreamedBytes(c, source)
 			return
 		}
 
-		dlFunc := core.GetDownloadFunc(source)
+		dlFunc := core.GetDownloadFuncContext(c.Request.Context(), source)
 		if dlFunc == nil {
 			c.String(400, "Unknown source")
 			return
@@ -938,9 +962,8 @@ func RegisterMusicRoutes(api, configAPI *gin.RouterGroup) {
 				c.Header("Content-Range", rangeFetch.ContentRange)
 			}
 			c.Status(rangeFetch.StatusCode)
-			if err := rangeFetch.WriteTo(c.Writer); err != nil {
-				return
-			}
+			_ = rangeFetch.WriteTo(c.Writer)
+			observeStreamedBytes(c, source)
 			return
 		}
 
@@ -951,7 +974,7 @@ func RegisterMusicRoutes(api, configAPI *gin.RouterGroup) {
 		}
 
 		client := &http.Client{}
-		resp, err := client.Do(req)
+		resp, err := client.Do(req.WithContext(c.Request.Context()))
 		if err != nil {
 			c.String(502, "Upstream stream error")
 			return
@@ -992,6 +1015,7 @@ func RegisterMusicRoutes(api, configAPI *gin.RouterGroup) {
 		}
 		c.Status(resp.StatusCode)
 		io.Copy(c.Writer, resp.Body)
+		observeStreamedBytes(c, source)
 	}
 	api.GET("/download", downloadHandler)
 	api.POST("/download", downloadHandler)
@@ -1010,7 +1034,7 @@ func RegisterMusicRoutes(api, configAPI *gin.RouterGroup) {
 			return
 		}
 
-		fn := core.GetLyricFunc(song.Source)
+		fn := core.GetLyricFuncContext(c.Request.Context(), song.Source)
 		if fn == nil {
 			c.String(404, "No support")
 			return
@@ -1085,7 +1109,7 @@ func RegisterMusicRoutes(api, configAPI *gin.RouterGroup) {
 			return
 		}
 
-		fn := core.GetLyricFunc(song.Source)
+		fn := core.GetLyricFuncContext(c.Request.Context(), song.Source)
 		if fn != nil {
 			lrc, _ := fn(song)
 			if lrc != "" {
@@ -1380,29 +1404,31 @@ func isSwitchSourceAllowed(source string, current string) bool {
 	return true
 }
 
-func searchSwitchSourceCandidates(source string, fn func(string) ([]model.Song, error), keyword string, name string, artist string, origDuration int) []switchCandidate {
+// searchSourceWithTimeout runs one source search, giving up after
+// switchSourceSearchTimeout.
+func searchSourceWithTimeout(fn func(string) ([]model.Song, error), query string) ([]model.Song, error) {
 	type searchResponse struct {
 		songs []model.Song
 		err   error
 	}
 
-	callSearch := func(query string) ([]model.Song, error) {
-		done := make(chan searchResponse, 1)
-		go func() {
-			res, err := fn(query)
-			done <- searchResponse{songs: res, err: err}
-		}()
-		select {
-		case res := <-done:
-			return res.songs, res.err
-		case <-time.After(switchSourceSearchTimeout):
-			return nil, fmt.Errorf("search timeout")
-		}
+	done := make(chan searchResponse, 1)
+	go func() {
+		res, err := fn(query)
+		done <- searchResponse{songs: res, err: err}
+	}()
+	select {
+	case res := <-done:
+		return res.songs, res.err
+	case <-time.After(switchSourceSearchTimeout):
+		return nil, fmt.Errorf("search timeout")
 	}
+}
 
-	res, err := callSearch(keyword)
+func searchSwitchSourceCandidates(source string, fn func(string) ([]model.Song, error), keyword string, name string, artist string, origDuration int) []switchCandidate {
+	res, err := searchSourceWithTimeout(fn, keyword)
 	if (err != nil || len(res) == 0) && artist != "" {
-		res, _ = callSearch(name)
+		res, _ = searchSourceWithTimeout(fn, name)
 	}
 	if len(res) == 0 {
 		return nil
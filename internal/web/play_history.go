@@ -0,0 +1,151 @@
+package web
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/guohuiyuan/go-music-dl/core"
+)
+
+const (
+	playHistoryMinYear = 2000
+	playHistoryLimit   = 10
+)
+
+// recordPlayEvent 可在测试中替换，避免写入进程级的配置数据库。
+var recordPlayEvent = core.RecordPlayEvent
+
+// RegisterPlayHistoryRoutes 注册播放历史：播放器上报 start / progress /
+// complete / skip 事件，以及最近播放、续播、排行和年度回顾的查询。
+// 清空历史和下载记录一样需要登录。
+func RegisterPlayHistoryRoutes(api, configAPI *gin.RouterGroup) {
+	api.POST("/history/event", requireSameOriginWrite, func(c *gin.Context) {
+		var event core.PlayEvent
+		if err := c.ShouldBindJSON(&event); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid play event"})
+			return
+		}
+		if strings.TrimSpace(event.Client) == "" {
+			event.Client = "web"
+		}
+		record, err := recordPlayEvent(event, time.Now())
+		if err != nil {
+			status := http.StatusInternalServerError
+			if errors.Is(err, core.ErrPlayHistoryBadEvent) || errors.Is(err, core.ErrPlayHistoryNoSong) {
+				status = http.StatusBadRequest
+			}
+			c.JSON(status, gin.H{"error": err.Error()})
+			return
+		}
+		if record == nil {
+			c.JSON(http.StatusOK, gin.H{"status": "disabled", "play_id": 0})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "ok", "play_id": record.ID})
+	})
+
+	api.GET("/history/recent", func(c *gin.Context) {
+		page, _ := strconv.Atoi(strings.TrimSpace(c.DefaultQuery("page", "1")))
+		pageSize, _ := strconv.Atoi(strings.TrimSpace(c.DefaultQuery("page_size", "20")))
+		if page < 1 {
+			page = 1
+		}
+		if pageSize < 1 || pageSize > 100 {
+			pageSize = 20
+		}
+		records, total, err := core.RecentPlays(page, pageSize)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		totalPages := 1
+		if total > 0 {
+			totalPages = int((total + int64(pageSize) - 1) / int64(pageSize))
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"records":     records,
+			"page":        page,
+			"page_size":   pageSize,
+			"total":       total,
+			"total_pages": totalPages,
+		})
+	})
+
+	// 带 id 和 source 时只返回这首歌的续播位置，否则列出最近没听完的歌。
+	api.GET("/history/resume", func(c *gin.Context) {
+		if id := strings.TrimSpace(c.Query("id")); id != "" {
+			position, err := core.PlayResumePosition(c.Query("source"), id)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{"position": position})
+			return
+		}
+		records, err := core.ResumablePlays(parsePlayHistoryLimit(c))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"records": records})
+	})
+
+	api.GET("/history/top", func(c *gin.Context) {
+		period := strings.ToLower(strings.TrimSpace(c.DefaultQuery("period", "month")))
+		since, ok := core.PlayHistoryPeriodStart(period, time.Now())
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "period must be day, week, month, year or all"})
+			return
+		}
+		limit := parsePlayHistoryLimit(c)
+		tracks, err := core.TopPlayedTracks(since, time.Time{}, limit)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		artists, err := core.TopPlayedArtists(since, time.Time{}, limit)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"period": period, "tracks": tracks, "artists": artists})
+	})
+
+	api.GET("/history/recap", func(c *gin.Context) {
+		year := time.Now().Year()
+		if raw := strings.TrimSpace(c.Query("year")); raw != "" {
+			parsed, err := strconv.Atoi(raw)
+			if err != nil || parsed < playHistoryMinYear || parsed > year {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid year"})
+				return
+			}
+			year = parsed
+		}
+		recap, err := core.YearlyPlayRecap(year, time.Local, parsePlayHistoryLimit(c))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, recap)
+	})
+
+	configAPI.DELETE("/history", func(c *gin.Context) {
+		if err := core.ClearPlayHistory(); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "ok"})
+	})
+}
+
+func parsePlayHistoryLimit(c *gin.Context) int {
+	limit, err := strconv.Atoi(strings.TrimSpace(c.Query("limit")))
+	if err != nil || limit <= 0 {
+		return playHistoryLimit
+	}
+	return limit
+}
@@ -0,0 +1,92 @@
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/guohuiyuan/go-music-dl/core"
+)
+
+func newPlayHistoryTestRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	group := r.Group(RoutePrefix)
+	RegisterPlayHistoryRoutes(group, group)
+	return r
+}
+
+func TestPlayHistoryEventRoute(t *testing.T) {
+	var got []core.PlayEvent
+	previous := recordPlayEvent
+	recordPlayEvent = func(event core.PlayEvent, _ time.Time) (*core.PlayHistory, error) {
+		got = append(got, event)
+		switch {
+		case event.Event == "pause":
+			return nil, core.ErrPlayHistoryBadEvent
+		case event.SongID == "off":
+			return nil, nil
+		}
+		return &core.PlayHistory{ID: 7}, nil
+	}
+	t.Cleanup(func() { recordPlayEvent = previous })
+
+	router := newPlayHistoryTestRouter()
+	post := func(body string) (int, map[string]any) {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, RoutePrefix+"/history/event", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Requested-With", "XMLHttpRequest")
+		router.ServeHTTP(rec, req)
+		var out map[string]any
+		_ = json.Unmarshal(rec.Body.Bytes(), &out)
+		return rec.Code, out
+	}
+
+	if code, out := post(`{"event":"start","id":"1","source":"qq","name":"Song","duration":200}`); code != http.StatusOK || out["play_id"] != float64(7) {
+		t.Fatalf("start = %d %v", code, out)
+	}
+	if len(got) != 1 || got[0].Client != "web" || got[0].Duration != 200 {
+		t.Fatalf("recorded event = %+v", got)
+	}
+	if code, out := post(`{"event":"start","id":"off","source":"qq","name":"Song","client":"tui"}`); code != http.StatusOK || out["status"] != "disabled" || got[1].Client != "tui" {
+		t.Fatalf("disabled = %d %v", code, out)
+	}
+	if code, _ := post(`{"event":"pause"}`); code != http.StatusBadRequest {
+		t.Fatalf("bad event = %d", code)
+	}
+	if code, _ := post(`not json`); code != http.StatusBadRequest {
+		t.Fatalf("bad json = %d", code)
+	}
+
+	// 跨站页面不能替用户写播放记录，也就碰不到 scrobble 队列。
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "http://music.test"+RoutePrefix+"/history/event", strings.NewReader(`{"event":"start","id":"1","source":"qq","name":"Song"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Requested-With", "XMLHttpRequest")
+	req.Header.Set("Origin", "https://evil.example")
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusForbidden || len(got) != 3 {
+		t.Fatalf("cross-origin event = %d, recorded %d", rec.Code, len(got))
+	}
+}
+
+func TestPlayHistoryQueryRoutesRejectBadInput(t *testing.T) {
+	router := newPlayHistoryTestRouter()
+	for _, target := range []string{
+		"/history/top?period=decade",
+		"/history/recap?year=abc",
+		"/history/recap?year=1999",
+		"/history/recap?year=3000",
+	} {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, RoutePrefix+target, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s = %d, want 400", target, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package web
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/guohuiyuan/go-music-dl/core"
+)
+
+var (
+	// playbackSessionPollInterval 检查其他进程（例如 TUI）写入的会话修改。
+	playbackSessionPollInterval = 2 * time.Second
+	// playbackSessionHeartbeat 定期发送注释行，避免反向代理断开空闲的 SSE 连接。
+	playbackSessionHeartbeat = 25 * time.Second
+
+	// 会话存放在进程级的配置数据库里，测试中替换这几个函数。
+	getPlaybackSession      = core.GetPlaybackSession
+	applyPlaybackCommand    = core.ApplyPlaybackCommand
+	playbackSessionRevision = core.PlaybackSessionRevision
+)
+
+// RegisterPlaybackSessionRoutes 注册共享播放会话：读取当前队列和进度、发送
+// 播放控制命令，以及推送会话变化的 SSE 频道。和播放历史一样不需要登录，
+// 但控制命令只接受同源请求。
+func RegisterPlaybackSessionRoutes(api *gin.RouterGroup) {
+	api.GET("/playback/session", func(c *gin.Context) {
+		session, err := getPlaybackSession(time.Now())
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, session)
+	})
+
+	api.POST("/playback/session", requireSameOriginWrite, func(c *gin.Context) {
+		var cmd core.PlaybackCommand
+		if err := c.ShouldBindJSON(&cmd); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid playback command"})
+			return
+		}
+		session, err := applyPlaybackCommand(cmd, time.Now())
+		switch {
+		case errors.Is(err, core.ErrPlaybackConflict):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error(), "session": session})
+		case errors.Is(err, core.ErrPlaybackBadCommand):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case err != nil:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusOK, session)
+		}
+	})
+
+	// 连接后先推送一次当前会话，之后每次变化推送一条 session 事件。
+	api.GET("/playback/session/events", func(c *gin.Context) {
+		updates, cancel := core.SubscribePlaybackSession()
+		defer cancel()
+		session, err := getPlaybackSession(time.Now())
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.Header("Content-Type", "text/event-stream")
+		c.Header("Cache-Control", "no-cache")
+		c.Header("Connection", "keep-alive")
+		c.Header("X-Accel-Buffering", "no")
+		c.Status(http.StatusOK)
+
+		revision := session.Revision
+		send := func(session core.PlaybackSession) {
+			revision = session.Revision
+			c.SSEvent("session", session)
+			c.Writer.Flush()
+		}
+		send(session)
+
+		poll := time.NewTicker(playbackSessionPollInterval)
+		defer poll.Stop()
+		heartbeat := time.NewTicker(playbackSessionHeartbeat)
+		defer heartbeat.Stop()
+		for {
+			select {
+			case <-c.Request.Context().Done():
+				return
+			case session := <-updates:
+				if session.Revision > revision {
+					send(session)
+				}
+			case <-poll.C:
+				latest, err := playbackSessionRevision()
+				if err != nil || latest == revision {
+					continue
+				}
+				if session, err := getPlaybackSession(time.Now()); err == nil {
+					send(session)
+				}
+			case <-heartbeat.C:
+				fmt.Fprint(c.Writer, ": ping\n\n")
+				c.Writer.Flush()
+			}
+		}
+	})
+}
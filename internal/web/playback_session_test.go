@@ -0,0 +1,124 @@
+package web
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/guohuiyuan/go-music-dl/core"
+)
+
+func newPlaybackSessionTestRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	RegisterPlaybackSessionRoutes(r.Group(RoutePrefix))
+	return r
+}
+
+func TestPlaybackSessionCommandRoute(t *testing.T) {
+	previous := applyPlaybackCommand
+	applyPlaybackCommand = func(cmd core.PlaybackCommand, _ time.Time) (core.PlaybackSession, error) {
+		switch cmd.Action {
+		case "rewind":
+			return core.PlaybackSession{}, core.ErrPlaybackBadCommand
+		case core.PlaybackActionPause:
+			return core.PlaybackSession{Revision: 9}, core.ErrPlaybackConflict
+		}
+		return core.PlaybackSession{Revision: 3, Device: cmd.Device}, nil
+	}
+	t.Cleanup(func() { applyPlaybackCommand = previous })
+
+	router := newPlaybackSessionTestRouter()
+	origin := "http://music.test"
+	post := func(body string) (int, map[string]any) {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "http://music.test"+RoutePrefix+"/playback/session", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Requested-With", "XMLHttpRequest")
+		req.Header.Set("Origin", origin)
+		router.ServeHTTP(rec, req)
+		var out map[string]any
+		_ = json.Unmarshal(rec.Body.Bytes(), &out)
+		return rec.Code, out
+	}
+
+	if code, out := post(`{"action":"play","device":"phone"}`); code != http.StatusOK || out["device"] != "phone" || out["revision"] != float64(3) {
+		t.Fatalf("play = %d %v", code, out)
+	}
+	if code, out := post(`{"action":"pause","base_revision":2}`); code != http.StatusConflict || out["session"].(map[string]any)["revision"] != float64(9) {
+		t.Fatalf("conflict = %d %v", code, out)
+	}
+	if code, _ := post(`{"action":"rewind"}`); code != http.StatusBadRequest {
+		t.Fatalf("bad action = %d", code)
+	}
+	if code, _ := post(`not json`); code != http.StatusBadRequest {
+		t.Fatalf("bad json = %d", code)
+	}
+
+	origin = "https://evil.example"
+	if code, _ := post(`{"action":"clear"}`); code != http.StatusForbidden {
+		t.Fatalf("cross origin = %d", code)
+	}
+}
+
+func TestPlaybackSessionEventsStreamChanges(t *testing.T) {
+	var revision atomic.Int64
+	revision.Store(1)
+	previousGet, previousRevision, previousPoll := getPlaybackSession, playbackSessionRevision, playbackSessionPollInterval
+	getPlaybackSession = func(time.Time) (core.PlaybackSession, error) {
+		return core.PlaybackSession{Revision: revision.Load(), Device: "tui"}, nil
+	}
+	playbackSessionRevision = func() (int64, error) { return revision.Load(), nil }
+	playbackSessionPollInterval = 10 * time.Millisecond
+	t.Cleanup(func() {
+		getPlaybackSession, playbackSessionRevision, playbackSessionPollInterval = previousGet, previousRevision, previousPoll
+	})
+
+	server := httptest.NewServer(newPlaybackSessionTestRouter())
+	defer server.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+RoutePrefix+"/playback/session/events", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if got := resp.Header.Get("Content-Type"); !strings.HasPrefix(got, "text/event-stream") {
+		t.Fatalf("content type = %q", got)
+	}
+
+	reader := bufio.NewReader(resp.Body)
+	nextSession := func() core.PlaybackSession {
+		t.Helper()
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				t.Fatalf("read stream: %v", err)
+			}
+			if data, ok := strings.CutPrefix(strings.TrimSpace(line), "data:"); ok {
+				var session core.PlaybackSession
+				if err := json.Unmarshal([]byte(data), &session); err != nil {
+					t.Fatalf("decode %q: %v", data, err)
+				}
+				return session
+			}
+		}
+	}
+
+	if first := nextSession(); first.Revision != 1 || first.Device != "tui" {
+		t.Fatalf("initial event = %+v", first)
+	}
+	// 其他进程写入新修订号后，轮询会推送新的会话。
+	revision.Store(2)
+	if second := nextSession(); second.Revision != 2 {
+		t.Fatalf("polled event = %+v", second)
+	}
+}
@@ -0,0 +1,241 @@
+package web
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/guohuiyuan/go-music-dl/core"
+)
+
+var (
+	// scrobbleRetryInterval 是后台重试离线队列的间隔，播放事件本身会立即提交。
+	scrobbleRetryInterval = 5 * time.Minute
+
+	scrobbleLoopMu   sync.Mutex
+	scrobbleLoopStop chan struct{}
+	scrobbleLoopDone chan struct{}
+)
+
+// scrobblerView is what the settings panel sees. The token and API secret are
+// write-only, like webhook tokens; the queue fields come from the retry queue.
+type scrobblerView struct {
+	core.ScrobbleTarget
+	Token        string `json:"token,omitempty"`
+	APISecret    string `json:"api_secret,omitempty"`
+	HasToken     bool   `json:"has_token"`
+	HasAPISecret bool   `json:"has_api_secret"`
+	Queued       int    `json:"queued"`
+	LastError    string `json:"last_error,omitempty"`
+}
+
+func newScrobblerView(target core.ScrobbleTarget, stat core.ScrobbleQueueStat) scrobblerView {
+	return scrobblerView{
+		ScrobbleTarget: target,
+		HasToken:       target.Token != "",
+		HasAPISecret:   target.APISecret != "",
+		Queued:         stat.Pending,
+		LastError:      stat.LastError,
+	}
+}
+
+type scrobblerRequest struct {
+	Name      string `json:"name"`
+	Kind      string `json:"kind"`
+	URL       string `json:"url"`
+	Token     string `json:"token"`
+	APIKey    string `json:"api_key"`
+	APISecret string `json:"api_secret"`
+	Enabled   *bool  `json:"enabled"`
+}
+
+// target keeps the stored token and secret when an update leaves them empty.
+// Unlike webhooks there is no clear flag: every scrobbler needs a token.
+func (req scrobblerRequest) target(existing *core.ScrobbleTarget) core.ScrobbleTarget {
+	target := core.ScrobbleTarget{
+		Name:      req.Name,
+		Kind:      req.Kind,
+		URL:       req.URL,
+		Token:     req.Token,
+		APIKey:    req.APIKey,
+		APISecret: req.APISecret,
+		Enabled:   true,
+	}
+	if req.Enabled != nil {
+		target.Enabled = *req.Enabled
+	}
+	if existing != nil {
+		target.ID = existing.ID
+		if strings.TrimSpace(req.Token) == "" {
+			target.Token = existing.Token
+		}
+		if strings.TrimSpace(req.APISecret) == "" {
+			target.APISecret = existing.APISecret
+		}
+	}
+	return target
+}
+
+func scrobblerErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, core.ErrScrobbleTargetNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, core.ErrInvalidScrobbleTarget):
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+func scrobblerIDParam(c *gin.Context) (uint, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil || id == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid scrobbler id"})
+		return 0, false
+	}
+	return uint(id), true
+}
+
+// RegisterScrobbleRoutes registers ListenBrainz / Last.fm account management
+// on the protected config API. Plays reach the scrobblers through the play
+// history events, so there is no public endpoint here.
+func RegisterScrobbleRoutes(configAPI *gin.RouterGroup) {
+	configAPI.GET("/scrobblers", func(c *gin.Context) {
+		targets, err := core.ListScrobbleTargets()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		stats, err := core.ScrobbleQueueStats()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		views := make([]scrobblerView, 0, len(targets))
+		for _, target := range targets {
+			views = append(views, newScrobblerView(target, stats[target.ID]))
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"scrobblers": views,
+			"kinds":      []string{core.ScrobbleKindListenBrainz, core.ScrobbleKindLastFM},
+		})
+	})
+
+	configAPI.POST("/scrobblers", func(c *gin.Context) {
+		var req scrobblerRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid scrobbler payload"})
+			return
+		}
+		target, err := core.SaveScrobbleTarget(req.target(nil))
+		if err != nil {
+			c.JSON(scrobblerErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, newScrobblerView(*target, core.ScrobbleQueueStat{}))
+	})
+
+	configAPI.PUT("/scrobblers/:id", func(c *gin.Context) {
+		id, ok := scrobblerIDParam(c)
+		if !ok {
+			return
+		}
+		var req scrobblerRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid scrobbler payload"})
+			return
+		}
+		existing, err := core.GetScrobbleTarget(id)
+		if err != nil {
+			c.JSON(scrobblerErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		target, err := core.SaveScrobbleTarget(req.target(existing))
+		if err != nil {
+			c.JSON(scrobblerErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, newScrobblerView(*target, core.ScrobbleQueueStat{}))
+	})
+
+	configAPI.DELETE("/scrobblers/:id", func(c *gin.Context) {
+		id, ok := scrobblerIDParam(c)
+		if !ok {
+			return
+		}
+		if err := core.DeleteScrobbleTarget(id); err != nil {
+			c.JSON(scrobblerErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "ok"})
+	})
+
+	configAPI.POST("/scrobblers/:id/test", func(c *gin.Context) {
+		id, ok := scrobblerIDParam(c)
+		if !ok {
+			return
+		}
+		if err := core.SendScrobbleTest(c.Request.Context(), id); err != nil {
+			status := scrobblerErrorStatus(err)
+			if status == http.StatusInternalServerError {
+				status = http.StatusBadGateway
+			}
+			c.JSON(status, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "ok"})
+	})
+
+	// 手动重试：忽略退避时间，立即提交队列里的全部记录。
+	configAPI.POST("/scrobblers/flush", func(c *gin.Context) {
+		result, err := core.FlushScrobbleQueue(c.Request.Context(), time.Now().Add(365*24*time.Hour))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, result)
+	})
+}
+
+// startScrobbleLoop retries queued scrobbles while the web server is up, so
+// listens recorded offline go out once the service is reachable again.
+func startScrobbleLoop() {
+	scrobbleLoopMu.Lock()
+	defer scrobbleLoopMu.Unlock()
+	if scrobbleLoopStop != nil {
+		return
+	}
+	stop, done := make(chan struct{}), make(chan struct{})
+	scrobbleLoopStop, scrobbleLoopDone = stop, done
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(scrobbleRetryInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				if _, err := core.FlushScrobbleQueue(context.Background(), time.Now()); err != nil {
+					core.Logger().Warn("flush scrobble queue failed", "error", err)
+				}
+			}
+		}
+	}()
+}
+
+func stopScrobbleLoop() {
+	scrobbleLoopMu.Lock()
+	defer scrobbleLoopMu.Unlock()
+	if scrobbleLoopStop == nil {
+		return
+	}
+	close(scrobbleLoopStop)
+	<-scrobbleLoopDone
+	scrobbleLoopStop, scrobbleLoopDone = nil, nil
+	core.WaitScrobbles(5 * time.Second)
+}
@@ -0,0 +1,64 @@
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/guohuiyuan/go-music-dl/core"
+)
+
+func TestScrobblerViewHidesSecrets(t *testing.T) {
+	target := core.ScrobbleTarget{ID: 1, Kind: core.ScrobbleKindLastFM, APIKey: "key", APISecret: "shh", Token: "session"}
+	data, err := json.Marshal(newScrobblerView(target, core.ScrobbleQueueStat{Pending: 2}))
+	if err != nil {
+		t.Fatalf("marshal view: %v", err)
+	}
+	body := string(data)
+	if strings.Contains(body, "shh") || strings.Contains(body, "session") ||
+		!strings.Contains(body, `"has_token":true`) || !strings.Contains(body, `"has_api_secret":true`) || !strings.Contains(body, `"queued":2`) {
+		t.Fatalf("unexpected view JSON %s", data)
+	}
+}
+
+func TestScrobblerRequestKeepsSecrets(t *testing.T) {
+	existing := &core.ScrobbleTarget{ID: 4, Token: "stored", APISecret: "secret"}
+	disabled := false
+
+	target := scrobblerRequest{APIKey: "key", Enabled: &disabled}.target(existing)
+	if target.ID != 4 || target.Token != "stored" || target.APISecret != "secret" || target.Enabled {
+		t.Fatalf("update without secrets = %+v", target)
+	}
+	if target := (scrobblerRequest{Token: "new"}).target(existing); target.Token != "new" || target.APISecret != "secret" {
+		t.Fatalf("token not replaced: %+v", target)
+	}
+	if target := (scrobblerRequest{}).target(nil); !target.Enabled {
+		t.Fatal("new scrobblers should be enabled by default")
+	}
+}
+
+func TestScrobbleRoutesRejectBadInput(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	RegisterScrobbleRoutes(r.Group(RoutePrefix))
+
+	for _, tc := range []struct {
+		method, path, body string
+	}{
+		{http.MethodPost, "/scrobblers", `not json`},
+		{http.MethodPut, "/scrobblers/abc", `{}`},
+		{http.MethodDelete, "/scrobblers/0", ""},
+		{http.MethodPost, "/scrobblers/x/test", ""},
+	} {
+		req := httptest.NewRequest(tc.method, RoutePrefix+tc.path, strings.NewReader(tc.body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("%s %s = %d, want 400 (%s)", tc.method, tc.path, w.Code, w.Body.String())
+		}
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"reflect"
 	"strconv"
 	"strings"
 	"time"
@@ -45,6 +46,9 @@ func defaultSourcesForSearchType(searchType string) []string {
 	case "album":
 		return core.GetAlbumSourceNames()
 	default:
+		if sources := core.GetWebSettings().SearchSources; len(sources) > 0 {
+			return sources
+		}
 		return core.GetDefaultSourceNames()
 	}
 }
@@ -234,6 +238,9 @@ func renderIndex(c *gin.Context, songs []model.Song, playlists []model.Playlist,
 	playlistCategorySources, _ := c.Get("PlaylistCategorySources")
 	playlistCategoryCurrent, _ := c.Get("PlaylistCategoryCurrent")
 	playlistSourceTabs, _ := c.Get("PlaylistSourceTabs")
+	collectionFolders, _ := c.Get("CollectionFolders")
+	collectionTags, _ := c.Get("CollectionTags")
+	collectionFilter, _ := c.Get("CollectionFilter")
 
 	settings := core.GetWebSettings()
 	defaultPageSize := settings.WebPageSize
@@ -328,6 +335,9 @@ func renderIndex(c *gin.Context, songs []model.Song, playlists []model.Playlist,
 		"PlaylistCategoryCurrent": playlistCategoryCurrent,
 		"PlaylistSourceTabs":      playlistSourceTabs,
 		"UserPlaylistSupported":   userPlaylistSupported,
+		"CollectionFolders":       collectionFolders,
+		"CollectionTags":          collectionTags,
+		"CollectionFilter":        collectionFilter,
 	})
 }
 
@@ -353,7 +363,7 @@ func StartWithOptions(port string, opts StartOptions) {
 	if !opts.DisableAuth {
 		settings, err := core.GetWebAuthSettings()
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Failed to read web auth settings: %v\n", err)
+			core.Logger().Error("read web auth settings failed", "error", err)
 		} else if token, tokenErr := prepareSetupToken(settings); tokenErr == nil && token != "" {
 			fmt.Printf("Web setup token: %s\nOpen %s/setup and keep this startup terminal private until setup is complete.\n", token, RoutePrefix)
 		}
@@ -361,16 +371,25 @@ func StartWithOptions(port string, opts StartOptions) {
 	InitDB()
 	defer CloseDB()
 	syncLocalMusicIndexAsync()
+	startLocalMusicWatcher()
+	defer stopLocalMusicWatcher()
+	startCollectionSyncLoop()
+	defer stopCollectionSyncLoop()
+	startScrobbleLoop()
+	defer stopScrobbleLoop()
 
 	gin.SetMode(gin.ReleaseMode)
-	r := gin.Default()
+	r := gin.New()
+	r.Use(requestLogMiddleware(), gin.Recovery())
 	r.Use(corsMiddleware())
+	r.Use(metricsMiddleware())
 
 	tmpl := template.Must(template.New("").Funcs(template.FuncMap{
 		"artistTokens":       splitArtistTokens,
 		"albumID":            songAlbumID,
 		"playlistDetailURL":  playlistDetailURL,
 		"playlistExtraValue": playlistExtraValue,
+		"collectionTags":     splitCollectionTags,
 		"tojson": func(v interface{}) string {
 			if v == nil {
 				return ""
@@ -396,12 +415,7 @@ func StartWithOptions(port string, opts StartOptions) {
 
 	api := r.Group(RoutePrefix)
 
-	api.GET("/healthz", func(c *gin.Context) {
-		c.JSON(http.StatusOK, gin.H{
-			"app":    "go-music-dl",
-			"status": "ok",
-		})
-	})
+	RegisterHealthRoutes(api, opts)
 
 	// Static assets embedded at build time.
 	api.GET("/icon.png", func(c *gin.Context) { c.FileFromFS("templates/static/images/icon.png", http.FS(templateFS)) })
@@ -434,8 +448,14 @@ func StartWithOptions(port string, opts StartOptions) {
 	api.GET("/settings", func(c *gin.Context) {
 		c.JSON(200, core.GetWebSettings())
 	})
+	configAPI.GET("/settings/overrides", func(c *gin.Context) {
+		c.JSON(200, gin.H{"file": core.ConfigFilePath(), "fields": core.WebSettingsOverrides()})
+	})
 	configAPI.POST("/settings", func(c *gin.Context) {
-		var req core.WebSettings
+		// Bind onto the stored settings so fields an older client does not
+		// send survive its save.
+		previous := core.GetWebSettings()
+		req := previous
 		if err := c.ShouldBindJSON(&req); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid settings payload"})
 			return
@@ -444,11 +464,23 @@ func StartWithOptions(port string, opts StartOptions) {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
+		if current := core.GetWebSettings(); current.DownloadDir != previous.DownloadDir ||
+			current.LocalMusicWatchMode != previous.LocalMusicWatchMode ||
+			!reflect.DeepEqual(current.LibraryRoots, previous.LibraryRoots) ||
+			current.LocalMusicSearchPinyin != previous.LocalMusicSearchPinyin ||
+			current.LocalMusicSearchFoldVariants != previous.LocalMusicSearchFoldVariants {
+			refreshLocalMusicAfterSettingsChange()
+		}
 		c.JSON(200, core.GetWebSettings())
 	})
 
 	RegisterMusicRoutes(api, configAPI)
 	RegisterQRLoginRoutes(configAPI)
+	RegisterConfigRoutes(configAPI)
+	RegisterWebhookRoutes(api, configAPI)
+	RegisterPlayHistoryRoutes(api, configAPI)
+	RegisterScrobbleRoutes(configAPI)
+	RegisterPlaybackSessionRoutes(api)
 	RegisterCollectionRoutes(api)
 	RegisterLocalMusicRoutes(api)
 	RegisterVideogenRoutes(api, videoDir)
@@ -458,10 +490,10 @@ func StartWithOptions(port string, opts StartOptions) {
 	listener, err := net.Listen("tcp", listenAddr)
 	if err != nil {
 		if strings.Contains(strings.ToLower(err.Error()), "address already in use") {
-			fmt.Fprintf(os.Stderr, "Failed to start web server: port %s is already in use. Please use --port to specify another port, e.g. music-dl web --port 8081\n", port)
+			core.Logger().Error("failed to start web server: port is already in use, use --port to pick another one, e.g. music-dl web --port 8081", "port", port)
 			return
 		}
-		fmt.Fprintf(os.Stderr, "Failed to start web server on %s: %v\n", listenAddr, err)
+		core.Logger().Error("failed to start web server", "addr", listenAddr, "error", err)
 		return
 	}
 
@@ -475,7 +507,7 @@ func StartWithOptions(port string, opts StartOptions) {
 		go func() { time.Sleep(500 * time.Millisecond); core.OpenBrowser(urlStr) }()
 	}
 	if err := http.Serve(listener, r); err != nil && !errors.Is(err, http.ErrServerClosed) {
-		fmt.Fprintf(os.Stderr, "Web server stopped with error: %v\n", err)
+		core.Logger().Error("web server stopped", "error", err)
 	}
 }
 
@@ -42,9 +42,22 @@ let webSettings = {
   disableFloatingLyrics: false,
   webPageSize: DEFAULT_WEB_PAGE_SIZE,
   cliPageSize: DEFAULT_CLI_PAGE_SIZE,
+  searchSources: [],
   autoCheckUpdate: true,
   autoSwitchInvalidSources: true,
   autoCacheOnPlay: false,
+  localMusicWatchMode: "auto",
+  localMusicSearchPinyin: true,
+  localMusicSearchFoldVariants: true,
+  libraryRoots: [],
+  transcodeProfiles: [],
+  downloadTranscodeMode: "",
+  downloadTranscodeProfile: "mp3-320",
+  disablePlayHistory: false,
+  playHistoryRetentionDays: 0,
+  replayGainMode: "",
+  crossfadeSeconds: 0,
+  gaplessPlayback: false,
   updateRepoUrl: DEFAULT_UPDATE_REPO_URL,
   githubProxyEnabled: false,
   githubProxyUrl: DEFAULT_GITHUB_PROXY_URL,
@@ -54,6 +67,12 @@ let webSettings = {
   vgExportVideo: false,
 };
 
+const LOCAL_MUSIC_WATCH_MODES = ["auto", "fsnotify", "poll", "off"];
+const DOWNLOAD_TRANSCODE_MODES = ["", "also", "convert"];
+const TRANSCODE_FORMATS = ["mp3", "m4a", "opus", "ogg", "flac", "wav"];
+const REPLAY_GAIN_MODES = ["", "track"];
+const MAX_CROSSFADE_SECONDS = 12;
+
 function normalizeWebSettings(raw) {
   const next = {
     embedDownload: true,
@@ -63,9 +82,22 @@ function normalizeWebSettings(raw) {
     disableFloatingLyrics: false,
     webPageSize: DEFAULT_WEB_PAGE_SIZE,
     cliPageSize: DEFAULT_CLI_PAGE_SIZE,
+    searchSources: [],
     autoCheckUpdate: true,
     autoSwitchInvalidSources: true,
     autoCacheOnPlay: false,
+    localMusicWatchMode: "auto",
+    localMusicSearchPinyin: true,
+    localMusicSearchFoldVariants: true,
+    libraryRoots: [],
+    transcodeProfiles: [],
+    downloadTranscodeMode: "",
+    downloadTranscodeProfile: "mp3-320",
+    disablePlayHistory: false,
+    playHistoryRetentionDays: 0,
+    replayGainMode: "",
+    crossfadeSeconds: 0,
+    gaplessPlayback: false,
     updateRepoUrl: DEFAULT_UPDATE_REPO_URL,
     githubProxyEnabled: false,
     githubProxyUrl: DEFAULT_GITHUB_PROXY_URL,
@@ -100,6 +132,11 @@ function normalizeWebSettings(raw) {
   if (Number.isInteger(raw.cliPageSize) && raw.cliPageSize > 0) {
     next.cliPageSize = Math.min(raw.cliPageSize, 200);
   }
+  if (Array.isArray(raw.searchSources)) {
+    next.searchSources = raw.searchSources.filter(
+      (source) => typeof source === "string" && source.trim() !== "",
+    );
+  }
   if (typeof raw.autoCheckUpdate === "boolean") {
     next.autoCheckUpdate = raw.autoCheckUpdate;
   }
@@ -109,6 +146,65 @@ function normalizeWebSettings(raw) {
   if (typeof raw.autoCacheOnPlay === "boolean") {
     next.autoCacheOnPlay = raw.autoCacheOnPlay;
   }
+  if (LOCAL_MUSIC_WATCH_MODES.includes(raw.localMusicWatchMode)) {
+    next.localMusicWatchMode = raw.localMusicWatchMode;
+  }
+  if (typeof raw.localMusicSearchPinyin === "boolean") {
+    next.localMusicSearchPinyin = raw.localMusicSearchPinyin;
+  }
+  if (typeof raw.disablePlayHistory === "boolean") {
+    next.disablePlayHistory = raw.disablePlayHistory;
+  }
+  if (
+    Number.isInteger(raw.playHistoryRetentionDays) &&
+    raw.playHistoryRetentionDays > 0
+  ) {
+    next.playHistoryRetentionDays = raw.playHistoryRetentionDays;
+  }
+  if (REPLAY_GAIN_MODES.includes(raw.replayGainMode)) {
+    next.replayGainMode = raw.replayGainMode;
+  }
+  if (Number.isInteger(raw.crossfadeSeconds) && raw.crossfadeSeconds > 0) {
+    next.crossfadeSeconds = Math.min(raw.crossfadeSeconds, MAX_CROSSFADE_SECONDS);
+  }
+  if (typeof raw.gaplessPlayback === "boolean") {
+    next.gaplessPlayback = raw.gaplessPlayback;
+  }
+  if (typeof raw.localMusicSearchFoldVariants === "boolean") {
+    next.localMusicSearchFoldVariants = raw.localMusicSearchFoldVariants;
+  }
+  if (Array.isArray(raw.libraryRoots)) {
+    next.libraryRoots = raw.libraryRoots
+      .filter((root) => root && typeof root.path === "string" && root.path.trim() !== "")
+      .map((root) => ({
+        key: typeof root.key === "string" ? root.key : "",
+        label: typeof root.label === "string" ? root.label.trim() : "",
+        path: root.path.trim(),
+        readOnly: !!root.readOnly,
+        include: Array.isArray(root.include) ? root.include : [],
+        exclude: Array.isArray(root.exclude) ? root.exclude : [],
+      }));
+  }
+  if (Array.isArray(raw.transcodeProfiles)) {
+    next.transcodeProfiles = raw.transcodeProfiles
+      .filter((profile) => profile && TRANSCODE_FORMATS.includes(profile.format))
+      .map((profile) => ({
+        name: typeof profile.name === "string" ? profile.name.trim() : "",
+        format: profile.format,
+        bitrate: Number.isInteger(profile.bitrate) && profile.bitrate > 0 ? profile.bitrate : 0,
+        sampleRate:
+          Number.isInteger(profile.sampleRate) && profile.sampleRate > 0 ? profile.sampleRate : 0,
+      }));
+  }
+  if (DOWNLOAD_TRANSCODE_MODES.includes(raw.downloadTranscodeMode)) {
+    next.downloadTranscodeMode = raw.downloadTranscodeMode;
+  }
+  if (
+    typeof raw.downloadTranscodeProfile === "string" &&
+    raw.downloadTranscodeProfile.trim() !== ""
+  ) {
+    next.downloadTranscodeProfile = raw.downloadTranscodeProfile.trim();
+  }
   if (
     typeof raw.updateRepoUrl === "string" &&
     raw.updateRepoUrl.trim() !== ""
@@ -285,6 +381,10 @@ function applyWebSettings(settings) {
     );
   }
 
+  document.querySelectorAll(".setting-search-source").forEach((input) => {
+    input.checked = webSettings.searchSources.includes(input.value);
+  });
+
   const autoSwitchInvalidSourcesToggle = document.getElementById(
     "setting-auto-switch-invalid-sources",
   );
@@ -300,6 +400,85 @@ function applyWebSettings(settings) {
     autoCacheOnPlayToggle.checked = webSettings.autoCacheOnPlay;
   }
 
+  const playHistoryToggle = document.getElementById("setting-play-history");
+  if (playHistoryToggle) {
+    playHistoryToggle.checked = !webSettings.disablePlayHistory;
+  }
+
+  const playHistoryRetentionSelect = document.getElementById(
+    "setting-play-history-retention",
+  );
+  if (playHistoryRetentionSelect) {
+    const days = String(webSettings.playHistoryRetentionDays);
+    // 配置文件或环境变量可能给出下拉框里没有的天数。
+    if (
+      !Array.from(playHistoryRetentionSelect.options).some(
+        (option) => option.value === days,
+      )
+    ) {
+      playHistoryRetentionSelect.add(new Option(`${days} 天`, days));
+    }
+    playHistoryRetentionSelect.value = days;
+  }
+
+  const replayGainModeSelect = document.getElementById(
+    "setting-replay-gain-mode",
+  );
+  if (replayGainModeSelect) {
+    replayGainModeSelect.value = webSettings.replayGainMode;
+  }
+
+  const crossfadeSelect = document.getElementById("setting-crossfade-seconds");
+  if (crossfadeSelect) {
+    const seconds = String(webSettings.crossfadeSeconds);
+    if (
+      !Array.from(crossfadeSelect.options).some(
+        (option) => option.value === seconds,
+      )
+    ) {
+      crossfadeSelect.add(new Option(`${seconds} 秒`, seconds));
+    }
+    crossfadeSelect.value = seconds;
+  }
+
+  const gaplessToggle = document.getElementById("setting-gapless-playback");
+  if (gaplessToggle) {
+    gaplessToggle.checked = webSettings.gaplessPlayback;
+  }
+
+  const localMusicWatchModeSelect = document.getElementById(
+    "setting-local-music-watch-mode",
+  );
+  if (localMusicWatchModeSelect) {
+    localMusicWatchModeSelect.value = webSettings.localMusicWatchMode;
+  }
+
+  const localMusicSearchPinyinToggle = document.getElementById(
+    "setting-local-music-search-pinyin",
+  );
+  if (localMusicSearchPinyinToggle) {
+    localMusicSearchPinyinToggle.checked = webSettings.localMusicSearchPinyin;
+  }
+
+  const localMusicSearchFoldVariantsToggle = document.getElementById(
+    "setting-local-music-search-fold-variants",
+  );
+  if (localMusicSearchFoldVariantsToggle) {
+    localMusicSearchFoldVariantsToggle.checked =
+      webSettings.localMusicSearchFoldVariants;
+  }
+
+  renderLibraryRootsEditor(webSettings.libraryRoots);
+
+  const downloadTranscodeModeSelect = document.getElementById(
+    "setting-download-transcode-mode",
+  );
+  if (downloadTranscodeModeSelect) {
+    downloadTranscodeModeSelect.value = webSettings.downloadTranscodeMode;
+  }
+  renderTranscodeProfilesEditor(webSettings.transcodeProfiles);
+  loadDownloadTranscodeProfileOptions();
+
   const vgChangeCoverToggle = document.getElementById(
     "setting-vg-change-cover",
   );
@@ -330,6 +509,7 @@ function applyWebSettings(settings) {
 
   applyVideoGenFeatureVisibility();
   syncFloatingLyricsSetting();
+  syncPlaybackEffectsSetting();
   refreshDownloadLinks();
   if (webSettings.autoSwitchInvalidSources) {
     if (!wasAutoSwitchInvalidSourcesEnabled) {
@@ -1112,6 +1292,7 @@ function initializePageContent(root = document) {
   syncAllPlayButtons();
   syncMediaSession();
   initializeLocalMusicPage(root);
+  initializeCollectionSongList(root);
   scheduleBatchLocalMusicMatch();
 }
 
@@ -1870,9 +2051,9 @@ function getLocalMusicPageSize() {
 
 let queuedLocalMusicPageLoad = null;
 
-function buildLocalMusicPageURL(params) {
+function buildLocalMusicPageURL(params, endpoint = "/local_music") {
   const apiRoot = String(window.API_ROOT || "").replace(/\/+$/, "");
-  const url = new URL(`${apiRoot}/local_music`, window.location.origin);
+  const url = new URL(`${apiRoot}${endpoint}`, window.location.origin);
   url.search = params.toString();
   return url.toString();
 }
@@ -1883,8 +2064,8 @@ function shouldRetryLocalMusicPageRequest(error) {
   return error.name === "TypeError" || /failed to fetch|network/i.test(message);
 }
 
-async function fetchLocalMusicPagePayload(params) {
-  const requestURL = buildLocalMusicPageURL(params);
+async function fetchLocalMusicPagePayload(params, endpoint) {
+  const requestURL = buildLocalMusicPageURL(params, endpoint);
   let lastError = null;
 
   for (let attempt = 0; attempt < 2; attempt++) {
@@ -1994,6 +2175,7 @@ function renderLocalMusicPageCard(track) {
                 <div class="artist-line">${renderArtistLineHTML(song)}</div>
                 <div class="tags">
                     <span class="tag tag-local">本地</span>
+                    ${track?.root ? `<span class="tag tag-library-root" title="${escapeHTML(track.read_only ? "只读曲库" : "曲库目录")}">${escapeHTML(track.root_label || track.root)}</span>` : ""}
                     <span class="tag tag-duration">${formatDuration(song.duration)}</span>
                     <span class="tag tag-loading" id="size-${escapeHTML(song.id)}"><i class="fa fa-spinner fa-spin"></i></span>
                     <span class="tag tag-loading" id="bitrate-${escapeHTML(song.id)}"><i class="fa fa-circle-notch fa-spin"></i></span>
@@ -2008,9 +2190,12 @@ function renderLocalMusicPageCard(track) {
                 </button>
                 ${lyricButton}
                 ${coverButton}
-                <button type="button" class="btn-circle btn-delete-local" title="删除本地音乐" onclick="deleteLocalMusicFromButton(this)">
+                ${track?.read_only ? "" : `<button type="button" class="btn-circle btn-edit-tags" title="编辑标签" onclick="openLocalMusicTagEditorFromButton(this)">
+                    <i class="fa-solid fa-tags"></i>
+                </button>`}
+                ${track?.read_only ? "" : `<button type="button" class="btn-circle btn-delete-local" title="删除本地音乐" onclick="deleteLocalMusicFromButton(this)">
                     <i class="fa-solid fa-trash"></i>
-                </button>
+                </button>`}
             </div>
         </li>
     `;
@@ -2076,18 +2261,23 @@ async function loadLocalMusicPage(page = 1, options = {}) {
   const targetPage = Math.max(1, parsePositiveInt(page, 1));
   const pageSize = getLocalMusicPageSize();
   const offset = (targetPage - 1) * pageSize;
+  const browse = localMusicBrowseRequest(localMusicBrowseState);
   const params = new URLSearchParams({
     offset: String(offset),
     limit: String(pageSize),
   });
-  if (options.force) {
+  Object.entries(browse.params).forEach(([key, value]) =>
+    params.set(key, value),
+  );
+  if (options.force && browse.kind === "all") {
     params.set("refresh", "1");
   }
 
   list.dataset.loading = "1";
+  renderLocalMusicBrowseBar();
   setLocalMusicPageHint("正在加载本地音乐...");
   try {
-    const payload = await fetchLocalMusicPagePayload(params);
+    const payload = await fetchLocalMusicPagePayload(params, browse.endpoint);
 
     const total = parsePositiveInt(payload.total, 0);
     const totalPages = Math.max(1, Math.ceil(total / pageSize));
@@ -2101,7 +2291,7 @@ async function loadLocalMusicPage(page = 1, options = {}) {
     }
 
     const totalEl = document.getElementById("localMusicPageTotal");
-    if (totalEl) totalEl.textContent = String(total);
+    if (totalEl && browse.kind === "all") totalEl.textContent = String(total);
 
     const toolbar = document.getElementById("batch-toolbar");
     if (toolbar && toolbar.dataset.localMusic === "true") {
@@ -2112,9 +2302,13 @@ async function loadLocalMusicPage(page = 1, options = {}) {
     }
 
     const tracks = Array.isArray(payload.tracks) ? payload.tracks : [];
-    list.innerHTML = tracks.map(renderLocalMusicPageCard).join("");
+    list.innerHTML =
+      renderLocalMusicBrowseGroups(browse, payload, targetPage) +
+      tracks.map(renderLocalMusicPageCard).join("");
 
-    if (!payload.exists) {
+    if (browse.kind !== "all") {
+      setLocalMusicPageHint(list.children.length ? "" : browse.empty);
+    } else if (!payload.exists) {
       setLocalMusicPageHint("下载目录还不存在。上传音乐后会自动创建该目录。");
     } else if (total === 0) {
       setLocalMusicPageHint(
@@ -2163,15 +2357,286 @@ function initializeLocalMusicPage(root = document) {
   const list = document.getElementById("localMusicPageList");
   if (!list || list.dataset.initialized === "1") return;
   list.dataset.initialized = "1";
+  localMusicBrowseState = readLocalMusicBrowseState();
   loadLocalMusicPage(getCurrentLocalMusicPage(), {
     updateHistory: false,
   });
+  loadLocalMusicUploadRoots();
+}
+
+// 本地曲库浏览：歌手 / 专辑 / 文件夹 / 最近添加 / 从未播放。
+// 状态保存在 URL 查询参数里，前进后退与刷新都能回到同一层级。
+const LOCAL_MUSIC_BROWSE_VIEWS = [
+  "all",
+  "artists",
+  "albums",
+  "folders",
+  "recent",
+  "never_played",
+];
+const LOCAL_MUSIC_BROWSE_KEYS = [
+  "artist",
+  "album",
+  "album_artist",
+  "root",
+  "path",
+  "label",
+];
+let localMusicBrowseState = { view: "all" };
+
+function readLocalMusicBrowseState() {
+  let params;
+  try {
+    params = new URL(window.location.href).searchParams;
+  } catch (_) {
+    return { view: "all" };
+  }
+  const view = params.get("view");
+  const state = {
+    view: LOCAL_MUSIC_BROWSE_VIEWS.includes(view) ? view : "all",
+  };
+  LOCAL_MUSIC_BROWSE_KEYS.forEach((key) => {
+    if (params.has(key)) state[key] = params.get(key);
+  });
+  return state;
+}
+
+function navigateLocalMusicBrowse(state) {
+  const view = LOCAL_MUSIC_BROWSE_VIEWS.includes(state?.view)
+    ? state.view
+    : "all";
+  localMusicBrowseState = { ...state, view };
+  const url = new URL(window.location.href);
+  ["view", "page", ...LOCAL_MUSIC_BROWSE_KEYS].forEach((key) =>
+    url.searchParams.delete(key),
+  );
+  if (view !== "all") url.searchParams.set("view", view);
+  LOCAL_MUSIC_BROWSE_KEYS.forEach((key) => {
+    if (typeof localMusicBrowseState[key] === "string") {
+      url.searchParams.set(key, localMusicBrowseState[key]);
+    }
+  });
+  window.history.pushState(null, "", url.toString());
+  return loadLocalMusicPage(1, { updateHistory: false, scroll: true });
+}
+
+function switchLocalMusicBrowseView(view) {
+  return navigateLocalMusicBrowse({ view });
+}
+
+function openLocalMusicBrowseCard(el) {
+  try {
+    navigateLocalMusicBrowse(JSON.parse(el.dataset.browse || "{}"));
+  } catch (_) {}
+}
+
+function localMusicBrowseRequest(state) {
+  const tracks = "/local_music/browse/tracks";
+  switch (state?.view) {
+    case "artists":
+      if (typeof state.artist === "string") {
+        return {
+          kind: "tracks",
+          endpoint: tracks,
+          params: { artist: state.artist },
+          empty: "这位歌手暂无本地歌曲。",
+        };
+      }
+      return {
+        kind: "artists",
+        endpoint: "/local_music/browse/artists",
+        params: {},
+        empty: "曲库里还没有歌曲。",
+      };
+    case "albums":
+      if (typeof state.album === "string") {
+        return {
+          kind: "tracks",
+          endpoint: tracks,
+          params: { album: state.album, album_artist: state.album_artist || "" },
+          empty: "这张专辑暂无本地歌曲。",
+        };
+      }
+      return {
+        kind: "albums",
+        endpoint: "/local_music/browse/albums",
+        params: {},
+        empty: "还没有带专辑标签的歌曲。",
+      };
+    case "folders": {
+      const params = { path: state.path || "" };
+      if (typeof state.root === "string") params.root = state.root;
+      return {
+        kind: "folders",
+        endpoint: "/local_music/browse/folders",
+        params,
+        empty: "这个文件夹是空的。",
+      };
+    }
+    case "recent":
+      return {
+        kind: "tracks",
+        endpoint: tracks,
+        params: { smart: "recent" },
+        empty: "曲库里还没有歌曲。",
+      };
+    case "never_played":
+      return {
+        kind: "tracks",
+        endpoint: tracks,
+        params: { smart: "never_played" },
+        empty: "所有歌曲都播放过了。",
+      };
+    default:
+      return { kind: "all", endpoint: "/local_music", params: {}, empty: "" };
+  }
+}
+
+function localMusicBrowseCardHTML(card) {
+  const cover = card.cover
+    ? `<img src="${escapeHTML(card.cover)}" alt="" loading="lazy" onerror="this.remove()">`
+    : "";
+  return `
+        <li class="local-music-browse-card">
+            <button type="button" data-browse='${escapeHTML(JSON.stringify(card.state))}' onclick="openLocalMusicBrowseCard(this)">
+                <span class="local-music-browse-cover"><i class="fa-solid ${card.icon}" aria-hidden="true"></i>${cover}</span>
+                <span class="local-music-browse-body">
+                    <span class="local-music-browse-title">${escapeHTML(card.title)}</span>
+                    <span class="local-music-browse-meta">${escapeHTML(card.meta)}</span>
+                </span>
+                <i class="fa-solid fa-chevron-right local-music-browse-chevron" aria-hidden="true"></i>
+            </button>
+        </li>
+    `;
+}
+
+function renderLocalMusicBrowseGroups(browse, payload, page) {
+  if (browse.kind === "artists") {
+    return (payload.items || [])
+      .map((item) =>
+        localMusicBrowseCardHTML({
+          icon: "fa-microphone",
+          cover: item.cover,
+          title: item.name || "未知歌手",
+          meta: `${item.track_count || 0} 首 · ${item.album_count || 0} 张专辑`,
+          state: { view: "artists", artist: String(item.name || "") },
+        }),
+      )
+      .join("");
+  }
+  if (browse.kind === "albums") {
+    return (payload.items || [])
+      .map((item) =>
+        localMusicBrowseCardHTML({
+          icon: "fa-compact-disc",
+          cover: item.cover,
+          title: item.album,
+          meta: `${item.album_artist || "未知歌手"} · ${item.track_count || 0} 首 · ${formatDuration(item.duration || 0)}`,
+          state: {
+            view: "albums",
+            album: String(item.album || ""),
+            album_artist: String(item.album_artist || ""),
+          },
+        }),
+      )
+      .join("");
+  }
+  if (browse.kind === "folders" && page === 1) {
+    return (payload.folders || [])
+      .map((item) =>
+        localMusicBrowseCardHTML({
+          icon: item.path ? "fa-folder" : "fa-hard-drive",
+          title: item.name,
+          meta: `${item.track_count || 0} 首`,
+          state: {
+            view: "folders",
+            root: String(item.root || ""),
+            path: String(item.path || ""),
+            label: item.path ? localMusicBrowseState.label : item.name,
+          },
+        }),
+      )
+      .join("");
+  }
+  return "";
+}
+
+function localMusicBrowseCrumbs(state) {
+  if (state.view === "artists" && typeof state.artist === "string") {
+    return [
+      { label: "全部歌手", state: { view: "artists" } },
+      { label: state.artist || "未知歌手" },
+    ];
+  }
+  if (state.view === "albums" && typeof state.album === "string") {
+    return [
+      { label: "全部专辑", state: { view: "albums" } },
+      { label: `${state.album} · ${state.album_artist || "未知歌手"}` },
+    ];
+  }
+  if (
+    state.view !== "folders" ||
+    (typeof state.root !== "string" && !state.path)
+  ) {
+    return [];
+  }
+  const crumbs = [{ label: "全部文件夹", state: { view: "folders" } }];
+  const base = { view: "folders", root: state.root, label: state.label };
+  if (state.label) {
+    crumbs.push({ label: state.label, state: { ...base, path: "" } });
+  }
+  let prefix = "";
+  String(state.path || "")
+    .split("/")
+    .filter(Boolean)
+    .forEach((part) => {
+      prefix = prefix ? `${prefix}/${part}` : part;
+      crumbs.push({ label: part, state: { ...base, path: prefix } });
+    });
+  delete crumbs[crumbs.length - 1].state;
+  return crumbs;
+}
+
+function renderLocalMusicBrowseBar() {
+  const state = localMusicBrowseState;
+  document.querySelectorAll(".local-music-browse-tab").forEach((tab) => {
+    const active = tab.dataset.view === state.view;
+    tab.classList.toggle("is-active", active);
+    tab.setAttribute("aria-selected", active ? "true" : "false");
+  });
+  const nav = document.getElementById("localMusicBrowseCrumbs");
+  if (!nav) return;
+  const crumbs = localMusicBrowseCrumbs(state);
+  nav.hidden = crumbs.length === 0;
+  nav.innerHTML = crumbs
+    .map((crumb) =>
+      crumb.state
+        ? `<button type="button" data-browse='${escapeHTML(JSON.stringify(crumb.state))}' onclick="openLocalMusicBrowseCard(this)">${escapeHTML(crumb.label)}</button>`
+        : `<span aria-current="page">${escapeHTML(crumb.label)}</span>`,
+    )
+    .join('<i class="fa-solid fa-chevron-right" aria-hidden="true"></i>');
+}
+
+function reportLocalMusicPlayed(id) {
+  fetch(`${API_ROOT}/local_music/played`, {
+    method: "POST",
+    headers: {
+      "Content-Type": "application/json",
+      "X-Requested-With": "XMLHttpRequest",
+    },
+    body: JSON.stringify({ id }),
+  }).catch(() => {});
 }
 
 const DUPLICATE_GROUP_PAGE_SIZE = 10;
 let activeDuplicatePage = 1;
+// tags 按歌曲名和歌手聚合；fingerprint 按音频指纹相似度聚合。
+let activeDuplicateMode = "tags";
+let activeDuplicateGroups = [];
+let duplicateFingerprintTimer = null;
 
-async function checkDuplicateSongs(page = 1) {
+async function checkDuplicateSongs(page = 1, mode = activeDuplicateMode) {
+  activeDuplicateMode = mode === "fingerprint" ? "fingerprint" : "tags";
   // 显示 loading 弹窗
   showDuplicateModal(null, true);
   try {
@@ -2179,6 +2644,9 @@ async function checkDuplicateSongs(page = 1) {
       page: String(Math.max(1, parsePositiveInt(page, 1))),
       page_size: String(DUPLICATE_GROUP_PAGE_SIZE),
     });
+    if (activeDuplicateMode === "fingerprint") {
+      params.set("mode", "fingerprint");
+    }
     const resp = await fetch(`${API_ROOT}/local_music/duplicates?${params}`);
     if (!resp.ok) throw new Error("API error");
     const data = await resp.json();
@@ -2188,11 +2656,125 @@ async function checkDuplicateSongs(page = 1) {
       total: Math.max(0, parsePositiveInt(data.total, 0)),
       totalPages: Math.max(1, parsePositiveInt(data.total_pages, 1)),
     });
+    if (activeDuplicateMode === "fingerprint") {
+      refreshDuplicateFingerprintStatus();
+    }
   } catch (_) {
     showDuplicateModal([], false, { page: 1, total: 0, totalPages: 1 });
   }
 }
 
+function renderDuplicateFingerprintStatus(data) {
+  const status = document.getElementById("duplicateFingerprintStatus");
+  const button = document.getElementById("duplicateFingerprintBtn");
+  if (!status) return false;
+  const job = data?.job;
+  const running = job?.status === "running";
+  if (running) {
+    status.textContent = `正在计算指纹 ${job.processed}/${job.total}`;
+  } else if (data?.error) {
+    status.textContent = data.error;
+  } else {
+    const failed = job?.failed ? `，${job.failed} 首失败` : "";
+    status.textContent = `已计算指纹 ${data?.fingerprinted || 0} / ${data?.total || 0} 首${failed}`;
+  }
+  if (button) {
+    button.disabled =
+      running || !!data?.error || (data?.fingerprinted || 0) >= (data?.total || 0);
+  }
+  return running;
+}
+
+async function refreshDuplicateFingerprintStatus() {
+  clearTimeout(duplicateFingerprintTimer);
+  try {
+    const resp = await fetch(`${API_ROOT}/local_music/fingerprints`);
+    const data = await resp.json();
+    const wasRunning = document
+      .getElementById("duplicateFingerprintStatus")
+      ?.classList.contains("is-running");
+    const running = renderDuplicateFingerprintStatus(data);
+    document
+      .getElementById("duplicateFingerprintStatus")
+      ?.classList.toggle("is-running", running);
+    if (running) {
+      duplicateFingerprintTimer = setTimeout(
+        refreshDuplicateFingerprintStatus,
+        1500,
+      );
+    } else if (wasRunning) {
+      await checkDuplicateSongs(1, "fingerprint");
+    }
+  } catch (_) {}
+}
+
+async function startDuplicateFingerprintJob() {
+  try {
+    const resp = await fetch(`${API_ROOT}/local_music/fingerprints`, {
+      method: "POST",
+      headers: {
+        "Content-Type": "application/json",
+        "X-Requested-With": "XMLHttpRequest",
+      },
+      body: "{}",
+    });
+    const data = await resp.json().catch(() => ({}));
+    if (!resp.ok) throw new Error(data.error || "计算指纹失败");
+    document
+      .getElementById("duplicateFingerprintStatus")
+      ?.classList.add("is-running");
+    await refreshDuplicateFingerprintStatus();
+  } catch (error) {
+    showToast("计算指纹失败", error.message || "", "error", 4000);
+  }
+}
+
+// resolveDuplicateGroups 保留每组建议的版本，其余的删除或移到 .duplicates。
+async function resolveDuplicateGroups(action) {
+  const groups = activeDuplicateGroups
+    .filter((group) => group.keep)
+    .map((group) => ({
+      keep: group.keep,
+      remove: group.songs
+        .filter((song) => song.id !== group.keep && !song.read_only)
+        .map((song) => song.id),
+    }))
+    .filter((group) => group.remove.length > 0);
+  const count = groups.reduce((sum, group) => sum + group.remove.length, 0);
+  if (count === 0) return;
+  const verb = action === "move" ? "移到 .duplicates 文件夹" : "删除";
+  if (!confirm(`保留本页每组建议的版本，将其余 ${count} 个文件${verb}？`)) {
+    return;
+  }
+  try {
+    const resp = await fetch(`${API_ROOT}/local_music/duplicates/resolve`, {
+      method: "POST",
+      headers: {
+        "Content-Type": "application/json",
+        "X-Requested-With": "XMLHttpRequest",
+      },
+      body: JSON.stringify({ action, groups }),
+    });
+    const data = await resp.json().catch(() => ({}));
+    if (!resp.ok) throw new Error(data.error || "处理失败");
+    const removed = new Set(groups.flatMap((group) => group.remove));
+    stopDeletedLocalMusicPlayback(removed);
+    const failed = Array.isArray(data.failed) ? data.failed.length : 0;
+    showToast(
+      "重复文件已处理",
+      failed > 0
+        ? `已处理 ${data.resolved} 个，${failed} 个失败`
+        : `已处理 ${data.resolved} 个`,
+      failed > 0 ? "warning" : "success",
+      4000,
+    );
+    await refreshLocalMusicPageAfterMutation();
+    await checkDuplicateSongs(activeDuplicatePage);
+  } catch (error) {
+    alert(error.message || "处理失败");
+  }
+}
+
 async function refreshLocalMusicPageAfterMutation() {
   if (isLocalMusicPageActive()) {
     return loadLocalMusicPage(getCurrentLocalMusicPage(), {
@@ -2214,18 +2796,46 @@ function showDuplicateModal(groups, loading, pagination = {}) {
   overlay.onclick = (e) => {
     if (e.target === overlay) overlay.remove();
   };
+  activeDuplicateGroups = groups || [];
+  const fingerprintMode = activeDuplicateMode === "fingerprint";
 
   const modal = document.createElement("div");
   modal.className = "modal utility-modal duplicate-modal";
   const header = document.createElement("div");
   header.className = "modal-header";
   header.innerHTML = [
-    '<div><h3><i class="fa-solid fa-triangle-exclamation"></i> 重复歌曲检测</h3><p class="utility-modal-subtitle">按歌曲名和歌手聚合本地音乐</p></div>',
+    `<div><h3><i class="fa-solid fa-triangle-exclamation"></i> 重复歌曲检测</h3><p class="utility-modal-subtitle">${fingerprintMode ? "按音频指纹相似度聚合，标签不同也能找出来" : "按歌曲名和歌手聚合本地音乐"}</p></div>`,
     '<button type="button" class="modal-close" aria-label="关闭重复检测"><i class="fa-solid fa-xmark"></i></button>',
   ].join("");
   header.querySelector(".modal-close")?.addEventListener("click", () => overlay.remove());
   modal.appendChild(header);
 
+  const toolbar = document.createElement("div");
+  toolbar.className = "duplicate-toolbar";
+  const hasRemovable = activeDuplicateGroups.some((group) =>
+    group.songs.some((song) => song.id !== group.keep && !song.read_only),
+  );
+  toolbar.innerHTML = `
+    <div class="duplicate-mode-tabs">
+      <button type="button" class="${fingerprintMode ? "" : "active"}" onclick="checkDuplicateSongs(1, 'tags')">按标签</button>
+      <button type="button" class="${fingerprintMode ? "active" : ""}" onclick="checkDuplicateSongs(1, 'fingerprint')">按音频指纹</button>
+    </div>
+    ${
+      fingerprintMode
+        ? `<span id="duplicateFingerprintStatus" class="duplicate-fingerprint-status"></span>
+    <button type="button" class="btn-pill" id="duplicateFingerprintBtn" onclick="startDuplicateFingerprintJob()" disabled><i class="fa-solid fa-fingerprint"></i> 计算指纹</button>`
+        : ""
+    }
+    ${
+      !loading && hasRemovable
+        ? `<span class="duplicate-bulk-actions">
+      <button type="button" class="btn-pill" onclick="resolveDuplicateGroups('move')"><i class="fa-solid fa-folder-minus"></i> 其余移走</button>
+      <button type="button" class="btn-pill btn-pill-danger" onclick="resolveDuplicateGroups('delete')"><i class="fa-solid fa-trash"></i> 其余删除</button>
+    </span>`
+        : ""
+    }`;
+  modal.appendChild(toolbar);
+
   const body = document.createElement("div");
   body.className = "modal-body duplicate-content";
 
@@ -2248,8 +2858,19 @@ function showDuplicateModal(groups, loading, pagination = {}) {
             String(s.duration % 60).padStart(2, "0")
           : "?";
         const row = document.createElement("div");
-        row.className = "duplicate-row";
-        row.innerHTML = `<span class="duplicate-meta">${escapeHTML(s.ext || "?")} · ${sizeStr} · ${durStr}</span><span class="duplicate-actions"></span>`;
+        row.className = s.keep ? "duplicate-row is-keep" : "duplicate-row";
+        const details = [escapeHTML(s.ext || "?"), sizeStr, durStr];
+        if (s.bitrate) details.push(`${s.bitrate}kbps`);
+        if (fingerprintMode && !s.keep && s.similarity) {
+          details.push(`相似 ${Math.round(s.similarity * 100)}%`);
+        }
+        const keepBadge = s.keep
+          ? '<span class="duplicate-keep-badge">建议保留</span>'
+          : "";
+        const path = fingerprintMode
+          ? `<span class="duplicate-path">${escapeHTML(s.name)} · ${escapeHTML(s.artist)} — ${escapeHTML(s.rel_path || "")}</span>`
+          : "";
+        row.innerHTML = `<span class="duplicate-meta">${keepBadge}${details.join(" · ")}${path}</span><span class="duplicate-actions"></span>`;
         const actions = row.querySelector(".duplicate-actions");
 
         const playBtn = document.createElement("button");
@@ -3465,6 +4086,9 @@ function rememberPlaybackHistory(audio) {
   );
   records.unshift(entry);
   writePlaybackHistory(records);
+  if (isLocalMusicSourceValue(source)) {
+    reportLocalMusicPlayed(id);
+  }
 }
 
 function formatPlaybackHistoryTime(value) {
@@ -3532,8 +4156,8 @@ function renderPlaybackHistory(page = activePlaybackHistoryPage) {
 function openPlaybackHistoryModal() {
   const modal = document.getElementById("playbackHistoryModal");
   if (!modal) return;
-  renderPlaybackHistory(1);
   modal.style.display = "flex";
+  switchPlayHistoryView("recent");
 }
 
 function closePlaybackHistoryModal() {
@@ -3541,18 +4165,29 @@ function closePlaybackHistoryModal() {
   if (modal) modal.style.display = "none";
 }
 
-function clearPlaybackHistory() {
-  if (!confirm("确定清空播放历史？")) return;
+async function clearPlaybackHistory() {
+  if (!confirm("确定清空播放历史？排行和年度回顾也会一起清空。")) return;
   try {
     localStorage.removeItem(PLAYBACK_HISTORY_STORAGE_KEY);
   } catch (_) {}
   activePlaybackHistoryEntries = [];
   activePlaybackHistoryPage = 1;
-  renderPlaybackHistory(1);
+  try {
+    const resp = await fetch(`${API_ROOT}/history`, { method: "DELETE" });
+    if (!resp.ok) throw new Error(`HTTP ${resp.status}`);
+  } catch (err) {
+    showToast("清空失败", err.message, "error");
+  }
+  switchPlayHistoryView(activePlayHistoryView);
 }
 
 function playPlaybackHistoryItem(index) {
   const entry = activePlaybackHistoryEntries[index];
+  if (!entry) return;
+  playHistorySong(entry);
+}
+
+function playHistorySong(entry, resumeAt = 0) {
   if (!entry || !ap?.list) return;
 
   const song = {
@@ -3565,6 +4200,10 @@ function playPlaybackHistoryItem(index) {
     duration: Number(entry.duration || 0) || 0,
     extra: entry.extra || "",
   };
+  playHistorySession.pendingSeek =
+    resumeAt > 0
+      ? { key: `${song.source}\u0000${song.id}`, at: resumeAt }
+      : null;
   const lyricURLs = lyricURLsForPlayback(song);
   ap.list.clear();
   ap.list.add([{
@@ -3585,80 +4224,453 @@ function playPlaybackHistoryItem(index) {
   closePlaybackHistoryModal();
 }
 
-function escapeHtml(str) {
-  if (!str) return "";
-  return String(str)
-    .replace(/&/g, "&amp;")
-    .replace(/</g, "&lt;")
-    .replace(/>/g, "&gt;")
-    .replace(/"/g, "&quot;");
-}
+// === 服务端播放历史：上报播放事件，最近播放 / 继续播放 / 排行 / 年度回顾 ===
+const PLAY_HISTORY_PROGRESS_INTERVAL = 15_000;
+const PLAY_HISTORY_VIEWS = ["recent", "resume", "top", "recap"];
+// 当前这次播放：start 返回的 play_id 和最近一次的播放位置。
+const playHistorySession = {
+  key: "",
+  audio: null,
+  playId: 0,
+  position: 0,
+  duration: 0,
+  lastReport: 0,
+  pendingSeek: null,
+};
+let activePlayHistoryView = "recent";
+let activePlayHistoryRecords = [];
 
-// 选择下载目录文件夹
-async function pickDownloadFolder() {
-  try {
-    if (!window.showDirectoryPicker) {
-      // 降级：让用户手动选择预设或输入路径
-      document.getElementById("setting-download-dir-preset").value = "__custom__";
-      document.getElementById("setting-download-dir").focus();
-      return;
-    }
-    const dirHandle = await window.showDirectoryPicker();
-    const path = dirHandle.name;
-    // showDirectoryPicker 不暴露完整路径，用名称回填让用户补全
-    // 但大多数情况下用户知道自己的目录结构
-    const input = document.getElementById("setting-download-dir");
-    input.value = input.value || path;
-    input.focus();
-    input.setSelectionRange(input.value.length, input.value.length);
-  } catch (err) {
-    if (err.name !== "AbortError") {
-      console.warn("文件夹选择器不可用:", err);
+function playHistoryAudioKey(audio) {
+  const id = getPlaybackCardID(audio);
+  const source = String(audio?.source || "").trim();
+  if (!id || !source || !String(audio?.name || "").trim()) return "";
+  return `${source}\u0000${id}`;
+}
+
+// 不用 sendBeacon：它带不了 X-Requested-With，keepalive 的 fetch 在页面关闭时同样能发出去。
+function sendPlayHistoryEvent(event) {
+  const audio = playHistorySession.audio;
+  if (!audio) return Promise.resolve(null);
+  const body = JSON.stringify({
+    play_id: playHistorySession.playId,
+    event,
+    id: getPlaybackCardID(audio),
+    source: String(audio.source || "").trim(),
+    name: String(audio.name || "").trim(),
+    artist: String(audio.artist || "").trim(),
+    album: String(audio.album || "").trim(),
+    cover: String(audio.cover || "").trim(),
+    extra:
+      typeof audio.extra === "string"
+        ? audio.extra
+        : serializeSongExtra(audio.extra),
+    duration: Math.round(playHistorySession.duration),
+    position: Math.round(playHistorySession.position),
+  });
+  return fetch(`${API_ROOT}/history/event`, {
+    method: "POST",
+    headers: {
+      "Content-Type": "application/json",
+      "X-Requested-With": "XMLHttpRequest",
+    },
+    body,
+    keepalive: true,
+  })
+    .then((resp) => (resp.ok ? resp.json() : null))
+    .catch(() => null);
+}
+
+function beginPlayHistorySession(audio) {
+  const key = playHistoryAudioKey(audio);
+  // 暂停后继续播放同一首不算新的一次播放。
+  if (!key || key === playHistorySession.key) return;
+  finishPlayHistorySession("skip");
+  Object.assign(playHistorySession, {
+    key,
+    audio,
+    playId: 0,
+    position: 0,
+    duration: Number(audio.duration || 0) || 0,
+    lastReport: Date.now(),
+  });
+  sendPlayHistoryEvent("start").then((data) => {
+    if (data && playHistorySession.key === key) {
+      playHistorySession.playId = Number(data.play_id || 0) || 0;
     }
-  }
-}
-
-function proxiedGithubURL(rawURL, proxyURL, enabled) {
-  if (!enabled || !rawURL || !rawURL.startsWith("https://github.com/")) {
-    return rawURL;
-  }
-  return `${(proxyURL || "").replace(/\/$/, "")}/${rawURL}`;
+  });
 }
 
-function desktopExternalOpenCallback() {
-  try {
-    if (
-      typeof globalThis !== "undefined" &&
-      globalThis.callback &&
-      typeof globalThis.callback.musicDlOpenDownload === "function"
-    ) {
-      return globalThis.callback.musicDlOpenDownload;
-    }
-  } catch (_) {}
-  return null;
+function finishPlayHistorySession(event) {
+  if (!playHistorySession.key) return;
+  // complete / skip 早于 start 的响应时 play_id 还是 0，服务端会按歌曲补上。
+  sendPlayHistoryEvent(event);
+  playHistorySession.key = "";
+  playHistorySession.audio = null;
+  playHistorySession.playId = 0;
 }
 
-function openClientExternalURL(url, popup = null) {
-  const callback = desktopExternalOpenCallback();
-  if (callback) {
-    callback(url);
-    if (popup && !popup.closed) {
-      popup.close();
-    }
-    return true;
+function trackPlayHistoryProgress() {
+  if (
+    !playHistorySession.key ||
+    playHistoryAudioKey(getCurrentAPlayerAudio()) !== playHistorySession.key
+  ) {
+    return;
   }
-
-  if (popup && !popup.closed) {
-    try {
-      popup.opener = null;
-    } catch (_) {}
-    popup.location.href = url;
-    return true;
+  playHistorySession.position = Number(ap.audio.currentTime || 0);
+  if (Number.isFinite(ap.audio.duration) && ap.audio.duration > 0) {
+    playHistorySession.duration = ap.audio.duration;
   }
-
-  const opened = window.open(url, "_blank", "noopener,noreferrer");
-  if (opened) {
-    return true;
+  const now = Date.now();
+  if (now - playHistorySession.lastReport >= PLAY_HISTORY_PROGRESS_INTERVAL) {
+    playHistorySession.lastReport = now;
+    sendPlayHistoryEvent("progress");
+  }
+}
+
+function applyPendingPlayHistorySeek() {
+  const pending = playHistorySession.pendingSeek;
+  if (!pending) return;
+  if (playHistoryAudioKey(getCurrentAPlayerAudio()) !== pending.key) return;
+  playHistorySession.pendingSeek = null;
+  ap.seek(pending.at);
+}
+
+function playHistoryRecordEntry(record) {
+  return {
+    id: record.song_id,
+    source: record.source,
+    name: record.name,
+    artist: record.artist || "",
+    album: record.album || "",
+    cover: record.cover || "",
+    duration: Number(record.duration || 0) || 0,
+    extra: record.extra || "",
+  };
+}
+
+function setPlayHistoryCount(text) {
+  const countEl = document.getElementById("playback-history-count");
+  if (countEl) countEl.textContent = text;
+}
+
+function renderPlayHistoryEmpty(message) {
+  const listEl = document.getElementById("playback-history-list");
+  if (listEl) {
+    listEl.innerHTML = `<div class="utility-empty-state"><div><i class="fa-regular fa-clock"></i><br>${escapeHTML(message)}</div></div>`;
+  }
+}
+
+function renderPlayHistoryRecordItem(record, index, meta, action) {
+  const cover = record.cover
+    ? `<img src="${escapeHTML(record.cover)}" alt="">`
+    : '<i class="fa-solid fa-music"></i>';
+  return `<div class="playback-history-item">
+    <div class="playback-history-cover">${cover}</div>
+    <div class="playback-history-main">
+      <div class="playback-history-name">${escapeHTML(record.name)}</div>
+      <div class="playback-history-meta">${escapeHTML(record.artist || "未知歌手")}</div>
+      <div class="playback-history-time">${escapeHTML(meta)}</div>
+    </div>
+    <button type="button" class="playback-history-play" onclick="playPlayHistoryRecord(${index})"><i class="fa-solid fa-play"></i> ${action}</button>
+  </div>`;
+}
+
+function playPlayHistoryRecord(index) {
+  const record = activePlayHistoryRecords[index];
+  if (!record) return;
+  const resumeAt =
+    activePlayHistoryView === "resume" ? Number(record.position || 0) : 0;
+  playHistorySong(playHistoryRecordEntry(record), resumeAt);
+}
+
+async function fetchPlayHistoryJSON(path) {
+  const resp = await fetch(`${API_ROOT}${path}`);
+  const data = await resp.json().catch(() => ({}));
+  if (!resp.ok) throw new Error(data.error || `HTTP ${resp.status}`);
+  return data;
+}
+
+function switchPlayHistoryView(view) {
+  activePlayHistoryView = PLAY_HISTORY_VIEWS.includes(view) ? view : "recent";
+  document.querySelectorAll(".play-history-tab").forEach((tab) => {
+    const active = tab.dataset.view === activePlayHistoryView;
+    tab.classList.toggle("is-active", active);
+    tab.setAttribute("aria-selected", active ? "true" : "false");
+  });
+  const periodSelect = document.getElementById("play-history-period");
+  if (periodSelect) periodSelect.hidden = activePlayHistoryView !== "top";
+  const yearSelect = document.getElementById("play-history-year");
+  if (yearSelect) {
+    yearSelect.hidden = activePlayHistoryView !== "recap";
+    if (!yearSelect.options.length) {
+      const year = new Date().getFullYear();
+      for (let y = year; y > year - 5; y--) {
+        yearSelect.add(new Option(`${y} 年`, String(y)));
+      }
+    }
+  }
+  const paginationEl = document.getElementById("playback-history-pagination");
+  if (paginationEl) paginationEl.innerHTML = "";
+
+  switch (activePlayHistoryView) {
+    case "resume":
+      return loadResumablePlays();
+    case "top":
+      return loadTopPlays(periodSelect?.value || "month");
+    case "recap":
+      return loadPlayRecap(yearSelect?.value || new Date().getFullYear());
+    default:
+      return loadRecentPlays(1);
+  }
+}
+
+async function loadRecentPlays(page = 1) {
+  const paginationEl = document.getElementById("playback-history-pagination");
+  let data;
+  try {
+    data = await fetchPlayHistoryJSON(
+      `/history/recent?page=${parsePositiveInt(page, 1)}&page_size=${PLAYBACK_HISTORY_PAGE_SIZE}`,
+    );
+  } catch (_) {
+    data = null;
+  }
+  if (activePlayHistoryView !== "recent") return;
+  // 服务端没有记录（历史被关闭或刚升级）时退回到本机记录。
+  if (!data || !Number(data.total || 0)) {
+    renderPlaybackHistory(1);
+    return;
+  }
+  activePlayHistoryRecords = data.records || [];
+  setPlayHistoryCount(`最近播放 ${data.total} 首`);
+  const listEl = document.getElementById("playback-history-list");
+  if (listEl) {
+    listEl.innerHTML = activePlayHistoryRecords
+      .map((record, index) =>
+        renderPlayHistoryRecordItem(
+          record,
+          index,
+          formatPlaybackHistoryTime(Date.parse(record.started_at)),
+          "播放",
+        ),
+      )
+      .join("");
+  }
+  if (paginationEl) {
+    paginationEl.innerHTML = renderUtilityModalPagination(
+      data.page,
+      data.total_pages,
+      "loadRecentPlays",
+    );
+  }
+}
+
+async function loadResumablePlays() {
+  try {
+    const data = await fetchPlayHistoryJSON("/history/resume?limit=20");
+    if (activePlayHistoryView !== "resume") return;
+    activePlayHistoryRecords = data.records || [];
+  } catch (err) {
+    setPlayHistoryCount("加载失败");
+    renderPlayHistoryEmpty(err.message);
+    return;
+  }
+  if (!activePlayHistoryRecords.length) {
+    setPlayHistoryCount("没有未听完的歌曲");
+    renderPlayHistoryEmpty("中途停下的歌曲会显示在这里，可从上次的位置接着听");
+    return;
+  }
+  setPlayHistoryCount(`未听完 ${activePlayHistoryRecords.length} 首`);
+  const listEl = document.getElementById("playback-history-list");
+  if (!listEl) return;
+  listEl.innerHTML = activePlayHistoryRecords
+    .map((record, index) => {
+      const total = record.duration
+        ? ` / ${formatDuration(record.duration)}`
+        : "";
+      return renderPlayHistoryRecordItem(
+        record,
+        index,
+        `听到 ${formatDuration(record.position)}${total}`,
+        "继续",
+      );
+    })
+    .join("");
+}
+
+function renderPlayHistoryRanking(title, rows, label) {
+  if (!rows.length) return "";
+  return `<div class="play-history-ranking">
+    <h4>${escapeHTML(title)}</h4>
+    <ol>${rows.map((row) => `<li>${label(row)}</li>`).join("")}</ol>
+  </div>`;
+}
+
+async function loadTopPlays(period) {
+  let data;
+  try {
+    data = await fetchPlayHistoryJSON(
+      `/history/top?period=${encodeURIComponent(period)}&limit=10`,
+    );
+  } catch (err) {
+    setPlayHistoryCount("加载失败");
+    renderPlayHistoryEmpty(err.message);
+    return;
+  }
+  if (activePlayHistoryView !== "top") return;
+  const tracks = data.tracks || [];
+  const artists = data.artists || [];
+  activePlayHistoryRecords = [];
+  if (!tracks.length) {
+    setPlayHistoryCount("暂无排行");
+    renderPlayHistoryEmpty("听满 30 秒或听完的歌曲会计入排行");
+    return;
+  }
+  setPlayHistoryCount(`共 ${tracks.length} 首上榜`);
+  const listEl = document.getElementById("playback-history-list");
+  if (!listEl) return;
+  listEl.innerHTML =
+    renderPlayHistoryRanking(
+      "歌曲",
+      tracks,
+      (track) =>
+        `<span>${escapeHTML(track.name)} <small>${escapeHTML(track.artist || "未知歌手")}</small></span><em>${track.plays} 次</em>`,
+    ) +
+    renderPlayHistoryRanking(
+      "歌手",
+      artists,
+      (artist) =>
+        `<span>${escapeHTML(artist.artist)} <small>${artist.tracks} 首</small></span><em>${artist.plays} 次</em>`,
+    );
+}
+
+async function loadPlayRecap(year) {
+  let recap;
+  try {
+    recap = await fetchPlayHistoryJSON(
+      `/history/recap?year=${encodeURIComponent(year)}&limit=5`,
+    );
+  } catch (err) {
+    setPlayHistoryCount("加载失败");
+    renderPlayHistoryEmpty(err.message);
+    return;
+  }
+  if (activePlayHistoryView !== "recap") return;
+  activePlayHistoryRecords = [];
+  if (!recap.plays) {
+    setPlayHistoryCount(`${recap.year} 年暂无播放`);
+    renderPlayHistoryEmpty("这一年还没有计入统计的播放");
+    return;
+  }
+  setPlayHistoryCount(`${recap.year} 年度回顾`);
+  const hours = (Number(recap.seconds || 0) / 3600).toFixed(1);
+  const maxMonth = Math.max(1, ...recap.months.map((month) => month.plays));
+  const topTrack = (recap.top_tracks || [])[0];
+  const topArtist = (recap.top_artists || [])[0];
+  const facts = [
+    ["播放", `${recap.plays} 次`],
+    ["收听", `${hours} 小时`],
+    ["歌曲", `${recap.tracks} 首`],
+    ["歌手", `${recap.artists} 位`],
+  ];
+  const listEl = document.getElementById("playback-history-list");
+  if (!listEl) return;
+  listEl.innerHTML = `<div class="play-history-recap">
+    <div class="play-history-recap-facts">${facts
+      .map(
+        ([label, value]) =>
+          `<div><strong>${escapeHTML(value)}</strong><span>${label}</span></div>`,
+      )
+      .join("")}</div>
+    <div class="play-history-recap-months" aria-label="每月播放次数">${recap.months
+      .map(
+        (month) =>
+          `<div title="${month.month} 月 ${month.plays} 次"><i style="height: ${Math.round((month.plays / maxMonth) * 100)}%"></i><span>${month.month}</span></div>`,
+      )
+      .join("")}</div>
+    <ul class="play-history-recap-notes">
+      ${topTrack ? `<li>最常听的歌：${escapeHTML(topTrack.name)} - ${escapeHTML(topTrack.artist)}（${topTrack.plays} 次）</li>` : ""}
+      ${topArtist ? `<li>最常听的歌手：${escapeHTML(topArtist.artist)}（${topArtist.plays} 次）</li>` : ""}
+      ${recap.busiest_day ? `<li>听歌最多的一天：${escapeHTML(recap.busiest_day)}（${recap.busiest_day_plays} 次）</li>` : ""}
+      ${recap.first_play ? `<li>这一年听的第一首：${escapeHTML(recap.first_play.name)}</li>` : ""}
+    </ul>
+    <a class="btn-pill" href="${API_ROOT}/history/recap?year=${recap.year}" target="_blank" rel="noopener"><i class="fa-solid fa-file-code"></i> 查看 JSON</a>
+  </div>`;
+}
+
+function escapeHtml(str) {
+  if (!str) return "";
+  return String(str)
+    .replace(/&/g, "&amp;")
+    .replace(/</g, "&lt;")
+    .replace(/>/g, "&gt;")
+    .replace(/"/g, "&quot;");
+}
+
+// 选择下载目录文件夹
+async function pickDownloadFolder() {
+  try {
+    if (!window.showDirectoryPicker) {
+      // 降级：让用户手动选择预设或输入路径
+      document.getElementById("setting-download-dir-preset").value = "__custom__";
+      document.getElementById("setting-download-dir").focus();
+      return;
+    }
+    const dirHandle = await window.showDirectoryPicker();
+    const path = dirHandle.name;
+    // showDirectoryPicker 不暴露完整路径，用名称回填让用户补全
+    // 但大多数情况下用户知道自己的目录结构
+    const input = document.getElementById("setting-download-dir");
+    input.value = input.value || path;
+    input.focus();
+    input.setSelectionRange(input.value.length, input.value.length);
+  } catch (err) {
+    if (err.name !== "AbortError") {
+      console.warn("文件夹选择器不可用:", err);
+    }
+  }
+}
+
+function proxiedGithubURL(rawURL, proxyURL, enabled) {
+  if (!enabled || !rawURL || !rawURL.startsWith("https://github.com/")) {
+    return rawURL;
+  }
+  return `${(proxyURL || "").replace(/\/$/, "")}/${rawURL}`;
+}
+
+function desktopExternalOpenCallback() {
+  try {
+    if (
+      typeof globalThis !== "undefined" &&
+      globalThis.callback &&
+      typeof globalThis.callback.musicDlOpenDownload === "function"
+    ) {
+      return globalThis.callback.musicDlOpenDownload;
+    }
+  } catch (_) {}
+  return null;
+}
+
+function openClientExternalURL(url, popup = null) {
+  const callback = desktopExternalOpenCallback();
+  if (callback) {
+    callback(url);
+    if (popup && !popup.closed) {
+      popup.close();
+    }
+    return true;
+  }
+
+  if (popup && !popup.closed) {
+    try {
+      popup.opener = null;
+    } catch (_) {}
+    popup.location.href = url;
+    return true;
+  }
+
+  const opened = window.open(url, "_blank", "noopener,noreferrer");
+  if (opened) {
+    return true;
   }
 
   window.location.href = url;
@@ -3728,3017 +4740,6746 @@ async function openSystemConfig() {
     }
     setAuthFloatLoggedIn(true);
     if (modal) modal.style.display = "flex";
+    loadConfigProfiles();
+    loadSettingsOverrides();
+    loadWebhooks();
+    loadScrobblers();
+    loadLocalMusicWatcherStatus();
   } catch (error) {
     applyWebSettings(webSettings);
     showToast("系统配置加载失败", error.message || "请稍后重试", "error");
   }
 }
 
-function openCookieModal() {
-  openSystemConfig();
+const LOCAL_MUSIC_WATCH_LABELS = {
+  fsnotify: "系统文件通知",
+  poll: "定期轮询",
+};
+
+async function loadLocalMusicWatcherStatus() {
+  const hint = document.getElementById("setting-local-music-watch-status");
+  if (!hint) return;
+  try {
+    const response = await fetch(API_ROOT + "/local_music/watcher", {
+      headers: { Accept: "application/json" },
+    });
+    const status = await response.json().catch(() => null);
+    if (!response.ok || !status) return;
+    if (!status.running) {
+      hint.textContent = status.last_error
+        ? `监听未运行：${status.last_error}`
+        : "监听已关闭，本地音乐列表按缓存过期后重扫。";
+      return;
+    }
+    const parts = [
+      `${status.healthy ? "运行中" : "异常"}：${LOCAL_MUSIC_WATCH_LABELS[status.active] || status.active}`,
+      `新增 ${status.added} / 更新 ${status.updated} / 删除 ${status.removed}`,
+    ];
+    if (status.fallback_reason) parts.push(`已降级为轮询（${status.fallback_reason}）`);
+    if (status.last_error) parts.push(`最近错误：${status.last_error}`);
+    hint.textContent = parts.join("，");
+  } catch (_) {}
 }
 
-async function saveCookies() {
-  const webPageSizeInput = document.getElementById("setting-web-page-size");
-  const cliPageSizeInput = document.getElementById("setting-cli-page-size");
+// 额外曲库目录：每行一个根目录，include / exclude 以逗号分隔的 glob。
+// key 由服务端生成并原样回传，改标签或路径不会让已收藏的曲目失效。
+function splitLibraryGlobs(value) {
+  return String(value || "")
+    .split(/[,，\n]/)
+    .map((item) => item.trim())
+    .filter(Boolean);
+}
 
-  const nextSettings = normalizeWebSettings({
-    embedDownload: !!document.getElementById("setting-embed-download")?.checked,
-    downloadDir: document.getElementById("setting-download-dir")?.value || "",
-    downloadFilenameTemplate:
-      document.getElementById("setting-download-filename-template")?.value ||
-      "",
-    disableFloatingLyrics: !document.getElementById("setting-floating-lyrics")
-      ?.checked,
-    webPageSize: parsePositiveInt(
-      webPageSizeInput?.value,
-      DEFAULT_WEB_PAGE_SIZE,
-    ),
-    cliPageSize: parsePositiveInt(
-      cliPageSizeInput?.value,
-      DEFAULT_CLI_PAGE_SIZE,
-    ),
-    autoCheckUpdate: webSettings.autoCheckUpdate,
-    autoSwitchInvalidSources: !!document.getElementById(
-      "setting-auto-switch-invalid-sources",
-    )?.checked,
-    autoCacheOnPlay: !!document.getElementById("setting-auto-cache-on-play")
-      ?.checked,
-    updateRepoUrl: webSettings.updateRepoUrl || DEFAULT_UPDATE_REPO_URL,
-    githubProxyEnabled: !!webSettings.githubProxyEnabled,
-    githubProxyUrl: webSettings.githubProxyUrl || DEFAULT_GITHUB_PROXY_URL,
-    vgChangeCover: !!document.getElementById("setting-vg-change-cover")
-      ?.checked,
-    vgChangeAudio: !!document.getElementById("setting-vg-change-audio")
-      ?.checked,
-    vgChangeLyric: !!document.getElementById("setting-vg-change-lyric")
-      ?.checked,
-    vgExportVideo: !!document.getElementById("setting-vg-export-video")
-      ?.checked,
-  });
+function libraryRootRowHTML(root = {}) {
+  return `
+        <div class="library-root-row" data-key="${escapeHTML(root.key || "")}">
+            <input type="text" class="library-root-label" placeholder="标签，如 NAS" value="${escapeHTML(root.label || "")}">
+            <input type="text" class="library-root-path" placeholder="/mnt/nas/music" value="${escapeHTML(root.path || "")}">
+            <label class="library-root-readonly"><input type="checkbox" ${root.readOnly ? "checked" : ""}> 只读</label>
+            <input type="text" class="library-root-include" placeholder="包含，如 *.flac" value="${escapeHTML((root.include || []).join(", "))}">
+            <input type="text" class="library-root-exclude" placeholder="排除，如 Podcasts" value="${escapeHTML((root.exclude || []).join(", "))}">
+            <button type="button" class="btn-circle" title="移除" onclick="this.closest('.library-root-row').remove()"><i class="fa-solid fa-xmark"></i></button>
+        </div>
+    `;
+}
 
-  const data = {};
-  document.querySelectorAll('input[id^="cookie-"]').forEach((input) => {
-    data[input.id.replace("cookie-", "")] = input.value;
-  });
+function renderLibraryRootsEditor(roots) {
+  const list = document.getElementById("setting-library-roots-list");
+  if (!list) return;
+  list.innerHTML = (roots || []).map((root) => libraryRootRowHTML(root)).join("");
+}
 
-  try {
-    const [cookiesResponse, settingsResponse] = await Promise.all([
-      fetch(API_ROOT + "/cookies", {
-        method: "POST",
-        headers: {
-          "Content-Type": "application/json",
-          Accept: "application/json",
-        },
-        body: JSON.stringify(data),
-      }),
-      fetch(API_ROOT + "/settings", {
-        method: "POST",
-        headers: {
-          "Content-Type": "application/json",
-          Accept: "application/json",
-        },
-        body: JSON.stringify(nextSettings),
-      }),
-    ]);
-    const cookiesPayload = await cookiesResponse.json().catch(() => null);
-    const savedSettings = await settingsResponse.json().catch(() => null);
-    if (
-      handleConfigAuthResponse(cookiesResponse, cookiesPayload) ||
-      handleConfigAuthResponse(settingsResponse, savedSettings)
-    )
-      return;
-    if (!cookiesResponse.ok || !settingsResponse.ok) {
-      throw new Error("保存失败，请稍后重试");
-    }
-    applyWebSettings(savedSettings || nextSettings);
-    setAuthFloatLoggedIn(true);
-    alert("保存成功");
-    document.getElementById("cookieModal").style.display = "none";
-  } catch (error) {
-    alert(error.message || "保存失败，请稍后重试");
-  }
+function addLibraryRootRow() {
+  const list = document.getElementById("setting-library-roots-list");
+  if (list) list.insertAdjacentHTML("beforeend", libraryRootRowHTML());
 }
 
-window.addEventListener("scroll", () => {
-  const topBtn = document.getElementById("back-to-top");
-  const bottomBtn = document.getElementById("back-to-bottom");
-  if (topBtn) {
-    if (window.scrollY > 300) {
-      topBtn.classList.add("show");
-    } else {
-      topBtn.classList.remove("show");
-    }
-  }
-  if (bottomBtn) {
-    if (window.scrollY > 300) {
-      bottomBtn.classList.add("show");
-    } else {
-      bottomBtn.classList.remove("show");
-    }
-  }
-});
+function readLibraryRootsEditor() {
+  const list = document.getElementById("setting-library-roots-list");
+  if (!list) return webSettings.libraryRoots;
+  return Array.from(list.querySelectorAll(".library-root-row"))
+    .map((row) => ({
+      key: row.dataset.key || "",
+      label: row.querySelector(".library-root-label")?.value.trim() || "",
+      path: row.querySelector(".library-root-path")?.value.trim() || "",
+      readOnly: !!row.querySelector(".library-root-readonly input")?.checked,
+      include: splitLibraryGlobs(row.querySelector(".library-root-include")?.value),
+      exclude: splitLibraryGlobs(row.querySelector(".library-root-exclude")?.value),
+    }))
+    .filter((root) => root.path !== "");
+}
 
-function scrollToTop() {
-  window.scrollTo({ top: 0, behavior: "smooth" });
+function transcodeProfileRowHTML(profile = {}) {
+  const formats = TRANSCODE_FORMATS.map(
+    (format) =>
+      `<option value="${format}" ${profile.format === format ? "selected" : ""}>${format}</option>`,
+  ).join("");
+  return `
+        <div class="transcode-profile-row">
+            <input type="text" class="transcode-profile-name" placeholder="名称，如 car" value="${escapeHTML(profile.name || "")}">
+            <select class="transcode-profile-format">${formats}</select>
+            <input type="number" class="transcode-profile-bitrate" min="0" step="1" placeholder="码率 kbps" value="${profile.bitrate || ""}">
+            <input type="number" class="transcode-profile-sample-rate" min="0" step="1" placeholder="采样率 Hz" value="${profile.sampleRate || ""}">
+            <button type="button" class="btn-circle" title="移除" onclick="this.closest('.transcode-profile-row').remove()"><i class="fa-solid fa-xmark"></i></button>
+        </div>
+    `;
 }
 
-function scrollToBottom() {
-  window.scrollTo({ top: document.body.scrollHeight, behavior: "smooth" });
+function renderTranscodeProfilesEditor(profiles) {
+  const list = document.getElementById("setting-transcode-profiles-list");
+  if (!list) return;
+  list.innerHTML = (profiles || []).map((profile) => transcodeProfileRowHTML(profile)).join("");
 }
 
-// ==========================================
-// 浮动翻页键
-// ==========================================
+function addTranscodeProfileRow() {
+  const list = document.getElementById("setting-transcode-profiles-list");
+  if (list) list.insertAdjacentHTML("beforeend", transcodeProfileRowHTML({ format: "mp3" }));
+}
 
-function updateFloatPageNav() {
-  const numEl = document.getElementById("float-page-num");
-  if (!numEl) return;
+function readTranscodeProfilesEditor() {
+  const list = document.getElementById("setting-transcode-profiles-list");
+  if (!list) return webSettings.transcodeProfiles;
+  return Array.from(list.querySelectorAll(".transcode-profile-row")).map((row) => ({
+    name: row.querySelector(".transcode-profile-name")?.value.trim() || "",
+    format: row.querySelector(".transcode-profile-format")?.value || "mp3",
+    bitrate: parseInt(row.querySelector(".transcode-profile-bitrate")?.value, 10) || 0,
+    sampleRate: parseInt(row.querySelector(".transcode-profile-sample-rate")?.value, 10) || 0,
+  }));
+}
 
-  // 本地音乐页
-  if (isLocalMusicPageActive()) {
-    const bar = document.getElementById("localMusicPagePagination");
-    if (!bar) {
-      setFloatPageNumber(numEl, 1);
-      return;
-    }
-    const cur = parseInt(bar.dataset.currentPage, 10);
-    if (Number.isFinite(cur)) setFloatPageNumber(numEl, cur);
-    return;
+async function fetchTranscodeProfiles() {
+  const response = await fetch(API_ROOT + "/transcode/profiles", {
+    headers: { Accept: "application/json" },
+  });
+  const payload = await response.json().catch(() => null);
+  if (!response.ok || !payload) {
+    throw new Error(payload?.error || "加载转码档案失败");
   }
+  return payload;
+}
 
-  const { current } = getRenderedSearchPageInfo();
-  setFloatPageNumber(numEl, current);
+function transcodeProfileLabel(profile) {
+  const parts = [profile.format];
+  if (profile.bitrate) parts.push(`${profile.bitrate}k`);
+  if (profile.sampleRate) parts.push(`${profile.sampleRate / 1000}kHz`);
+  return `${profile.name}（${parts.join(" · ")}）`;
 }
 
-function setFloatPageNumber(element, page) {
-  if (element.tagName === "INPUT") {
-    element.value = String(page);
-  } else {
-    element.textContent = String(page);
-  }
+async function loadDownloadTranscodeProfileOptions() {
+  const select = document.getElementById("setting-download-transcode-profile");
+  if (!select) return;
+  try {
+    const payload = await fetchTranscodeProfiles();
+    select.innerHTML = (payload.profiles || [])
+      .map(
+        (profile) =>
+          `<option value="${escapeHTML(profile.name)}">${escapeHTML(transcodeProfileLabel(profile))}</option>`,
+      )
+      .join("");
+    select.value = webSettings.downloadTranscodeProfile;
+  } catch (_) {}
 }
 
-function getRenderedSearchPageInfo() {
-  const fallback = parseInt(new URLSearchParams(window.location.search).get("page"), 10) || 1;
-  const summary = document.querySelector(".page-summary");
-  const match = summary ? summary.textContent.match(/(\d+)\s*\/\s*(\d+)/) : null;
-  if (!match) return { current: fallback, total: null };
-  return {
-    current: parseInt(match[1], 10) || fallback,
-    total: parseInt(match[2], 10) || null,
-  };
+async function loadLocalMusicUploadRoots() {
+  const select = document.getElementById("localMusicUploadRoot");
+  if (!select) return;
+  try {
+    const response = await fetch(API_ROOT + "/local_music/roots", {
+      headers: { Accept: "application/json" },
+    });
+    const payload = await response.json().catch(() => null);
+    if (!response.ok || !payload) return;
+    const writable = (payload.roots || []).filter((root) => !root.read_only && (root.exists || root.download));
+    select.innerHTML = writable
+      .map((root) => `<option value="${escapeHTML(root.key)}">${escapeHTML(root.label || root.path)}</option>`)
+      .join("");
+    select.hidden = writable.length < 2;
+  } catch (_) {}
 }
 
-function floatPageUp() {
-  if (isLocalMusicPageActive()) {
-    const bar = document.getElementById("localMusicPagePagination");
-    if (!bar) return;
-    const cur = parseInt(bar.dataset.currentPage, 10);
-    if (cur > 1) goToPage(cur - 1);
-  } else {
-    const { current } = getRenderedSearchPageInfo();
-    if (current > 1) goToPage(current - 1);
+function openCookieModal() {
+  openSystemConfig();
+}
+
+// Settings pinned by --config or MUSIC_DL_* variables are rendered read-only;
+// the server ignores them on save anyway.
+const SETTINGS_OVERRIDE_INPUTS = {
+  embedDownload: ["setting-embed-download"],
+  downloadDir: ["setting-download-dir", "setting-download-dir-preset"],
+  downloadFilenameTemplate: ["setting-download-filename-template"],
+  disableFloatingLyrics: ["setting-floating-lyrics"],
+  webPageSize: ["setting-web-page-size"],
+  cliPageSize: ["setting-cli-page-size"],
+  searchSources: ["setting-search-sources"],
+  autoSwitchInvalidSources: ["setting-auto-switch-invalid-sources"],
+  autoCacheOnPlay: ["setting-auto-cache-on-play"],
+  disablePlayHistory: ["setting-play-history"],
+  playHistoryRetentionDays: ["setting-play-history-retention"],
+  replayGainMode: ["setting-replay-gain-mode"],
+  crossfadeSeconds: ["setting-crossfade-seconds"],
+  gaplessPlayback: ["setting-gapless-playback"],
+  localMusicWatchMode: ["setting-local-music-watch-mode"],
+  localMusicSearchPinyin: ["setting-local-music-search-pinyin"],
+  localMusicSearchFoldVariants: ["setting-local-music-search-fold-variants"],
+  libraryRoots: ["setting-library-roots"],
+  transcodeProfiles: ["setting-transcode-profiles"],
+  downloadTranscodeMode: ["setting-download-transcode-mode"],
+  downloadTranscodeProfile: ["setting-download-transcode-profile"],
+  vgChangeCover: ["setting-vg-change-cover"],
+  vgChangeAudio: ["setting-vg-change-audio"],
+  vgChangeLyric: ["setting-vg-change-lyric"],
+  vgExportVideo: ["setting-vg-export-video"],
+};
+
+function describeSettingsOverride(origin) {
+  if (origin.startsWith("env:")) return `环境变量 ${origin.slice(4)}`;
+  if (origin.startsWith("file:")) return `配置文件 ${origin.slice(5)}`;
+  return origin;
+}
+
+function applySettingsOverrides(fields) {
+  for (const [key, ids] of Object.entries(SETTINGS_OVERRIDE_INPUTS)) {
+    const origin = fields[key] || "";
+    ids.forEach((id) => {
+      const el = document.getElementById(id);
+      if (!el) return;
+      el.disabled = !!origin;
+      const item = el.closest(".cookie-item");
+      if (!item) return;
+      item.classList.toggle("is-pinned", !!origin);
+      item.title = origin ? `由${describeSettingsOverride(origin)}设置，只读` : "";
+      item.querySelectorAll("button").forEach((button) => {
+        button.disabled = !!origin;
+      });
+      let badge = item.querySelector(".setting-pinned-badge");
+      if (origin && !badge) {
+        badge = document.createElement("span");
+        badge.className = "setting-pinned-badge";
+        (item.querySelector("label") || item).appendChild(badge);
+      }
+      if (badge) {
+        if (origin) {
+          badge.textContent = origin.startsWith("env:") ? "环境变量" : "配置文件";
+        } else {
+          badge.remove();
+        }
+      }
+    });
   }
 }
 
-function floatPageDown() {
-  if (isLocalMusicPageActive()) {
-    const bar = document.getElementById("localMusicPagePagination");
-    if (!bar) return;
-    const cur = parseInt(bar.dataset.currentPage, 10);
-    const total = parseInt(bar.dataset.totalPages, 10);
-    if (cur < total) goToPage(cur + 1);
-  } else {
-    const { current, total } = getRenderedSearchPageInfo();
-    if (total && current < total) goToPage(current + 1);
+async function loadSettingsOverrides() {
+  try {
+    const response = await fetch(API_ROOT + "/settings/overrides", {
+      headers: { Accept: "application/json" },
+    });
+    const data = await readConfigJSON(response);
+    if (!data) return;
+    applySettingsOverrides(data.fields || {});
+  } catch (_) {
+    applySettingsOverrides({});
   }
 }
 
-// 点击页数 → 输入框 → Enter/blur 跳转
-function floatPageNumClick() {
-  const numEl = document.getElementById("float-page-num");
-  if (!numEl) return;
-  if (isLocalMusicPageActive()) {
-    if (!document.getElementById("localMusicPagePagination")) return;
-  } else if (!getRenderedSearchPageInfo().total) {
-    return;
+async function readConfigJSON(response) {
+  const payload = await response.json().catch(() => null);
+  if (handleConfigAuthResponse(response, payload)) return null;
+  if (!response.ok) {
+    throw new Error((payload && payload.error) || "请求失败，请稍后重试");
   }
-  const currentVal = numEl.textContent.trim();
-  // 如果已经是输入框则忽略
-  if (numEl.tagName === "INPUT") return;
+  return payload || {};
+}
 
-  const input = document.createElement("input");
-  input.type = "number";
-  input.min = "1";
-  input.value = currentVal;
-  input.className = numEl.className;
-  input.id = numEl.id;
-  input.style.width = "40px";
-  input.style.height = "28px";
-  input.style.textAlign = "center";
-  input.style.fontSize = "13px";
-  input.style.fontWeight = "700";
-  input.style.color = "#10b981";
-  input.style.border = "2px solid #10b981";
-  input.style.borderRadius = "6px";
-  input.style.outline = "none";
-  input.style.background = "white";
+async function loadConfigProfiles() {
+  const select = document.getElementById("setting-profile-select");
+  if (!select) return;
+  try {
+    const response = await fetch(API_ROOT + "/config/profiles", {
+      headers: { Accept: "application/json" },
+    });
+    const data = await readConfigJSON(response);
+    if (!data) return;
+    const profiles = Array.isArray(data.profiles) ? data.profiles : [];
+    select.innerHTML = profiles.length
+      ? profiles
+          .map((profile) => {
+            const name = escapeHtml(profile.name || "");
+            const selected = profile.name === data.active ? " selected" : "";
+            return `<option value="${name}"${selected}>${name}</option>`;
+          })
+          .join("")
+      : '<option value="">（暂无档案）</option>';
+  } catch (error) {
+    select.innerHTML = '<option value="">（加载失败）</option>';
+  }
+}
 
-  let submitted = false;
-  const restore = () => {
-    if (input.parentNode) input.parentNode.replaceChild(numEl, input);
-  };
-  const jump = () => {
-    if (submitted) return;
-    submitted = true;
-    const val = parseInt(input.value, 10);
-    restore();
-    if (Number.isFinite(val) && val > 0) goToPage(val);
-  };
+const WEBHOOK_EVENT_LABELS = {
+  "download.success": "单曲下载成功",
+  "download.failed": "单曲下载失败",
+  "download.batch_finished": "批量下载完成",
+  "library.cached": "边播边缓存完成",
+  "library.cache_failed": "边播边缓存失败",
+  "library.uploaded": "上传到曲库",
+  "collection.changed": "导入歌单上游变化",
+};
 
-  input.onkeydown = function(e) {
-    if (e.key === "Enter") {
-      e.preventDefault();
-      jump();
-    }
-    if (e.key === "Escape") {
-      submitted = true;
-      restore();
+async function loadWebhooks() {
+  const list = document.getElementById("setting-webhook-list");
+  const eventsBox = document.getElementById("setting-webhook-events");
+  if (!list) return;
+  try {
+    const response = await fetch(API_ROOT + "/webhooks", {
+      headers: { Accept: "application/json" },
+    });
+    const data = await readConfigJSON(response);
+    if (!data) return;
+    const hooks = Array.isArray(data.webhooks) ? data.webhooks : [];
+    list.innerHTML = hooks.length
+      ? hooks
+          .map((hook) => {
+            const events = (hook.events || [])
+              .map((event) => WEBHOOK_EVENT_LABELS[event] || event)
+              .join("、");
+            return `<div class="webhook-row">
+              <span class="webhook-row-main"><strong>${escapeHtml(hook.kind)}</strong> ${escapeHtml(hook.url)}
+              <small>${escapeHtml(events)}</small></span>
+              <button type="button" class="cookie-qr-btn" onclick="testWebhook(${hook.id})">测试</button>
+              <button type="button" class="cookie-qr-btn" onclick="deleteWebhook(${hook.id})">删除</button>
+            </div>`;
+          })
+          .join("")
+      : '<p class="setting-hint" style="margin-left: 0;">尚未配置 Webhook</p>';
+    if (eventsBox && !eventsBox.dataset.ready) {
+      const defaults = ["download.failed", "download.batch_finished", "library.cache_failed"];
+      eventsBox.innerHTML = (data.events || [])
+        .map((event) => {
+          const checked = defaults.includes(event) ? " checked" : "";
+          return `<label class="webhook-event"><input type="checkbox" value="${escapeHtml(event)}"${checked}> ${escapeHtml(WEBHOOK_EVENT_LABELS[event] || event)}</label>`;
+        })
+        .join("");
+      eventsBox.dataset.ready = "1";
     }
-  };
-  input.onblur = jump;
-
-  numEl.parentNode.replaceChild(input, numEl);
-  input.focus();
-  input.select();
+  } catch (error) {
+    list.innerHTML = '<p class="setting-hint" style="margin-left: 0;">Webhook 加载失败</p>';
+  }
 }
 
-let defaultDocumentTitle = document.title;
-let mediaSessionSyncTimer = 0;
-let mediaSessionSyncVersion = 0;
-const mediaSessionCoverCache = new Map();
-
-function mediaSessionControllerSupported() {
-  return typeof navigator !== "undefined" && !!navigator.mediaSession;
+async function addWebhook() {
+  const urlInput = document.getElementById("setting-webhook-url");
+  const tokenInput = document.getElementById("setting-webhook-token");
+  const url = (urlInput?.value || "").trim();
+  if (!url) {
+    showToast("请填写 Webhook 地址", "", "warning", 3000);
+    return;
+  }
+  const events = Array.from(
+    document.querySelectorAll("#setting-webhook-events input:checked"),
+  ).map((input) => input.value);
+  try {
+    const response = await fetch(API_ROOT + "/webhooks", {
+      method: "POST",
+      headers: {
+        "Content-Type": "application/json",
+        Accept: "application/json",
+      },
+      body: JSON.stringify({
+        kind: document.getElementById("setting-webhook-kind")?.value || "generic",
+        url,
+        token: (tokenInput?.value || "").trim(),
+        events,
+      }),
+    });
+    if (!(await readConfigJSON(response))) return;
+    if (urlInput) urlInput.value = "";
+    if (tokenInput) tokenInput.value = "";
+    await loadWebhooks();
+    showToast("Webhook 已添加", url, "success", 3000);
+  } catch (error) {
+    showToast("添加 Webhook 失败", error.message, "error");
+  }
 }
 
-function mediaSessionMetadataSupported() {
-  return (
-    mediaSessionControllerSupported() &&
-    typeof window.MediaMetadata === "function"
-  );
+async function deleteWebhook(id) {
+  if (!confirm("确认删除该 Webhook？")) return;
+  try {
+    const response = await fetch(`${API_ROOT}/webhooks/${id}`, { method: "DELETE" });
+    if (!(await readConfigJSON(response))) return;
+    await loadWebhooks();
+  } catch (error) {
+    showToast("删除 Webhook 失败", error.message, "error");
+  }
 }
 
-function getCurrentAPlayerAudio() {
-  if (!ap || !ap.list || !Array.isArray(ap.list.audios)) return null;
-  const index = ap.list.index;
-  if (typeof index !== "number" || index < 0) return null;
-  return ap.list.audios[index] || null;
+async function testWebhook(id) {
+  try {
+    const response = await fetch(`${API_ROOT}/webhooks/${id}/test`, { method: "POST" });
+    const delivery = await readConfigJSON(response);
+    if (!delivery) return;
+    if (delivery.status === "success") {
+      showToast("测试通知已送达", `HTTP ${delivery.status_code}`, "success", 3000);
+    } else {
+      showToast("测试通知失败", delivery.error || `HTTP ${delivery.status_code}`, "error");
+    }
+    loadWebhookDeliveries();
+  } catch (error) {
+    showToast("测试通知失败", error.message, "error");
+  }
 }
 
-function getPlaybackCardID(audio) {
-  return String(audio?.original_id || audio?.custom_id || "").trim();
+async function loadWebhookDeliveries() {
+  const box = document.getElementById("setting-webhook-deliveries");
+  if (!box) return;
+  try {
+    const response = await fetch(API_ROOT + "/webhooks/deliveries?limit=20", {
+      headers: { Accept: "application/json" },
+    });
+    const data = await readConfigJSON(response);
+    if (!data) return;
+    const rows = Array.isArray(data.deliveries) ? data.deliveries : [];
+    box.innerHTML = rows.length
+      ? rows
+          .map((row) => {
+            const time = new Date(row.created_at).toLocaleString();
+            const detail = row.error ? ` · ${escapeHtml(row.error)}` : "";
+            return `<div class="webhook-delivery is-${escapeHtml(row.status)}">${escapeHtml(time)} · ${escapeHtml(row.target_name)} · ${escapeHtml(row.event)} · ${escapeHtml(row.status)} (${row.attempts}次)${detail}</div>`;
+          })
+          .join("")
+      : '<p class="setting-hint" style="margin-left: 0;">暂无投递记录</p>';
+  } catch (error) {
+    box.innerHTML = '<p class="setting-hint" style="margin-left: 0;">投递记录加载失败</p>';
+  }
 }
 
-function buildMediaSessionTrackKey(audio = getCurrentAPlayerAudio()) {
-  if (!audio) return "";
-
-  const customId = String(audio.custom_id || "").trim();
-  if (customId) return customId;
-
-  const source = String(audio.source || "").trim();
-  const name = String(audio.name || "").trim();
-  const artist = String(audio.artist || "").trim();
-  if (!source && !name && !artist) return "";
+const SCROBBLER_KIND_LABELS = {
+  listenbrainz: "ListenBrainz",
+  lastfm: "Last.fm",
+};
 
-  return `${source}::${name}::${artist}`;
+function updateScrobblerInputs() {
+  const kind = document.getElementById("setting-scrobbler-kind")?.value;
+  const isLastFM = kind === "lastfm";
+  const lastfmRow = document.getElementById("setting-scrobbler-lastfm");
+  const urlInput = document.getElementById("setting-scrobbler-url");
+  const tokenInput = document.getElementById("setting-scrobbler-token");
+  if (lastfmRow) lastfmRow.style.display = isLastFM ? "" : "none";
+  if (urlInput) {
+    urlInput.placeholder = isLastFM
+      ? "留空使用 ws.audioscrobbler.com/2.0/"
+      : "留空使用 api.listenbrainz.org";
+  }
+  if (tokenInput) tokenInput.placeholder = isLastFM ? "Session Key" : "User Token";
 }
 
-function normalizeMediaSessionURL(value) {
-  const raw = String(value || "").trim();
-  if (!raw) return "";
-
+async function loadScrobblers() {
+  const list = document.getElementById("setting-scrobbler-list");
+  if (!list) return;
   try {
-    return new URL(raw, window.location.href).toString();
-  } catch (_) {
-    return raw;
+    const response = await fetch(API_ROOT + "/scrobblers", {
+      headers: { Accept: "application/json" },
+    });
+    const data = await readConfigJSON(response);
+    if (!data) return;
+    const scrobblers = Array.isArray(data.scrobblers) ? data.scrobblers : [];
+    list.innerHTML = scrobblers.length
+      ? scrobblers
+          .map((item) => {
+            const queue = item.queued
+              ? `待提交 ${item.queued} 条${item.last_error ? ` · ${item.last_error}` : ""}`
+              : "队列为空";
+            return `<div class="webhook-row">
+              <span class="webhook-row-main"><strong>${escapeHtml(SCROBBLER_KIND_LABELS[item.kind] || item.kind)}</strong> ${escapeHtml(item.name)}
+              <small>${escapeHtml(item.url)} · ${escapeHtml(queue)}</small></span>
+              <button type="button" class="cookie-qr-btn" onclick="testScrobbler(${item.id})">测试</button>
+              <button type="button" class="cookie-qr-btn" onclick="deleteScrobbler(${item.id})">删除</button>
+            </div>`;
+          })
+          .join("")
+      : '<p class="setting-hint" style="margin-left: 0;">尚未配置 Scrobble 服务</p>';
+  } catch (error) {
+    list.innerHTML =
+      '<p class="setting-hint" style="margin-left: 0;">Scrobble 服务加载失败</p>';
   }
 }
 
-function isTransientMediaSessionURL(value) {
-  const lowered = String(value || "")
-    .trim()
-    .toLowerCase();
-  return lowered.startsWith("data:") || lowered.startsWith("blob:");
-}
-
-function extractURLFromBackgroundImage(value) {
-  const raw = String(value || "").trim();
-  if (!raw) return "";
-
-  const match = raw.match(/^url\((['"]?)(.*)\1\)$/i);
-  if (!match || !match[2]) return "";
-  return match[2].trim();
-}
-
-function buildMediaSessionCoverURL(audio = getCurrentAPlayerAudio()) {
-  const candidates = [];
-  const trackKey = buildMediaSessionTrackKey(audio);
-
-  if (audio) {
-    candidates.push({
-      url: audio.cover,
-      source: audio.source || "",
-    });
-  }
-
-  const currentId =
-    getPlaybackCardID(audio) || String(currentPlayingId || "").trim();
-  if (currentId) {
-    const card = Array.from(document.querySelectorAll(".song-card")).find(
-      (item) => item?.dataset?.id === currentId,
-    );
-    if (card) {
-      const imgEl = card.querySelector(".cover-wrapper img");
-      if (imgEl && imgEl.src) {
-        candidates.unshift({
-          url: imgEl.src,
-          source: card.dataset.source || audio?.source || "",
-        });
-      }
-
-      if (card.dataset.cover) {
-        candidates.push({
-          url: card.dataset.cover,
-          source: card.dataset.source || audio?.source || "",
-        });
-      }
-    }
-  }
-
-  const apPic = document.querySelector(".aplayer-pic");
-  if (apPic?.style?.backgroundImage) {
-    const playerCover = extractURLFromBackgroundImage(
-      apPic.style.backgroundImage,
+async function addScrobbler() {
+  const value = (id) => (document.getElementById(id)?.value || "").trim();
+  const kind = value("setting-scrobbler-kind") || "listenbrainz";
+  const token = value("setting-scrobbler-token");
+  if (!token) {
+    showToast(
+      kind === "lastfm" ? "请填写 Session Key" : "请填写 User Token",
+      "",
+      "warning",
+      3000,
     );
-    if (playerCover) {
-      candidates.unshift({
-        url: playerCover,
-        source: audio?.source || "",
-      });
-    }
-  }
-
-  const fallbackCandidates = [];
-  const seen = new Set();
-  for (const candidate of candidates) {
-    const normalized = normalizeMediaSessionURL(candidate?.url);
-    if (!normalized || seen.has(normalized)) continue;
-    seen.add(normalized);
-
-    if (isTransientMediaSessionURL(normalized)) {
-      fallbackCandidates.push(normalized);
-      continue;
-    }
-
-    try {
-      const parsed = new URL(normalized, window.location.href);
-      if (
-        parsed.origin === window.location.origin &&
-        parsed.pathname === `${API_ROOT}/cover_proxy`
-      ) {
-        const resolved = parsed.toString();
-        if (trackKey) {
-          mediaSessionCoverCache.set(trackKey, resolved);
-        }
-        return resolved;
-      }
-
-      const proxy = new URL(`${API_ROOT}/cover_proxy`, window.location.href);
-      proxy.searchParams.set("url", parsed.toString());
-      const sourceValue = String(candidate?.source || "").trim();
-      if (sourceValue) {
-        proxy.searchParams.set("source", sourceValue);
-      }
-      const resolved = proxy.toString();
-      if (trackKey) {
-        mediaSessionCoverCache.set(trackKey, resolved);
-      }
-      return resolved;
-    } catch (_) {
-      if (trackKey) {
-        mediaSessionCoverCache.set(trackKey, normalized);
-      }
-      return normalized;
-    }
-  }
-
-  if (trackKey) {
-    const cached = mediaSessionCoverCache.get(trackKey);
-    if (cached) {
-      return cached;
-    }
+    return;
   }
-
-  // Do not expose transient blob/data artwork URLs to MediaSession.
-  // They often expire quickly and cause a visible "flash then disappear" effect on MPRIS clients.
-  if (fallbackCandidates.length > 0 && trackKey) {
-    const cached = mediaSessionCoverCache.get(trackKey);
-    if (cached && !isTransientMediaSessionURL(cached)) {
-      return cached;
-    }
+  try {
+    const response = await fetch(API_ROOT + "/scrobblers", {
+      method: "POST",
+      headers: {
+        "Content-Type": "application/json",
+        Accept: "application/json",
+      },
+      body: JSON.stringify({
+        kind,
+        url: value("setting-scrobbler-url"),
+        token,
+        api_key: kind === "lastfm" ? value("setting-scrobbler-api-key") : "",
+        api_secret:
+          kind === "lastfm" ? value("setting-scrobbler-api-secret") : "",
+      }),
+    });
+    const saved = await readConfigJSON(response);
+    if (!saved) return;
+    for (const id of [
+      "setting-scrobbler-url",
+      "setting-scrobbler-token",
+      "setting-scrobbler-api-key",
+      "setting-scrobbler-api-secret",
+    ]) {
+      const input = document.getElementById(id);
+      if (input) input.value = "";
+    }
+    await loadScrobblers();
+    showToast("Scrobble 服务已添加", saved.name || "", "success", 3000);
+  } catch (error) {
+    showToast("添加 Scrobble 服务失败", error.message, "error");
   }
-
-  return "";
-}
-
-function buildMediaSessionArtwork(audio = getCurrentAPlayerAudio()) {
-  const src = buildMediaSessionCoverURL(audio);
-  if (!src) return [];
-
-  return [{ src }];
 }
 
-function updateDocumentTitleForMedia(audio) {
-  if (!audio || !audio.name) {
-    document.title = defaultDocumentTitle;
+async function deleteScrobbler(id) {
+  if (!confirm("确认删除该 Scrobble 服务？队列中未提交的记录也会一并删除。")) {
     return;
   }
-
-  const parts = [audio.name];
-  if (audio.artist) {
-    parts.push(audio.artist);
+  try {
+    const response = await fetch(`${API_ROOT}/scrobblers/${id}`, {
+      method: "DELETE",
+    });
+    if (!(await readConfigJSON(response))) return;
+    await loadScrobblers();
+  } catch (error) {
+    showToast("删除 Scrobble 服务失败", error.message, "error");
   }
-  document.title = `${parts.join(" - ")} | music-dl`;
 }
 
-function shouldPreserveMediaSessionMetadata() {
-  return !!ap?.list?.audios?.length;
+async function testScrobbler(id) {
+  try {
+    const response = await fetch(`${API_ROOT}/scrobblers/${id}/test`, {
+      method: "POST",
+    });
+    if (!(await readConfigJSON(response))) return;
+    showToast("连接成功", "已发送一条“正在播放”测试", "success", 3000);
+  } catch (error) {
+    showToast("Scrobble 测试失败", error.message, "error");
+  }
 }
 
-function updateMediaSessionMetadata(audio = getCurrentAPlayerAudio()) {
-  if (!mediaSessionControllerSupported()) return;
-
-  if (!audio) {
-    if (shouldPreserveMediaSessionMetadata()) {
-      return;
-    }
-    if (mediaSessionMetadataSupported()) {
-      navigator.mediaSession.metadata = null;
-    }
-    updateDocumentTitleForMedia(null);
-    return;
+async function flushScrobbleQueue() {
+  try {
+    const response = await fetch(API_ROOT + "/scrobblers/flush", {
+      method: "POST",
+    });
+    const result = await readConfigJSON(response);
+    if (!result) return;
+    await loadScrobblers();
+    showToast(
+      "队列已重试",
+      `提交 ${result.sent} 条，丢弃 ${result.dropped} 条，剩余 ${result.pending} 条`,
+      result.pending ? "warning" : "success",
+      4000,
+    );
+  } catch (error) {
+    showToast("重试队列失败", error.message, "error");
   }
+}
 
-  if (!mediaSessionMetadataSupported()) {
-    updateDocumentTitleForMedia(audio);
-    return;
+async function saveConfigProfile() {
+  const select = document.getElementById("setting-profile-select");
+  const name = (prompt("档案名称（例如 home / travel）", select?.value || "") || "").trim();
+  if (!name) return;
+  try {
+    const response = await fetch(API_ROOT + "/config/profiles", {
+      method: "POST",
+      headers: {
+        "Content-Type": "application/json",
+        Accept: "application/json",
+      },
+      body: JSON.stringify({ name }),
+    });
+    if (!(await readConfigJSON(response))) return;
+    await loadConfigProfiles();
+    showToast("档案已保存", `当前设置已保存为 “${name}”`, "success");
+  } catch (error) {
+    showToast("保存档案失败", error.message, "error");
   }
+}
 
-  const metadata = {
-    title: audio.name || "music-dl",
-    artist: audio.artist || "",
-  };
-
-  if (audio.album) {
-    metadata.album = audio.album;
+async function activateConfigProfile() {
+  const name = document.getElementById("setting-profile-select")?.value || "";
+  if (!name) return;
+  try {
+    const response = await fetch(API_ROOT + "/config/profiles/activate", {
+      method: "POST",
+      headers: {
+        "Content-Type": "application/json",
+        Accept: "application/json",
+      },
+      body: JSON.stringify({ name }),
+    });
+    const settings = await readConfigJSON(response);
+    if (!settings) return;
+    applyWebSettings(settings);
+    await loadConfigProfiles();
+    showToast("已切换档案", name, "success");
+  } catch (error) {
+    showToast("切换档案失败", error.message, "error");
   }
+}
 
-  const artwork = buildMediaSessionArtwork(audio);
-  if (artwork.length > 0) {
-    metadata.artwork = artwork;
+async function deleteConfigProfile() {
+  const name = document.getElementById("setting-profile-select")?.value || "";
+  if (!name || !confirm(`确定删除档案 “${name}” 吗？当前设置不会改变。`)) return;
+  try {
+    const response = await fetch(
+      API_ROOT + "/config/profiles?name=" + encodeURIComponent(name),
+      { method: "DELETE", headers: { Accept: "application/json" } },
+    );
+    if (!(await readConfigJSON(response))) return;
+    await loadConfigProfiles();
+  } catch (error) {
+    showToast("删除档案失败", error.message, "error");
   }
-
-  navigator.mediaSession.metadata = new MediaMetadata(metadata);
-  updateDocumentTitleForMedia(audio);
 }
 
-function updateMediaSessionPlaybackState() {
-  if (!mediaSessionControllerSupported()) return;
+function configArchiveFormData() {
+  const form = new FormData();
+  const includeCookies = !!document.getElementById(
+    "setting-config-include-cookies",
+  )?.checked;
+  form.append("cookies", includeCookies ? "1" : "0");
+  form.append(
+    "passphrase",
+    document.getElementById("setting-config-passphrase")?.value || "",
+  );
+  return form;
+}
 
-  const audio = getCurrentAPlayerAudio();
-  if (!ap?.audio || !audio) {
-    navigator.mediaSession.playbackState = "none";
+async function exportConfigArchive() {
+  const form = configArchiveFormData();
+  if (form.get("cookies") === "1" && !form.get("passphrase")) {
+    showToast("需要口令", "导出 Cookie 时必须填写加密口令", "error");
     return;
   }
-
-  navigator.mediaSession.playbackState = ap.audio.paused ? "paused" : "playing";
+  try {
+    const response = await fetch(API_ROOT + "/config/export", {
+      method: "POST",
+      body: form,
+    });
+    if (!response.ok) {
+      await readConfigJSON(response);
+      return;
+    }
+    const disposition = response.headers.get("Content-Disposition") || "";
+    const match = disposition.match(/filename\*?=(?:UTF-8'')?"?([^";]+)"?/i);
+    const filename = match ? decodeURIComponent(match[1]) : "go-music-dl-config.zip";
+    const url = URL.createObjectURL(await response.blob());
+    const link = document.createElement("a");
+    link.href = url;
+    link.download = filename;
+    document.body.appendChild(link);
+    link.click();
+    link.remove();
+    setTimeout(() => URL.revokeObjectURL(url), 1000);
+  } catch (error) {
+    showToast("导出失败", error.message, "error");
+  }
 }
 
-function updateMediaSessionPositionState() {
-  if (!mediaSessionControllerSupported()) return;
-  if (
-    !ap?.audio ||
-    typeof navigator.mediaSession.setPositionState !== "function"
-  )
-    return;
-
-  const duration = Number(ap.audio.duration);
-  const position = Number(ap.audio.currentTime);
-  const playbackRate = Number(ap.audio.playbackRate) || 1;
-
-  if (!Number.isFinite(duration) || duration <= 0) return;
-  if (!Number.isFinite(position) || position < 0) return;
-
+async function importConfigArchive(input) {
+  const file = input?.files?.[0];
+  if (!file) return;
+  const form = configArchiveFormData();
+  form.append("file", file);
+  input.value = "";
   try {
-    navigator.mediaSession.setPositionState({
-      duration,
-      playbackRate,
-      position: Math.min(position, duration),
+    const response = await fetch(API_ROOT + "/config/import", {
+      method: "POST",
+      body: form,
     });
-  } catch (_) {}
+    const data = await readConfigJSON(response);
+    if (!data) return;
+    if (data.settings) applyWebSettings(data.settings);
+    await loadConfigProfiles();
+    const result = data.result || {};
+    const created = (result.sections && result.sections.collections) || 0;
+    showToast(
+      "导入完成",
+      `档案 ${result.profiles || 0} 个，Cookie ${result.cookies || 0} 条，去重记录 ${result.dedup || 0} 条，新建歌单 ${created} 个`,
+      "success",
+    );
+  } catch (error) {
+    showToast("导入失败", error.message, "error");
+  }
 }
 
-function syncMediaSession(audio = getCurrentAPlayerAudio()) {
-  if (!mediaSessionControllerSupported()) return;
-  updateMediaSessionMetadata(audio);
-  updateMediaSessionPlaybackState();
-  updateMediaSessionPositionState();
-}
+async function saveCookies() {
+  const webPageSizeInput = document.getElementById("setting-web-page-size");
+  const cliPageSizeInput = document.getElementById("setting-cli-page-size");
 
-function scheduleMediaSessionSync(
-  audio = getCurrentAPlayerAudio(),
-  delayMs = 160,
-) {
-  if (!mediaSessionControllerSupported()) return;
+  const nextSettings = normalizeWebSettings({
+    embedDownload: !!document.getElementById("setting-embed-download")?.checked,
+    downloadDir: document.getElementById("setting-download-dir")?.value || "",
+    downloadFilenameTemplate:
+      document.getElementById("setting-download-filename-template")?.value ||
+      "",
+    disableFloatingLyrics: !document.getElementById("setting-floating-lyrics")
+      ?.checked,
+    webPageSize: parsePositiveInt(
+      webPageSizeInput?.value,
+      DEFAULT_WEB_PAGE_SIZE,
+    ),
+    cliPageSize: parsePositiveInt(
+      cliPageSizeInput?.value,
+      DEFAULT_CLI_PAGE_SIZE,
+    ),
+    searchSources: Array.from(
+      document.querySelectorAll(".setting-search-source:checked"),
+    ).map((input) => input.value),
+    autoCheckUpdate: webSettings.autoCheckUpdate,
+    autoSwitchInvalidSources: !!document.getElementById(
+      "setting-auto-switch-invalid-sources",
+    )?.checked,
+    autoCacheOnPlay: !!document.getElementById("setting-auto-cache-on-play")
+      ?.checked,
+    disablePlayHistory: !document.getElementById("setting-play-history")
+      ?.checked,
+    playHistoryRetentionDays: parsePositiveInt(
+      document.getElementById("setting-play-history-retention")?.value,
+      0,
+    ),
+    replayGainMode:
+      document.getElementById("setting-replay-gain-mode")?.value || "",
+    crossfadeSeconds: parsePositiveInt(
+      document.getElementById("setting-crossfade-seconds")?.value,
+      0,
+    ),
+    gaplessPlayback: !!document.getElementById("setting-gapless-playback")
+      ?.checked,
+    localMusicWatchMode:
+      document.getElementById("setting-local-music-watch-mode")?.value ||
+      webSettings.localMusicWatchMode,
+    localMusicSearchPinyin: !!document.getElementById(
+      "setting-local-music-search-pinyin",
+    )?.checked,
+    localMusicSearchFoldVariants: !!document.getElementById(
+      "setting-local-music-search-fold-variants",
+    )?.checked,
+    libraryRoots: readLibraryRootsEditor(),
+    transcodeProfiles: readTranscodeProfilesEditor(),
+    downloadTranscodeMode:
+      document.getElementById("setting-download-transcode-mode")?.value || "",
+    downloadTranscodeProfile:
+      document.getElementById("setting-download-transcode-profile")?.value ||
+      webSettings.downloadTranscodeProfile,
+    updateRepoUrl: webSettings.updateRepoUrl || DEFAULT_UPDATE_REPO_URL,
+    githubProxyEnabled: !!webSettings.githubProxyEnabled,
+    githubProxyUrl: webSettings.githubProxyUrl || DEFAULT_GITHUB_PROXY_URL,
+    vgChangeCover: !!document.getElementById("setting-vg-change-cover")
+      ?.checked,
+    vgChangeAudio: !!document.getElementById("setting-vg-change-audio")
+      ?.checked,
+    vgChangeLyric: !!document.getElementById("setting-vg-change-lyric")
+      ?.checked,
+    vgExportVideo: !!document.getElementById("setting-vg-export-video")
+      ?.checked,
+  });
 
-  const expectedId = String(audio?.custom_id || "").trim();
-  const syncVersion = ++mediaSessionSyncVersion;
+  const data = {};
+  document.querySelectorAll('input[id^="cookie-"]').forEach((input) => {
+    data[input.id.replace("cookie-", "")] = input.value;
+  });
 
-  if (mediaSessionSyncTimer) {
-    clearTimeout(mediaSessionSyncTimer);
+  try {
+    const [cookiesResponse, settingsResponse] = await Promise.all([
+      fetch(API_ROOT + "/cookies", {
+        method: "POST",
+        headers: {
+          "Content-Type": "application/json",
+          Accept: "application/json",
+        },
+        body: JSON.stringify(data),
+      }),
+      fetch(API_ROOT + "/settings", {
+        method: "POST",
+        headers: {
+          "Content-Type": "application/json",
+          Accept: "application/json",
+        },
+        body: JSON.stringify(nextSettings),
+      }),
+    ]);
+    const cookiesPayload = await cookiesResponse.json().catch(() => null);
+    const savedSettings = await settingsResponse.json().catch(() => null);
+    if (
+      handleConfigAuthResponse(cookiesResponse, cookiesPayload) ||
+      handleConfigAuthResponse(settingsResponse, savedSettings)
+    )
+      return;
+    if (!cookiesResponse.ok || !settingsResponse.ok) {
+      throw new Error("保存失败，请稍后重试");
+    }
+    applyWebSettings(savedSettings || nextSettings);
+    setAuthFloatLoggedIn(true);
+    alert("保存成功");
+    document.getElementById("cookieModal").style.display = "none";
+  } catch (error) {
+    alert(error.message || "保存失败，请稍后重试");
   }
-
-  mediaSessionSyncTimer = setTimeout(
-    () => {
-      if (syncVersion !== mediaSessionSyncVersion) return;
-
-      const currentAudio = getCurrentAPlayerAudio();
-      if (
-        expectedId &&
-        currentAudio &&
-        String(currentAudio.custom_id || "").trim() !== expectedId
-      ) {
-        return;
-      }
-
-      syncMediaSession(currentAudio || audio);
-    },
-    Math.max(0, Number(delayMs) || 0),
-  );
 }
 
-function clearMediaSession() {
-  if (!mediaSessionControllerSupported()) return;
-  mediaSessionSyncVersion++;
-  if (mediaSessionSyncTimer) {
-    clearTimeout(mediaSessionSyncTimer);
-    mediaSessionSyncTimer = 0;
+window.addEventListener("scroll", () => {
+  const topBtn = document.getElementById("back-to-top");
+  const bottomBtn = document.getElementById("back-to-bottom");
+  if (topBtn) {
+    if (window.scrollY > 300) {
+      topBtn.classList.add("show");
+    } else {
+      topBtn.classList.remove("show");
+    }
   }
-  if (mediaSessionMetadataSupported()) {
-    navigator.mediaSession.metadata = null;
+  if (bottomBtn) {
+    if (window.scrollY > 300) {
+      bottomBtn.classList.add("show");
+    } else {
+      bottomBtn.classList.remove("show");
+    }
   }
-  navigator.mediaSession.playbackState = "none";
-  updateDocumentTitleForMedia(null);
-}
-
-function switchTrackByOffset(offset) {
-  if (!ap?.list?.audios?.length) return;
+});
 
-  const total = ap.list.audios.length;
-  const currentIndex =
-    typeof ap.list.index === "number" && ap.list.index >= 0 ? ap.list.index : 0;
-  const nextIndex = (currentIndex + offset + total) % total;
+function scrollToTop() {
+  window.scrollTo({ top: 0, behavior: "smooth" });
+}
 
-  ap.list.switch(nextIndex);
-  ap.play();
+function scrollToBottom() {
+  window.scrollTo({ top: document.body.scrollHeight, behavior: "smooth" });
 }
 
-function seekCurrentTrack(position) {
-  if (!ap?.audio) return;
+// ==========================================
+// 浮动翻页键
+// ==========================================
 
-  const duration = Number(ap.audio.duration);
-  if (!Number.isFinite(duration) || duration <= 0) return;
+function updateFloatPageNav() {
+  const numEl = document.getElementById("float-page-num");
+  if (!numEl) return;
 
-  const target = Math.max(0, Math.min(duration, Number(position) || 0));
-  try {
-    if (typeof ap.audio.fastSeek === "function") {
-      ap.audio.fastSeek(target);
-    } else {
-      ap.audio.currentTime = target;
+  // 本地音乐页
+  if (isLocalMusicPageActive()) {
+    const bar = document.getElementById("localMusicPagePagination");
+    if (!bar) {
+      setFloatPageNumber(numEl, 1);
+      return;
     }
-  } catch (_) {
-    ap.audio.currentTime = target;
+    const cur = parseInt(bar.dataset.currentPage, 10);
+    if (Number.isFinite(cur)) setFloatPageNumber(numEl, cur);
+    return;
   }
 
-  updateMediaSessionPositionState();
-}
-
-function adjustCurrentTrackPosition(offset) {
-  if (!ap?.audio) return;
-  seekCurrentTrack((Number(ap.audio.currentTime) || 0) + offset);
+  const { current } = getRenderedSearchPageInfo();
+  setFloatPageNumber(numEl, current);
 }
 
-function registerMediaSessionAction(action, handler) {
-  if (!mediaSessionControllerSupported()) return;
-  try {
-    navigator.mediaSession.setActionHandler(action, handler);
-  } catch (_) {}
+function setFloatPageNumber(element, page) {
+  if (element.tagName === "INPUT") {
+    element.value = String(page);
+  } else {
+    element.textContent = String(page);
+  }
 }
 
-function bindMediaSessionAudioEvents() {
-  if (!ap?.audio || ap.audio.dataset.mediaSessionBound === "1") return;
-
-  ap.audio.dataset.mediaSessionBound = "1";
-  const syncPosition = () => updateMediaSessionPositionState();
-  const syncState = () => {
-    updateMediaSessionPlaybackState();
-    updateMediaSessionPositionState();
+function getRenderedSearchPageInfo() {
+  const fallback = parseInt(new URLSearchParams(window.location.search).get("page"), 10) || 1;
+  const summary = document.querySelector(".page-summary");
+  const match = summary ? summary.textContent.match(/(\d+)\s*\/\s*(\d+)/) : null;
+  if (!match) return { current: fallback, total: null };
+  return {
+    current: parseInt(match[1], 10) || fallback,
+    total: parseInt(match[2], 10) || null,
   };
-
-  [
-    "timeupdate",
-    "durationchange",
-    "loadedmetadata",
-    "seeked",
-    "ratechange",
-  ].forEach((eventName) => {
-    ap.audio.addEventListener(eventName, syncPosition);
-  });
-  ["play", "pause"].forEach((eventName) => {
-    ap.audio.addEventListener(eventName, syncState);
-  });
-  ap.audio.addEventListener("loadedmetadata", () => {
-    syncMediaSession();
-    scheduleMediaSessionSync(getCurrentAPlayerAudio(), 180);
-  });
 }
 
-function setupMediaSession() {
-  if (!mediaSessionControllerSupported()) return;
+function floatPageUp() {
+  if (isLocalMusicPageActive()) {
+    const bar = document.getElementById("localMusicPagePagination");
+    if (!bar) return;
+    const cur = parseInt(bar.dataset.currentPage, 10);
+    if (cur > 1) goToPage(cur - 1);
+  } else {
+    const { current } = getRenderedSearchPageInfo();
+    if (current > 1) goToPage(current - 1);
+  }
+}
 
-  bindMediaSessionAudioEvents();
+function floatPageDown() {
+  if (isLocalMusicPageActive()) {
+    const bar = document.getElementById("localMusicPagePagination");
+    if (!bar) return;
+    const cur = parseInt(bar.dataset.currentPage, 10);
+    const total = parseInt(bar.dataset.totalPages, 10);
+    if (cur < total) goToPage(cur + 1);
+  } else {
+    const { current, total } = getRenderedSearchPageInfo();
+    if (total && current < total) goToPage(current + 1);
+  }
+}
 
-  registerMediaSessionAction("play", () => {
-    if (!ap?.list?.audios?.length) return;
-    ap.play();
-  });
-  registerMediaSessionAction("pause", () => {
-    ap?.pause();
-  });
-  registerMediaSessionAction("stop", () => {
-    ap?.pause();
-    seekCurrentTrack(0);
-    syncMediaSession();
-  });
-  registerMediaSessionAction("previoustrack", () => {
-    switchTrackByOffset(-1);
-  });
-  registerMediaSessionAction("nexttrack", () => {
-    switchTrackByOffset(1);
-  });
-  registerMediaSessionAction("seekbackward", (details) => {
-    adjustCurrentTrackPosition(-(Number(details?.seekOffset) || 10));
-  });
-  registerMediaSessionAction("seekforward", (details) => {
-    adjustCurrentTrackPosition(Number(details?.seekOffset) || 10);
-  });
-  registerMediaSessionAction("seekto", (details) => {
-    if (!details || typeof details.seekTime !== "number") return;
-    seekCurrentTrack(details.seekTime);
-  });
+// 点击页数 → 输入框 → Enter/blur 跳转
+function floatPageNumClick() {
+  const numEl = document.getElementById("float-page-num");
+  if (!numEl) return;
+  if (isLocalMusicPageActive()) {
+    if (!document.getElementById("localMusicPagePagination")) return;
+  } else if (!getRenderedSearchPageInfo().total) {
+    return;
+  }
+  const currentVal = numEl.textContent.trim();
+  // 如果已经是输入框则忽略
+  if (numEl.tagName === "INPUT") return;
 
-  bindMediaKeyFallback();
-  syncMediaSession();
+  const input = document.createElement("input");
+  input.type = "number";
+  input.min = "1";
+  input.value = currentVal;
+  input.className = numEl.className;
+  input.id = numEl.id;
+  input.style.width = "40px";
+  input.style.height = "28px";
+  input.style.textAlign = "center";
+  input.style.fontSize = "13px";
+  input.style.fontWeight = "700";
+  input.style.color = "#10b981";
+  input.style.border = "2px solid #10b981";
+  input.style.borderRadius = "6px";
+  input.style.outline = "none";
+  input.style.background = "white";
+
+  let submitted = false;
+  const restore = () => {
+    if (input.parentNode) input.parentNode.replaceChild(numEl, input);
+  };
+  const jump = () => {
+    if (submitted) return;
+    submitted = true;
+    const val = parseInt(input.value, 10);
+    restore();
+    if (Number.isFinite(val) && val > 0) goToPage(val);
+  };
+
+  input.onkeydown = function(e) {
+    if (e.key === "Enter") {
+      e.preventDefault();
+      jump();
+    }
+    if (e.key === "Escape") {
+      submitted = true;
+      restore();
+    }
+  };
+  input.onblur = jump;
+
+  numEl.parentNode.replaceChild(input, numEl);
+  input.focus();
+  input.select();
 }
 
-let mediaKeysBound = false;
+let defaultDocumentTitle = document.title;
+let mediaSessionSyncTimer = 0;
+let mediaSessionSyncVersion = 0;
+const mediaSessionCoverCache = new Map();
 
-// bindMediaKeyFallback 监听 WebView 经 DOM 下发的媒体键（部分车机/头机会把方向盘
-// 媒体键作为 KeyboardEvent 送进网页），作为系统 MediaSession 之外的兜底切歌途径。
-function bindMediaKeyFallback() {
-  if (mediaKeysBound) return;
-  mediaKeysBound = true;
+function mediaSessionControllerSupported() {
+  return typeof navigator !== "undefined" && !!navigator.mediaSession;
+}
 
-  document.addEventListener("keydown", function (event) {
-    if (event.defaultPrevented || event.isComposing) return;
-    if (
-      typeof ap === "undefined" ||
-      !ap ||
-      !ap.list ||
-      !ap.list.audios ||
-      ap.list.audios.length === 0
-    )
-      return;
+function mediaSessionMetadataSupported() {
+  return (
+    mediaSessionControllerSupported() &&
+    typeof window.MediaMetadata === "function"
+  );
+}
 
-    switch (event.key) {
-      case "MediaTrackNext":
-        event.preventDefault();
-        switchTrackByOffset(1);
-        break;
-      case "MediaTrackPrevious":
-        event.preventDefault();
-        switchTrackByOffset(-1);
-        break;
-      case "MediaPlayPause":
-        event.preventDefault();
-        togglePlayback();
-        break;
-      case "MediaStop":
-        event.preventDefault();
-        if (ap.audio) ap.pause();
-        break;
-      default:
-        break;
-    }
-  });
+function getCurrentAPlayerAudio() {
+  if (!ap || !ap.list || !Array.isArray(ap.list.audios)) return null;
+  const index = ap.list.index;
+  if (typeof index !== "number" || index < 0) return null;
+  return ap.list.audios[index] || null;
 }
 
-const KaraokeLyrics = (() => {
-  const timeRe = /\[(\d+):(\d+)\.(\d{1,3})\]/g;
-  const fallbackLineDuration = 1200;
-  let container = null;
-  let body = null;
-  let currentKey = "";
-  let groups = [];
-  let activeIndex = -1;
-  let visible = false;
-  let animationFrame = 0;
+function getPlaybackCardID(audio) {
+  return String(audio?.original_id || audio?.custom_id || "").trim();
+}
 
-  function getProgress(ms, start, end) {
-    if (ms <= start) return 0;
-    if (!Number.isFinite(end) || end <= start) return 1;
-    return Math.max(0, Math.min(1, (ms - start) / (end - start)));
-  }
+function buildMediaSessionTrackKey(audio = getCurrentAPlayerAudio()) {
+  if (!audio) return "";
 
-  function getLyricHost() {
-    return (
-      document.querySelector(".aplayer.aplayer-fixed .aplayer-lrc") ||
-      document.querySelector(".aplayer-lrc") ||
-      document.querySelector(
-        ".aplayer.aplayer-fixed .aplayer-lrc .aplayer-lrc-contents",
-      ) ||
-      document.querySelector(".aplayer-lrc .aplayer-lrc-contents") ||
-      document.body
-    );
-  }
+  const customId = String(audio.custom_id || "").trim();
+  if (customId) return customId;
 
-  function ensureContainer() {
-    const host = getLyricHost();
-    if (!container) {
-      container = document.createElement("div");
-      container.id = "karaoke-lyrics";
-      container.className = "karaoke-lyrics";
-      container.hidden = true;
-      container.innerHTML = '<div class="karaoke-lyrics-body"></div>';
-      body = container.querySelector(".karaoke-lyrics-body");
-    }
-    if (container.parentElement !== host) {
-      host.appendChild(container);
-    }
-    container.dataset.fallback = host === document.body ? "true" : "false";
-    return container;
-  }
+  const source = String(audio.source || "").trim();
+  const name = String(audio.name || "").trim();
+  const artist = String(audio.artist || "").trim();
+  if (!source && !name && !artist) return "";
 
-  function stopLoop() {
-    if (animationFrame) {
-      cancelAnimationFrame(animationFrame);
-      animationFrame = 0;
-    }
-  }
+  return `${source}::${name}::${artist}`;
+}
 
-  function animationTick() {
-    animationFrame = 0;
-    update();
-    if (visible && ap?.audio && !ap.audio.paused) {
-      animationFrame = requestAnimationFrame(animationTick);
-    }
-  }
+function normalizeMediaSessionURL(value) {
+  const raw = String(value || "").trim();
+  if (!raw) return "";
 
-  function startLoop() {
-    if (animationFrame || !visible || !ap?.audio) return;
-    if (ap.audio.paused) {
-      update();
-      return;
-    }
-    animationFrame = requestAnimationFrame(animationTick);
+  try {
+    return new URL(raw, window.location.href).toString();
+  } catch (_) {
+    return raw;
   }
+}
 
-  function timeToMs(parts) {
-    const minute = Number(parts[1]) || 0;
-    const second = Number(parts[2]) || 0;
-    let ms = String(parts[3] || "0");
-    if (ms.length === 1) ms += "00";
-    if (ms.length === 2) ms += "0";
-    return minute * 60000 + second * 1000 + Number(ms.slice(0, 3));
-  }
+function isTransientMediaSessionURL(value) {
+  const lowered = String(value || "")
+    .trim()
+    .toLowerCase();
+  return lowered.startsWith("data:") || lowered.startsWith("blob:");
+}
 
-  function parseLine(line) {
-    timeRe.lastIndex = 0;
-    const matches = Array.from(line.matchAll(timeRe));
-    if (matches.length === 0) return null;
-    const start = timeToMs(matches[0]);
-    const words = [];
-    for (let i = 0; i < matches.length; i++) {
-      const textStart = matches[i].index + matches[i][0].length;
-      const textEnd =
-        i + 1 < matches.length ? matches[i + 1].index : line.length;
-      const text = line.slice(textStart, textEnd);
-      if (!text) continue;
-      words.push({
-        start: timeToMs(matches[i]),
-        end: i + 1 < matches.length ? timeToMs(matches[i + 1]) : null,
-        text,
-      });
-    }
-    const text = line.replace(timeRe, "").trim();
-    return { start, words, text, verbatim: matches.length > 1 };
-  }
+function extractURLFromBackgroundImage(value) {
+  const raw = String(value || "").trim();
+  if (!raw) return "";
 
-  function normalizeGroupWords(
-    sourceWords,
-    groupStart,
-    groupEnd,
-    fallbackText,
-  ) {
-    const words =
-      Array.isArray(sourceWords) && sourceWords.length > 0
-        ? sourceWords
-        : [{ text: fallbackText || "", start: groupStart, end: groupEnd }];
-    return words
-      .map((word, index) => {
-        const start = Number(word?.start);
-        const nextStart =
-          index + 1 < words.length ? Number(words[index + 1]?.start) : NaN;
-        let end = Number(word?.end);
-        const safeStart = Number.isFinite(start) ? start : groupStart;
-        if (!Number.isFinite(end) || end <= safeStart) {
-          end =
-            Number.isFinite(nextStart) && nextStart > safeStart
-              ? nextStart
-              : groupEnd;
-        }
-        return {
-          text: String(word?.text || ""),
-          start: safeStart,
-          end,
-        };
-      })
-      .filter((word) => word.text !== "");
-  }
+  const match = raw.match(/^url\((['"]?)(.*)\1\)$/i);
+  if (!match || !match[2]) return "";
+  return match[2].trim();
+}
 
-  function normalizeGroups(rawGroups) {
-    return (rawGroups || [])
-      .map((group, index, list) => {
-        const start = Number(group?.start || 0);
-        const nextStart =
-          index + 1 < list.length ? Number(list[index + 1]?.start || 0) : 0;
-        const end =
-          nextStart > start ? nextStart : start + fallbackLineDuration;
-        const lines = (group?.lines || []).map((line) => ({
-          ...line,
-          text: String(line?.text || ""),
-          words: normalizeGroupWords(line?.words, start, end, line?.text),
-        }));
-        return { start, end, lines };
-      })
-      .filter((group) => group.lines.some((line) => line.text));
+function buildMediaSessionCoverURL(audio = getCurrentAPlayerAudio()) {
+  const candidates = [];
+  const trackKey = buildMediaSessionTrackKey(audio);
+
+  if (audio) {
+    candidates.push({
+      url: audio.cover,
+      source: audio.source || "",
+    });
   }
 
-  function parse(raw) {
-    const map = new Map();
-    let hasVerbatim = false;
-    String(raw || "")
-      .split(/\r?\n/)
-      .forEach((rawLine) => {
-        const line = rawLine.trim();
-        if (!line || /^\[[A-Za-z]+:[^\]]*\]$/.test(line)) return;
-        const parsed = parseLine(line);
-        if (!parsed || !parsed.text) return;
-        hasVerbatim = hasVerbatim || parsed.verbatim;
-        if (!map.has(parsed.start)) {
-          map.set(parsed.start, { start: parsed.start, lines: [] });
-        }
-        map.get(parsed.start).lines.push(parsed);
-      });
-    const result = normalizeGroups(
-      Array.from(map.values()).sort((a, b) => a.start - b.start),
+  const currentId =
+    getPlaybackCardID(audio) || String(currentPlayingId || "").trim();
+  if (currentId) {
+    const card = Array.from(document.querySelectorAll(".song-card")).find(
+      (item) => item?.dataset?.id === currentId,
     );
-    const hasMultiLang = result.some((group) => group.lines.length > 1);
-    return {
-      type: hasVerbatim || hasMultiLang ? "karaoke" : "line",
-      groups: result,
-    };
+    if (card) {
+      const imgEl = card.querySelector(".cover-wrapper img");
+      if (imgEl && imgEl.src) {
+        candidates.unshift({
+          url: imgEl.src,
+          source: card.dataset.source || audio?.source || "",
+        });
+      }
+
+      if (card.dataset.cover) {
+        candidates.push({
+          url: card.dataset.cover,
+          source: card.dataset.source || audio?.source || "",
+        });
+      }
+    }
   }
 
-  function looksLikeRomajiLine(line) {
-    const text = String(line?.text || "").trim();
-    if (!text) return false;
-    const latinCount = (text.match(/[A-Za-z]/g) || []).length;
-    const cjkOrKanaCount = (text.match(/[\u3040-\u30ff\u3400-\u9fff]/g) || [])
-      .length;
-    return latinCount > 0 && latinCount >= cjkOrKanaCount;
+  const apPic = document.querySelector(".aplayer-pic");
+  if (apPic?.style?.backgroundImage) {
+    const playerCover = extractURLFromBackgroundImage(
+      apPic.style.backgroundImage,
+    );
+    if (playerCover) {
+      candidates.unshift({
+        url: playerCover,
+        source: audio?.source || "",
+      });
+    }
   }
 
-  function splitGroupLines(lines) {
-    const [orig, ...extras] = lines || [];
-    let roma = null;
-    let trans = null;
-    extras.forEach((line) => {
-      if (!roma && looksLikeRomajiLine(line)) {
-        roma = line;
-        return;
+  const fallbackCandidates = [];
+  const seen = new Set();
+  for (const candidate of candidates) {
+    const normalized = normalizeMediaSessionURL(candidate?.url);
+    if (!normalized || seen.has(normalized)) continue;
+    seen.add(normalized);
+
+    if (isTransientMediaSessionURL(normalized)) {
+      fallbackCandidates.push(normalized);
+      continue;
+    }
+
+    try {
+      const parsed = new URL(normalized, window.location.href);
+      if (
+        parsed.origin === window.location.origin &&
+        parsed.pathname === `${API_ROOT}/cover_proxy`
+      ) {
+        const resolved = parsed.toString();
+        if (trackKey) {
+          mediaSessionCoverCache.set(trackKey, resolved);
+        }
+        return resolved;
       }
-      if (!trans) {
-        trans = line;
-        return;
+
+      const proxy = new URL(`${API_ROOT}/cover_proxy`, window.location.href);
+      proxy.searchParams.set("url", parsed.toString());
+      const sourceValue = String(candidate?.source || "").trim();
+      if (sourceValue) {
+        proxy.searchParams.set("source", sourceValue);
       }
-      if (!roma) {
-        roma = line;
+      const resolved = proxy.toString();
+      if (trackKey) {
+        mediaSessionCoverCache.set(trackKey, resolved);
       }
-    });
-    return { orig, roma, trans };
+      return resolved;
+    } catch (_) {
+      if (trackKey) {
+        mediaSessionCoverCache.set(trackKey, normalized);
+      }
+      return normalized;
+    }
   }
 
-  function renderGroup(group, index) {
-    const { orig, roma, trans } = splitGroupLines(group.lines);
-    const renderWords = (words, fallbackStart, fallbackEnd) =>
-      (words || [])
-        .map((word) =>
-          [
-            `<span class="karaoke-word" data-start="${word.start || fallbackStart}" data-end="${word.end || fallbackEnd}" style="--karaoke-progress:0%;">`,
-            `<span class="karaoke-word-base">${escapeHTML(word.text)}</span>`,
-            `<span class="karaoke-word-fill">${escapeHTML(word.text)}</span>`,
-            "</span>",
-          ].join(""),
-        )
-        .join("");
-    const renderLine = (line, className, useWordProgress) => {
-      if (!line?.text) return "";
-      const content =
-        useWordProgress && Array.isArray(line.words) && line.words.length > 0
-          ? renderWords(line.words, group.start, group.end)
-          : escapeHTML(line.text);
-      return `<div class="${className}">${content}</div>`;
-    };
-    return [
-      `<div class="karaoke-group" data-index="${index}" data-start="${group.start}">`,
-      renderLine(orig, "karaoke-orig", true),
-      renderLine(roma, "karaoke-roma", !!roma?.verbatim),
-      renderLine(trans, "karaoke-trans", !!trans?.verbatim),
-      "</div>",
-    ].join("");
+  if (trackKey) {
+    const cached = mediaSessionCoverCache.get(trackKey);
+    if (cached) {
+      return cached;
+    }
   }
 
-  function show(nextGroups) {
-    ensureContainer();
-    groups = nextGroups || [];
-    activeIndex = -1;
-    body.innerHTML = groups.map(renderGroup).join("");
-    visible = groups.length > 0;
-    container.hidden = !visible;
-    document.body.classList.toggle("karaoke-lyrics-active", visible);
-    startLoop();
+  // Do not expose transient blob/data artwork URLs to MediaSession.
+  // They often expire quickly and cause a visible "flash then disappear" effect on MPRIS clients.
+  if (fallbackCandidates.length > 0 && trackKey) {
+    const cached = mediaSessionCoverCache.get(trackKey);
+    if (cached && !isTransientMediaSessionURL(cached)) {
+      return cached;
+    }
   }
 
-  function hide() {
-    ensureContainer();
-    stopLoop();
-    visible = false;
-    groups = [];
-    activeIndex = -1;
-    body.innerHTML = "";
-    container.hidden = true;
-    document.body.classList.remove("karaoke-lyrics-active");
+  return "";
+}
+
+function buildMediaSessionArtwork(audio = getCurrentAPlayerAudio()) {
+  const src = buildMediaSessionCoverURL(audio);
+  if (!src) return [];
+
+  return [{ src }];
+}
+
+function updateDocumentTitleForMedia(audio) {
+  if (!audio || !audio.name) {
+    document.title = defaultDocumentTitle;
+    return;
   }
 
-  async function load(audio) {
-    ensureContainer();
-    if (!floatingLyricsEnabled()) {
-      currentKey = "";
-      hide();
-      return;
-    }
-    const key = `${audio?.source || ""}:${audio?.custom_id || ""}:${audio?.raw_lrc || audio?.lrc || ""}`;
-    if (!audio || !key.trim()) {
-      currentKey = "";
-      hide();
+  const parts = [audio.name];
+  if (audio.artist) {
+    parts.push(audio.artist);
+  }
+  document.title = `${parts.join(" - ")} | music-dl`;
+}
+
+function shouldPreserveMediaSessionMetadata() {
+  return !!ap?.list?.audios?.length;
+}
+
+function updateMediaSessionMetadata(audio = getCurrentAPlayerAudio()) {
+  if (!mediaSessionControllerSupported()) return;
+
+  if (!audio) {
+    if (shouldPreserveMediaSessionMetadata()) {
       return;
     }
-    if (key === currentKey) {
-      update();
-      return;
+    if (mediaSessionMetadataSupported()) {
+      navigator.mediaSession.metadata = null;
     }
-    currentKey = key;
-    hide();
+    updateDocumentTitleForMedia(null);
+    return;
+  }
 
-    const url = audio.raw_lrc || audio.lrc;
-    if (!url) return;
-    try {
-      const response = await fetch(url);
-      if (!response.ok) return;
-      const raw = await response.text();
-      if (key !== currentKey) return;
-      if (!floatingLyricsEnabled()) {
-        currentKey = "";
-        hide();
-        return;
-      }
-      const parsed = parse(raw);
-      if (parsed.type !== "karaoke") {
-        hide();
-        return;
-      }
-      show(parsed.groups);
-      update();
-      startLoop();
-    } catch (_) {
-      hide();
-    }
+  if (!mediaSessionMetadataSupported()) {
+    updateDocumentTitleForMedia(audio);
+    return;
   }
 
-  function update() {
-    if (!floatingLyricsEnabled()) return;
-    if (!visible || !ap?.audio || groups.length === 0) return;
-    const ms = Math.max(0, (Number(ap.audio.currentTime) || 0) * 1000);
-    let nextIndex = -1;
-    for (let i = 0; i < groups.length; i++) {
-      if (ms >= groups[i].start) nextIndex = i;
-      else break;
-    }
-    if (nextIndex < 0) return;
+  const metadata = {
+    title: audio.name || "music-dl",
+    artist: audio.artist || "",
+  };
 
-    const active = body.querySelector(
-      `.karaoke-group[data-index="${nextIndex}"]`,
-    );
-    if (!active) return;
-    if (nextIndex !== activeIndex) {
-      body
-        .querySelectorAll(".karaoke-group.active")
-        .forEach((el) => el.classList.remove("active"));
-      active.classList.add("active");
-      const targetTop =
-        active.offsetTop -
-        Math.max(0, (body.clientHeight - active.clientHeight) / 2);
-      body.scrollTo({ top: Math.max(0, targetTop), behavior: "smooth" });
-      activeIndex = nextIndex;
-    }
-    body.querySelectorAll(".karaoke-word").forEach((word) => {
-      const start = Number(word.dataset.start || 0);
-      const end = Number(word.dataset.end || start + fallbackLineDuration);
-      const progress = getProgress(ms, start, end);
-      // 核心修复：100% 进度时直接加上安全距离，防止强迫症切缝
-      word.style.setProperty(
-        "--karaoke-progress",
-        progress === 1 ? "calc(100% + 8px)" : `${(progress * 100).toFixed(3)}%`,
-      );
-      word.classList.toggle("is-active", progress > 0 && progress < 1);
-    });
+  if (audio.album) {
+    metadata.album = audio.album;
   }
 
-  function handlePlayStateChange(isPlaying) {
-    if (!floatingLyricsEnabled()) {
-      hide();
-      return;
-    }
-    if (isPlaying) {
-      startLoop();
-      return;
-    }
-    update();
-    stopLoop();
+  const artwork = buildMediaSessionArtwork(audio);
+  if (artwork.length > 0) {
+    metadata.artwork = artwork;
   }
 
-  return { load, update, hide, parse, handlePlayStateChange };
-})();
+  navigator.mediaSession.metadata = new MediaMetadata(metadata);
+  updateDocumentTitleForMedia(audio);
+}
 
-window.KaraokeLyrics = KaraokeLyrics;
+function updateMediaSessionPlaybackState() {
+  if (!mediaSessionControllerSupported()) return;
 
-// APlayer Config
-const ap = new APlayer({
-  container: document.getElementById("aplayer"),
-  fixed: true,
-  autoplay: false,
-  theme: "#10b981",
-  loop: "all",
-  order: "list",
-  preload: "metadata",
-  volume: 0.7,
-  listFolded: false,
-  lrcType: 3,
-  audio: [],
-});
+  const audio = getCurrentAPlayerAudio();
+  if (!ap?.audio || !audio) {
+    navigator.mediaSession.playbackState = "none";
+    return;
+  }
 
-window.ap = ap;
+  navigator.mediaSession.playbackState = ap.audio.paused ? "paused" : "playing";
+}
 
-setupMediaSession();
-ap.audio.addEventListener("timeupdate", () => KaraokeLyrics.update());
-ap.audio.addEventListener("seeked", () => KaraokeLyrics.update());
-ap.audio.addEventListener("loadedmetadata", () =>
-  KaraokeLyrics.load(getCurrentAPlayerAudio()),
-);
-ap.audio.addEventListener("play", () =>
-  KaraokeLyrics.handlePlayStateChange(true),
-);
-ap.audio.addEventListener("pause", () =>
-  KaraokeLyrics.handlePlayStateChange(false),
-);
-ap.audio.addEventListener("ended", () =>
-  KaraokeLyrics.handlePlayStateChange(false),
-);
+function updateMediaSessionPositionState() {
+  if (!mediaSessionControllerSupported()) return;
+  if (
+    !ap?.audio ||
+    typeof navigator.mediaSession.setPositionState !== "function"
+  )
+    return;
 
-// === 播放模式切换按钮 ===
-(function initPlayModeToggle() {
-  const MODES = [
-    { order: "list", loop: "all", cls: "mode-order", label: "顺序播放" },
-    { order: "random", loop: "all", cls: "mode-random", label: "随机播放" },
-    { order: "list", loop: "one", cls: "mode-one", label: "单曲循环" },
-  ];
+  const duration = Number(ap.audio.duration);
+  const position = Number(ap.audio.currentTime);
+  const playbackRate = Number(ap.audio.playbackRate) || 1;
 
-  let modeIndex = parseInt(localStorage.getItem("musicdl:playmode") || "0", 10);
-  if (![0, 1, 2].includes(modeIndex)) modeIndex = 0;
+  if (!Number.isFinite(duration) || duration <= 0) return;
+  if (!Number.isFinite(position) || position < 0) return;
 
-  function applyMode(idx) {
-    const m = MODES[idx];
-    if (ap && ap.options) {
-      ap.options.order = m.order;
-      ap.options.loop = m.loop;
-    }
+  try {
+    navigator.mediaSession.setPositionState({
+      duration,
+      playbackRate,
+      position: Math.min(position, duration),
+    });
+  } catch (_) {}
+}
+
+function syncMediaSession(audio = getCurrentAPlayerAudio()) {
+  if (!mediaSessionControllerSupported()) return;
+  updateMediaSessionMetadata(audio);
+  updateMediaSessionPlaybackState();
+  updateMediaSessionPositionState();
+}
+
+function scheduleMediaSessionSync(
+  audio = getCurrentAPlayerAudio(),
+  delayMs = 160,
+) {
+  if (!mediaSessionControllerSupported()) return;
+
+  const expectedId = String(audio?.custom_id || "").trim();
+  const syncVersion = ++mediaSessionSyncVersion;
+
+  if (mediaSessionSyncTimer) {
+    clearTimeout(mediaSessionSyncTimer);
+  }
+
+  mediaSessionSyncTimer = setTimeout(
+    () => {
+      if (syncVersion !== mediaSessionSyncVersion) return;
+
+      const currentAudio = getCurrentAPlayerAudio();
+      if (
+        expectedId &&
+        currentAudio &&
+        String(currentAudio.custom_id || "").trim() !== expectedId
+      ) {
+        return;
+      }
+
+      syncMediaSession(currentAudio || audio);
+    },
+    Math.max(0, Number(delayMs) || 0),
+  );
+}
+
+function clearMediaSession() {
+  if (!mediaSessionControllerSupported()) return;
+  mediaSessionSyncVersion++;
+  if (mediaSessionSyncTimer) {
+    clearTimeout(mediaSessionSyncTimer);
+    mediaSessionSyncTimer = 0;
+  }
+  if (mediaSessionMetadataSupported()) {
+    navigator.mediaSession.metadata = null;
+  }
+  navigator.mediaSession.playbackState = "none";
+  updateDocumentTitleForMedia(null);
+}
+
+function switchTrackByOffset(offset) {
+  if (!ap?.list?.audios?.length) return;
+
+  const total = ap.list.audios.length;
+  const currentIndex =
+    typeof ap.list.index === "number" && ap.list.index >= 0 ? ap.list.index : 0;
+  const nextIndex = (currentIndex + offset + total) % total;
+
+  ap.list.switch(nextIndex);
+  ap.play();
+}
+
+function seekCurrentTrack(position) {
+  if (!ap?.audio) return;
+
+  const duration = Number(ap.audio.duration);
+  if (!Number.isFinite(duration) || duration <= 0) return;
+
+  const target = Math.max(0, Math.min(duration, Number(position) || 0));
+  try {
+    if (typeof ap.audio.fastSeek === "function") {
+      ap.audio.fastSeek(target);
+    } else {
+      ap.audio.currentTime = target;
+    }
+  } catch (_) {
+    ap.audio.currentTime = target;
+  }
+
+  updateMediaSessionPositionState();
+}
+
+function adjustCurrentTrackPosition(offset) {
+  if (!ap?.audio) return;
+  seekCurrentTrack((Number(ap.audio.currentTime) || 0) + offset);
+}
+
+function registerMediaSessionAction(action, handler) {
+  if (!mediaSessionControllerSupported()) return;
+  try {
+    navigator.mediaSession.setActionHandler(action, handler);
+  } catch (_) {}
+}
+
+function bindMediaSessionAudioEvents() {
+  if (!ap?.audio || ap.audio.dataset.mediaSessionBound === "1") return;
+
+  ap.audio.dataset.mediaSessionBound = "1";
+  const syncPosition = () => updateMediaSessionPositionState();
+  const syncState = () => {
+    updateMediaSessionPlaybackState();
+    updateMediaSessionPositionState();
+  };
+
+  [
+    "timeupdate",
+    "durationchange",
+    "loadedmetadata",
+    "seeked",
+    "ratechange",
+  ].forEach((eventName) => {
+    ap.audio.addEventListener(eventName, syncPosition);
+  });
+  ["play", "pause"].forEach((eventName) => {
+    ap.audio.addEventListener(eventName, syncState);
+  });
+  ap.audio.addEventListener("loadedmetadata", () => {
+    syncMediaSession();
+    scheduleMediaSessionSync(getCurrentAPlayerAudio(), 180);
+  });
+}
+
+function setupMediaSession() {
+  if (!mediaSessionControllerSupported()) return;
+
+  bindMediaSessionAudioEvents();
+
+  registerMediaSessionAction("play", () => {
+    if (!ap?.list?.audios?.length) return;
+    ap.play();
+  });
+  registerMediaSessionAction("pause", () => {
+    ap?.pause();
+  });
+  registerMediaSessionAction("stop", () => {
+    ap?.pause();
+    seekCurrentTrack(0);
+    syncMediaSession();
+  });
+  registerMediaSessionAction("previoustrack", () => {
+    switchTrackByOffset(-1);
+  });
+  registerMediaSessionAction("nexttrack", () => {
+    switchTrackByOffset(1);
+  });
+  registerMediaSessionAction("seekbackward", (details) => {
+    adjustCurrentTrackPosition(-(Number(details?.seekOffset) || 10));
+  });
+  registerMediaSessionAction("seekforward", (details) => {
+    adjustCurrentTrackPosition(Number(details?.seekOffset) || 10);
+  });
+  registerMediaSessionAction("seekto", (details) => {
+    if (!details || typeof details.seekTime !== "number") return;
+    seekCurrentTrack(details.seekTime);
+  });
+
+  bindMediaKeyFallback();
+  syncMediaSession();
+}
+
+let mediaKeysBound = false;
+
+// bindMediaKeyFallback 监听 WebView 经 DOM 下发的媒体键（部分车机/头机会把方向盘
+// 媒体键作为 KeyboardEvent 送进网页），作为系统 MediaSession 之外的兜底切歌途径。
+function bindMediaKeyFallback() {
+  if (mediaKeysBound) return;
+  mediaKeysBound = true;
+
+  document.addEventListener("keydown", function (event) {
+    if (event.defaultPrevented || event.isComposing) return;
+    if (
+      typeof ap === "undefined" ||
+      !ap ||
+      !ap.list ||
+      !ap.list.audios ||
+      ap.list.audios.length === 0
+    )
+      return;
+
+    switch (event.key) {
+      case "MediaTrackNext":
+        event.preventDefault();
+        switchTrackByOffset(1);
+        break;
+      case "MediaTrackPrevious":
+        event.preventDefault();
+        switchTrackByOffset(-1);
+        break;
+      case "MediaPlayPause":
+        event.preventDefault();
+        togglePlayback();
+        break;
+      case "MediaStop":
+        event.preventDefault();
+        if (ap.audio) ap.pause();
+        break;
+      default:
+        break;
+    }
+  });
+}
+
+const KaraokeLyrics = (() => {
+  const timeRe = /\[(\d+):(\d+)\.(\d{1,3})\]/g;
+  const fallbackLineDuration = 1200;
+  let container = null;
+  let body = null;
+  let currentKey = "";
+  let groups = [];
+  let activeIndex = -1;
+  let visible = false;
+  let animationFrame = 0;
+
+  function getProgress(ms, start, end) {
+    if (ms <= start) return 0;
+    if (!Number.isFinite(end) || end <= start) return 1;
+    return Math.max(0, Math.min(1, (ms - start) / (end - start)));
+  }
+
+  function getLyricHost() {
+    return (
+      document.querySelector(".aplayer.aplayer-fixed .aplayer-lrc") ||
+      document.querySelector(".aplayer-lrc") ||
+      document.querySelector(
+        ".aplayer.aplayer-fixed .aplayer-lrc .aplayer-lrc-contents",
+      ) ||
+      document.querySelector(".aplayer-lrc .aplayer-lrc-contents") ||
+      document.body
+    );
+  }
+
+  function ensureContainer() {
+    const host = getLyricHost();
+    if (!container) {
+      container = document.createElement("div");
+      container.id = "karaoke-lyrics";
+      container.className = "karaoke-lyrics";
+      container.hidden = true;
+      container.innerHTML = '<div class="karaoke-lyrics-body"></div>';
+      body = container.querySelector(".karaoke-lyrics-body");
+    }
+    if (container.parentElement !== host) {
+      host.appendChild(container);
+    }
+    container.dataset.fallback = host === document.body ? "true" : "false";
+    return container;
+  }
+
+  function stopLoop() {
+    if (animationFrame) {
+      cancelAnimationFrame(animationFrame);
+      animationFrame = 0;
+    }
+  }
+
+  function animationTick() {
+    animationFrame = 0;
+    update();
+    if (visible && ap?.audio && !ap.audio.paused) {
+      animationFrame = requestAnimationFrame(animationTick);
+    }
+  }
+
+  function startLoop() {
+    if (animationFrame || !visible || !ap?.audio) return;
+    if (ap.audio.paused) {
+      update();
+      return;
+    }
+    animationFrame = requestAnimationFrame(animationTick);
+  }
+
+  function timeToMs(parts) {
+    const minute = Number(parts[1]) || 0;
+    const second = Number(parts[2]) || 0;
+    let ms = String(parts[3] || "0");
+    if (ms.length === 1) ms += "00";
+    if (ms.length === 2) ms += "0";
+    return minute * 60000 + second * 1000 + Number(ms.slice(0, 3));
+  }
+
+  function parseLine(line) {
+    timeRe.lastIndex = 0;
+    const matches = Array.from(line.matchAll(timeRe));
+    if (matches.length === 0) return null;
+    const start = timeToMs(matches[0]);
+    const words = [];
+    for (let i = 0; i < matches.length; i++) {
+      const textStart = matches[i].index + matches[i][0].length;
+      const textEnd =
+        i + 1 < matches.length ? matches[i + 1].index : line.length;
+      const text = line.slice(textStart, textEnd);
+      if (!text) continue;
+      words.push({
+        start: timeToMs(matches[i]),
+        end: i + 1 < matches.length ? timeToMs(matches[i + 1]) : null,
+        text,
+      });
+    }
+    const text = line.replace(timeRe, "").trim();
+    return { start, words, text, verbatim: matches.length > 1 };
+  }
+
+  function normalizeGroupWords(
+    sourceWords,
+    groupStart,
+    groupEnd,
+    fallbackText,
+  ) {
+    const words =
+      Array.isArray(sourceWords) && sourceWords.length > 0
+        ? sourceWords
+        : [{ text: fallbackText || "", start: groupStart, end: groupEnd }];
+    return words
+      .map((word, index) => {
+        const start = Number(word?.start);
+        const nextStart =
+          index + 1 < words.length ? Number(words[index + 1]?.start) : NaN;
+        let end = Number(word?.end);
+        const safeStart = Number.isFinite(start) ? start : groupStart;
+        if (!Number.isFinite(end) || end <= safeStart) {
+          end =
+            Number.isFinite(nextStart) && nextStart > safeStart
+              ? nextStart
+              : groupEnd;
+        }
+        return {
+          text: String(word?.text || ""),
+          start: safeStart,
+          end,
+        };
+      })
+      .filter((word) => word.text !== "");
+  }
+
+  function normalizeGroups(rawGroups) {
+    return (rawGroups || [])
+      .map((group, index, list) => {
+        const start = Number(group?.start || 0);
+        const nextStart =
+          index + 1 < list.length ? Number(list[index + 1]?.start || 0) : 0;
+        const end =
+          nextStart > start ? nextStart : start + fallbackLineDuration;
+        const lines = (group?.lines || []).map((line) => ({
+          ...line,
+          text: String(line?.text || ""),
+          words: normalizeGroupWords(line?.words, start, end, line?.text),
+        }));
+        return { start, end, lines };
+      })
+      .filter((group) => group.lines.some((line) => line.text));
+  }
+
+  function parse(raw) {
+    const map = new Map();
+    let hasVerbatim = false;
+    String(raw || "")
+      .split(/\r?\n/)
+      .forEach((rawLine) => {
+        const line = rawLine.trim();
+        if (!line || /^\[[A-Za-z]+:[^\]]*\]$/.test(line)) return;
+        const parsed = parseLine(line);
+        if (!parsed || !parsed.text) return;
+        hasVerbatim = hasVerbatim || parsed.verbatim;
+        if (!map.has(parsed.start)) {
+          map.set(parsed.start, { start: parsed.start, lines: [] });
+        }
+        map.get(parsed.start).lines.push(parsed);
+      });
+    const result = normalizeGroups(
+      Array.from(map.values()).sort((a, b) => a.start - b.start),
+    );
+    const hasMultiLang = result.some((group) => group.lines.length > 1);
+    return {
+      type: hasVerbatim || hasMultiLang ? "karaoke" : "line",
+      groups: result,
+    };
+  }
+
+  function looksLikeRomajiLine(line) {
+    const text = String(line?.text || "").trim();
+    if (!text) return false;
+    const latinCount = (text.match(/[A-Za-z]/g) || []).length;
+    const cjkOrKanaCount = (text.match(/[\u3040-\u30ff\u3400-\u9fff]/g) || [])
+      .length;
+    return latinCount > 0 && latinCount >= cjkOrKanaCount;
+  }
+
+  function splitGroupLines(lines) {
+    const [orig, ...extras] = lines || [];
+    let roma = null;
+    let trans = null;
+    extras.forEach((line) => {
+      if (!roma && looksLikeRomajiLine(line)) {
+        roma = line;
+        return;
+      }
+      if (!trans) {
+        trans = line;
+        return;
+      }
+      if (!roma) {
+        roma = line;
+      }
+    });
+    return { orig, roma, trans };
+  }
+
+  function renderGroup(group, index) {
+    const { orig, roma, trans } = splitGroupLines(group.lines);
+    const renderWords = (words, fallbackStart, fallbackEnd) =>
+      (words || [])
+        .map((word) =>
+          [
+            `<span class="karaoke-word" data-start="${word.start || fallbackStart}" data-end="${word.end || fallbackEnd}" style="--karaoke-progress:0%;">`,
+            `<span class="karaoke-word-base">${escapeHTML(word.text)}</span>`,
+            `<span class="karaoke-word-fill">${escapeHTML(word.text)}</span>`,
+            "</span>",
+          ].join(""),
+        )
+        .join("");
+    const renderLine = (line, className, useWordProgress) => {
+      if (!line?.text) return "";
+      const content =
+        useWordProgress && Array.isArray(line.words) && line.words.length > 0
+          ? renderWords(line.words, group.start, group.end)
+          : escapeHTML(line.text);
+      return `<div class="${className}">${content}</div>`;
+    };
+    return [
+      `<div class="karaoke-group" data-index="${index}" data-start="${group.start}">`,
+      renderLine(orig, "karaoke-orig", true),
+      renderLine(roma, "karaoke-roma", !!roma?.verbatim),
+      renderLine(trans, "karaoke-trans", !!trans?.verbatim),
+      "</div>",
+    ].join("");
+  }
+
+  function show(nextGroups) {
+    ensureContainer();
+    groups = nextGroups || [];
+    activeIndex = -1;
+    body.innerHTML = groups.map(renderGroup).join("");
+    visible = groups.length > 0;
+    container.hidden = !visible;
+    document.body.classList.toggle("karaoke-lyrics-active", visible);
+    startLoop();
+  }
+
+  function hide() {
+    ensureContainer();
+    stopLoop();
+    visible = false;
+    groups = [];
+    activeIndex = -1;
+    body.innerHTML = "";
+    container.hidden = true;
+    document.body.classList.remove("karaoke-lyrics-active");
+  }
+
+  async function load(audio) {
+    ensureContainer();
+    if (!floatingLyricsEnabled()) {
+      currentKey = "";
+      hide();
+      return;
+    }
+    const key = `${audio?.source || ""}:${audio?.custom_id || ""}:${audio?.raw_lrc || audio?.lrc || ""}`;
+    if (!audio || !key.trim()) {
+      currentKey = "";
+      hide();
+      return;
+    }
+    if (key === currentKey) {
+      update();
+      return;
+    }
+    currentKey = key;
+    hide();
+
+    const url = audio.raw_lrc || audio.lrc;
+    if (!url) return;
+    try {
+      const response = await fetch(url);
+      if (!response.ok) return;
+      const raw = await response.text();
+      if (key !== currentKey) return;
+      if (!floatingLyricsEnabled()) {
+        currentKey = "";
+        hide();
+        return;
+      }
+      const parsed = parse(raw);
+      if (parsed.type !== "karaoke") {
+        hide();
+        return;
+      }
+      show(parsed.groups);
+      update();
+      startLoop();
+    } catch (_) {
+      hide();
+    }
+  }
+
+  function update() {
+    if (!floatingLyricsEnabled()) return;
+    if (!visible || !ap?.audio || groups.length === 0) return;
+    const ms = Math.max(0, (Number(ap.audio.currentTime) || 0) * 1000);
+    let nextIndex = -1;
+    for (let i = 0; i < groups.length; i++) {
+      if (ms >= groups[i].start) nextIndex = i;
+      else break;
+    }
+    if (nextIndex < 0) return;
+
+    const active = body.querySelector(
+      `.karaoke-group[data-index="${nextIndex}"]`,
+    );
+    if (!active) return;
+    if (nextIndex !== activeIndex) {
+      body
+        .querySelectorAll(".karaoke-group.active")
+        .forEach((el) => el.classList.remove("active"));
+      active.classList.add("active");
+      const targetTop =
+        active.offsetTop -
+        Math.max(0, (body.clientHeight - active.clientHeight) / 2);
+      body.scrollTo({ top: Math.max(0, targetTop), behavior: "smooth" });
+      activeIndex = nextIndex;
+    }
+    body.querySelectorAll(".karaoke-word").forEach((word) => {
+      const start = Number(word.dataset.start || 0);
+      const end = Number(word.dataset.end || start + fallbackLineDuration);
+      const progress = getProgress(ms, start, end);
+      // 核心修复：100% 进度时直接加上安全距离，防止强迫症切缝
+      word.style.setProperty(
+        "--karaoke-progress",
+        progress === 1 ? "calc(100% + 8px)" : `${(progress * 100).toFixed(3)}%`,
+      );
+      word.classList.toggle("is-active", progress > 0 && progress < 1);
+    });
+  }
+
+  function handlePlayStateChange(isPlaying) {
+    if (!floatingLyricsEnabled()) {
+      hide();
+      return;
+    }
+    if (isPlaying) {
+      startLoop();
+      return;
+    }
+    update();
+    stopLoop();
+  }
+
+  return { load, update, hide, parse, handlePlayStateChange };
+})();
+
+window.KaraokeLyrics = KaraokeLyrics;
+
+// === 共享播放会话：和其他设备 / TUI 同步队列和进度，支持在另一端接着播放 ===
+const PLAYBACK_SYNC_PROGRESS_INTERVAL = 15_000;
+const playbackSync = {
+  device: playbackDeviceID(),
+  revision: 0,
+  queueKey: "",
+  session: null,
+  lastProgress: 0,
+  // 应用远端状态时暂停本地上报，避免把刚收到的状态再发回去。
+  applying: false,
+  dismissedRevision: 0,
+};
+
+function playbackDeviceID() {
+  const storageKey = "musicdl:playback-device";
+  let id = localStorage.getItem(storageKey);
+  if (!id) {
+    const ua = navigator.userAgent || "";
+    let platform = "Web";
+    if (/Android/i.test(ua)) platform = "Android";
+    else if (/iPhone|iPad/i.test(ua)) platform = "iOS";
+    else if (/Windows/i.test(ua)) platform = "Windows";
+    else if (/Macintosh/i.test(ua)) platform = "Mac";
+    else if (/Linux/i.test(ua)) platform = "Linux";
+    id = `${platform}-${Math.random().toString(36).slice(2, 6)}`;
+    localStorage.setItem(storageKey, id);
+  }
+  return id;
+}
+
+function playbackQueueItem(audio) {
+  return {
+    id: String(audio?.custom_id || "").trim(),
+    source: String(audio?.source || "").trim(),
+    name: String(audio?.name || "").trim(),
+    artist: String(audio?.artist || "").trim(),
+    album: String(audio?.album || "").trim(),
+    cover: String(audio?.cover || "").trim(),
+    duration: parseInt(audio?.duration, 10) || 0,
+    extra:
+      typeof audio?.extra === "string"
+        ? audio.extra
+        : serializeSongExtra(audio?.extra),
+  };
+}
+
+function playbackItemKey(item) {
+  return item ? `${item.source}\u0000${item.id}` : "";
+}
+
+// 会话命令要带 X-Requested-With，sendBeacon 设不了请求头；keepalive 的 fetch
+// 在页面关闭时同样能发出去。
+function sendPlaybackCommand(command) {
+  return fetch(`${API_ROOT}/playback/session`, {
+    method: "POST",
+    headers: {
+      "Content-Type": "application/json",
+      Accept: "application/json",
+      "X-Requested-With": "XMLHttpRequest",
+    },
+    body: JSON.stringify({ device: playbackSync.device, ...command }),
+    keepalive: true,
+  })
+    .then((resp) => (resp.ok ? resp.json() : null))
+    .then((session) => {
+      if (session) rememberPlaybackSession(session);
+      return session;
+    })
+    .catch(() => null);
+}
+
+function rememberPlaybackSession(session) {
+  const revision = Number(session?.revision || 0);
+  if (revision < playbackSync.revision) return false;
+  playbackSync.revision = revision;
+  playbackSync.session = session;
+  return true;
+}
+
+function localPlaybackPosition(key) {
+  const pending = playHistorySession.pendingSeek;
+  if (pending && pending.key === key) return pending.at;
+  return Number(ap.audio.currentTime || 0);
+}
+
+// 本地开始播放时把整个播放列表和当前位置写入共享会话，会话随之归属本设备。
+async function publishPlaybackPlay() {
+  if (playbackSync.applying || !ap?.list) return;
+  const items = [];
+  let index = -1;
+  ap.list.audios.forEach((audio, i) => {
+    const item = playbackQueueItem(audio);
+    // 浏览器里打开的本地文件其他设备播放不了，不放进共享队列。
+    if (!item.id || !item.source || !item.name || item.source === "local-file") {
+      return;
+    }
+    if (i === ap.list.index) index = items.length;
+    items.push(item);
+  });
+  if (index < 0) return;
+  const position = localPlaybackPosition(playbackItemKey(items[index]));
+  const queueKey = items.map(playbackItemKey).join("\n");
+  if (queueKey !== playbackSync.queueKey) {
+    playbackSync.queueKey = queueKey;
+    await sendPlaybackCommand({
+      action: "replace",
+      items,
+      index,
+      position,
+      shuffle: ap.options.order === "random",
+      repeat: ap.options.loop === "one" ? "one" : "all",
+    });
+  }
+  playbackSync.lastProgress = Date.now();
+  sendPlaybackCommand({ action: "play", index, position });
+}
+
+function publishPlaybackPause() {
+  if (playbackSync.applying) return;
+  if (playbackSync.session?.device !== playbackSync.device) return;
+  if (!playbackSync.session?.playing) return;
+  sendPlaybackCommand({
+    action: "pause",
+    position: Number(ap.audio.currentTime || 0),
+  });
+}
+
+function trackPlaybackSyncProgress() {
+  if (ap.audio.paused || playbackSync.applying) return;
+  if (playbackSync.session?.device !== playbackSync.device) return;
+  const now = Date.now();
+  if (now - playbackSync.lastProgress < PLAYBACK_SYNC_PROGRESS_INTERVAL) return;
+  playbackSync.lastProgress = now;
+  sendPlaybackCommand({
+    action: "progress",
+    position: Number(ap.audio.currentTime || 0),
+  });
+}
+
+function applyRemotePlayback(action) {
+  playbackSync.applying = true;
+  try {
+    action();
+  } finally {
+    window.setTimeout(() => {
+      playbackSync.applying = false;
+    }, 500);
+  }
+}
+
+// 收到会话变化：其他设备接管时暂停本地播放；会话归属本设备时执行远程的
+// 暂停、继续、切歌和跳转。
+function handlePlaybackSessionUpdate(session) {
+  if (!rememberPlaybackSession(session)) return;
+  const current = session.queue?.[session.index];
+  const localAudio = getCurrentAPlayerAudio();
+  const localPlaying = !!localAudio && !ap.audio.paused;
+
+  if (session.device !== playbackSync.device) {
+    if (localPlaying && session.playing) {
+      applyRemotePlayback(() => ap.pause());
+      showToast(
+        "已在其他设备继续播放",
+        `${session.device}：${current?.name || ""}`,
+        "info",
+        4000,
+      );
+    }
+  } else if (current && localAudio) {
+    const key = playbackItemKey(current);
+    if (playbackItemKey(playbackQueueItem(localAudio)) !== key) {
+      const target = ap.list.audios.findIndex(
+        (audio) => playbackItemKey(playbackQueueItem(audio)) === key,
+      );
+      if (target >= 0) applyRemotePlayback(() => ap.list.switch(target));
+    } else if (Math.abs(ap.audio.currentTime - session.position) > 5) {
+      applyRemotePlayback(() => ap.seek(session.position));
+    }
+    if (session.playing && ap.audio.paused) {
+      applyRemotePlayback(() => ap.play());
+    } else if (!session.playing && !ap.audio.paused) {
+      applyRemotePlayback(() => ap.pause());
+    }
+  }
+  renderPlaybackHandoff();
+}
+
+function formatPlaybackClock(seconds) {
+  const total = Math.max(0, Math.floor(Number(seconds) || 0));
+  return `${Math.floor(total / 60)}:${String(total % 60).padStart(2, "0")}`;
+}
+
+// 其他设备有会话、本设备没在播放时显示“在此继续”的提示条。
+function renderPlaybackHandoff() {
+  const session = playbackSync.session;
+  const current = session?.queue?.[session.index];
+  let bar = document.getElementById("playback-handoff");
+  const localPlaying = !!getCurrentAPlayerAudio() && !ap.audio.paused;
+  const show =
+    current &&
+    !localPlaying &&
+    session.revision > playbackSync.dismissedRevision &&
+    (session.device !== playbackSync.device || !ap.list.audios.length);
+  if (!show) {
+    if (bar) bar.hidden = true;
+    return;
+  }
+  if (!bar) {
+    bar = document.createElement("div");
+    bar.id = "playback-handoff";
+    bar.className = "playback-handoff";
+    document.body.appendChild(bar);
+  }
+  const device = session.device || "其他设备";
+  const state = session.playing ? "正在播放" : "暂停于";
+  const title = current.artist
+    ? `${current.name} - ${current.artist}`
+    : current.name;
+  bar.innerHTML = `<i class="fa-solid fa-tower-broadcast" aria-hidden="true"></i>
+    <span class="playback-handoff-text">${escapeHTML(device)} ${state}：<strong>${escapeHTML(title)}</strong> · ${formatPlaybackClock(session.position)}</span>
+    <button type="button" class="btn-pill btn-pill-primary" onclick="takeOverPlaybackSession()">在此继续</button>
+    <button type="button" class="playback-handoff-close" aria-label="关闭" onclick="dismissPlaybackHandoff()">&times;</button>`;
+  bar.hidden = false;
+}
+
+function dismissPlaybackHandoff() {
+  playbackSync.dismissedRevision = playbackSync.revision;
+  renderPlaybackHandoff();
+}
+
+// 把共享会话的队列装进本地播放器，从推算出的位置接着播放。
+async function takeOverPlaybackSession() {
+  let session = null;
+  try {
+    const resp = await fetch(`${API_ROOT}/playback/session`, {
+      headers: { Accept: "application/json" },
+    });
+    if (resp.ok) session = await resp.json();
+  } catch (err) {
+    session = null;
+  }
+  const items = Array.isArray(session?.queue) ? session.queue : [];
+  const current = items[session?.index];
+  if (!current) {
+    showToast("没有可以接续的播放", "", "warning", 3000);
+    return;
+  }
+  rememberPlaybackSession(session);
+  playHistorySession.pendingSeek =
+    session.position > 1
+      ? { key: playbackItemKey(current), at: session.position }
+      : null;
+  playbackSync.queueKey = items.map(playbackItemKey).join("\n");
+  ap.list.clear();
+  ap.list.add(
+    items.map((item) => {
+      const lyricURLs = lyricURLsForPlayback(item);
+      return {
+        name: item.name,
+        artist: item.artist,
+        album: item.album,
+        url: buildStreamURL(
+          item.id,
+          item.source,
+          item.name,
+          item.artist,
+          item.album,
+          item.cover,
+          item.extra,
+        ),
+        cover: item.cover,
+        lrc: lyricURLs.line,
+        raw_lrc: lyricURLs.auto,
+        theme: "#10b981",
+        custom_id: item.id,
+        source: item.source,
+        duration: item.duration,
+        extra: item.extra,
+      };
+    }),
+  );
+  ap.list.switch(session.index);
+  ap.play();
+  renderPlaybackHandoff();
+}
+
+function connectPlaybackSessionEvents() {
+  if (!window.EventSource) return;
+  const events = new EventSource(`${API_ROOT}/playback/session/events`);
+  events.addEventListener("session", (event) => {
+    try {
+      handlePlaybackSessionUpdate(JSON.parse(event.data));
+    } catch (err) {
+      // 忽略无法解析的事件，EventSource 会自动重连。
+    }
+  });
+}
+
+// APlayer Config
+const ap = new APlayer({
+  container: document.getElementById("aplayer"),
+  fixed: true,
+  autoplay: false,
+  theme: "#10b981",
+  loop: "all",
+  order: "list",
+  preload: "metadata",
+  volume: 0.7,
+  listFolded: false,
+  lrcType: 3,
+  audio: [],
+});
+
+window.ap = ap;
+
+setupMediaSession();
+ap.audio.addEventListener("timeupdate", () => KaraokeLyrics.update());
+ap.audio.addEventListener("timeupdate", trackPlayHistoryProgress);
+ap.audio.addEventListener("loadedmetadata", applyPendingPlayHistorySeek);
+window.addEventListener("pagehide", () => {
+  if (playHistorySession.key) sendPlayHistoryEvent("progress");
+  if (!ap.audio.paused) publishPlaybackPause();
+});
+ap.audio.addEventListener("timeupdate", trackPlaybackSyncProgress);
+connectPlaybackSessionEvents();
+ap.audio.addEventListener("seeked", () => KaraokeLyrics.update());
+ap.audio.addEventListener("loadedmetadata", () =>
+  KaraokeLyrics.load(getCurrentAPlayerAudio()),
+);
+ap.audio.addEventListener("play", () =>
+  KaraokeLyrics.handlePlayStateChange(true),
+);
+ap.audio.addEventListener("pause", () =>
+  KaraokeLyrics.handlePlayStateChange(false),
+);
+ap.audio.addEventListener("ended", () =>
+  KaraokeLyrics.handlePlayStateChange(false),
+);
+
+// === 播放模式切换按钮 ===
+(function initPlayModeToggle() {
+  const MODES = [
+    { order: "list", loop: "all", cls: "mode-order", label: "顺序播放" },
+    { order: "random", loop: "all", cls: "mode-random", label: "随机播放" },
+    { order: "list", loop: "one", cls: "mode-one", label: "单曲循环" },
+  ];
+
+  let modeIndex = parseInt(localStorage.getItem("musicdl:playmode") || "0", 10);
+  if (![0, 1, 2].includes(modeIndex)) modeIndex = 0;
+
+  function applyMode(idx) {
+    const m = MODES[idx];
+    if (ap && ap.options) {
+      ap.options.order = m.order;
+      ap.options.loop = m.loop;
+    }
+  }
+
+  let attempts = 0;
+  function tryInject() {
+    attempts++;
+    // 尝试注入，最多重试 30 次 (约 3 秒)
+    if (attempts > 30) return;
+
+    const apFixed = document.querySelector(".aplayer.aplayer-fixed");
+    if (!apFixed) {
+      setTimeout(tryInject, 100);
+      return;
+    }
+
+    if (document.querySelector(".playmode-toggle-btn")) return;
+
+    const btn = document.createElement("button");
+    btn.type = "button";
+    btn.className = "playmode-toggle-btn";
+
+    function refresh() {
+      const m = MODES[modeIndex];
+      const icons = {
+        "mode-order": "fa-list-ol",
+        "mode-random": "fa-shuffle",
+        "mode-one": "fa-repeat",
+      };
+      btn.innerHTML = `<i class="fa-solid ${icons[m.cls]}" aria-hidden="true"></i>`;
+      btn.title = m.label;
+      btn.setAttribute("aria-label", m.label);
+    }
+
+    btn.addEventListener("click", (e) => {
+      e.stopPropagation();
+      e.preventDefault();
+      modeIndex = (modeIndex + 1) % MODES.length;
+      localStorage.setItem("musicdl:playmode", modeIndex);
+      applyMode(modeIndex);
+      refresh();
+    });
+
+    apFixed.appendChild(btn);
+    refresh();
+    if (modeIndex !== 0) applyMode(modeIndex);
+  }
+
+  // 等 APlayer 渲染出来后再注入
+  setTimeout(tryInject, 100);
+})();
+
+setTimeout(() => {
+  const apPic = document.querySelector(".aplayer-pic");
+  if (apPic) {
+    apPic.style.cursor = "pointer";
+    apPic.title = "点击打开详情/生成视频";
+
+    apPic.addEventListener(
+      "click",
+      (e) => {
+        if (
+          e.target.closest(".aplayer-button") ||
+          e.target.closest(".aplayer-play")
+        ) {
+          return;
+        }
+        e.stopPropagation();
+        e.preventDefault();
+
+        const idx = ap.list.index;
+        const audio = ap.list.audios[idx];
+
+        if (audio && audio.custom_id && window.VideoGen) {
+          window.VideoGen.open({
+            id: audio.custom_id,
+            source: audio.source || "netease",
+            name: audio.name,
+            artist: audio.artist,
+            album: audio.album || "",
+            cover: audio.cover,
+            duration: parseInt(audio.duration) || 0,
+            extra: audio.extra || "",
+          });
+        }
+      },
+      true,
+    );
+  }
+}, 800);
+
+ap.on("listswitch", (e) => {
+  const index = e.index;
+  const newAudio = ap.list.audios[index];
+  const playbackCardID = getPlaybackCardID(newAudio);
+  if (playbackCardID) {
+    currentPlayingId = playbackCardID;
+    window.currentPlayingId = currentPlayingId;
+    highlightCard(currentPlayingId);
+    syncAllPlayButtons();
+
+    const vgModal = document.getElementById("vg-modal");
+    if (vgModal && vgModal.classList.contains("active") && window.VideoGen) {
+      if (
+        !window.VideoGen.data ||
+        window.VideoGen.data.id !== newAudio.custom_id
+      ) {
+        window.VideoGen.open({
+          id: newAudio.custom_id,
+          source: newAudio.source || "netease",
+          name: newAudio.name,
+          artist: newAudio.artist,
+          album: newAudio.album || "",
+          cover: newAudio.cover,
+          duration: parseInt(newAudio.duration) || 0,
+          extra: newAudio.extra || "",
+        });
+      }
+    }
+  }
+  if (
+    newAudio &&
+    newAudio.source !== "local" &&
+    newAudio.source !== "local-file"
+  ) {
+    scheduleAutoCache(newAudio);
+  }
+  syncMediaSession(newAudio || getCurrentAPlayerAudio());
+  scheduleMediaSessionSync(newAudio || getCurrentAPlayerAudio(), 180);
+  KaraokeLyrics.load(newAudio || getCurrentAPlayerAudio());
+});
+
+ap.on("play", () => {
+  const idx = ap?.list?.index;
+  const audio = typeof idx === "number" ? ap.list.audios[idx] : null;
+  rememberPlaybackHistory(audio);
+  beginPlayHistorySession(audio);
+  publishPlaybackPlay();
+  renderPlaybackHandoff();
+  const playbackCardID = getPlaybackCardID(audio);
+  if (playbackCardID) {
+    currentPlayingId = playbackCardID;
+    window.currentPlayingId = currentPlayingId;
+    highlightCard(currentPlayingId);
+  }
+  syncAllPlayButtons();
+  syncMediaSession(audio || getCurrentAPlayerAudio());
+  scheduleMediaSessionSync(audio || getCurrentAPlayerAudio(), 180);
+  KaraokeLyrics.load(audio || getCurrentAPlayerAudio());
+
+  if (audio && audio.source !== "local" && audio.source !== "local-file") {
+    scheduleAutoCache(audio);
+  }
+
+  if (window.VideoGen && window.VideoGen.updatePlayBtnState) {
+    window.VideoGen.updatePlayBtnState(true);
+  }
+});
+
+ap.on("pause", () => {
+  publishPlaybackPause();
+  syncAllPlayButtons();
+  syncMediaSession();
+  if (window.VideoGen && window.VideoGen.updatePlayBtnState) {
+    window.VideoGen.updatePlayBtnState(false);
+  }
+});
+
+ap.on("ended", () => {
+  finishPlayHistorySession("complete");
+  currentPlayingId = null;
+  window.currentPlayingId = null;
+  highlightCard(null);
+  syncAllPlayButtons();
+  scheduleMediaSessionSync(getCurrentAPlayerAudio(), 180);
+  KaraokeLyrics.hide();
+});
+
+// ==========================================
+// 响度均衡、淡入淡出与无缝播放（Web Audio）
+// ==========================================
+
+// 开启响度均衡或切歌过渡后，ap.audio 经过 AudioContext 里的增益节点输出；
+// 一个元素只能建一次 MediaElementSource，视频生成器的频谱也从这里接入。
+// 过渡时用备用元素提前播放下一首，APlayer 切过去后把主元素跳到同一位置再换回。
+const GAPLESS_PRELOAD_SECONDS = 15;
+const PLAYBACK_HANDOFF_SECONDS = 0.08;
+const replayGainCache = new Map();
+let playerAudioGraph = null;
+let playbackSpare = null;
+let playbackTransition = null;
+
+function playbackEffectsEnabled() {
+  return (
+    webSettings.replayGainMode === "track" ||
+    webSettings.crossfadeSeconds > 0 ||
+    webSettings.gaplessPlayback
+  );
+}
+
+function getPlayerAudioGraph() {
+  if (playerAudioGraph) return playerAudioGraph;
+  const AudioContextClass = window.AudioContext || window.webkitAudioContext;
+  if (!AudioContextClass) return null;
+  const ctx = new AudioContextClass();
+  try {
+    const source = ctx.createMediaElementSource(ap.audio);
+    const gain = ctx.createGain();
+    source.connect(gain);
+    gain.connect(ctx.destination);
+    playerAudioGraph = { ctx, source, gain };
+  } catch (error) {
+    console.warn("Web Audio routing unavailable:", error);
+    ctx.close();
+    return null;
+  }
+  return playerAudioGraph;
+}
+window.getPlayerAudioGraph = getPlayerAudioGraph;
+
+// resumePlayerAudioGraph 在播放时（有用户手势）建好或恢复音频图。
+function resumePlayerAudioGraph() {
+  if (!playerAudioGraph && !playbackEffectsEnabled()) return null;
+  const graph = getPlayerAudioGraph();
+  if (graph && graph.ctx.state === "suspended") {
+    graph.ctx.resume().catch(() => {});
+  }
+  return graph;
+}
+
+function rampPlayerGain(node, value, seconds) {
+  const now = playerAudioGraph.ctx.currentTime;
+  node.gain.cancelScheduledValues(now);
+  node.gain.setValueAtTime(node.gain.value, now);
+  node.gain.linearRampToValueAtTime(value, now + Math.max(seconds, 0.01));
+}
+
+function replayGainKey(audio) {
+  if (!audio || !isLocalMusicSourceValue(audio.source)) return "";
+  return String(audio.custom_id || "").trim();
+}
+
+// replayGainFactor 返回 audio 的线性增益；未开启、未分析的歌曲和在线歌曲为 1。
+function replayGainFactor(audio) {
+  if (webSettings.replayGainMode !== "track") return 1;
+  const adjust = replayGainCache.get(replayGainKey(audio));
+  return typeof adjust === "number" ? Math.pow(10, adjust / 20) : 1;
+}
+
+async function loadReplayGains(audios) {
+  if (webSettings.replayGainMode !== "track") return;
+  const ids = [...new Set(audios.map(replayGainKey))].filter(
+    (id) => id && !replayGainCache.has(id),
+  );
+  if (ids.length === 0) return;
+  // 先占位，未分析的歌曲不再重复请求。
+  ids.forEach((id) => replayGainCache.set(id, null));
+  const params = new URLSearchParams();
+  ids.forEach((id) => params.append("ids", id));
+  try {
+    const response = await fetch(`${API_ROOT}/local_music/replaygain?${params}`);
+    const data = await response.json();
+    Object.entries(data?.tracks || {}).forEach(([id, track]) => {
+      replayGainCache.set(id, Number(track.adjust) || 0);
+    });
+  } catch (_) {
+    ids.forEach((id) => replayGainCache.delete(id));
+  }
+  applyReplayGain();
+}
+
+function applyReplayGain(seconds = 0.05) {
+  if (!playerAudioGraph || playbackTransition?.started) return;
+  rampPlayerGain(
+    playerAudioGraph.gain,
+    replayGainFactor(getCurrentAPlayerAudio()),
+    seconds,
+  );
+}
+
+// syncPlaybackEffectsSetting 让保存后的设置立即作用到正在播放的歌曲。
+function syncPlaybackEffectsSetting() {
+  if (!playerAudioGraph) return;
+  if (!webSettings.crossfadeSeconds && !webSettings.gaplessPlayback) {
+    cancelPlaybackTransition();
+  }
+  loadReplayGains([getCurrentAPlayerAudio()]);
+  applyReplayGain(0.2);
+}
+
+function getPlaybackSpare() {
+  if (playbackSpare) return playbackSpare;
+  const graph = getPlayerAudioGraph();
+  if (!graph) return null;
+  const el = new Audio();
+  el.preload = "auto";
+  const gain = graph.ctx.createGain();
+  gain.gain.value = 0;
+  graph.ctx.createMediaElementSource(el).connect(gain);
+  gain.connect(graph.ctx.destination);
+  playbackSpare = { el, gain };
+  return playbackSpare;
+}
+
+// 只有顺序播放 + 列表循环时下一首是确定的，随机和单曲循环不做过渡。
+function nextPlaybackTransitionIndex() {
+  if (ap.options?.order !== "list" || ap.options?.loop !== "all") return -1;
+  const count = ap.list.audios.length;
+  if (count < 2) return -1;
+  return (ap.list.index + 1) % count;
+}
+
+function preparePlaybackTransition() {
+  const index = nextPlaybackTransitionIndex();
+  if (index < 0) return null;
+  if (playbackTransition?.index === index) return playbackTransition;
+  const audio = ap.list.audios[index];
+  const spare = getPlaybackSpare();
+  if (!spare || !audio?.url) return null;
+  cancelPlaybackTransition();
+  spare.el.src = audio.url;
+  spare.el.load();
+  loadReplayGains([audio]);
+  playbackTransition = { index, audio, started: false, handoff: false };
+  return playbackTransition;
+}
+
+function startPlaybackTransition(fadeSeconds) {
+  const transition = preparePlaybackTransition();
+  if (!transition || transition.started) return;
+  transition.started = true;
+  const spare = playbackSpare;
+  const target = replayGainFactor(transition.audio);
+  spare.el.volume = ap.audio.volume;
+  spare.el.currentTime = 0;
+  spare.gain.gain.cancelScheduledValues(playerAudioGraph.ctx.currentTime);
+  spare.gain.gain.setValueAtTime(
+    fadeSeconds > 0 ? 0 : target,
+    playerAudioGraph.ctx.currentTime,
+  );
+  spare.el.play().catch(() => cancelPlaybackTransition());
+  rampPlayerGain(spare.gain, target, fadeSeconds);
+  rampPlayerGain(playerAudioGraph.gain, 0, fadeSeconds);
+}
+
+function cancelPlaybackTransition() {
+  const transition = playbackTransition;
+  if (!transition) return;
+  playbackTransition = null;
+  if (playbackSpare) {
+    playbackSpare.el.pause();
+    rampPlayerGain(playbackSpare.gain, 0, 0.05);
+  }
+  if (transition.started) applyReplayGain(0.2);
+}
+
+// finishPlaybackTransition 在主元素跳到备用元素的位置后换回主元素输出。
+function finishPlaybackTransition() {
+  const spare = playbackSpare;
+  playbackTransition = null;
+  rampPlayerGain(spare.gain, 0, PLAYBACK_HANDOFF_SECONDS);
+  applyReplayGain(PLAYBACK_HANDOFF_SECONDS);
+  setTimeout(() => {
+    if (!playbackTransition?.started) spare.el.pause();
+  }, PLAYBACK_HANDOFF_SECONDS * 1000 + 50);
+}
+
+ap.audio.addEventListener("timeupdate", () => {
+  if (!playerAudioGraph || playbackTransition?.handoff) return;
+  const fade = webSettings.crossfadeSeconds;
+  if (!fade && !webSettings.gaplessPlayback) {
+    cancelPlaybackTransition();
+    return;
+  }
+  const duration = ap.audio.duration;
+  if (!Number.isFinite(duration) || ap.audio.paused) return;
+  const remaining = duration - ap.audio.currentTime;
+  const limit = playbackTransition?.started
+    ? fade + 1
+    : fade + GAPLESS_PRELOAD_SECONDS + 1;
+  if (playbackTransition && remaining > limit) {
+    // 往回拖了进度。
+    cancelPlaybackTransition();
+    return;
+  }
+  if (remaining <= fade + GAPLESS_PRELOAD_SECONDS) {
+    preparePlaybackTransition();
+  }
+  if (fade > 0 && duration > fade * 2 && remaining <= fade) {
+    startPlaybackTransition(fade);
+  }
+});
+
+// 捕获阶段的监听先于 APlayer 自己的 ended 处理（它会立刻切到下一首），
+// 无缝播放在这里启动已缓冲好的下一首。
+ap.audio.addEventListener(
+  "ended",
+  () => {
+    if (playbackTransition && !playbackTransition.started) {
+      startPlaybackTransition(0);
+    }
+  },
+  { capture: true },
+);
+
+ap.audio.addEventListener("playing", () => {
+  const transition = playbackTransition;
+  if (!transition?.handoff || transition.seeking) return;
+  const position = playbackSpare.el.currentTime;
+  const seekable = ap.audio.seekable;
+  if (seekable.length === 0 || position > seekable.end(seekable.length - 1)) {
+    finishPlaybackTransition();
+    return;
+  }
+  transition.seeking = true;
+  ap.audio.currentTime = position;
+});
+
+ap.audio.addEventListener("seeked", () => {
+  if (playbackTransition?.seeking) finishPlaybackTransition();
+});
+
+ap.audio.addEventListener("error", () => cancelPlaybackTransition());
+
+ap.audio.addEventListener("volumechange", () => {
+  if (playbackSpare) playbackSpare.el.volume = ap.audio.volume;
+});
+
+ap.on("listswitch", (e) => {
+  const transition = playbackTransition;
+  if (transition?.started && e.index === transition.index) {
+    transition.handoff = true;
+  } else {
+    cancelPlaybackTransition();
+  }
+  if (!playerAudioGraph) return;
+  const next = nextPlaybackTransitionIndex();
+  loadReplayGains([ap.list.audios[e.index], ap.list.audios[next]]);
+  applyReplayGain();
+});
+
+ap.on("play", () => {
+  if (!resumePlayerAudioGraph()) return;
+  const next = nextPlaybackTransitionIndex();
+  loadReplayGains([getCurrentAPlayerAudio(), ap.list.audios[next]]);
+  applyReplayGain();
+});
+
+ap.on("pause", () => {
+  // 播完时也会先触发 pause，交给 ended 和切歌处理。
+  if (ap.audio.ended || playbackTransition?.handoff) return;
+  cancelPlaybackTransition();
+});
+
+function highlightCard(targetId) {
+  document
+    .querySelectorAll(".song-card")
+    .forEach((c) => c.classList.remove("playing-active"));
+  if (!targetId) return;
+  const target = document.querySelector(`.song-card[data-id="${targetId}"]`);
+  if (target) {
+    target.classList.add("playing-active");
+  }
+}
+
+function setPlayButtonState(card, isPlaying) {
+  if (!card) return;
+  const btn = card.querySelector(".btn-play");
+  if (!btn) return;
+  const icon = btn.querySelector("i");
+  if (!icon) return;
+
+  icon.classList.remove("fa-play", "fa-stop");
+  icon.classList.add(isPlaying ? "fa-stop" : "fa-play");
+  btn.title = isPlaying ? "停止" : "播放";
+}
+
+function syncAllPlayButtons() {
+  const isActuallyPlaying = ap?.audio && !ap.audio.paused;
+  document.querySelectorAll(".song-card").forEach((card) => {
+    const id = card.dataset.id;
+    const active =
+      isActuallyPlaying && currentPlayingId && id === currentPlayingId;
+    setPlayButtonState(card, active);
+  });
+}
+
+function formatDuration(seconds) {
+  const s = Number(seconds || 0);
+  if (!s || s <= 0) return "-";
+  const min = Math.floor(s / 60);
+  const sec = Math.floor(s % 60);
+  return `${String(min).padStart(2, "0")}:${String(sec).padStart(2, "0")}`;
+}
+
+function escapeHTML(value) {
+  return String(value ?? "").replace(/[&<>"']/g, (char) => {
+    switch (char) {
+      case "&":
+        return "&amp;";
+      case "<":
+        return "&lt;";
+      case ">":
+        return "&gt;";
+      case '"':
+        return "&quot;";
+      case "'":
+        return "&#39;";
+      default:
+        return char;
+    }
+  });
+}
+
+function containsEastAsianChar(value) {
+  return /[\u3040-\u30ff\u3400-\u9fff\uf900-\ufaff\uac00-\ud7af]/.test(
+    String(value || ""),
+  );
+}
+
+function trimArtistToken(value) {
+  return String(value || "")
+    .trim()
+    .replace(/^[-_·|\\/,，、；;&\s]+|[-_·|\\/,，、；;&\s]+$/g, "")
+    .trim();
+}
+
+function splitArtistTokens(artist) {
+  const rawArtist = String(artist || "").trim();
+  if (!rawArtist) return [];
+
+  let normalized = rawArtist.replace(
+    /\s+(feat(?:uring)?\.?|ft\.?|with|x)\s+/gi,
+    "|",
+  );
+  normalized = normalized.replace(/[、，；;]/g, "|");
+
+  if (containsEastAsianChar(rawArtist)) {
+    normalized = normalized.replace(/[\/&]/g, "|");
+  } else {
+    normalized = normalized.replace(/\s+(?:\/|&|\+)\s+/g, "|");
+  }
+
+  const tokens = [];
+  const seen = new Set();
+  normalized.split("|").forEach((item) => {
+    const token = trimArtistToken(item);
+    const key = token.toLowerCase().replace(/\s+/g, " ").trim();
+    if (!key || seen.has(key)) return;
+    seen.add(key);
+    tokens.push(token);
+  });
+
+  return tokens.length > 0 ? tokens : [rawArtist];
+}
+
+function buildArtistSearchURL(source, artist) {
+  const params = new URLSearchParams({
+    q: String(artist || ""),
+    type: "song",
+    exact_artist: String(artist || ""),
+    sources: String(source || ""),
+  });
+  return `${API_ROOT}/search?${params.toString()}`;
+}
+
+function buildAlbumDetailURL(source, albumId) {
+  const params = new URLSearchParams({
+    id: String(albumId || ""),
+    source: String(source || ""),
+  });
+  return `${API_ROOT}/album?${params.toString()}`;
+}
+
+function buildAlbumJumpURL(source, album, artist) {
+  const params = new URLSearchParams({
+    name: String(album || ""),
+    artist: String(artist || ""),
+    source: String(source || ""),
+  });
+  return `${API_ROOT}/album_jump?${params.toString()}`;
+}
+
+function getSongAlbumId(song) {
+  if (song && song.album_id) return String(song.album_id);
+  if (song && song.albumId) return String(song.albumId);
+  if (
+    song &&
+    song.extra &&
+    typeof song.extra === "object" &&
+    song.extra.album_id
+  ) {
+    return String(song.extra.album_id);
+  }
+  return "";
+}
+
+function renderArtistLineHTML(song) {
+  const artists = splitArtistTokens(song.artist || "");
+  const parts = ['<i class="fa-regular fa-user artist-icon"></i>'];
+
+  if (isLocalMusicSourceValue(song.source)) {
+    parts.push(`<span>${escapeHTML(song.artist || "未知歌手")}</span>`);
+    if (song.album) {
+      parts.push('<span class="meta-separator">&middot;</span>');
+      parts.push(
+        `<span class="meta-link-disabled album-link">${escapeHTML(song.album)}</span>`,
+      );
+    }
+    return parts.join("");
+  }
+
+  if (artists.length > 0) {
+    artists.forEach((artist, index) => {
+      if (index > 0) {
+        parts.push('<span class="meta-separator">/</span>');
+      }
+      parts.push(
+        `<a href="${buildArtistSearchURL(song.source, artist)}" class="meta-link artist-link">${escapeHTML(artist)}</a>`,
+      );
+    });
+  } else {
+    parts.push("<span>-</span>");
+  }
+
+  if (song.album) {
+    const albumId = getSongAlbumId(song);
+    parts.push('<span class="meta-separator">&middot;</span>');
+    const href = albumId
+      ? buildAlbumDetailURL(song.source, albumId)
+      : buildAlbumJumpURL(song.source, song.album, song.artist || "");
+    parts.push(
+      `<a href="${href}" class="meta-link album-link">${escapeHTML(song.album)}</a>`,
+    );
+  }
+
+  return parts.join("");
+}
+
+function syncLocalSongActionButtons(card, song) {
+  if (!card || !song || !isLocalMusicSourceValue(song.source)) return;
+  const actions = card.querySelector(".actions");
+  if (!actions) return;
+
+  const extra = normalizeSongExtra(song.extra);
+  const deleteBtn = actions.querySelector(".btn-delete-local");
+  const insertBefore = (node) => {
+    if (deleteBtn) {
+      actions.insertBefore(node, deleteBtn);
+    } else {
+      actions.appendChild(node);
+    }
+  };
+
+  if (extra.lyric && !actions.querySelector(".btn-lyric")) {
+    const lyric = document.createElement("a");
+    lyric.id = `lrc-${song.id}`;
+    lyric.className = "btn-circle btn-dl btn-lyric";
+    lyric.title = "下载歌词";
+    lyric.target = "_blank";
+    lyric.href = lyricURLsForSong(song).download;
+    lyric.innerHTML = '<i class="fa-solid fa-file-lines"></i>';
+    const coverBtn = actions.querySelector(".btn-cover");
+    if (coverBtn) {
+      actions.insertBefore(lyric, coverBtn);
+    } else {
+      insertBefore(lyric);
+    }
+  }
+
+  if (song.cover && !actions.querySelector(".btn-cover")) {
+    const cover = document.createElement("a");
+    cover.className = "btn-circle btn-dl btn-cover";
+    cover.title = "下载封面";
+    cover.target = "_blank";
+    cover.href = buildCoverDownloadURL(song);
+    cover.innerHTML = '<i class="fa-regular fa-image"></i>';
+    insertBefore(cover);
+  }
+}
+
+function updateCardWithSong(card, song, options = {}) {
+  if (!card || !song) return;
+  const oldId = card.dataset.id;
+  const shouldInspect = options.inspect !== false;
+
+  card.dataset.id = song.id;
+  card.dataset.source = song.source;
+  card.dataset.albumId = getSongAlbumId(song);
+  card.dataset.album = song.album || "";
+  card.dataset.duration = song.duration || 0;
+  card.dataset.sortDuration = String(parsePositiveInt(song.duration, 0));
+  card.dataset.name = song.name || card.dataset.name;
+  card.dataset.artist = song.artist || card.dataset.artist;
+  card.dataset.cover = song.cover || "";
+  card.dataset.extra = serializeSongExtra(song.extra);
+
+  const titleEl = card.querySelector(".song-info h3");
+  if (titleEl) {
+    if (song.link) {
+      titleEl.innerHTML = `<a href="${song.link}" target="_blank" class="song-title-link" title="打开原始链接">${song.name || ""}</a>`;
+    } else {
+      titleEl.textContent = song.name || "";
+    }
+  }
+
+  const artistLine = card.querySelector(".artist-line");
+  if (artistLine) {
+    artistLine.innerHTML = renderArtistLineHTML(song);
+  }
+
+  const sourceTag = card.querySelector(".tag-src, .tag-local");
+  if (sourceTag) {
+    const isLocal = isLocalMusicSourceValue(song.source);
+    sourceTag.textContent = isLocal ? "本地" : song.source;
+    sourceTag.classList.toggle("tag-local", isLocal);
+    sourceTag.classList.toggle("tag-src", !isLocal);
+  }
+
+  const durationTag = card.querySelector(".tag-duration");
+  if (durationTag) {
+    durationTag.textContent = formatDuration(song.duration);
+  }
+
+  const coverWrap = card.querySelector(".cover-wrapper");
+  if (coverWrap) {
+    let imgEl = coverWrap.querySelector("img");
+    if (!imgEl) {
+      imgEl = document.createElement("img");
+      coverWrap.innerHTML = "";
+      coverWrap.appendChild(imgEl);
+    }
+    imgEl.src = song.cover || "https://via.placeholder.com/150?text=Music";
+    imgEl.alt = song.name || "";
+
+    coverWrap.onclick = (e) => {
+      e.stopPropagation();
+      if (window.VideoGen) {
+        window.VideoGen.open({
+          id: card.dataset.id,
+          source: card.dataset.source,
+          name: card.dataset.name,
+          artist: card.dataset.artist,
+          album: card.dataset.album || "",
+          cover: imgEl.src,
+          duration: parseInt(card.dataset.duration) || 0,
+          extra: card.dataset.extra || "",
+        });
+      }
+    };
+  }
+
+  const dl = card.querySelector(".btn-download");
+  if (dl) {
+    dl.href = buildDownloadURL(
+      song.id,
+      song.source,
+      song.name,
+      song.artist,
+      song.album || "",
+      song.cover || "",
+      card.dataset.extra || "",
+    );
+    dl.id = `dl-${song.id}`;
+    dl.title = "保存到本地目录";
+  }
+
+  const browserDl = card.querySelector(".btn-browser-download");
+  if (browserDl) {
+    browserDl.href = buildBrowserDownloadURL(
+      song.id,
+      song.source,
+      song.name,
+      song.artist,
+      song.album || "",
+      song.cover || "",
+      card.dataset.extra || "",
+    );
+    browserDl.id = `browser-dl-${song.id}`;
+    browserDl.title = "浏览器下载";
+  }
+
+  const lrc = card.querySelector(".btn-lyric");
+  if (lrc) {
+    lrc.href = lyricURLsForSong(song).download;
+    lrc.id = `lrc-${song.id}`;
+  }
+
+  const coverBtn = card.querySelector(".btn-cover");
+  if (coverBtn) {
+    coverBtn.href = buildCoverDownloadURL(song);
+  }
+  syncLocalSongActionButtons(card, {
+    ...song,
+    extra: normalizeSongExtra(song.extra),
+  });
+
+  const sizeTag = card.querySelector('[id^="size-"]');
+  if (sizeTag) {
+    sizeTag.id = `size-${song.id}`;
+    sizeTag.className = "tag tag-loading";
+    sizeTag.innerHTML = '<i class="fa fa-spinner fa-spin"></i>';
+  }
+  const bitrateTag = card.querySelector('[id^="bitrate-"]');
+  if (bitrateTag) {
+    bitrateTag.id = `bitrate-${song.id}`;
+    bitrateTag.className = "tag tag-loading";
+    bitrateTag.innerHTML = '<i class="fa fa-circle-notch fa-spin"></i>';
+  }
+
+  if (currentPlayingId === oldId) {
+    currentPlayingId = song.id;
+  }
+
+  syncAllPlayButtons();
+  if (shouldInspect) {
+    queueInspectSong(card);
+  }
+  syncSongToAPlayer(oldId, song);
+  if (currentPlayingId === song.id) {
+    syncMediaSession();
+  }
+}
+
+function syncSongToAPlayer(oldId, newSong) {
+  if (!ap || !ap.list || !ap.list.audios) return;
+  const index = ap.list.audios.findIndex(
+    (a) => a.custom_id === oldId || a.original_id === oldId,
+  );
+  if (index !== -1) {
+    const audio = ap.list.audios[index];
+    audio.name = newSong.name;
+    audio.artist = newSong.artist;
+    audio.album = newSong.album || "";
+    audio.cover = newSong.cover;
+    audio.url = buildStreamURL(
+      newSong.id,
+      newSong.source,
+      newSong.name,
+      newSong.artist,
+      newSong.album || "",
+      newSong.cover || "",
+      serializeSongExtra(newSong.extra),
+    );
+    const lyricURLs = lyricURLsForPlayback(newSong);
+    audio.lrc = lyricURLs.line;
+    audio.raw_lrc = lyricURLs.auto;
+    audio.custom_id = newSong.id;
+    audio.original_id = newSong.id;
+    audio.source = newSong.source;
+    audio.duration = newSong.duration || 0;
+    audio.extra = serializeSongExtra(newSong.extra);
+
+    if (ap.list.index === index) {
+      ap.list.switch(index);
+      if (ap.audio.paused) {
+        ap.play();
+      }
+    }
+  }
+}
+
+function switchSource(btn, options = {}) {
+  const card = btn.closest(".song-card");
+  if (!card) return Promise.resolve(false);
+
+  const ds = card.dataset;
+  const name = ds.name || "";
+  const artist = ds.artist || "";
+  const source = ds.source || "";
+  if (!name || !source) return Promise.resolve(false);
+
+  btn.disabled = true;
+  btn.style.opacity = "0.6";
+
+  const duration = ds.duration || "";
+  const url = `${API_ROOT}/switch_source?name=${encodeURIComponent(name)}&artist=${encodeURIComponent(artist)}&source=${encodeURIComponent(source)}&duration=${encodeURIComponent(duration)}`;
+  return fetch(url)
+    .then((r) => (r.ok ? r.json() : Promise.reject()))
+    .then((song) => {
+      updateCardWithSong(card, song);
+      clearSongCardSelection(card, { deferToolbar: !!options.deferToolbar });
+      return true;
+    })
+    .catch(() => {
+      if (!options.silent) {
+        alert("换源失败，请稍后重试");
+      }
+      return false;
+    })
+    .finally(() => {
+      btn.disabled = false;
+      btn.style.opacity = "1";
+    });
+}
+
+function playAllAndJumpTo(btn) {
+  const currentCard = btn.closest(".song-card");
+  const allCards = Array.from(document.querySelectorAll(".song-card"));
+  const clickedIndex = allCards.indexOf(currentCard);
+
+  if (clickedIndex === -1) return;
+
+  const clickedId = currentCard.dataset.id;
+  const isActuallyPlaying = ap?.audio && !ap.audio.paused;
+
+  if (currentPlayingId && currentPlayingId === clickedId && isActuallyPlaying) {
+    ap.pause();
+    try {
+      ap.seek(0);
+    } catch (e) {}
+    currentPlayingId = null;
+    highlightCard(null);
+    syncAllPlayButtons();
+    syncMediaSession();
+    return;
+  }
+
+  ap.list.clear();
+  const playlist = [];
+
+  allCards.forEach((card) => {
+    const ds = card.dataset;
+    const song = songFromCard(card);
+    if (!song) return;
+    let coverUrl = ds.cover || "";
+    const imgEl = card.querySelector(".cover-wrapper img");
+    if (imgEl && imgEl.src) coverUrl = imgEl.src;
+
+    const localMatch = localMusicMatchCache[ds.id];
+    const useLocal = localMatch && localMatch.id;
+    const playbackSong = useLocal
+      ? {
+          id: localMatch.id,
+          source: "local",
+          name: localMatch.name || song.name,
+          artist: localMatch.artist || song.artist,
+          album: song.album || "",
+          cover: song.cover || "",
+          duration: song.duration || 0,
+          extra: song.extra || "",
+        }
+      : song;
+    const lyricURLs = lyricURLsForPlayback(playbackSong);
+    const streamUrl = buildStreamURL(
+      playbackSong.id,
+      playbackSong.source,
+      playbackSong.name,
+      playbackSong.artist,
+      playbackSong.album || "",
+      playbackSong.cover || "",
+      playbackSong.extra || "",
+    );
+
+    playlist.push({
+      name: playbackSong.name,
+      artist: playbackSong.artist,
+      album: playbackSong.album || "",
+      url: streamUrl,
+      cover: coverUrl,
+      lrc: lyricURLs.line,
+      raw_lrc: lyricURLs.auto,
+      theme: "#10b981",
+      custom_id: playbackSong.id,
+      original_id: ds.id,
+      source: playbackSong.source,
+      duration: playbackSong.duration || parsePositiveInt(ds.duration, 0),
+      extra: playbackSong.extra || "",
+    });
+  });
+
+  ap.list.add(playlist);
+  ap.list.switch(clickedIndex);
+  ap.play();
+
+  currentPlayingId = clickedId;
+  highlightCard(currentPlayingId);
+  syncAllPlayButtons();
+}
+
+window.playAllAndJumpToId = function (songId) {
+  const targetCard = document.querySelector(`.song-card[data-id="${songId}"]`);
+  if (targetCard) {
+    const btn = targetCard.querySelector(".btn-play");
+    if (btn) {
+      playAllAndJumpTo(btn);
+    }
+  }
+};
+
+let isBatchMode = false;
+let autoSwitchInvalidTimer = 0;
+let autoSwitchInvalidInProgress = false;
+let autoSwitchInvalidPending = false;
+let autoSwitchInvalidLastKey = "";
+
+function clearAutoSwitchInvalidTimer() {
+  if (autoSwitchInvalidTimer) {
+    window.clearTimeout(autoSwitchInvalidTimer);
+    autoSwitchInvalidTimer = 0;
   }
+  autoSwitchInvalidPending = false;
+}
 
-  let attempts = 0;
-  function tryInject() {
-    attempts++;
-    // 尝试注入，最多重试 30 次 (约 3 秒)
-    if (attempts > 30) return;
+function resetAutoSwitchInvalidState() {
+  clearAutoSwitchInvalidTimer();
+  autoSwitchInvalidInProgress = false;
+  autoSwitchInvalidLastKey = "";
+}
 
-    const apFixed = document.querySelector(".aplayer.aplayer-fixed");
-    if (!apFixed) {
-      setTimeout(tryInject, 100);
-      return;
-    }
+function isAutoSwitchInvalidSourcesEnabled() {
+  return webSettings.autoSwitchInvalidSources !== false;
+}
 
-    if (document.querySelector(".playmode-toggle-btn")) return;
+function hasPendingSongInspections() {
+  return !!document.querySelector('.song-card[data-inspect-pending="1"]');
+}
 
-    const btn = document.createElement("button");
-    btn.type = "button";
-    btn.className = "playmode-toggle-btn";
+function getInvalidSongCards(root = document) {
+  const seen = new Set();
+  const cards = [];
+  root.querySelectorAll(".tag-fail").forEach((tag) => {
+    const card = tag.closest(".song-card");
+    if (!card || seen.has(card) || isLocalMusicSourceValue(card.dataset.source))
+      return;
+    if (card.dataset.autoSwitchInvalidAttempted === "1" && root === document)
+      return;
+    seen.add(card);
+    cards.push(card);
+  });
+  return cards;
+}
 
-    function refresh() {
-      const m = MODES[modeIndex];
-      const icons = {
-        "mode-order": "fa-list-ol",
-        "mode-random": "fa-shuffle",
-        "mode-one": "fa-repeat",
-      };
-      btn.innerHTML = `<i class="fa-solid ${icons[m.cls]}" aria-hidden="true"></i>`;
-      btn.title = m.label;
-      btn.setAttribute("aria-label", m.label);
-    }
+function getManualInvalidSongCards(root = document) {
+  const seen = new Set();
+  const cards = [];
+  root.querySelectorAll(".tag-fail").forEach((tag) => {
+    const card = tag.closest(".song-card");
+    if (!card || seen.has(card) || isLocalMusicSourceValue(card.dataset.source))
+      return;
+    seen.add(card);
+    cards.push(card);
+  });
+  return cards;
+}
 
-    btn.addEventListener("click", (e) => {
-      e.stopPropagation();
-      e.preventDefault();
-      modeIndex = (modeIndex + 1) % MODES.length;
-      localStorage.setItem("musicdl:playmode", modeIndex);
-      applyMode(modeIndex);
-      refresh();
-    });
+function invalidSongCardsKey(cards) {
+  return cards
+    .map((card) => `${card.dataset.source || ""}:${card.dataset.id || ""}`)
+    .sort()
+    .join("|");
+}
 
-    apFixed.appendChild(btn);
-    refresh();
-    if (modeIndex !== 0) applyMode(modeIndex);
+function clearSongCardSelection(card, options = {}) {
+  if (!card) return false;
+  const cb = card.querySelector(".song-checkbox");
+  if (!cb || !cb.checked) return false;
+  cb.checked = false;
+  card.classList.remove("selected");
+  if (!options.deferToolbar) {
+    updateBatchToolbar();
   }
+  return true;
+}
 
-  // 等 APlayer 渲染出来后再注入
-  setTimeout(tryInject, 100);
-})();
-
-setTimeout(() => {
-  const apPic = document.querySelector(".aplayer-pic");
-  if (apPic) {
-    apPic.style.cursor = "pointer";
-    apPic.title = "点击打开详情/生成视频";
-
-    apPic.addEventListener(
-      "click",
-      (e) => {
-        if (
-          e.target.closest(".aplayer-button") ||
-          e.target.closest(".aplayer-play")
-        ) {
-          return;
-        }
-        e.stopPropagation();
-        e.preventDefault();
+function selectInvalidSongCards(options = {}) {
+  const invalidCards = Array.isArray(options.cards)
+    ? options.cards
+    : getManualInvalidSongCards();
+  if (invalidCards.length === 0) {
+    if (!options.silent) {
+      alert("当前列表中没有检测到无效歌曲");
+    }
+    return 0;
+  }
 
-        const idx = ap.list.index;
-        const audio = ap.list.audios[idx];
+  let changed = 0;
+  const invalidSet = new Set(invalidCards);
+  document.querySelectorAll(".song-checkbox").forEach((checkbox) => {
+    const card = checkbox.closest(".song-card");
+    const shouldCheck = invalidSet.has(card);
+    if (!shouldCheck && options.clearExisting !== false) {
+      if (checkbox.checked) {
+        checkbox.checked = false;
+        changed++;
+      }
+      return;
+    }
+    if (!shouldCheck) return;
+    if (!checkbox.checked) {
+      checkbox.checked = true;
+      changed++;
+    }
+  });
 
-        if (audio && audio.custom_id && window.VideoGen) {
-          window.VideoGen.open({
-            id: audio.custom_id,
-            source: audio.source || "netease",
-            name: audio.name,
-            artist: audio.artist,
-            album: audio.album || "",
-            cover: audio.cover,
-            duration: parseInt(audio.duration) || 0,
-            extra: audio.extra || "",
-          });
-        }
-      },
-      true,
-    );
+  updateBatchToolbar();
+  if (!options.silent && changed === 0) {
+    alert("无效歌曲已全部选中");
   }
-}, 800);
+  return invalidCards.length;
+}
 
-ap.on("listswitch", (e) => {
-  const index = e.index;
-  const newAudio = ap.list.audios[index];
-  const playbackCardID = getPlaybackCardID(newAudio);
-  if (playbackCardID) {
-    currentPlayingId = playbackCardID;
-    window.currentPlayingId = currentPlayingId;
-    highlightCard(currentPlayingId);
-    syncAllPlayButtons();
+function scheduleAutoSwitchInvalidSources(
+  delay = AUTO_SWITCH_INVALID_DELAY_MS,
+) {
+  if (!isAutoSwitchInvalidSourcesEnabled()) return;
+  if (autoSwitchInvalidInProgress) {
+    autoSwitchInvalidPending = true;
+    return;
+  }
+  if (autoSwitchInvalidTimer) {
+    window.clearTimeout(autoSwitchInvalidTimer);
+  }
+  autoSwitchInvalidTimer = window.setTimeout(
+    () => {
+      autoSwitchInvalidTimer = 0;
+      autoSwitchInvalidSources();
+    },
+    Math.max(0, delay),
+  );
+}
 
-    const vgModal = document.getElementById("vg-modal");
-    if (vgModal && vgModal.classList.contains("active") && window.VideoGen) {
-      if (
-        !window.VideoGen.data ||
-        window.VideoGen.data.id !== newAudio.custom_id
-      ) {
-        window.VideoGen.open({
-          id: newAudio.custom_id,
-          source: newAudio.source || "netease",
-          name: newAudio.name,
-          artist: newAudio.artist,
-          album: newAudio.album || "",
-          cover: newAudio.cover,
-          duration: parseInt(newAudio.duration) || 0,
-          extra: newAudio.extra || "",
-        });
-      }
-    }
+async function autoSwitchInvalidSources() {
+  if (!isAutoSwitchInvalidSourcesEnabled()) return false;
+  if (autoSwitchInvalidInProgress) {
+    autoSwitchInvalidPending = true;
+    return false;
   }
-  if (
-    newAudio &&
-    newAudio.source !== "local" &&
-    newAudio.source !== "local-file"
-  ) {
-    scheduleAutoCache(newAudio);
+  if (hasPendingSongInspections()) {
+    scheduleAutoSwitchInvalidSources(AUTO_SWITCH_INVALID_DELAY_MS);
+    return false;
   }
-  syncMediaSession(newAudio || getCurrentAPlayerAudio());
-  scheduleMediaSessionSync(newAudio || getCurrentAPlayerAudio(), 180);
-  KaraokeLyrics.load(newAudio || getCurrentAPlayerAudio());
-});
 
-ap.on("play", () => {
-  const idx = ap?.list?.index;
-  const audio = typeof idx === "number" ? ap.list.audios[idx] : null;
-  rememberPlaybackHistory(audio);
-  const playbackCardID = getPlaybackCardID(audio);
-  if (playbackCardID) {
-    currentPlayingId = playbackCardID;
-    window.currentPlayingId = currentPlayingId;
-    highlightCard(currentPlayingId);
+  const invalidCards = getInvalidSongCards();
+  if (invalidCards.length === 0) return false;
+
+  const key = invalidSongCardsKey(invalidCards);
+  if (key && key === autoSwitchInvalidLastKey) return false;
+  autoSwitchInvalidLastKey = key;
+  invalidCards.forEach((card) => {
+    card.dataset.autoSwitchInvalidAttempted = "1";
+  });
+
+  selectInvalidSongCards({ silent: true, cards: invalidCards });
+  autoSwitchInvalidInProgress = true;
+  showToast(
+    "自动换源",
+    `检测到 ${invalidCards.length} 首无效歌曲，正在批量换源`,
+    "info",
+    3500,
+  );
+  try {
+    await batchSwitchSource({
+      skipConfirm: true,
+      silent: true,
+      auto: true,
+      cards: invalidCards,
+    });
+    return true;
+  } finally {
+    autoSwitchInvalidInProgress = false;
+    if (autoSwitchInvalidPending) {
+      autoSwitchInvalidPending = false;
+      scheduleAutoSwitchInvalidSources();
+    }
   }
-  syncAllPlayButtons();
-  syncMediaSession(audio || getCurrentAPlayerAudio());
-  scheduleMediaSessionSync(audio || getCurrentAPlayerAudio(), 180);
-  KaraokeLyrics.load(audio || getCurrentAPlayerAudio());
+}
 
-  if (audio && audio.source !== "local" && audio.source !== "local-file") {
-    scheduleAutoCache(audio);
+function toggleBatchMode() {
+  isBatchMode = !isBatchMode;
+  document.body.classList.toggle("batch-mode", isBatchMode);
+  const btn = document.getElementById("btn-batch-toggle");
+  const toolbar = document.getElementById("batch-toolbar");
+
+  if (!btn || !toolbar) return;
+
+  if (isBatchMode) {
+    btn.innerHTML = '<i class="fa-solid fa-xmark"></i> 退出批量';
+    btn.classList.add("is-active");
+    btn.setAttribute("aria-pressed", "true");
+    toolbar.classList.add("active");
+  } else {
+    btn.innerHTML = '<i class="fa-solid fa-list-check"></i> 批量操作';
+    btn.classList.remove("is-active");
+    btn.setAttribute("aria-pressed", "false");
+    toolbar.classList.remove("active");
+    document
+      .querySelectorAll(".song-checkbox")
+      .forEach((cb) => (cb.checked = false));
+    updateBatchToolbar();
   }
+}
 
-  if (window.VideoGen && window.VideoGen.updatePlayBtnState) {
-    window.VideoGen.updatePlayBtnState(true);
+function updateBatchToolbar() {
+  const checkedBoxes = document.querySelectorAll(".song-checkbox:checked");
+  const count = checkedBoxes.length;
+  const selectAllCb = document.getElementById("select-all-checkbox");
+  const batchSwitch = document.getElementById("btn-batch-switch");
+  const batchDl = document.getElementById("btn-batch-dl");
+  const batchDeleteLocal = document.getElementById("btn-batch-delete-local");
+  const batchTagsLocal = document.getElementById("btn-batch-tags-local");
+  const batchIdentifyLocal = document.getElementById(
+    "btn-batch-identify-local",
+  );
+  const batchTranscodeLocal = document.getElementById(
+    "btn-batch-transcode-local",
+  );
+  const batchFavLocal = document.getElementById("btn-batch-fav-local");
+  const batchFav = document.getElementById("btn-batch-fav");
+  const batchRemoveCollection = document.getElementById(
+    "btn-batch-remove-collection",
+  );
+  const batchTransferCollection = document.getElementById(
+    "btn-batch-transfer-collection",
+  );
+
+  if (document.getElementById("selected-count")) {
+    document.getElementById("selected-count").textContent = count;
   }
-});
 
-ap.on("pause", () => {
-  syncAllPlayButtons();
-  syncMediaSession();
-  if (window.VideoGen && window.VideoGen.updatePlayBtnState) {
-    window.VideoGen.updatePlayBtnState(false);
+  const allBoxes = document.querySelectorAll(".song-checkbox");
+  if (allBoxes.length > 0 && selectAllCb) {
+    selectAllCb.checked = allBoxes.length === count;
   }
-});
 
-ap.on("ended", () => {
-  currentPlayingId = null;
-  window.currentPlayingId = null;
-  highlightCard(null);
-  syncAllPlayButtons();
-  scheduleMediaSessionSync(getCurrentAPlayerAudio(), 180);
-  KaraokeLyrics.hide();
-});
+  const selectedSongs = getSelectedSongs();
+  const nonLocalCount = selectedSongs.filter(
+    (song) => !isLocalMusicSourceValue(song.source),
+  ).length;
+  const localCount = selectedSongs.filter((song) =>
+    isLocalMusicSourceValue(song.source),
+  ).length;
+
+  if (count > 0) {
+    if (batchSwitch) batchSwitch.disabled = nonLocalCount === 0;
+    if (batchDl) batchDl.disabled = nonLocalCount === 0;
+    if (batchDeleteLocal) batchDeleteLocal.disabled = localCount === 0;
+    if (batchTagsLocal) batchTagsLocal.disabled = localCount === 0;
+    if (batchIdentifyLocal) batchIdentifyLocal.disabled = localCount === 0;
+    if (batchTranscodeLocal) batchTranscodeLocal.disabled = localCount === 0;
+    if (batchFavLocal) batchFavLocal.disabled = localCount === 0;
+    if (batchFav) batchFav.disabled = false;
+    if (batchRemoveCollection) batchRemoveCollection.disabled = false;
+    if (batchTransferCollection) batchTransferCollection.disabled = false;
+  } else {
+    if (batchSwitch) batchSwitch.disabled = true;
+    if (batchDl) batchDl.disabled = true;
+    if (batchDeleteLocal) batchDeleteLocal.disabled = true;
+    if (batchTagsLocal) batchTagsLocal.disabled = true;
+    if (batchIdentifyLocal) batchIdentifyLocal.disabled = true;
+    if (batchTranscodeLocal) batchTranscodeLocal.disabled = true;
+    if (batchFavLocal) batchFavLocal.disabled = true;
+    if (batchFav) batchFav.disabled = true;
+    if (batchRemoveCollection) batchRemoveCollection.disabled = true;
+    if (batchTransferCollection) batchTransferCollection.disabled = true;
+  }
 
-function highlightCard(targetId) {
   document
     .querySelectorAll(".song-card")
-    .forEach((c) => c.classList.remove("playing-active"));
-  if (!targetId) return;
-  const target = document.querySelector(`.song-card[data-id="${targetId}"]`);
-  if (target) {
-    target.classList.add("playing-active");
-  }
+    .forEach((card) => card.classList.remove("selected"));
+  checkedBoxes.forEach((cb) => {
+    cb.closest(".song-card").classList.add("selected");
+  });
 }
 
-function setPlayButtonState(card, isPlaying) {
-  if (!card) return;
-  const btn = card.querySelector(".btn-play");
-  if (!btn) return;
-  const icon = btn.querySelector("i");
-  if (!icon) return;
+function toggleSelectAll(mainCb) {
+  const checkboxes = document.querySelectorAll(".song-checkbox");
+  checkboxes.forEach((cb) => (cb.checked = mainCb.checked));
+  updateBatchToolbar();
+}
 
-  icon.classList.remove("fa-play", "fa-stop");
-  icon.classList.add(isPlaying ? "fa-stop" : "fa-play");
-  btn.title = isPlaying ? "停止" : "播放";
+function selectInvalidSongs() {
+  selectInvalidSongCards();
 }
 
-function syncAllPlayButtons() {
-  const isActuallyPlaying = ap?.audio && !ap.audio.paused;
-  document.querySelectorAll(".song-card").forEach((card) => {
-    const id = card.dataset.id;
-    const active =
-      isActuallyPlaying && currentPlayingId && id === currentPlayingId;
-    setPlayButtonState(card, active);
+function getSelectedSongs() {
+  const checkedBoxes = document.querySelectorAll(".song-checkbox:checked");
+  const songs = [];
+  checkedBoxes.forEach((cb) => {
+    const card = cb.closest(".song-card");
+    if (card) {
+      const song = songFromCard(card);
+      if (!song) return;
+      const lyricURLs = lyricURLsForSong(song);
+
+      songs.push({
+        id: song.id,
+        source: song.source,
+        name: song.name,
+        artist: song.artist,
+        duration: song.duration,
+        extra: song.extra,
+        url: buildDownloadURL(
+          song.id,
+          song.source,
+          song.name,
+          song.artist,
+          song.album || "",
+          song.cover || "",
+          song.extra || "",
+        ),
+        cover: song.cover,
+        lrc: lyricURLs.line,
+        raw_lrc: lyricURLs.auto,
+        theme: "#10b981",
+      });
+    }
   });
+  return songs;
 }
 
-function formatDuration(seconds) {
-  const s = Number(seconds || 0);
-  if (!s || s <= 0) return "-";
-  const min = Math.floor(s / 60);
-  const sec = Math.floor(s % 60);
-  return `${String(min).padStart(2, "0")}:${String(sec).padStart(2, "0")}`;
-}
+async function batchDownload() {
+  const selectedSongs = getSelectedSongs();
+  const songs = selectedSongs.filter(
+    (song) => !isLocalMusicSourceValue(song.source),
+  );
+  const skippedLocalCount = selectedSongs.length - songs.length;
+  if (selectedSongs.length === 0) return;
+  if (songs.length === 0) {
+    alert("选中的歌曲都是本地歌曲，无需批量下载。");
+    return;
+  }
+  const batchDl = document.getElementById("btn-batch-dl");
+  const batchSwitch = document.getElementById("btn-batch-switch");
+  const originalBatchDlHTML = batchDl ? batchDl.innerHTML : "";
 
-function escapeHTML(value) {
-  return String(value ?? "").replace(/[&<>"']/g, (char) => {
-    switch (char) {
-      case "&":
-        return "&amp;";
-      case "<":
-        return "&lt;";
-      case ">":
-        return "&gt;";
-      case '"':
-        return "&quot;";
-      case "'":
-        return "&#39;";
-      default:
-        return char;
+  if (!confirm(`确认批量下载 ${songs.length} 首歌曲？`)) return;
+
+  if (batchDl) {
+    batchDl.disabled = true;
+    batchDl.innerHTML = '<i class="fa-solid fa-spinner fa-spin"></i> 下载中';
+  }
+  if (batchSwitch) {
+    batchSwitch.disabled = true;
+  }
+
+  setDownloadRecordsButtonState("downloading");
+  const ignoredLocalText =
+    skippedLocalCount > 0 ? `，已忽略 ${skippedLocalCount} 首本地歌曲` : "";
+  const dismissBatchStartNotice = showToast(
+    "批量下载已开始",
+    `正在保存 ${songs.length} 首歌曲${ignoredLocalText}，进度与结果请查看右侧“下载记录”。`,
+    "info",
+    BATCH_DOWNLOAD_NOTICE_MS,
+  );
+
+  let success = 0;
+  let skipped = 0;
+  let failed = 0;
+  let warningCount = 0;
+  const failures = [];
+
+  try {
+    for (const song of songs) {
+      try {
+        const result = await requestLocalDownload(song.url);
+        if (result && result.skipped) {
+          skipped++;
+        } else {
+          success++;
+          if (result && result.warning) {
+            warningCount++;
+          }
+        }
+      } catch (_) {
+        failed++;
+        failures.push(formatBatchSongLabel(song));
+      }
+    }
+    reportBatchDownloadFinished({
+      total: songs.length,
+      success,
+      skipped,
+      failed,
+      failures,
+    });
+
+    const summary = [`成功 ${success}`, `跳过 ${skipped}`, `失败 ${failed}`];
+    if (skippedLocalCount > 0) {
+      summary.push(`忽略本地 ${skippedLocalCount}`);
+    }
+    if (warningCount > 0) {
+      summary.push(`降级提示 ${warningCount}`);
     }
-  });
-}
 
-function containsEastAsianChar(value) {
-  return /[\u3040-\u30ff\u3400-\u9fff\uf900-\ufaff\uac00-\ud7af]/.test(
-    String(value || ""),
-  );
+    dismissBatchStartNotice(true);
+    showToast(
+      failed > 0 ? "批量下载部分完成" : "批量下载完成",
+      `${summary.join("，")}。详情请查看右侧“下载记录”。`,
+      failed > 0 ? "warning" : "success",
+      BATCH_DOWNLOAD_NOTICE_MS,
+    );
+
+    setDownloadRecordsButtonState("updated");
+    if (await refreshOpenDownloadRecords()) {
+      setDownloadRecordsButtonState("idle");
+    }
+  } finally {
+    if (batchDl) {
+      batchDl.innerHTML = originalBatchDlHTML;
+    }
+    updateBatchToolbar();
+    if (
+      batchSwitch &&
+      document.querySelectorAll(".song-checkbox:checked").length === 0
+    ) {
+      batchSwitch.disabled = true;
+    }
+  }
 }
 
-function trimArtistToken(value) {
-  return String(value || "")
-    .trim()
-    .replace(/^[-_·|\\/,，、；;&\s]+|[-_·|\\/,，、；;&\s]+$/g, "")
-    .trim();
+// reportBatchDownloadFinished 通知后端批量下载已结束，用于触发 Webhook 推送。
+function reportBatchDownloadFinished(summary) {
+  fetch(API_ROOT + "/downloads/batch_finished", {
+    method: "POST",
+    headers: {
+      "Content-Type": "application/json",
+      "X-Requested-With": "XMLHttpRequest",
+    },
+    body: JSON.stringify(summary),
+  }).catch(() => {});
 }
 
-function splitArtistTokens(artist) {
-  const rawArtist = String(artist || "").trim();
-  if (!rawArtist) return [];
+async function deleteLocalMusic(trackId) {
+  const id = String(trackId || "").trim();
+  if (!id) {
+    throw new Error("缺少本地音乐 ID");
+  }
 
-  let normalized = rawArtist.replace(
-    /\s+(feat(?:uring)?\.?|ft\.?|with|x)\s+/gi,
-    "|",
+  const response = await fetch(
+    `${API_ROOT}/local_music?id=${encodeURIComponent(id)}`,
+    {
+      method: "DELETE",
+    },
   );
-  normalized = normalized.replace(/[、，；;]/g, "|");
-
-  if (containsEastAsianChar(rawArtist)) {
-    normalized = normalized.replace(/[\/&]/g, "|");
-  } else {
-    normalized = normalized.replace(/\s+(?:\/|&|\+)\s+/g, "|");
+  const payload = await response.json().catch(() => null);
+  if (!response.ok || !payload || payload.error) {
+    throw new Error((payload && payload.error) || "删除失败");
   }
+  return payload;
+}
 
-  const tokens = [];
-  const seen = new Set();
-  normalized.split("|").forEach((item) => {
-    const token = trimArtistToken(item);
-    const key = token.toLowerCase().replace(/\s+/g, " ").trim();
-    if (!key || seen.has(key)) return;
-    seen.add(key);
-    tokens.push(token);
-  });
+function confirmLocalMusicDeletion(songs) {
+  const items = Array.isArray(songs) ? songs : [];
+  if (items.length === 0) return false;
 
-  return tokens.length > 0 ? tokens : [rawArtist];
+  const names = items.slice(0, 5).map(formatBatchSongLabel).join("\n");
+  const more = items.length > 5 ? `\n...等 ${items.length} 首` : "";
+  const scope =
+    items.length === 1
+      ? `《${formatBatchSongLabel(items[0])}》`
+      : `${items.length} 首本地音乐`;
+  if (
+    !confirm(
+      `准备删除 ${scope}。\n删除后文件会从本地下载目录移除，且不可恢复。\n\n${names}${more}`,
+    )
+  ) {
+    return false;
+  }
+  return confirm(`再次确认：确定永久删除 ${scope} 吗？`);
 }
 
-function buildArtistSearchURL(source, artist) {
-  const params = new URLSearchParams({
-    q: String(artist || ""),
-    type: "song",
-    exact_artist: String(artist || ""),
-    sources: String(source || ""),
-  });
-  return `${API_ROOT}/search?${params.toString()}`;
+function stopDeletedLocalMusicPlayback(deletedIds) {
+  if (!currentPlayingId || !deletedIds || !deletedIds.has(currentPlayingId))
+    return;
+  try {
+    ap?.pause();
+    ap?.list?.clear();
+  } catch (_) {}
+  currentPlayingId = null;
+  highlightCard(null);
+  syncAllPlayButtons();
+  syncMediaSession();
 }
 
-function buildAlbumDetailURL(source, albumId) {
-  const params = new URLSearchParams({
-    id: String(albumId || ""),
-    source: String(source || ""),
-  });
-  return `${API_ROOT}/album?${params.toString()}`;
-}
+async function deleteLocalMusicFromButton(btn) {
+  const card = btn?.closest(".song-card");
+  const song = songFromCard(card);
+  if (!song || !isLocalMusicSourceValue(song.source)) return;
+  if (!confirmLocalMusicDeletion([song])) return;
 
-function buildAlbumJumpURL(source, album, artist) {
-  const params = new URLSearchParams({
-    name: String(album || ""),
-    artist: String(artist || ""),
-    source: String(source || ""),
-  });
-  return `${API_ROOT}/album_jump?${params.toString()}`;
-}
+  const originalHTML = btn.innerHTML;
+  btn.disabled = true;
+  btn.innerHTML = '<i class="fa-solid fa-spinner fa-spin"></i>';
 
-function getSongAlbumId(song) {
-  if (song && song.album_id) return String(song.album_id);
-  if (song && song.albumId) return String(song.albumId);
-  if (
-    song &&
-    song.extra &&
-    typeof song.extra === "object" &&
-    song.extra.album_id
-  ) {
-    return String(song.extra.album_id);
+  try {
+    await deleteLocalMusic(song.id);
+    stopDeletedLocalMusicPlayback(new Set([song.id]));
+    await refreshLocalMusicPageAfterMutation();
+  } catch (error) {
+    alert(error.message || "删除失败");
+  } finally {
+    if (btn.isConnected) {
+      btn.innerHTML = originalHTML;
+      btn.disabled = false;
+    }
   }
-  return "";
 }
 
-function renderArtistLineHTML(song) {
-  const artists = splitArtistTokens(song.artist || "");
-  const parts = ['<i class="fa-regular fa-user artist-icon"></i>'];
+async function batchDeleteLocalMusic() {
+  const songs = getSelectedSongs().filter((song) =>
+    isLocalMusicSourceValue(song.source),
+  );
+  if (songs.length === 0) return;
 
-  if (isLocalMusicSourceValue(song.source)) {
-    parts.push(`<span>${escapeHTML(song.artist || "未知歌手")}</span>`);
-    if (song.album) {
-      parts.push('<span class="meta-separator">&middot;</span>');
-      parts.push(
-        `<span class="meta-link-disabled album-link">${escapeHTML(song.album)}</span>`,
-      );
-    }
-    return parts.join("");
+  if (!confirmLocalMusicDeletion(songs)) {
+    return;
   }
 
-  if (artists.length > 0) {
-    artists.forEach((artist, index) => {
-      if (index > 0) {
-        parts.push('<span class="meta-separator">/</span>');
-      }
-      parts.push(
-        `<a href="${buildArtistSearchURL(song.source, artist)}" class="meta-link artist-link">${escapeHTML(artist)}</a>`,
-      );
-    });
-  } else {
-    parts.push("<span>-</span>");
+  const batchDelete = document.getElementById("btn-batch-delete-local");
+  const originalHTML = batchDelete ? batchDelete.innerHTML : "";
+  if (batchDelete) {
+    batchDelete.disabled = true;
+    batchDelete.innerHTML =
+      '<i class="fa-solid fa-spinner fa-spin"></i> 删除中';
   }
 
-  if (song.album) {
-    const albumId = getSongAlbumId(song);
-    parts.push('<span class="meta-separator">&middot;</span>');
-    const href = albumId
-      ? buildAlbumDetailURL(song.source, albumId)
-      : buildAlbumJumpURL(song.source, song.album, song.artist || "");
-    parts.push(
-      `<a href="${href}" class="meta-link album-link">${escapeHTML(song.album)}</a>`,
-    );
+  let success = 0;
+  const failures = [];
+  const deletedIds = new Set();
+
+  try {
+    for (const song of songs) {
+      try {
+        await deleteLocalMusic(song.id);
+        success++;
+        deletedIds.add(song.id);
+      } catch (error) {
+        failures.push({
+          song,
+          reason: error && error.message ? error.message : "删除失败",
+        });
+      }
+    }
+
+    stopDeletedLocalMusicPlayback(deletedIds);
+
+    let message = `批量删除完成，成功 ${success}/${songs.length}`;
+    message += buildBatchFailureMessage(failures, "失败");
+    alert(message);
+    await refreshLocalMusicPageAfterMutation();
+  } finally {
+    if (batchDelete) {
+      batchDelete.innerHTML = originalHTML;
+    }
+    updateBatchToolbar();
   }
+}
 
-  return parts.join("");
+// ==========================================
+// 本地音乐标签编辑
+// ==========================================
+
+const LOCAL_TAG_FIELD_LABELS = {
+  title: "标题",
+  artist: "歌手",
+  album: "专辑",
+  album_artist: "专辑艺术家",
+  track: "音轨号",
+  disc: "碟号",
+  year: "年份",
+  genre: "流派",
+  lyrics: "歌词",
+  cover: "封面",
+};
+
+let localMusicTagEditor = {
+  ids: [],
+  coverData: "",
+  coverMime: "",
+  removeCover: false,
+  editId: 0,
+};
+
+function localMusicTagInputs() {
+  return Array.from(
+    document.querySelectorAll("#localMusicTagModal [data-tag-field]"),
+  );
 }
 
-function syncLocalSongActionButtons(card, song) {
-  if (!card || !song || !isLocalMusicSourceValue(song.source)) return;
-  const actions = card.querySelector(".actions");
-  if (!actions) return;
+function setLocalMusicTagStatus(message, type = "") {
+  const status = document.getElementById("localTagStatus");
+  if (!status) return;
+  status.textContent = message || "";
+  status.className = `setting-inline-status${type ? ` ${type}` : ""}`;
+}
 
-  const extra = normalizeSongExtra(song.extra);
-  const deleteBtn = actions.querySelector(".btn-delete-local");
-  const insertBefore = (node) => {
-    if (deleteBtn) {
-      actions.insertBefore(node, deleteBtn);
-    } else {
-      actions.appendChild(node);
-    }
-  };
+function setLocalMusicTagCoverPreview(src) {
+  const img = document.getElementById("localTagCoverPreview");
+  if (!img) return;
+  img.hidden = !src;
+  if (src) img.src = src;
+  else img.removeAttribute("src");
+}
 
-  if (extra.lyric && !actions.querySelector(".btn-lyric")) {
-    const lyric = document.createElement("a");
-    lyric.id = `lrc-${song.id}`;
-    lyric.className = "btn-circle btn-dl btn-lyric";
-    lyric.title = "下载歌词";
-    lyric.target = "_blank";
-    lyric.href = lyricURLsForSong(song).download;
-    lyric.innerHTML = '<i class="fa-solid fa-file-lines"></i>';
-    const coverBtn = actions.querySelector(".btn-cover");
-    if (coverBtn) {
-      actions.insertBefore(lyric, coverBtn);
-    } else {
-      insertBefore(lyric);
-    }
-  }
+function openLocalMusicTagEditorFromButton(btn) {
+  const song = songFromCard(btn?.closest(".song-card"));
+  if (!song || !isLocalMusicSourceValue(song.source)) return;
+  openLocalMusicTagEditor([song]);
+}
 
-  if (song.cover && !actions.querySelector(".btn-cover")) {
-    const cover = document.createElement("a");
-    cover.className = "btn-circle btn-dl btn-cover";
-    cover.title = "下载封面";
-    cover.target = "_blank";
-    cover.href = buildCoverDownloadURL(song);
-    cover.innerHTML = '<i class="fa-regular fa-image"></i>';
-    insertBefore(cover);
-  }
+function openLocalMusicTagEditorForSelection() {
+  const songs = getSelectedSongs().filter((song) =>
+    isLocalMusicSourceValue(song.source),
+  );
+  if (songs.length === 0) return;
+  openLocalMusicTagEditor(songs);
 }
 
-function updateCardWithSong(card, song, options = {}) {
-  if (!card || !song) return;
-  const oldId = card.dataset.id;
-  const shouldInspect = options.inspect !== false;
+async function openLocalMusicTagEditor(songs) {
+  const modal = document.getElementById("localMusicTagModal");
+  if (!modal || !Array.isArray(songs) || songs.length === 0) return;
 
-  card.dataset.id = song.id;
-  card.dataset.source = song.source;
-  card.dataset.albumId = getSongAlbumId(song);
-  card.dataset.album = song.album || "";
-  card.dataset.duration = song.duration || 0;
-  card.dataset.sortDuration = String(parsePositiveInt(song.duration, 0));
-  card.dataset.name = song.name || card.dataset.name;
-  card.dataset.artist = song.artist || card.dataset.artist;
-  card.dataset.cover = song.cover || "";
-  card.dataset.extra = serializeSongExtra(song.extra);
+  const single = songs.length === 1;
+  localMusicTagEditor = {
+    ids: songs.map((song) => song.id),
+    coverData: "",
+    coverMime: "",
+    removeCover: false,
+    editId: 0,
+  };
+  localMusicTagInputs().forEach((input) => {
+    input.value = "";
+    input.classList.remove("is-dirty");
+    input.placeholder = single ? "" : "保持不变";
+    input.oninput = () => input.classList.add("is-dirty");
+  });
+  const removeCover = document.getElementById("localTagRemoveCover");
+  if (removeCover) removeCover.checked = false;
+  const preview = document.getElementById("localTagPreview");
+  if (preview) {
+    preview.hidden = true;
+    preview.innerHTML = "";
+  }
+  const undoBtn = document.getElementById("localTagUndoBtn");
+  if (undoBtn) undoBtn.hidden = true;
+  setLocalMusicTagCoverPreview("");
+  document.getElementById("localTagTitle").textContent = single
+    ? "编辑标签"
+    : "批量编辑标签";
+  document.getElementById("localTagSummary").textContent = single
+    ? formatBatchSongLabel(songs[0])
+    : `已选 ${songs.length} 首，只会写入修改过的字段，留空的字段保持不变`;
+  setLocalMusicTagStatus(single ? "正在读取标签..." : "");
+  modal.style.display = "flex";
 
-  const titleEl = card.querySelector(".song-info h3");
-  if (titleEl) {
-    if (song.link) {
-      titleEl.innerHTML = `<a href="${song.link}" target="_blank" class="song-title-link" title="打开原始链接">${song.name || ""}</a>`;
-    } else {
-      titleEl.textContent = song.name || "";
+  if (!single) return;
+  try {
+    const response = await fetch(
+      `${API_ROOT}/local_music/tags?id=${encodeURIComponent(songs[0].id)}`,
+    );
+    const payload = await response.json().catch(() => null);
+    if (!response.ok || !payload || payload.error) {
+      throw new Error((payload && payload.error) || "读取标签失败");
+    }
+    const tags = payload.tags || {};
+    localMusicTagInputs().forEach((input) => {
+      input.value = tags[input.dataset.tagField] || "";
+    });
+    if (payload.has_cover) {
+      setLocalMusicTagCoverPreview(`${payload.cover}&t=${Date.now()}`);
     }
+    setLocalMusicTagStatus(
+      payload.writable ? "" : "该文件所在曲库为只读或格式不支持写入标签",
+      payload.writable ? "" : "error",
+    );
+  } catch (error) {
+    setLocalMusicTagStatus(error.message || "读取标签失败", "error");
   }
+}
 
-  const artistLine = card.querySelector(".artist-line");
-  if (artistLine) {
-    artistLine.innerHTML = renderArtistLineHTML(song);
-  }
+function closeLocalMusicTagEditor() {
+  const modal = document.getElementById("localMusicTagModal");
+  if (modal) modal.style.display = "none";
+}
 
-  const sourceTag = card.querySelector(".tag-src, .tag-local");
-  if (sourceTag) {
-    const isLocal = isLocalMusicSourceValue(song.source);
-    sourceTag.textContent = isLocal ? "本地" : song.source;
-    sourceTag.classList.toggle("tag-local", isLocal);
-    sourceTag.classList.toggle("tag-src", !isLocal);
-  }
+function pickLocalMusicTagCover(input) {
+  const file = input?.files?.[0];
+  if (!file) return;
+  const reader = new FileReader();
+  reader.onload = () => {
+    localMusicTagEditor.coverData = String(reader.result || "");
+    localMusicTagEditor.coverMime = file.type || "";
+    localMusicTagEditor.removeCover = false;
+    const removeCover = document.getElementById("localTagRemoveCover");
+    if (removeCover) removeCover.checked = false;
+    setLocalMusicTagCoverPreview(localMusicTagEditor.coverData);
+  };
+  reader.readAsDataURL(file);
+  input.value = "";
+}
 
-  const durationTag = card.querySelector(".tag-duration");
-  if (durationTag) {
-    durationTag.textContent = formatDuration(song.duration);
+function markLocalMusicTagCoverRemoved(checkbox) {
+  localMusicTagEditor.removeCover = !!checkbox?.checked;
+  if (localMusicTagEditor.removeCover) {
+    localMusicTagEditor.coverData = "";
+    localMusicTagEditor.coverMime = "";
+    setLocalMusicTagCoverPreview("");
   }
+}
 
-  const coverWrap = card.querySelector(".cover-wrapper");
-  if (coverWrap) {
-    let imgEl = coverWrap.querySelector("img");
-    if (!imgEl) {
-      imgEl = document.createElement("img");
-      coverWrap.innerHTML = "";
-      coverWrap.appendChild(imgEl);
+function collectLocalMusicTagRequest() {
+  const changes = {};
+  localMusicTagInputs().forEach((input) => {
+    if (input.classList.contains("is-dirty")) {
+      changes[input.dataset.tagField] = input.value.trim();
     }
-    imgEl.src = song.cover || "https://via.placeholder.com/150?text=Music";
-    imgEl.alt = song.name || "";
+  });
+  return {
+    ids: localMusicTagEditor.ids,
+    changes,
+    cover_data: localMusicTagEditor.coverData,
+    cover_mime: localMusicTagEditor.coverMime,
+    remove_cover: localMusicTagEditor.removeCover,
+  };
+}
 
-    coverWrap.onclick = (e) => {
-      e.stopPropagation();
-      if (window.VideoGen) {
-        window.VideoGen.open({
-          id: card.dataset.id,
-          source: card.dataset.source,
-          name: card.dataset.name,
-          artist: card.dataset.artist,
-          album: card.dataset.album || "",
-          cover: imgEl.src,
-          duration: parseInt(card.dataset.duration) || 0,
-          extra: card.dataset.extra || "",
-        });
-      }
-    };
+async function postLocalMusicTagRequest(path, body) {
+  const response = await fetch(`${API_ROOT}${path}`, {
+    method: "POST",
+    headers: {
+      "Content-Type": "application/json",
+      "X-Requested-With": "XMLHttpRequest",
+    },
+    body: JSON.stringify(body),
+  });
+  const payload = await response.json().catch(() => null);
+  if (!response.ok || !payload || payload.error) {
+    throw new Error((payload && payload.error) || "请求失败");
   }
+  return payload;
+}
 
-  const dl = card.querySelector(".btn-download");
-  if (dl) {
-    dl.href = buildDownloadURL(
-      song.id,
-      song.source,
-      song.name,
-      song.artist,
-      song.album || "",
-      song.cover || "",
-      card.dataset.extra || "",
-    );
-    dl.id = `dl-${song.id}`;
-    dl.title = "保存到本地目录";
+function renderLocalMusicTagPreviewItem(item) {
+  const title = escapeHTML(item.name || item.filename || item.id);
+  if (item.error) {
+    return `<div class="local-tag-preview-item is-error"><strong>${title}</strong>${escapeHTML(item.error)}</div>`;
+  }
+  if (!item.changed || item.changed.length === 0) {
+    return `<div class="local-tag-preview-item"><strong>${title}</strong>无变化</div>`;
   }
+  const rows = item.changed.map((field) => {
+    const label = LOCAL_TAG_FIELD_LABELS[field] || field;
+    if (field === "cover") return `${label}：已修改`;
+    const before = item.before?.[field] || "（空）";
+    const after = item.after?.[field] || "（空）";
+    return `${escapeHTML(label)}：<del>${escapeHTML(before)}</del> → <ins>${escapeHTML(after)}</ins>`;
+  });
+  return `<div class="local-tag-preview-item"><strong>${title}</strong>${rows.join("<br>")}</div>`;
+}
 
-  const browserDl = card.querySelector(".btn-browser-download");
-  if (browserDl) {
-    browserDl.href = buildBrowserDownloadURL(
-      song.id,
-      song.source,
-      song.name,
-      song.artist,
-      song.album || "",
-      song.cover || "",
-      card.dataset.extra || "",
+async function previewLocalMusicTagEdit() {
+  const preview = document.getElementById("localTagPreview");
+  if (!preview) return;
+  setLocalMusicTagStatus("正在生成预览...");
+  try {
+    const payload = await postLocalMusicTagRequest(
+      "/local_music/tags/preview",
+      collectLocalMusicTagRequest(),
     );
-    browserDl.id = `browser-dl-${song.id}`;
-    browserDl.title = "浏览器下载";
+    const items = Array.isArray(payload.items) ? payload.items : [];
+    preview.innerHTML = items.map(renderLocalMusicTagPreviewItem).join("");
+    preview.hidden = false;
+    const changed = items.filter(
+      (item) => !item.error && item.changed && item.changed.length > 0,
+    ).length;
+    setLocalMusicTagStatus(`将修改 ${changed}/${items.length} 首`);
+  } catch (error) {
+    setLocalMusicTagStatus(error.message || "预览失败", "error");
   }
+}
 
-  const lrc = card.querySelector(".btn-lyric");
-  if (lrc) {
-    lrc.href = lyricURLsForSong(song).download;
-    lrc.id = `lrc-${song.id}`;
+async function saveLocalMusicTagEdit() {
+  const request = collectLocalMusicTagRequest();
+  if (
+    request.ids.length > 1 &&
+    !confirm(`确定把修改写入 ${request.ids.length} 首本地音乐吗？`)
+  ) {
+    return;
   }
-
-  const coverBtn = card.querySelector(".btn-cover");
-  if (coverBtn) {
-    coverBtn.href = buildCoverDownloadURL(song);
+  const saveBtn = document.getElementById("localTagSaveBtn");
+  if (saveBtn) saveBtn.disabled = true;
+  setLocalMusicTagStatus("正在写入标签...");
+  try {
+    const payload = await postLocalMusicTagRequest(
+      "/local_music/tags",
+      request,
+    );
+    const failed = Array.isArray(payload.failed) ? payload.failed : [];
+    const saved = Array.isArray(payload.tracks) ? payload.tracks.length : 0;
+    localMusicTagEditor.editId = payload.edit_id || 0;
+    const undoBtn = document.getElementById("localTagUndoBtn");
+    if (undoBtn) undoBtn.hidden = !localMusicTagEditor.editId;
+    let message = `已保存 ${saved}/${request.ids.length} 首`;
+    if (failed.length > 0) {
+      message += `，失败 ${failed.length} 首：${failed[0].error}`;
+    }
+    setLocalMusicTagStatus(message, failed.length > 0 ? "error" : "success");
+    localMusicTagInputs().forEach((input) =>
+      input.classList.remove("is-dirty"),
+    );
+    await refreshLocalMusicPageAfterMutation();
+  } catch (error) {
+    setLocalMusicTagStatus(error.message || "保存失败", "error");
+  } finally {
+    if (saveBtn) saveBtn.disabled = false;
   }
-  syncLocalSongActionButtons(card, {
-    ...song,
-    extra: normalizeSongExtra(song.extra),
-  });
+}
 
-  const sizeTag = card.querySelector('[id^="size-"]');
-  if (sizeTag) {
-    sizeTag.id = `size-${song.id}`;
-    sizeTag.className = "tag tag-loading";
-    sizeTag.innerHTML = '<i class="fa fa-spinner fa-spin"></i>';
-  }
-  const bitrateTag = card.querySelector('[id^="bitrate-"]');
-  if (bitrateTag) {
-    bitrateTag.id = `bitrate-${song.id}`;
-    bitrateTag.className = "tag tag-loading";
-    bitrateTag.innerHTML = '<i class="fa fa-circle-notch fa-spin"></i>';
+async function undoLocalMusicTagEdit() {
+  if (!localMusicTagEditor.editId) return;
+  setLocalMusicTagStatus("正在撤销...");
+  try {
+    const payload = await postLocalMusicTagRequest("/local_music/tags/undo", {
+      edit_id: localMusicTagEditor.editId,
+    });
+    localMusicTagEditor.editId = 0;
+    const undoBtn = document.getElementById("localTagUndoBtn");
+    if (undoBtn) undoBtn.hidden = true;
+    const restored = Array.isArray(payload.tracks) ? payload.tracks.length : 0;
+    setLocalMusicTagStatus(`已撤销，恢复 ${restored} 首`, "success");
+    await refreshLocalMusicPageAfterMutation();
+  } catch (error) {
+    setLocalMusicTagStatus(error.message || "撤销失败", "error");
   }
+}
 
-  if (currentPlayingId === oldId) {
-    currentPlayingId = song.id;
-  }
+// ==========================================
+// 本地音乐自动识别
+// ==========================================
 
-  syncAllPlayButtons();
-  if (shouldInspect) {
-    queueInspectSong(card);
-  }
-  syncSongToAPlayer(oldId, song);
-  if (currentPlayingId === song.id) {
-    syncMediaSession();
+const LOCAL_IDENTIFY_POLL_INTERVAL = 1500;
+let localMusicIdentifyState = {
+  ids: [],
+  job: null,
+  timer: null,
+  editId: 0,
+};
+
+function openLocalMusicIdentifyModalForSelection() {
+  const ids = getSelectedSongs()
+    .filter((song) => isLocalMusicSourceValue(song.source))
+    .map((song) => song.id);
+  if (ids.length === 0) return;
+  openLocalMusicIdentifyModal(ids);
+}
+
+function closeLocalMusicIdentifyModal() {
+  document.getElementById("identify-modal-overlay")?.remove();
+  clearTimeout(localMusicIdentifyState.timer);
+  localMusicIdentifyState.timer = null;
+}
+
+async function openLocalMusicIdentifyModal(ids = []) {
+  closeLocalMusicIdentifyModal();
+  localMusicIdentifyState = { ids, job: null, timer: null, editId: 0 };
+
+  const overlay = document.createElement("div");
+  overlay.id = "identify-modal-overlay";
+  overlay.className = "modal-overlay utility-modal-overlay is-open";
+  overlay.onclick = (e) => {
+    if (e.target === overlay) closeLocalMusicIdentifyModal();
+  };
+  const scope =
+    ids.length > 0
+      ? `识别已选的 ${ids.length} 首`
+      : "识别缺少专辑、歌手或封面的本地音乐";
+  overlay.innerHTML = `
+    <div class="modal utility-modal identify-modal">
+      <div class="modal-header">
+        <div><h3><i class="fa-solid fa-wand-magic-sparkles"></i> 自动识别标签</h3><p class="utility-modal-subtitle">${escapeHTML(scope)}，用标签或文件名到在线音源匹配</p></div>
+        <button type="button" class="modal-close" aria-label="关闭自动识别" onclick="closeLocalMusicIdentifyModal()"><i class="fa-solid fa-xmark"></i></button>
+      </div>
+      <div class="modal-body">
+        <div class="identify-options">
+          <label><input type="checkbox" id="identifyAutoApply"> 置信度达到阈值时自动写入</label>
+          <label>阈值
+            <select id="identifyThreshold">
+              <option value="0.8">80%</option>
+              <option value="0.9" selected>90%</option>
+              <option value="0.95">95%</option>
+            </select>
+          </label>
+          <button type="button" class="btn-pill btn-pill-primary" id="identifyStartBtn" onclick="startLocalMusicIdentifyJob()"><i class="fa-solid fa-play"></i> 开始识别</button>
+        </div>
+        <div id="identifyProgress" class="setting-inline-status"></div>
+        <div id="identifyResults" class="identify-results"></div>
+        <div class="identify-actions">
+          <button type="button" class="btn-pill" id="identifyCancelBtn" onclick="cancelLocalMusicIdentifyJob()" hidden><i class="fa-solid fa-stop"></i> 停止识别</button>
+          <button type="button" class="btn-pill btn-pill-warn" id="identifyUndoBtn" onclick="undoLocalMusicIdentifyEdit()" hidden><i class="fa-solid fa-rotate-left"></i> 撤销写入</button>
+          <button type="button" class="btn-pill btn-pill-dl" id="identifyApplyBtn" onclick="applyLocalMusicIdentifyResults()" hidden><i class="fa-solid fa-check"></i> 应用所选</button>
+        </div>
+      </div>
+    </div>`;
+  document.body.appendChild(overlay);
+
+  // 打开时如果有上次的任务（比如还在跑），直接展示进度。
+  try {
+    const response = await fetch(`${API_ROOT}/local_music/identify`);
+    const payload = await response.json().catch(() => null);
+    if (payload?.job?.status === "running") {
+      renderLocalMusicIdentifyJob(payload.job);
+      scheduleLocalMusicIdentifyPoll();
+    }
+  } catch (_) {}
+}
+
+async function startLocalMusicIdentifyJob() {
+  const ids = localMusicIdentifyState.ids;
+  const body = {
+    ids,
+    all_incomplete: ids.length === 0,
+    auto_apply: !!document.getElementById("identifyAutoApply")?.checked,
+    threshold: Number(document.getElementById("identifyThreshold")?.value) || 0.9,
+  };
+  const progress = document.getElementById("identifyProgress");
+  try {
+    const payload = await postLocalMusicTagRequest(
+      "/local_music/identify",
+      body,
+    );
+    renderLocalMusicIdentifyJob(payload.job);
+    scheduleLocalMusicIdentifyPoll();
+  } catch (error) {
+    if (progress) {
+      progress.textContent = error.message || "启动识别失败";
+      progress.className = "setting-inline-status error";
+    }
   }
 }
 
-function syncSongToAPlayer(oldId, newSong) {
-  if (!ap || !ap.list || !ap.list.audios) return;
-  const index = ap.list.audios.findIndex(
-    (a) => a.custom_id === oldId || a.original_id === oldId,
+function scheduleLocalMusicIdentifyPoll() {
+  clearTimeout(localMusicIdentifyState.timer);
+  localMusicIdentifyState.timer = setTimeout(
+    pollLocalMusicIdentifyJob,
+    LOCAL_IDENTIFY_POLL_INTERVAL,
   );
-  if (index !== -1) {
-    const audio = ap.list.audios[index];
-    audio.name = newSong.name;
-    audio.artist = newSong.artist;
-    audio.album = newSong.album || "";
-    audio.cover = newSong.cover;
-    audio.url = buildStreamURL(
-      newSong.id,
-      newSong.source,
-      newSong.name,
-      newSong.artist,
-      newSong.album || "",
-      newSong.cover || "",
-      serializeSongExtra(newSong.extra),
+}
+
+async function pollLocalMusicIdentifyJob() {
+  if (!document.getElementById("identify-modal-overlay")) return;
+  try {
+    const response = await fetch(`${API_ROOT}/local_music/identify`);
+    const payload = await response.json().catch(() => null);
+    if (!payload?.job) return;
+    renderLocalMusicIdentifyJob(payload.job);
+    if (payload.job.status === "running") {
+      scheduleLocalMusicIdentifyPoll();
+    } else if (payload.job.applied > 0) {
+      await refreshLocalMusicPageAfterMutation();
+    }
+  } catch (_) {
+    scheduleLocalMusicIdentifyPoll();
+  }
+}
+
+function renderLocalMusicIdentifyResult(result, threshold) {
+  const label = escapeHTML(result.name || result.filename || result.id);
+  const proposal = result.proposal;
+  if (!proposal) {
+    const reason =
+      result.status === "pending"
+        ? "等待识别"
+        : result.error || "没有找到匹配";
+    return `<div class="identify-result is-empty"><strong>${label}</strong><span>${escapeHTML(reason)}</span></div>`;
+  }
+  const confidence = Math.round((result.confidence || 0) * 100);
+  const applied = result.status === "applied";
+  const checked = !applied && result.confidence >= threshold ? "checked" : "";
+  const fields = (proposal.fields || [])
+    .map((field) => LOCAL_TAG_FIELD_LABELS[field] || field)
+    .join("、");
+  return `<label class="identify-result${applied ? " is-applied" : ""}">
+      <input type="checkbox" class="identify-result-check" value="${escapeHTML(result.id)}" ${checked} ${applied ? "disabled" : ""}>
+      <span class="identify-result-main">
+        <strong>${label}</strong>
+        <span>→ ${escapeHTML(proposal.name)} · ${escapeHTML(proposal.artist)}${proposal.album ? ` · ${escapeHTML(proposal.album)}` : ""} <em>${escapeHTML(proposal.source)}</em></span>
+        <span class="identify-result-fields">${applied ? "已写入" : fields ? `修改：${escapeHTML(fields)}` : "无需修改"}${result.error ? ` · ${escapeHTML(result.error)}` : ""}</span>
+      </span>
+      <span class="identify-confidence${confidence >= threshold * 100 ? " is-high" : ""}">${confidence}%</span>
+    </label>`;
+}
+
+function renderLocalMusicIdentifyJob(job) {
+  if (!job) return;
+  localMusicIdentifyState.job = job;
+  if (job.edit_id) localMusicIdentifyState.editId = job.edit_id;
+  const running = job.status === "running";
+  const progress = document.getElementById("identifyProgress");
+  if (progress) {
+    const statusText = running
+      ? "识别中"
+      : job.status === "cancelled"
+        ? "已取消"
+        : "识别完成";
+    progress.textContent = `${statusText}：${job.processed}/${job.total}，匹配 ${job.matched} 首，已写入 ${job.applied} 首`;
+    progress.className = "setting-inline-status";
+  }
+  const startBtn = document.getElementById("identifyStartBtn");
+  if (startBtn) startBtn.disabled = running;
+  const cancelBtn = document.getElementById("identifyCancelBtn");
+  if (cancelBtn) cancelBtn.hidden = !running;
+  const results = document.getElementById("identifyResults");
+  if (results) {
+    results.innerHTML = (job.results || [])
+      .map((result) => renderLocalMusicIdentifyResult(result, job.threshold))
+      .join("");
+  }
+  const applyBtn = document.getElementById("identifyApplyBtn");
+  if (applyBtn) {
+    applyBtn.hidden =
+      running ||
+      !(job.results || []).some(
+        (result) => result.proposal && result.status !== "applied",
+      );
+  }
+  const undoBtn = document.getElementById("identifyUndoBtn");
+  if (undoBtn) undoBtn.hidden = running || !localMusicIdentifyState.editId;
+}
+
+async function cancelLocalMusicIdentifyJob() {
+  try {
+    await postLocalMusicTagRequest("/local_music/identify/cancel", {});
+  } catch (_) {}
+  await pollLocalMusicIdentifyJob();
+}
+
+async function applyLocalMusicIdentifyResults() {
+  const job = localMusicIdentifyState.job;
+  const ids = Array.from(
+    document.querySelectorAll(".identify-result-check:checked"),
+  ).map((input) => input.value);
+  if (!job || ids.length === 0) return;
+  const progress = document.getElementById("identifyProgress");
+  try {
+    const payload = await postLocalMusicTagRequest(
+      "/local_music/identify/apply",
+      { job_id: job.id, ids },
     );
-    const lyricURLs = lyricURLsForPlayback(newSong);
-    audio.lrc = lyricURLs.line;
-    audio.raw_lrc = lyricURLs.auto;
-    audio.custom_id = newSong.id;
-    audio.original_id = newSong.id;
-    audio.source = newSong.source;
-    audio.duration = newSong.duration || 0;
-    audio.extra = serializeSongExtra(newSong.extra);
+    if (payload.edit_id) localMusicIdentifyState.editId = payload.edit_id;
+    await pollLocalMusicIdentifyJob();
+    const failed = Array.isArray(payload.failed) ? payload.failed.length : 0;
+    if (progress && failed > 0) {
+      progress.textContent += `，${failed} 首写入失败`;
+    }
+    await refreshLocalMusicPageAfterMutation();
+  } catch (error) {
+    if (progress) {
+      progress.textContent = error.message || "写入失败";
+      progress.className = "setting-inline-status error";
+    }
+  }
+}
 
-    if (ap.list.index === index) {
-      ap.list.switch(index);
-      if (ap.audio.paused) {
-        ap.play();
-      }
+async function undoLocalMusicIdentifyEdit() {
+  if (!localMusicIdentifyState.editId) return;
+  const progress = document.getElementById("identifyProgress");
+  try {
+    await postLocalMusicTagRequest("/local_music/tags/undo", {
+      edit_id: localMusicIdentifyState.editId,
+    });
+    localMusicIdentifyState.editId = 0;
+    document.getElementById("identifyUndoBtn").hidden = true;
+    if (progress) {
+      progress.textContent = "已撤销写入的标签";
+      progress.className = "setting-inline-status success";
+    }
+    await refreshLocalMusicPageAfterMutation();
+  } catch (error) {
+    if (progress) {
+      progress.textContent = error.message || "撤销失败";
+      progress.className = "setting-inline-status error";
     }
   }
 }
 
-function switchSource(btn, options = {}) {
-  const card = btn.closest(".song-card");
-  if (!card) return Promise.resolve(false);
+// ==========================================
+// 整理曲库（按下载文件名模板重命名 / 移动）
+// ==========================================
 
-  const ds = card.dataset;
-  const name = ds.name || "";
-  const artist = ds.artist || "";
-  const source = ds.source || "";
-  if (!name || !source) return Promise.resolve(false);
+const LOCAL_ORGANIZE_POLL_INTERVAL = 1000;
+let localMusicOrganizeState = { timer: null };
 
-  btn.disabled = true;
-  btn.style.opacity = "0.6";
+function closeLocalMusicOrganizeModal() {
+  document.getElementById("organize-modal-overlay")?.remove();
+  clearTimeout(localMusicOrganizeState.timer);
+  localMusicOrganizeState.timer = null;
+}
 
-  const duration = ds.duration || "";
-  const url = `${API_ROOT}/switch_source?name=${encodeURIComponent(name)}&artist=${encodeURIComponent(artist)}&source=${encodeURIComponent(source)}&duration=${encodeURIComponent(duration)}`;
-  return fetch(url)
-    .then((r) => (r.ok ? r.json() : Promise.reject()))
-    .then((song) => {
-      updateCardWithSong(card, song);
-      clearSongCardSelection(card, { deferToolbar: !!options.deferToolbar });
-      return true;
-    })
-    .catch(() => {
-      if (!options.silent) {
-        alert("换源失败，请稍后重试");
-      }
-      return false;
+async function openLocalMusicOrganizeModal() {
+  closeLocalMusicOrganizeModal();
+  const overlay = document.createElement("div");
+  overlay.id = "organize-modal-overlay";
+  overlay.className = "modal-overlay utility-modal-overlay is-open";
+  overlay.onclick = (e) => {
+    if (e.target === overlay) closeLocalMusicOrganizeModal();
+  };
+  overlay.innerHTML = `
+    <div class="modal utility-modal organize-modal">
+      <div class="modal-header">
+        <div><h3><i class="fa-solid fa-folder-tree"></i> 整理曲库</h3><p class="utility-modal-subtitle">按文件标签和下载文件名模板重命名、移动本地音乐，歌词和封面一起移动</p></div>
+        <button type="button" class="modal-close" aria-label="关闭整理曲库" onclick="closeLocalMusicOrganizeModal()"><i class="fa-solid fa-xmark"></i></button>
+      </div>
+      <div class="modal-body">
+        <div class="organize-options">
+          <input type="text" id="organizeTemplate" placeholder="留空使用设置里的下载文件名模板" autocomplete="off">
+          <button type="button" class="btn-pill" id="organizePreviewBtn" onclick="startLocalMusicOrganizeJob(false)"><i class="fa-solid fa-eye"></i> 预览</button>
+        </div>
+        <div id="organizeStatus" class="setting-inline-status"></div>
+        <div id="organizeResults" class="organize-results"></div>
+        <div class="identify-actions">
+          <button type="button" class="btn-pill" id="organizeCancelBtn" onclick="cancelLocalMusicOrganizeJob()" hidden><i class="fa-solid fa-stop"></i> 停止</button>
+          <button type="button" class="btn-pill btn-pill-dl" id="organizeApplyBtn" onclick="startLocalMusicOrganizeJob(true)" hidden><i class="fa-solid fa-check"></i> 开始整理</button>
+        </div>
+      </div>
+    </div>`;
+  document.body.appendChild(overlay);
+
+  // 上次的任务还在跑就接着看进度，否则直接算一次预览。
+  try {
+    const response = await fetch(`${API_ROOT}/local_music/organize`);
+    const payload = await response.json().catch(() => null);
+    if (payload?.job?.status === "running") {
+      renderLocalMusicOrganizeJob(payload.job);
+      scheduleLocalMusicOrganizePoll();
+      return;
+    }
+  } catch (_) {}
+  startLocalMusicOrganizeJob(false);
+}
+
+function renderLocalMusicOrganizeItems(items) {
+  const labels = {
+    move: "",
+    moved: "已移动",
+    skipped: "跳过",
+    failed: "失败",
+  };
+  return items
+    .map((item) => {
+      const sidecars = (item.sidecars || [])
+        .map((sidecar) => escapeHTML(sidecar.to.split("/").pop()))
+        .join("、");
+      const target = item.to
+        ? `<span class="organize-to">→ ${escapeHTML(item.to)}</span>`
+        : "";
+      const note = [
+        labels[item.status],
+        item.reason,
+        sidecars ? `附带 ${sidecars}` : "",
+      ]
+        .filter(Boolean)
+        .join(" · ");
+      return `<div class="organize-item is-${escapeHTML(item.status)}">
+          <span class="organize-from">${escapeHTML(item.from || item.id)}</span>
+          ${target}
+          ${note ? `<span class="organize-note">${escapeHTML(note)}</span>` : ""}
+        </div>`;
     })
-    .finally(() => {
-      btn.disabled = false;
-      btn.style.opacity = "1";
-    });
+    .join("");
 }
 
-function playAllAndJumpTo(btn) {
-  const currentCard = btn.closest(".song-card");
-  const allCards = Array.from(document.querySelectorAll(".song-card"));
-  const clickedIndex = allCards.indexOf(currentCard);
+async function startLocalMusicOrganizeJob(apply) {
+  if (apply && !confirm("确定按预览移动这些文件吗？")) return;
+  const status = document.getElementById("organizeStatus");
+  try {
+    const payload = await postLocalMusicTagRequest(
+      apply ? "/local_music/organize" : "/local_music/organize/preview",
+      { template: document.getElementById("organizeTemplate")?.value.trim() || "" },
+    );
+    renderLocalMusicOrganizeJob(payload.job);
+    scheduleLocalMusicOrganizePoll();
+  } catch (error) {
+    if (status) {
+      status.textContent = error.message || (apply ? "整理失败" : "预览失败");
+      status.className = "setting-inline-status error";
+    }
+  }
+}
 
-  if (clickedIndex === -1) return;
+function scheduleLocalMusicOrganizePoll() {
+  clearTimeout(localMusicOrganizeState.timer);
+  localMusicOrganizeState.timer = setTimeout(
+    pollLocalMusicOrganizeJob,
+    LOCAL_ORGANIZE_POLL_INTERVAL,
+  );
+}
 
-  const clickedId = currentCard.dataset.id;
-  const isActuallyPlaying = ap?.audio && !ap.audio.paused;
+async function pollLocalMusicOrganizeJob() {
+  if (!document.getElementById("organize-modal-overlay")) return;
+  try {
+    const response = await fetch(`${API_ROOT}/local_music/organize`);
+    const payload = await response.json().catch(() => null);
+    if (!payload?.job) return;
+    renderLocalMusicOrganizeJob(payload.job);
+    if (payload.job.status === "running") {
+      scheduleLocalMusicOrganizePoll();
+    } else if (payload.job.moved > 0) {
+      await refreshLocalMusicPageAfterMutation();
+    }
+  } catch (_) {
+    scheduleLocalMusicOrganizePoll();
+  }
+}
 
-  if (currentPlayingId && currentPlayingId === clickedId && isActuallyPlaying) {
-    ap.pause();
-    try {
-      ap.seek(0);
-    } catch (e) {}
-    currentPlayingId = null;
-    highlightCard(null);
-    syncAllPlayButtons();
-    syncMediaSession();
-    return;
+async function cancelLocalMusicOrganizeJob() {
+  try {
+    await postLocalMusicTagRequest("/local_music/organize/cancel", {});
+  } catch (_) {}
+  await pollLocalMusicOrganizeJob();
+}
+
+function renderLocalMusicOrganizeJob(job) {
+  if (!job) return;
+  const running = job.status === "running";
+  const status = document.getElementById("organizeStatus");
+  if (status) {
+    const progress = `${job.processed}/${job.total}`;
+    if (running) {
+      status.textContent = `${job.apply ? "整理中" : "计算中"}：${progress}`;
+    } else if (job.apply) {
+      status.textContent = `${job.status === "cancelled" ? `已取消（${progress}），` : ""}已移动 ${job.moved} 首${job.failed ? `，${job.failed} 首失败` : ""}`;
+    } else {
+      status.textContent = `${job.status === "cancelled" ? `已取消（${progress}），` : ""}将移动 ${job.moves} 首，${job.unchanged} 首已在正确位置，跳过 ${job.skipped} 首`;
+    }
+    status.className = `setting-inline-status${
+      !running && job.apply ? (job.failed ? " error" : " success") : ""
+    }`;
   }
+  const templateInput = document.getElementById("organizeTemplate");
+  if (templateInput && !templateInput.value && job.template) {
+    templateInput.placeholder = `当前模板：${job.template}`;
+  }
+  const results = document.getElementById("organizeResults");
+  if (results) {
+    results.innerHTML = renderLocalMusicOrganizeItems(job.items || []);
+  }
+  const previewBtn = document.getElementById("organizePreviewBtn");
+  if (previewBtn) previewBtn.disabled = running;
+  const cancelBtn = document.getElementById("organizeCancelBtn");
+  if (cancelBtn) cancelBtn.hidden = !running;
+  const applyBtn = document.getElementById("organizeApplyBtn");
+  if (applyBtn) {
+    // 只有完整跑完的预览才能直接应用；整理会重新按当前文件计算一遍。
+    applyBtn.hidden = running || job.apply || job.status !== "done" || !job.moves;
+  }
+}
 
-  ap.list.clear();
-  const playlist = [];
+// ==========================================
+// 转码任务（本地批量转码 / 下载后转码共用队列）
+// ==========================================
 
-  allCards.forEach((card) => {
-    const ds = card.dataset;
-    const song = songFromCard(card);
-    if (!song) return;
-    let coverUrl = ds.cover || "";
-    const imgEl = card.querySelector(".cover-wrapper img");
-    if (imgEl && imgEl.src) coverUrl = imgEl.src;
+const LOCAL_TRANSCODE_POLL_INTERVAL = 1000;
+let localMusicTranscodeState = { ids: [], timer: null, changed: false };
 
-    const localMatch = localMusicMatchCache[ds.id];
-    const useLocal = localMatch && localMatch.id;
-    const playbackSong = useLocal
-      ? {
-          id: localMatch.id,
-          source: "local",
-          name: localMatch.name || song.name,
-          artist: localMatch.artist || song.artist,
-          album: song.album || "",
-          cover: song.cover || "",
-          duration: song.duration || 0,
-          extra: song.extra || "",
-        }
-      : song;
-    const lyricURLs = lyricURLsForPlayback(playbackSong);
-    const streamUrl = buildStreamURL(
-      playbackSong.id,
-      playbackSong.source,
-      playbackSong.name,
-      playbackSong.artist,
-      playbackSong.album || "",
-      playbackSong.cover || "",
-      playbackSong.extra || "",
-    );
+function openLocalMusicTranscodeModalForSelection() {
+  const ids = getSelectedSongs()
+    .filter((song) => isLocalMusicSourceValue(song.source))
+    .map((song) => song.id);
+  if (ids.length === 0) return;
+  openLocalMusicTranscodeModal(ids);
+}
 
-    playlist.push({
-      name: playbackSong.name,
-      artist: playbackSong.artist,
-      album: playbackSong.album || "",
-      url: streamUrl,
-      cover: coverUrl,
-      lrc: lyricURLs.line,
-      raw_lrc: lyricURLs.auto,
-      theme: "#10b981",
-      custom_id: playbackSong.id,
-      original_id: ds.id,
-      source: playbackSong.source,
-      duration: playbackSong.duration || parsePositiveInt(ds.duration, 0),
-      extra: playbackSong.extra || "",
-    });
-  });
+function closeLocalMusicTranscodeModal() {
+  document.getElementById("transcode-modal-overlay")?.remove();
+  clearTimeout(localMusicTranscodeState.timer);
+  localMusicTranscodeState.timer = null;
+  if (localMusicTranscodeState.changed) {
+    refreshLocalMusicPageAfterMutation();
+  }
+}
 
-  ap.list.add(playlist);
-  ap.list.switch(clickedIndex);
-  ap.play();
+async function openLocalMusicTranscodeModal(ids = []) {
+  closeLocalMusicTranscodeModal();
+  localMusicTranscodeState = { ids, timer: null, changed: false };
 
-  currentPlayingId = clickedId;
-  highlightCard(currentPlayingId);
-  syncAllPlayButtons();
+  const overlay = document.createElement("div");
+  overlay.id = "transcode-modal-overlay";
+  overlay.className = "modal-overlay utility-modal-overlay is-open";
+  overlay.onclick = (e) => {
+    if (e.target === overlay) closeLocalMusicTranscodeModal();
+  };
+  const scope =
+    ids.length > 0
+      ? `把已选的 ${ids.length} 首转成指定格式`
+      : "查看批量转码和下载后转码的进度";
+  overlay.innerHTML = `
+    <div class="modal utility-modal transcode-modal">
+      <div class="modal-header">
+        <div><h3><i class="fa-solid fa-file-audio"></i> 转码任务</h3><p class="utility-modal-subtitle">${escapeHTML(scope)}，标签、封面和歌词会一起保留</p></div>
+        <button type="button" class="modal-close" aria-label="关闭转码任务" onclick="closeLocalMusicTranscodeModal()"><i class="fa-solid fa-xmark"></i></button>
+      </div>
+      <div class="modal-body">
+        <div class="transcode-options" ${ids.length > 0 ? "" : "hidden"}>
+          <select id="transcodeProfile" aria-label="转码档案"></select>
+          <label><input type="checkbox" id="transcodeReplace"> 替换原文件</label>
+          <button type="button" class="btn-pill btn-pill-primary" id="transcodeStartBtn" onclick="startLocalMusicTranscodeJob()"><i class="fa-solid fa-play"></i> 开始转码</button>
+        </div>
+        <div id="transcodeStatus" class="setting-inline-status"></div>
+        <div id="transcodeJobs" class="transcode-jobs"></div>
+        <div class="identify-actions">
+          <button type="button" class="btn-pill" onclick="clearLocalMusicTranscodeJobs()"><i class="fa-solid fa-broom"></i> 清除已完成</button>
+        </div>
+      </div>
+    </div>`;
+  document.body.appendChild(overlay);
+
+  const status = document.getElementById("transcodeStatus");
+  try {
+    const payload = await fetchTranscodeProfiles();
+    const select = document.getElementById("transcodeProfile");
+    if (select) {
+      select.innerHTML = (payload.profiles || [])
+        .map(
+          (profile) =>
+            `<option value="${escapeHTML(profile.name)}">${escapeHTML(transcodeProfileLabel(profile))}</option>`,
+        )
+        .join("");
+      select.value = payload.download_profile || select.value;
+    }
+    if (payload.error && status) {
+      status.textContent = payload.error;
+      status.className = "setting-inline-status error";
+      const startBtn = document.getElementById("transcodeStartBtn");
+      if (startBtn) startBtn.disabled = true;
+    }
+  } catch (error) {
+    if (status) {
+      status.textContent = error.message;
+      status.className = "setting-inline-status error";
+    }
+  }
+  pollLocalMusicTranscodeJobs();
 }
 
-window.playAllAndJumpToId = function (songId) {
-  const targetCard = document.querySelector(`.song-card[data-id="${songId}"]`);
-  if (targetCard) {
-    const btn = targetCard.querySelector(".btn-play");
-    if (btn) {
-      playAllAndJumpTo(btn);
+async function startLocalMusicTranscodeJob() {
+  const replace = !!document.getElementById("transcodeReplace")?.checked;
+  if (replace && !confirm("转码成功后会删除原文件，确定继续吗？")) return;
+  const status = document.getElementById("transcodeStatus");
+  const startBtn = document.getElementById("transcodeStartBtn");
+  if (startBtn) startBtn.disabled = true;
+  try {
+    await postLocalMusicTagRequest("/transcode/jobs", {
+      ids: localMusicTranscodeState.ids,
+      profile: document.getElementById("transcodeProfile")?.value || "",
+      replace,
+    });
+    if (status) {
+      status.textContent = "已加入转码队列";
+      status.className = "setting-inline-status success";
+    }
+    pollLocalMusicTranscodeJobs();
+  } catch (error) {
+    if (status) {
+      status.textContent = error.message || "启动转码失败";
+      status.className = "setting-inline-status error";
     }
+    if (startBtn) startBtn.disabled = false;
   }
-};
+}
 
-let isBatchMode = false;
-let autoSwitchInvalidTimer = 0;
-let autoSwitchInvalidInProgress = false;
-let autoSwitchInvalidPending = false;
-let autoSwitchInvalidLastKey = "";
+async function cancelLocalMusicTranscodeJob(id) {
+  try {
+    await postLocalMusicTagRequest(`/transcode/jobs/${encodeURIComponent(id)}/cancel`, {});
+  } catch (_) {}
+  pollLocalMusicTranscodeJobs();
+}
 
-function clearAutoSwitchInvalidTimer() {
-  if (autoSwitchInvalidTimer) {
-    window.clearTimeout(autoSwitchInvalidTimer);
-    autoSwitchInvalidTimer = 0;
-  }
-  autoSwitchInvalidPending = false;
+async function clearLocalMusicTranscodeJobs() {
+  try {
+    await postLocalMusicTagRequest("/transcode/jobs/clear", {});
+  } catch (_) {}
+  pollLocalMusicTranscodeJobs();
 }
 
-function resetAutoSwitchInvalidState() {
-  clearAutoSwitchInvalidTimer();
-  autoSwitchInvalidInProgress = false;
-  autoSwitchInvalidLastKey = "";
+async function pollLocalMusicTranscodeJobs() {
+  clearTimeout(localMusicTranscodeState.timer);
+  if (!document.getElementById("transcode-modal-overlay")) return;
+  let jobs = [];
+  try {
+    const response = await fetch(`${API_ROOT}/transcode/jobs`);
+    const payload = await response.json().catch(() => null);
+    jobs = payload?.jobs || [];
+  } catch (_) {}
+  const list = document.getElementById("transcodeJobs");
+  if (list) {
+    list.innerHTML = jobs.length
+      ? jobs.map((job) => renderLocalMusicTranscodeJob(job)).join("")
+      : `<div class="transcode-empty">暂无转码任务</div>`;
+  }
+  if (jobs.some((job) => job.converted > 0)) {
+    localMusicTranscodeState.changed = true;
+  }
+  if (jobs.some((job) => job.status === "queued" || job.status === "running")) {
+    localMusicTranscodeState.timer = setTimeout(
+      pollLocalMusicTranscodeJobs,
+      LOCAL_TRANSCODE_POLL_INTERVAL,
+    );
+  }
 }
 
-function isAutoSwitchInvalidSourcesEnabled() {
-  return webSettings.autoSwitchInvalidSources !== false;
+function renderLocalMusicTranscodeJob(job) {
+  const labels = {
+    queued: "排队中",
+    running: "转码中",
+    done: "已完成",
+    cancelled: "已取消",
+  };
+  const itemLabels = {
+    pending: "等待",
+    running: "转码中",
+    done: "完成",
+    skipped: "跳过",
+    failed: "失败",
+    cancelled: "已取消",
+  };
+  const percent = Math.round((job.progress || 0) * 100);
+  const active = job.status === "queued" || job.status === "running";
+  const kind = job.kind === "download" ? "下载后转码" : "批量转码";
+  const summary = [
+    `${job.processed}/${job.total}`,
+    job.converted ? `转换 ${job.converted}` : "",
+    job.skipped ? `跳过 ${job.skipped}` : "",
+    job.failed ? `失败 ${job.failed}` : "",
+  ]
+    .filter(Boolean)
+    .join(" · ");
+  const items = (job.items || [])
+    .filter((item) => item.status !== "done" || item.target)
+    .map((item) => {
+      const note = [itemLabels[item.status] || item.status, item.error]
+        .filter(Boolean)
+        .join(" · ");
+      const target = item.target
+        ? `<span class="organize-to">→ ${escapeHTML(item.target)}</span>`
+        : "";
+      return `<div class="organize-item is-${escapeHTML(item.status)}">
+          <span class="organize-from">${escapeHTML(item.source)}</span>
+          ${target}
+          <span class="organize-note">${escapeHTML(note)}</span>
+        </div>`;
+    })
+    .join("");
+  return `<div class="transcode-job is-${escapeHTML(job.status)}">
+      <div class="transcode-job-head">
+        <strong>${escapeHTML(kind)} · ${escapeHTML(job.profile?.name || "")}${job.replace ? " · 替换原文件" : ""}</strong>
+        <span>${escapeHTML(labels[job.status] || job.status)} · ${escapeHTML(summary)}</span>
+        ${active ? `<button type="button" class="btn-circle" title="取消" onclick="cancelLocalMusicTranscodeJob('${escapeHTML(job.id)}')"><i class="fa-solid fa-stop"></i></button>` : ""}
+      </div>
+      <div class="transcode-progress"><span style="width:${percent}%"></span></div>
+      ${job.current ? `<div class="organize-note">正在转码：${escapeHTML(job.current)}</div>` : ""}
+      <div class="organize-results">${items}</div>
+    </div>`;
 }
 
-function hasPendingSongInspections() {
-  return !!document.querySelector('.song-card[data-inspect-pending="1"]');
+// ==========================================
+// 响度分析（EBU R128 / ReplayGain）
+// ==========================================
+
+const LOCAL_LOUDNESS_POLL_INTERVAL = 1500;
+let localMusicLoudnessTimer = null;
+
+function closeLocalMusicLoudnessModal() {
+  document.getElementById("loudness-modal-overlay")?.remove();
+  clearTimeout(localMusicLoudnessTimer);
+  localMusicLoudnessTimer = null;
 }
 
-function getInvalidSongCards(root = document) {
-  const seen = new Set();
-  const cards = [];
-  root.querySelectorAll(".tag-fail").forEach((tag) => {
-    const card = tag.closest(".song-card");
-    if (!card || seen.has(card) || isLocalMusicSourceValue(card.dataset.source))
-      return;
-    if (card.dataset.autoSwitchInvalidAttempted === "1" && root === document)
-      return;
-    seen.add(card);
-    cards.push(card);
-  });
-  return cards;
+function openLocalMusicLoudnessModal() {
+  closeLocalMusicLoudnessModal();
+  const overlay = document.createElement("div");
+  overlay.id = "loudness-modal-overlay";
+  overlay.className = "modal-overlay utility-modal-overlay is-open";
+  overlay.onclick = (e) => {
+    if (e.target === overlay) closeLocalMusicLoudnessModal();
+  };
+  overlay.innerHTML = `
+    <div class="modal utility-modal transcode-modal">
+      <div class="modal-header">
+        <div><h3><i class="fa-solid fa-volume-high"></i> 响度分析</h3><p class="utility-modal-subtitle">用 ffmpeg ebur128 测量每首歌的响度，开启「响度均衡」后播放时统一音量</p></div>
+        <button type="button" class="modal-close" aria-label="关闭响度分析" onclick="closeLocalMusicLoudnessModal()"><i class="fa-solid fa-xmark"></i></button>
+      </div>
+      <div class="modal-body">
+        <div class="transcode-options">
+          <label><input type="checkbox" id="loudnessWriteTags"> 同时写入 REPLAYGAIN 标签（mp3 / flac）</label>
+          <button type="button" class="btn-pill btn-pill-primary" id="loudnessStartBtn" onclick="startLocalMusicLoudnessJob()"><i class="fa-solid fa-play"></i> 分析未测量的歌曲</button>
+          <button type="button" class="btn-pill" id="loudnessCancelBtn" onclick="cancelLocalMusicLoudnessJob()" hidden><i class="fa-solid fa-stop"></i> 取消</button>
+        </div>
+        <div class="transcode-progress"><span id="loudnessProgress" style="width:0%"></span></div>
+        <div id="loudnessStatus" class="setting-inline-status"></div>
+      </div>
+    </div>`;
+  document.body.appendChild(overlay);
+  pollLocalMusicLoudness();
 }
 
-function getManualInvalidSongCards(root = document) {
-  const seen = new Set();
-  const cards = [];
-  root.querySelectorAll(".tag-fail").forEach((tag) => {
-    const card = tag.closest(".song-card");
-    if (!card || seen.has(card) || isLocalMusicSourceValue(card.dataset.source))
-      return;
-    seen.add(card);
-    cards.push(card);
-  });
-  return cards;
+function renderLocalMusicLoudnessStatus(data) {
+  const status = document.getElementById("loudnessStatus");
+  const progress = document.getElementById("loudnessProgress");
+  const startBtn = document.getElementById("loudnessStartBtn");
+  const cancelBtn = document.getElementById("loudnessCancelBtn");
+  const job = data?.job;
+  const running = job?.status === "running";
+  if (status) {
+    const parts = [`已分析 ${data?.analyzed || 0} / ${data?.total || 0} 首`];
+    if (running) {
+      parts.unshift(`正在分析 ${job.processed}/${job.total}`);
+    } else if (job) {
+      if (job.tagged) parts.push(`写入标签 ${job.tagged} 首`);
+      if (job.failed) parts.push(`${job.failed} 首失败`);
+      if (job.status === "cancelled") parts.push("已取消");
+    }
+    if (job?.last_error) parts.push(`最近错误：${job.last_error}`);
+    status.textContent = data?.error || parts.join(" · ");
+    status.className = `setting-inline-status${data?.error ? " error" : ""}`;
+  }
+  if (progress) {
+    const percent = job?.total ? Math.round((job.processed / job.total) * 100) : 0;
+    progress.style.width = `${running || job ? percent : 0}%`;
+  }
+  if (startBtn) {
+    startBtn.disabled =
+      running || !!data?.error || (data?.analyzed || 0) >= (data?.total || 0);
+  }
+  if (cancelBtn) cancelBtn.hidden = !running;
+  return running;
 }
 
-function invalidSongCardsKey(cards) {
-  return cards
-    .map((card) => `${card.dataset.source || ""}:${card.dataset.id || ""}`)
-    .sort()
-    .join("|");
+async function pollLocalMusicLoudness() {
+  clearTimeout(localMusicLoudnessTimer);
+  if (!document.getElementById("loudness-modal-overlay")) return;
+  try {
+    const response = await fetch(`${API_ROOT}/local_music/loudness`);
+    const data = await response.json();
+    if (renderLocalMusicLoudnessStatus(data)) {
+      localMusicLoudnessTimer = setTimeout(
+        pollLocalMusicLoudness,
+        LOCAL_LOUDNESS_POLL_INTERVAL,
+      );
+    } else {
+      // 新的测量结果要重新取增益。
+      replayGainCache.clear();
+      loadReplayGains([getCurrentAPlayerAudio()]);
+    }
+  } catch (_) {}
 }
 
-function clearSongCardSelection(card, options = {}) {
-  if (!card) return false;
-  const cb = card.querySelector(".song-checkbox");
-  if (!cb || !cb.checked) return false;
-  cb.checked = false;
-  card.classList.remove("selected");
-  if (!options.deferToolbar) {
-    updateBatchToolbar();
+async function startLocalMusicLoudnessJob() {
+  const startBtn = document.getElementById("loudnessStartBtn");
+  if (startBtn) startBtn.disabled = true;
+  try {
+    await postLocalMusicTagRequest("/local_music/loudness", {
+      write_tags: !!document.getElementById("loudnessWriteTags")?.checked,
+    });
+    pollLocalMusicLoudness();
+  } catch (error) {
+    const status = document.getElementById("loudnessStatus");
+    if (status) {
+      status.textContent = error.message || "启动响度分析失败";
+      status.className = "setting-inline-status error";
+    }
+    if (startBtn) startBtn.disabled = false;
   }
-  return true;
 }
 
-function selectInvalidSongCards(options = {}) {
-  const invalidCards = Array.isArray(options.cards)
-    ? options.cards
-    : getManualInvalidSongCards();
-  if (invalidCards.length === 0) {
+async function cancelLocalMusicLoudnessJob() {
+  try {
+    await postLocalMusicTagRequest("/local_music/loudness/cancel", {});
+  } catch (_) {}
+  pollLocalMusicLoudness();
+}
+
+async function batchSwitchSource(options = {}) {
+  const optionCards = Array.isArray(options.cards)
+    ? options.cards.filter((card) => card && card.isConnected)
+    : null;
+  const checkedBoxes = optionCards
+    ? optionCards
+        .map((card) => card.querySelector(".song-checkbox"))
+        .filter(Boolean)
+    : Array.from(document.querySelectorAll(".song-checkbox:checked"));
+  if (checkedBoxes.length === 0) return false;
+
+  const candidateCards =
+    optionCards || checkedBoxes.map((cb) => cb.closest(".song-card"));
+  const cards = candidateCards.filter(
+    (card) => card && !isLocalMusicSourceValue(card.dataset.source),
+  );
+  const skippedLocalCount = candidateCards.length - cards.length;
+  if (cards.length === 0) {
     if (!options.silent) {
-      alert("当前列表中没有检测到无效歌曲");
+      alert("选中的歌曲都是本地歌曲，无需批量换源。");
     }
-    return 0;
+    return false;
   }
 
-  let changed = 0;
-  const invalidSet = new Set(invalidCards);
-  document.querySelectorAll(".song-checkbox").forEach((checkbox) => {
-    const card = checkbox.closest(".song-card");
-    const shouldCheck = invalidSet.has(card);
-    if (!shouldCheck && options.clearExisting !== false) {
-      if (checkbox.checked) {
-        checkbox.checked = false;
-        changed++;
+  const skipText =
+    skippedLocalCount > 0 ? `\n已跳过 ${skippedLocalCount} 首本地歌曲。` : "";
+  if (
+    !options.skipConfirm &&
+    !confirm(
+      `准备对 ${cards.length} 首歌曲进行自动换源。\n这可能需要一些时间，请耐心等待。${skipText}`,
+    )
+  ) {
+    return false;
+  }
+
+  const batchSwitch = document.getElementById("btn-batch-switch");
+  const originalHTML = batchSwitch ? batchSwitch.innerHTML : "";
+  if (batchSwitch) {
+    batchSwitch.disabled = true;
+    batchSwitch.innerHTML =
+      '<i class="fa-solid fa-spinner fa-spin"></i> 换源中';
+  }
+
+  const concurrency = Math.min(3, cards.length);
+  let nextIndex = 0;
+  const runWorker = async () => {
+    while (nextIndex < cards.length) {
+      const card = cards[nextIndex++];
+      const switchBtn = card.querySelector(".btn-switch");
+      if (switchBtn) {
+        await switchSource(switchBtn, {
+          silent: !!options.silent,
+          deferToolbar: true,
+        });
       }
-      return;
     }
-    if (!shouldCheck) return;
-    if (!checkbox.checked) {
-      checkbox.checked = true;
-      changed++;
+  };
+  try {
+    await Promise.all(Array.from({ length: concurrency }, runWorker));
+    return true;
+  } finally {
+    if (batchSwitch) {
+      batchSwitch.innerHTML = originalHTML;
     }
-  });
-
-  updateBatchToolbar();
-  if (!options.silent && changed === 0) {
-    alert("无效歌曲已全部选中");
+    updateBatchToolbar();
   }
-  return invalidCards.length;
 }
 
-function scheduleAutoSwitchInvalidSources(
-  delay = AUTO_SWITCH_INVALID_DELAY_MS,
-) {
-  if (!isAutoSwitchInvalidSourcesEnabled()) return;
-  if (autoSwitchInvalidInProgress) {
-    autoSwitchInvalidPending = true;
+async function batchRemoveFromCollection(colId) {
+  const id = String(colId || "").trim();
+  const songs = getSelectedSongs();
+  if (!id || songs.length === 0) return;
+
+  if (!confirm(`确定从当前自建歌单中取消收藏 ${songs.length} 首歌曲吗？`)) {
     return;
   }
-  if (autoSwitchInvalidTimer) {
-    window.clearTimeout(autoSwitchInvalidTimer);
-  }
-  autoSwitchInvalidTimer = window.setTimeout(
-    () => {
-      autoSwitchInvalidTimer = 0;
-      autoSwitchInvalidSources();
-    },
-    Math.max(0, delay),
-  );
-}
 
-async function autoSwitchInvalidSources() {
-  if (!isAutoSwitchInvalidSourcesEnabled()) return false;
-  if (autoSwitchInvalidInProgress) {
-    autoSwitchInvalidPending = true;
-    return false;
-  }
-  if (hasPendingSongInspections()) {
-    scheduleAutoSwitchInvalidSources(AUTO_SWITCH_INVALID_DELAY_MS);
-    return false;
+  const btn = document.getElementById("btn-batch-remove-collection");
+  const originalHTML = btn ? btn.innerHTML : "";
+  if (btn) {
+    btn.disabled = true;
+    btn.innerHTML = '<i class="fa-solid fa-spinner fa-spin"></i> 移出中';
   }
 
-  const invalidCards = getInvalidSongCards();
-  if (invalidCards.length === 0) return false;
-
-  const key = invalidSongCardsKey(invalidCards);
-  if (key && key === autoSwitchInvalidLastKey) return false;
-  autoSwitchInvalidLastKey = key;
-  invalidCards.forEach((card) => {
-    card.dataset.autoSwitchInvalidAttempted = "1";
-  });
-
-  selectInvalidSongCards({ silent: true, cards: invalidCards });
-  autoSwitchInvalidInProgress = true;
-  showToast(
-    "自动换源",
-    `检测到 ${invalidCards.length} 首无效歌曲，正在批量换源`,
-    "info",
-    3500,
-  );
   try {
-    await batchSwitchSource({
-      skipConfirm: true,
-      silent: true,
-      auto: true,
-      cards: invalidCards,
-    });
-    return true;
+    const response = await fetch(
+      `${API_ROOT}/collections/${encodeURIComponent(id)}/songs`,
+      {
+        method: "DELETE",
+        headers: { "Content-Type": "application/json" },
+        body: JSON.stringify({
+          songs: songs.map((song) => ({ id: song.id, source: song.source })),
+        }),
+      },
+    );
+    const payload = await response.json().catch(() => null);
+    if (!response.ok || !payload || payload.error) {
+      throw new Error((payload && payload.error) || "批量取消收藏失败");
+    }
+    alert(`已从当前歌单取消收藏 ${songs.length} 首歌曲。`);
+    await refreshCurrentPageContent({ scroll: false });
+  } catch (error) {
+    alert(error.message || "批量取消收藏失败");
   } finally {
-    autoSwitchInvalidInProgress = false;
-    if (autoSwitchInvalidPending) {
-      autoSwitchInvalidPending = false;
-      scheduleAutoSwitchInvalidSources();
+    if (btn && btn.isConnected) {
+      btn.innerHTML = originalHTML;
     }
+    updateBatchToolbar();
   }
 }
 
-function toggleBatchMode() {
-  isBatchMode = !isBatchMode;
-  document.body.classList.toggle("batch-mode", isBatchMode);
-  const btn = document.getElementById("btn-batch-toggle");
-  const toolbar = document.getElementById("batch-toolbar");
+// ==========================================
+// 自制歌单（本地收藏夹）前端交互
+// ==========================================
+
+let pendingFavSong = null;
+let activeLocalMusicCollectionId = "";
+let localMusicModalState = {
+  offset: 0,
+  limit: 80,
+  total: 0,
+  hasMore: false,
+  loading: false,
+};
+
+function localMusicElements() {
+  return {
+    modal: document.getElementById("localMusicModal"),
+    list: document.getElementById("localMusicList"),
+    hint: document.getElementById("localMusicHint"),
+    dir: document.getElementById("localMusicDir"),
+  };
+}
+
+function setLocalMusicHint(message, isError = false) {
+  const { hint } = localMusicElements();
+  if (!hint) return;
+  hint.textContent = message || "";
+  hint.classList.toggle("error", !!isError);
+  hint.style.display = message ? "block" : "none";
+}
 
-  if (!btn || !toolbar) return;
+async function openLocalMusicModal(collectionId = "") {
+  activeLocalMusicCollectionId = String(collectionId || "").trim();
+  const { modal, list, dir } = localMusicElements();
+  if (!modal) return;
 
-  if (isBatchMode) {
-    btn.innerHTML = '<i class="fa-solid fa-xmark"></i> 退出批量';
-    btn.classList.add("is-active");
-    btn.setAttribute("aria-pressed", "true");
-    toolbar.classList.add("active");
-  } else {
-    btn.innerHTML = '<i class="fa-solid fa-list-check"></i> 批量操作';
-    btn.classList.remove("is-active");
-    btn.setAttribute("aria-pressed", "false");
-    toolbar.classList.remove("active");
-    document
-      .querySelectorAll(".song-checkbox")
-      .forEach((cb) => (cb.checked = false));
-    updateBatchToolbar();
-  }
+  closeDownloadRecordsModal();
+  if (list) list.innerHTML = "";
+  if (dir) dir.textContent = `下载目录：${webSettings.downloadDir || "-"}`;
+  setLocalMusicHint("正在加载本地音乐...");
+  modal.style.display = "flex";
+  refreshLocalMusicList();
 }
 
-function updateBatchToolbar() {
-  const checkedBoxes = document.querySelectorAll(".song-checkbox:checked");
-  const count = checkedBoxes.length;
-  const selectAllCb = document.getElementById("select-all-checkbox");
-  const batchSwitch = document.getElementById("btn-batch-switch");
-  const batchDl = document.getElementById("btn-batch-dl");
-  const batchDeleteLocal = document.getElementById("btn-batch-delete-local");
-  const batchFavLocal = document.getElementById("btn-batch-fav-local");
-  const batchFav = document.getElementById("btn-batch-fav");
-  const batchRemoveCollection = document.getElementById(
-    "btn-batch-remove-collection",
-  );
+function closeLocalMusicModal() {
+  const { modal } = localMusicElements();
+  if (modal) modal.style.display = "none";
+}
 
-  if (document.getElementById("selected-count")) {
-    document.getElementById("selected-count").textContent = count;
+async function refreshLocalMusicList(options = {}) {
+  const { list, dir } = localMusicElements();
+  if (!list) return;
+  if (localMusicModalState.loading) return;
+
+  const append = !!options.append;
+  if (!append) {
+    localMusicModalState.offset = 0;
+    localMusicModalState.total = 0;
+    localMusicModalState.hasMore = false;
   }
 
-  const allBoxes = document.querySelectorAll(".song-checkbox");
-  if (allBoxes.length > 0 && selectAllCb) {
-    selectAllCb.checked = allBoxes.length === count;
+  const params = new URLSearchParams({
+    offset: String(localMusicModalState.offset),
+    limit: String(localMusicModalState.limit),
+  });
+  if (activeLocalMusicCollectionId) {
+    params.set("collection_id", activeLocalMusicCollectionId);
+  }
+  try {
+    localMusicModalState.loading = true;
+    const response = await fetch(
+      `${API_ROOT}/local_music?${params.toString()}`,
+    );
+    const payload = await response.json().catch(() => null);
+    if (!response.ok || !payload || payload.error) {
+      throw new Error((payload && payload.error) || "加载失败");
+    }
+    if (dir) {
+      dir.textContent = `下载目录：${payload.download_dir || webSettings.downloadDir || "-"}`;
+    }
+    renderLocalMusicList(payload, append);
+  } catch (error) {
+    if (!append) {
+      list.innerHTML = "";
+    }
+    setLocalMusicHint(error.message || "加载本地音乐失败", true);
+  } finally {
+    localMusicModalState.loading = false;
   }
+}
 
-  const selectedSongs = getSelectedSongs();
-  const nonLocalCount = selectedSongs.filter(
-    (song) => !isLocalMusicSourceValue(song.source),
-  ).length;
-  const localCount = selectedSongs.filter((song) =>
-    isLocalMusicSourceValue(song.source),
-  ).length;
+function localMusicMissingText(track) {
+  const labels = { title: "标题", artist: "歌手", album: "专辑" };
+  const missing = Array.isArray(track?.missing) ? track.missing : [];
+  if (missing.length === 0) return "";
+  return `缺少${missing.map((key) => labels[key] || key).join("、")}`;
+}
 
-  if (count > 0) {
-    if (batchSwitch) batchSwitch.disabled = nonLocalCount === 0;
-    if (batchDl) batchDl.disabled = nonLocalCount === 0;
-    if (batchDeleteLocal) batchDeleteLocal.disabled = localCount === 0;
-    if (batchFavLocal) batchFavLocal.disabled = localCount === 0;
-    if (batchFav) batchFav.disabled = false;
-    if (batchRemoveCollection) batchRemoveCollection.disabled = false;
+function renderLocalMusicList(payload, append = false) {
+  const { list } = localMusicElements();
+  if (!list) return;
+
+  const tracks = Array.isArray(payload.tracks) ? payload.tracks : [];
+  localMusicModalState.total = parsePositiveInt(payload.total, 0);
+  localMusicModalState.offset =
+    parsePositiveInt(payload.offset, 0) + tracks.length;
+  localMusicModalState.hasMore = !!payload.has_more;
+
+  if (!append) {
+    list.innerHTML = "";
   } else {
-    if (batchSwitch) batchSwitch.disabled = true;
-    if (batchDl) batchDl.disabled = true;
-    if (batchDeleteLocal) batchDeleteLocal.disabled = true;
-    if (batchFavLocal) batchFavLocal.disabled = true;
-    if (batchFav) batchFav.disabled = true;
-    if (batchRemoveCollection) batchRemoveCollection.disabled = true;
+    list.querySelector(".local-music-load-more")?.remove();
   }
 
-  document
-    .querySelectorAll(".song-card")
-    .forEach((card) => card.classList.remove("selected"));
-  checkedBoxes.forEach((cb) => {
-    cb.closest(".song-card").classList.add("selected");
-  });
-}
+  if (!payload.exists) {
+    setLocalMusicHint("下载目录还不存在。上传音乐后会自动创建该目录。");
+    return;
+  }
+  if (tracks.length === 0) {
+    setLocalMusicHint(
+      "下载目录里还没有支持的音频文件，可上传 mp3、flac、m4a、ogg、wav、wma、aac。",
+    );
+    return;
+  }
 
-function toggleSelectAll(mainCb) {
-  const checkboxes = document.querySelectorAll(".song-checkbox");
-  checkboxes.forEach((cb) => (cb.checked = mainCb.checked));
-  updateBatchToolbar();
-}
+  setLocalMusicHint("");
+  tracks.forEach((track) => {
+    const item = document.createElement("div");
+    item.className = "local-music-item";
 
-function selectInvalidSongs() {
-  selectInvalidSongCards();
-}
+    const title = track.name || track.filename || "未命名音乐";
+    const artist = track.artist || "未知歌手";
+    const album = track.album || "未知专辑";
+    const sizeText = track.size_text || "-";
+    const missingText = localMusicMissingText(track);
 
-function getSelectedSongs() {
-  const checkedBoxes = document.querySelectorAll(".song-checkbox:checked");
-  const songs = [];
-  checkedBoxes.forEach((cb) => {
-    const card = cb.closest(".song-card");
-    if (card) {
-      const song = songFromCard(card);
-      if (!song) return;
-      const lyricURLs = lyricURLsForSong(song);
+    const canAddToCollection = !!activeLocalMusicCollectionId;
+    item.innerHTML = `
+            <div class="local-music-cover"><i class="fa-solid fa-music"></i></div>
+            <div class="local-music-main">
+                <div class="local-music-title" title="${escapeHTML(title)}">${escapeHTML(title)}</div>
+                <div class="local-music-meta">
+                    <span>${escapeHTML(artist)}</span>
+                    <span>·</span>
+                    <span>${escapeHTML(album)}</span>
+                    <span>·</span>
+                    <span>${escapeHTML(sizeText)}</span>
+                    ${missingText ? `<span class="local-music-missing">${escapeHTML(missingText)}</span>` : ""}
+                </div>
+                <div class="local-music-file" title="${escapeHTML(track.rel_path || track.filename || "")}">
+                    ${escapeHTML(track.rel_path || track.filename || "")}
+                </div>
+            </div>
+            ${canAddToCollection ? `<button type="button" class="btn-pill btn-pill-primary local-music-add ${track.already_added ? "is-added" : ""}" ${track.already_added ? "disabled" : ""}>
+                <i class="fa-solid ${track.already_added ? "fa-check" : "fa-plus"}"></i> ${track.already_added ? "已添加" : "添加"}
+            </button>` : ""}
+        `;
 
-      songs.push({
-        id: song.id,
-        source: song.source,
-        name: song.name,
-        artist: song.artist,
-        duration: song.duration,
-        extra: song.extra,
-        url: buildDownloadURL(
-          song.id,
-          song.source,
-          song.name,
-          song.artist,
-          song.album || "",
-          song.cover || "",
-          song.extra || "",
-        ),
-        cover: song.cover,
-        lrc: lyricURLs.line,
-        raw_lrc: lyricURLs.auto,
-        theme: "#10b981",
-      });
+    const btn = item.querySelector(".local-music-add");
+    if (canAddToCollection && btn && !track.already_added) {
+      btn.onclick = () => addLocalMusicToCollection(track.id, btn);
     }
+    list.appendChild(item);
   });
-  return songs;
+
+  if (localMusicModalState.hasMore) {
+    const more = document.createElement("button");
+    more.type = "button";
+    more.className = "btn-pill btn-pill-dl local-music-load-more";
+    more.innerHTML = '<i class="fa-solid fa-chevron-down"></i> 加载更多';
+    more.onclick = () => refreshLocalMusicList({ append: true });
+    list.appendChild(more);
+    setLocalMusicHint(
+      `已显示 ${localMusicModalState.offset} / ${localMusicModalState.total} 首`,
+    );
+  } else {
+    setLocalMusicHint("");
+  }
 }
 
-async function batchDownload() {
-  const selectedSongs = getSelectedSongs();
-  const songs = selectedSongs.filter(
-    (song) => !isLocalMusicSourceValue(song.source),
-  );
-  const skippedLocalCount = selectedSongs.length - songs.length;
-  if (selectedSongs.length === 0) return;
-  if (songs.length === 0) {
-    alert("选中的歌曲都是本地歌曲，无需批量下载。");
+function setLocalMusicUploadHint(message, isError = false) {
+  if (isLocalMusicPageActive()) {
+    setLocalMusicPageHint(message, isError);
     return;
   }
-  const batchDl = document.getElementById("btn-batch-dl");
-  const batchSwitch = document.getElementById("btn-batch-switch");
-  const originalBatchDlHTML = batchDl ? batchDl.innerHTML : "";
+  setLocalMusicHint(message, isError);
+}
 
-  if (!confirm(`确认批量下载 ${songs.length} 首歌曲？`)) return;
+async function uploadLocalMusicFile(input) {
+  if (!input || !input.files || input.files.length === 0) return;
 
-  if (batchDl) {
-    batchDl.disabled = true;
-    batchDl.innerHTML = '<i class="fa-solid fa-spinner fa-spin"></i> 下载中';
-  }
-  if (batchSwitch) {
-    batchSwitch.disabled = true;
+  const acceptedExtensions = new Set(["mp3", "flac", "m4a", "ogg", "wav", "wma", "aac"]);
+  const files = Array.from(input.files).filter((file) => {
+    const extension = file.name.split(".").pop().toLowerCase();
+    return acceptedExtensions.has(extension);
+  });
+  const ignoredCount = input.files.length - files.length;
+  if (files.length === 0) {
+    input.value = "";
+    setLocalMusicUploadHint("所选内容中没有可导入的音频文件。", true);
+    return;
   }
 
-  setDownloadRecordsButtonState("downloading");
-  const ignoredLocalText =
-    skippedLocalCount > 0 ? `，已忽略 ${skippedLocalCount} 首本地歌曲` : "";
-  const dismissBatchStartNotice = showToast(
-    "批量下载已开始",
-    `正在保存 ${songs.length} 首歌曲${ignoredLocalText}，进度与结果请查看右侧“下载记录”。`,
-    "info",
-    BATCH_DOWNLOAD_NOTICE_MS,
-  );
-
-  let success = 0;
-  let skipped = 0;
-  let failed = 0;
-  let warningCount = 0;
-
+  const failures = [];
+  let imported = 0;
   try {
-    for (const song of songs) {
+    for (const [index, file] of files.entries()) {
+      setLocalMusicUploadHint(`正在导入 ${index + 1} / ${files.length}：${file.webkitRelativePath || file.name}`);
+      const formData = new FormData();
+      formData.append("file", file);
+      const uploadRoot = document.getElementById("localMusicUploadRoot");
+      if (uploadRoot && uploadRoot.value) formData.append("root", uploadRoot.value);
+
       try {
-        const result = await requestLocalDownload(song.url);
-        if (result && result.skipped) {
-          skipped++;
-        } else {
-          success++;
-          if (result && result.warning) {
-            warningCount++;
-          }
+        const response = await fetch(`${API_ROOT}/local_music/upload`, {
+          method: "POST",
+          body: formData,
+        });
+        const payload = await response.json().catch(() => null);
+        if (!response.ok || !payload || payload.error) {
+          throw new Error((payload && payload.error) || "导入失败");
         }
-      } catch (_) {
-        failed++;
+        imported++;
+      } catch (error) {
+        failures.push(`${file.name}：${error.message || "导入失败"}`);
       }
     }
 
-    const summary = [`成功 ${success}`, `跳过 ${skipped}`, `失败 ${failed}`];
-    if (skippedLocalCount > 0) {
-      summary.push(`忽略本地 ${skippedLocalCount}`);
+    if (isLocalMusicPageActive()) {
+      await refreshLocalMusicPageAfterMutation();
     }
-    if (warningCount > 0) {
-      summary.push(`降级提示 ${warningCount}`);
+    const ignoredText = ignoredCount > 0 ? `，忽略 ${ignoredCount} 个非音频文件` : "";
+    if (failures.length > 0) {
+      setLocalMusicUploadHint(`已导入 ${imported} 首${ignoredText}，${failures.length} 首失败：${failures[0]}`, true);
+    } else {
+      setLocalMusicUploadHint(`已导入 ${imported} 首${ignoredText}。`);
     }
+  } finally {
+    input.value = "";
+  }
+}
 
-    dismissBatchStartNotice(true);
-    showToast(
-      failed > 0 ? "批量下载部分完成" : "批量下载完成",
-      `${summary.join("，")}。详情请查看右侧“下载记录”。`,
-      failed > 0 ? "warning" : "success",
-      BATCH_DOWNLOAD_NOTICE_MS,
-    );
+async function addLocalMusicToCollection(trackId, btn) {
+  if (!activeLocalMusicCollectionId || !trackId) return;
 
-    setDownloadRecordsButtonState("updated");
-    if (await refreshOpenDownloadRecords()) {
-      setDownloadRecordsButtonState("idle");
+  if (btn) {
+    btn.disabled = true;
+    btn.innerHTML = '<i class="fa-solid fa-spinner fa-spin"></i> 添加中';
+  }
+
+  try {
+    const response = await fetch(
+      `${API_ROOT}/collections/${activeLocalMusicCollectionId}/local_music`,
+      {
+        method: "POST",
+        headers: { "Content-Type": "application/json" },
+        body: JSON.stringify({ id: trackId }),
+      },
+    );
+    const payload = await response.json().catch(() => null);
+    if (!response.ok || !payload || payload.error) {
+      throw new Error((payload && payload.error) || "添加失败");
     }
-  } finally {
-    if (batchDl) {
-      batchDl.innerHTML = originalBatchDlHTML;
+
+    if (btn) {
+      btn.classList.add("is-added");
+      btn.innerHTML = '<i class="fa-solid fa-check"></i> 已添加';
     }
-    updateBatchToolbar();
-    if (
-      batchSwitch &&
-      document.querySelectorAll(".song-checkbox:checked").length === 0
-    ) {
-      batchSwitch.disabled = true;
+    await refreshCurrentPageContent({ scroll: false });
+    await refreshLocalMusicList();
+  } catch (error) {
+    if (btn) {
+      btn.disabled = false;
+      btn.innerHTML = '<i class="fa-solid fa-plus"></i> 添加';
     }
+    alert(error.message || "添加失败");
   }
 }
 
-async function deleteLocalMusic(trackId) {
-  const id = String(trackId || "").trim();
-  if (!id) {
-    throw new Error("缺少本地音乐 ID");
+function playAllSongs() {
+  const firstPlayBtn = document.querySelector(".song-card .btn-play");
+  if (firstPlayBtn) {
+    playAllAndJumpTo(firstPlayBtn);
+  } else {
+    alert("列表为空，无法播放");
   }
+}
 
-  const response = await fetch(
-    `${API_ROOT}/local_music?id=${encodeURIComponent(id)}`,
-    {
-      method: "DELETE",
-    },
+let pendingBatchFavIds = [];
+let pendingBatchFavSongs = [];
+
+function batchAddToCollection() {
+  const songs = getSelectedSongs();
+  if (songs.length === 0) {
+    alert("请先选择歌曲");
+    return;
+  }
+  pendingBatchFavSongs = songs
+    .map((song) => ({
+      id: song.id,
+      source: song.source,
+      name: song.name,
+      artist: song.artist,
+      cover: song.cover,
+      duration: song.duration,
+      extra: song.extra,
+    }))
+    .filter((song) => song.id && song.source);
+  pendingBatchFavIds = [];
+  pendingFavSong = null;
+  document.getElementById("addToCollectionModal").style.display = "flex";
+  refreshAddToCollectionList();
+}
+
+async function submitBatchAddToCollection(colId) {
+  const songs = pendingBatchFavSongs.slice();
+  pendingBatchFavSongs = [];
+  if (!colId || songs.length === 0) return;
+
+  try {
+    const response = await fetch(
+      `${API_ROOT}/collections/${colId}/songs/batch`,
+      {
+        method: "POST",
+        headers: { "Content-Type": "application/json" },
+        body: JSON.stringify({ songs }),
+      },
+    );
+    const payload = await response.json().catch(() => null);
+    if (!response.ok || !payload || payload.error) {
+      throw new Error((payload && payload.error) || "批量收藏失败");
+    }
+    document.getElementById("addToCollectionModal").style.display = "none";
+    let message = `批量收藏完成：新增 ${payload.added || 0}`;
+    if (payload.duplicate) message += `，已存在 ${payload.duplicate}`;
+    if (payload.failed) message += `，失败 ${payload.failed}`;
+    alert(message);
+  } catch (error) {
+    alert(error.message || "批量收藏失败");
+  }
+}
+
+function batchAddLocalMusicToCollection() {
+  const songs = getSelectedSongs().filter((song) =>
+    isLocalMusicSourceValue(song.source),
   );
-  const payload = await response.json().catch(() => null);
-  if (!response.ok || !payload || payload.error) {
-    throw new Error((payload && payload.error) || "删除失败");
+  if (songs.length === 0) {
+    alert("请先选择本地音乐");
+    return;
   }
-  return payload;
+  pendingBatchFavIds = songs.map((song) => song.id).filter(Boolean);
+  pendingBatchFavSongs = [];
+  pendingFavSong = null;
+  document.getElementById("addToCollectionModal").style.display = "flex";
+  refreshAddToCollectionList();
 }
 
-function confirmLocalMusicDeletion(songs) {
-  const items = Array.isArray(songs) ? songs : [];
-  if (items.length === 0) return false;
+async function submitBatchAddLocalMusicToCollection(colId) {
+  const ids = pendingBatchFavIds.slice();
+  pendingBatchFavIds = [];
+  if (!colId || ids.length === 0) return;
 
-  const names = items.slice(0, 5).map(formatBatchSongLabel).join("\n");
-  const more = items.length > 5 ? `\n...等 ${items.length} 首` : "";
-  const scope =
-    items.length === 1
-      ? `《${formatBatchSongLabel(items[0])}》`
-      : `${items.length} 首本地音乐`;
-  if (
-    !confirm(
-      `准备删除 ${scope}。\n删除后文件会从本地下载目录移除，且不可恢复。\n\n${names}${more}`,
-    )
-  ) {
-    return false;
+  try {
+    const response = await fetch(
+      `${API_ROOT}/collections/${colId}/local_music/batch`,
+      {
+        method: "POST",
+        headers: { "Content-Type": "application/json" },
+        body: JSON.stringify({ ids }),
+      },
+    );
+    const payload = await response.json().catch(() => null);
+    if (!response.ok || !payload || payload.error) {
+      throw new Error((payload && payload.error) || "批量收藏失败");
+    }
+    document.getElementById("addToCollectionModal").style.display = "none";
+    let message = `批量收藏完成：新增 ${payload.added || 0}`;
+    if (payload.duplicate) message += `，已存在 ${payload.duplicate}`;
+    if (payload.failed) message += `，失败 ${payload.failed}`;
+    alert(message);
+    await refreshCurrentPageContent({ scroll: false });
+  } catch (error) {
+    alert(error.message || "批量收藏失败");
   }
-  return confirm(`再次确认：确定永久删除 ${scope} 吗？`);
 }
 
-function stopDeletedLocalMusicPlayback(deletedIds) {
-  if (!currentPlayingId || !deletedIds || !deletedIds.has(currentPlayingId))
-    return;
-  try {
-    ap?.pause();
-    ap?.list?.clear();
-  } catch (_) {}
-  currentPlayingId = null;
-  highlightCard(null);
-  syncAllPlayButtons();
-  syncMediaSession();
+function openCollectionManager() {
+  navigateTo(API_ROOT + "/my_collections");
 }
 
-async function deleteLocalMusicFromButton(btn) {
-  const card = btn?.closest(".song-card");
-  const song = songFromCard(card);
-  if (!song || !isLocalMusicSourceValue(song.source)) return;
-  if (!confirmLocalMusicDeletion([song])) return;
+function openLocalMusicPage() {
+  closeDownloadRecordsModal();
+  navigateTo(API_ROOT + "/local_music_page");
+}
 
-  const originalHTML = btn.innerHTML;
-  btn.disabled = true;
-  btn.innerHTML = '<i class="fa-solid fa-spinner fa-spin"></i>';
+function showEditCollectionModal(id = "", name = "", desc = "", cover = "") {
+  document.getElementById("editColTitle").textContent = id
+    ? "编辑歌单"
+    : "新建歌单";
+  document.getElementById("editColId").value = id;
+  document.getElementById("editColName").value = name;
+  document.getElementById("editColDesc").value = desc;
 
-  try {
-    await deleteLocalMusic(song.id);
-    stopDeletedLocalMusicPlayback(new Set([song.id]));
-    await refreshLocalMusicPageAfterMutation();
-  } catch (error) {
-    alert(error.message || "删除失败");
-  } finally {
-    if (btn.isConnected) {
-      btn.innerHTML = originalHTML;
-      btn.disabled = false;
-    }
+  if (cover && cover.includes("picsum.photos")) {
+    document.getElementById("editColCover").value = "";
+  } else {
+    document.getElementById("editColCover").value = cover;
   }
+
+  document.getElementById("editCollectionModal").style.display = "flex";
 }
 
-async function batchDeleteLocalMusic() {
-  const songs = getSelectedSongs().filter((song) =>
-    isLocalMusicSourceValue(song.source),
+function showEditCollectionModalFromButton(btn) {
+  if (!btn) return;
+  showEditCollectionModal(
+    btn.dataset.id || "",
+    btn.dataset.name || "",
+    btn.dataset.description || "",
+    btn.dataset.cover || "",
   );
-  if (songs.length === 0) return;
+}
 
-  if (!confirmLocalMusicDeletion(songs)) {
-    return;
-  }
+function saveCollection() {
+  const id = document.getElementById("editColId").value;
+  const name = document.getElementById("editColName").value.trim();
+  const desc = document.getElementById("editColDesc").value.trim();
+  const cover = document.getElementById("editColCover").value.trim();
 
-  const batchDelete = document.getElementById("btn-batch-delete-local");
-  const originalHTML = batchDelete ? batchDelete.innerHTML : "";
-  if (batchDelete) {
-    batchDelete.disabled = true;
-    batchDelete.innerHTML =
-      '<i class="fa-solid fa-spinner fa-spin"></i> 删除中';
-  }
+  if (!name) return alert("名称不能为空");
 
-  let success = 0;
-  const failures = [];
-  const deletedIds = new Set();
+  const payload = { name, description: desc, cover };
+  const isAddingSongModalOpen =
+    document.getElementById("addToCollectionModal").style.display === "flex";
 
-  try {
-    for (const song of songs) {
-      try {
-        await deleteLocalMusic(song.id);
-        success++;
-        deletedIds.add(song.id);
-      } catch (error) {
-        failures.push({
-          song,
-          reason: error && error.message ? error.message : "删除失败",
-        });
+  const url = id ? `${API_ROOT}/collections/${id}` : `${API_ROOT}/collections`;
+  const method = id ? "PUT" : "POST";
+
+  fetch(url, {
+    method: method,
+    headers: { "Content-Type": "application/json" },
+    body: JSON.stringify(payload),
+  })
+    .then((r) => r.json())
+    .then((res) => {
+      if (res.error) return alert(res.error);
+
+      document.getElementById("editCollectionModal").style.display = "none";
+
+      if (isAddingSongModalOpen) {
+        refreshAddToCollectionList();
+      } else {
+        refreshCurrentPageContent();
       }
-    }
+    });
+}
 
-    stopDeletedLocalMusicPlayback(deletedIds);
+function setImportCollectionButtonState(btn, imported) {
+  if (!btn) return;
 
-    let message = `批量删除完成，成功 ${success}/${songs.length}`;
-    message += buildBatchFailureMessage(failures, "失败");
-    alert(message);
-    await refreshLocalMusicPageAfterMutation();
-  } finally {
-    if (batchDelete) {
-      batchDelete.innerHTML = originalHTML;
-    }
-    updateBatchToolbar();
+  btn.disabled = !!imported;
+  if (imported) {
+    btn.innerHTML = '<i class="fa-solid fa-check"></i> 已导入';
+    btn.style.opacity = "0.85";
+  } else {
+    btn.innerHTML = '<i class="fa-solid fa-download"></i> 导入本地';
+    btn.style.opacity = "";
   }
 }
 
-async function batchSwitchSource(options = {}) {
-  const optionCards = Array.isArray(options.cards)
-    ? options.cards.filter((card) => card && card.isConnected)
-    : null;
-  const checkedBoxes = optionCards
-    ? optionCards
-        .map((card) => card.querySelector(".song-checkbox"))
-        .filter(Boolean)
-    : Array.from(document.querySelectorAll(".song-checkbox:checked"));
-  if (checkedBoxes.length === 0) return false;
+function importCollectionFromButton(btn) {
+  if (!btn) return;
 
-  const candidateCards =
-    optionCards || checkedBoxes.map((cb) => cb.closest(".song-card"));
-  const cards = candidateCards.filter(
-    (card) => card && !isLocalMusicSourceValue(card.dataset.source),
-  );
-  const skippedLocalCount = candidateCards.length - cards.length;
-  if (cards.length === 0) {
-    if (!options.silent) {
-      alert("选中的歌曲都是本地歌曲，无需批量换源。");
-    }
-    return false;
-  }
+  const payload = {
+    name: btn.dataset.name || "",
+    description: btn.dataset.description || "",
+    cover: btn.dataset.cover || "",
+    creator: btn.dataset.creator || "",
+    track_count: parsePositiveInt(btn.dataset.trackCount, 0),
+    source: btn.dataset.source || "",
+    external_id: btn.dataset.externalId || "",
+    link: btn.dataset.link || "",
+    content_type: btn.dataset.contentType || "playlist",
+  };
 
-  const skipText =
-    skippedLocalCount > 0 ? `\n已跳过 ${skippedLocalCount} 首本地歌曲。` : "";
-  if (
-    !options.skipConfirm &&
-    !confirm(
-      `准备对 ${cards.length} 首歌曲进行自动换源。\n这可能需要一些时间，请耐心等待。${skipText}`,
-    )
-  ) {
-    return false;
+  if (!payload.source || !payload.external_id) {
+    alert("缺少导入参数");
+    return;
   }
 
-  const batchSwitch = document.getElementById("btn-batch-switch");
-  const originalHTML = batchSwitch ? batchSwitch.innerHTML : "";
-  if (batchSwitch) {
-    batchSwitch.disabled = true;
-    batchSwitch.innerHTML =
-      '<i class="fa-solid fa-spinner fa-spin"></i> 换源中';
-  }
+  btn.disabled = true;
+  btn.style.opacity = "0.6";
 
-  const concurrency = Math.min(3, cards.length);
-  let nextIndex = 0;
-  const runWorker = async () => {
-    while (nextIndex < cards.length) {
-      const card = cards[nextIndex++];
-      const switchBtn = card.querySelector(".btn-switch");
-      if (switchBtn) {
-        await switchSource(switchBtn, {
-          silent: !!options.silent,
-          deferToolbar: true,
-        });
+  fetch(`${API_ROOT}/collections/import`, {
+    method: "POST",
+    headers: { "Content-Type": "application/json" },
+    body: JSON.stringify(payload),
+  })
+    .then((r) => r.json())
+    .then((res) => {
+      if (res.error) {
+        btn.disabled = false;
+        btn.style.opacity = "";
+        alert(res.error);
+        return;
       }
-    }
+
+      setImportCollectionButtonState(btn, true);
+      alert(
+        res.duplicate
+          ? "该歌单/专辑已在本地列表中"
+          : "导入成功，已加入本地歌单列表",
+      );
+    })
+    .catch(() => {
+      btn.disabled = false;
+      btn.style.opacity = "";
+      alert("导入失败，请稍后重试");
+    });
+}
+
+function deleteCollection(id) {
+  if (!confirm("确定删除此歌单吗？内部歌曲记录也将被清空。")) return;
+  fetch(`${API_ROOT}/collections/${id}`, { method: "DELETE" })
+    .then((r) => r.json())
+    .then((res) => {
+      if (res.error) return alert(res.error);
+      refreshCurrentPageContent();
+    });
+}
+
+function deleteCollectionFromModal(id) {
+  if (!confirm("确定删除此歌单吗？内部歌曲记录也将被清空。")) return;
+  fetch(`${API_ROOT}/collections/${id}`, { method: "DELETE" })
+    .then((r) => r.json())
+    .then((res) => {
+      if (res.error) return alert(res.error);
+      refreshAddToCollectionList();
+    });
+}
+
+// ==========================================
+// 歌单文件导入（M3U / XSPF / JSON / CSV）
+// ==========================================
+
+const PLAYLIST_IMPORT_POLL_INTERVAL = 1500;
+let playlistImportState = { collectionId: 0, job: null, timer: null };
+
+function closePlaylistFileImportModal() {
+  document.getElementById("playlist-import-modal-overlay")?.remove();
+  clearTimeout(playlistImportState.timer);
+  playlistImportState.timer = null;
+}
+
+async function openPlaylistFileImportModal(collectionId = 0) {
+  closePlaylistFileImportModal();
+  playlistImportState = {
+    collectionId: Number(collectionId) || 0,
+    job: null,
+    timer: null,
+  };
+
+  const overlay = document.createElement("div");
+  overlay.id = "playlist-import-modal-overlay";
+  overlay.className = "modal-overlay utility-modal-overlay is-open";
+  overlay.onclick = (e) => {
+    if (e.target === overlay) closePlaylistFileImportModal();
   };
+  const scope = playlistImportState.collectionId
+    ? "把歌单文件里的歌曲加入当前歌单"
+    : "从 M3U/M3U8/XSPF/JSON/CSV 文件新建本地歌单";
+  overlay.innerHTML = `
+    <div class="modal utility-modal playlist-import-modal">
+      <div class="modal-header">
+        <div><h3><i class="fa-solid fa-file-import"></i> 导入歌单文件</h3><p class="utility-modal-subtitle">${escapeHTML(scope)}，先在本地曲库查找，找不到再到在线音源匹配</p></div>
+        <button type="button" class="modal-close" aria-label="关闭导入歌单文件" onclick="closePlaylistFileImportModal()"><i class="fa-solid fa-xmark"></i></button>
+      </div>
+      <div class="modal-body">
+        <div class="playlist-import-options">
+          <input type="file" id="playlistImportFile" accept=".m3u,.m3u8,.xspf,.json,.csv,.txt">
+          <input type="text" id="playlistImportName" placeholder="歌单名（留空使用文件里的名称）" autocomplete="off" ${playlistImportState.collectionId ? "hidden" : ""}>
+        </div>
+        <div class="playlist-import-options">
+          <label><input type="checkbox" id="playlistImportOnline" checked> 本地找不到时搜索在线音源</label>
+          <label>自动匹配阈值
+            <select id="playlistImportThreshold">
+              <option value="0.75">75%</option>
+              <option value="0.85" selected>85%</option>
+              <option value="0.95">95%</option>
+            </select>
+          </label>
+          <button type="button" class="btn-pill btn-pill-primary" id="playlistImportStartBtn" onclick="startPlaylistFileImport()"><i class="fa-solid fa-play"></i> 开始匹配</button>
+        </div>
+        <div id="playlistImportProgress" class="setting-inline-status"></div>
+        <div id="playlistImportResults" class="identify-results"></div>
+        <div class="identify-actions">
+          <button type="button" class="btn-pill" id="playlistImportCancelBtn" onclick="cancelPlaylistFileImport()" hidden><i class="fa-solid fa-stop"></i> 停止匹配</button>
+          <button type="button" class="btn-pill btn-pill-dl" id="playlistImportApplyBtn" onclick="applyPlaylistFileImport()" hidden><i class="fa-solid fa-check"></i> 导入所选</button>
+        </div>
+      </div>
+    </div>`;
+  document.body.appendChild(overlay);
+
   try {
-    await Promise.all(Array.from({ length: concurrency }, runWorker));
-    return true;
-  } finally {
-    if (batchSwitch) {
-      batchSwitch.innerHTML = originalHTML;
+    const response = await fetch(`${API_ROOT}/collections/import_file`);
+    const payload = await response.json().catch(() => null);
+    if (payload?.job?.status === "running") {
+      renderPlaylistFileImportJob(payload.job);
+      schedulePlaylistFileImportPoll();
     }
-    updateBatchToolbar();
-  }
+  } catch (_) {}
 }
 
-async function batchRemoveFromCollection(colId) {
-  const id = String(colId || "").trim();
-  const songs = getSelectedSongs();
-  if (!id || songs.length === 0) return;
+function setPlaylistImportProgress(text, className = "") {
+  const progress = document.getElementById("playlistImportProgress");
+  if (!progress) return;
+  progress.textContent = text;
+  progress.className = `setting-inline-status${className ? ` ${className}` : ""}`;
+}
 
-  if (!confirm(`确定从当前自建歌单中取消收藏 ${songs.length} 首歌曲吗？`)) {
+async function startPlaylistFileImport() {
+  const file = document.getElementById("playlistImportFile")?.files?.[0];
+  if (!file) {
+    setPlaylistImportProgress("请选择歌单文件", "error");
     return;
   }
-
-  const btn = document.getElementById("btn-batch-remove-collection");
-  const originalHTML = btn ? btn.innerHTML : "";
-  if (btn) {
-    btn.disabled = true;
-    btn.innerHTML = '<i class="fa-solid fa-spinner fa-spin"></i> 移出中';
-  }
-
+  const form = new FormData();
+  form.append("file", file);
+  form.append(
+    "name",
+    document.getElementById("playlistImportName")?.value.trim() || "",
+  );
+  form.append(
+    "threshold",
+    document.getElementById("playlistImportThreshold")?.value || "0.85",
+  );
+  form.append(
+    "online",
+    document.getElementById("playlistImportOnline")?.checked ? "1" : "0",
+  );
+  setPlaylistImportProgress("正在读取文件...");
   try {
-    const response = await fetch(
-      `${API_ROOT}/collections/${encodeURIComponent(id)}/songs`,
-      {
-        method: "DELETE",
-        headers: { "Content-Type": "application/json" },
-        body: JSON.stringify({
-          songs: songs.map((song) => ({ id: song.id, source: song.source })),
-        }),
-      },
-    );
+    const response = await fetch(`${API_ROOT}/collections/import_file`, {
+      method: "POST",
+      headers: { "X-Requested-With": "XMLHttpRequest" },
+      body: form,
+    });
     const payload = await response.json().catch(() => null);
     if (!response.ok || !payload || payload.error) {
-      throw new Error((payload && payload.error) || "批量取消收藏失败");
+      throw new Error((payload && payload.error) || "导入失败");
+    }
+    if (payload.status === "imported") {
+      // 本程序导出的 JSON 带着完整的歌曲信息，不需要匹配。
+      closePlaylistFileImportModal();
+      showToast(
+        "导入完成",
+        `新建 ${payload.created} 个歌单，已有同名歌单的歌曲合并进原歌单`,
+        "success",
+        4000,
+      );
+      refreshCurrentPageContent();
+      return;
     }
-    alert(`已从当前歌单取消收藏 ${songs.length} 首歌曲。`);
-    await refreshCurrentPageContent({ scroll: false });
+    renderPlaylistFileImportJob(payload.job);
+    schedulePlaylistFileImportPoll();
   } catch (error) {
-    alert(error.message || "批量取消收藏失败");
-  } finally {
-    if (btn && btn.isConnected) {
-      btn.innerHTML = originalHTML;
-    }
-    updateBatchToolbar();
+    setPlaylistImportProgress(error.message || "导入失败", "error");
   }
 }
 
-// ==========================================
-// 自制歌单（本地收藏夹）前端交互
-// ==========================================
-
-let pendingFavSong = null;
-let activeLocalMusicCollectionId = "";
-let localMusicModalState = {
-  offset: 0,
-  limit: 80,
-  total: 0,
-  hasMore: false,
-  loading: false,
-};
-
-function localMusicElements() {
-  return {
-    modal: document.getElementById("localMusicModal"),
-    list: document.getElementById("localMusicList"),
-    hint: document.getElementById("localMusicHint"),
-    dir: document.getElementById("localMusicDir"),
-  };
+function schedulePlaylistFileImportPoll() {
+  clearTimeout(playlistImportState.timer);
+  playlistImportState.timer = setTimeout(
+    pollPlaylistFileImport,
+    PLAYLIST_IMPORT_POLL_INTERVAL,
+  );
 }
 
-function setLocalMusicHint(message, isError = false) {
-  const { hint } = localMusicElements();
-  if (!hint) return;
-  hint.textContent = message || "";
-  hint.classList.toggle("error", !!isError);
-  hint.style.display = message ? "block" : "none";
+async function pollPlaylistFileImport() {
+  if (!document.getElementById("playlist-import-modal-overlay")) return;
+  try {
+    const response = await fetch(`${API_ROOT}/collections/import_file`);
+    const payload = await response.json().catch(() => null);
+    if (!payload?.job) return;
+    renderPlaylistFileImportJob(payload.job);
+    if (payload.job.status === "running") schedulePlaylistFileImportPoll();
+  } catch (_) {
+    schedulePlaylistFileImportPoll();
+  }
 }
 
-async function openLocalMusicModal(collectionId = "") {
-  activeLocalMusicCollectionId = String(collectionId || "").trim();
-  const { modal, list, dir } = localMusicElements();
-  if (!modal) return;
-
-  closeDownloadRecordsModal();
-  if (list) list.innerHTML = "";
-  if (dir) dir.textContent = `下载目录：${webSettings.downloadDir || "-"}`;
-  setLocalMusicHint("正在加载本地音乐...");
-  modal.style.display = "flex";
-  refreshLocalMusicList();
+function playlistImportCandidateLabel(candidate) {
+  const parts = [candidate.name, candidate.artist, candidate.album].filter(
+    Boolean,
+  );
+  const where = candidate.local ? "本地" : candidate.source;
+  return `${parts.join(" · ")} [${where}] ${Math.round(candidate.confidence * 100)}%`;
 }
 
-function closeLocalMusicModal() {
-  const { modal } = localMusicElements();
-  if (modal) modal.style.display = "none";
+function renderPlaylistFileImportEntry(entry) {
+  const label = escapeHTML(
+    [entry.title, entry.artist].filter(Boolean).join(" · ") ||
+      entry.location ||
+      `#${entry.index + 1}`,
+  );
+  const candidates = entry.candidates || [];
+  if (candidates.length === 0) {
+    const reason = entry.status === "pending" ? "等待匹配" : "没有找到匹配";
+    return `<div class="identify-result is-empty"><strong>${label}</strong><span>${reason}</span></div>`;
+  }
+  const matched = entry.status === "matched";
+  const options = candidates
+    .map(
+      (candidate, i) =>
+        `<option value="${i}" ${i === entry.selected ? "selected" : ""}>${escapeHTML(playlistImportCandidateLabel(candidate))}</option>`,
+    )
+    .join("");
+  const confidence = Math.round((entry.confidence || 0) * 100);
+  return `<label class="identify-result${matched ? "" : " is-review"}">
+      <input type="checkbox" class="playlist-import-check" value="${entry.index}" ${matched ? "checked" : ""}>
+      <span class="identify-result-main">
+        <strong>${label}</strong>
+        <select class="playlist-import-candidate" data-index="${entry.index}">${options}</select>
+        <span class="identify-result-fields">${matched ? "已自动匹配" : "置信度较低，请确认"}</span>
+      </span>
+      <span class="identify-confidence${matched ? " is-high" : ""}">${confidence}%</span>
+    </label>`;
+}
+
+function renderPlaylistFileImportJob(job) {
+  if (!job) return;
+  playlistImportState.job = job;
+  const running = job.status === "running";
+  const statusText = running
+    ? "匹配中"
+    : job.status === "cancelled"
+      ? "已取消"
+      : "匹配完成";
+  setPlaylistImportProgress(
+    `${statusText}：${job.processed}/${job.total}，自动匹配 ${job.matched} 首，待确认 ${job.review} 首，未找到 ${job.unmatched} 首`,
+  );
+  const startBtn = document.getElementById("playlistImportStartBtn");
+  if (startBtn) startBtn.disabled = running;
+  const cancelBtn = document.getElementById("playlistImportCancelBtn");
+  if (cancelBtn) cancelBtn.hidden = !running;
+  const nameInput = document.getElementById("playlistImportName");
+  if (nameInput && !nameInput.value && job.name) {
+    nameInput.placeholder = `歌单名：${job.name}`;
+  }
+  const results = document.getElementById("playlistImportResults");
+  if (results) {
+    results.innerHTML = (job.entries || [])
+      .map((entry) => renderPlaylistFileImportEntry(entry))
+      .join("");
+  }
+  const applyBtn = document.getElementById("playlistImportApplyBtn");
+  if (applyBtn) {
+    applyBtn.hidden =
+      running ||
+      !(job.entries || []).some((entry) => (entry.candidates || []).length);
+  }
 }
 
-async function refreshLocalMusicList(options = {}) {
-  const { list, dir } = localMusicElements();
-  if (!list) return;
-  if (localMusicModalState.loading) return;
-
-  const append = !!options.append;
-  if (!append) {
-    localMusicModalState.offset = 0;
-    localMusicModalState.total = 0;
-    localMusicModalState.hasMore = false;
-  }
+async function cancelPlaylistFileImport() {
+  try {
+    await postLocalMusicTagRequest("/collections/import_file/cancel", {});
+  } catch (_) {}
+  await pollPlaylistFileImport();
+}
 
-  const params = new URLSearchParams({
-    offset: String(localMusicModalState.offset),
-    limit: String(localMusicModalState.limit),
+async function applyPlaylistFileImport() {
+  const job = playlistImportState.job;
+  const items = Array.from(
+    document.querySelectorAll(".playlist-import-check:checked"),
+  ).map((input) => {
+    const index = Number(input.value);
+    const select = document.querySelector(
+      `.playlist-import-candidate[data-index="${index}"]`,
+    );
+    return { index, candidate: Number(select?.value || 0) };
   });
-  if (activeLocalMusicCollectionId) {
-    params.set("collection_id", activeLocalMusicCollectionId);
+  if (!job || items.length === 0) {
+    setPlaylistImportProgress("请勾选要导入的歌曲", "error");
+    return;
   }
   try {
-    localMusicModalState.loading = true;
-    const response = await fetch(
-      `${API_ROOT}/local_music?${params.toString()}`,
+    const payload = await postLocalMusicTagRequest(
+      "/collections/import_file/apply",
+      {
+        job_id: job.id,
+        collection_id: playlistImportState.collectionId,
+        name: document.getElementById("playlistImportName")?.value.trim() || "",
+        items,
+      },
     );
-    const payload = await response.json().catch(() => null);
-    if (!response.ok || !payload || payload.error) {
-      throw new Error((payload && payload.error) || "加载失败");
-    }
-    if (dir) {
-      dir.textContent = `下载目录：${payload.download_dir || webSettings.downloadDir || "-"}`;
-    }
-    renderLocalMusicList(payload, append);
+    closePlaylistFileImportModal();
+    showToast(
+      "导入完成",
+      `已向「${payload.name}」加入 ${payload.added} 首`,
+      "success",
+      4000,
+    );
+    navigateTo(`${API_ROOT}/collection?id=${payload.id}`);
   } catch (error) {
-    if (!append) {
-      list.innerHTML = "";
-    }
-    setLocalMusicHint(error.message || "加载本地音乐失败", true);
-  } finally {
-    localMusicModalState.loading = false;
+    setPlaylistImportProgress(error.message || "导入失败", "error");
   }
 }
 
-function localMusicMissingText(track) {
-  const labels = { title: "标题", artist: "歌手", album: "专辑" };
-  const missing = Array.isArray(track?.missing) ? track.missing : [];
-  if (missing.length === 0) return "";
-  return `缺少${missing.map((key) => labels[key] || key).join("、")}`;
-}
+// ==========================================
+// 跨平台歌单迁移
+// ==========================================
 
-function renderLocalMusicList(payload, append = false) {
-  const { list } = localMusicElements();
-  if (!list) return;
+const COLLECTION_MIGRATION_POLL_INTERVAL = 1500;
+const COLLECTION_MIGRATION_STATUS_LABELS = {
+  pending: "等待匹配",
+  matched: "已匹配",
+  low_confidence: "置信度较低，请确认",
+  not_found: "没有找到",
+};
+let collectionMigrationState = { request: null, migrationId: 0, timer: null };
+
+function closeCollectionMigrationModal() {
+  document.getElementById("collection-migration-modal-overlay")?.remove();
+  clearTimeout(collectionMigrationState.timer);
+  collectionMigrationState.timer = null;
+}
+
+async function openCollectionMigrationModal(btn) {
+  closeCollectionMigrationModal();
+  const data = btn?.dataset || {};
+  collectionMigrationState = {
+    request: {
+      kind: data.kind || "collection",
+      source: data.source || "",
+      id: data.id || "",
+      link: data.link || "",
+      collection_id: Number(data.collectionId) || 0,
+    },
+    migrationId: 0,
+    timer: null,
+  };
 
-  const tracks = Array.isArray(payload.tracks) ? payload.tracks : [];
-  localMusicModalState.total = parsePositiveInt(payload.total, 0);
-  localMusicModalState.offset =
-    parsePositiveInt(payload.offset, 0) + tracks.length;
-  localMusicModalState.hasMore = !!payload.has_more;
+  const overlay = document.createElement("div");
+  overlay.id = "collection-migration-modal-overlay";
+  overlay.className = "modal-overlay utility-modal-overlay is-open";
+  overlay.onclick = (e) => {
+    if (e.target === overlay) closeCollectionMigrationModal();
+  };
+  overlay.innerHTML = `
+    <div class="modal utility-modal playlist-import-modal">
+      <div class="modal-header">
+        <div><h3><i class="fa-solid fa-right-left"></i> 迁移到其他平台</h3><p class="utility-modal-subtitle">逐首在目标平台查找同一首歌，生成新的本地歌单和匹配报告</p></div>
+        <button type="button" class="modal-close" aria-label="关闭歌单迁移" onclick="closeCollectionMigrationModal()"><i class="fa-solid fa-xmark"></i></button>
+      </div>
+      <div class="modal-body">
+        <div class="playlist-import-options" id="collectionMigrationTargets">加载目标平台...</div>
+        <div class="playlist-import-options">
+          <input type="text" id="collectionMigrationName" placeholder="新歌单名（留空使用「${escapeHTML(data.name || "原歌单")} → 目标平台」）" autocomplete="off">
+          <label>自动匹配阈值
+            <select id="collectionMigrationThreshold">
+              <option value="0.8">80%</option>
+              <option value="0.9" selected>90%</option>
+              <option value="0.98">98%</option>
+            </select>
+          </label>
+          <label><input type="checkbox" id="collectionMigrationValidate" checked> 校验能否播放</label>
+          <button type="button" class="btn-pill btn-pill-primary" id="collectionMigrationStartBtn" onclick="startCollectionMigration()"><i class="fa-solid fa-play"></i> 开始迁移</button>
+        </div>
+        <div id="collectionMigrationProgress" class="setting-inline-status"></div>
+        <div id="collectionMigrationResults" class="identify-results"></div>
+        <div class="identify-actions" id="collectionMigrationActions">
+          <button type="button" class="btn-pill" id="collectionMigrationCancelBtn" onclick="cancelCollectionMigration()" hidden><i class="fa-solid fa-stop"></i> 停止迁移</button>
+        </div>
+      </div>
+    </div>`;
+  document.body.appendChild(overlay);
 
-  if (!append) {
-    list.innerHTML = "";
-  } else {
-    list.querySelector(".local-music-load-more")?.remove();
-  }
+  try {
+    const response = await fetch(`${API_ROOT}/collections/migrate/targets`);
+    const payload = await response.json().catch(() => null);
+    const targets = payload?.targets || [];
+    const container = document.getElementById("collectionMigrationTargets");
+    if (!container) return;
+    container.innerHTML = targets.length
+      ? targets
+          .filter((target) => target.name !== data.source)
+          .map(
+            (target) =>
+              `<label><input type="checkbox" class="collection-migration-target" value="${escapeHTML(target.name)}"> ${escapeHTML(target.label || target.name)}</label>`,
+          )
+          .join("")
+      : "没有可用的目标平台";
+
+    const current = await fetch(`${API_ROOT}/collections/migrate`);
+    const running = await current.json().catch(() => null);
+    if (running?.job?.status === "running") {
+      renderCollectionMigrationJob(running.job);
+      scheduleCollectionMigrationPoll();
+    }
+  } catch (_) {}
+}
 
-  if (!payload.exists) {
-    setLocalMusicHint("下载目录还不存在。上传音乐后会自动创建该目录。");
+function setCollectionMigrationProgress(text, className = "") {
+  const progress = document.getElementById("collectionMigrationProgress");
+  if (!progress) return;
+  progress.textContent = text;
+  progress.className = `setting-inline-status${className ? ` ${className}` : ""}`;
+}
+
+async function startCollectionMigration() {
+  const targets = Array.from(
+    document.querySelectorAll(".collection-migration-target:checked"),
+  ).map((input) => input.value);
+  if (targets.length === 0) {
+    setCollectionMigrationProgress("请选择至少一个目标平台", "error");
     return;
   }
-  if (tracks.length === 0) {
-    setLocalMusicHint(
-      "下载目录里还没有支持的音频文件，可上传 mp3、flac、m4a、ogg、wav、wma、aac。",
-    );
-    return;
+  setCollectionMigrationProgress("正在读取原歌单...");
+  try {
+    const payload = await postLocalMusicTagRequest("/collections/migrate", {
+      ...collectionMigrationState.request,
+      name: document.getElementById("collectionMigrationName")?.value.trim() || "",
+      targets,
+      threshold: Number(
+        document.getElementById("collectionMigrationThreshold")?.value || 0.9,
+      ),
+      validate: !!document.getElementById("collectionMigrationValidate")?.checked,
+    });
+    renderCollectionMigrationJob(payload.job);
+    scheduleCollectionMigrationPoll();
+  } catch (error) {
+    setCollectionMigrationProgress(error.message || "迁移失败", "error");
   }
+}
 
-  setLocalMusicHint("");
-  tracks.forEach((track) => {
-    const item = document.createElement("div");
-    item.className = "local-music-item";
+function scheduleCollectionMigrationPoll() {
+  clearTimeout(collectionMigrationState.timer);
+  collectionMigrationState.timer = setTimeout(
+    pollCollectionMigration,
+    COLLECTION_MIGRATION_POLL_INTERVAL,
+  );
+}
 
-    const title = track.name || track.filename || "未命名音乐";
-    const artist = track.artist || "未知歌手";
-    const album = track.album || "未知专辑";
-    const sizeText = track.size_text || "-";
-    const missingText = localMusicMissingText(track);
+async function pollCollectionMigration() {
+  if (!document.getElementById("collection-migration-modal-overlay")) return;
+  try {
+    const response = await fetch(`${API_ROOT}/collections/migrate`);
+    const payload = await response.json().catch(() => null);
+    if (!payload?.job) return;
+    renderCollectionMigrationJob(payload.job);
+    if (payload.job.status === "running") scheduleCollectionMigrationPoll();
+  } catch (_) {
+    scheduleCollectionMigrationPoll();
+  }
+}
 
-    const canAddToCollection = !!activeLocalMusicCollectionId;
-    item.innerHTML = `
-            <div class="local-music-cover"><i class="fa-solid fa-music"></i></div>
-            <div class="local-music-main">
-                <div class="local-music-title" title="${escapeHTML(title)}">${escapeHTML(title)}</div>
-                <div class="local-music-meta">
-                    <span>${escapeHTML(artist)}</span>
-                    <span>·</span>
-                    <span>${escapeHTML(album)}</span>
-                    <span>·</span>
-                    <span>${escapeHTML(sizeText)}</span>
-                    ${missingText ? `<span class="local-music-missing">${escapeHTML(missingText)}</span>` : ""}
-                </div>
-                <div class="local-music-file" title="${escapeHTML(track.rel_path || track.filename || "")}">
-                    ${escapeHTML(track.rel_path || track.filename || "")}
-                </div>
-            </div>
-            ${canAddToCollection ? `<button type="button" class="btn-pill btn-pill-primary local-music-add ${track.already_added ? "is-added" : ""}" ${track.already_added ? "disabled" : ""}>
-                <i class="fa-solid ${track.already_added ? "fa-check" : "fa-plus"}"></i> ${track.already_added ? "已添加" : "添加"}
-            </button>` : ""}
-        `;
+function collectionMigrationCandidateLabel(candidate) {
+  const parts = [candidate.name, candidate.artist, candidate.album].filter(
+    Boolean,
+  );
+  return `${parts.join(" · ")} [${candidate.source}] ${Math.round(candidate.score * 100)}%`;
+}
+
+function renderCollectionMigrationTrack(track, editable) {
+  const label = escapeHTML(
+    [track.name, track.artist].filter(Boolean).join(" · ") ||
+      `#${track.index + 1}`,
+  );
+  const status = escapeHTML(
+    COLLECTION_MIGRATION_STATUS_LABELS[track.status] || track.status,
+  );
+  const candidates = track.candidates || [];
+  const search = editable
+    ? `<button type="button" class="btn-pill" onclick="researchCollectionMigrationTrack(${track.index})"><i class="fa-solid fa-magnifying-glass"></i></button>`
+    : "";
+  if (candidates.length === 0) {
+    return `<div class="identify-result is-empty" data-index="${track.index}"><strong>${label}</strong><span>${status}</span>${search}</div>`;
+  }
+  const options = [
+    `<option value="-1" ${track.selected < 0 ? "selected" : ""}>不加入歌单</option>`,
+    ...candidates.map(
+      (candidate, i) =>
+        `<option value="${i}" ${i === track.selected ? "selected" : ""}>${escapeHTML(collectionMigrationCandidateLabel(candidate))}</option>`,
+    ),
+  ].join("");
+  const matched = track.status === "matched";
+  return `<div class="identify-result${matched ? "" : " is-review"}" data-index="${track.index}">
+      <span class="identify-result-main">
+        <strong>${label}</strong>
+        <select class="playlist-import-candidate" ${editable ? `onchange="selectCollectionMigrationCandidate(${track.index}, this.value)"` : "disabled"}>${options}</select>
+        <span class="identify-result-fields">${status}</span>
+      </span>
+      ${search}
+      <span class="identify-confidence${matched ? " is-high" : ""}">${Math.round((track.score || 0) * 100)}%</span>
+    </div>`;
+}
+
+function renderCollectionMigrationJob(job) {
+  if (!job) return;
+  const running = job.status === "running";
+  const statusText = running
+    ? "迁移中"
+    : job.status === "cancelled"
+      ? "已取消"
+      : job.status === "failed"
+        ? `保存失败：${job.last_error || ""}`
+        : "迁移完成";
+  setCollectionMigrationProgress(
+    `${statusText}：${job.processed}/${job.total}，已匹配 ${job.matched} 首，低置信度 ${job.low_confidence} 首，未找到 ${job.not_found} 首`,
+    job.status === "failed" ? "error" : "",
+  );
+  const startBtn = document.getElementById("collectionMigrationStartBtn");
+  if (startBtn) startBtn.disabled = running;
+  const cancelBtn = document.getElementById("collectionMigrationCancelBtn");
+  if (cancelBtn) cancelBtn.hidden = !running;
+
+  collectionMigrationState.migrationId =
+    job.status === "done" ? job.migration_id : 0;
+  const editable = !!collectionMigrationState.migrationId;
+  const results = document.getElementById("collectionMigrationResults");
+  if (results) {
+    results.innerHTML = (job.tracks || [])
+      .map((track) => renderCollectionMigrationTrack(track, editable))
+      .join("");
+  }
+  const actions = document.getElementById("collectionMigrationActions");
+  if (
+    actions &&
+    editable &&
+    !actions.querySelector(".collection-migration-link")
+  ) {
+    const report = `${API_ROOT}/collections/migrations/${job.migration_id}/report`;
+    actions.insertAdjacentHTML(
+      "beforeend",
+      `<a class="btn-pill collection-migration-link" href="${report}?format=csv" download><i class="fa-solid fa-file-csv"></i> 导出报告 CSV</a>
+       <a class="btn-pill" href="${report}?format=json" download><i class="fa-solid fa-file-code"></i> 导出报告 JSON</a>
+       <button type="button" class="btn-pill btn-pill-dl" onclick="closeCollectionMigrationModal(); navigateTo('${API_ROOT}/collection?id=${job.collection_id}')"><i class="fa-solid fa-list"></i> 打开新歌单</button>`,
+    );
+  }
+}
 
-    const btn = item.querySelector(".local-music-add");
-    if (canAddToCollection && btn && !track.already_added) {
-      btn.onclick = () => addLocalMusicToCollection(track.id, btn);
-    }
-    list.appendChild(item);
-  });
+async function cancelCollectionMigration() {
+  try {
+    await postLocalMusicTagRequest("/collections/migrate/cancel", {});
+  } catch (_) {}
+  await pollCollectionMigration();
+}
 
-  if (localMusicModalState.hasMore) {
-    const more = document.createElement("button");
-    more.type = "button";
-    more.className = "btn-pill btn-pill-dl local-music-load-more";
-    more.innerHTML = '<i class="fa-solid fa-chevron-down"></i> 加载更多';
-    more.onclick = () => refreshLocalMusicList({ append: true });
-    list.appendChild(more);
-    setLocalMusicHint(
-      `已显示 ${localMusicModalState.offset} / ${localMusicModalState.total} 首`,
+function replaceCollectionMigrationTrack(track) {
+  const row = document.querySelector(
+    `#collectionMigrationResults [data-index="${track.index}"]`,
+  );
+  if (row) row.outerHTML = renderCollectionMigrationTrack(track, true);
+}
+
+async function selectCollectionMigrationCandidate(index, candidate) {
+  const id = collectionMigrationState.migrationId;
+  if (!id) return;
+  try {
+    const response = await fetch(
+      `${API_ROOT}/collections/migrations/${id}/tracks/${index}`,
+      {
+        method: "PUT",
+        headers: {
+          "Content-Type": "application/json",
+          "X-Requested-With": "XMLHttpRequest",
+        },
+        body: JSON.stringify({ candidate: Number(candidate) }),
+      },
     );
-  } else {
-    setLocalMusicHint("");
+    const payload = await response.json().catch(() => null);
+    if (!response.ok || !payload || payload.error) {
+      throw new Error((payload && payload.error) || "修改失败");
+    }
+    replaceCollectionMigrationTrack(payload.track);
+  } catch (error) {
+    showToast("修改失败", error.message || "请稍后重试", "error", 3000);
   }
 }
 
-function setLocalMusicUploadHint(message, isError = false) {
-  if (isLocalMusicPageActive()) {
-    setLocalMusicPageHint(message, isError);
-    return;
+async function researchCollectionMigrationTrack(index) {
+  const id = collectionMigrationState.migrationId;
+  const keyword = (prompt("用这个关键词重新搜索") || "").trim();
+  if (!id || !keyword) return;
+  try {
+    const payload = await postLocalMusicTagRequest(
+      `/collections/migrations/${id}/tracks/${index}/search`,
+      { keyword },
+    );
+    replaceCollectionMigrationTrack(payload.track);
+  } catch (error) {
+    showToast("搜索失败", error.message || "请稍后重试", "error", 3000);
   }
-  setLocalMusicHint(message, isError);
 }
 
-async function uploadLocalMusicFile(input) {
-  if (!input || !input.files || input.files.length === 0) return;
+// ==========================================
+// 导入歌单同步与快照
+// ==========================================
 
-  const acceptedExtensions = new Set(["mp3", "flac", "m4a", "ogg", "wav", "wma", "aac"]);
-  const files = Array.from(input.files).filter((file) => {
-    const extension = file.name.split(".").pop().toLowerCase();
-    return acceptedExtensions.has(extension);
-  });
-  const ignoredCount = input.files.length - files.length;
-  if (files.length === 0) {
-    input.value = "";
-    setLocalMusicUploadHint("所选内容中没有可导入的音频文件。", true);
-    return;
-  }
+const COLLECTION_SYNC_INTERVALS = [
+  [0, "只手动同步"],
+  [6, "每 6 小时"],
+  [24, "每天"],
+  [168, "每周"],
+];
+let collectionSyncId = 0;
 
-  const failures = [];
-  let imported = 0;
-  try {
-    for (const [index, file] of files.entries()) {
-      setLocalMusicUploadHint(`正在导入 ${index + 1} / ${files.length}：${file.webkitRelativePath || file.name}`);
-      const formData = new FormData();
-      formData.append("file", file);
+function closeCollectionSyncModal() {
+  document.getElementById("collection-sync-modal-overlay")?.remove();
+}
 
-      try {
-        const response = await fetch(`${API_ROOT}/local_music/upload`, {
-          method: "POST",
-          body: formData,
-        });
-        const payload = await response.json().catch(() => null);
-        if (!response.ok || !payload || payload.error) {
-          throw new Error((payload && payload.error) || "导入失败");
-        }
-        imported++;
-      } catch (error) {
-        failures.push(`${file.name}：${error.message || "导入失败"}`);
-      }
-    }
+async function openCollectionSyncModal(collectionId) {
+  closeCollectionSyncModal();
+  collectionSyncId = Number(collectionId) || 0;
 
-    if (isLocalMusicPageActive()) {
-      await refreshLocalMusicPageAfterMutation();
+  const overlay = document.createElement("div");
+  overlay.id = "collection-sync-modal-overlay";
+  overlay.className = "modal-overlay utility-modal-overlay is-open";
+  overlay.onclick = (e) => {
+    if (e.target === overlay) closeCollectionSyncModal();
+  };
+  const intervals = COLLECTION_SYNC_INTERVALS.map(
+    ([hours, label]) => `<option value="${hours}">${label}</option>`,
+  ).join("");
+  overlay.innerHTML = `
+    <div class="modal utility-modal playlist-import-modal">
+      <div class="modal-header">
+        <div><h3><i class="fa-solid fa-rotate"></i> 同步导入歌单</h3><p class="utility-modal-subtitle">把上游曲目列表存成快照，比较新增和移除的歌曲；上游拉取失败时继续使用最后一次快照</p></div>
+        <button type="button" class="modal-close" aria-label="关闭歌单同步" onclick="closeCollectionSyncModal()"><i class="fa-solid fa-xmark"></i></button>
+      </div>
+      <div class="modal-body">
+        <div class="playlist-import-options">
+          <label>定期同步
+            <select id="collectionSyncInterval" onchange="saveCollectionSyncSettings()">${intervals}</select>
+          </label>
+          <label><input type="checkbox" id="collectionSyncAutoDownload" onchange="saveCollectionSyncSettings()"> 新增歌曲自动下载到本地曲库</label>
+          <button type="button" class="btn-pill btn-pill-primary" id="collectionSyncNowBtn" onclick="runCollectionSync()"><i class="fa-solid fa-rotate"></i> 立即同步</button>
+        </div>
+        <div id="collectionSyncStatus" class="setting-inline-status"></div>
+        <div id="collectionSyncSnapshots" class="identify-results"></div>
+      </div>
+    </div>`;
+  document.body.appendChild(overlay);
+  await loadCollectionSyncSnapshots();
+}
+
+function renderCollectionSyncSongs(title, songs) {
+  if (!songs || songs.length === 0) return "";
+  const items = songs
+    .map(
+      (song) =>
+        `<li>${escapeHTML([song.name, song.artist].filter(Boolean).join(" - "))}</li>`,
+    )
+    .join("");
+  return `<div class="identify-result-fields">${title}</div><ul class="collection-sync-songs">${items}</ul>`;
+}
+
+function renderCollectionSyncSnapshot(snapshot) {
+  const when = new Date(snapshot.created_at).toLocaleString();
+  const summary = snapshot.baseline
+    ? `首次快照，${snapshot.track_count} 首`
+    : `新增 ${snapshot.added_count} 首，移除 ${snapshot.removed_count} 首，共 ${snapshot.track_count} 首`;
+  const downloads =
+    snapshot.downloaded || snapshot.download_failed
+      ? `，自动下载 ${snapshot.downloaded} 首${snapshot.download_failed ? `（失败 ${snapshot.download_failed}）` : ""}`
+      : "";
+  const expand = snapshot.baseline
+    ? ""
+    : `<button type="button" class="btn-pill" onclick="toggleCollectionSyncSnapshot(this, ${snapshot.id})"><i class="fa-solid fa-list"></i> 变化</button>`;
+  return `<div class="identify-result is-empty" data-snapshot="${snapshot.id}">
+      <strong>${escapeHTML(when)}</strong>
+      <span>${summary}${downloads}</span>
+      ${expand}
+      <div class="collection-sync-diff" hidden></div>
+    </div>`;
+}
+
+async function loadCollectionSyncSnapshots() {
+  const list = document.getElementById("collectionSyncSnapshots");
+  if (!list || !collectionSyncId) return;
+  try {
+    const response = await fetch(
+      `${API_ROOT}/collections/${collectionSyncId}/snapshots`,
+    );
+    const payload = await response.json().catch(() => null);
+    if (!response.ok || !payload || payload.error) {
+      throw new Error((payload && payload.error) || "加载失败");
     }
-    const ignoredText = ignoredCount > 0 ? `，忽略 ${ignoredCount} 个非音频文件` : "";
-    if (failures.length > 0) {
-      setLocalMusicUploadHint(`已导入 ${imported} 首${ignoredText}，${failures.length} 首失败：${failures[0]}`, true);
+    const collection = payload.collection || {};
+    const interval = document.getElementById("collectionSyncInterval");
+    if (interval) interval.value = String(collection.sync_interval_hours || 0);
+    const autoDownload = document.getElementById("collectionSyncAutoDownload");
+    if (autoDownload) autoDownload.checked = !!collection.sync_auto_download;
+    if (collection.last_sync_error) {
+      setCollectionSyncStatus(
+        `上次同步失败：${collection.last_sync_error}`,
+        "error",
+      );
+    } else if (collection.last_synced_at) {
+      setCollectionSyncStatus(
+        `上次同步：${new Date(collection.last_synced_at).toLocaleString()}`,
+      );
     } else {
-      setLocalMusicUploadHint(`已导入 ${imported} 首${ignoredText}。`);
+      setCollectionSyncStatus("还没有同步过");
     }
-  } finally {
-    input.value = "";
+    const snapshots = payload.snapshots || [];
+    list.innerHTML = snapshots.length
+      ? snapshots.map(renderCollectionSyncSnapshot).join("")
+      : '<div class="identify-result is-empty"><span>暂无快照</span></div>';
+  } catch (error) {
+    setCollectionSyncStatus(error.message || "加载失败", "error");
   }
 }
 
-async function addLocalMusicToCollection(trackId, btn) {
-  if (!activeLocalMusicCollectionId || !trackId) return;
-
-  if (btn) {
-    btn.disabled = true;
-    btn.innerHTML = '<i class="fa-solid fa-spinner fa-spin"></i> 添加中';
-  }
+function setCollectionSyncStatus(text, className = "") {
+  const status = document.getElementById("collectionSyncStatus");
+  if (!status) return;
+  status.textContent = text;
+  status.className = `setting-inline-status${className ? ` ${className}` : ""}`;
+}
 
+async function saveCollectionSyncSettings() {
   try {
     const response = await fetch(
-      `${API_ROOT}/collections/${activeLocalMusicCollectionId}/local_music`,
+      `${API_ROOT}/collections/${collectionSyncId}/sync`,
       {
-        method: "POST",
-        headers: { "Content-Type": "application/json" },
-        body: JSON.stringify({ id: trackId }),
+        method: "PUT",
+        headers: {
+          "Content-Type": "application/json",
+          "X-Requested-With": "XMLHttpRequest",
+        },
+        body: JSON.stringify({
+          interval_hours: Number(
+            document.getElementById("collectionSyncInterval")?.value || 0,
+          ),
+          auto_download: !!document.getElementById("collectionSyncAutoDownload")
+            ?.checked,
+        }),
       },
     );
     const payload = await response.json().catch(() => null);
     if (!response.ok || !payload || payload.error) {
-      throw new Error((payload && payload.error) || "添加失败");
+      throw new Error((payload && payload.error) || "保存失败");
     }
+    showToast("已保存", "同步设置已更新", "success", 2000);
+  } catch (error) {
+    setCollectionSyncStatus(error.message || "保存失败", "error");
+  }
+}
 
-    if (btn) {
-      btn.classList.add("is-added");
-      btn.innerHTML = '<i class="fa-solid fa-check"></i> 已添加';
+async function runCollectionSync() {
+  const btn = document.getElementById("collectionSyncNowBtn");
+  if (btn) btn.disabled = true;
+  setCollectionSyncStatus("正在拉取上游歌单...");
+  try {
+    const payload = await postLocalMusicTagRequest(
+      `/collections/${collectionSyncId}/sync`,
+      {},
+    );
+    await loadCollectionSyncSnapshots();
+    if (payload.changed && !payload.snapshot?.baseline) {
+      showToast(
+        "歌单有更新",
+        `新增 ${payload.added.length} 首，移除 ${payload.removed.length} 首`,
+        "success",
+        4000,
+      );
+      refreshCurrentPageContent();
+    } else {
+      showToast("同步完成", "上游歌单没有变化", "success", 2000);
     }
-    await refreshCurrentPageContent({ scroll: false });
-    await refreshLocalMusicList();
   } catch (error) {
-    if (btn) {
-      btn.disabled = false;
-      btn.innerHTML = '<i class="fa-solid fa-plus"></i> 添加';
+    setCollectionSyncStatus(error.message || "同步失败", "error");
+  } finally {
+    if (btn) btn.disabled = false;
+  }
+}
+
+async function toggleCollectionSyncSnapshot(btn, snapshotId) {
+  const diff = btn.parentElement?.querySelector(".collection-sync-diff");
+  if (!diff) return;
+  if (!diff.hidden) {
+    diff.hidden = true;
+    return;
+  }
+  if (!diff.innerHTML) {
+    try {
+      const response = await fetch(
+        `${API_ROOT}/collections/${collectionSyncId}/snapshots/${snapshotId}`,
+      );
+      const payload = await response.json().catch(() => null);
+      if (!response.ok || !payload || payload.error) {
+        throw new Error((payload && payload.error) || "加载失败");
+      }
+      diff.innerHTML =
+        renderCollectionSyncSongs("新增", payload.added) +
+        renderCollectionSyncSongs("移除", payload.removed);
+    } catch (error) {
+      diff.textContent = error.message || "加载失败";
     }
-    alert(error.message || "添加失败");
   }
+  diff.hidden = false;
 }
 
-function playAllSongs() {
-  const firstPlayBtn = document.querySelector(".song-card .btn-play");
-  if (firstPlayBtn) {
-    playAllAndJumpTo(firstPlayBtn);
+// ==========================================
+// 智能歌单
+// ==========================================
+
+const SMART_COLLECTION_FIELDS = [
+  ["artist", "歌手", "text"],
+  ["album", "专辑", "text"],
+  ["album_artist", "专辑艺人", "text"],
+  ["name", "歌名", "text"],
+  ["format", "格式", "text"],
+  ["bitrate", "码率 (kbps)", "number"],
+  ["duration", "时长 (秒)", "number"],
+  ["added", "入库时间", "date"],
+  ["modified", "修改时间", "date"],
+  ["downloaded", "下载时间", "date"],
+  ["has_lyric", "有歌词", "bool"],
+  ["has_cover", "有封面", "bool"],
+];
+const SMART_COLLECTION_OPS = {
+  text: [
+    ["in", "是其中之一"],
+    ["not_in", "不是"],
+    ["contains", "包含"],
+  ],
+  number: [
+    ["lt", "小于"],
+    ["gt", "大于"],
+    ["between", "介于"],
+  ],
+  date: [
+    ["within_days", "最近几天内"],
+    ["older_than_days", "超过几天未发生"],
+  ],
+  bool: [
+    ["yes", "是"],
+    ["no", "否"],
+  ],
+};
+const SMART_COLLECTION_SORTS = [
+  ["added_desc", "最近入库"],
+  ["added_asc", "最早入库"],
+  ["modified_desc", "最近修改"],
+  ["name", "歌名"],
+  ["artist", "歌手"],
+  ["album", "专辑"],
+  ["duration_desc", "时长从长到短"],
+  ["duration_asc", "时长从短到长"],
+  ["bitrate_desc", "码率从高到低"],
+  ["bitrate_asc", "码率从低到高"],
+  ["random", "随机"],
+];
+let smartCollectionEditingId = 0;
+
+function closeSmartCollectionModal() {
+  document.getElementById("smart-collection-modal-overlay")?.remove();
+}
+
+function smartCollectionFieldKind(field) {
+  const found = SMART_COLLECTION_FIELDS.find(([key]) => key === field);
+  return found ? found[2] : "text";
+}
+
+async function openSmartCollectionModal(collectionId) {
+  closeSmartCollectionModal();
+  smartCollectionEditingId = Number(collectionId) || 0;
+
+  const overlay = document.createElement("div");
+  overlay.id = "smart-collection-modal-overlay";
+  overlay.className = "modal-overlay utility-modal-overlay is-open";
+  overlay.onclick = (e) => {
+    if (e.target === overlay) closeSmartCollectionModal();
+  };
+  const sorts = SMART_COLLECTION_SORTS.map(
+    ([value, label]) => `<option value="${value}">${label}</option>`,
+  ).join("");
+  overlay.innerHTML = `
+    <div class="modal utility-modal playlist-import-modal">
+      <div class="modal-header">
+        <div><h3><i class="fa-solid fa-wand-magic-sparkles"></i> ${smartCollectionEditingId ? "编辑智能歌单" : "新建智能歌单"}</h3><p class="utility-modal-subtitle">按规则从本地曲库和下载记录中自动挑选歌曲，每次打开时重新计算</p></div>
+        <button type="button" class="modal-close" aria-label="关闭智能歌单" onclick="closeSmartCollectionModal()"><i class="fa-solid fa-xmark"></i></button>
+      </div>
+      <div class="modal-body">
+        <div class="playlist-import-options">
+          <label>名称 <input type="text" id="smartCollectionName" placeholder="例如：缺歌词的无损"></label>
+          <label>匹配
+            <select id="smartCollectionMatch">
+              <option value="all">全部规则</option>
+              <option value="any">任一规则</option>
+            </select>
+          </label>
+          <label>排序 <select id="smartCollectionSort">${sorts}</select></label>
+          <label>最多 <input type="number" id="smartCollectionLimit" min="1" max="5000" value="500"> 首</label>
+        </div>
+        <div id="smartCollectionRules" class="smart-collection-rules"></div>
+        <div class="playlist-import-options">
+          <button type="button" class="btn-pill" onclick="addSmartCollectionRule()"><i class="fa-solid fa-plus"></i> 添加规则</button>
+          <button type="button" class="btn-pill" onclick="previewSmartCollection()"><i class="fa-solid fa-eye"></i> 预览</button>
+          <button type="button" class="btn-pill btn-pill-primary" onclick="saveSmartCollection()"><i class="fa-solid fa-floppy-disk"></i> 保存</button>
+        </div>
+        <div id="smartCollectionStatus" class="setting-inline-status"></div>
+        <div id="smartCollectionPreview" class="identify-results"></div>
+      </div>
+    </div>`;
+  document.body.appendChild(overlay);
+
+  if (!smartCollectionEditingId) {
+    addSmartCollectionRule({ field: "added", op: "within_days", value: 30 });
+    return;
+  }
+  try {
+    const response = await fetch(
+      `${API_ROOT}/collections/${smartCollectionEditingId}/rules`,
+    );
+    const payload = await response.json().catch(() => null);
+    if (!response.ok || !payload || payload.error) {
+      throw new Error((payload && payload.error) || "加载失败");
+    }
+    const rules = payload.rules || {};
+    document.getElementById("smartCollectionName").value = payload.name || "";
+    document.getElementById("smartCollectionMatch").value =
+      rules.match || "all";
+    document.getElementById("smartCollectionSort").value =
+      rules.sort || "added_desc";
+    document.getElementById("smartCollectionLimit").value = rules.limit || 500;
+    (rules.rules || []).forEach((rule) => addSmartCollectionRule(rule));
+  } catch (error) {
+    setSmartCollectionStatus(error.message || "加载失败", "error");
+  }
+}
+
+function addSmartCollectionRule(rule = {}) {
+  const list = document.getElementById("smartCollectionRules");
+  if (!list) return;
+  const row = document.createElement("div");
+  row.className = "smart-collection-rule";
+  const fields = SMART_COLLECTION_FIELDS.map(
+    ([value, label]) => `<option value="${value}">${label}</option>`,
+  ).join("");
+  row.innerHTML = `
+    <select class="smart-rule-field" onchange="renderSmartCollectionRuleInputs(this.parentElement)">${fields}</select>
+    <select class="smart-rule-op" onchange="renderSmartCollectionRuleInputs(this.parentElement, true)"></select>
+    <span class="smart-rule-inputs"></span>
+    <button type="button" class="btn-circle" title="删除规则" onclick="this.parentElement.remove()"><i class="fa-solid fa-xmark"></i></button>`;
+  list.appendChild(row);
+  row.querySelector(".smart-rule-field").value = rule.field || "artist";
+  renderSmartCollectionRuleInputs(row, false, rule);
+}
+
+function renderSmartCollectionRuleInputs(row, keepOp = false, rule = {}) {
+  const kind = smartCollectionFieldKind(
+    row.querySelector(".smart-rule-field").value,
+  );
+  const opSelect = row.querySelector(".smart-rule-op");
+  const ops = SMART_COLLECTION_OPS[kind];
+  if (keepOp) {
+    // 只换条件时保留已填的数值。
+    rule = {
+      value: row.querySelector(".smart-rule-value")?.value,
+      max: row.querySelector(".smart-rule-max")?.value,
+      values: [row.querySelector(".smart-rule-value")?.value || ""],
+    };
+  }
+  const currentOp = keepOp ? opSelect.value : rule.op;
+  opSelect.innerHTML = ops
+    .map(([value, label]) => `<option value="${value}">${label}</option>`)
+    .join("");
+  opSelect.value = ops.some(([value]) => value === currentOp)
+    ? currentOp
+    : ops[0][0];
+
+  const inputs = row.querySelector(".smart-rule-inputs");
+  if (kind === "bool") {
+    inputs.innerHTML = "";
+  } else if (kind === "text") {
+    inputs.innerHTML = `<input type="text" class="smart-rule-value" placeholder="多个值用逗号分隔" value="${escapeHTML((rule.values || []).join(", "))}">`;
   } else {
-    alert("列表为空，无法播放");
+    const max =
+      opSelect.value === "between"
+        ? ` - <input type="number" class="smart-rule-max" min="0" value="${Number(rule.max) || 0}">`
+        : "";
+    const unit = kind === "date" ? " 天" : "";
+    inputs.innerHTML = `<input type="number" class="smart-rule-value" min="0" value="${Number(rule.value) || 0}">${max}${unit}`;
   }
 }
 
-let pendingBatchFavIds = [];
-let pendingBatchFavSongs = [];
+function collectSmartCollectionPayload() {
+  const rows = document.querySelectorAll(
+    "#smartCollectionRules .smart-collection-rule",
+  );
+  const rules = Array.from(rows).map((row) => {
+    const field = row.querySelector(".smart-rule-field").value;
+    const rule = { field, op: row.querySelector(".smart-rule-op").value };
+    const value = row.querySelector(".smart-rule-value")?.value || "";
+    const kind = smartCollectionFieldKind(field);
+    if (kind === "text") {
+      rule.values = value
+        .split(/[,，]/)
+        .map((item) => item.trim())
+        .filter(Boolean);
+    } else if (kind !== "bool") {
+      rule.value = Number(value) || 0;
+      rule.max = Number(row.querySelector(".smart-rule-max")?.value) || 0;
+    }
+    return rule;
+  });
+  return {
+    name: document.getElementById("smartCollectionName")?.value.trim() || "",
+    rules: {
+      match: document.getElementById("smartCollectionMatch")?.value || "all",
+      sort: document.getElementById("smartCollectionSort")?.value || "",
+      limit:
+        Number(document.getElementById("smartCollectionLimit")?.value) || 0,
+      rules,
+    },
+  };
+}
 
-function batchAddToCollection() {
-  const songs = getSelectedSongs();
-  if (songs.length === 0) {
-    alert("请先选择歌曲");
+function setSmartCollectionStatus(text, className = "") {
+  const status = document.getElementById("smartCollectionStatus");
+  if (!status) return;
+  status.textContent = text;
+  status.className = `setting-inline-status${className ? ` ${className}` : ""}`;
+}
+
+async function previewSmartCollection() {
+  const list = document.getElementById("smartCollectionPreview");
+  setSmartCollectionStatus("正在计算...");
+  try {
+    const payload = await postLocalMusicTagRequest(
+      "/collections/smart/preview",
+      collectSmartCollectionPayload(),
+    );
+    const songs = payload.songs || [];
+    setSmartCollectionStatus(
+      `符合规则 ${payload.total} 首${payload.total > songs.length ? `，下面列出前 ${songs.length} 首` : ""}`,
+    );
+    if (list) {
+      list.innerHTML = songs.length
+        ? `<ul class="collection-sync-songs">${songs
+            .map(
+              (song) =>
+                `<li>${escapeHTML([song.name, song.artist].filter(Boolean).join(" - "))}</li>`,
+            )
+            .join("")}</ul>`
+        : '<div class="identify-result is-empty"><span>没有符合规则的歌曲</span></div>';
+    }
+  } catch (error) {
+    setSmartCollectionStatus(error.message || "预览失败", "error");
+  }
+}
+
+async function saveSmartCollection() {
+  const payload = collectSmartCollectionPayload();
+  if (!payload.name) {
+    setSmartCollectionStatus("名称不能为空", "error");
     return;
   }
-  pendingBatchFavSongs = songs
-    .map((song) => ({
-      id: song.id,
-      source: song.source,
-      name: song.name,
-      artist: song.artist,
-      cover: song.cover,
-      duration: song.duration,
-      extra: song.extra,
-    }))
-    .filter((song) => song.id && song.source);
-  pendingBatchFavIds = [];
-  pendingFavSong = null;
-  document.getElementById("addToCollectionModal").style.display = "flex";
-  refreshAddToCollectionList();
+  try {
+    let id = smartCollectionEditingId;
+    if (id) {
+      const response = await fetch(`${API_ROOT}/collections/${id}/rules`, {
+        method: "PUT",
+        headers: {
+          "Content-Type": "application/json",
+          "X-Requested-With": "XMLHttpRequest",
+        },
+        body: JSON.stringify(payload),
+      });
+      const result = await response.json().catch(() => null);
+      if (!response.ok || !result || result.error) {
+        throw new Error((result && result.error) || "保存失败");
+      }
+    } else {
+      const result = await postLocalMusicTagRequest(
+        "/collections/smart",
+        payload,
+      );
+      id = result.id;
+    }
+    closeSmartCollectionModal();
+    showToast("已保存", `智能歌单「${payload.name}」已更新`, "success", 2000);
+    navigateTo(`${API_ROOT}/collection?id=${id}`);
+  } catch (error) {
+    setSmartCollectionStatus(error.message || "保存失败", "error");
+  }
+}
+
+// ==========================================
+// 歌单整理：排序、移动/复制、备注评分、分组
+// ==========================================
+
+let collectionDragCard = null;
+let collectionDragOrder = "";
+
+function collectionSongCardKey(card) {
+  return `${card.dataset.id}|${card.dataset.source}`;
 }
 
-async function submitBatchAddToCollection(colId) {
-  const songs = pendingBatchFavSongs.slice();
-  pendingBatchFavSongs = [];
-  if (!colId || songs.length === 0) return;
+async function initializeCollectionSongList(root = document) {
+  const list = root.querySelector(".result-list[data-collection-id]");
+  if (!list) return;
+  const collectionId = list.dataset.collectionId;
+  const cards = Array.from(list.querySelectorAll(".song-card"));
+  cards.forEach((card) => {
+    if (card.dataset.reorderBound) return;
+    card.dataset.reorderBound = "true";
+    card.draggable = true;
+    card.addEventListener("dragstart", (e) => {
+      collectionDragCard = card;
+      collectionDragOrder = collectionSongListOrder(list);
+      card.classList.add("is-dragging");
+      e.dataTransfer.effectAllowed = "move";
+    });
+    card.addEventListener("dragover", (e) => {
+      if (!collectionDragCard || collectionDragCard === card) return;
+      e.preventDefault();
+      const rect = card.getBoundingClientRect();
+      const after = e.clientY > rect.top + rect.height / 2;
+      list.insertBefore(collectionDragCard, after ? card.nextSibling : card);
+    });
+    card.addEventListener("dragend", () => {
+      card.classList.remove("is-dragging");
+      if (!collectionDragCard) return;
+      collectionDragCard = null;
+      if (collectionSongListOrder(list) !== collectionDragOrder) {
+        saveCollectionSongOrder(collectionId, list);
+      }
+    });
+  });
 
   try {
     const response = await fetch(
-      `${API_ROOT}/collections/${colId}/songs/batch`,
-      {
-        method: "POST",
-        headers: { "Content-Type": "application/json" },
-        body: JSON.stringify({ songs }),
-      },
+      `${API_ROOT}/collections/${encodeURIComponent(collectionId)}/songs`,
     );
-    const payload = await response.json().catch(() => null);
-    if (!response.ok || !payload || payload.error) {
-      throw new Error((payload && payload.error) || "批量收藏失败");
-    }
-    document.getElementById("addToCollectionModal").style.display = "none";
-    let message = `批量收藏完成：新增 ${payload.added || 0}`;
-    if (payload.duplicate) message += `，已存在 ${payload.duplicate}`;
-    if (payload.failed) message += `，失败 ${payload.failed}`;
-    alert(message);
+    const songs = await response.json().catch(() => null);
+    if (!response.ok || !Array.isArray(songs)) return;
+    const byKey = new Map(
+      songs.map((song) => [`${song.id}|${song.source}`, song]),
+    );
+    cards.forEach((card) => {
+      const song = byKey.get(collectionSongCardKey(card));
+      if (song) renderCollectionSongNote(card, song.note, song.rating);
+    });
   } catch (error) {
-    alert(error.message || "批量收藏失败");
+    console.warn("load collection notes failed", error);
   }
 }
 
-function batchAddLocalMusicToCollection() {
-  const songs = getSelectedSongs().filter((song) =>
-    isLocalMusicSourceValue(song.source),
-  );
-  if (songs.length === 0) {
-    alert("请先选择本地音乐");
-    return;
-  }
-  pendingBatchFavIds = songs.map((song) => song.id).filter(Boolean);
-  pendingBatchFavSongs = [];
-  pendingFavSong = null;
-  document.getElementById("addToCollectionModal").style.display = "flex";
-  refreshAddToCollectionList();
+function collectionSongListOrder(list) {
+  return Array.from(list.querySelectorAll(".song-card"))
+    .map(collectionSongCardKey)
+    .join("\n");
 }
 
-async function submitBatchAddLocalMusicToCollection(colId) {
-  const ids = pendingBatchFavIds.slice();
-  pendingBatchFavIds = [];
-  if (!colId || ids.length === 0) return;
-
+async function saveCollectionSongOrder(collectionId, list) {
+  const songs = Array.from(list.querySelectorAll(".song-card")).map((card) => ({
+    id: card.dataset.id,
+    source: card.dataset.source,
+  }));
   try {
     const response = await fetch(
-      `${API_ROOT}/collections/${colId}/local_music/batch`,
+      `${API_ROOT}/collections/${encodeURIComponent(collectionId)}/songs/order`,
       {
-        method: "POST",
-        headers: { "Content-Type": "application/json" },
-        body: JSON.stringify({ ids }),
+        method: "PUT",
+        headers: {
+          "Content-Type": "application/json",
+          "X-Requested-With": "XMLHttpRequest",
+        },
+        body: JSON.stringify({ songs }),
       },
     );
     const payload = await response.json().catch(() => null);
     if (!response.ok || !payload || payload.error) {
-      throw new Error((payload && payload.error) || "批量收藏失败");
+      throw new Error((payload && payload.error) || "保存顺序失败");
     }
-    document.getElementById("addToCollectionModal").style.display = "none";
-    let message = `批量收藏完成：新增 ${payload.added || 0}`;
-    if (payload.duplicate) message += `，已存在 ${payload.duplicate}`;
-    if (payload.failed) message += `，失败 ${payload.failed}`;
-    alert(message);
-    await refreshCurrentPageContent({ scroll: false });
+    showToast("已保存", "歌单顺序已更新", "success", 1500);
   } catch (error) {
-    alert(error.message || "批量收藏失败");
+    showToast("保存顺序失败", error.message || "请稍后重试", "error", 3000);
+    await refreshCurrentPageContent({ scroll: false });
   }
 }
 
-function openCollectionManager() {
-  navigateTo(API_ROOT + "/my_collections");
-}
-
-function openLocalMusicPage() {
-  closeDownloadRecordsModal();
-  navigateTo(API_ROOT + "/local_music_page");
+function renderCollectionSongNote(card, note, rating) {
+  const box = card.querySelector(".collection-song-note");
+  if (!box) return;
+  card.dataset.note = note || "";
+  card.dataset.rating = String(rating || 0);
+  const stars =
+    rating > 0
+      ? `<span class="collection-song-rating">${"★".repeat(rating)}</span>`
+      : "";
+  box.innerHTML = `${stars}${note ? `<span>${escapeHTML(note)}</span>` : ""}`;
+  box.hidden = !stars && !note;
 }
 
-function showEditCollectionModal(id = "", name = "", desc = "", cover = "") {
-  document.getElementById("editColTitle").textContent = id
-    ? "编辑歌单"
-    : "新建歌单";
-  document.getElementById("editColId").value = id;
-  document.getElementById("editColName").value = name;
-  document.getElementById("editColDesc").value = desc;
-
-  if (cover && cover.includes("picsum.photos")) {
-    document.getElementById("editColCover").value = "";
-  } else {
-    document.getElementById("editColCover").value = cover;
-  }
-
-  document.getElementById("editCollectionModal").style.display = "flex";
+function closeCollectionOrganizeModal() {
+  document.getElementById("collection-organize-modal-overlay")?.remove();
 }
 
-function showEditCollectionModalFromButton(btn) {
-  if (!btn) return;
-  showEditCollectionModal(
-    btn.dataset.id || "",
-    btn.dataset.name || "",
-    btn.dataset.description || "",
-    btn.dataset.cover || "",
-  );
+function openCollectionOrganizeModal(title, subtitle, body) {
+  closeCollectionOrganizeModal();
+  const overlay = document.createElement("div");
+  overlay.id = "collection-organize-modal-overlay";
+  overlay.className = "modal-overlay utility-modal-overlay is-open";
+  overlay.onclick = (e) => {
+    if (e.target === overlay) closeCollectionOrganizeModal();
+  };
+  overlay.innerHTML = `
+    <div class="modal utility-modal playlist-import-modal">
+      <div class="modal-header">
+        <div><h3>${title}</h3><p class="utility-modal-subtitle">${subtitle}</p></div>
+        <button type="button" class="modal-close" aria-label="关闭" onclick="closeCollectionOrganizeModal()"><i class="fa-solid fa-xmark"></i></button>
+      </div>
+      <div class="modal-body">
+        ${body}
+        <div id="collectionOrganizeStatus" class="setting-inline-status"></div>
+      </div>
+    </div>`;
+  document.body.appendChild(overlay);
+  return overlay;
 }
 
-function saveCollection() {
-  const id = document.getElementById("editColId").value;
-  const name = document.getElementById("editColName").value.trim();
-  const desc = document.getElementById("editColDesc").value.trim();
-  const cover = document.getElementById("editColCover").value.trim();
-
-  if (!name) return alert("名称不能为空");
-
-  const payload = { name, description: desc, cover };
-  const isAddingSongModalOpen =
-    document.getElementById("addToCollectionModal").style.display === "flex";
-
-  const url = id ? `${API_ROOT}/collections/${id}` : `${API_ROOT}/collections`;
-  const method = id ? "PUT" : "POST";
-
-  fetch(url, {
-    method: method,
-    headers: { "Content-Type": "application/json" },
-    body: JSON.stringify(payload),
-  })
-    .then((r) => r.json())
-    .then((res) => {
-      if (res.error) return alert(res.error);
-
-      document.getElementById("editCollectionModal").style.display = "none";
-
-      if (isAddingSongModalOpen) {
-        refreshAddToCollectionList();
-      } else {
-        refreshCurrentPageContent();
-      }
-    });
+function setCollectionOrganizeStatus(text, className = "") {
+  const status = document.getElementById("collectionOrganizeStatus");
+  if (!status) return;
+  status.textContent = text;
+  status.className = `setting-inline-status${className ? ` ${className}` : ""}`;
 }
 
-function setImportCollectionButtonState(btn, imported) {
-  if (!btn) return;
-
-  btn.disabled = !!imported;
-  if (imported) {
-    btn.innerHTML = '<i class="fa-solid fa-check"></i> 已导入';
-    btn.style.opacity = "0.85";
-  } else {
-    btn.innerHTML = '<i class="fa-solid fa-download"></i> 导入本地';
-    btn.style.opacity = "";
+async function putCollectionOrganizeRequest(path, body) {
+  const response = await fetch(`${API_ROOT}${path}`, {
+    method: "PUT",
+    headers: {
+      "Content-Type": "application/json",
+      "X-Requested-With": "XMLHttpRequest",
+    },
+    body: JSON.stringify(body),
+  });
+  const payload = await response.json().catch(() => null);
+  if (!response.ok || !payload || payload.error) {
+    throw new Error((payload && payload.error) || "保存失败");
   }
+  return payload;
 }
 
-function importCollectionFromButton(btn) {
-  if (!btn) return;
-
-  const payload = {
-    name: btn.dataset.name || "",
-    description: btn.dataset.description || "",
-    cover: btn.dataset.cover || "",
-    creator: btn.dataset.creator || "",
-    track_count: parsePositiveInt(btn.dataset.trackCount, 0),
-    source: btn.dataset.source || "",
-    external_id: btn.dataset.externalId || "",
-    link: btn.dataset.link || "",
-    content_type: btn.dataset.contentType || "playlist",
+function openCollectionSongNoteModal(btn, collectionId) {
+  const card = btn.closest(".song-card");
+  if (!card) return;
+  const rating = Number(card.dataset.rating) || 0;
+  const options = [0, 1, 2, 3, 4, 5]
+    .map(
+      (value) =>
+        `<option value="${value}" ${value === rating ? "selected" : ""}>${value ? "★".repeat(value) : "不评分"}</option>`,
+    )
+    .join("");
+  const overlay = openCollectionOrganizeModal(
+    '<i class="fa-regular fa-note-sticky"></i> 备注与评分',
+    escapeHTML(
+      [card.dataset.name, card.dataset.artist].filter(Boolean).join(" - "),
+    ),
+    `<div class="playlist-import-options">
+      <label>评分 <select id="collectionSongRating">${options}</select></label>
+    </div>
+    <textarea id="collectionSongNote" class="collection-song-note-input" rows="4" maxlength="500" placeholder="写点什么，例如在哪听到的、适合什么场景"></textarea>
+    <div class="playlist-import-options">
+      <button type="button" class="btn-pill btn-pill-primary" id="collectionSongNoteSave"><i class="fa-solid fa-floppy-disk"></i> 保存</button>
+    </div>`,
+  );
+  overlay.querySelector("#collectionSongNote").value = card.dataset.note || "";
+  overlay.querySelector("#collectionSongNoteSave").onclick = async () => {
+    const note = overlay.querySelector("#collectionSongNote").value.trim();
+    const value = Number(overlay.querySelector("#collectionSongRating").value);
+    try {
+      await putCollectionOrganizeRequest(
+        `/collections/${encodeURIComponent(collectionId)}/songs/note`,
+        {
+          id: card.dataset.id,
+          source: card.dataset.source,
+          note,
+          rating: value,
+        },
+      );
+      renderCollectionSongNote(card, note, value);
+      closeCollectionOrganizeModal();
+    } catch (error) {
+      setCollectionOrganizeStatus(error.message || "保存失败", "error");
+    }
   };
+}
 
-  if (!payload.source || !payload.external_id) {
-    alert("缺少导入参数");
+async function openCollectionTransferModal(collectionId) {
+  const songs = getSelectedSongs();
+  if (songs.length === 0) return;
+  let collections = [];
+  try {
+    const response = await fetch(`${API_ROOT}/collections`);
+    collections = await response.json();
+  } catch (error) {
+    showToast("加载歌单失败", error.message || "请稍后重试", "error", 3000);
     return;
   }
-
-  btn.disabled = true;
-  btn.style.opacity = "0.6";
-
-  fetch(`${API_ROOT}/collections/import`, {
-    method: "POST",
-    headers: { "Content-Type": "application/json" },
-    body: JSON.stringify(payload),
-  })
-    .then((r) => r.json())
-    .then((res) => {
-      if (res.error) {
-        btn.disabled = false;
-        btn.style.opacity = "";
-        alert(res.error);
-        return;
-      }
-
-      setImportCollectionButtonState(btn, true);
-      alert(
-        res.duplicate
-          ? "该歌单/专辑已在本地列表中"
-          : "导入成功，已加入本地歌单列表",
+  const targets = (Array.isArray(collections) ? collections : []).filter(
+    (col) => String(col.id) !== String(collectionId),
+  );
+  if (targets.length === 0) {
+    showToast("没有其他自建歌单", "请先新建一个歌单", "error", 3000);
+    return;
+  }
+  const options = targets
+    .map(
+      (col) =>
+        `<option value="${col.id}">${escapeHTML(col.folder ? `${col.folder} / ${col.name}` : col.name)}</option>`,
+    )
+    .join("");
+  const overlay = openCollectionOrganizeModal(
+    '<i class="fa-solid fa-right-to-bracket"></i> 移动/复制到其他歌单',
+    `已选 ${songs.length} 首，备注和评分会一起带过去`,
+    `<div class="playlist-import-options">
+      <label>目标歌单 <select id="collectionTransferTarget">${options}</select></label>
+      <label><input type="radio" name="collectionTransferMode" value="copy" checked> 复制</label>
+      <label><input type="radio" name="collectionTransferMode" value="move"> 移动</label>
+      <button type="button" class="btn-pill btn-pill-primary" id="collectionTransferSave"><i class="fa-solid fa-check"></i> 确定</button>
+    </div>`,
+  );
+  overlay.querySelector("#collectionTransferSave").onclick = async () => {
+    const mode = overlay.querySelector(
+      'input[name="collectionTransferMode"]:checked',
+    ).value;
+    try {
+      const payload = await postLocalMusicTagRequest(
+        `/collections/${encodeURIComponent(collectionId)}/songs/transfer`,
+        {
+          target_id: Number(
+            overlay.querySelector("#collectionTransferTarget").value,
+          ),
+          mode,
+          songs: songs.map((song) => ({ id: song.id, source: song.source })),
+        },
       );
-    })
-    .catch(() => {
-      btn.disabled = false;
-      btn.style.opacity = "";
-      alert("导入失败，请稍后重试");
-    });
-}
-
-function deleteCollection(id) {
-  if (!confirm("确定删除此歌单吗？内部歌曲记录也将被清空。")) return;
-  fetch(`${API_ROOT}/collections/${id}`, { method: "DELETE" })
-    .then((r) => r.json())
-    .then((res) => {
-      if (res.error) return alert(res.error);
-      refreshCurrentPageContent();
-    });
+      closeCollectionOrganizeModal();
+      showToast(
+        mode === "move" ? "已移动" : "已复制",
+        `加入 ${payload.added} 首${payload.duplicate ? `，${payload.duplicate} 首目标歌单里已有` : ""}`,
+        "success",
+        3000,
+      );
+      if (mode === "move") await refreshCurrentPageContent({ scroll: false });
+    } catch (error) {
+      setCollectionOrganizeStatus(error.message || "操作失败", "error");
+    }
+  };
 }
 
-function deleteCollectionFromModal(id) {
-  if (!confirm("确定删除此歌单吗？内部歌曲记录也将被清空。")) return;
-  fetch(`${API_ROOT}/collections/${id}`, { method: "DELETE" })
-    .then((r) => r.json())
-    .then((res) => {
-      if (res.error) return alert(res.error);
-      refreshAddToCollectionList();
-    });
+function openCollectionGroupModal(btn) {
+  const id = btn.dataset.id;
+  const overlay = openCollectionOrganizeModal(
+    '<i class="fa-solid fa-folder"></i> 分组与标签',
+    "文件夹可以用 / 嵌套，例如“运动/跑步”；多个标签用逗号分隔",
+    `<div class="playlist-import-options">
+      <label>文件夹 <input type="text" id="collectionGroupFolder" placeholder="不分组留空"></label>
+    </div>
+    <div class="playlist-import-options">
+      <label>标签 <input type="text" id="collectionGroupTags" placeholder="例如：睡前, 工作"></label>
+      <button type="button" class="btn-pill btn-pill-primary" id="collectionGroupSave"><i class="fa-solid fa-floppy-disk"></i> 保存</button>
+    </div>`,
+  );
+  overlay.querySelector("#collectionGroupFolder").value =
+    btn.dataset.folder || "";
+  overlay.querySelector("#collectionGroupTags").value = (
+    btn.dataset.tags || ""
+  ).replaceAll(",", ", ");
+  overlay.querySelector("#collectionGroupSave").onclick = async () => {
+    try {
+      await putCollectionOrganizeRequest(
+        `/collections/${encodeURIComponent(id)}/group`,
+        {
+          folder: overlay.querySelector("#collectionGroupFolder").value,
+          tags: [overlay.querySelector("#collectionGroupTags").value],
+        },
+      );
+      closeCollectionOrganizeModal();
+      await refreshCurrentPageContent({ scroll: false });
+    } catch (error) {
+      setCollectionOrganizeStatus(error.message || "保存失败", "error");
+    }
+  };
 }
 
 function refreshAddToCollectionList() {
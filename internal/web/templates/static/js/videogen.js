@@ -1357,7 +1357,11 @@
       },
 
       initAudioContext: function() {
-          if (!this.audioCtx) this.audioCtx = new (window.AudioContext || window.webkitAudioContext)();
+          if (!this.audioCtx) {
+              // 和主播放器共用 AudioContext：ap.audio 只能接一次 MediaElementSource。
+              const graph = window.getPlayerAudioGraph && window.getPlayerAudioGraph();
+              this.audioCtx = graph ? graph.ctx : new (window.AudioContext || window.webkitAudioContext)();
+          }
           if (this.audioCtx.state === 'suspended') this.audioCtx.resume();
           if (!this.analyser) { 
               this.analyser = this.audioCtx.createAnalyser(); 
@@ -1376,11 +1380,15 @@
               } else {
                   if (!window.ap || !window.ap.audio) return;
                   if (!this.sourceNode) {
-                      this.sourceNode = this.audioCtx.createMediaElementSource(window.ap.audio);
+                      const graph = window.getPlayerAudioGraph && window.getPlayerAudioGraph();
+                      this.sourceNode = graph && graph.ctx === this.audioCtx ? graph.gain : this.audioCtx.createMediaElementSource(window.ap.audio);
                   }
                   if (this.sourceNode) this.sourceNode.connect(this.analyser);
               }
-              this.analyser.connect(this.audioCtx.destination);
+              // 主播放器的增益节点已经接到扬声器，频谱只旁路取数据，避免声音叠两遍。
+              const shared = !this.isLocalAudio && window.getPlayerAudioGraph && this.sourceNode === window.getPlayerAudioGraph().gain;
+              this.analyser.disconnect();
+              if (!shared) this.analyser.connect(this.audioCtx.destination);
           } catch(e) { console.warn("Audio Routing Warning:", e); }
       },
       startRealtimeVisualizer: function() {
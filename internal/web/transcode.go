@@ -0,0 +1,489 @@
+package web
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/guohuiyuan/go-music-dl/core"
+)
+
+// 转码任务：本地曲目的批量转码和下载后的自动转码共用一个队列，按提交顺序
+// 一次只跑一个 ffmpeg（单个转码已经能吃满 CPU）。队列只在内存里，保留最近
+// transcodeJobHistory 个任务供页面展示。转码后的文件写在原文件旁边，标签
+// 和封面由 ffmpeg 带过去，同名歌词 / 封面旁挂文件在改名时一并复制。
+
+const (
+	transcodeJobHistory = 20
+
+	transcodeJobKindLocal    = "local"
+	transcodeJobKindDownload = "download"
+)
+
+var (
+	transcodeAudioFile = core.TranscodeAudioFile
+	transcodeReady     = func() error {
+		if _, err := core.ResolveFFmpegPath(); err != nil {
+			return errors.New("转码需要 ffmpeg")
+		}
+		return nil
+	}
+
+	transcodeJobs = &transcodeJobQueue{}
+)
+
+type transcodeItem struct {
+	// ID 是本地曲目 ID；下载任务的条目在转码后才有 NewID。
+	ID     string `json:"id,omitempty"`
+	NewID  string `json:"new_id,omitempty"`
+	Source string `json:"source"`
+	Target string `json:"target,omitempty"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+
+	root   localLibraryRoot
+	srcAbs string
+}
+
+// transcodeJob 的 StartedAt 是入队时间，排队期间 Status 为 queued。
+type transcodeJob struct {
+	backgroundJob
+	Kind    string                `json:"kind"`
+	Profile core.TranscodeProfile `json:"profile"`
+	// Replace 为 true 时转码成功后删除原文件，曲目 ID 与收藏保持不变。
+	Replace   bool            `json:"replace"`
+	Converted int             `json:"converted"`
+	Skipped   int             `json:"skipped"`
+	Progress  float64         `json:"progress"`
+	Current   string          `json:"current,omitempty"`
+	Items     []transcodeItem `json:"items"`
+}
+
+type transcodeJobQueue struct {
+	mu      sync.Mutex
+	jobs    []*transcodeJob
+	pending []*transcodeJob
+	working bool
+}
+
+type transcodeJobRequest struct {
+	IDs     []string `json:"ids"`
+	Profile string   `json:"profile"`
+	// Custom 是一次性的档案，给了就忽略 Profile。
+	Custom  *core.TranscodeProfile `json:"custom"`
+	Replace bool                   `json:"replace"`
+}
+
+func registerTranscodeRoutes(api *gin.RouterGroup) {
+	api.GET("/transcode/profiles", func(c *gin.Context) {
+		settings := localMusicTranscodeSettingsProvider()
+		body := gin.H{
+			"profiles":         core.TranscodeProfiles(settings.TranscodeProfiles),
+			"formats":          core.TranscodeFormats,
+			"download_mode":    settings.DownloadTranscodeMode,
+			"download_profile": settings.DownloadTranscodeProfile,
+		}
+		if err := transcodeReady(); err != nil {
+			body["error"] = err.Error()
+		}
+		c.JSON(http.StatusOK, body)
+	})
+
+	api.GET("/transcode/jobs", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"jobs": transcodeJobs.snapshots()})
+	})
+
+	api.POST("/transcode/jobs", requireSameOriginWrite, func(c *gin.Context) {
+		var req transcodeJobRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+			return
+		}
+		job, err := startLocalMusicTranscodeJob(req)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "queued", "job": job.snapshot()})
+	})
+
+	api.POST("/transcode/jobs/:id/cancel", requireSameOriginWrite, func(c *gin.Context) {
+		job := transcodeJobs.find(c.Param("id"))
+		if job == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "转码任务不存在"})
+			return
+		}
+		job.cancel()
+		c.JSON(http.StatusOK, gin.H{"status": "ok"})
+	})
+
+	api.POST("/transcode/jobs/clear", requireSameOriginWrite, func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"status": "ok", "removed": transcodeJobs.clearFinished()})
+	})
+}
+
+// localMusicTranscodeSettingsProvider is swapped in tests so they do not
+// read the process-wide settings database.
+var localMusicTranscodeSettingsProvider = func() core.WebSettings {
+	return core.GetWebSettings()
+}
+
+func resolveTranscodeProfile(name string, custom *core.TranscodeProfile) (core.TranscodeProfile, error) {
+	if custom != nil {
+		return core.NormalizeTranscodeProfile(*custom)
+	}
+	if strings.TrimSpace(name) == "" {
+		return core.TranscodeProfile{}, errors.New("请选择转码档案")
+	}
+	return core.FindTranscodeProfile(localMusicTranscodeSettingsProvider().TranscodeProfiles, name)
+}
+
+// startLocalMusicTranscodeJob queues a batch conversion of local tracks.
+// Tracks on read-only roots are listed as skipped, since the output goes next
+// to the source file.
+func startLocalMusicTranscodeJob(req transcodeJobRequest) (*transcodeJob, error) {
+	if err := transcodeReady(); err != nil {
+		return nil, err
+	}
+	profile, err := resolveTranscodeProfile(req.Profile, req.Custom)
+	if err != nil {
+		return nil, err
+	}
+	items := make([]transcodeItem, 0, len(req.IDs))
+	seen := make(map[string]bool, len(req.IDs))
+	for _, id := range req.IDs {
+		id = strings.TrimSpace(id)
+		if id == "" || seen[id] {
+			continue
+		}
+		seen[id] = true
+		item := transcodeItem{ID: id, Source: id, Status: "pending"}
+		track, err := localMusicTrackByID(id)
+		switch {
+		case err != nil:
+			item.Status, item.Error = "failed", "本地音乐不存在"
+		case track.ReadOnly:
+			item.Source = track.RelPath
+			item.Status, item.Error = "skipped", errLocalLibraryReadOnly.Error()
+		default:
+			root, _ := localLibraryRootByKey(track.Root)
+			item.Source, item.root, item.srcAbs = track.RelPath, root, track.absPath
+		}
+		items = append(items, item)
+	}
+	if len(items) == 0 {
+		return nil, errors.New("没有要转码的本地音乐")
+	}
+	return transcodeJobs.submit(transcodeJobKindLocal, profile, req.Replace, items), nil
+}
+
+// enqueueDownloadTranscode queues the conversion of a file the web download
+// just saved. mode and profileName fall back to the download settings.
+func enqueueDownloadTranscode(savedPath string, mode string, profileName string) (*transcodeJob, error) {
+	settings := localMusicTranscodeSettingsProvider()
+	if strings.TrimSpace(mode) == "" {
+		mode = settings.DownloadTranscodeMode
+	}
+	if mode == "off" || mode == core.TranscodeModeOff {
+		return nil, nil
+	}
+	if mode != core.TranscodeModeAlso && mode != core.TranscodeModeConvert {
+		return nil, fmt.Errorf("unknown transcode mode %q", mode)
+	}
+	if strings.TrimSpace(profileName) == "" {
+		profileName = settings.DownloadTranscodeProfile
+	}
+	if err := transcodeReady(); err != nil {
+		return nil, err
+	}
+	profile, err := core.FindTranscodeProfile(settings.TranscodeProfiles, profileName)
+	if err != nil {
+		return nil, err
+	}
+	if abs, err := filepath.Abs(savedPath); err == nil {
+		savedPath = abs
+	}
+	root := localLibraryRoots()[0]
+	item := transcodeItem{Source: filepath.Base(savedPath), Status: "pending", root: root, srcAbs: savedPath}
+	if rel, err := filepath.Rel(root.Abs, savedPath); err == nil && isPathInside(root.Abs, savedPath) {
+		item.Source = filepath.ToSlash(rel)
+	}
+	return transcodeJobs.submit(transcodeJobKindDownload, profile, mode == core.TranscodeModeConvert, []transcodeItem{item}), nil
+}
+
+func (q *transcodeJobQueue) submit(kind string, profile core.TranscodeProfile, replace bool, items []transcodeItem) *transcodeJob {
+	job := &transcodeJob{
+		backgroundJob: newBackgroundJob("queued", len(items)),
+		Kind:          kind,
+		Profile:       profile,
+		Replace:       replace,
+		Items:         items,
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.jobs = append(q.jobs, job)
+	q.pending = append(q.pending, job)
+	q.trimLocked()
+	if !q.working {
+		q.working = true
+		go q.work()
+	}
+	return job
+}
+
+func (q *transcodeJobQueue) work() {
+	for {
+		q.mu.Lock()
+		if len(q.pending) == 0 {
+			q.working = false
+			q.mu.Unlock()
+			return
+		}
+		job := q.pending[0]
+		q.pending = q.pending[1:]
+		q.mu.Unlock()
+		job.run()
+	}
+}
+
+// trimLocked drops the oldest finished jobs beyond the history limit.
+func (q *transcodeJobQueue) trimLocked() {
+	for len(q.jobs) > transcodeJobHistory {
+		idx := -1
+		for i, job := range q.jobs {
+			if job.finished() {
+				idx = i
+				break
+			}
+		}
+		if idx < 0 {
+			return
+		}
+		q.jobs = append(q.jobs[:idx], q.jobs[idx+1:]...)
+	}
+}
+
+func (q *transcodeJobQueue) find(id string) *transcodeJob {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, job := range q.jobs {
+		if job.ID == id {
+			return job
+		}
+	}
+	return nil
+}
+
+// snapshots lists the jobs newest first.
+func (q *transcodeJobQueue) snapshots() []*transcodeJob {
+	q.mu.Lock()
+	jobs := append([]*transcodeJob(nil), q.jobs...)
+	q.mu.Unlock()
+	out := make([]*transcodeJob, 0, len(jobs))
+	for i := len(jobs) - 1; i >= 0; i-- {
+		out = append(out, jobs[i].snapshot())
+	}
+	return out
+}
+
+func (q *transcodeJobQueue) clearFinished() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	kept := q.jobs[:0]
+	removed := 0
+	for _, job := range q.jobs {
+		if job.finished() {
+			removed++
+			continue
+		}
+		kept = append(kept, job)
+	}
+	q.jobs = kept
+	return removed
+}
+
+func (job *transcodeJob) snapshot() *transcodeJob {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	return &transcodeJob{
+		backgroundJob: job.snapshotLocked(),
+		Kind:          job.Kind,
+		Profile:       job.Profile,
+		Replace:       job.Replace,
+		Converted:     job.Converted,
+		Skipped:       job.Skipped,
+		Progress:      job.Progress,
+		Current:       job.Current,
+		Items:         append([]transcodeItem(nil), job.Items...),
+	}
+}
+
+func (job *transcodeJob) run() {
+	job.mu.Lock()
+	job.Status = "running"
+	job.mu.Unlock()
+
+	changed := false
+	job.process(1, func(index int) func() {
+		job.mu.Lock()
+		item := job.Items[index]
+		if item.Status != "pending" {
+			// 入队时就已判定跳过或失败的条目。
+			job.mu.Unlock()
+			return func() { job.countLocked(item.Status) }
+		}
+		job.Items[index].Status = "running"
+		job.Current = item.Source
+		job.mu.Unlock()
+
+		status, err := job.convert(&item, func(p float64) {
+			job.mu.Lock()
+			job.Progress = (float64(job.Processed) + p) / float64(job.Total)
+			job.mu.Unlock()
+		})
+		if status == "done" {
+			changed = true
+		}
+		item.Status = status
+		if err != nil {
+			item.Error = err.Error()
+		}
+		return func() {
+			job.Items[index] = item
+			job.countLocked(status)
+		}
+	})
+	if changed {
+		invalidateLocalMusicScanCache()
+	}
+
+	job.mu.Lock()
+	for i := range job.Items {
+		// 取消后没轮到的条目。
+		if job.Items[i].Status == "pending" {
+			job.Items[i].Status = "cancelled"
+		}
+	}
+	job.Current = ""
+	job.Progress = 1
+	job.mu.Unlock()
+	job.finish(nil)
+}
+
+// countLocked tallies one handled item; process has already counted it in
+// Processed.
+func (job *transcodeJob) countLocked(status string) {
+	switch status {
+	case "done":
+		job.Converted++
+	case "skipped":
+		job.Skipped++
+	case "failed":
+		job.Failed++
+	}
+	job.Progress = float64(job.Processed) / float64(job.Total)
+}
+
+// convert transcodes one item and reports its final status.
+func (job *transcodeJob) convert(item *transcodeItem, progress func(float64)) (string, error) {
+	src, root := item.srcAbs, item.root
+	ext := job.Profile.Ext()
+	if strings.EqualFold(strings.TrimPrefix(filepath.Ext(src), "."), ext) {
+		return "skipped", errors.New("已是目标格式")
+	}
+	target := core.TranscodeTargetPath(src, "", job.Profile)
+	if _, err := os.Stat(target); err == nil {
+		target = uniqueLocalMusicPath(filepath.Dir(target), filepath.Base(target))
+	}
+
+	if err := transcodeAudioFile(job.ctx, src, target, job.Profile, progress); err != nil {
+		if job.ctx.Err() != nil {
+			return "cancelled", nil
+		}
+		return "failed", err
+	}
+
+	localMusicTagWriteMu.Lock()
+	defer localMusicTagWriteMu.Unlock()
+	item.Target = localMusicOrganizeRel(root, target)
+	carryLocalMusicSidecars(src, target, job.Replace)
+
+	oldRel := localMusicOrganizeRel(root, src)
+	if job.Replace {
+		if err := os.Remove(src); err != nil {
+			return "failed", err
+		}
+		forgetLocalMusicTrack(root.Abs, oldRel)
+		if id, ok := localMusicIdentityIDAt(root.Key, oldRel); ok {
+			// 原文件被替换：身份跟着新文件走，收藏、播放次数都不丢。
+			moveLocalMusicIdentity(id, root.Key, item.Target)
+		} else {
+			deleteLocalMusicIndexRowAt(root.Key, oldRel)
+		}
+	}
+	if root.Abs != "" && isPathInside(root.Abs, target) {
+		if track, err := buildLocalMusicTrack(root, target); err == nil {
+			item.NewID = track.ID
+			if job.Replace && item.ID != "" {
+				relinkLocalMusicID(item.ID, track)
+			} else {
+				upsertLocalMusicIndexRow(track)
+			}
+		}
+	}
+	return "done", nil
+}
+
+// carryLocalMusicSidecars gives the converted file the lyric and cover
+// sidecars of the source when its base name differs (e.g. "Song (1).mp3").
+// With move the sidecars follow the file, otherwise they are copied.
+func carryLocalMusicSidecars(src string, target string, move bool) {
+	srcBase := strings.TrimSuffix(src, filepath.Ext(src))
+	targetBase := strings.TrimSuffix(target, filepath.Ext(target))
+	if srcBase == targetBase {
+		return
+	}
+	for _, exts := range [][]string{localMusicLyricExts, localMusicCoverExts} {
+		sidecar, ext, ok := localMusicExactSidecarFile(src, exts)
+		if !ok {
+			continue
+		}
+		dest := targetBase + ext
+		if _, err := os.Stat(dest); err == nil {
+			continue
+		}
+		var err error
+		if move {
+			err = os.Rename(sidecar, dest)
+		} else {
+			err = copyLocalMusicSidecar(sidecar, dest)
+		}
+		if err != nil {
+			core.Logger().Warn("carry local music sidecar failed", "path", sidecar, "error", err)
+		}
+	}
+}
+
+func copyLocalMusicSidecar(src string, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	return out.Close()
+}
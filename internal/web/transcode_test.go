@@ -0,0 +1,144 @@
+package web
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/guohuiyuan/go-music-dl/core"
+)
+
+// stubTranscodeForTest replaces ffmpeg with a copy of a tagged mp3 so the
+// job queue can be exercised without the binary.
+func stubTranscodeForTest(t *testing.T, settings core.WebSettings) {
+	t.Helper()
+	originalAudio, originalReady, originalJobs, originalSettings := transcodeAudioFile, transcodeReady, transcodeJobs, localMusicTranscodeSettingsProvider
+	transcodeAudioFile = func(ctx context.Context, src string, dst string, profile core.TranscodeProfile, progress func(float64)) error {
+		writeTaggedMP3ForTest(t, dst, core.AudioTags{Title: "Converted " + filepath.Base(src), Artist: "Tester"})
+		progress(1)
+		return nil
+	}
+	transcodeReady = func() error { return nil }
+	transcodeJobs = &transcodeJobQueue{}
+	localMusicTranscodeSettingsProvider = func() core.WebSettings { return settings }
+	t.Cleanup(func() {
+		transcodeAudioFile, transcodeReady, transcodeJobs, localMusicTranscodeSettingsProvider = originalAudio, originalReady, originalJobs, originalSettings
+	})
+}
+
+func waitTranscodeJobForTest(t *testing.T, id string) *transcodeJob {
+	t.Helper()
+	job := transcodeJobs.find(id)
+	if job == nil {
+		t.Fatalf("job %s not found", id)
+	}
+	waitJobDoneForTest(t, "transcode", job.done)
+	return job.snapshot()
+}
+
+func TestLocalMusicTranscodeReplaceKeepsID(t *testing.T) {
+	initCollectionDBForTest(t)
+	downloadDir := t.TempDir()
+	withLocalMusicDownloadDir(t, downloadDir)
+	stubTranscodeForTest(t, core.WebSettings{})
+
+	writeLocalMusicFileForTest(t, filepath.Join(downloadDir, "song.flac"), "fLaC-not-really")
+	writeLocalMusicFileForTest(t, filepath.Join(downloadDir, "song.lrc"), "[00:01.00]hello")
+	writeTaggedMP3ForTest(t, filepath.Join(downloadDir, "done.mp3"), core.AudioTags{Title: "Done"})
+	oldID := encodeLocalMusicID("song.flac")
+	for _, id := range []string{oldID, encodeLocalMusicID("done.mp3")} {
+		track, err := localMusicTrackByID(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		upsertLocalMusicIndexRow(track)
+	}
+	collection := Collection{Name: "Fav", Kind: collectionKindManual, ContentType: collectionContentPlaylist, Source: "local"}
+	db.Create(&collection)
+	db.Create(&SavedSong{CollectionID: collection.ID, SongID: oldID, Source: localMusicSource, Name: "song"})
+
+	var resp struct {
+		Job *transcodeJob `json:"job"`
+	}
+	payload := gin.H{"ids": []string{oldID, encodeLocalMusicID("done.mp3"), "missing"}, "profile": "mp3-320", "replace": true}
+	if code := postLocalMusicTagsJSON(t, "/transcode/jobs", payload, &resp); code != http.StatusOK || resp.Job == nil {
+		t.Fatalf("start job status = %d, resp = %+v", code, resp)
+	}
+	job := waitTranscodeJobForTest(t, resp.Job.ID)
+	if job.Status != "done" || job.Converted != 1 || job.Skipped != 1 || job.Failed != 1 || job.Progress != 1 {
+		t.Fatalf("job = %+v", job)
+	}
+	if item := job.Items[0]; item.Target != "song.mp3" || item.NewID != oldID {
+		t.Fatalf("converted item = %+v, want id %s kept", item, oldID)
+	}
+	if _, err := os.Stat(filepath.Join(downloadDir, "song.flac")); !os.IsNotExist(err) {
+		t.Fatalf("source should be replaced, stat err = %v", err)
+	}
+	var row LocalMusicIndex
+	if err := db.First(&row, "id = ?", oldID).Error; err != nil || row.RelPath != "song.mp3" || !row.HasLyric {
+		t.Fatalf("index row = %+v, %v", row, err)
+	}
+	var saved SavedSong
+	if err := db.First(&saved, "collection_id = ?", collection.ID).Error; err != nil || saved.SongID != oldID {
+		t.Fatalf("saved song = %+v, %v", saved, err)
+	}
+	if track, err := localMusicTrackByID(oldID); err != nil || track.RelPath != "song.mp3" {
+		t.Fatalf("track by old id = %+v, %v", track, err)
+	}
+}
+
+func TestDownloadTranscodeAlsoKeepsOriginal(t *testing.T) {
+	initCollectionDBForTest(t)
+	downloadDir := t.TempDir()
+	withLocalMusicDownloadDir(t, downloadDir)
+	stubTranscodeForTest(t, core.WebSettings{DownloadTranscodeMode: core.TranscodeModeAlso, DownloadTranscodeProfile: "aac-256"})
+
+	src := filepath.Join(downloadDir, "Artist", "download.flac")
+	writeLocalMusicFileForTest(t, src, "fLaC-not-really")
+	writeLocalMusicFileForTest(t, filepath.Join(downloadDir, "Artist", "download.jpg"), "jpg")
+
+	if job, err := enqueueDownloadTranscode(src, "off", ""); err != nil || job != nil {
+		t.Fatalf("off mode = %+v, %v", job, err)
+	}
+	if _, err := enqueueDownloadTranscode(src, "", "no-such-profile"); err == nil {
+		t.Fatal("unknown profile should fail")
+	}
+	job, err := enqueueDownloadTranscode(src, "", "")
+	if err != nil || job == nil {
+		t.Fatalf("enqueue = %+v, %v", job, err)
+	}
+	done := waitTranscodeJobForTest(t, job.ID)
+	if done.Kind != transcodeJobKindDownload || done.Replace || done.Converted != 1 || done.Profile.Name != "aac-256" {
+		t.Fatalf("job = %+v", done)
+	}
+	item := done.Items[0]
+	if item.Source != "Artist/download.flac" || item.Target != "Artist/download.m4a" || item.NewID == "" {
+		t.Fatalf("item = %+v", item)
+	}
+	for _, name := range []string{"download.flac", "download.m4a", "download.jpg"} {
+		if _, err := os.Stat(filepath.Join(downloadDir, "Artist", name)); err != nil {
+			t.Fatalf("%s missing: %v", name, err)
+		}
+	}
+	var row LocalMusicIndex
+	if err := db.First(&row, "id = ?", item.NewID).Error; err != nil || row.RelPath != "Artist/download.m4a" {
+		t.Fatalf("index row = %+v, %v", row, err)
+	}
+
+	var listed struct {
+		Jobs []*transcodeJob `json:"jobs"`
+	}
+	rec := httptest.NewRecorder()
+	newLocalMusicTestRouter().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, RoutePrefix+"/transcode/jobs", nil))
+	if err := json.Unmarshal(rec.Body.Bytes(), &listed); err != nil || rec.Code != http.StatusOK || len(listed.Jobs) != 1 {
+		t.Fatalf("jobs = %d %s", rec.Code, rec.Body.String())
+	}
+	if removed := transcodeJobs.clearFinished(); removed != 1 || len(transcodeJobs.snapshots()) != 0 {
+		t.Fatalf("clearFinished removed %d", removed)
+	}
+}
@@ -421,7 +421,7 @@ func RegisterVideogenRoutes(api *gin.RouterGroup, videoDir string) {
 				outputText = strings.TrimSpace(output.String())
 			}
 			if outputText != "" {
-				fmt.Println("FFmpeg Error:", outputText)
+				requestLogger(c).Error("videogen ffmpeg render failed", "error", err, "output", outputText)
 			}
 			os.Remove(outPath)
 			if outputText != "" {
@@ -0,0 +1,190 @@
+package web
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/guohuiyuan/go-music-dl/core"
+)
+
+// webhookView is what the settings panel sees. The token is write-only: it is
+// never sent back, only whether one is configured.
+type webhookView struct {
+	core.WebhookTarget
+	Token    string `json:"token,omitempty"`
+	HasToken bool   `json:"has_token"`
+}
+
+func newWebhookView(target core.WebhookTarget) webhookView {
+	return webhookView{WebhookTarget: target, HasToken: target.Token != ""}
+}
+
+type webhookRequest struct {
+	Name     string   `json:"name"`
+	Kind     string   `json:"kind"`
+	URL      string   `json:"url"`
+	Token    string   `json:"token"`
+	Events   []string `json:"events"`
+	Template string   `json:"template"`
+	Enabled  *bool    `json:"enabled"`
+	// ClearToken removes the stored token; an empty Token alone keeps it.
+	ClearToken bool `json:"clear_token"`
+}
+
+func (req webhookRequest) target(existing *core.WebhookTarget) core.WebhookTarget {
+	target := core.WebhookTarget{
+		Name:     req.Name,
+		Kind:     req.Kind,
+		URL:      req.URL,
+		Token:    req.Token,
+		Events:   req.Events,
+		Template: req.Template,
+		Enabled:  true,
+	}
+	if req.Enabled != nil {
+		target.Enabled = *req.Enabled
+	}
+	if existing != nil {
+		target.ID = existing.ID
+		if strings.TrimSpace(req.Token) == "" && !req.ClearToken {
+			target.Token = existing.Token
+		}
+	}
+	return target
+}
+
+func webhookErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, core.ErrWebhookNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, core.ErrInvalidWebhook):
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+func webhookIDParam(c *gin.Context) (uint, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil || id == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid webhook id"})
+		return 0, false
+	}
+	return uint(id), true
+}
+
+// RegisterWebhookRoutes registers webhook management on the protected config
+// API and the batch summary endpoint the download UI reports to.
+func RegisterWebhookRoutes(api, configAPI *gin.RouterGroup) {
+	configAPI.GET("/webhooks", func(c *gin.Context) {
+		targets, err := core.ListWebhookTargets()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		views := make([]webhookView, 0, len(targets))
+		for _, target := range targets {
+			views = append(views, newWebhookView(target))
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"webhooks": views,
+			"events":   core.WebhookEvents,
+			"kinds":    []string{core.WebhookKindGeneric, core.WebhookKindNtfy, core.WebhookKindGotify, core.WebhookKindBark},
+		})
+	})
+
+	configAPI.POST("/webhooks", func(c *gin.Context) {
+		var req webhookRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid webhook payload"})
+			return
+		}
+		target, err := core.SaveWebhookTarget(req.target(nil))
+		if err != nil {
+			c.JSON(webhookErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, newWebhookView(*target))
+	})
+
+	configAPI.PUT("/webhooks/:id", func(c *gin.Context) {
+		id, ok := webhookIDParam(c)
+		if !ok {
+			return
+		}
+		var req webhookRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid webhook payload"})
+			return
+		}
+		existing, err := core.GetWebhookTarget(id)
+		if err != nil {
+			c.JSON(webhookErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		target, err := core.SaveWebhookTarget(req.target(existing))
+		if err != nil {
+			c.JSON(webhookErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, newWebhookView(*target))
+	})
+
+	configAPI.DELETE("/webhooks/:id", func(c *gin.Context) {
+		id, ok := webhookIDParam(c)
+		if !ok {
+			return
+		}
+		if err := core.DeleteWebhookTarget(id); err != nil {
+			c.JSON(webhookErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "ok"})
+	})
+
+	configAPI.POST("/webhooks/:id/test", func(c *gin.Context) {
+		id, ok := webhookIDParam(c)
+		if !ok {
+			return
+		}
+		delivery, err := core.SendWebhookTest(c.Request.Context(), id)
+		if err != nil {
+			c.JSON(webhookErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, delivery)
+	})
+
+	configAPI.GET("/webhooks/deliveries", func(c *gin.Context) {
+		limit, _ := strconv.Atoi(c.Query("limit"))
+		deliveries, err := core.GetWebhookDeliveries(limit)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"deliveries": deliveries})
+	})
+
+	// 批量下载由前端逐首请求 /download，结束后在这里汇报一次结果。
+	api.POST("/downloads/batch_finished", requireSameOriginWrite, func(c *gin.Context) {
+		var summary core.BatchDownloadSummary
+		if err := c.ShouldBindJSON(&summary); err != nil || summary.Total <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid batch summary"})
+			return
+		}
+		if len(summary.Failures) > 20 {
+			summary.Failures = summary.Failures[:20]
+		}
+		core.NotifyBatchDownloadFinished(c.Request.Context(), summary)
+		c.JSON(http.StatusOK, gin.H{"status": "ok"})
+	})
+}
+
+func songLabel(name, artist string) string {
+	if strings.TrimSpace(artist) == "" {
+		return name
+	}
+	return name + " - " + artist
+}
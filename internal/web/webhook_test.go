@@ -0,0 +1,67 @@
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/guohuiyuan/go-music-dl/core"
+)
+
+func TestWebhookViewHidesToken(t *testing.T) {
+	view := newWebhookView(core.WebhookTarget{ID: 1, URL: "https://example.com", Token: "secret"})
+	data, err := json.Marshal(view)
+	if err != nil {
+		t.Fatalf("marshal view: %v", err)
+	}
+	if strings.Contains(string(data), "secret") || !strings.Contains(string(data), `"has_token":true`) {
+		t.Fatalf("unexpected view JSON %s", data)
+	}
+}
+
+func TestWebhookRequestKeepsTokenUnlessCleared(t *testing.T) {
+	existing := &core.WebhookTarget{ID: 3, Token: "stored"}
+	disabled := false
+
+	target := webhookRequest{URL: "https://example.com", Enabled: &disabled}.target(existing)
+	if target.ID != 3 || target.Token != "stored" || target.Enabled {
+		t.Fatalf("update without token = %+v", target)
+	}
+	if target := (webhookRequest{Token: "new"}).target(existing); target.Token != "new" {
+		t.Fatalf("token not replaced: %+v", target)
+	}
+	if target := (webhookRequest{ClearToken: true}).target(existing); target.Token != "" {
+		t.Fatalf("token not cleared: %+v", target)
+	}
+	if target := (webhookRequest{}).target(nil); !target.Enabled {
+		t.Fatal("new webhooks should be enabled by default")
+	}
+}
+
+func TestWebhookRoutesRejectBadInput(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	group := r.Group(RoutePrefix)
+	RegisterWebhookRoutes(group, group)
+
+	for _, tc := range []struct {
+		method, path, body string
+	}{
+		{http.MethodDelete, "/webhooks/abc", ""},
+		{http.MethodPost, "/webhooks/0/test", ""},
+		{http.MethodPost, "/downloads/batch_finished", `{"total":0}`},
+		{http.MethodPost, "/downloads/batch_finished", `not json`},
+	} {
+		req := httptest.NewRequest(tc.method, RoutePrefix+tc.path, strings.NewReader(tc.body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Requested-With", "XMLHttpRequest")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("%s %s = %d, want 400 (%s)", tc.method, tc.path, w.Code, w.Body.String())
+		}
+	}
+}
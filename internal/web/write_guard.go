@@ -12,6 +12,14 @@ func wantsSaveLocal(c *gin.Context) bool {
 	return c != nil && strings.TrimSpace(c.Query("save_local")) == "1"
 }
 
+// requireSameOriginWrite 挂在会改文件或状态的 POST 路由上：CORS 放开了 *，
+// 不拦的话任意网页都能替用户发这些请求。
+func requireSameOriginWrite(c *gin.Context) {
+	if !allowSameOriginWrite(c) {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+	}
+}
+
 func allowSameOriginWrite(c *gin.Context) bool {
 	if c == nil {
 		return false